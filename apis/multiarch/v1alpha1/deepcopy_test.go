@@ -0,0 +1,83 @@
+package v1alpha1
+
+import (
+	"reflect"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fuzzedObjects is the number of random objects deep copied for each kind
+const fuzzedObjects = 200
+
+// newFuzzer returns a fuzzer filling the objects with random values, the same for the same seed
+func newFuzzer(seed int64) *fuzz.Fuzzer {
+	return fuzz.NewWithSeed(seed).NilChance(0.2).NumElements(1, 3)
+}
+
+// mutate changes in place every value reachable from the value, following the pointers, the slices and the maps, so
+// that the values the deep copy shares with the object it was copied from are changed in that object too
+func mutate(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			mutate(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				mutate(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			mutate(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			mutate(value)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.String:
+		v.SetString(v.String() + "-mutated")
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	}
+}
+
+// The deep copies are generated by controller-gen, see the generate target of the Makefile: a field added without
+// generating them again is shallow copied, and the reconcilers mutating the copies of the objects read from the cache
+// of the informers would corrupt it.
+var _ = Describe("The deep copies of the objects", func() {
+	DescribeTable("should not share any value with the objects they are copied from",
+		func(newObject func() runtime.Object) {
+			for i := int64(0); i < fuzzedObjects; i++ {
+				seed := GinkgoRandomSeed() + i
+				// the twin is filled with the same values as the object, without copying it
+				object, twin := newObject(), newObject()
+				newFuzzer(seed).Fuzz(object)
+				newFuzzer(seed).Fuzz(twin)
+				Expect(object).To(Equal(twin))
+
+				copied := object.DeepCopyObject()
+				Expect(copied).To(Equal(object), "the copy of the object of the seed %d differs", seed)
+				mutate(reflect.ValueOf(copied))
+				Expect(copied).NotTo(Equal(twin))
+				Expect(object).To(Equal(twin), "the copy of the object of the seed %d shares some values", seed)
+			}
+		},
+		Entry("PodPlacementConfig", func() runtime.Object { return &PodPlacementConfig{} }),
+		Entry("PodPlacementConfigList", func() runtime.Object { return &PodPlacementConfigList{} }),
+		Entry("PodPlacementPolicy", func() runtime.Object { return &PodPlacementPolicy{} }),
+		Entry("PodPlacementPolicyList", func() runtime.Object { return &PodPlacementPolicyList{} }),
+	)
+})
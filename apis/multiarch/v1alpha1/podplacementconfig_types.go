@@ -18,6 +18,8 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"multiarch-operator/pkg/reasons"
 )
 
 // +kubebuilder:validation:Enum=Normal;Debug;Trace;TraceAll
@@ -30,7 +32,41 @@ const (
 	LogVerbosityLevelTraceAll LogVerbosityLevel = "TraceAll"
 )
 
+const (
+	// DegradedConditionType reports that the pod placement is not fully operational, e.g., because the cluster does not
+	// support the pod scheduling gates and the pods are not gated
+	DegradedConditionType = "Degraded"
+	// SchedulingGatesUnsupportedReason is the reason of the Degraded condition when the cluster does not support the
+	// pod scheduling gates
+	SchedulingGatesUnsupportedReason = reasons.SchedulingGatesUnsupported
+	// AsExpectedReason is the reason of the Degraded condition when the pod placement is fully operational
+	AsExpectedReason = reasons.AsExpected
+	// NodeSyncerAvailableConditionType reports whether every node runs an up-to-date and ready pod of the node syncer
+	// DaemonSet, i.e., whether the system config files of all the nodes are in sync. It is only set when the operator
+	// manages the node syncer.
+	NodeSyncerAvailableConditionType = "NodeSyncerAvailable"
+	// NodeSyncerRolloutInProgressReason is the reason of the NodeSyncerAvailable condition when some pods of the node
+	// syncer are not updated or not ready yet
+	NodeSyncerRolloutInProgressReason = reasons.RolloutInProgress
+	// PausedConditionType reports whether the pod placement is paused by the paused field of a PodPlacementConfig
+	PausedConditionType = "Paused"
+	// PlacementPausedReason is the reason of the Paused condition when the pod placement is paused
+	PlacementPausedReason = reasons.PlacementPaused
+	// EffectivelyDisabledConditionType reports whether the cluster only has nodes of a single architecture, so that the
+	// pod placement has no effect: the pods can only run on that architecture
+	EffectivelyDisabledConditionType = "EffectivelyDisabled"
+	// SingleArchitectureClusterReason is the reason of the EffectivelyDisabled condition when the cluster only has
+	// nodes of a single architecture
+	SingleArchitectureClusterReason = reasons.SingleArchitectureCluster
+	// ForceSyncAnnotation requests a resync of the system config files of the operator from the current state of the
+	// cluster, e.g., multiarch.openshift.io/force-sync: "2023-05-04T10:00:00Z". Every new value requests a resync; the
+	// value of the last completed one is reported by the forcedSync status field.
+	ForceSyncAnnotation = "multiarch.openshift.io/force-sync"
+)
+
 // PodPlacementConfigSpec defines the desired state of PodPlacementConfig
+// +kubebuilder:validation:XValidation:rule="!has(self.optOut) || !self.optOut || (!has(self.placementMode) && !has(self.allowedArchitectures) && !has(self.failurePolicy) && !has(self.defaultArchitectures))",message="placementMode, allowedArchitectures, failurePolicy and defaultArchitectures must not be set when optOut is true"
+// +kubebuilder:validation:XValidation:rule="!has(self.keepDecisionAnnotations) || !self.keepDecisionAnnotations || (has(self.cleanupAnnotationsAfterScheduling) && self.cleanupAnnotationsAfterScheduling)",message="keepDecisionAnnotations requires cleanupAnnotationsAfterScheduling to be true"
 type PodPlacementConfigSpec struct {
 	// LogVerbosity is the log level for the pod placement controller
 	// Valid values are: "Normal", "Debug", "Trace", "TraceAll".
@@ -84,12 +120,226 @@ type PodPlacementConfigSpec struct {
 	// Default to the empty LabelSelector, which matches everything.
 	// +optional
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// TrustedMultiArchPrefixes is a list of repository prefixes of images known to be available for all the
+	// architectures of the cluster, e.g., "registry.access.redhat.com/ubi9" or "quay.io/openshift-release-dev".
+	// The pods whose images all match one of the prefixes are not gated and no node affinity is set for them.
+	// A prefix matches the repositories equal to it or nested in it: "quay.io/org" matches "quay.io/org/app"
+	// but not "quay.io/organization/app". A prefix with no path, like "quay.io", matches all the repositories of
+	// the registry. The prefixes without a registry are normalized, e.g., "nginx" matches "docker.io/library/nginx".
+	// +optional
+	TrustedMultiArchPrefixes []string `json:"trustedMultiArchPrefixes,omitempty"`
+
+	// Paused stops all the mutations of the pods, e.g., during an incident, while the watchers and the metrics keep
+	// running. The pod placement is paused while any PodPlacementConfig is paused: the webhook does not gate the new
+	// pods and the reconciler removes the scheduling gate of the gated ones, without setting their node affinity.
+	// Unpausing only places the pods created afterwards.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// PinToInspectedDigest rewrites the image references of the containers of the pods placed by the reconciler to the
+	// digests of the inspected images, in the same update setting their node affinity, so that the images the pods
+	// run are the ones whose architectures were inspected even if their tags move in the meantime. The images already
+	// referenced by digest, the ones resolved through their image stream and the pods declaring their architectures
+	// are not rewritten. The images are pinned while any PodPlacementConfig sets it.
+	// Defaults to false.
+	// +optional
+	PinToInspectedDigest bool `json:"pinToInspectedDigest,omitempty"`
+
+	// ConsiderAutoscaledCapacity considers the architectures of the nodes the scalable MachineSets of the OpenShift
+	// Machine API can provision as fitting the pods, even when no node of them exists yet, e.g., an arm64 MachineSet
+	// scaled to zero replicas by the cluster autoscaler. The architecture of a MachineSet is read from the
+	// kubernetes.io/arch label of its template or of itself, from the capacity.cluster-autoscaler.kubernetes.io/labels
+	// annotation, or mapped from the instance type of its providerSpec. It has no effect if the Machine API is not
+	// installed. Defaults to false.
+	// +optional
+	ConsiderAutoscaledCapacity bool `json:"considerAutoscaledCapacity,omitempty"`
+
+	// CleanupAnnotationsAfterScheduling removes the annotations the operator set on the pods once they are running,
+	// e.g., multiarch.openshift.io/placement-decision and multiarch.openshift.io/mutated-by. The annotations set by
+	// the users, e.g., multiarch.openshift.io/architectures, are kept. The annotations are removed while any
+	// PodPlacementConfig sets it. Defaults to false.
+	// +optional
+	CleanupAnnotationsAfterScheduling bool `json:"cleanupAnnotationsAfterScheduling,omitempty"`
+
+	// KeepDecisionAnnotations keeps the annotations reporting the inputs of the placement of the pods, i.e.,
+	// multiarch.openshift.io/supported-architectures, multiarch.openshift.io/capacity-excluded-architectures and
+	// multiarch.openshift.io/inspected-digests, when cleanupAnnotationsAfterScheduling is set. Defaults to false.
+	// +optional
+	KeepDecisionAnnotations bool `json:"keepDecisionAnnotations,omitempty"`
+
+	// AdditionalNodeSelectorTerms are the node selector requirements added, by architecture, to the node affinity of
+	// the pods placed on it, e.g., {"arm64": {"matchLabels": {"node-role.kubernetes.io/arm-workers": ""}}} to keep the
+	// pods placed on arm64 off the arm64 nodes without that label. The requirements of an architecture are ANDed with
+	// the kubernetes.io/arch requirement of the pods only when it is one of the architectures they are placed on: each
+	// architecture with requirements gets its own node selector term. The requirements on the keys the pods already
+	// select, through their nodeSelector or their node affinity, are not added, and a warning event reports them. The
+	// requirements of all the PodPlacementConfig objects are added.
+	// +optional
+	AdditionalNodeSelectorTerms map[string]metav1.LabelSelector `json:"additionalNodeSelectorTerms,omitempty"`
+
+	// ExtendedResourceArchitectures restricts the architectures of the pods requesting extended resources, e.g.,
+	// nvidia.com/gpu, to the architectures of the nodes providing them: the architectures supported by the images of
+	// a pod are intersected with the ones of each mapped extended resource it requests. The architectures of a
+	// resource are the ones listed for it or, if none is listed, the ones of the schedulable nodes advertising it in
+	// their allocatable resources, refreshed periodically. The pods are placed on the architectures of their images
+	// when the intersection is empty or the architectures of a resource are not discovered yet. The resources of all
+	// the PodPlacementConfig objects are mapped, the first one by name listing a resource winning.
+	// +optional
+	ExtendedResourceArchitectures []ExtendedResourceArchitectures `json:"extendedResourceArchitectures,omitempty"`
+
+	// SkipSingleArchCluster stops the gating of the pods while the cluster only has schedulable nodes of a single
+	// architecture, and of the architectures of the scalable MachineSets when considerAutoscaledCapacity is set: the
+	// pods can only run on it, and gating them only delays their scheduling. The webhook admits them with the
+	// multiarch.openshift.io/placement-reason annotation set to SingleArchitectureCluster. The gating resumes as soon
+	// as a node of another architecture joins the cluster, and the pods admitted without being gated that are not
+	// scheduled yet are reported through an event, to be recreated. The gating is skipped while any
+	// PodPlacementConfig sets it. Defaults to false.
+	// +optional
+	SkipSingleArchCluster bool `json:"skipSingleArchCluster,omitempty"`
+
+	// MutateWorkloadTemplates sets the node affinity of the pod templates of the Deployments, StatefulSets and Jobs at
+	// their admission, so that it is visible in the workloads instead of being set on each of their pods. Only the
+	// templates whose images all have their architectures cached are mutated: the pods of the other ones are gated and
+	// placed one by one. The node affinity of a template is computed again every time it is updated, and removed when it
+	// no longer applies, e.g., when its images are no longer cached. The workloads themselves are never gated, and the
+	// pod templates of the Jobs are only mutated at their creation. The templates are mutated while any
+	// PodPlacementConfig sets it. Defaults to false.
+	// +optional
+	MutateWorkloadTemplates bool `json:"mutateWorkloadTemplates,omitempty"`
+
+	// Admission are the settings of the admission of the pods by the webhook
+	// +optional
+	Admission *AdmissionSettings `json:"admission,omitempty"`
+
+	// Tuning overrides the tunables of the operator set by its command line flags, e.g., the TTLs of its caches, and
+	// is applied by every replica as soon as it changes, without restarting them. The fields of all the
+	// PodPlacementConfig objects are applied, the first one by name setting a field winning. The unset fields keep the
+	// values of the flags.
+	// +optional
+	Tuning *TuningSettings `json:"tuning,omitempty"`
+
+	// PlacementPolicy are the cluster-wide placement settings. The PodPlacementPolicy objects override them field by
+	// field for the pods of their namespace.
+	PlacementPolicy `json:",inline"`
+}
+
+// AdmissionSettings are the settings of the admission of the pods by the webhook
+type AdmissionSettings struct {
+	// DenyImpossiblePods denies the admission of the pods whose images are known to support none of the architectures
+	// of the schedulable nodes of the cluster, e.g., amd64-only images on a cluster without amd64 nodes, instead of
+	// admitting them to stay pending forever. The pods are only denied when the architectures of all their images are
+	// cached; when only some of them are, the pods are admitted and gated with a warning. The images of the pods
+	// declaring their architectures are not checked. The pods are denied while any PodPlacementConfig sets it.
+	// Defaults to false.
+	// +optional
+	DenyImpossiblePods bool `json:"denyImpossiblePods,omitempty"`
+}
+
+// TuningSettings are the tunables of the operator. The durations are strings parsed by time.ParseDuration, e.g., 90s.
+type TuningSettings struct {
+	// InspectionTimeout is the time an inspection of an image can take, waiting for a slot of the parallel
+	// inspections included. It overrides --image-inspection-timeout. It must be between 1s and 1h.
+	// +optional
+	InspectionTimeout *metav1.Duration `json:"inspectionTimeout,omitempty"`
+	// MaxParallelInspections is the number of the inspections of images running at the same time. It overrides
+	// --max-parallel-inspections. It must be between 1 and 1000.
+	// +optional
+	MaxParallelInspections *int32 `json:"maxParallelInspections,omitempty"`
+	// ArchitecturesCacheTTL is the time the architectures of the inspected images are cached. It overrides
+	// --image-architectures-cache-ttl. Zero caches them forever, otherwise it must be between 1m and 168h.
+	// +optional
+	ArchitecturesCacheTTL *metav1.Duration `json:"architecturesCacheTTL,omitempty"`
+	// NotFoundCacheTTL is the time the inspections of the images missing from their registry are cached. It overrides
+	// --image-not-found-cache-ttl. Zero disables their caching, otherwise it must be between 1s and 24h.
+	// +optional
+	NotFoundCacheTTL *metav1.Duration `json:"notFoundCacheTTL,omitempty"`
+	// UnauthorizedCacheTTL is the time the inspections rejected by the registry for the credentials used are cached.
+	// It overrides --image-unauthorized-cache-ttl. Zero disables their caching, otherwise it must be between 1s and
+	// 24h.
+	// +optional
+	UnauthorizedCacheTTL *metav1.Duration `json:"unauthorizedCacheTTL,omitempty"`
+	// DecisionCacheTTL is the time the placement decisions are cached for the pods of the same template. It overrides
+	// --decision-cache-ttl, but does not enable the cache when the flag disables it. It must be between 1s and 24h.
+	// +optional
+	DecisionCacheTTL *metav1.Duration `json:"decisionCacheTTL,omitempty"`
+	// MaxRetryableBackoff caps the backoff of the gated pods whose placement failed for a reason that can resolve
+	// itself soon, e.g., a registry answering 503. It defaults to 1000s and must be between 1s and 1h.
+	// +optional
+	MaxRetryableBackoff *metav1.Duration `json:"maxRetryableBackoff,omitempty"`
+	// MaxPermanentFailureBackoff caps the backoff of the gated pods whose placement failed permanently, e.g., because
+	// one of their images is missing from its registry. It defaults to 30m and must be between 1m and 24h.
+	// +optional
+	MaxPermanentFailureBackoff *metav1.Duration `json:"maxPermanentFailureBackoff,omitempty"`
+	// GatedPodsSweepInterval is the interval of the sweeps requeueing the pods gated for a long time. It overrides
+	// --gated-pods-sweep-interval, but does not enable the sweeps when the flag disables them. It must be between 10s
+	// and 24h.
+	// +optional
+	GatedPodsSweepInterval *metav1.Duration `json:"gatedPodsSweepInterval,omitempty"`
+}
+
+// ExtendedResourceArchitectures are the architectures of the nodes providing an extended resource
+type ExtendedResourceArchitectures struct {
+	// Name is the name of the extended resource, e.g., nvidia.com/gpu
+	Name string `json:"name"`
+	// Architectures are the architectures of the nodes providing the extended resource. If empty, they are discovered
+	// from the allocatable resources of the schedulable nodes.
+	// +optional
+	Architectures []string `json:"architectures,omitempty"`
 }
 
 // PodPlacementConfigStatus defines the observed state of PodPlacementConfig
 type PodPlacementConfigStatus struct {
 	// Conditions represents the latest available observations of a PodPlacementConfig's current state.
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ClusterArchitectures is the number of schedulable nodes of each architecture of the cluster, sorted by
+	// architecture. It is refreshed periodically, when the operator is configured to report it.
+	// +optional
+	ClusterArchitectures []ArchitectureNodes `json:"clusterArchitectures,omitempty"`
+
+	// PendingGatedPods is the number of gated pods waiting for their placement, grouped by the architectures they
+	// require. It is refreshed along with ClusterArchitectures.
+	// +optional
+	PendingGatedPods []GatedPodsRequirement `json:"pendingGatedPods,omitempty"`
+
+	// ForcedSync is the last resync of the system config files requested through the
+	// multiarch.openshift.io/force-sync annotation.
+	// +optional
+	ForcedSync *ForcedSync `json:"forcedSync,omitempty"`
+
+	// ObservedGeneration is the generation of the PodPlacementConfig the status was last written for. The operators
+	// older than the one that wrote the fields they do not know leave the status of the observed generations alone.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// ForcedSync is a completed resync of the system config files requested through the multiarch.openshift.io/force-sync
+// annotation
+type ForcedSync struct {
+	// Value is the value of the annotation that requested the resync
+	Value string `json:"value"`
+	// CompletionTime is the time the files were written
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// ArchitectureNodes is the number of schedulable nodes of an architecture
+type ArchitectureNodes struct {
+	// Architecture is the architecture of the nodes, read from their kubernetes.io/arch label
+	Architecture string `json:"architecture"`
+	// Nodes is the number of schedulable nodes of the architecture
+	Nodes int32 `json:"nodes"`
+}
+
+// GatedPodsRequirement is the number of gated pods requiring the same architectures
+type GatedPodsRequirement struct {
+	// Architectures are the architectures required by the pods through the multiarch.openshift.io/architectures
+	// annotation or the kubernetes.io/arch node selector, sorted. It is empty for the pods whose architectures are
+	// decided by the inspection of their images.
+	// +optional
+	Architectures []string `json:"architectures,omitempty"`
+	// Pods is the number of gated pods requiring the architectures
+	Pods int32 `json:"pods"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,138 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"multiarch-operator/pkg/reasons"
+)
+
+// +kubebuilder:validation:Enum=Required;Preferred
+type PlacementMode string
+
+const (
+	// PlacementModeRequired restricts the pods to the nodes of the architectures supported by their images
+	PlacementModeRequired PlacementMode = "Required"
+	// PlacementModePreferred only makes the scheduler prefer the nodes of the architectures supported by the images
+	// of the pods, e.g., on clusters running the other architectures' binaries through emulation
+	PlacementModePreferred PlacementMode = "Preferred"
+)
+
+// +kubebuilder:validation:Enum=Ignore;Fail
+type PlacementFailurePolicy string
+
+const (
+	// PlacementFailurePolicyIgnore removes the scheduling gate without setting the node affinity of the pods whose
+	// images cannot be inspected
+	PlacementFailurePolicyIgnore PlacementFailurePolicy = "Ignore"
+	// PlacementFailurePolicyFail keeps the pods whose images cannot be inspected gated, and retries their placement
+	PlacementFailurePolicyFail PlacementFailurePolicy = "Fail"
+)
+
+const (
+	// ValidConditionType reports whether the PodPlacementPolicy is applied to the pods of its namespace
+	ValidConditionType = "Valid"
+	// InvalidSpecReason is the reason of the Valid condition when the spec of the PodPlacementPolicy is invalid
+	InvalidSpecReason = reasons.InvalidSpec
+	// MultiplePoliciesReason is the reason of the Valid condition of the PodPlacementPolicy objects ignored because
+	// an older one exists in the same namespace
+	MultiplePoliciesReason = reasons.MultiplePolicies
+)
+
+// PlacementPolicy are the settings of the placement of the pods shared by the PodPlacementConfig and the
+// PodPlacementPolicy objects. The unset fields of a PodPlacementPolicy inherit the value of the PodPlacementConfig.
+type PlacementPolicy struct {
+	// PlacementMode is the kind of node affinity set for the architectures supported by the images of the pods.
+	// Valid values are: "Required", "Preferred".
+	// Defaults to "Required".
+	// +optional
+	PlacementMode PlacementMode `json:"placementMode,omitempty"`
+
+	// AllowedArchitectures restricts the architectures the pods can be placed on, e.g., ["amd64"].
+	// The pods whose images support none of them are kept gated.
+	// Defaults to all the architectures.
+	// +optional
+	AllowedArchitectures []string `json:"allowedArchitectures,omitempty"`
+
+	// FailurePolicy decides what happens to the pods whose images cannot be inspected.
+	// Valid values are: "Ignore", "Fail".
+	// Defaults to "Ignore".
+	// +optional
+	FailurePolicy PlacementFailurePolicy `json:"failurePolicy,omitempty"`
+
+	// DefaultArchitectures are the architectures the pods whose images cannot be inspected are placed on, e.g.,
+	// ["amd64"] for the images of a registry serving their manifests to the nodes only. When set, they take
+	// precedence over the FailurePolicy. They never replace the architectures of the images that can be inspected.
+	// Defaults to none: the FailurePolicy applies.
+	// +optional
+	DefaultArchitectures []string `json:"defaultArchitectures,omitempty"`
+
+	// ExcludedContainerNames are the names of the containers, init containers included, whose images are not
+	// inspected and do not restrict the architectures the pods are placed on, e.g., ["istio-proxy", "linkerd-*"] for
+	// the injected sidecars whose images are known to be multi-arch. The names are glob patterns, with the syntax of
+	// the path.Match Go function. The pods whose containers are all excluded, and that have no image volume, are placed
+	// without node affinity. The excluded containers are recorded by the
+	// multiarch.openshift.io/excluded-containers annotation of the pods.
+	// Defaults to none.
+	// +optional
+	ExcludedContainerNames []string `json:"excludedContainerNames,omitempty"`
+
+	// OptOut disables the placement of the pods: they are not gated and no node affinity is set for them.
+	// It cannot be set to true together with the other fields.
+	// Defaults to false.
+	// +optional
+	OptOut *bool `json:"optOut,omitempty"`
+}
+
+// PodPlacementPolicySpec defines the desired state of PodPlacementPolicy
+// +kubebuilder:validation:XValidation:rule="!has(self.optOut) || !self.optOut || (!has(self.placementMode) && !has(self.allowedArchitectures) && !has(self.failurePolicy) && !has(self.defaultArchitectures))",message="placementMode, allowedArchitectures, failurePolicy and defaultArchitectures must not be set when optOut is true"
+type PodPlacementPolicySpec struct {
+	PlacementPolicy `json:",inline"`
+}
+
+// PodPlacementPolicyStatus defines the observed state of PodPlacementPolicy
+type PodPlacementPolicyStatus struct {
+	// Conditions represents the latest available observations of a PodPlacementPolicy's current state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=podplacementpolicies,scope=Namespaced
+// PodPlacementPolicy is the Schema for the podplacementpolicies API. It overrides the placement settings of the
+// PodPlacementConfig for the pods of its namespace. Only one PodPlacementPolicy per namespace is allowed.
+type PodPlacementPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodPlacementPolicySpec   `json:"spec,omitempty"`
+	Status PodPlacementPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PodPlacementPolicyList contains a list of PodPlacementPolicy
+type PodPlacementPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodPlacementPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodPlacementPolicy{}, &PodPlacementPolicyList{})
+}
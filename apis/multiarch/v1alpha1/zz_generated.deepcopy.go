@@ -26,6 +26,127 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdmissionSettings) DeepCopyInto(out *AdmissionSettings) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdmissionSettings.
+func (in *AdmissionSettings) DeepCopy() *AdmissionSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(AdmissionSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArchitectureNodes) DeepCopyInto(out *ArchitectureNodes) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArchitectureNodes.
+func (in *ArchitectureNodes) DeepCopy() *ArchitectureNodes {
+	if in == nil {
+		return nil
+	}
+	out := new(ArchitectureNodes)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExtendedResourceArchitectures) DeepCopyInto(out *ExtendedResourceArchitectures) {
+	*out = *in
+	if in.Architectures != nil {
+		in, out := &in.Architectures, &out.Architectures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExtendedResourceArchitectures.
+func (in *ExtendedResourceArchitectures) DeepCopy() *ExtendedResourceArchitectures {
+	if in == nil {
+		return nil
+	}
+	out := new(ExtendedResourceArchitectures)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForcedSync) DeepCopyInto(out *ForcedSync) {
+	*out = *in
+	in.CompletionTime.DeepCopyInto(&out.CompletionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForcedSync.
+func (in *ForcedSync) DeepCopy() *ForcedSync {
+	if in == nil {
+		return nil
+	}
+	out := new(ForcedSync)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatedPodsRequirement) DeepCopyInto(out *GatedPodsRequirement) {
+	*out = *in
+	if in.Architectures != nil {
+		in, out := &in.Architectures, &out.Architectures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatedPodsRequirement.
+func (in *GatedPodsRequirement) DeepCopy() *GatedPodsRequirement {
+	if in == nil {
+		return nil
+	}
+	out := new(GatedPodsRequirement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementPolicy) DeepCopyInto(out *PlacementPolicy) {
+	*out = *in
+	if in.AllowedArchitectures != nil {
+		in, out := &in.AllowedArchitectures, &out.AllowedArchitectures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DefaultArchitectures != nil {
+		in, out := &in.DefaultArchitectures, &out.DefaultArchitectures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedContainerNames != nil {
+		in, out := &in.ExcludedContainerNames, &out.ExcludedContainerNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OptOut != nil {
+		in, out := &in.OptOut, &out.OptOut
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementPolicy.
+func (in *PlacementPolicy) DeepCopy() *PlacementPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodPlacementConfig) DeepCopyInto(out *PodPlacementConfig) {
 	*out = *in
@@ -93,6 +214,36 @@ func (in *PodPlacementConfigSpec) DeepCopyInto(out *PodPlacementConfigSpec) {
 		*out = new(v1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.TrustedMultiArchPrefixes != nil {
+		in, out := &in.TrustedMultiArchPrefixes, &out.TrustedMultiArchPrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalNodeSelectorTerms != nil {
+		in, out := &in.AdditionalNodeSelectorTerms, &out.AdditionalNodeSelectorTerms
+		*out = make(map[string]v1.LabelSelector, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ExtendedResourceArchitectures != nil {
+		in, out := &in.ExtendedResourceArchitectures, &out.ExtendedResourceArchitectures
+		*out = make([]ExtendedResourceArchitectures, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Admission != nil {
+		in, out := &in.Admission, &out.Admission
+		*out = new(AdmissionSettings)
+		**out = **in
+	}
+	if in.Tuning != nil {
+		in, out := &in.Tuning, &out.Tuning
+		*out = new(TuningSettings)
+		(*in).DeepCopyInto(*out)
+	}
+	in.PlacementPolicy.DeepCopyInto(&out.PlacementPolicy)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementConfigSpec.
@@ -115,6 +266,23 @@ func (in *PodPlacementConfigStatus) DeepCopyInto(out *PodPlacementConfigStatus)
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ClusterArchitectures != nil {
+		in, out := &in.ClusterArchitectures, &out.ClusterArchitectures
+		*out = make([]ArchitectureNodes, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingGatedPods != nil {
+		in, out := &in.PendingGatedPods, &out.PendingGatedPods
+		*out = make([]GatedPodsRequirement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ForcedSync != nil {
+		in, out := &in.ForcedSync, &out.ForcedSync
+		*out = new(ForcedSync)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementConfigStatus.
@@ -126,3 +294,160 @@ func (in *PodPlacementConfigStatus) DeepCopy() *PodPlacementConfigStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodPlacementPolicy) DeepCopyInto(out *PodPlacementPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicy.
+func (in *PodPlacementPolicy) DeepCopy() *PodPlacementPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PodPlacementPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodPlacementPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodPlacementPolicyList) DeepCopyInto(out *PodPlacementPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PodPlacementPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicyList.
+func (in *PodPlacementPolicyList) DeepCopy() *PodPlacementPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(PodPlacementPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodPlacementPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodPlacementPolicySpec) DeepCopyInto(out *PodPlacementPolicySpec) {
+	*out = *in
+	in.PlacementPolicy.DeepCopyInto(&out.PlacementPolicy)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicySpec.
+func (in *PodPlacementPolicySpec) DeepCopy() *PodPlacementPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodPlacementPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodPlacementPolicyStatus) DeepCopyInto(out *PodPlacementPolicyStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacementPolicyStatus.
+func (in *PodPlacementPolicyStatus) DeepCopy() *PodPlacementPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PodPlacementPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TuningSettings) DeepCopyInto(out *TuningSettings) {
+	*out = *in
+	if in.InspectionTimeout != nil {
+		in, out := &in.InspectionTimeout, &out.InspectionTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxParallelInspections != nil {
+		in, out := &in.MaxParallelInspections, &out.MaxParallelInspections
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ArchitecturesCacheTTL != nil {
+		in, out := &in.ArchitecturesCacheTTL, &out.ArchitecturesCacheTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.NotFoundCacheTTL != nil {
+		in, out := &in.NotFoundCacheTTL, &out.NotFoundCacheTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.UnauthorizedCacheTTL != nil {
+		in, out := &in.UnauthorizedCacheTTL, &out.UnauthorizedCacheTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DecisionCacheTTL != nil {
+		in, out := &in.DecisionCacheTTL, &out.DecisionCacheTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxRetryableBackoff != nil {
+		in, out := &in.MaxRetryableBackoff, &out.MaxRetryableBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxPermanentFailureBackoff != nil {
+		in, out := &in.MaxPermanentFailureBackoff, &out.MaxPermanentFailureBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.GatedPodsSweepInterval != nil {
+		in, out := &in.GatedPodsSweepInterval, &out.GatedPodsSweepInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TuningSettings.
+func (in *TuningSettings) DeepCopy() *TuningSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(TuningSettings)
+	in.DeepCopyInto(out)
+	return out
+}
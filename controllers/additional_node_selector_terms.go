@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// additionalNodeSelectorRequirements returns the node selector requirements of the additionalNodeSelectorTerms of the
+// PodPlacementConfig objects, by architecture. The selectors with an invalid operator are logged and ignored.
+func additionalNodeSelectorRequirements(
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) map[string][]corev1.NodeSelectorRequirement {
+	var additional map[string][]corev1.NodeSelectorRequirement
+	for _, ppc := range podPlacementConfigs {
+		for architecture, selector := range ppc.Spec.AdditionalNodeSelectorTerms {
+			requirements, err := nodeSelectorRequirements(selector)
+			if err != nil {
+				klog.Warningf("Ignoring the additional node selector terms of the architecture %s of the "+
+					"PodPlacementConfig %s: %v", architecture, ppc.Name, err)
+				continue
+			}
+			if len(requirements) == 0 {
+				continue
+			}
+			if additional == nil {
+				additional = map[string][]corev1.NodeSelectorRequirement{}
+			}
+			additional[architecture] = append(additional[architecture], requirements...)
+		}
+	}
+	return additional
+}
+
+// nodeSelectorRequirements returns the node selector requirements equivalent to the label selector, the ones of its
+// matchLabels first, sorted by key
+func nodeSelectorRequirements(selector metav1.LabelSelector) ([]corev1.NodeSelectorRequirement, error) {
+	keys := make([]string, 0, len(selector.MatchLabels))
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	requirements := make([]corev1.NodeSelectorRequirement, 0, len(keys)+len(selector.MatchExpressions))
+	for _, key := range keys {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{selector.MatchLabels[key]},
+		})
+	}
+	for _, expression := range selector.MatchExpressions {
+		var operator corev1.NodeSelectorOperator
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn:
+			operator = corev1.NodeSelectorOpIn
+		case metav1.LabelSelectorOpNotIn:
+			operator = corev1.NodeSelectorOpNotIn
+		case metav1.LabelSelectorOpExists:
+			operator = corev1.NodeSelectorOpExists
+		case metav1.LabelSelectorOpDoesNotExist:
+			operator = corev1.NodeSelectorOpDoesNotExist
+		default:
+			return nil, fmt.Errorf("invalid operator %q of the key %s", expression.Operator, expression.Key)
+		}
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      expression.Key,
+			Operator: operator,
+			Values:   append([]string(nil), expression.Values...),
+		})
+	}
+	return requirements, nil
+}
+
+// withoutSelectedKeys returns the additional requirements without the ones on the keys the pod already selects,
+// through its nodeSelector or the expressions of its required node affinity, and the sorted conflicting keys. The
+// values the users set are never overridden.
+func withoutSelectedKeys(pod *corev1.Pod,
+	additional map[string][]corev1.NodeSelectorRequirement) (map[string][]corev1.NodeSelectorRequirement, []string) {
+	if len(additional) == 0 {
+		return nil, nil
+	}
+	selected := sets.KeySet(pod.Spec.NodeSelector)
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil &&
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, expression := range term.MatchExpressions {
+				selected.Insert(expression.Key)
+			}
+		}
+	}
+	filtered := make(map[string][]corev1.NodeSelectorRequirement, len(additional))
+	conflicts := sets.New[string]()
+	for architecture, requirements := range additional {
+		for _, requirement := range requirements {
+			if selected.Has(requirement.Key) {
+				conflicts.Insert(requirement.Key)
+				continue
+			}
+			filtered[architecture] = append(filtered[architecture], requirement)
+		}
+	}
+	return filtered, sets.List(conflicts)
+}
+
+// architectureTerms returns the node selector terms matching the nodes of the architectures of the requirement. Each
+// architecture with additional requirements gets its own term, ANDing them with the requirement for the architecture;
+// the other ones share the first term. When betaArchLabelFallback is true, each term is followed by the same one
+// matching the nodes by the betaArchLabel label.
+func architectureTerms(requirement corev1.NodeSelectorRequirement,
+	additional map[string][]corev1.NodeSelectorRequirement, betaArchLabelFallback bool) []corev1.NodeSelectorTerm {
+	var shared []string
+	var terms []corev1.NodeSelectorTerm
+	for _, architecture := range requirement.Values {
+		if len(additional[architecture]) == 0 || requirement.Operator != corev1.NodeSelectorOpIn {
+			shared = append(shared, architecture)
+			continue
+		}
+		architectureRequirement := *requirement.DeepCopy()
+		architectureRequirement.Values = []string{architecture}
+		terms = append(terms, corev1.NodeSelectorTerm{MatchExpressions: append(
+			[]corev1.NodeSelectorRequirement{architectureRequirement}, additional[architecture]...)})
+	}
+	if len(shared) > 0 {
+		sharedRequirement := *requirement.DeepCopy()
+		sharedRequirement.Values = shared
+		terms = append([]corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{sharedRequirement},
+		}}, terms...)
+	}
+	if !betaArchLabelFallback {
+		return terms
+	}
+	withFallback := make([]corev1.NodeSelectorTerm, 0, 2*len(terms))
+	for _, term := range terms {
+		beta := *term.DeepCopy()
+		beta.MatchExpressions[0] = betaArchRequirement(beta.MatchExpressions[0])
+		withFallback = append(withFallback, term, beta)
+	}
+	return withFallback
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// armWorkersRequirement is the requirement of the additional node selector terms of arm64 in the tests
+var armWorkersRequirement = corev1.NodeSelectorRequirement{
+	Key:      "node-role.kubernetes.io/arm-workers",
+	Operator: corev1.NodeSelectorOpIn,
+	Values:   []string{""},
+}
+
+var _ = Describe("The additional node selector terms", func() {
+	var (
+		c             client.Client
+		architectures *fakeArchitectures
+		recorder      *record.FakeRecorder
+		reconciler    *PodReconciler
+	)
+
+	BeforeEach(func() {
+		config := clusterPlacementPolicy("cluster", multiarchv1alpha1.PlacementPolicy{})
+		config.Spec.AdditionalNodeSelectorTerms = map[string]metav1.LabelSelector{
+			"arm64": {MatchLabels: map[string]string{"node-role.kubernetes.io/arm-workers": ""}},
+		}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(config).Build()
+		architectures = &fakeArchitectures{registry: map[string][]string{
+			"//quay.io/org/app:v1":   {"amd64", "arm64"},
+			"//quay.io/org/x86:v1":   {"amd64"},
+			"//quay.io/org/arm64:v1": {"arm64"},
+		}}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &PodReconciler{Client: c, Inspector: architectures, Recorder: recorder}
+	})
+
+	createGated := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		return pod
+	}
+
+	requiredTerms := func(pod *corev1.Pod) []corev1.NodeSelectorTerm {
+		affinity := getPod(c, pod).Spec.Affinity
+		return affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	}
+
+	It("should be ANDed with the requirement for their architecture in its own term", func() {
+		pod := createGated(podWithImages("pod", "quay.io/org/app:v1"))
+		reconcilePods(reconciler, pod)
+		armTerm := archTerm(archLabel, "arm64")
+		armTerm.MatchExpressions = append(armTerm.MatchExpressions, armWorkersRequirement)
+		Expect(requiredTerms(pod)).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64"), armTerm}))
+	})
+
+	It("should not be added when their architecture is not one of the pod", func() {
+		pod := createGated(podWithImages("pod", "quay.io/org/x86:v1"))
+		reconcilePods(reconciler, pod)
+		Expect(requiredTerms(pod)).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64")}))
+	})
+
+	It("should be added to each node selector term of the pod", func() {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		zone := corev1.NodeSelectorRequirement{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn,
+			Values: []string{"a"}}
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{zone}}},
+			},
+		}}
+		reconcilePods(reconciler, createGated(pod))
+		amd64 := archTerm(archLabel, "amd64").MatchExpressions[0]
+		arm64 := archTerm(archLabel, "arm64").MatchExpressions[0]
+		Expect(requiredTerms(pod)).To(Equal([]corev1.NodeSelectorTerm{
+			{MatchExpressions: []corev1.NodeSelectorRequirement{zone, amd64}},
+			{MatchExpressions: []corev1.NodeSelectorRequirement{zone, arm64, armWorkersRequirement}},
+		}))
+	})
+
+	It("should leave the values selected by the pod and report the conflicts", func() {
+		pod := podWithImages("pod", "quay.io/org/arm64:v1")
+		pod.Spec.NodeSelector = map[string]string{"node-role.kubernetes.io/arm-workers": "false"}
+		reconcilePods(reconciler, createGated(pod))
+		placed := getPod(c, pod)
+		Expect(placed.Spec.NodeSelector).To(HaveKeyWithValue("node-role.kubernetes.io/arm-workers", "false"))
+		Expect(requiredTerms(pod)).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasons.ArchitectureConstrained)))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasons.AdditionalNodeSelectorTermsConflict)))
+	})
+
+	It("should convert the expressions of the label selectors", func() {
+		requirements, err := nodeSelectorRequirements(metav1.LabelSelector{
+			MatchLabels: map[string]string{"b": "2", "a": "1"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "c", Operator: metav1.LabelSelectorOpExists},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requirements).To(Equal([]corev1.NodeSelectorRequirement{
+			{Key: "a", Operator: corev1.NodeSelectorOpIn, Values: []string{"1"}},
+			{Key: "b", Operator: corev1.NodeSelectorOpIn, Values: []string{"2"}},
+			{Key: "c", Operator: corev1.NodeSelectorOpExists},
+		}))
+		_, err = nodeSelectorRequirements(metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "c", Operator: "Gt"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// betaOnlyNodeWithCapacity returns a node reporting its architecture only through the deprecated beta label
+func betaOnlyNodeWithCapacity(name, arch, cpu, memory string) *corev1.Node {
+	node := nodeWithCapacity(name, arch, cpu, memory, false)
+	node.Labels = map[string]string{betaArchLabel: arch}
+	return node
+}
+
+func archTerm(key string, values ...string) corev1.NodeSelectorTerm {
+	return corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   values,
+		}},
+	}
+}
+
+var _ = Describe("The node architecture labels", func() {
+	var pod *corev1.Pod
+
+	BeforeEach(func() {
+		pod = podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64,s390x"}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+	})
+
+	reconcile := func(objs ...client.Object) client.Client {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(append(objs, pod)...).Build()
+		// any inspection would panic
+		r := &PodReconciler{Client: c, Inspector: noInspections{}}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	It("should index the architectures of the nodes with either label", func() {
+		c := NewArchitectureCapacityCache(fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			nodeWithCapacity("amd64-1", "amd64", "4", "16Gi", false),
+			betaOnlyNodeWithCapacity("s390x-1", "s390x", "2", "8Gi"),
+			betaOnlyNodeWithCapacity("s390x-2", "s390x", "2", "8Gi"),
+		).Build(), time.Minute)
+		Expect(c.refresh(context.Background())).To(Succeed())
+		Expect(c.headroom).To(HaveLen(2))
+		expectQuantity(c.headroom["s390x"], corev1.ResourceCPU, "4")
+	})
+
+	It("should prefer the GA label when a node has both", func() {
+		node := nodeWithCapacity("node", "arm64", "1", "1Gi", false)
+		node.Labels[betaArchLabel] = "amd64"
+		arch, ok := schedulableNodeArchitecture(node)
+		Expect(ok).To(BeTrue())
+		Expect(arch).To(Equal("arm64"))
+	})
+
+	It("should only match the GA label when all the nodes have it", func() {
+		both := nodeWithCapacity("both", "arm64", "1", "1Gi", false)
+		both.Labels[betaArchLabel] = "arm64"
+		updated := getPod(reconcile(both, nodeWithCapacity("ga", "s390x", "1", "1Gi", false)), pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64", "s390x")}))
+	})
+
+	It("should also match the beta label when some nodes only have it", func() {
+		updated := getPod(reconcile(nodeWithCapacity("ga", "arm64", "1", "1Gi", false),
+			betaOnlyNodeWithCapacity("beta", "s390x", "1", "1Gi")), pod)
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{
+				archTerm(archLabel, "arm64", "s390x"),
+				archTerm(betaArchLabel, "arm64", "s390x"),
+			}))
+	})
+
+	It("should not add terms to the node affinity of the pod", func() {
+		zoneTerm := corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{
+				Key:      "topology.kubernetes.io/zone",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"zone-a"},
+			}},
+		}
+		betaTerm := archTerm(betaArchLabel, "s390x")
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{zoneTerm, betaTerm},
+			},
+		}}
+		updated := getPod(reconcile(betaOnlyNodeWithCapacity("beta", "s390x", "1", "1Gi")), pod)
+		expectedZoneTerm := *zoneTerm.DeepCopy()
+		expectedZoneTerm.MatchExpressions = append(expectedZoneTerm.MatchExpressions,
+			archTerm(archLabel, "arm64", "s390x").MatchExpressions...)
+		// the term already matching the architecture by the beta label is kept as is
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{expectedZoneTerm, betaTerm}))
+	})
+
+	It("should prefer the nodes by either label in the Preferred mode", func() {
+		policy := namespacePlacementPolicy("policy", time.Now(), multiarchv1alpha1.PlacementPolicy{
+			PlacementMode: multiarchv1alpha1.PlacementModePreferred,
+		})
+		updated := getPod(reconcile(policy, betaOnlyNodeWithCapacity("beta", "s390x", "1", "1Gi")), pod)
+		Expect(updated.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(ConsistOf(
+			corev1.PreferredSchedulingTerm{
+				Weight:     preferredArchitecturesWeight,
+				Preference: archTerm(archLabel, "arm64", "s390x"),
+			},
+			corev1.PreferredSchedulingTerm{
+				Weight:     preferredArchitecturesWeight,
+				Preference: archTerm(betaArchLabel, "arm64", "s390x"),
+			}))
+	})
+})
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// ArchitecturesDistribution returns the number of schedulable nodes of each architecture and the number of gated pods
+// of each architectures requirement, as reported by the status of the PodPlacementConfig. The requirement of a pod is
+// the one of its valid architectures override annotation, or else of its archLabel node selector; it is empty for the
+// pods whose architectures are decided by the inspection of their images. Both lists are sorted, so that they only
+// change with the distribution. The gated pods are the ones with the scheduling gate of the instance.
+func ArchitecturesDistribution(ctx context.Context, c client.Reader, instance *Instance) (
+	[]multiarchv1alpha1.ArchitectureNodes, []multiarchv1alpha1.GatedPodsRequirement, error) {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, nil, err
+	}
+	nodesByArchitecture := map[string]int32{}
+	for i := range nodes.Items {
+		if arch, ok := schedulableNodeArchitecture(&nodes.Items[i]); ok {
+			nodesByArchitecture[arch]++
+		}
+	}
+	clusterArchitectures := make([]multiarchv1alpha1.ArchitectureNodes, 0, len(nodesByArchitecture))
+	for _, arch := range sets.List(sets.KeySet(nodesByArchitecture)) {
+		clusterArchitectures = append(clusterArchitectures, multiarchv1alpha1.ArchitectureNodes{
+			Architecture: arch,
+			Nodes:        nodesByArchitecture[arch],
+		})
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods); err != nil {
+		return nil, nil, err
+	}
+	podsByRequirement := map[string]int32{}
+	for i := range pods.Items {
+		if instance.hasSchedulingGate(&pods.Items[i]) {
+			podsByRequirement[strings.Join(gatedPodRequirement(instance, &pods.Items[i]), ",")]++
+		}
+	}
+	pendingGatedPods := make([]multiarchv1alpha1.GatedPodsRequirement, 0, len(podsByRequirement))
+	for _, requirement := range sets.List(sets.KeySet(podsByRequirement)) {
+		pendingGatedPods = append(pendingGatedPods, multiarchv1alpha1.GatedPodsRequirement{
+			Architectures: splitArchitectures(requirement),
+			Pods:          podsByRequirement[requirement],
+		})
+	}
+	return clusterArchitectures, pendingGatedPods, nil
+}
+
+// gatedPodRequirement returns the sorted architectures required by the gated pod, or nil when they are decided by the
+// inspection of its images. An invalid override annotation is ignored, as the pod reconciler does.
+func gatedPodRequirement(instance *Instance, pod *corev1.Pod) []string {
+	if value, ok := pod.Annotations[instance.annotation(architecturesOverrideAnnotation)]; ok {
+		if architectures, err := parseArchitectures(value); err == nil {
+			return architectures
+		}
+	}
+	if arch, ok := pod.Spec.NodeSelector[archLabel]; ok {
+		return []string{arch}
+	}
+	return nil
+}
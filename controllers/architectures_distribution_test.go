@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+var _ = Describe("The distribution of the architectures", func() {
+	gatedPod := func(name string) *corev1.Pod {
+		pod := podWithImages(name, "quay.io/org/app:latest")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		return pod
+	}
+
+	It("should count the schedulable nodes and the gated pods of each requirement", func() {
+		betaNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   "beta-node",
+			Labels: map[string]string{betaArchLabel: "arm64"},
+		}}
+		overridden := gatedPod("overridden")
+		overridden.Annotations = map[string]string{architecturesOverrideAnnotation: "x86_64, aarch64"}
+		invalid := gatedPod("invalid")
+		invalid.Annotations = map[string]string{architecturesOverrideAnnotation: "sparc"}
+		selected := gatedPod("selected")
+		selected.Spec.NodeSelector = map[string]string{archLabel: "s390x"}
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			nodeWithCapacity("amd64-1", "amd64", "4", "16Gi", false),
+			nodeWithCapacity("amd64-2", "amd64", "4", "16Gi", false),
+			nodeWithCapacity("amd64-cordoned", "amd64", "4", "16Gi", true),
+			nodeWithCapacity("arm64-1", "arm64", "4", "16Gi", false),
+			betaNode,
+			gatedPod("inspected-1"), gatedPod("inspected-2"), invalid, overridden, selected,
+			podWithImages("placed", "quay.io/org/app:latest"),
+		).Build()
+
+		clusterArchitectures, pendingGatedPods, err := ArchitecturesDistribution(context.Background(), c, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusterArchitectures).To(Equal([]multiarchv1alpha1.ArchitectureNodes{
+			{Architecture: "amd64", Nodes: 2},
+			{Architecture: "arm64", Nodes: 2},
+		}))
+		Expect(pendingGatedPods).To(Equal([]multiarchv1alpha1.GatedPodsRequirement{
+			{Pods: 3},
+			{Architectures: []string{"amd64", "arm64"}, Pods: 1},
+			{Architectures: []string{"s390x"}, Pods: 1},
+		}))
+	})
+})
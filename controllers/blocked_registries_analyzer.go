@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	ocpv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxPodsPerRegistryInEvent is the maximum number of pods listed for each registry in the summarized event
+	maxPodsPerRegistryInEvent = 5
+)
+
+// blockedRegistriesChange carries the arguments of a BlockedRegistriesObserver notification
+type blockedRegistriesChange struct {
+	blocked []string
+	added   []string
+}
+
+// BlockedRegistriesAnalyzer looks for the pods using images from the blocked registries when the set of blocked
+// registries changes. It exports the number of such pods per registry through the
+// multiarch_workloads_on_blocked_registries gauge and emits a single Warning event on the
+// image.config.openshift.io/cluster object summarizing the pods affected by the newly blocked registries.
+// It implements both the system_config.BlockedRegistriesObserver and the manager.Runnable interfaces.
+// The scans are rate-limited: changes received while the analyzer waits for minInterval to elapse are coalesced.
+type BlockedRegistriesAnalyzer struct {
+	client      client.Reader
+	recorder    record.EventRecorder
+	minInterval time.Duration
+	changes     chan blockedRegistriesChange
+	// registries is the set of registries for which the gauge has a value
+	registries sets.Set[string]
+}
+
+func NewBlockedRegistriesAnalyzer(c client.Reader, recorder record.EventRecorder, minInterval time.Duration) *BlockedRegistriesAnalyzer {
+	return &BlockedRegistriesAnalyzer{
+		client:      c,
+		recorder:    recorder,
+		minInterval: minInterval,
+		changes:     make(chan blockedRegistriesChange, 1),
+		registries:  sets.New[string](),
+	}
+}
+
+// OnBlockedRegistriesChange queues a new analysis. If an analysis is already queued, the newly added registries are
+// merged into it.
+func (a *BlockedRegistriesAnalyzer) OnBlockedRegistriesChange(blocked []string, added []string) {
+	change := blockedRegistriesChange{blocked: blocked, added: added}
+	for {
+		select {
+		case a.changes <- change:
+			return
+		default:
+		}
+		select {
+		case pending := <-a.changes:
+			change.added = sets.List(sets.New(change.added...).Insert(pending.added...).Intersection(sets.New(blocked...)))
+		default:
+		}
+	}
+}
+
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Start consumes the queued changes until the context is done, waiting at least minInterval between two scans.
+func (a *BlockedRegistriesAnalyzer) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-a.changes:
+			if err := a.analyze(ctx, change); err != nil {
+				klog.Warningf("unable to analyze the pods using blocked registries: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(a.minInterval):
+		}
+	}
+}
+
+func (a *BlockedRegistriesAnalyzer) analyze(ctx context.Context, change blockedRegistriesChange) error {
+	pods := &corev1.PodList{}
+	if err := a.client.List(ctx, pods); err != nil {
+		return err
+	}
+	podsPerRegistry := map[string][]string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, registry := range blockedRegistriesUsedByPod(pod, change.blocked) {
+			podsPerRegistry[registry] = append(podsPerRegistry[registry], fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		}
+	}
+	for _, registry := range sets.List(a.registries.Difference(sets.New(change.blocked...))) {
+		workloadsOnBlockedRegistries.DeleteLabelValues(registry)
+	}
+	a.registries = sets.New(change.blocked...)
+	for _, registry := range change.blocked {
+		workloadsOnBlockedRegistries.WithLabelValues(registry).Set(float64(len(podsPerRegistry[registry])))
+	}
+
+	summary := make([]string, 0, len(change.added))
+	for _, registry := range change.added {
+		podNames := podsPerRegistry[registry]
+		if len(podNames) == 0 {
+			continue
+		}
+		sort.Strings(podNames)
+		sample := podNames
+		if len(sample) > maxPodsPerRegistryInEvent {
+			sample = append(sample[:maxPodsPerRegistryInEvent:maxPodsPerRegistryInEvent], "...")
+		}
+		summary = append(summary, fmt.Sprintf("%s (%d pods: %s)", registry, len(podNames), strings.Join(sample, ", ")))
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+	imageConfig, err := a.clusterImageConfig(ctx)
+	if err != nil {
+		return err
+	}
+	a.recorder.Eventf(imageConfig, corev1.EventTypeWarning, reasons.RegistryBlocked,
+		"Pods using images from newly blocked registries will fail to pull them on restart: %s",
+		strings.Join(summary, "; "))
+	return nil
+}
+
+// blockedRegistriesUsedByPod returns the blocked registries the images of the pod are pulled from
+func blockedRegistriesUsedByPod(pod *corev1.Pod, blocked []string) []string {
+	used := sets.New[string]()
+	for _, container := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
+		named, err := reference.ParseNormalizedNamed(container.Image)
+		if err != nil {
+			continue
+		}
+		for _, registry := range blocked {
+			if imageMatchesRegistry(named, registry) {
+				used.Insert(registry)
+			}
+		}
+	}
+	return sets.List(used)
+}
+
+// imageMatchesRegistry returns true if the image is in the scope of the registry entry, according to the syntax of
+// the image.config.openshift.io registrySources: a host, optionally followed by a repository path, or a wildcard
+// domain like *.example.com.
+func imageMatchesRegistry(named reference.Named, registry string) bool {
+	if strings.HasPrefix(registry, "*.") {
+		host := reference.Domain(named)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.HasSuffix(host, registry[1:])
+	}
+	name := named.Name()
+	return name == registry || strings.HasPrefix(name, registry+"/")
+}
+
+//+kubebuilder:rbac:groups=config.openshift.io,resources=images,verbs=get;list;watch
+
+// clusterImageConfig returns the object the events about the image registry configuration are attached to.
+// The object is read from the cluster, as the events need its UID to be listed with it.
+func (a *BlockedRegistriesAnalyzer) clusterImageConfig(ctx context.Context) (*ocpv1.Image, error) {
+	imageConfig := &ocpv1.Image{}
+	if err := a.client.Get(ctx, client.ObjectKey{Name: "cluster"}, imageConfig); err != nil {
+		return nil, err
+	}
+	// The typed clients drop the TypeMeta
+	imageConfig.SetGroupVersionKind(ocpv1.GroupVersion.WithKind("Image"))
+	return imageConfig, nil
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ocpv1 "github.com/openshift/api/config/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func podWithImages(name string, images ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"}}
+	for _, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Image: image})
+	}
+	return pod
+}
+
+// objectRecorder records the objects the events are attached to
+type objectRecorder struct {
+	*record.FakeRecorder
+	objects []runtime.Object
+}
+
+func (r *objectRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.objects = append(r.objects, object)
+	r.FakeRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func gaugeValue(gauge prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	Expect(gauge.Write(m)).To(Succeed())
+	return m.GetGauge().GetValue()
+}
+
+func seriesCount(collector prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	collector.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+var _ = Describe("The blocked registries analyzer", func() {
+	var (
+		a        *BlockedRegistriesAnalyzer
+		recorder *objectRecorder
+	)
+
+	BeforeEach(func() {
+		recorder = &objectRecorder{FakeRecorder: record.NewFakeRecorder(10)}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ocpv1.AddToScheme(scheme)).To(Succeed())
+		a = NewBlockedRegistriesAnalyzer(fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&ocpv1.Image{ObjectMeta: metav1.ObjectMeta{Name: "cluster", UID: "cluster-uid"}},
+			podWithImages("a", "quay.io/org/app:latest", "nginx"),
+			podWithImages("b", "registry.example.com:5000/app@sha256:"+strings.Repeat("a", 64)),
+			podWithImages("c", "mirror.internal.example.com/app"),
+			podWithImages("d", "quay.io/other/app"),
+		).Build(), recorder, 0)
+		workloadsOnBlockedRegistries.Reset()
+	})
+
+	It("should coalesce the changes not consumed yet", func() {
+		a.OnBlockedRegistriesChange([]string{"docker.io", "quay.io/org"}, []string{"docker.io"})
+		a.OnBlockedRegistriesChange([]string{"docker.io", "*.example.com"}, []string{"*.example.com"})
+		change := <-a.changes
+		Expect(change.blocked).To(Equal([]string{"docker.io", "*.example.com"}))
+		Expect(change.added).To(ConsistOf("docker.io", "*.example.com"))
+	})
+
+	It("should report the pods using images from the blocked registries", func() {
+		Expect(a.analyze(context.Background(), blockedRegistriesChange{
+			blocked: []string{"docker.io", "quay.io/org", "*.example.com"},
+			added:   []string{"quay.io/org", "*.example.com"},
+		})).To(Succeed())
+		for _, registry := range []string{"docker.io", "quay.io/org"} {
+			Expect(gaugeValue(workloadsOnBlockedRegistries.WithLabelValues(registry))).To(BeEquivalentTo(1))
+		}
+		Expect(gaugeValue(workloadsOnBlockedRegistries.WithLabelValues("*.example.com"))).To(BeEquivalentTo(2))
+		Expect(recorder.Events).To(HaveLen(1))
+		event := <-recorder.Events
+		Expect(event).To(ContainSubstring("test/a"))
+		Expect(event).To(ContainSubstring("*.example.com (2 pods: test/b, test/c)"))
+		Expect(event).NotTo(ContainSubstring("test/d"))
+		Expect(recorder.objects).To(HaveLen(1))
+		imageConfig, ok := recorder.objects[0].(*ocpv1.Image)
+		Expect(ok).To(BeTrue())
+		Expect(imageConfig.UID).To(BeEquivalentTo("cluster-uid"))
+		Expect(imageConfig.GroupVersionKind()).To(Equal(ocpv1.GroupVersion.WithKind("Image")))
+	})
+
+	It("should only update the gauge when no registry is added", func() {
+		Expect(a.analyze(context.Background(), blockedRegistriesChange{
+			blocked: []string{"docker.io", "quay.io/org"},
+		})).To(Succeed())
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(seriesCount(workloadsOnBlockedRegistries)).To(Equal(2))
+
+		Expect(a.analyze(context.Background(), blockedRegistriesChange{
+			blocked: []string{"docker.io"},
+		})).To(Succeed())
+		Expect(seriesCount(workloadsOnBlockedRegistries)).To(Equal(1))
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+const (
+	// podPlacementConfigsResource and podPlacementPoliciesResource are the resources of the CustomResourceDefinitions
+	// of the operator
+	podPlacementConfigsResource  = "podplacementconfigs"
+	podPlacementPoliciesResource = "podplacementpolicies"
+)
+
+// minSchedulingGatesVersion is the first Kubernetes version enabling the PodSchedulingReadiness feature gate by default
+var minSchedulingGatesVersion = version.MustParseGeneric("1.27.0")
+
+// errCustomResourcesInstalled stops the manager of the operator started without its CustomResourceDefinitions
+var errCustomResourcesInstalled = errors.New("the CustomResourceDefinitions of the operator have been installed: " +
+	"restarting to manage the PodPlacementConfig and PodPlacementPolicy objects")
+
+// SchedulingGatesSupported returns whether the API server supports the pod scheduling gates, based on its version.
+// The API servers older than 1.27 reject the pods with scheduling gates, unless the PodSchedulingReadiness feature
+// gate is explicitly enabled.
+func SchedulingGatesSupported(client discovery.ServerVersionInterface) (bool, error) {
+	info, err := client.ServerVersion()
+	if err != nil {
+		return false, fmt.Errorf("unable to get the version of the API server: %w", err)
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, fmt.Errorf("unable to parse the version of the API server %q: %w", info.GitVersion, err)
+	}
+	supported := serverVersion.AtLeast(minSchedulingGatesVersion)
+	klog.V(3).Infof("API server version %s, scheduling gates supported: %t", serverVersion, supported)
+	return supported, nil
+}
+
+// CustomResourcesInstalled returns whether the API server serves the PodPlacementConfig and PodPlacementPolicy
+// resources, i.e., whether the CustomResourceDefinitions of the operator are installed
+func CustomResourcesInstalled(client discovery.ServerResourcesInterface) (bool, error) {
+	groupVersion := multiarchv1alpha1.GroupVersion.String()
+	resources, err := client.ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		klog.V(3).Infof("The API server does not serve the %s resources", groupVersion)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to discover the %s resources: %w", groupVersion, err)
+	}
+	served := sets.New[string]()
+	for _, resource := range resources.APIResources {
+		served.Insert(resource.Name)
+	}
+	installed := served.HasAll(podPlacementConfigsResource, podPlacementPoliciesResource)
+	klog.V(3).Infof("The API server serves the %s resources %v, custom resources installed: %t", groupVersion,
+		sets.List(served), installed)
+	return installed, nil
+}
+
+// CustomResourcesWatcher stops the manager once the CustomResourceDefinitions of the operator are installed, so that
+// the operator started without them is restarted to manage the PodPlacementConfig and PodPlacementPolicy objects.
+// It implements the manager.Runnable interface.
+type CustomResourcesWatcher struct {
+	discovery discovery.ServerResourcesInterface
+	interval  time.Duration
+	clock     clock.WithTicker
+}
+
+func NewCustomResourcesWatcher(discovery discovery.ServerResourcesInterface,
+	interval time.Duration) *CustomResourcesWatcher {
+	return &CustomResourcesWatcher{
+		discovery: discovery,
+		interval:  interval,
+		clock:     clock.RealClock{},
+	}
+}
+
+// Start checks every interval whether the custom resources are installed, until the context is done. It returns an
+// error, stopping the manager, once they are.
+func (w *CustomResourcesWatcher) Start(ctx context.Context) error {
+	ticker := w.clock.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			installed, err := CustomResourcesInstalled(w.discovery)
+			if err != nil {
+				klog.Warningf("Unable to check whether the custom resources are installed: %v", err)
+				continue
+			}
+			if installed {
+				return errCustomResourcesInstalled
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false: every replica has to be restarted.
+func (w *CustomResourcesWatcher) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func fakeDiscovery(gitVersion string) *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{
+		Fake:               &clienttesting.Fake{},
+		FakedServerVersion: &version.Info{GitVersion: gitVersion},
+	}
+}
+
+var _ = Describe("The scheduling gates capability check", func() {
+	DescribeTable("should check the version of the API server",
+		func(gitVersion string, expected bool) {
+			supported, err := SchedulingGatesSupported(fakeDiscovery(gitVersion))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(supported).To(Equal(expected))
+		},
+		Entry("Kubernetes 1.25", "v1.25.11", false),
+		Entry("OpenShift 4.13", "v1.26.5+7d22122", false),
+		Entry("Kubernetes 1.27", "v1.27.0", true),
+		Entry("OpenShift 4.14", "v1.27.4+2c83a9f", true),
+		Entry("Kubernetes 1.28 release candidate", "v1.28.0-rc.1", true),
+	)
+
+	It("should return an error when the version cannot be parsed", func() {
+		_, err := SchedulingGatesSupported(fakeDiscovery("unknown"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error when the version cannot be discovered", func() {
+		discovery := fakeDiscovery("v1.27.0")
+		discovery.PrependReactor("get", "version", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("connection refused")
+		})
+		_, err := SchedulingGatesSupported(discovery)
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+	})
+
+	It("should not gate the pods when the webhook runs in degraded mode", func() {
+		supported, err := SchedulingGatesSupported(fakeDiscovery("v1.26.5"))
+		Expect(err).NotTo(HaveOccurred())
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{
+			Client:     fake.NewClientBuilder().WithScheme(scheme).Build(),
+			SkipGating: !supported,
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+})
+
+// withCustomResources returns the discovery serving the resources of the multiarch.openshift.io/v1alpha1 group
+func withCustomResources(discovery *fakediscovery.FakeDiscovery, resources ...string) *fakediscovery.FakeDiscovery {
+	list := &metav1.APIResourceList{GroupVersion: multiarchv1alpha1.GroupVersion.String()}
+	for _, resource := range resources {
+		list.APIResources = append(list.APIResources, metav1.APIResource{Name: resource})
+	}
+	discovery.Resources = []*metav1.APIResourceList{list}
+	return discovery
+}
+
+// listingWithoutCustomResources fails the lists of the PodPlacementConfig and PodPlacementPolicy objects as the
+// clients do when their CustomResourceDefinitions are not installed
+var listingWithoutCustomResources = interceptor.Funcs{
+	List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+		switch list.(type) {
+		case *multiarchv1alpha1.PodPlacementConfigList, *multiarchv1alpha1.PodPlacementPolicyList:
+			return &meta.NoKindMatchError{GroupKind: multiarchv1alpha1.GroupVersion.WithKind("List").GroupKind()}
+		}
+		return c.List(ctx, list, opts...)
+	},
+}
+
+var _ = Describe("The custom resources capability check", func() {
+	DescribeTable("should check the resources served by the API server",
+		func(discovery *fakediscovery.FakeDiscovery, expected bool) {
+			installed, err := CustomResourcesInstalled(discovery)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(installed).To(Equal(expected))
+		},
+		Entry("both resources", withCustomResources(fakeDiscovery("v1.27.0"), podPlacementConfigsResource,
+			podPlacementPoliciesResource, podPlacementConfigsResource+"/status"), true),
+		Entry("group not served", fakeDiscovery("v1.27.0"), false),
+		Entry("PodPlacementPolicy resource missing", withCustomResources(fakeDiscovery("v1.27.0"),
+			podPlacementConfigsResource), false),
+	)
+
+	It("should stop the manager once the custom resources are installed", func() {
+		discovery := fakeDiscovery("v1.27.0")
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		watcher := NewCustomResourcesWatcher(discovery, time.Minute)
+		watcher.clock = fakeClock
+		done := make(chan error, 1)
+		go func() {
+			done <- watcher.Start(context.Background())
+		}()
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(time.Minute)
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		withCustomResources(discovery, podPlacementConfigsResource, podPlacementPoliciesResource)
+		fakeClock.Step(time.Minute)
+		Eventually(done).Should(Receive(MatchError(errCustomResourcesInstalled)))
+	})
+
+	It("should gate and place the pods with the default placement settings without the custom resources", func() {
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(listingWithoutCustomResources).Build()
+		webhook := &PodSchedulingGateMutatingWebHook{
+			Client:              c,
+			PodPlacementConfigs: NewDefaultPodPlacementConfigSnapshot(),
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		r := &PodReconciler{
+			Client:                 c,
+			Inspector:              &fakeArchitectures{registry: map[string][]string{"//quay.io/org/app:v1": {"arm64"}}},
+			CustomResourcesMissing: true,
+		}
+		reconcilePods(r, pod)
+		updated := getPod(c, pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+})
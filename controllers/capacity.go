@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// capacityResources are the resources compared against the pod requests to decide whether an architecture can fit it
+var capacityResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// ArchitectureCapacityCache periodically computes the allocatable headroom of the cluster for each architecture, as the
+// sum of the allocatable resources of the schedulable nodes minus the requests of the pods running on them.
+// When a PodPlacementConfig sets considerAutoscaledCapacity, the architectures of the scalable MachineSets are
+// refreshed too.
+// It implements the manager.Runnable interface and is expected to be added to the manager.
+type ArchitectureCapacityCache struct {
+	client          client.Client
+	refreshInterval time.Duration
+	headroom        map[string]corev1.ResourceList
+	// autoscaled are the architectures the scalable MachineSets can provision nodes of, always considered as fitting
+	// the pods. It is empty unless considerAutoscaledCapacity is set.
+	autoscaled sets.Set[string]
+	// mutex is used to protect the headroom map and the autoscaled set from concurrent access
+	mutex sync.RWMutex
+}
+
+func NewArchitectureCapacityCache(c client.Client, refreshInterval time.Duration) *ArchitectureCapacityCache {
+	return &ArchitectureCapacityCache{
+		client:          c,
+		refreshInterval: refreshInterval,
+		headroom:        map[string]corev1.ResourceList{},
+	}
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// Start refreshes the headroom every refreshInterval until the context is done.
+func (c *ArchitectureCapacityCache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.refresh(ctx); err != nil {
+			klog.Warningf("unable to refresh the per-architecture capacity headroom: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ArchitectureCapacityCache) refresh(ctx context.Context) error {
+	nodes := &corev1.NodeList{}
+	if err := c.client.List(ctx, nodes); err != nil {
+		return err
+	}
+	pods := &corev1.PodList{}
+	if err := c.client.List(ctx, pods); err != nil {
+		return err
+	}
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, c.client)
+	if err != nil {
+		return err
+	}
+	autoscaled := sets.New[string]()
+	if considersAutoscaledCapacity(podPlacementConfigs) {
+		if autoscaled, err = autoscaledArchitectures(ctx, c.client); err != nil {
+			return err
+		}
+	}
+	nodeArchitecture := map[string]string{}
+	headroom := map[string]corev1.ResourceList{}
+	for _, node := range nodes.Items {
+		arch, ok := schedulableNodeArchitecture(&node)
+		if !ok {
+			continue
+		}
+		nodeArchitecture[node.Name] = arch
+		if headroom[arch] == nil {
+			headroom[arch] = corev1.ResourceList{}
+		}
+		addResourceList(headroom[arch], node.Status.Allocatable, 1)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		arch, ok := nodeArchitecture[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		addResourceList(headroom[arch], podRequests(pod), -1)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.headroom = headroom
+	c.autoscaled = autoscaled
+	klog.V(5).Infof("per-architecture capacity headroom: %+v, architectures of the scalable MachineSets: %v",
+		headroom, sets.List(autoscaled))
+	return nil
+}
+
+// provisionableArchitectures returns the architectures the scalable MachineSets can provision nodes of, empty unless
+// considerAutoscaledCapacity is set
+func (c *ArchitectureCapacityCache) provisionableArchitectures() sets.Set[string] {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.autoscaled.Clone()
+}
+
+// filterArchitectures returns the subset of the architectures whose headroom can fit the requests of the pod, or that
+// the scalable MachineSets can provision nodes of, and the subset of the architectures that have been excluded.
+// If none of the architectures can fit the pod or the headroom has not been computed yet, it returns the input set
+// unchanged, so that the result is never empty.
+func (c *ArchitectureCapacityCache) filterArchitectures(pod *corev1.Pod, architectures []string) (fitting []string, excluded []string) {
+	requests := podRequests(pod)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if len(c.headroom) == 0 {
+		return architectures, nil
+	}
+	for _, arch := range architectures {
+		if fits(c.headroom[arch], requests) || c.autoscaled.Has(arch) {
+			fitting = append(fitting, arch)
+		} else {
+			excluded = append(excluded, arch)
+		}
+	}
+	if len(fitting) == 0 {
+		return architectures, nil
+	}
+	return fitting, excluded
+}
+
+// fits returns true if all the capacityResources requested are lower than or equal to the available headroom.
+// A nil headroom means the architecture has no schedulable nodes.
+func fits(headroom corev1.ResourceList, requests corev1.ResourceList) bool {
+	if headroom == nil {
+		return false
+	}
+	for _, name := range capacityResources {
+		requested, ok := requests[name]
+		if !ok {
+			continue
+		}
+		available := headroom[name]
+		if requested.Cmp(available) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// podRequests returns the effective requests of the pod, computed as the maximum between the sum of the containers'
+// requests and the requests of each init container, plus the pod overhead.
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResourceList(requests, container.Resources.Requests, 1)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	addResourceList(requests, pod.Spec.Overhead, 1)
+	return requests
+}
+
+// addResourceList adds (sign = 1) or subtracts (sign = -1) the capacityResources in delta to/from list.
+func addResourceList(list corev1.ResourceList, delta corev1.ResourceList, sign int) {
+	if list == nil {
+		return
+	}
+	for _, name := range capacityResources {
+		quantity, ok := delta[name]
+		if !ok {
+			continue
+		}
+		current, ok := list[name]
+		if !ok {
+			current = resource.Quantity{Format: quantity.Format}
+		}
+		if sign < 0 {
+			current.Sub(quantity)
+		} else {
+			current.Add(quantity)
+		}
+		list[name] = current
+	}
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func podRequesting(cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(cpu),
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+				},
+			}},
+		},
+	}
+}
+
+func nodeWithCapacity(name, arch, cpu, memory string, unschedulable bool) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{archLabel: arch},
+		},
+		Spec: corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+func runningPodRequesting(name, nodeName, cpu, memory string, phase corev1.PodPhase) *corev1.Pod {
+	pod := podRequesting(cpu, memory)
+	pod.Name = name
+	pod.Namespace = "test"
+	pod.Spec.NodeName = nodeName
+	pod.Status.Phase = phase
+	return pod
+}
+
+func expectQuantity(list corev1.ResourceList, name corev1.ResourceName, expected string) {
+	quantity := list[name]
+	Expect(quantity.Cmp(resource.MustParse(expected))).To(BeZero(),
+		"expected %s to be %s, got %s", name, expected, quantity.String())
+}
+
+var _ = Describe("The architecture capacity cache", func() {
+	var c *ArchitectureCapacityCache
+
+	BeforeEach(func() {
+		c = &ArchitectureCapacityCache{
+			headroom: map[string]corev1.ResourceList{
+				"amd64": {
+					corev1.ResourceCPU:    resource.MustParse("16"),
+					corev1.ResourceMemory: resource.MustParse("64Gi"),
+				},
+				"arm64": {
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+		}
+	})
+
+	DescribeTable("should filter the architectures that cannot fit the pod",
+		func(pod *corev1.Pod, architectures, expectedFitting, expectedExcluded []string) {
+			fitting, excluded := c.filterArchitectures(pod, architectures)
+			Expect(fitting).To(Equal(expectedFitting))
+			Expect(excluded).To(Equal(expectedExcluded))
+		},
+		Entry("fits everywhere", podRequesting("100m", "128Mi"),
+			[]string{"amd64", "arm64"}, []string{"amd64", "arm64"}, nil),
+		Entry("drops arm64", podRequesting("2", "128Mi"),
+			[]string{"amd64", "arm64"}, []string{"amd64"}, []string{"arm64"}),
+		Entry("drops the architectures without nodes", podRequesting("100m", "128Mi"),
+			[]string{"amd64", "s390x"}, []string{"amd64"}, []string{"s390x"}),
+		Entry("never returns an empty set", podRequesting("2", "128Mi"),
+			[]string{"arm64", "s390x"}, []string{"arm64", "s390x"}, nil),
+	)
+
+	It("should not filter the architectures before the first refresh", func() {
+		c = NewArchitectureCapacityCache(nil, time.Minute)
+		fitting, excluded := c.filterArchitectures(podRequesting("2", "128Mi"), []string{"amd64", "arm64"})
+		Expect(fitting).To(Equal([]string{"amd64", "arm64"}))
+		Expect(excluded).To(BeEmpty())
+	})
+
+	It("should compute the effective requests of the pod", func() {
+		pod := podRequesting("1", "1Gi")
+		pod.Spec.InitContainers = []corev1.Container{{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			},
+		}}
+		pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+		requests := podRequests(pod)
+		expectQuantity(requests, corev1.ResourceCPU, "2")
+		expectQuantity(requests, corev1.ResourceMemory, "2Gi")
+	})
+
+	It("should sum the allocatable of the schedulable nodes minus the requests of their active pods", func() {
+		c = NewArchitectureCapacityCache(fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			nodeWithCapacity("amd64-1", "amd64", "4", "16Gi", false),
+			nodeWithCapacity("amd64-2", "amd64", "4", "16Gi", false),
+			nodeWithCapacity("amd64-cordoned", "amd64", "4", "16Gi", true),
+			nodeWithCapacity("arm64-1", "arm64", "2", "8Gi", false),
+			runningPodRequesting("a", "amd64-1", "1", "2Gi", corev1.PodRunning),
+			runningPodRequesting("b", "amd64-2", "500m", "1Gi", corev1.PodRunning),
+			runningPodRequesting("c", "amd64-cordoned", "1", "1Gi", corev1.PodRunning),
+			runningPodRequesting("d", "arm64-1", "2", "2Gi", corev1.PodSucceeded),
+			runningPodRequesting("e", "arm64-1", "1500m", "1Gi", corev1.PodRunning),
+			runningPodRequesting("pending", "", "8", "8Gi", corev1.PodPending),
+		).Build(), time.Minute)
+		Expect(c.refresh(context.Background())).To(Succeed())
+		Expect(c.headroom).To(HaveLen(2))
+		expectQuantity(c.headroom["amd64"], corev1.ResourceCPU, "6500m")
+		expectQuantity(c.headroom["amd64"], corev1.ResourceMemory, "29Gi")
+		expectQuantity(c.headroom["arm64"], corev1.ResourceCPU, "500m")
+		expectQuantity(c.headroom["arm64"], corev1.ResourceMemory, "7Gi")
+	})
+})
+
+var _ = Describe("The PodReconciler capacity refinement", func() {
+	var (
+		r           *PodReconciler
+		requirement corev1.NodeSelectorRequirement
+	)
+
+	BeforeEach(func() {
+		r = &PodReconciler{
+			CapacityCache: NewArchitectureCapacityCache(
+				fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+					nodeWithCapacity("amd64-1", "amd64", "8", "32Gi", false),
+					nodeWithCapacity("arm64-1", "arm64", "1", "4Gi", false),
+				).Build(), time.Minute),
+		}
+		Expect(r.CapacityCache.refresh(context.Background())).To(Succeed())
+		requirement = corev1.NodeSelectorRequirement{
+			Key:      archLabel,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{"amd64", "arm64"},
+		}
+	})
+
+	It("should drop the architectures that cannot fit the pod and report them", func() {
+		pod := podRequesting("2", "1Gi")
+		refineRequirementByCapacity(r.CapacityCache, nil, pod, &requirement)
+		Expect(requirement.Values).To(Equal([]string{"amd64"}))
+		Expect(pod.Annotations).To(Equal(map[string]string{
+			supportedArchitecturesAnnotation:        "amd64,arm64",
+			capacityExcludedArchitecturesAnnotation: "arm64",
+		}))
+	})
+
+	It("should keep all the architectures when the pod fits everywhere", func() {
+		pod := podRequesting("500m", "1Gi")
+		refineRequirementByCapacity(r.CapacityCache, nil, pod, &requirement)
+		Expect(requirement.Values).To(Equal([]string{"amd64", "arm64"}))
+		Expect(pod.Annotations).To(Equal(map[string]string{
+			supportedArchitecturesAnnotation: "amd64,arm64",
+		}))
+	})
+})
+
+var _ = Describe("The placement of the pods with no node of their architectures", func() {
+	var (
+		c          client.Client
+		reconciler *PodReconciler
+	)
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		reconciler = &PodReconciler{
+			Client: c,
+			Inspector: &fakeArchitectures{
+				registry: map[string][]string{"//quay.io/org/app:v1": {"arm64"}},
+			},
+		}
+	})
+
+	// reconcileOnce creates a gated pod with the image, reconciles it once and returns it with the result
+	reconcileOnce := func(image string) (*corev1.Pod, ctrl.Result, error) {
+		pod := podWithImages("pod", image)
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		result, err := reconciler.Reconcile(context.Background(),
+			ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		return getPod(c, pod), result, err
+	}
+
+	// placeOnce reconciles the gated pod once and returns it: the cluster autoscaler only considers the pods without
+	// scheduling gates, so the gate must be removed without waiting for the nodes of the architectures of the pod
+	placeOnce := func() *corev1.Pod {
+		placed, result, err := reconcileOnce("quay.io/org/app:v1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		return placed
+	}
+
+	// createPolicy creates a PodPlacementConfig object with the policy
+	createPolicy := func(policy multiarchv1alpha1.PlacementPolicy) {
+		Expect(c.Create(context.Background(), clusterPlacementPolicy("cluster", policy))).To(Succeed())
+	}
+
+	It("should ungate the pods with the node affinity when the cluster has no nodes", func() {
+		Expect(placeOnce().Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+
+	It("should ungate the pods with the node affinity when only the nodes of other architectures exist", func() {
+		Expect(c.Create(context.Background(), nodeWithCapacity("amd64-1", "amd64", "8", "32Gi", false))).To(Succeed())
+		reconciler.CapacityCache = NewArchitectureCapacityCache(c, time.Minute)
+		Expect(reconciler.CapacityCache.refresh(context.Background())).To(Succeed())
+		Expect(placeOnce().Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+
+	It("should keep the pods gated when their images cannot be inspected and the failure policy is Fail", func() {
+		createPolicy(multiarchv1alpha1.PlacementPolicy{FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail})
+		gated, _, err := reconcileOnce("quay.io/org/missing:v1")
+		Expect(err).To(HaveOccurred())
+		Expect(gated.Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(gated.Spec.Affinity).To(BeNil())
+	})
+
+	It("should keep the pods gated when none of their architectures is allowed", func() {
+		createPolicy(multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"amd64"}})
+		gated, _, err := reconcileOnce("quay.io/org/app:v1")
+		Expect(err).To(HaveOccurred())
+		Expect(gated.Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(gated.Spec.Affinity).To(BeNil())
+	})
+})
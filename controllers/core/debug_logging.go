@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+// debugLoggingKey is the context key of the debug logger
+type debugLoggingKey struct{}
+
+// debugLogger logs the decision traces of a single placement, rate limited by the limiter shared by all the placements
+type debugLogger struct {
+	logger  klog.Logger
+	limiter flowcontrol.RateLimiter
+}
+
+// DebugLogging rate limits the decision traces logged for the pods of the namespaces with debug logging enabled. The
+// traces exceeding the rate limit are dropped.
+type DebugLogging struct {
+	limiter flowcontrol.RateLimiter
+}
+
+// NewDebugLogging returns a DebugLogging logging at most qps traces per second, with bursts of at most burst traces.
+func NewDebugLogging(qps float32, burst int) *DebugLogging {
+	return &DebugLogging{limiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst)}
+}
+
+// WithLogger returns a context logging the decision traces through the logger. The functions receiving it log their
+// traces with DebugLog, regardless of the verbosity of the operator.
+func (d *DebugLogging) WithLogger(ctx context.Context, logger klog.Logger) context.Context {
+	return context.WithValue(ctx, debugLoggingKey{}, &debugLogger{logger: logger, limiter: d.limiter})
+}
+
+// DebugLog logs the decision trace if the context was returned by DebugLogging.WithLogger and the rate limit is not
+// exceeded. It never logs otherwise: the callers can trace details, e.g., the manifests of the images, that are too
+// verbose for the cluster-wide logs. The secrets must never be traced.
+func DebugLog(ctx context.Context, format string, args ...interface{}) {
+	d, ok := ctx.Value(debugLoggingKey{}).(*debugLogger)
+	if !ok || !d.limiter.TryAccept() {
+		return
+	}
+	d.logger.Info(fmt.Sprintf(format, args...))
+}
+
+// DebugLogEnabled returns true if the decision traces of the context are logged, so that the callers can skip
+// computing the expensive ones otherwise.
+func DebugLogEnabled(ctx context.Context) bool {
+	_, ok := ctx.Value(debugLoggingKey{}).(*debugLogger)
+	return ok
+}
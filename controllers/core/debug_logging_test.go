@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+// logRecorder records the lines logged through its logger
+type logRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logRecorder) logger() klog.Logger {
+	return funcr.New(func(prefix, args string) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.lines = append(l.lines, args)
+	}, funcr.Options{})
+}
+
+var _ = Describe("The debug logging", func() {
+	var recorder *logRecorder
+
+	BeforeEach(func() {
+		recorder = &logRecorder{}
+	})
+
+	It("should only log the traces of the contexts with the debug logger", func() {
+		ctx := NewDebugLogging(10, 10).WithLogger(context.Background(), recorder.logger().WithValues("pod", "ns/pod"))
+		Expect(DebugLogEnabled(ctx)).To(BeTrue())
+		DebugLog(ctx, "The image %s supports the architectures %v", "quay.io/org/app", []string{"amd64"})
+		Expect(DebugLogEnabled(context.Background())).To(BeFalse())
+		DebugLog(context.Background(), "not traced")
+		Expect(recorder.lines).To(ConsistOf(And(
+			ContainSubstring(`"msg"="The image quay.io/org/app supports the architectures [amd64]"`),
+			ContainSubstring(`"pod"="ns/pod"`))))
+	})
+
+	It("should drop the traces exceeding the rate limit", func() {
+		debugLogging := NewDebugLogging(0.001, 3)
+		// the limit is shared by all the contexts
+		first := debugLogging.WithLogger(context.Background(), recorder.logger())
+		second := debugLogging.WithLogger(context.Background(), recorder.logger())
+		for i := 0; i < 5; i++ {
+			DebugLog(first, "trace %d", i)
+			DebugLog(second, "trace %d", i)
+		}
+		Expect(recorder.lines).To(HaveLen(3))
+	})
+})
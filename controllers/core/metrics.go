@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const metricsNamespace = "multiarch"
+
+var (
+	informerHandlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_handler_errors_total",
+		Help:      "The number of errors returned by the event handlers of the single object watchers",
+	}, []string{"kind"})
+	informerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_errors_total",
+		Help:      "The number of failures to get or watch the objects of the single object watchers",
+	}, []string{"kind", "operation"})
+	informerLastEventTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_last_event_timestamp_seconds",
+		Help:      "The Unix time of the last event received by the single object watchers, polling included",
+	}, []string{"kind", "object"})
+)
+
+func init() {
+	version.MetricsRegisterer().MustRegister(informerHandlerErrors, informerErrors, informerLastEventTimestamp)
+}
+
+// instrumentHandler wraps the handler of the watcher of the object namespace/name of the given kind, so that the time
+// of the last event is exported by informerLastEventTimestamp and the errors it returns are logged and counted by
+// informerHandlerErrors.
+func instrumentHandler[T client.Object](kind, namespace, name string,
+	handler func(watch.EventType, T) error) func(watch.EventType, T) {
+	object := objectKey(namespace, name)
+	lastEvent := informerLastEventTimestamp.WithLabelValues(kind, object)
+	handlerErrors := informerHandlerErrors.WithLabelValues(kind)
+	return func(eventType watch.EventType, obj T) {
+		lastEvent.SetToCurrentTime()
+		if err := handler(eventType, obj); err != nil {
+			handlerErrors.Inc()
+			klog.Warningf("Error handling the %s event of the %s %s: %v", eventType, kind, object, err)
+		}
+	}
+}
+
+func objectKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
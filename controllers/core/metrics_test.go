@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func metricValue(metric interface{ Write(*dto.Metric) error }) float64 {
+	m := &dto.Metric{}
+	Expect(metric.Write(m)).To(Succeed())
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+var _ = Describe("The instrumented event handlers", func() {
+	It("should count the errors of the handler by kind", func() {
+		errs := informerHandlerErrors.WithLabelValues("Secret")
+		before := metricValue(errs)
+		handle := instrumentHandler("Secret", "test", "failing",
+			func(watch.EventType, *corev1.Secret) error {
+				return errors.New("invalid secret")
+			})
+		handle(watch.Modified, &corev1.Secret{})
+		handle(watch.Added, &corev1.Secret{})
+		Expect(metricValue(errs)).To(Equal(before + 2))
+	})
+
+	It("should export the time of the last event of each watcher", func() {
+		var calls int
+		handle := instrumentHandler("Secret", "test", "working",
+			func(watch.EventType, *corev1.Secret) error {
+				calls++
+				return nil
+			})
+		errs := informerHandlerErrors.WithLabelValues("Secret")
+		before := metricValue(errs)
+		start := time.Now().Unix()
+		handle(watch.Modified, &corev1.Secret{})
+		Expect(calls).To(Equal(1))
+		Expect(metricValue(informerLastEventTimestamp.WithLabelValues("Secret", "test/working"))).To(
+			BeNumerically(">=", start))
+		Expect(metricValue(errs)).To(Equal(before))
+	})
+
+	It("should not prefix the cluster-scoped objects with the namespace", func() {
+		handle := instrumentHandler("ConfigMap", "", "cluster",
+			func(watch.EventType, *corev1.ConfigMap) error { return nil })
+		handle(watch.Modified, &corev1.ConfigMap{})
+		Expect(metricValue(informerLastEventTimestamp.WithLabelValues("ConfigMap", "cluster"))).NotTo(BeZero())
+	})
+})
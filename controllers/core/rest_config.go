@@ -0,0 +1,50 @@
+package core
+
+import (
+	"sync"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const (
+	// DefaultKubeAPIQPS and DefaultKubeAPIBurst are the default limits of the requests of the clients of the operator
+	// to the API server, the ones of controller-runtime
+	DefaultKubeAPIQPS   = 20
+	DefaultKubeAPIBurst = 30
+)
+
+var (
+	restConfig      *rest.Config
+	restConfigMutex sync.Mutex
+)
+
+// ConfigureRESTConfig sets the limits of the requests to the API server of the clients built from the configuration.
+// The non-positive values keep the ones of the configuration.
+func ConfigureRESTConfig(cfg *rest.Config, qps float64, burst int) {
+	if qps > 0 {
+		cfg.QPS = float32(qps)
+	}
+	if burst > 0 {
+		cfg.Burst = burst
+	}
+}
+
+// SetRESTConfig sets the configuration of the clients of the watchers created by NewSingleObjectEventHandler, so that
+// they share the configuration of the manager, e.g., its limits and its user agent. It is expected to be called before
+// the watchers are created: they read the default configuration when it is not set.
+func SetRESTConfig(cfg *rest.Config) {
+	restConfigMutex.Lock()
+	defer restConfigMutex.Unlock()
+	restConfig = cfg
+}
+
+// RESTConfig returns the configuration set by SetRESTConfig, or the default one if it is not set
+func RESTConfig() *rest.Config {
+	restConfigMutex.Lock()
+	defer restConfigMutex.Unlock()
+	if restConfig == nil {
+		return config.GetConfigOrDie()
+	}
+	return restConfig
+}
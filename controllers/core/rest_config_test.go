@@ -0,0 +1,42 @@
+package core
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/client-go/discovery"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("The configuration of the clients", func() {
+	// qpsOf returns the QPS of the rate limiter of the transport of the client
+	qpsOf := func(c rest.Interface) float32 {
+		restClient, ok := c.(*rest.RESTClient)
+		Expect(ok).To(BeTrue())
+		return restClient.GetRateLimiter().QPS()
+	}
+
+	It("should limit the requests of the clients built from the shared configuration", func() {
+		cfg := &rest.Config{Host: "https://127.0.0.1:6443"}
+		ConfigureRESTConfig(cfg, 42, 84)
+		SetRESTConfig(cfg)
+		DeferCleanup(SetRESTConfig, (*rest.Config)(nil))
+		Expect(RESTConfig()).To(BeIdenticalTo(cfg))
+		Expect(RESTConfig().Burst).To(Equal(84))
+
+		coreClient, err := corev1client.NewForConfig(RESTConfig())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(qpsOf(coreClient.RESTClient())).To(Equal(float32(42)))
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(RESTConfig())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(qpsOf(discoveryClient.RESTClient())).To(Equal(float32(42)))
+	})
+
+	It("should keep the limits of the configuration for the non-positive values", func() {
+		cfg := &rest.Config{QPS: 5, Burst: 10}
+		ConfigureRESTConfig(cfg, 0, -1)
+		Expect(cfg.QPS).To(Equal(float32(5)))
+		Expect(cfg.Burst).To(Equal(10))
+	})
+})
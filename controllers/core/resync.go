@@ -0,0 +1,40 @@
+package core
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultResyncPeriod is the default period of the resyncs of the single object watchers, see
+	// NewSingleObjectEventHandler
+	DefaultResyncPeriod = time.Hour
+	// DefaultResyncJitter is the default fraction of the resync period the period of each watcher is spread by
+	DefaultResyncJitter = 0.1
+)
+
+var (
+	jitterRand      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandMutex sync.Mutex
+)
+
+// JitteredPeriod returns the period spread by a random amount of up to jitter times the period, earlier or later,
+// e.g., between 54 and 66 minutes for 1 hour and a jitter of 0.1, so that the watchers created together do not resync
+// at the same time, in the same replica nor across replicas. The period is returned unchanged when it or jitter is not
+// positive; jitter is capped at 1.
+func JitteredPeriod(period time.Duration, jitter float64) time.Duration {
+	if period <= 0 || jitter <= 0 {
+		return period
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	jitterRandMutex.Lock()
+	factor := 1 + jitter*(2*jitterRand.Float64()-1)
+	jitterRandMutex.Unlock()
+	if jittered := time.Duration(float64(period) * factor); jittered > 0 {
+		return jittered
+	}
+	return period
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The jittered resync periods", func() {
+	It("should stay within the jitter of the period", func() {
+		for i := 0; i < 100; i++ {
+			Expect(JitteredPeriod(time.Hour, 0.1)).To(And(
+				BeNumerically(">=", 54*time.Minute), BeNumerically("<=", 66*time.Minute)))
+		}
+	})
+
+	It("should return the period unchanged without jitter or without resyncs", func() {
+		Expect(JitteredPeriod(time.Hour, 0)).To(Equal(time.Hour))
+		Expect(JitteredPeriod(0, 0.1)).To(BeZero())
+	})
+
+	It("should cap the jitter at the period", func() {
+		for i := 0; i < 100; i++ {
+			Expect(JitteredPeriod(time.Minute, 5)).To(And(BeNumerically(">", 0), BeNumerically("<=", 2*time.Minute)))
+		}
+	})
+})
@@ -7,7 +7,6 @@ import (
 	"k8s.io/klog/v2"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"time"
 )
 
@@ -18,18 +17,19 @@ import (
 // the list of T objects to watch. The function also takes the name of the object the handler should subscribe to
 // and the namespace to watch (use an empty string for the namespace if the resource is cluster-scoped).
 // handler is a function that takes the event type and the object that was changed. Event types are defined in watch.go
-// and can be Added, Modified, Deleted, Bookmark and Error (not handled by handler).
+// and can be Added, Modified, Deleted, Bookmark and Error (not handled by handler). The errors it returns are logged
+// and counted in the informer_handler_errors_total metric.
 // errorHandler is an optional (nullable pointer to a) function executed when the event type is Error.
 func NewSingleObjectEventHandler[T client.Object, L client.ObjectList](ctx context.Context,
 	name string, namespace string, pollingInterval time.Duration,
-	handler func(watch.EventType, T), errorHandler *func(*metav1.Status)) error {
+	handler func(watch.EventType, T) error, errorHandler *func(*metav1.Status)) error {
 
-	cfg := config.GetConfigOrDie()
-
-	cli, err := client.NewWithWatch(cfg, client.Options{})
+	cli, err := client.NewWithWatch(RESTConfig(), client.Options{})
 	if err != nil {
 		return err
 	}
+	kind := reflect.TypeOf((*T)(nil)).Elem().Elem().Name()
+	handle := instrumentHandler(kind, namespace, name, handler)
 	list := reflect.New(reflect.TypeOf((*L)(nil)).Elem().Elem()).Interface().(L)
 	lop := &client.ListOptions{}
 	if namespace != "" {
@@ -37,20 +37,30 @@ func NewSingleObjectEventHandler[T client.Object, L client.ObjectList](ctx conte
 	}
 	w, err := cli.Watch(ctx, list, lop)
 	if err != nil {
+		informerErrors.WithLabelValues(kind, "watch").Inc()
 		return err
 	}
 
 	go func() {
+		defer w.Stop()
 		for {
 			select {
-			case e := <-w.ResultChan():
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.ResultChan():
+				if !ok {
+					// The watch has been closed: only the polling, if enabled, keeps the handler up to date
+					klog.Warningf("The watch for %s/%s has been closed", namespace, name)
+					return
+				}
 				switch eventType := e.Type; eventType {
 				case watch.Added, watch.Modified, watch.Deleted, watch.Bookmark:
 					if e.Object != nil && e.Object.(metav1.Object).GetName() != name {
 						continue
 					}
-					handler(eventType, e.Object.DeepCopyObject().(T))
+					handle(eventType, e.Object.DeepCopyObject().(T))
 				case watch.Error:
+					informerErrors.WithLabelValues(kind, "watch").Inc()
 					if e.Object != nil && errorHandler != nil {
 						obj := e.Object.(*metav1.Status)
 						(*errorHandler)(obj)
@@ -69,10 +79,11 @@ func NewSingleObjectEventHandler[T client.Object, L client.ObjectList](ctx conte
 			Name:      name,
 		}, obj)
 		if err != nil {
+			informerErrors.WithLabelValues(kind, "get").Inc()
 			klog.Errorf("Error getting object %s/%s: %v", namespace, name, err)
 			return err
 		}
-		handler(watch.Modified, obj)
+		handle(watch.Modified, obj)
 		return nil
 	}
 	// getAndHandle is called at the end of this function to force a first synchronous get and make the
@@ -83,10 +94,13 @@ func NewSingleObjectEventHandler[T client.Object, L client.ObjectList](ctx conte
 		return getAndHandle()
 	}
 	// Use polling to periodically get the obj and execute the handler to guarantee robustness against the loss of watch events.
-	ticker := time.NewTicker(pollingInterval * time.Second)
+	ticker := time.NewTicker(pollingInterval)
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case <-ticker.C:
 				_ = getAndHandle()
 			}
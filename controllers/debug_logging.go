@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"multiarch-operator/controllers/core"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// debugContext returns the context of the placement of the pod. When debugLogging is set and the namespace of the pod
+// has the debugAnnotation annotation of the instance set to "true", the decision traces are logged through the logger
+// of ctx, with the pod as a value. The namespace is read through c, expected to be the cached client: the annotation is
+// checked at every placement without requests to the API server.
+func debugContext(ctx context.Context, c client.Reader, debugLogging *core.DebugLogging, instance *Instance,
+	pod *corev1.Pod) context.Context {
+	if debugLogging == nil {
+		return ctx
+	}
+	annotation := instance.annotation(debugAnnotation)
+	namespace := &corev1.Namespace{}
+	getCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	if err := c.Get(getCtx, client.ObjectKey{Name: pod.Namespace}, namespace); err != nil {
+		klog.V(4).Infof("Unable to get the namespace %s to check the %s annotation: %v", pod.Namespace,
+			annotation, err)
+		return ctx
+	}
+	if namespace.Annotations[annotation] != "true" {
+		return ctx
+	}
+	name := pod.Name
+	if name == "" {
+		// the pods are not named yet at admission when they have a generateName
+		name = pod.GenerateName
+	}
+	return debugLogging.WithLogger(ctx, log.FromContext(ctx).WithValues("pod", klog.KRef(pod.Namespace, name)))
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"multiarch-operator/controllers/core"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The namespace debug logging", func() {
+	var (
+		mu         sync.Mutex
+		lines      []string
+		ctx        context.Context
+		namespace  *corev1.Namespace
+		reconciler *PodReconciler
+	)
+
+	BeforeEach(func() {
+		lines = nil
+		ctx = log.IntoContext(context.Background(), funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, args)
+		}, funcr.Options{}))
+		namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{debugAnnotation: "true"},
+		}}
+		reconciler = &PodReconciler{
+			Inspector: &fakeArchitectures{
+				registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+			},
+			DebugLogging: core.NewDebugLogging(100, 100),
+		}
+	})
+
+	reconcile := func() {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		reconciler.Client = fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithObjects(namespace, pod).Build()
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should trace the decisions for the pods of the annotated namespaces", func() {
+		reconcile()
+		Expect(lines).To(ContainElement(And(
+			ContainSubstring(`The image //quay.io/org/app:v1 with digest`),
+			ContainSubstring(`supports the architectures [amd64 arm64]`),
+			ContainSubstring(`"pod"={"name":"pod","namespace":"test"}`))))
+		Expect(lines).To(ContainElement(ContainSubstring("Placing the pod on the architectures [amd64 arm64]")))
+	})
+
+	It("should not trace the decisions for the pods of the other namespaces", func() {
+		namespace.Annotations[debugAnnotation] = "false"
+		reconcile()
+		Expect(lines).To(BeEmpty())
+	})
+
+	It("should not trace the decisions when the debug logging is disabled", func() {
+		reconciler.DebugLogging = nil
+		reconcile()
+		Expect(lines).To(BeEmpty())
+	})
+
+	It("should trace the decisions of the webhook", func() {
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{
+			Client:       fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace).Build(),
+			DebugLogging: core.NewDebugLogging(100, 100),
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(lines).To(ContainElement(ContainSubstring("Gating the pod until the reconciler places it")))
+	})
+})
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultDecisionCacheTTL is the default time the placement decisions are cached for the pods of the same template
+	DefaultDecisionCacheTTL = time.Minute
+	// decisionCacheSize bounds the number of pod templates whose decision is cached
+	decisionCacheSize = 1024
+)
+
+// DecisionCache caches the placement decisions computed for the gated pods by pod template, so that the next pods of
+// the same controller and template revision, e.g., the replicas of a ReplicaSet created one after the other, are placed
+// without enumerating and inspecting their images again. The decisions are keyed by the namespace, the controller and
+// the template revision label of the pods, and by the generations of the placement settings: any change of a
+// PodPlacementConfig or of the PodPlacementPolicy objects of the namespace invalidates the decisions computed before.
+// The decisions also expire after a TTL, as they depend on the state of the cluster too, e.g., on its capacity, and at
+// most decisionCacheSize are cached, the least recently used ones being evicted first.
+// The pods without a controller or a template revision label, and the pods placed at admission, are never cached.
+// The nil DecisionCache caches nothing.
+type DecisionCache struct {
+	decisions *cache.LRUExpireCache
+	ttl       time.Duration
+}
+
+// NewDecisionCache returns a DecisionCache caching the decisions for ttl, unless the PodPlacementConfig objects tune it
+func NewDecisionCache(ttl time.Duration) *DecisionCache {
+	return newDecisionCache(ttl, clock.RealClock{})
+}
+
+func newDecisionCache(ttl time.Duration, c clock.Clock) *DecisionCache {
+	return &DecisionCache{decisions: cache.NewLRUExpireCacheWithClock(decisionCacheSize, c), ttl: ttl}
+}
+
+// decisionCacheKey identifies the pods of the same template and the placement settings their decision was computed
+// with
+type decisionCacheKey struct {
+	namespace    string
+	controller   types.UID
+	templateHash string
+	// generations are the generations of the placement settings, see placementSettingsGenerations
+	generations string
+}
+
+// cachedDecision is a decision and the inputs of the pod it was computed for
+type cachedDecision struct {
+	decision placementDecision
+	inputs   decisionInputs
+}
+
+// get returns the decision cached for the key, if any and computed for the same inputs
+func (d *DecisionCache) get(key decisionCacheKey, inputs decisionInputs) (placementDecision, bool) {
+	if d == nil {
+		return placementDecision{}, false
+	}
+	value, ok := d.decisions.Get(key)
+	// the pods of the same template can still differ, e.g., in the images set by a mutating webhook
+	if !ok || !equality.Semantic.DeepEqual(value.(cachedDecision).inputs, inputs) {
+		decisionCacheLookups.WithLabelValues("miss").Inc()
+		return placementDecision{}, false
+	}
+	decisionCacheLookups.WithLabelValues("hit").Inc()
+	return value.(cachedDecision).decision, true
+}
+
+// add caches the decision computed for the pod with the inputs. The decisions of the pods whose images cannot be
+// inspected are not cached, so that the next pods try the inspection again.
+func (d *DecisionCache) add(key decisionCacheKey, inputs decisionInputs, decision placementDecision) {
+	if d == nil {
+		return
+	}
+	switch decision.reason {
+	case reasons.GateRemovedByPolicy, reasons.DefaultedArchitectures, reasons.PlacedAtAdmission:
+		return
+	}
+	ttl := tunedDuration(d.ttl,
+		func(t *multiarchv1alpha1.TuningSettings) *metav1.Duration { return t.DecisionCacheTTL })
+	d.decisions.Add(key, cachedDecision{decision: decision, inputs: inputs}, ttl)
+}
+
+// decideCached returns the decision cached for the pods of the same template as pod, if any, or computes it with
+// decide and caches it. cached is true when the decision comes from the cache: no image of the pod was inspected.
+func (r *PodReconciler) decideCached(ctx context.Context, pod *corev1.Pod,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (decision placementDecision, cached bool, err error) {
+	key, ok := r.decisionCacheKey(ctx, pod, podPlacementConfigs)
+	if !ok {
+		decision, err = r.decide(ctx, pod)
+		return decision, false, err
+	}
+	inputs := r.podDecisionInputs(pod)
+	if decision, ok := r.DecisionCache.get(key, inputs); ok {
+		klog.V(4).Infof("Using the decision cached for the template %s of pod %s/%s", key.templateHash,
+			pod.Namespace, pod.Name)
+		core.DebugLog(ctx, "Using the decision cached for the pods of the template %s: skipping the inspection",
+			key.templateHash)
+		return decision, true, nil
+	}
+	decision, err = r.decide(ctx, pod)
+	if err == nil && ctx.Err() == nil {
+		r.DecisionCache.add(key, inputs, decision)
+	}
+	return decision, false, err
+}
+
+// decisionCacheKey returns the key of the decision of the pod in the DecisionCache. ok is false when the decision of
+// the pod cannot be cached: the DecisionCache is not set, the pod was placed at admission or has no controller or
+// template revision label, or the placement settings of its namespace cannot be read.
+func (r *PodReconciler) decisionCacheKey(ctx context.Context, pod *corev1.Pod,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (key decisionCacheKey, ok bool) {
+	if r.DecisionCache == nil || r.Instance.hasPlacementDecision(pod) {
+		return decisionCacheKey{}, false
+	}
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil {
+		return decisionCacheKey{}, false
+	}
+	for _, label := range podTemplateHashLabels {
+		hash, found := pod.Labels[label]
+		if !found {
+			continue
+		}
+		generations, err := placementSettingsGenerations(ctx, r.Client, pod.Namespace, podPlacementConfigs)
+		if err != nil {
+			klog.V(3).Infof("Not caching the decision of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			return decisionCacheKey{}, false
+		}
+		return decisionCacheKey{
+			namespace:    pod.Namespace,
+			controller:   controller.UID,
+			templateHash: label + "=" + hash,
+			generations:  generations,
+		}, true
+	}
+	return decisionCacheKey{}, false
+}
+
+// placementSettingsGenerations returns the identity of the placement settings of the pods of the namespace: the names,
+// UIDs and generations of the PodPlacementConfig objects and of the PodPlacementPolicy objects of the namespace.
+// It changes with any change of their spec, including their deletion and recreation.
+func placementSettingsGenerations(ctx context.Context, c client.Reader, namespace string,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (string, error) {
+	policies := &multiarchv1alpha1.PodPlacementPolicyList{}
+	if err := c.List(ctx, policies, client.InNamespace(namespace)); err != nil && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+	var generations []string
+	for i := range podPlacementConfigs {
+		generations = append(generations, objectGeneration(&podPlacementConfigs[i]))
+	}
+	for i := range policies.Items {
+		generations = append(generations, objectGeneration(&policies.Items[i]))
+	}
+	// the objects are not listed in a stable order
+	sort.Strings(generations)
+	return strings.Join(generations, ","), nil
+}
+
+// objectGeneration returns the kind, name, UID and generation of the object
+func objectGeneration(obj client.Object) string {
+	return fmt.Sprintf("%T/%s/%s/%d", obj, obj.GetName(), obj.GetUID(), obj.GetGeneration())
+}
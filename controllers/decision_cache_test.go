@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("The decision cache", func() {
+	var (
+		c             client.Client
+		architectures *fakeArchitectures
+		r             *PodReconciler
+	)
+
+	// replica returns a gated pod of the ReplicaSet whose images are inspected
+	replica := func(name, image string) *corev1.Pod {
+		pod := gatedReplica(name, "rs-uid", "abc", image)
+		pod.Annotations = nil
+		return pod
+	}
+
+	create := func(pods ...*corev1.Pod) []*corev1.Pod {
+		for _, pod := range pods {
+			Expect(c.Create(context.Background(), pod)).To(Succeed())
+		}
+		return pods
+	}
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		architectures = &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}
+		r = &PodReconciler{Client: c, Inspector: architectures, DecisionCache: NewDecisionCache(time.Minute)}
+	})
+
+	It("should inspect the images once for the pods of the same template", func() {
+		var pods []*corev1.Pod
+		for i := 0; i < 5; i++ {
+			pods = append(pods, replica(fmt.Sprintf("app-%d", i), "quay.io/org/app:v1"))
+		}
+		reconcilePods(r, create(pods...)...)
+		Expect(architectures.inspections).To(Equal(1))
+		for _, pod := range pods {
+			placed := getPod(c, pod)
+			Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+			Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+				To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+			Expect(placed.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.ArchitectureConstrained))
+		}
+	})
+
+	It("should inspect the images of the pods without a template revision", func() {
+		first, second := podWithImages("first", "quay.io/org/app:v1"), podWithImages("second", "quay.io/org/app:v1")
+		for _, pod := range []*corev1.Pod{first, second} {
+			pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		}
+		reconcilePods(r, create(first, second)...)
+		Expect(architectures.inspections).To(Equal(2))
+	})
+
+	It("should inspect the images again when the placement settings change", func() {
+		reconcilePods(r, create(replica("app-1", "quay.io/org/app:v1"))...)
+		Expect(c.Create(context.Background(), namespacePlacementPolicy("policy", time.Now(),
+			multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"arm64"}}))).To(Succeed())
+		second := replica("app-2", "quay.io/org/app:v1")
+		reconcilePods(r, create(second)...)
+		Expect(architectures.inspections).To(Equal(2))
+		Expect(getPod(c, second).Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+
+	It("should not cache the decisions of the pods whose images cannot be inspected", func() {
+		reconcilePods(r, create(replica("app-1", "quay.io/org/missing:v1"), replica("app-2",
+			"quay.io/org/missing:v1"))...)
+		Expect(architectures.inspections).To(Equal(2))
+	})
+
+	It("should not reuse the decision for the pods of the same template with other images", func() {
+		reconcilePods(r, create(replica("app-1", "quay.io/org/app:v1"), replica("app-2", "quay.io/org/other:v1"))...)
+		Expect(architectures.inspections).To(Equal(2))
+	})
+})
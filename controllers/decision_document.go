@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/decision"
+)
+
+// legacyDecisionAnnotations are the annotations of the placement the decisionAnnotation annotation records too. They
+// are left out of the pods whose decision is recorded when the legacy annotations are omitted, see
+// PodReconciler.OmitLegacyAnnotations.
+var legacyDecisionAnnotations = []string{supportedArchitecturesAnnotation, capacityExcludedArchitecturesAnnotation,
+	inspectedDigestsAnnotation, excludedContainersAnnotation, placementReasonAnnotation, mutatedByAnnotation}
+
+// document returns the decision document of the decision made at now by mutatedBy, e.g., the version of the operator.
+// ok is false for the decisions only removing the scheduling gate of the pods placed at admission: the document set
+// by the webhook is kept.
+func (d placementDecision) document(instance *Instance, now time.Time, mutatedBy string) (decision.Decision, bool) {
+	if d.reason == "" || len(d.annotations) == 0 {
+		return decision.Decision{}, false
+	}
+	document := decision.Decision{
+		SupportedArchitectures: splitArchitectures(d.annotations[instance.annotation(
+			supportedArchitecturesAnnotation)]),
+		CapacityExcludedArchitectures: splitArchitectures(d.annotations[instance.annotation(
+			capacityExcludedArchitecturesAnnotation)]),
+		Reason:    d.reason,
+		MutatedBy: mutatedBy,
+		Timestamp: now,
+	}
+	if excluded := d.annotations[instance.annotation(excludedContainersAnnotation)]; excluded != "" {
+		document.ExcludedContainers = strings.Split(excluded, ",")
+	}
+	if d.requirement != nil {
+		document.Architectures = d.requirement.Values
+	}
+	for reference, digest := range d.inspectedDigests {
+		document.Images = append(document.Images, decision.Image{
+			Reference: strings.TrimPrefix(reference, "//"),
+			Digest:    digest,
+		})
+	}
+	sort.Slice(document.Images, func(i, j int) bool {
+		return document.Images[i].Reference < document.Images[j].Reference
+	})
+	return document, true
+}
+
+// recordDecision sets the decisionAnnotation annotation of the instance on the pod to the document, whose generation
+// follows the one of the document of the pod as read as original. When omitLegacy is true, the
+// legacyDecisionAnnotations changed since are reverted: the pod only records the decision in the document.
+func (i *Instance) recordDecision(pod, original *corev1.Pod, document decision.Decision, omitLegacy bool) {
+	document.Generation = 1
+	if previous, ok := i.decision(original); ok {
+		document.Generation = previous.Generation + 1
+	}
+	value, err := decision.Format(document)
+	if err != nil {
+		klog.Warningf("Unable to record the decision of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	setPodAnnotation(pod, i.annotation(decisionAnnotation), value)
+	if !omitLegacy {
+		return
+	}
+	for _, key := range legacyDecisionAnnotations {
+		key = i.annotation(key)
+		if value, ok := original.Annotations[key]; ok {
+			pod.Annotations[key] = value
+		} else {
+			delete(pod.Annotations, key)
+		}
+	}
+}
+
+// decision returns the decision document of the instance recorded on the pod. ok is false when the pod has none, or
+// an invalid one, e.g., of a later version of the operator.
+func (i *Instance) decision(pod *corev1.Pod) (document *decision.Decision, ok bool) {
+	value, found := pod.Annotations[i.annotation(decisionAnnotation)]
+	if !found {
+		return nil, false
+	}
+	document, err := decision.Parse(value)
+	if err != nil {
+		klog.V(4).Infof("Ignoring the decision of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil, false
+	}
+	return document, true
+}
+
+// supportedArchitectures returns the architectures supported by the images of the pod, as recorded when the placement
+// considered the capacity of the architectures, by its decision document or by the supportedArchitecturesAnnotation
+// annotation of the pods placed before the document was recorded
+func (i *Instance) supportedArchitectures(pod *corev1.Pod) []string {
+	if document, ok := i.decision(pod); ok {
+		return document.SupportedArchitectures
+	}
+	return splitArchitectures(pod.Annotations[i.annotation(supportedArchitecturesAnnotation)])
+}
+
+// capacityExcludedArchitectures returns the architectures excluded from the node affinity of the pod for their
+// capacity, as supportedArchitectures
+func (i *Instance) capacityExcludedArchitectures(pod *corev1.Pod) []string {
+	if document, ok := i.decision(pod); ok {
+		return document.CapacityExcludedArchitectures
+	}
+	return splitArchitectures(pod.Annotations[i.annotation(capacityExcludedArchitecturesAnnotation)])
+}
+
+// placementReason returns the reason of the placement of the pod, as supportedArchitectures
+func (i *Instance) placementReason(pod *corev1.Pod) string {
+	if document, ok := i.decision(pod); ok {
+		return document.Reason
+	}
+	return pod.Annotations[i.annotation(placementReasonAnnotation)]
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"multiarch-operator/pkg/decision"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The decision documents", func() {
+	var (
+		c          client.Client
+		reconciler *PodReconciler
+		digest     = "sha256:" + strings.Repeat("a", 64)
+	)
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		reconciler = &PodReconciler{Client: c, MutatedBy: "v1.2.3", Inspector: &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+			digests:  map[string]string{"//quay.io/org/app:v1": digest},
+		}}
+	})
+
+	// place creates the gated pod, reconciles it and returns the document of its decision
+	place := func(pod *corev1.Pod) (*corev1.Pod, *decision.Decision) {
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		reconcilePods(reconciler, pod)
+		placed := getPod(c, pod)
+		document, err := decision.Parse(placed.Annotations[decisionAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+		return placed, document
+	}
+
+	It("should record the decisions of the reconciler", func() {
+		before := time.Now().Add(-time.Second)
+		placed, document := place(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(document.V).To(Equal(decision.Version))
+		Expect(document.Architectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(document.Images).To(Equal([]decision.Image{{Reference: "quay.io/org/app:v1", Digest: digest}}))
+		Expect(document.Reason).To(Equal(reasons.ArchitectureConstrained))
+		Expect(document.MutatedBy).To(Equal("v1.2.3"))
+		Expect(document.Generation).To(BeEquivalentTo(1))
+		Expect(document.Timestamp).To(BeTemporally(">=", before))
+		// the legacy annotations are still set
+		Expect(placed.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.ArchitectureConstrained))
+		Expect(placed.Annotations).To(HaveKeyWithValue(inspectedDigestsAnnotation, "quay.io/org/app:v1@"+digest))
+	})
+
+	It("should timestamp the decisions of the reconciler with its clock", func() {
+		now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+		reconciler.clock = clocktesting.NewFakePassiveClock(now)
+		_, document := place(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(document.Timestamp).To(BeTemporally("==", now))
+	})
+
+	It("should record the decisions removing the scheduling gate only", func() {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{excludePodPlacementAnnotation: "true"}
+		placed, document := place(pod)
+		Expect(placed.Spec.Affinity).To(BeNil())
+		Expect(document.Architectures).To(BeEmpty())
+		Expect(document.Reason).To(Equal(reasons.OptedOut))
+	})
+
+	It("should only record the decisions by the document when the legacy annotations are omitted", func() {
+		reconciler.OmitLegacyAnnotations = true
+		placed, document := place(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(placed.Annotations).To(HaveLen(1))
+		Expect(document.Reason).To(Equal(reasons.ArchitectureConstrained))
+		Expect(document.Images).To(HaveLen(1))
+	})
+
+	It("should only record the decisions of the pods placed at admission by the document of the webhook", func() {
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c, OmitLegacyAnnotations: true,
+			ArchitecturesCache: &fakeArchitectures{cached: map[string][]string{
+				"//quay.io/org/app:v1": {"amd64", "arm64"}}}}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveLen(2))
+		Expect(annotations).To(HaveKeyWithValue(placementDecisionAnnotation, placedByWebhook))
+		document, err := decision.Parse(annotations[decisionAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(document.Architectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(document.Reason).To(Equal(reasons.ArchitectureConstrained))
+	})
+
+	It("should count the decisions of the pods", func() {
+		var instance *Instance
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		original := pod.DeepCopy()
+		instance.recordDecision(pod, original, decision.Decision{Reason: reasons.InspectionFailed,
+			Timestamp: time.Now()}, false)
+		decided := pod.DeepCopy()
+		instance.recordDecision(pod, decided, decision.Decision{Reason: reasons.ArchitectureConstrained,
+			Timestamp: time.Now()}, false)
+		document, ok := instance.decision(pod)
+		Expect(ok).To(BeTrue())
+		Expect(document.Reason).To(Equal(reasons.ArchitectureConstrained))
+		Expect(document.Generation).To(BeEquivalentTo(2))
+	})
+
+	DescribeTable("should be read before the legacy annotations", func(annotations map[string]string,
+		supported, excluded []string, reason string) {
+		var instance *Instance
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = annotations
+		Expect(instance.supportedArchitectures(pod)).To(Equal(supported))
+		Expect(instance.capacityExcludedArchitectures(pod)).To(Equal(excluded))
+		Expect(instance.placementReason(pod)).To(Equal(reason))
+	},
+		Entry("the document", map[string]string{
+			decisionAnnotation: `{"v":1,"architectures":["amd64"],"supportedArchitectures":["amd64","arm64"],` +
+				`"capacityExcludedArchitectures":["arm64"],"reason":"ArchitectureConstrained","generation":1,` +
+				`"timestamp":"2023-06-01T10:00:00Z"}`,
+			supportedArchitecturesAnnotation: "s390x",
+			placementReasonAnnotation:        reasons.OptedOut,
+		}, []string{"amd64", "arm64"}, []string{"arm64"}, reasons.ArchitectureConstrained),
+		Entry("the legacy annotations of the pods placed before the document was recorded", map[string]string{
+			supportedArchitecturesAnnotation:        "amd64,arm64",
+			capacityExcludedArchitecturesAnnotation: "arm64",
+			placementReasonAnnotation:               reasons.ArchitectureConstrained,
+		}, []string{"amd64", "arm64"}, []string{"arm64"}, reasons.ArchitectureConstrained),
+		Entry("the legacy annotations of the pods with a document of another version", map[string]string{
+			decisionAnnotation:        `{"v":2,"reason":"Placed","generation":1,"timestamp":"2023-06-01T10:00:00Z"}`,
+			placementReasonAnnotation: reasons.SingleArchitectureCluster,
+		}, nil, nil, reasons.SingleArchitectureCluster),
+	)
+})
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"multiarch-operator/pkg/testenv"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// envtestTimeout bounds the waits for the webhook and for the reconciler of the harness
+const envtestTimeout = 10 * time.Second
+
+var _ = Describe("The webhook and the reconciler in the test environment", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	// createNamespace creates a namespace whose pods are gated by the webhook of the harness
+	createNamespace := func(generateName string) string {
+		ns := testenv.NewNamespace(generateName, map[string]string{envtestNamespaceLabel: "true"})
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, context.Background(), ns)
+		return ns.Name
+	}
+
+	// createPod creates the pod and returns it as admitted
+	createPod := func(pod *corev1.Pod) *corev1.Pod {
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		return pod
+	}
+
+	// create creates the cluster-scoped or namespaced object and deletes it at the end of the spec
+	create := func(obj client.Object) {
+		Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), obj))).To(Succeed())
+		})
+	}
+
+	// cached waits for the object to be in the cache of the manager, read by the webhook and the reconciler
+	cached := func(obj client.Object) {
+		Eventually(func() error {
+			return harness.Manager.GetClient().Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		}, envtestTimeout).Should(Succeed())
+	}
+
+	gated := func(pod *corev1.Pod) bool {
+		return envtestInstance.hasSchedulingGate(getPod(k8sClient, pod))
+	}
+
+	BeforeEach(func() {
+		if harness == nil {
+			Skip("the test environment is not available: KUBEBUILDER_ASSETS is not set")
+		}
+		ctx = context.Background()
+		namespace = createNamespace("envtest-")
+	})
+
+	Context("the webhook", func() {
+		It("should gate the pods", func() {
+			pod := createPod(testenv.NewPod(namespace, "pod", "quay.io/org/unknown:v1"))
+			Expect(pod.Spec.SchedulingGates).To(ContainElement(envtestInstance.schedulingGate()))
+		})
+
+		It("should not gate the pods of the protected namespaces", func() {
+			protected := createNamespace("openshift-envtest-")
+			pod := createPod(testenv.NewPod(protected, "pod", "quay.io/org/unknown:v1"))
+			Expect(pod.Spec.SchedulingGates).To(BeEmpty())
+		})
+
+		It("should deny the pods that cannot run on any node of the cluster", func() {
+			create(testenv.NewNode("envtest-amd64", "amd64"))
+			config := &multiarchv1alpha1.PodPlacementConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "envtest-deny-impossible-pods"},
+				Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+					Admission: &multiarchv1alpha1.AdmissionSettings{DenyImpossiblePods: true},
+				},
+			}
+			create(config)
+			cached(config)
+			// the pods admitted before the webhook reads the PodPlacementConfig do not fail the next attempts
+			Eventually(func() bool {
+				pod := testenv.NewPod(namespace, "", "quay.io/org/arm64-only:v1")
+				pod.GenerateName = "pod-"
+				return apierrors.IsForbidden(k8sClient.Create(ctx, pod))
+			}, envtestTimeout).Should(BeTrue())
+		})
+	})
+
+	Context("the reconciler", func() {
+		It("should place the gated pods", func() {
+			pod := testenv.NewPod(namespace, "pod", "quay.io/org/app:v1")
+			pod.Annotations = map[string]string{envtestInstance.annotation(architecturesOverrideAnnotation): "arm64"}
+			createPod(pod)
+			Eventually(gated).WithArguments(pod).WithTimeout(envtestTimeout).Should(BeFalse())
+			placed := getPod(k8sClient, pod)
+			Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+				To(ConsistOf(HaveField("MatchExpressions", ContainElement(corev1.NodeSelectorRequirement{
+					Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"},
+				}))))
+			Expect(placed.Annotations).To(HaveKeyWithValue(envtestInstance.annotation(placementReasonAnnotation),
+				reasons.ArchitectureConstrained))
+		})
+
+		It("should keep the node selector terms applied by another manager", func() {
+			// a GitOps controller, e.g., Argo CD, creates the pod by server-side apply with its own node affinity
+			const gitOpsManager = "argocd-controller"
+			diskType := corev1.NodeSelectorRequirement{Key: "disktype", Operator: corev1.NodeSelectorOpIn,
+				Values: []string{"ssd"}}
+			pod := testenv.NewPod(namespace, "pod", "quay.io/org/app:v1")
+			pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+			pod.Annotations = map[string]string{envtestInstance.annotation(architecturesOverrideAnnotation): "arm64"}
+			pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+						diskType}}},
+				},
+			}}
+			applied := pod.DeepCopy()
+			Expect(k8sClient.Patch(ctx, pod, client.Apply, client.FieldOwner(gitOpsManager))).To(Succeed())
+			Eventually(gated).WithArguments(pod).WithTimeout(envtestTimeout).Should(BeFalse())
+
+			expectMergedTerms := func() {
+				placed := getPod(k8sClient, pod)
+				Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+					NodeSelectorTerms).To(ConsistOf(HaveField("MatchExpressions", ConsistOf(diskType,
+					corev1.NodeSelectorRequirement{Key: archLabel, Operator: corev1.NodeSelectorOpIn,
+						Values: []string{"arm64"}}))))
+			}
+			expectMergedTerms()
+			Expect(getPod(k8sClient, pod).ManagedFields).To(ContainElement(And(
+				HaveField("Manager", FieldManager),
+				HaveField("Operation", metav1.ManagedFieldsOperationUpdate))))
+			// the terms are owned by the operator once merged: the next apply of the GitOps controller conflicts
+			// with it instead of overwriting them
+			err := k8sClient.Patch(ctx, applied.DeepCopy(), client.Apply, client.FieldOwner(gitOpsManager))
+			Expect(apierrors.IsConflict(err)).To(BeTrue(), "unexpected error: %v", err)
+			expectMergedTerms()
+			// forcing the ownership does not overwrite them either: the node affinity of the ungated pods is immutable
+			Expect(k8sClient.Patch(ctx, applied.DeepCopy(), client.Apply, client.FieldOwner(gitOpsManager),
+				client.ForceOwnership)).NotTo(Succeed())
+			expectMergedTerms()
+		})
+
+		It("should keep the pods gated when their images cannot be inspected and the failure policy is Fail", func() {
+			policy := &multiarchv1alpha1.PodPlacementPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "policy"},
+				Spec: multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+					FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+				}},
+			}
+			create(policy)
+			cached(policy)
+			pod := createPod(testenv.NewPod(namespace, "pod", "quay.io/org/missing:v1"))
+			Eventually(func() ([]corev1.Event, error) {
+				events := &corev1.EventList{}
+				err := k8sClient.List(ctx, events, client.InNamespace(namespace))
+				return events.Items, err
+			}, envtestTimeout).Should(ContainElement(And(
+				HaveField("InvolvedObject.Name", pod.Name),
+				HaveField("Reason", reasons.InspectionFailedNotFound))))
+			Consistently(gated).WithArguments(pod).WithTimeout(time.Second).Should(BeTrue())
+		})
+
+		It("should remove the gate without a node affinity when the images cannot be inspected", func() {
+			pod := createPod(testenv.NewPod(namespace, "pod", "quay.io/org/missing:v1"))
+			Eventually(gated).WithArguments(pod).WithTimeout(envtestTimeout).Should(BeFalse())
+			placed := getPod(k8sClient, pod)
+			Expect(placed.Annotations).To(HaveKeyWithValue(envtestInstance.annotation(placementReasonAnnotation),
+				reasons.GateRemovedByPolicy))
+			if placed.Spec.Affinity != nil {
+				Expect(placed.Spec.Affinity.NodeAffinity).To(BeNil())
+			}
+		})
+	})
+})
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// cronJobScheduleSuffixRegexp matches the suffix the CronJob controller appends to the names of its Jobs, i.e., the
+// scheduled time in minutes since the epoch
+var cronJobScheduleSuffixRegexp = regexp.MustCompile(`-[0-9]+$`)
+
+// DedupingEventRecorder is a record.EventRecorder dropping the events identical to one emitted for a pod of the same
+// owner less than interval ago, e.g., the Placed events of the pods of a CronJob firing every minute. The events are
+// keyed by the namespace and the owner of their object and the fingerprint of their type, reason and message. The
+// pods of the successive Jobs of a CronJob share the same owner, and the objects without a controller are their own
+// owner.
+type DedupingEventRecorder struct {
+	recorder record.EventRecorder
+	interval time.Duration
+	clock    clock.Clock
+	// emitted is the time of the last emitted event for each key. The keys older than interval are pruned at most once
+	// per interval.
+	emitted   map[string]time.Time
+	lastPrune time.Time
+	// mutex is used to protect the emitted map from concurrent access
+	mutex sync.Mutex
+}
+
+var _ record.EventRecorder = &DedupingEventRecorder{}
+
+func NewDedupingEventRecorder(recorder record.EventRecorder, interval time.Duration) *DedupingEventRecorder {
+	return &DedupingEventRecorder{
+		recorder: recorder,
+		interval: interval,
+		clock:    clock.RealClock{},
+		emitted:  map[string]time.Time{},
+	}
+}
+
+// Event emits the event unless an identical one was emitted for the same owner less than interval ago
+func (r *DedupingEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.shouldEmit(object, eventtype, reason, message) {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf is like Event, but with the message formatted by fmt.Sprintf
+func (r *DedupingEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string,
+	args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf is like Eventf, but with the annotations attached to the event. The annotations are not part of
+// the key of the event.
+func (r *DedupingEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype,
+	reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if r.shouldEmit(object, eventtype, reason, message) {
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+	}
+}
+
+// shouldEmit returns true, and records the emission, if no identical event was emitted for the owner of the object
+// less than interval ago
+func (r *DedupingEventRecorder) shouldEmit(object runtime.Object, eventtype, reason, message string) bool {
+	key := eventKey(object, eventtype, reason, message)
+	now := r.clock.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now.Sub(r.lastPrune) >= r.interval {
+		for k, emitted := range r.emitted {
+			if now.Sub(emitted) >= r.interval {
+				delete(r.emitted, k)
+			}
+		}
+		r.lastPrune = now
+	}
+	if emitted, ok := r.emitted[key]; ok && now.Sub(emitted) < r.interval {
+		klog.V(5).Infof("Dropping the %s event %s, identical to one emitted %v ago: %s", eventtype, reason,
+			now.Sub(emitted), message)
+		eventsDeduplicated.WithLabelValues(reason).Inc()
+		return false
+	}
+	r.emitted[key] = now
+	return true
+}
+
+// eventKey returns the key of the event: the namespace and the owner of the object and the fingerprint of the event
+func eventKey(object runtime.Object, eventtype, reason, message string) string {
+	fingerprint := sha256.Sum256([]byte(eventtype + "\x00" + reason + "\x00" + message))
+	accessor, ok := object.(metav1.Object)
+	if !ok {
+		return fmt.Sprintf("%T/%x", object, fingerprint)
+	}
+	return fmt.Sprintf("%s/%s/%x", accessor.GetNamespace(), eventOwner(accessor), fingerprint)
+}
+
+// eventOwner returns the kind and the name of the controller of the object, or its own name if it has none. The
+// suffix of the Jobs created by a CronJob is trimmed, so that the pods of all its Jobs share the same owner.
+func eventOwner(object metav1.Object) string {
+	controller := metav1.GetControllerOf(object)
+	if controller == nil {
+		return object.GetName()
+	}
+	name := controller.Name
+	if controller.Kind == "Job" {
+		name = cronJobScheduleSuffixRegexp.ReplaceAllString(name, "")
+	}
+	return controller.Kind + "/" + name
+}
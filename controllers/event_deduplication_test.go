@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// cronJobPod returns a gated pod of the Job the CronJob created for the scheduled time
+func cronJobPod(cronJob string, scheduledTime int) *corev1.Pod {
+	job := fmt.Sprintf("%s-%d", cronJob, scheduledTime)
+	pod := podWithImages(job+"-abcde", "quay.io/org/app:v1")
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       job,
+		UID:        types.UID(job),
+		Controller: &controller,
+	}}
+	pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+	return pod
+}
+
+// countEvents returns the number of events received by the recorder, without waiting for new ones
+func countEvents(recorder *record.FakeRecorder) int {
+	events := 0
+	for {
+		select {
+		case <-recorder.Events:
+			events++
+		default:
+			return events
+		}
+	}
+}
+
+var _ = Describe("The DedupingEventRecorder", func() {
+	var (
+		fakeClock *clocktesting.FakeClock
+		recorder  *record.FakeRecorder
+		deduping  *DedupingEventRecorder
+	)
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		recorder = record.NewFakeRecorder(200)
+		deduping = NewDedupingEventRecorder(recorder, 5*time.Minute)
+		deduping.clock = fakeClock
+	})
+
+	It("should emit a bounded number of events for 100 identical decisions", func() {
+		var pods []*corev1.Pod
+		for i := 0; i < 100; i++ {
+			pods = append(pods, cronJobPod("report", 28000000+i))
+		}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		for _, pod := range pods {
+			Expect(c.Create(context.Background(), pod)).To(Succeed())
+		}
+		r := &PodReconciler{
+			Client:    c,
+			Inspector: &fakeArchitectures{registry: map[string][]string{"//quay.io/org/app:v1": {"amd64"}}},
+			Recorder:  deduping,
+		}
+		for i, pod := range pods {
+			reconcilePods(r, pod)
+			Expect(getPod(c, pod).Spec.SchedulingGates).To(BeEmpty())
+			// the CronJob fires every minute
+			if i%10 == 9 {
+				fakeClock.Step(time.Minute)
+			}
+		}
+		Expect(countEvents(recorder)).To(BeNumerically("<=", 3))
+	})
+
+	It("should emit the identical events again after the interval", func() {
+		deduping.Event(cronJobPod("report", 1), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on amd64")
+		deduping.Event(cronJobPod("report", 2), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on amd64")
+		Expect(countEvents(recorder)).To(Equal(1))
+		fakeClock.Step(5 * time.Minute)
+		deduping.Eventf(cronJobPod("report", 3), corev1.EventTypeNormal,
+			reasons.ArchitectureConstrained, "Placed on %s", "amd64")
+		Expect(countEvents(recorder)).To(Equal(1))
+	})
+
+	It("should emit the events of other owners, types, reasons and messages", func() {
+		deduping.Event(cronJobPod("report", 1), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on amd64")
+		deduping.Event(cronJobPod("cleanup", 1), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on amd64")
+		deduping.Event(cronJobPod("report", 2), corev1.EventTypeWarning, reasons.ArchitectureConstrained, "Placed on amd64")
+		deduping.Event(cronJobPod("report", 3), corev1.EventTypeNormal, "Other", "Placed on amd64")
+		deduping.Event(cronJobPod("report", 4), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on arm64")
+		other := cronJobPod("report", 5)
+		other.Namespace = "other"
+		deduping.Event(other, corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed on amd64")
+		Expect(countEvents(recorder)).To(Equal(6))
+	})
+
+	It("should not deduplicate the events of the pods without a controller", func() {
+		deduping.Event(podWithImages("a", "quay.io/org/app:v1"), corev1.EventTypeNormal,
+			reasons.ArchitectureConstrained, "Placed")
+		deduping.Event(podWithImages("b", "quay.io/org/app:v1"), corev1.EventTypeNormal,
+			reasons.ArchitectureConstrained, "Placed")
+		deduping.Event(podWithImages("a", "quay.io/org/app:v1"), corev1.EventTypeNormal,
+			reasons.ArchitectureConstrained, "Placed")
+		Expect(countEvents(recorder)).To(Equal(2))
+	})
+
+	It("should prune the keys older than the interval", func() {
+		for i := 0; i < 10; i++ {
+			deduping.Event(cronJobPod(fmt.Sprintf("job%d", i), 1), corev1.EventTypeNormal,
+				reasons.ArchitectureConstrained, "Placed")
+		}
+		Expect(deduping.emitted).To(HaveLen(10))
+		fakeClock.Step(5 * time.Minute)
+		deduping.Event(cronJobPod("report", 1), corev1.EventTypeNormal, reasons.ArchitectureConstrained, "Placed")
+		Expect(deduping.emitted).To(HaveLen(1))
+	})
+})
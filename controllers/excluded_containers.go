@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// withoutExcludedContainers returns a copy of the pod without the containers, init containers included, whose names
+// match the excludedContainerNames of the policy, so that their images are neither inspected nor intersected with the
+// ones of the other containers, and the names of the excluded containers. The pod itself is returned when no container
+// is excluded.
+func withoutExcludedContainers(pod *corev1.Pod, policy multiarchv1alpha1.PlacementPolicy) (*corev1.Pod, []string) {
+	if len(policy.ExcludedContainerNames) == 0 {
+		return pod, nil
+	}
+	var excluded []string
+	filter := func(containers []corev1.Container) []corev1.Container {
+		var kept []corev1.Container
+		for _, container := range containers {
+			if isExcludedContainer(container.Name, policy.ExcludedContainerNames) {
+				excluded = append(excluded, container.Name)
+				continue
+			}
+			kept = append(kept, container)
+		}
+		return kept
+	}
+	containers := filter(pod.Spec.Containers)
+	initContainers := filter(pod.Spec.InitContainers)
+	if len(excluded) == 0 {
+		return pod, nil
+	}
+	filtered := pod.DeepCopy()
+	filtered.Spec.Containers = containers
+	filtered.Spec.InitContainers = initContainers
+	return filtered, excluded
+}
+
+// isExcludedContainer returns true if the name matches one of the glob patterns. The policies are validated before
+// being used: the malformed patterns match no name.
+func isExcludedContainer(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+var _ = Describe("The excluded containers", func() {
+	pod := func() *corev1.Pod {
+		pod := podWithImages("pod", "quay.io/org/app:v1", "quay.io/mesh/proxy:v1")
+		pod.Spec.Containers[0].Name = "app"
+		pod.Spec.Containers[1].Name = "istio-proxy"
+		pod.Spec.InitContainers = []corev1.Container{{Name: "init-sidecar", Image: "quay.io/mesh/init:v1"}}
+		return pod
+	}
+
+	It("should be removed from a copy of the pod", func() {
+		original := pod()
+		placed, excluded := withoutExcludedContainers(original, multiarchv1alpha1.PlacementPolicy{
+			ExcludedContainerNames: []string{"istio-proxy", "*-sidecar"},
+		})
+		Expect(excluded).To(Equal([]string{"istio-proxy", "init-sidecar"}))
+		Expect(placed.Spec.Containers).To(ConsistOf(HaveField("Name", "app")))
+		Expect(placed.Spec.InitContainers).To(BeEmpty())
+		Expect(original).To(Equal(pod()))
+	})
+
+	It("should leave the pod unchanged when no container matches", func() {
+		original := pod()
+		placed, excluded := withoutExcludedContainers(original, multiarchv1alpha1.PlacementPolicy{
+			ExcludedContainerNames: []string{"linkerd-*"},
+		})
+		Expect(excluded).To(BeEmpty())
+		Expect(placed).To(BeIdenticalTo(original))
+	})
+
+	It("should not match the malformed patterns", func() {
+		Expect(isExcludedContainer("sidecar", []string{"sidecar-[", "side*"})).To(BeTrue())
+		Expect(isExcludedContainer("sidecar-[", []string{"sidecar-["})).To(BeFalse())
+	})
+})
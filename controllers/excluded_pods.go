@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=deployments;replicasets,verbs=get;list;watch
+
+// isExcludedFromPlacement returns true if the pod, or the pod template of its ReplicaSet or of the Deployment of the
+// ReplicaSet, has the excludePodPlacementAnnotation annotation of the instance set to "true". The templates are only
+// read for the pods without the annotation, e.g., the ones created before the template of their owner was annotated,
+// through c: the ReplicaSets and the Deployments are read from the cache of the manager. The owners that no longer
+// exist do not exclude the pod. The other errors are returned, so that the pod is not placed against the will of its
+// owner.
+func isExcludedFromPlacement(ctx context.Context, c client.Reader, instance *Instance, pod *corev1.Pod) (bool,
+	error) {
+	if instance.isExcludedFromPlacement(pod) {
+		return true, nil
+	}
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil || controller.APIVersion != "apps/v1" || controller.Kind != "ReplicaSet" {
+		return false, nil
+	}
+	replicaSet := &appsv1.ReplicaSet{}
+	excluded, err := isExcludedByTemplate(ctx, c, instance, pod.Namespace, controller.Name, replicaSet,
+		&replicaSet.Spec.Template)
+	if err != nil || excluded {
+		return excluded, err
+	}
+	controller = metav1.GetControllerOf(replicaSet)
+	if controller == nil || controller.APIVersion != "apps/v1" || controller.Kind != "Deployment" {
+		return false, nil
+	}
+	deployment := &appsv1.Deployment{}
+	return isExcludedByTemplate(ctx, c, instance, pod.Namespace, controller.Name, deployment,
+		&deployment.Spec.Template)
+}
+
+// isExcludedByTemplate reads the owner namespace/name and returns true if its template, a field of the owner, has the
+// excludePodPlacementAnnotation annotation of the instance set to "true"
+func isExcludedByTemplate(ctx context.Context, c client.Reader, instance *Instance, namespace, name string,
+	owner client.Object, template *corev1.PodTemplateSpec) (bool, error) {
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, owner); err != nil {
+		if apierrors.IsNotFound(err) {
+			klog.V(4).Infof("The owner %s/%s of the pod no longer exists", namespace, name)
+			return false, nil
+		}
+		return false, err
+	}
+	return template.Annotations[instance.annotation(excludePodPlacementAnnotation)] == "true", nil
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("The pods excluded from the placement", func() {
+	var (
+		ctx        context.Context
+		deployment *appsv1.Deployment
+		replicaSet *appsv1.ReplicaSet
+		// ownerReads counts the reads of the ReplicaSets and of the Deployments
+		ownerReads int
+		// ownerErr is returned by the reads of the ReplicaSets and of the Deployments, when not nil
+		ownerErr error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		ownerReads, ownerErr = 0, nil
+		deployment = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test",
+			UID: "Deployment/app"}}
+		replicaSet = &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "test",
+			UID:             "ReplicaSet/app-1",
+			OwnerReferences: []metav1.OwnerReference{controllerReference("apps/v1", "Deployment", "app")}}}
+	})
+
+	// reconcileWithError reconciles the gated pod, created with the Deployment and its ReplicaSet, and returns it with
+	// the error of the reconcile
+	reconcileWithError := func(pod *corev1.Pod) (*corev1.Pod, error) {
+		scheme := newTestScheme()
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+				switch obj.(type) {
+				case *appsv1.ReplicaSet, *appsv1.Deployment:
+					ownerReads++
+					if ownerErr != nil {
+						return ownerErr
+					}
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).WithObjects(deployment, replicaSet, pod).Build()
+		reconciler := &PodReconciler{
+			Client: c,
+			Inspector: &fakeArchitectures{
+				registry: map[string][]string{"//quay.io/org/app:v1": {"arm64"}},
+			},
+			Recorder: record.NewFakeRecorder(10),
+		}
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		return getPod(c, pod), err
+	}
+
+	// reconcile reconciles the gated pod, as reconcileWithError, expecting no error
+	reconcile := func(pod *corev1.Pod) *corev1.Pod {
+		reconciled, err := reconcileWithError(pod)
+		Expect(err).NotTo(HaveOccurred())
+		return reconciled
+	}
+
+	replicaSetPod := func() *corev1.Pod {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.OwnerReferences = []metav1.OwnerReference{controllerReference("apps/v1", "ReplicaSet", "app-1")}
+		return pod
+	}
+
+	excluded := map[string]string{excludePodPlacementAnnotation: "true"}
+
+	It("should be released without node affinity by the reconciler", func() {
+		pod := replicaSetPod()
+		pod.Annotations = excluded
+		released := reconcile(pod)
+		Expect(released.Spec.SchedulingGates).To(BeEmpty())
+		Expect(released.Spec.Affinity).To(BeNil())
+		Expect(released.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.OptedOut))
+		// the owners are not read for the annotated pods
+		Expect(ownerReads).To(BeZero())
+	})
+
+	DescribeTable("should be excluded by the pod template of their owner", func(annotate func()) {
+		annotate()
+		released := reconcile(replicaSetPod())
+		Expect(released.Spec.SchedulingGates).To(BeEmpty())
+		Expect(released.Spec.Affinity).To(BeNil())
+	},
+		Entry("the ReplicaSet", func() { replicaSet.Spec.Template.Annotations = excluded }),
+		Entry("the Deployment of the ReplicaSet", func() { deployment.Spec.Template.Annotations = excluded }),
+	)
+
+	It("should not be excluded by the annotations of their owner outside its pod template", func() {
+		deployment.Annotations = excluded
+		replicaSet.Spec.Template.Annotations = map[string]string{excludePodPlacementAnnotation: "false"}
+		placed := reconcile(replicaSetPod())
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+
+	It("should stay gated when their owner cannot be read", func() {
+		ownerErr = apierrors.NewServiceUnavailable("unavailable")
+		gated, err := reconcileWithError(replicaSetPod())
+		Expect(err).To(HaveOccurred())
+		Expect(gated.Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(gated.Spec.Affinity).To(BeNil())
+	})
+
+	It("should not be excluded when their owner no longer exists", func() {
+		pod := replicaSetPod()
+		pod.OwnerReferences = []metav1.OwnerReference{controllerReference("apps/v1", "ReplicaSet", "missing")}
+		placed := reconcile(pod)
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+	})
+})
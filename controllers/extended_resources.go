@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/image"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExtendedResourcesIndex periodically maps the extended resources of the extendedResourceArchitectures of the
+// PodPlacementConfig objects to the architectures of the nodes providing them: the architectures listed for them, or
+// the ones of the schedulable nodes advertising them in their allocatable resources.
+// It implements the manager.Runnable interface and is expected to be added to the manager.
+type ExtendedResourcesIndex struct {
+	client          client.Client
+	refreshInterval time.Duration
+	// architectures are the sorted architectures of each mapped extended resource. The resources advertised by no
+	// schedulable node are missing.
+	architectures map[corev1.ResourceName][]string
+	// mutex is used to protect the architectures map from concurrent access
+	mutex sync.RWMutex
+}
+
+func NewExtendedResourcesIndex(c client.Client, refreshInterval time.Duration) *ExtendedResourcesIndex {
+	return &ExtendedResourcesIndex{
+		client:          c,
+		refreshInterval: refreshInterval,
+		architectures:   map[corev1.ResourceName][]string{},
+	}
+}
+
+// Start refreshes the index every refreshInterval until the context is done.
+func (x *ExtendedResourcesIndex) Start(ctx context.Context) error {
+	ticker := time.NewTicker(x.refreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := x.refresh(ctx); err != nil {
+			klog.Warningf("unable to refresh the architectures of the extended resources: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false: the webhook using the index is served by every replica of the operator.
+func (x *ExtendedResourcesIndex) NeedLeaderElection() bool {
+	return false
+}
+
+func (x *ExtendedResourcesIndex) refresh(ctx context.Context) error {
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, x.client)
+	if err != nil {
+		return err
+	}
+	mapping := extendedResourceMapping(podPlacementConfigs)
+	discovered := map[corev1.ResourceName]sets.Set[string]{}
+	for name, listed := range mapping {
+		if len(listed) == 0 {
+			discovered[name] = sets.New[string]()
+		}
+	}
+	if len(discovered) > 0 {
+		nodes := &corev1.NodeList{}
+		if err := x.client.List(ctx, nodes); err != nil {
+			return err
+		}
+		for i := range nodes.Items {
+			arch, ok := schedulableNodeArchitecture(&nodes.Items[i])
+			if !ok {
+				continue
+			}
+			for name, architectures := range discovered {
+				if quantity, ok := nodes.Items[i].Status.Allocatable[name]; ok && !quantity.IsZero() {
+					architectures.Insert(arch)
+				}
+			}
+		}
+	}
+	index := make(map[corev1.ResourceName][]string, len(mapping))
+	for name, listed := range mapping {
+		if len(listed) > 0 {
+			index[name] = listed
+		} else if discovered[name].Len() > 0 {
+			index[name] = sets.List(discovered[name])
+		}
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.architectures = index
+	klog.V(5).Infof("architectures of the extended resources: %v", index)
+	return nil
+}
+
+// extendedResourceMapping returns the sorted architectures listed for the extended resources of the
+// extendedResourceArchitectures of the PodPlacementConfig objects sorted by name, the first one listing a resource
+// winning. The resources whose architectures are discovered have none.
+func extendedResourceMapping(
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) map[corev1.ResourceName][]string {
+	mapping := map[corev1.ResourceName][]string{}
+	for _, ppc := range podPlacementConfigs {
+		for _, resource := range ppc.Spec.ExtendedResourceArchitectures {
+			name := corev1.ResourceName(resource.Name)
+			if _, ok := mapping[name]; ok {
+				continue
+			}
+			architectures := sets.New[string]()
+			for _, architecture := range resource.Architectures {
+				// the PodPlacementConfig objects are validated before being stored
+				architecture, _ = image.ParseArchitecture(architecture)
+				architectures.Insert(architecture)
+			}
+			mapping[name] = sets.List(architectures)
+		}
+	}
+	return mapping
+}
+
+// filterArchitectures returns the subset of the architectures of the nodes providing all the mapped extended
+// resources the pod requests, and the subset of the architectures that have been excluded. The resources whose
+// architectures are not known are ignored. If none of the architectures provides the resources, it returns the input
+// set unchanged, so that the result is never empty.
+func (x *ExtendedResourcesIndex) filterArchitectures(pod *corev1.Pod,
+	architectures []string) (providing []string, excluded []string) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	if len(x.architectures) == 0 {
+		return architectures, nil
+	}
+	var allowed sets.Set[string]
+	for name := range requestedResourceNames(pod) {
+		if resourceArchitectures, ok := x.architectures[name]; ok {
+			allowed = intersectArchitectures(allowed, sets.New(resourceArchitectures...))
+		}
+	}
+	if allowed == nil {
+		return architectures, nil
+	}
+	for _, arch := range architectures {
+		if allowed.Has(arch) {
+			providing = append(providing, arch)
+		} else {
+			excluded = append(excluded, arch)
+		}
+	}
+	if len(providing) == 0 {
+		return architectures, nil
+	}
+	return providing, excluded
+}
+
+// requestedResourceNames returns the names of the resources the containers of the pod, init containers included,
+// request or limit to a non-zero quantity. The requests of the extended resources default to their limits.
+func requestedResourceNames(pod *corev1.Pod) sets.Set[corev1.ResourceName] {
+	names := sets.New[corev1.ResourceName]()
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for _, list := range []corev1.ResourceList{container.Resources.Requests, container.Resources.Limits} {
+				for name, quantity := range list {
+					if !quantity.IsZero() {
+						names.Insert(name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// refineRequirementByExtendedResources drops from the requirement the architectures of the nodes not providing the
+// mapped extended resources the pod requests
+func refineRequirementByExtendedResources(ctx context.Context, extendedResources *ExtendedResourcesIndex,
+	pod *corev1.Pod, requirement *corev1.NodeSelectorRequirement) {
+	providing, excluded := extendedResources.filterArchitectures(pod, requirement.Values)
+	if len(excluded) == 0 {
+		return
+	}
+	klog.V(3).Infof("Excluding the architectures %v for pod %s/%s: the extended resources it requests are not "+
+		"provided by their nodes", excluded, pod.Namespace, pod.Name)
+	core.DebugLog(ctx, "Excluding the architectures %v not providing the extended resources of the pod", excluded)
+	requirement.Values = providing
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const gpuResource corev1.ResourceName = "nvidia.com/gpu"
+
+// nodeWithExtendedResources returns a node of the architecture advertising the quantities of the extended resources
+// in its allocatable resources
+func nodeWithExtendedResources(name, arch string, unschedulable bool,
+	resources map[corev1.ResourceName]string) *corev1.Node {
+	node := nodeWithCapacity(name, arch, "8", "32Gi", unschedulable)
+	for resourceName, quantity := range resources {
+		node.Status.Allocatable[resourceName] = resource.MustParse(quantity)
+	}
+	return node
+}
+
+// extendedResourcesConfig returns a PodPlacementConfig mapping the extended resources to their architectures
+func extendedResourcesConfig(name string,
+	mapping ...multiarchv1alpha1.ExtendedResourceArchitectures) *multiarchv1alpha1.PodPlacementConfig {
+	return &multiarchv1alpha1.PodPlacementConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       multiarchv1alpha1.PodPlacementConfigSpec{ExtendedResourceArchitectures: mapping},
+	}
+}
+
+// podWithResourceLimits returns a pod whose single container limits the resources to the quantities
+func podWithResourceLimits(name, image string, limits map[corev1.ResourceName]string) *corev1.Pod {
+	pod := podWithImages(name, image)
+	pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{}
+	for resourceName, quantity := range limits {
+		pod.Spec.Containers[0].Resources.Limits[resourceName] = resource.MustParse(quantity)
+	}
+	return pod
+}
+
+var _ = Describe("The extended resources index", func() {
+	newIndex := func(objects ...client.Object) *ExtendedResourcesIndex {
+		x := NewExtendedResourcesIndex(fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithObjects(objects...).Build(), time.Minute)
+		Expect(x.refresh(context.Background())).To(Succeed())
+		return x
+	}
+
+	It("should discover the architectures of the schedulable nodes advertising the mapped resources", func() {
+		x := newIndex(
+			extendedResourcesConfig("cluster", multiarchv1alpha1.ExtendedResourceArchitectures{Name: string(gpuResource)}),
+			nodeWithExtendedResources("arm64-gpu", "arm64", false, map[corev1.ResourceName]string{gpuResource: "4"}),
+			nodeWithExtendedResources("amd64-gpu-cordoned", "amd64", true,
+				map[corev1.ResourceName]string{gpuResource: "4"}),
+			nodeWithExtendedResources("ppc64le-no-gpu", "ppc64le", false,
+				map[corev1.ResourceName]string{gpuResource: "0"}),
+			nodeWithExtendedResources("s390x-other", "s390x", false,
+				map[corev1.ResourceName]string{"example.com/fpga": "1"}),
+		)
+		Expect(x.architectures).To(Equal(map[corev1.ResourceName][]string{gpuResource: {"arm64"}}))
+	})
+
+	It("should only index the resources mapped by the PodPlacementConfig objects", func() {
+		x := newIndex(nodeWithExtendedResources("arm64-gpu", "arm64", false,
+			map[corev1.ResourceName]string{gpuResource: "4"}))
+		Expect(x.architectures).To(BeEmpty())
+	})
+
+	It("should prefer the architectures listed by the first PodPlacementConfig mapping a resource", func() {
+		x := newIndex(
+			extendedResourcesConfig("a", multiarchv1alpha1.ExtendedResourceArchitectures{
+				Name: string(gpuResource), Architectures: []string{"x86_64", "aarch64"},
+			}),
+			extendedResourcesConfig("b", multiarchv1alpha1.ExtendedResourceArchitectures{Name: string(gpuResource)}),
+			nodeWithExtendedResources("ppc64le-gpu", "ppc64le", false, map[corev1.ResourceName]string{gpuResource: "1"}),
+		)
+		Expect(x.architectures).To(Equal(map[corev1.ResourceName][]string{gpuResource: {"amd64", "arm64"}}))
+	})
+
+	It("should drop the resources no node advertises anymore", func() {
+		gpuNode := nodeWithExtendedResources("arm64-gpu", "arm64", false,
+			map[corev1.ResourceName]string{gpuResource: "4"})
+		x := newIndex(
+			extendedResourcesConfig("cluster", multiarchv1alpha1.ExtendedResourceArchitectures{Name: string(gpuResource)}),
+			gpuNode,
+		)
+		Expect(x.architectures).To(HaveKey(gpuResource))
+		Expect(x.client.Delete(context.Background(), gpuNode)).To(Succeed())
+		Expect(x.refresh(context.Background())).To(Succeed())
+		Expect(x.architectures).To(BeEmpty())
+	})
+})
+
+var _ = Describe("The intersection with the architectures of the extended resources", func() {
+	var x *ExtendedResourcesIndex
+
+	BeforeEach(func() {
+		x = &ExtendedResourcesIndex{architectures: map[corev1.ResourceName][]string{
+			gpuResource:        {"amd64", "arm64"},
+			"example.com/fpga": {"arm64"},
+		}}
+	})
+
+	DescribeTable("should keep the architectures providing all the requested resources",
+		func(limits map[corev1.ResourceName]string, expectedProviding, expectedExcluded []string) {
+			pod := podWithResourceLimits("pod", "quay.io/org/app:v1", limits)
+			providing, excluded := x.filterArchitectures(pod, []string{"amd64", "arm64", "ppc64le"})
+			Expect(providing).To(Equal(expectedProviding))
+			Expect(excluded).To(Equal(expectedExcluded))
+		},
+		Entry("one resource", map[corev1.ResourceName]string{gpuResource: "1"},
+			[]string{"amd64", "arm64"}, []string{"ppc64le"}),
+		Entry("several resources", map[corev1.ResourceName]string{gpuResource: "1", "example.com/fpga": "1"},
+			[]string{"arm64"}, []string{"amd64", "ppc64le"}),
+		Entry("no mapped resource", map[corev1.ResourceName]string{"example.com/other": "1"},
+			[]string{"amd64", "arm64", "ppc64le"}, nil),
+		Entry("a zero quantity", map[corev1.ResourceName]string{gpuResource: "0"},
+			[]string{"amd64", "arm64", "ppc64le"}, nil),
+	)
+
+	It("should count the requests of the init containers", func() {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Spec.InitContainers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{"example.com/fpga": resource.MustParse("1")},
+		}}}
+		providing, excluded := x.filterArchitectures(pod, []string{"amd64", "arm64"})
+		Expect(providing).To(Equal([]string{"arm64"}))
+		Expect(excluded).To(Equal([]string{"amd64"}))
+	})
+
+	It("should keep the architectures of the images when none provides the resources", func() {
+		pod := podWithResourceLimits("pod", "quay.io/org/app:v1", map[corev1.ResourceName]string{gpuResource: "1"})
+		providing, excluded := x.filterArchitectures(pod, []string{"ppc64le", "s390x"})
+		Expect(providing).To(Equal([]string{"ppc64le", "s390x"}))
+		Expect(excluded).To(BeEmpty())
+	})
+
+	It("should keep the architectures before the first refresh", func() {
+		x = NewExtendedResourcesIndex(nil, time.Minute)
+		pod := podWithResourceLimits("pod", "quay.io/org/app:v1", map[corev1.ResourceName]string{gpuResource: "1"})
+		providing, _ := x.filterArchitectures(pod, []string{"amd64", "ppc64le"})
+		Expect(providing).To(Equal([]string{"amd64", "ppc64le"}))
+	})
+
+	It("should restrict the node affinity set by the reconciler", func() {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			extendedResourcesConfig("cluster", multiarchv1alpha1.ExtendedResourceArchitectures{Name: string(gpuResource)}),
+			nodeWithExtendedResources("arm64-gpu", "arm64", false, map[corev1.ResourceName]string{gpuResource: "4"}),
+			nodeWithCapacity("amd64-1", "amd64", "8", "32Gi", false),
+		).Build()
+		reconciler := &PodReconciler{
+			Client: c,
+			Inspector: &fakeArchitectures{
+				registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+			},
+			ExtendedResources: NewExtendedResourcesIndex(c, time.Minute),
+		}
+		Expect(reconciler.ExtendedResources.refresh(context.Background())).To(Succeed())
+		place := func(pod *corev1.Pod) []corev1.NodeSelectorTerm {
+			pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+			Expect(c.Create(context.Background(), pod)).To(Succeed())
+			_, err := reconciler.Reconcile(context.Background(),
+				ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).NotTo(HaveOccurred())
+			return getPod(c, pod).Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+				NodeSelectorTerms
+		}
+		Expect(place(podWithResourceLimits("gpu", "quay.io/org/app:v1",
+			map[corev1.ResourceName]string{gpuResource: "1"}))).To(Equal([]corev1.NodeSelectorTerm{
+			archTerm(archLabel, "arm64")}))
+		Expect(place(podWithImages("cpu", "quay.io/org/app:v1"))).To(Equal([]corev1.NodeSelectorTerm{
+			archTerm(archLabel, "amd64", "arm64")}))
+	})
+})
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"flag"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/multiarch"
+	"multiarch-operator/pkg/version"
+)
+
+// featuresMetricsSubsystem is the subsystem of the metrics reporting the build and the features of the operator
+const featuresMetricsSubsystem = "operator"
+
+var (
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: featuresMetricsSubsystem,
+		Name:      "build_info",
+		Help: "Always 1, labeled with the version, the git commit and the date of the build of the operator " +
+			"exporting it",
+	}, []string{"commit", "date"})
+	featureEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: featuresMetricsSubsystem,
+		Name:      "feature_enabled",
+		Help: "1 when the feature is enabled, 0 otherwise, by feature: the name of a boolean flag of the " +
+			"command line, or a toggle of the PodPlacementConfig objects, e.g., paused or placementMode=Preferred",
+	}, []string{"feature"})
+)
+
+func init() {
+	version.MetricsRegisterer().MustRegister(buildInfo, featureEnabled)
+	buildInfo.WithLabelValues(version.Commit, version.Date).Set(1)
+}
+
+// features is the state of the features of the operator exported by the feature_enabled metric and the Summary
+var features = &featureStates{}
+
+// featureStates are the features of the operator: the boolean flags of the command line, the mode of the operator and
+// the toggles of the last snapshot of the PodPlacementConfig objects
+type featureStates struct {
+	mutex sync.Mutex
+	// flags are the boolean flags of the command line, by name
+	flags map[string]bool
+	// schedulingGatesUnsupported is true when the cluster does not support the pod scheduling gates
+	schedulingGatesUnsupported bool
+	// configs is the last snapshot of the PodPlacementConfig objects, if any
+	configs *podPlacementConfigs
+}
+
+// RecordFeatures records the boolean flags of the flag set, e.g., flag.CommandLine once parsed, and whether the
+// cluster supports the pod scheduling gates, and exports them by the feature_enabled metric along with the toggles of
+// the PodPlacementConfig objects. The flags are exported by their name, e.g., enable-summary.
+func RecordFeatures(fs *flag.FlagSet, schedulingGatesUnsupported bool) {
+	flags := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && value.IsBoolFlag() {
+			flags[f.Name] = f.Value.String() == "true"
+		}
+	})
+	features.mutex.Lock()
+	defer features.mutex.Unlock()
+	features.flags, features.schedulingGatesUnsupported = flags, schedulingGatesUnsupported
+	features.publish()
+}
+
+// recordPodPlacementConfigFeatures exports the toggles of the snapshot of the PodPlacementConfig objects, replacing
+// the ones of the previous snapshot
+func recordPodPlacementConfigFeatures(configs *podPlacementConfigs) {
+	features.mutex.Lock()
+	defer features.mutex.Unlock()
+	features.configs = configs
+	features.publish()
+}
+
+// publish replaces the series of the feature_enabled metric with the current features. The mutex must be held.
+func (f *featureStates) publish() {
+	featureEnabled.Reset()
+	for feature, enabled := range f.snapshot(f.configs, operatorMode(f.schedulingGatesUnsupported, f.configs)) {
+		value := 0.
+		if enabled {
+			value = 1
+		}
+		featureEnabled.WithLabelValues(feature).Set(value)
+	}
+}
+
+// snapshot returns the recorded flags, the mode of the operator and the toggles of the configs, if any, by feature
+func (f *featureStates) snapshot(configs *podPlacementConfigs, mode string) map[string]bool {
+	snapshot := make(map[string]bool, len(f.flags))
+	for name, enabled := range f.flags {
+		snapshot[name] = enabled
+	}
+	setEnumFeature(snapshot, "mode", mode, SummaryModeActive, SummaryModePaused,
+		SummaryModeSchedulingGatesUnsupported)
+	if configs == nil {
+		return snapshot
+	}
+	for name, enabled := range configs.features {
+		snapshot[name] = enabled
+	}
+	return snapshot
+}
+
+// recordedFeatures returns the features of the operator for the configs and the mode, e.g., for the Summary
+func recordedFeatures(configs *podPlacementConfigs, mode string) map[string]bool {
+	features.mutex.Lock()
+	defer features.mutex.Unlock()
+	return features.snapshot(configs, mode)
+}
+
+// operatorMode returns the mode of the operator, one of SummaryModeActive, SummaryModePaused and
+// SummaryModeSchedulingGatesUnsupported
+func operatorMode(schedulingGatesUnsupported bool, configs *podPlacementConfigs) string {
+	switch {
+	case schedulingGatesUnsupported:
+		return SummaryModeSchedulingGatesUnsupported
+	case configs != nil && configs.pausedBy != "":
+		return SummaryModePaused
+	}
+	return SummaryModeActive
+}
+
+// podPlacementConfigToggles returns the boolean toggles of the spec of a PodPlacementConfig, by feature
+func podPlacementConfigToggles(spec *multiarchv1alpha1.PodPlacementConfigSpec) map[string]bool {
+	return map[string]bool{
+		"paused":                            spec.Paused,
+		"pinToInspectedDigest":              spec.PinToInspectedDigest,
+		"considerAutoscaledCapacity":        spec.ConsiderAutoscaledCapacity,
+		"cleanupAnnotationsAfterScheduling": spec.CleanupAnnotationsAfterScheduling,
+		"keepDecisionAnnotations":           spec.KeepDecisionAnnotations,
+		"skipSingleArchCluster":             spec.SkipSingleArchCluster,
+		"mutateWorkloadTemplates":           spec.MutateWorkloadTemplates,
+		"trustedMultiArchPrefixes":          len(spec.TrustedMultiArchPrefixes) > 0,
+		"additionalNodeSelectorTerms":       len(spec.AdditionalNodeSelectorTerms) > 0,
+		"extendedResourceArchitectures":     len(spec.ExtendedResourceArchitectures) > 0,
+		"admission.denyImpossiblePods":      spec.Admission != nil && spec.Admission.DenyImpossiblePods,
+		"tuning":                            spec.Tuning != nil,
+	}
+}
+
+// podPlacementConfigFeatures returns the toggles of the PodPlacementConfig objects sorted by name, named after the
+// fields of their spec: the podPlacementConfigToggles are enabled when any object enables them, and the cluster-wide
+// placement settings are merged as for the pods, e.g., placementMode=Required is enabled unless the first object
+// setting the placementMode sets it to Preferred.
+func podPlacementConfigFeatures(items []multiarchv1alpha1.PodPlacementConfig) map[string]bool {
+	toggles := podPlacementConfigToggles(&multiarchv1alpha1.PodPlacementConfigSpec{})
+	for i := range items {
+		for feature, enabled := range podPlacementConfigToggles(&items[i].Spec) {
+			toggles[feature] = toggles[feature] || enabled
+		}
+	}
+	var policy multiarchv1alpha1.PlacementPolicy
+	for _, ppc := range items {
+		if multiarch.ValidatePlacementPolicy(&ppc.Spec.PlacementPolicy) == nil {
+			inheritPlacementPolicy(&policy, &ppc.Spec.PlacementPolicy)
+		}
+	}
+	toggles["allowedArchitectures"] = len(policy.AllowedArchitectures) > 0
+	toggles["defaultArchitectures"] = len(policy.DefaultArchitectures) > 0
+	toggles["excludedContainerNames"] = len(policy.ExcludedContainerNames) > 0
+	toggles["optOut"] = isOptedOut(policy)
+	placementMode := policy.PlacementMode
+	if placementMode == "" {
+		placementMode = multiarchv1alpha1.PlacementModeRequired
+	}
+	setEnumFeature(toggles, "placementMode", string(placementMode), string(multiarchv1alpha1.PlacementModeRequired),
+		string(multiarchv1alpha1.PlacementModePreferred))
+	failurePolicy := policy.FailurePolicy
+	if failurePolicy == "" {
+		failurePolicy = multiarchv1alpha1.PlacementFailurePolicyIgnore
+	}
+	setEnumFeature(toggles, "failurePolicy", string(failurePolicy),
+		string(multiarchv1alpha1.PlacementFailurePolicyIgnore), string(multiarchv1alpha1.PlacementFailurePolicyFail))
+	return toggles
+}
+
+// setEnumFeature sets the features name=value of all the values of a setting, enabling the one of the current value
+func setEnumFeature(features map[string]bool, name, current string, values ...string) {
+	for _, value := range values {
+		features[name+"="+value] = value == current
+	}
+}
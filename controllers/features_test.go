@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"flag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordFlagFeatures records the boolean flags with the values as the features of the operator, until the end of the
+// spec
+func recordFlagFeatures(values map[string]bool) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for name, value := range values {
+		fs.Bool(name, value, "")
+	}
+	RecordFeatures(fs, false)
+	DeferCleanup(func() { RecordFeatures(flag.NewFlagSet("test", flag.ContinueOnError), false) })
+}
+
+// featureValue returns the value of the feature_enabled metric for the feature
+func featureValue(feature string) float64 {
+	return gaugeValue(featureEnabled.WithLabelValues(feature))
+}
+
+var _ = Describe("The features of the operator", func() {
+	It("should export the boolean flags of the command line", func() {
+		NewDefaultPodPlacementConfigSnapshot()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("enable-summary", false, "")
+		fs.Bool("leader-elect", false, "")
+		fs.String("metrics-bind-address", ":8080", "")
+		Expect(fs.Parse([]string{"--enable-summary"})).To(Succeed())
+		RecordFeatures(fs, false)
+		DeferCleanup(func() { RecordFeatures(flag.NewFlagSet("test", flag.ContinueOnError), false) })
+
+		Expect(featureValue("enable-summary")).To(Equal(1.))
+		Expect(featureValue("leader-elect")).To(Equal(0.))
+		Expect(recordedFeatures(nil, SummaryModeActive)).NotTo(HaveKey("metrics-bind-address"))
+		Expect(featureValue("mode=Active")).To(Equal(1.))
+	})
+
+	It("should report the mode of the clusters without the pod scheduling gates", func() {
+		RecordFeatures(flag.NewFlagSet("test", flag.ContinueOnError), true)
+		DeferCleanup(func() { RecordFeatures(flag.NewFlagSet("test", flag.ContinueOnError), false) })
+		Expect(featureValue("mode=SchedulingGatesUnsupported")).To(Equal(1.))
+		Expect(featureValue("mode=Active")).To(Equal(0.))
+	})
+
+	It("should export the toggles of the PodPlacementConfig objects when they change", func() {
+		ctx := context.Background()
+		optOut := false
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			&multiarchv1alpha1.PodPlacementConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+				Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+					PinToInspectedDigest: true,
+					PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+						PlacementMode: multiarchv1alpha1.PlacementModePreferred,
+						OptOut:        &optOut,
+					},
+				},
+			},
+			&multiarchv1alpha1.PodPlacementConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "b"},
+				Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+					Admission: &multiarchv1alpha1.AdmissionSettings{DenyImpossiblePods: true},
+					PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+						PlacementMode: multiarchv1alpha1.PlacementModeRequired,
+						FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+					},
+				},
+			},
+		).Build()
+		snapshot := NewPodPlacementConfigSnapshot(nil, c)
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		Expect(featureValue("pinToInspectedDigest")).To(Equal(1.))
+		Expect(featureValue("admission.denyImpossiblePods")).To(Equal(1.))
+		Expect(featureValue("paused")).To(Equal(0.))
+		Expect(featureValue("optOut")).To(Equal(0.))
+		// the first PodPlacementConfig setting a field wins
+		Expect(featureValue("placementMode=Preferred")).To(Equal(1.))
+		Expect(featureValue("placementMode=Required")).To(Equal(0.))
+		Expect(featureValue("failurePolicy=Fail")).To(Equal(1.))
+		Expect(featureValue("failurePolicy=Ignore")).To(Equal(0.))
+
+		Expect(c.Delete(ctx, &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "a"}})).
+			To(Succeed())
+		Expect(c.Create(ctx, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "incident"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Paused: true},
+		})).To(Succeed())
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		Expect(featureValue("pinToInspectedDigest")).To(Equal(0.))
+		Expect(featureValue("paused")).To(Equal(1.))
+		Expect(featureValue("mode=Paused")).To(Equal(1.))
+		Expect(featureValue("placementMode=Required")).To(Equal(1.))
+	})
+
+	It("should export the default placement settings without PodPlacementConfig objects", func() {
+		NewDefaultPodPlacementConfigSnapshot()
+		Expect(featureValue("placementMode=Required")).To(Equal(1.))
+		Expect(featureValue("failurePolicy=Ignore")).To(Equal(1.))
+		Expect(featureValue("paused")).To(Equal(0.))
+	})
+})
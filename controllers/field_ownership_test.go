@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const gitOpsFieldManager = "argocd-controller"
+
+var _ = Describe("The ownership of the node affinity", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	// appliedPod returns the configuration of the pod applied by a GitOps controller, without the node affinity
+	appliedPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace, Labels: map[string]string{
+				"app.kubernetes.io/instance": "app",
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "quay.io/org/app:latest"}}},
+		}
+	}
+
+	BeforeEach(func() {
+		if k8sClient == nil {
+			Skip("the test environment is not available: KUBEBUILDER_ASSETS is not set")
+		}
+		ctx = context.Background()
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "field-ownership-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, context.Background(), ns)
+		namespace = ns.Name
+	})
+
+	It("should keep the node affinity when a GitOps controller applies the pod again", func() {
+		// the pod is created gated, as by the webhook, and the GitOps controller takes the ownership of its fields
+		pod := appliedPod()
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "amd64,arm64"}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: schedulingGateName}}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		Expect(k8sClient.Patch(ctx, appliedPod(), client.Apply, client.FieldOwner(gitOpsFieldManager),
+			client.ForceOwnership)).To(Succeed())
+
+		r := &PodReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		expectedAffinity := getPod(k8sClient, pod).Spec.Affinity
+		Expect(expectedAffinity).NotTo(BeNil())
+
+		// the GitOps controller reconciles the pod with its configuration, not declaring the node affinity
+		Expect(k8sClient.Patch(ctx, appliedPod(), client.Apply, client.FieldOwner(gitOpsFieldManager),
+			client.ForceOwnership)).To(Succeed())
+		placed := getPod(k8sClient, pod)
+		Expect(placed.Spec.Affinity).To(Equal(expectedAffinity))
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		var owned bool
+		for _, entry := range placed.ManagedFields {
+			if entry.Manager == FieldManager && entry.FieldsV1 != nil &&
+				strings.Contains(string(entry.FieldsV1.Raw), `"f:nodeAffinity"`) {
+				owned = true
+			}
+		}
+		Expect(owned).To(BeTrue(), "the node affinity is not owned by %s: %v", FieldManager, placed.ManagedFields)
+
+		// the GitOps controller declaring its own terms conflicts with the operator's ones
+		conflicting := appliedPod()
+		conflicting.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key: "node-role.kubernetes.io/worker", Operator: corev1.NodeSelectorOpExists,
+				}},
+			}}},
+		}}
+		err = k8sClient.Patch(ctx, conflicting, client.Apply, client.FieldOwner(gitOpsFieldManager))
+		Expect(apierrors.IsConflict(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(getPod(k8sClient, pod).Spec.Affinity).To(Equal(expectedAffinity))
+	})
+})
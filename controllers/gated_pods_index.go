@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// GatedPodsIndex counts the pods gated by the Instance in each namespace, as reported by the informer of the pods of
+// the manager cache, so that the webhook can cap the gated pods of a namespace without listing them. The counts lag
+// the admissions by the latency of the informer and are zero until it is started.
+// It implements the manager.Runnable interface and is expected to be added to the manager.
+type GatedPodsIndex struct {
+	informers cache.Informers
+	instance  *Instance
+	// gated are the gated pods, by namespace
+	gated map[string]sets.Set[types.UID]
+	// mutex is used to protect the gated map from concurrent access
+	mutex sync.RWMutex
+}
+
+// NewGatedPodsIndex returns a GatedPodsIndex of the pods gated by the instance, watched through the informers, e.g.,
+// the cache of the manager
+func NewGatedPodsIndex(informers cache.Informers, instance *Instance) *GatedPodsIndex {
+	return &GatedPodsIndex{
+		informers: informers,
+		instance:  instance,
+		gated:     map[string]sets.Set[types.UID]{},
+	}
+}
+
+// Start registers the index with the informer of the pods. It returns once registered: the informer keeps it updated
+// until the manager stops.
+func (x *GatedPodsIndex) Start(ctx context.Context) error {
+	informer, err := x.informers.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { x.update(obj) },
+		UpdateFunc: func(_, obj interface{}) { x.update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				x.remove(pod)
+			}
+		},
+	})
+	return err
+}
+
+// NeedLeaderElection returns false: the webhook using the index is served by every replica of the operator.
+func (x *GatedPodsIndex) NeedLeaderElection() bool {
+	return false
+}
+
+// update indexes the pod if it is gated, or removes it from the index otherwise
+func (x *GatedPodsIndex) update(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if !x.instance.hasSchedulingGate(pod) {
+		x.remove(pod)
+		return
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.gated[pod.Namespace] == nil {
+		x.gated[pod.Namespace] = sets.New[types.UID]()
+	}
+	x.gated[pod.Namespace].Insert(pod.UID)
+}
+
+func (x *GatedPodsIndex) remove(pod *corev1.Pod) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	gated, ok := x.gated[pod.Namespace]
+	if !ok {
+		return
+	}
+	gated.Delete(pod.UID)
+	if gated.Len() == 0 {
+		delete(x.gated, pod.Namespace)
+	}
+}
+
+// count returns the number of the gated pods of the namespace. It is zero on the nil GatedPodsIndex.
+func (x *GatedPodsIndex) count(namespace string) int {
+	if x == nil {
+		return 0
+	}
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return x.gated[namespace].Len()
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// handlerRecordingInformer is a fake informer, returned for all the objects, recording its last event handler, e.g.,
+// to notify the deletions missed by the informer
+type handlerRecordingInformer struct {
+	cache.Informers
+	*controllertest.FakeInformer
+	handler toolscache.ResourceEventHandler
+}
+
+func (i *handlerRecordingInformer) AddEventHandler(
+	handler toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error) {
+	i.handler = handler
+	return i.FakeInformer.AddEventHandler(handler)
+}
+
+func (i *handlerRecordingInformer) GetInformer(context.Context, client.Object) (cache.Informer, error) {
+	return i, nil
+}
+
+var _ = Describe("The cap of the gated pods of the namespaces", func() {
+	var (
+		informer *handlerRecordingInformer
+		index    *GatedPodsIndex
+		webhook  *PodSchedulingGateMutatingWebHook
+	)
+
+	BeforeEach(func() {
+		informer = &handlerRecordingInformer{FakeInformer: &controllertest.FakeInformer{Synced: true}}
+		index = NewGatedPodsIndex(informer, nil)
+		Expect(index.Start(context.Background())).To(Succeed())
+		scheme := newTestScheme()
+		webhook = &PodSchedulingGateMutatingWebHook{
+			Client:                   fake.NewClientBuilder().WithScheme(scheme).Build(),
+			GatedPodsIndex:           index,
+			MaxGatedPodsPerNamespace: 3,
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		DeferCleanup(gatedPodsCapExceeded.Reset)
+	})
+
+	// admit admits the pod, returning the pod as gated by the webhook, or nil if it was not gated, and the warnings
+	admit := func(namespace, name string) (*corev1.Pod, []string) {
+		pod := podWithImages(name, "quay.io/org/app:v1")
+		pod.Namespace = namespace
+		pod.UID = types.UID(namespace + "/" + name)
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		for _, operation := range response.Patches {
+			if operation.Path == "/spec/schedulingGates" {
+				patchedValue(response, "/spec/schedulingGates", &pod.Spec.SchedulingGates)
+				return pod, response.Warnings
+			}
+		}
+		return nil, response.Warnings
+	}
+
+	It("should stop gating the pods of a namespace with a backlog until it drains", func() {
+		var backlog []*corev1.Pod
+		for _, name := range []string{"a", "b", "c"} {
+			pod, warnings := admit("test", name)
+			Expect(pod).NotTo(BeNil())
+			Expect(warnings).To(BeEmpty())
+			informer.Add(pod)
+			backlog = append(backlog, pod)
+		}
+		Expect(index.count("test")).To(Equal(3))
+
+		pod, warnings := admit("test", "d")
+		Expect(pod).To(BeNil())
+		Expect(warnings).To(ConsistOf(ContainSubstring("the namespace has 3 gated pods or more")))
+		Expect(counterValue(gatedPodsCapExceeded, "test")).To(Equal(1.0))
+		pod, _ = admit("other", "a")
+		Expect(pod).NotTo(BeNil())
+
+		// the placed pods leave the backlog
+		placed := backlog[0].DeepCopy()
+		placed.Spec.SchedulingGates = nil
+		informer.Update(backlog[0], placed)
+		Expect(index.count("test")).To(Equal(2))
+		pod, warnings = admit("test", "e")
+		Expect(pod).NotTo(BeNil())
+		Expect(warnings).To(BeEmpty())
+		informer.Add(pod)
+		pod, _ = admit("test", "f")
+		Expect(pod).To(BeNil())
+		Expect(counterValue(gatedPodsCapExceeded, "test")).To(Equal(2.0))
+
+		// so do the deleted ones, including the deletions missed by the informer
+		informer.Delete(backlog[1])
+		informer.handler.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "test/c", Obj: backlog[2]})
+		Expect(index.count("test")).To(Equal(1))
+		pod, _ = admit("test", "g")
+		Expect(pod).NotTo(BeNil())
+	})
+
+	It("should not cap the namespaces without a maximum", func() {
+		webhook.MaxGatedPodsPerNamespace = 0
+		for _, name := range []string{"a", "b", "c", "d"} {
+			pod, _ := admit("test", name)
+			Expect(pod).NotTo(BeNil())
+			informer.Add(pod)
+		}
+		Expect(index.count("test")).To(Equal(4))
+	})
+})
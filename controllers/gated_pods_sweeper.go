@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	sweepTriggerPeriodic      = "periodic"
+	sweepTriggerPullSecret    = "pull_secret"
+	sweepTriggerRegistryCerts = "registry_certs"
+	sweepTriggerStartup       = "startup"
+
+	// gatedAtRepairAdded is the direction of the repairs adding the gatedAtAnnotation annotation to the gated pods
+	// missing it
+	gatedAtRepairAdded = "added"
+	// gatedAtRepairRemoved is the direction of the repairs removing the gatedAtAnnotation annotation from the pods
+	// without the scheduling gate
+	gatedAtRepairRemoved = "removed"
+)
+
+// GatedPodsSweeper requeues the gated pods, e.g., the ones kept gated by the Fail failure policy, so that they do not
+// wait for their workqueue backoff, up to several minutes, to be placed once their images can be inspected. Every
+// interval, it requeues the pods gated for longer than minAge. It also requeues all the gated pods as soon as the
+// global pull secret or the registry certificates change, implementing both the image.PullSecretObserver and the
+// system_config.RegistryCertsObserver interfaces.
+// Every sweep also repairs the drift between the scheduling gate of the pods and their gatedAtAnnotation annotation,
+// set together by the webhook and removed together by the reconciler, but editable by the users, see repairGatedAt.
+// The requests are sent through the channel returned by Events: the pods are added to the workqueue of the
+// PodReconciler right away, ahead of the ones waiting for their backoff to elapse.
+// It implements the manager.Runnable interface.
+type GatedPodsSweeper struct {
+	client   client.Client
+	interval time.Duration
+	minAge   time.Duration
+	// instance is the instance whose gated pods are requeued
+	instance *Instance
+	clock    clock.WithTicker
+	// triggers carries the configuration changes requesting a sweep. It has a buffer of one: a pending sweep covers
+	// any later change, as it lists the pods at the time it runs.
+	triggers chan string
+	events   chan event.GenericEvent
+}
+
+func NewGatedPodsSweeper(c client.Client, instance *Instance, interval time.Duration,
+	minAge time.Duration) *GatedPodsSweeper {
+	return &GatedPodsSweeper{
+		client:   c,
+		instance: instance,
+		interval: interval,
+		minAge:   minAge,
+		clock:    clock.RealClock{},
+		triggers: make(chan string, 1),
+		events:   make(chan event.GenericEvent),
+	}
+}
+
+// Events returns the channel of the gated pods to requeue
+func (s *GatedPodsSweeper) Events() <-chan event.GenericEvent {
+	return s.events
+}
+
+// OnPullSecretChange queues a sweep of all the gated pods
+func (s *GatedPodsSweeper) OnPullSecretChange() {
+	s.trigger(sweepTriggerPullSecret)
+}
+
+// OnRegistryCertsChange queues a sweep of all the gated pods
+func (s *GatedPodsSweeper) OnRegistryCertsChange() {
+	s.trigger(sweepTriggerRegistryCerts)
+}
+
+func (s *GatedPodsSweeper) trigger(trigger string) {
+	select {
+	case s.triggers <- trigger:
+	default:
+	}
+}
+
+// Start sweeps the gated pods every interval, or the one tuned by the PodPlacementConfig objects, and at every
+// configuration change, until the context is done. When the instance has legacy scheduling gates, all the gated pods
+// are swept at the start too, so that the pods gated by the previous releases of the operator are adopted right after
+// an upgrade.
+func (s *GatedPodsSweeper) Start(ctx context.Context) error {
+	tuning := currentTuning()
+	interval := s.tunedInterval()
+	ticker := s.clock.NewTicker(interval)
+	defer func() { ticker.Stop() }()
+	if len(s.instance.legacySchedulingGateNames()) > 0 {
+		s.sweep(ctx, sweepTriggerStartup, 0)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			s.sweep(ctx, sweepTriggerPeriodic, s.minAge)
+		case trigger := <-s.triggers:
+			s.sweep(ctx, trigger, 0)
+		case <-tuning.changed:
+			tuning = currentTuning()
+			if tuned := s.tunedInterval(); tuned != interval {
+				klog.Infof("Sweeping the gated pods every %v", tuned)
+				interval = tuned
+				ticker.Stop()
+				ticker = s.clock.NewTicker(interval)
+			}
+		}
+	}
+}
+
+func (s *GatedPodsSweeper) tunedInterval() time.Duration {
+	return tunedDuration(s.interval,
+		func(t *multiarchv1alpha1.TuningSettings) *metav1.Duration { return t.GatedPodsSweepInterval })
+}
+
+// NeedLeaderElection returns true: the pods are requeued to the PodReconciler, only running in the leader.
+func (s *GatedPodsSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// sweep requeues the pods gated for at least minAge, exports the age of the oldest gated pod and repairs the
+// gatedAtAnnotation annotation of all the pods
+func (s *GatedPodsSweeper) sweep(ctx context.Context, trigger string, minAge time.Duration) {
+	pods := &corev1.PodList{}
+	if err := s.client.List(ctx, pods); err != nil {
+		klog.Warningf("Unable to list the gated pods to requeue them: %v", err)
+		return
+	}
+	gatedPodsSweeps.WithLabelValues(trigger).Inc()
+	now := s.clock.Now()
+	var oldest time.Duration
+	requeued := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		s.repairGatedAt(ctx, pod)
+		if !s.instance.hasSchedulingGate(pod) {
+			continue
+		}
+		age := now.Sub(pod.CreationTimestamp.Time)
+		if age > oldest {
+			oldest = age
+		}
+		if age < minAge {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case s.events <- event.GenericEvent{Object: pod}:
+		}
+		requeued++
+	}
+	oldestGatedPodAge.Set(oldest.Seconds())
+	gatedPodsRequeued.WithLabelValues(trigger).Add(float64(requeued))
+	klog.V(2).Infof("Requeued %d gated pods after the %s sweep", requeued, trigger)
+}
+
+// repairGatedAt repairs the gatedAtAnnotation annotation of the pod with a single patch, failing on conflicts, when it
+// drifted from its scheduling gate, e.g., when a user edited either:
+//   - a gated pod without the annotation is annotated with its creation time;
+//   - a pod without the scheduling gate has the annotation removed.
+//
+// The pods whose repair fails are repaired by the next sweep.
+func (s *GatedPodsSweeper) repairGatedAt(ctx context.Context, pod *corev1.Pod) {
+	key := s.instance.annotation(gatedAtAnnotation)
+	_, annotated := pod.Annotations[key]
+	original := pod.DeepCopy()
+	var direction string
+	switch gated := s.instance.hasSchedulingGate(pod); {
+	case gated && !annotated:
+		s.instance.markGatedAt(pod, pod.CreationTimestamp.Time)
+		direction = gatedAtRepairAdded
+	case !gated && annotated:
+		delete(pod.Annotations, key)
+		direction = gatedAtRepairRemoved
+	default:
+		return
+	}
+	if err := s.client.Patch(ctx, pod, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}),
+		client.FieldOwner(FieldManager)); err != nil {
+		klog.V(2).Infof("Unable to repair the %s annotation of pod %s/%s: %v", key, pod.Namespace, pod.Name, err)
+		original.DeepCopyInto(pod)
+		return
+	}
+	gatedAtRepairs.WithLabelValues(direction).Inc()
+	klog.V(3).Infof("Repaired the %s annotation of pod %s/%s: %s", key, pod.Namespace, pod.Name, direction)
+}
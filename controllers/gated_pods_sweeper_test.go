@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func counterValue(counter *prometheus.CounterVec, labels ...string) float64 {
+	m := &dto.Metric{}
+	Expect(counter.WithLabelValues(labels...).Write(m)).To(Succeed())
+	return m.GetCounter().GetValue()
+}
+
+var _ = Describe("The gated pods sweeper", func() {
+	var (
+		s         *GatedPodsSweeper
+		fakeClock *clocktesting.FakeClock
+		ctx       context.Context
+		cancel    context.CancelFunc
+	)
+
+	gatedPod := func(name string, age time.Duration) *corev1.Pod {
+		pod := podWithImages(name, "quay.io/org/app:latest")
+		pod.CreationTimestamp = metav1.NewTime(fakeClock.Now().Add(-age))
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: schedulingGateName}}
+		return pod
+	}
+
+	// requeued returns the names of the pods requeued by the next sweep
+	requeued := func() []string {
+		var names []string
+		for {
+			select {
+			case e := <-s.Events():
+				names = append(names, e.Object.GetName())
+			case <-time.After(100 * time.Millisecond):
+				return names
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		placed := podWithImages("placed", "quay.io/org/app:latest")
+		placed.CreationTimestamp = metav1.NewTime(fakeClock.Now().Add(-time.Hour))
+		s = NewGatedPodsSweeper(fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			gatedPod("old", 10*time.Minute), gatedPod("new", time.Minute), placed).Build(), nil, time.Minute,
+			5*time.Minute)
+		s.clock = fakeClock
+		gatedPodsSweeps.Reset()
+		gatedPodsRequeued.Reset()
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Start(ctx)).To(Succeed())
+		}()
+	})
+
+	It("should periodically requeue the pods gated for longer than the minimum age", func() {
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		Expect(requeued()).To(BeEmpty())
+		fakeClock.Step(time.Minute)
+		Expect(requeued()).To(ConsistOf("old"))
+		// the creation timestamps are stored with a precision of one second
+		Expect(gaugeValue(oldestGatedPodAge)).To(BeNumerically("~", (11 * time.Minute).Seconds(), 1))
+		Expect(counterValue(gatedPodsSweeps, sweepTriggerPeriodic)).To(BeNumerically("==", 1))
+		Expect(counterValue(gatedPodsRequeued, sweepTriggerPeriodic)).To(BeNumerically("==", 1))
+
+		fakeClock.Step(4 * time.Minute)
+		Expect(requeued()).To(ConsistOf("old", "new"))
+		Expect(counterValue(gatedPodsRequeued, sweepTriggerPeriodic)).To(BeNumerically("==", 3))
+	})
+
+	It("should sweep at the interval tuned by the PodPlacementConfig objects", func() {
+		DeferCleanup(applyTuning, multiarchv1alpha1.TuningSettings{})
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		applyTuning(multiarchv1alpha1.TuningSettings{GatedPodsSweepInterval: &metav1.Duration{Duration: 10 * time.Second}})
+		Expect(requeued()).To(BeEmpty())
+		fakeClock.Step(10 * time.Second)
+		Expect(requeued()).To(ConsistOf("old"))
+	})
+
+	It("should requeue all the gated pods when the configuration changes", func() {
+		s.OnPullSecretChange()
+		Expect(requeued()).To(ConsistOf("old", "new"))
+		Expect(counterValue(gatedPodsSweeps, sweepTriggerPullSecret)).To(BeNumerically("==", 1))
+		s.OnRegistryCertsChange()
+		Expect(requeued()).To(ConsistOf("old", "new"))
+		Expect(counterValue(gatedPodsRequeued, sweepTriggerRegistryCerts)).To(BeNumerically("==", 2))
+		Expect(counterValue(gatedPodsSweeps, sweepTriggerPeriodic)).To(BeZero())
+	})
+
+	It("should coalesce the configuration changes received during a sweep", func() {
+		s.OnPullSecretChange()
+		// the sweep is blocked on the first requeue until the events are consumed
+		var first event.GenericEvent
+		Eventually(s.Events()).Should(Receive(&first))
+		s.OnRegistryCertsChange()
+		s.OnRegistryCertsChange()
+		Expect(append(requeued(), first.Object.GetName())).To(ConsistOf("old", "new", "old", "new"))
+		Expect(counterValue(gatedPodsSweeps, sweepTriggerRegistryCerts)).To(BeNumerically("==", 1))
+	})
+})
+
+var _ = Describe("The gated-at annotation", func() {
+	var (
+		c         client.Client
+		createdAt metav1.Time
+	)
+
+	annotations := func(name string) map[string]string {
+		pod := &corev1.Pod{}
+		Expect(c.Get(context.Background(), client.ObjectKey{Namespace: "test", Name: name}, pod)).To(Succeed())
+		return pod.Annotations
+	}
+
+	BeforeEach(func() {
+		createdAt = metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		pod := func(name string, gated bool, annotations map[string]string) *corev1.Pod {
+			pod := podWithImages(name, "quay.io/org/app:v1")
+			pod.CreationTimestamp = createdAt
+			pod.Annotations = annotations
+			if gated {
+				pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+			}
+			return pod
+		}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			pod("gated-annotated", true, map[string]string{gatedAtAnnotation: "2023-06-01T10:00:00Z"}),
+			pod("gated", true, nil),
+			pod("ungated-annotated", false, map[string]string{gatedAtAnnotation: "2023-06-01T10:00:00Z"}),
+			pod("ungated", false, nil),
+		).Build()
+		gatedAtRepairs.Reset()
+	})
+
+	Context("when swept", func() {
+		BeforeEach(func() {
+			// the pods are younger than the minimum age: the sweep does not block on their requeue
+			NewGatedPodsSweeper(c, nil, time.Minute, time.Hour).sweep(context.Background(), sweepTriggerPeriodic,
+				24*time.Hour)
+		})
+
+		It("should be kept on the gated pods", func() {
+			Expect(annotations("gated-annotated")).To(HaveKeyWithValue(gatedAtAnnotation, "2023-06-01T10:00:00Z"))
+		})
+
+		It("should be added to the gated pods missing it with their creation time", func() {
+			Expect(annotations("gated")).To(HaveKeyWithValue(gatedAtAnnotation, createdAt.UTC().Format(time.RFC3339)))
+			Expect(counterValue(gatedAtRepairs, gatedAtRepairAdded)).To(BeNumerically("==", 1))
+		})
+
+		It("should be removed from the pods without the scheduling gate", func() {
+			Expect(annotations("ungated-annotated")).NotTo(HaveKey(gatedAtAnnotation))
+			Expect(counterValue(gatedAtRepairs, gatedAtRepairRemoved)).To(BeNumerically("==", 1))
+		})
+
+		It("should not be added to the pods without the scheduling gate", func() {
+			Expect(annotations("ungated")).NotTo(HaveKey(gatedAtAnnotation))
+		})
+	})
+
+	It("should be removed by the reconciler together with the scheduling gate", func() {
+		r := &PodReconciler{Client: c, Inspector: &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "test",
+			Name: "gated-annotated"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(annotations("gated-annotated")).NotTo(HaveKey(gatedAtAnnotation))
+	})
+})
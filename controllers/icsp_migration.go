@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ocpv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+// icspMigrationRequest is the only request of the ICSPMigrationReconciler: the report covers all the
+// ImageContentSourcePolicy objects at once.
+var icspMigrationRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "icsp-migration"}}
+
+// ICSPMigrationReconciler reports the ImageDigestMirrorSet objects equivalent to the ImageContentSourcePolicy objects,
+// to plan their migration, without creating them: the ConfigMap holds one YAML document per ImageContentSourcePolicy,
+// keyed by its name followed by .yaml, and an event on each ImageContentSourcePolicy summarizes the aspects of its
+// translation that are lossy, when its generation is first reported.
+type ICSPMigrationReconciler struct {
+	client.Client
+	// Reader reads the ConfigMap, so that the ConfigMaps are not cached by the manager
+	Reader   client.Reader
+	Recorder record.EventRecorder
+	// ConfigMap is the ConfigMap the report is written to
+	ConfigMap types.NamespacedName
+	// reported are the generations of the ImageContentSourcePolicy objects whose event was recorded, by name
+	reported map[string]int64
+}
+
+//+kubebuilder:rbac:groups=operator.openshift.io,resources=imagecontentsourcepolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile writes the ImageDigestMirrorSet objects equivalent to the ImageContentSourcePolicy objects to the ConfigMap
+func (r *ICSPMigrationReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	icsps := &operatorv1alpha1.ImageContentSourcePolicyList{}
+	if err := r.List(ctx, icsps); err != nil {
+		return ctrl.Result{}, err
+	}
+	data := make(map[string]string, len(icsps.Items))
+	lossyAspects := make(map[string][]string, len(icsps.Items))
+	for i := range icsps.Items {
+		icsp := &icsps.Items[i]
+		if icsp.DeletionTimestamp != nil {
+			continue
+		}
+		idms, lossy := imageDigestMirrorSetOf(icsp)
+		document, err := yaml.Marshal(idms)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to render the ImageDigestMirrorSet of the "+
+				"ImageContentSourcePolicy %s: %w", icsp.Name, err)
+		}
+		data[icsp.Name+".yaml"] = string(document)
+		lossyAspects[icsp.Name] = lossy
+	}
+	if err := r.writeReport(ctx, data); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recordTranslations(icsps.Items, lossyAspects)
+	return ctrl.Result{}, nil
+}
+
+// writeReport creates the ConfigMap with the data, or replaces its data
+func (r *ICSPMigrationReconciler) writeReport(ctx context.Context, data map[string]string) error {
+	cm := &corev1.ConfigMap{}
+	err := r.Reader.Get(ctx, r.ConfigMap, cm)
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: r.ConfigMap.Name, Namespace: r.ConfigMap.Namespace},
+			Data:       data,
+		}
+		klog.V(2).Infof("Creating the ICSP migration report %s", r.ConfigMap)
+		return r.Create(ctx, cm)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get the configmap %s: %w", r.ConfigMap, err)
+	}
+	if equality.Semantic.DeepEqual(cm.Data, data) || (len(cm.Data) == 0 && len(data) == 0) {
+		return nil
+	}
+	cm.Data = data
+	klog.V(2).Infof("Updating the ICSP migration report %s", r.ConfigMap)
+	return r.Update(ctx, cm)
+}
+
+// recordTranslations records an event on the ImageContentSourcePolicy objects whose generation was not reported yet:
+// a Warning listing the lossy aspects of their translation, if any
+func (r *ICSPMigrationReconciler) recordTranslations(icsps []operatorv1alpha1.ImageContentSourcePolicy,
+	lossyAspects map[string][]string) {
+	if r.reported == nil {
+		r.reported = map[string]int64{}
+	}
+	for name := range r.reported {
+		if _, ok := lossyAspects[name]; !ok {
+			delete(r.reported, name)
+		}
+	}
+	for i := range icsps {
+		icsp := &icsps[i]
+		lossy, ok := lossyAspects[icsp.Name]
+		if !ok || r.reported[icsp.Name] == icsp.Generation {
+			continue
+		}
+		r.reported[icsp.Name] = icsp.Generation
+		if len(lossy) == 0 {
+			r.Recorder.Event(icsp, corev1.EventTypeNormal, reasons.IDMSTranslation, versionedEventMessage(
+				fmt.Sprintf("The equivalent ImageDigestMirrorSet is reported in the configmap %s", r.ConfigMap)))
+			continue
+		}
+		r.Recorder.Event(icsp, corev1.EventTypeWarning, reasons.IDMSTranslation, versionedEventMessage(
+			fmt.Sprintf("The equivalent ImageDigestMirrorSet is reported in the configmap %s, with lossy aspects: %s",
+				r.ConfigMap, strings.Join(lossy, "; "))))
+	}
+}
+
+// imageDigestMirrorSetOf returns the ImageDigestMirrorSet equivalent to the ImageContentSourcePolicy, with the same
+// name, and the aspects of the translation that are lossy: the ImageDigestMirrorSet lists each source once, with
+// the mirrors of all its repositoryDigestMirrors entries without duplicates, as its mirrors are a set, and leaves out
+// the sources without mirrors. The mirrorSourcePolicy is left unset: the source can be contacted when the mirrors
+// fail, as with the ImageContentSourcePolicy. The labels of the ImageContentSourcePolicy are not copied.
+func imageDigestMirrorSetOf(icsp *operatorv1alpha1.ImageContentSourcePolicy) (*ocpv1.ImageDigestMirrorSet, []string) {
+	idms := &ocpv1.ImageDigestMirrorSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: ocpv1.GroupVersion.String(), Kind: "ImageDigestMirrorSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: icsp.Name},
+	}
+	var lossy, withoutMirrors []string
+	indexes := map[string]int{}
+	mirrors := map[string]sets.Set[string]{}
+	merged, duplicated := sets.New[string](), sets.New[string]()
+	for _, rdm := range icsp.Spec.RepositoryDigestMirrors {
+		if len(rdm.Mirrors) == 0 {
+			withoutMirrors = append(withoutMirrors, rdm.Source)
+			continue
+		}
+		index, ok := indexes[rdm.Source]
+		if !ok {
+			index = len(idms.Spec.ImageDigestMirrors)
+			indexes[rdm.Source] = index
+			mirrors[rdm.Source] = sets.New[string]()
+			idms.Spec.ImageDigestMirrors = append(idms.Spec.ImageDigestMirrors, ocpv1.ImageDigestMirrors{
+				Source: rdm.Source})
+		} else {
+			merged.Insert(rdm.Source)
+		}
+		for _, mirror := range rdm.Mirrors {
+			if mirrors[rdm.Source].Has(mirror) {
+				duplicated.Insert(rdm.Source)
+				continue
+			}
+			mirrors[rdm.Source].Insert(mirror)
+			idms.Spec.ImageDigestMirrors[index].Mirrors = append(idms.Spec.ImageDigestMirrors[index].Mirrors,
+				ocpv1.ImageMirror(mirror))
+		}
+	}
+	if merged.Len() > 0 {
+		lossy = append(lossy, "the entries of the sources "+strings.Join(sets.List(merged), ",")+" are merged")
+	}
+	if duplicated.Len() > 0 {
+		lossy = append(lossy, "the duplicate mirrors of the sources "+strings.Join(sets.List(duplicated), ",")+
+			" are removed")
+	}
+	if len(withoutMirrors) > 0 {
+		lossy = append(lossy, "the sources without mirrors "+strings.Join(withoutMirrors, ",")+" are left out")
+	}
+	if len(icsp.Labels) > 0 {
+		lossy = append(lossy, "the labels are not copied")
+	}
+	return idms, lossy
+}
+
+// SetupWithManager sets up the controller with the Manager. All the events of the ImageContentSourcePolicy objects
+// refresh the report.
+func (r *ICSPMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("icsp-migration").
+		Watches(&operatorv1alpha1.ImageContentSourcePolicy{}, handler.EnqueueRequestsFromMapFunc(
+			func(context.Context, client.Object) []reconcile.Request {
+				return []reconcile.Request{icspMigrationRequest}
+			})).
+		Complete(r)
+}
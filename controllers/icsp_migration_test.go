@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ocpv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("The ICSP migration report", func() {
+	var (
+		ctx        context.Context
+		c          client.Client
+		recorder   *record.FakeRecorder
+		reconciler *ICSPMigrationReconciler
+		report     = types.NamespacedName{Namespace: "openshift-multiarch-operator", Name: "icsp-migration"}
+	)
+
+	icsp := func(name string, generation int64, mirrors ...operatorv1alpha1.RepositoryDigestMirrors) client.Object {
+		return &operatorv1alpha1.ImageContentSourcePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Generation: generation},
+			Spec:       operatorv1alpha1.ImageContentSourcePolicySpec{RepositoryDigestMirrors: mirrors},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := newTestScheme()
+		Expect(operatorv1alpha1.Install(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			icsp("release", 1, operatorv1alpha1.RepositoryDigestMirrors{Source: "quay.io/openshift-release-dev/ocp-release",
+				Mirrors: []string{"mirror.example.com/ocp-release"}}),
+		).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &ICSPMigrationReconciler{Client: c, Reader: c, Recorder: recorder, ConfigMap: report}
+	})
+
+	reconcileReport := func() map[string]string {
+		_, err := reconciler.Reconcile(ctx, icspMigrationRequest)
+		Expect(err).NotTo(HaveOccurred())
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, report, cm)).To(Succeed())
+		return cm.Data
+	}
+
+	documentOf := func(data map[string]string, key string) *ocpv1.ImageDigestMirrorSet {
+		Expect(data).To(HaveKey(key))
+		idms := &ocpv1.ImageDigestMirrorSet{}
+		Expect(yaml.UnmarshalStrict([]byte(data[key]), idms)).To(Succeed())
+		return idms
+	}
+
+	It("should report the equivalent ImageDigestMirrorSet of each ImageContentSourcePolicy", func() {
+		idms := documentOf(reconcileReport(), "release.yaml")
+		Expect(idms.APIVersion).To(Equal("config.openshift.io/v1"))
+		Expect(idms.Kind).To(Equal("ImageDigestMirrorSet"))
+		Expect(idms.Name).To(Equal("release"))
+		Expect(idms.Spec.ImageDigestMirrors).To(Equal([]ocpv1.ImageDigestMirrors{{
+			Source:  "quay.io/openshift-release-dev/ocp-release",
+			Mirrors: []ocpv1.ImageMirror{"mirror.example.com/ocp-release"},
+		}}))
+		Expect(recorder.Events).To(Receive(And(HavePrefix(corev1.EventTypeNormal),
+			ContainSubstring(reasons.IDMSTranslation))))
+	})
+
+	It("should report the lossy aspects of the translation", func() {
+		Expect(c.Create(ctx, icsp("lossy", 1,
+			operatorv1alpha1.RepositoryDigestMirrors{Source: "quay.io/org/app",
+				Mirrors: []string{"mirror.example.com/app", "mirror.example.com/app"}},
+			operatorv1alpha1.RepositoryDigestMirrors{Source: "docker.io/library/busybox"},
+			operatorv1alpha1.RepositoryDigestMirrors{Source: "quay.io/org/app",
+				Mirrors: []string{"backup.example.com/app", "mirror.example.com/app"}},
+		))).To(Succeed())
+		idms := documentOf(reconcileReport(), "lossy.yaml")
+		Expect(idms.Spec.ImageDigestMirrors).To(Equal([]ocpv1.ImageDigestMirrors{{
+			Source:  "quay.io/org/app",
+			Mirrors: []ocpv1.ImageMirror{"mirror.example.com/app", "backup.example.com/app"},
+		}}))
+		events := []string{<-recorder.Events, <-recorder.Events}
+		Expect(events).To(ContainElement(And(HavePrefix(corev1.EventTypeWarning),
+			ContainSubstring("the entries of the sources quay.io/org/app are merged"),
+			ContainSubstring("the duplicate mirrors of the sources quay.io/org/app are removed"),
+			ContainSubstring("the sources without mirrors docker.io/library/busybox are left out"))))
+	})
+
+	It("should refresh the report and only record the events of the new generations", func() {
+		reconcileReport()
+		Expect(recorder.Events).To(Receive())
+
+		Expect(c.Create(ctx, icsp("app", 1, operatorv1alpha1.RepositoryDigestMirrors{Source: "quay.io/org/app",
+			Mirrors: []string{"mirror.example.com/app"}}))).To(Succeed())
+		Expect(reconcileReport()).To(HaveLen(2))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasons.IDMSTranslation)))
+		Expect(recorder.Events).NotTo(Receive())
+
+		Expect(c.Delete(ctx, icsp("release", 1))).To(Succeed())
+		data := reconcileReport()
+		Expect(data).To(HaveLen(1))
+		Expect(data).To(HaveKey("app.yaml"))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should leave the report unchanged when the ImageContentSourcePolicy objects are unchanged", func() {
+		reconcileReport()
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, report, cm)).To(Succeed())
+		reconcileReport()
+		unchanged := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, report, unchanged)).To(Succeed())
+		Expect(unchanged.ResourceVersion).To(Equal(cm.ResourceVersion))
+	})
+})
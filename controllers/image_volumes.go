@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// imageVolume is a volume of a pod mounting an OCI image or artifact, see KEP-4639. The kubelet pulls its image for
+// the architecture of the node, as the ones of the containers.
+type imageVolume struct {
+	name      string
+	reference string
+}
+
+// rawPodImageVolumes is the part of a pod declaring its image volumes. The image volume source, added by Kubernetes
+// 1.31, is missing from the API types of the operator: the volumes are decoded from the raw object, and the pods of
+// the clusters without the feature simply have none.
+type rawPodImageVolumes struct {
+	Spec struct {
+		Volumes []struct {
+			Name  string `json:"name"`
+			Image *struct {
+				Reference string `json:"reference"`
+			} `json:"image,omitempty"`
+		} `json:"volumes"`
+	} `json:"spec"`
+}
+
+// decodeImageVolumes returns the image volumes of the raw pod, in the order they are declared. The volumes without a
+// reference are ignored: the API server rejects them.
+func decodeImageVolumes(raw []byte) ([]imageVolume, error) {
+	pod := &rawPodImageVolumes{}
+	if err := json.Unmarshal(raw, pod); err != nil {
+		return nil, err
+	}
+	var volumes []imageVolume
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Image != nil && volume.Image.Reference != "" {
+			volumes = append(volumes, imageVolume{name: volume.Name, reference: volume.Image.Reference})
+		}
+	}
+	return volumes, nil
+}
+
+// formatImageVolumes returns the value of the imageVolumesAnnotation annotation for the volumes, e.g.,
+// "models=quay.io/org/models:v1,data=quay.io/org/data:v2". The names of the volumes and the image references contain
+// neither commas nor equal signs.
+func formatImageVolumes(volumes []imageVolume) string {
+	entries := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		entries = append(entries, volume.name+"="+volume.reference)
+	}
+	return strings.Join(entries, ",")
+}
+
+// setImageVolumes records the image volumes in the imageVolumesAnnotation annotation of the instance, or removes the
+// annotation if there are none, e.g., when set by the creator of the pod
+func (i *Instance) setImageVolumes(pod *corev1.Pod, volumes []imageVolume) {
+	if len(volumes) == 0 {
+		delete(pod.Annotations, i.annotation(imageVolumesAnnotation))
+		return
+	}
+	setPodAnnotation(pod, i.annotation(imageVolumesAnnotation), formatImageVolumes(volumes))
+}
+
+// imageVolumes returns the image volumes recorded by the imageVolumesAnnotation annotation of the instance. The
+// malformed entries are ignored.
+func (i *Instance) imageVolumes(pod *corev1.Pod) []imageVolume {
+	value := pod.Annotations[i.annotation(imageVolumesAnnotation)]
+	if value == "" {
+		return nil
+	}
+	var volumes []imageVolume
+	for _, entry := range strings.Split(value, ",") {
+		name, reference, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || reference == "" {
+			klog.V(4).Infof("Ignoring the malformed image volume %q of pod %s/%s", entry, pod.Namespace, pod.Name)
+			continue
+		}
+		volumes = append(volumes, imageVolume{name: name, reference: reference})
+	}
+	return volumes
+}
+
+// podImageReferences returns the references of the images of the pod as declared: the ones of its containers, init
+// containers included, and the ones of its image volumes recorded by the instance
+func (i *Instance) podImageReferences(pod *corev1.Pod) []string {
+	var references []string
+	for _, container := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
+		references = append(references, container.Image)
+	}
+	for _, volume := range i.imageVolumes(pod) {
+		references = append(references, volume.reference)
+	}
+	return references
+}
+
+// imageUsers are the containers and the image volumes of a pod using the same image
+type imageUsers struct {
+	containers []string
+	volumes    []string
+}
+
+// String describes the users, e.g., "the containers app, sidecar and the image volumes models"
+func (u imageUsers) String() string {
+	var descriptions []string
+	if len(u.containers) > 0 {
+		descriptions = append(descriptions, "the containers "+strings.Join(u.containers, ", "))
+	}
+	if len(u.volumes) > 0 {
+		descriptions = append(descriptions, "the image volumes "+strings.Join(u.volumes, ", "))
+	}
+	return strings.Join(descriptions, " and ")
+}
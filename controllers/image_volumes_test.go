@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// rawPodWithImageVolumes returns the raw pod with the images, declaring an image volume for each of the references,
+// named after the last element of its repository
+func rawPodWithImageVolumes(pod *corev1.Pod, references ...string) []byte {
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+	object := map[string]interface{}{}
+	Expect(json.Unmarshal(raw, &object)).To(Succeed())
+	var volumes []interface{}
+	for _, reference := range references {
+		repository, _, _ := strings.Cut(reference[strings.LastIndex(reference, "/")+1:], ":")
+		volumes = append(volumes, map[string]interface{}{
+			"name":  repository,
+			"image": map[string]interface{}{"reference": reference, "pullPolicy": "IfNotPresent"},
+		})
+	}
+	object["spec"].(map[string]interface{})["volumes"] = volumes
+	raw, err = json.Marshal(object)
+	Expect(err).NotTo(HaveOccurred())
+	return raw
+}
+
+var _ = Describe("The image volumes", func() {
+	It("should be decoded from the raw pods, ignoring the other volumes", func() {
+		raw := []byte(`{"spec":{"volumes":[{"name":"cache","emptyDir":{}},` +
+			`{"name":"models","image":{"reference":"quay.io/org/models:v1"}}]}}`)
+		Expect(decodeImageVolumes(raw)).To(Equal([]imageVolume{{name: "models", reference: "quay.io/org/models:v1"}}))
+		Expect(decodeImageVolumes([]byte(`{"spec":{}}`))).To(BeEmpty())
+	})
+
+	It("should be recorded by the webhook without removing them from the pods", func() {
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c, PodPlacementConfigs: NewDefaultPodPlacementConfigSnapshot()}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object: runtime.RawExtension{Raw: rawPodWithImageVolumes(podWithImages("pod", "quay.io/org/app:v1"),
+					"quay.io/org/models:v1")},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		for _, operation := range response.Patches {
+			Expect(operation.Path).NotTo(HavePrefix("/spec/volumes"))
+		}
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveKeyWithValue(imageVolumesAnnotation, "models=quay.io/org/models:v1"))
+	})
+
+	It("should not be recorded on the pods the webhook does not gate", func() {
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		snapshot := NewPodPlacementConfigSnapshot(nil, c)
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c, PodPlacementConfigs: snapshot}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object: runtime.RawExtension{Raw: rawPodWithImageVolumes(podWithImages("pod", "quay.io/org/app:v1"),
+					"quay.io/org/models:v1")},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("should not let the pods using untrusted images in their image volumes skip the gating", func() {
+		prefixes := []trustedPrefix{{domain: "registry.access.redhat.com"}}
+		pod := podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122")
+		Expect(imagesMatchTrustedPrefixes(nil, pod, prefixes)).To(BeTrue())
+		pod.Annotations = map[string]string{imageVolumesAnnotation: "models=quay.io/org/models:v1"}
+		Expect(imagesMatchTrustedPrefixes(nil, pod, prefixes)).To(BeFalse())
+	})
+
+	Context("when the pods are reconciled", func() {
+		var (
+			c             client.Client
+			architectures *fakeArchitectures
+			recorder      *record.FakeRecorder
+			reconciler    *PodReconciler
+		)
+
+		BeforeEach(func() {
+			// the updates would drop the image volume sources
+			c = fake.NewClientBuilder().WithScheme(newTestScheme()).WithInterceptorFuncs(interceptor.Funcs{
+				Update: func(context.Context, client.WithWatch, client.Object, ...client.UpdateOption) error {
+					return errors.New("the pods with image volumes must be patched")
+				},
+			}).WithObjects(
+				namespacePlacementPolicy("policy", time.Now(), multiarchv1alpha1.PlacementPolicy{
+					FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+				})).Build()
+			architectures = &fakeArchitectures{registry: map[string][]string{
+				"//quay.io/org/app:v1":    {"amd64", "arm64"},
+				"//quay.io/org/models:v1": {"arm64"},
+			}}
+			recorder = record.NewFakeRecorder(10)
+			reconciler = &PodReconciler{Client: c, Inspector: architectures, Recorder: recorder}
+		})
+
+		createGated := func(images string, volumes string) *corev1.Pod {
+			pod := podWithImages("pod", images)
+			pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+			pod.Annotations = map[string]string{imageVolumesAnnotation: volumes}
+			Expect(c.Create(context.Background(), pod)).To(Succeed())
+			return pod
+		}
+
+		It("should place the pods on the architectures supported by the images of their image volumes too", func() {
+			pod := createGated("quay.io/org/app:v1", "models=quay.io/org/models:v1,weights=quay.io/org/app:v1")
+			reconcilePods(reconciler, pod)
+			placed := getPod(c, pod)
+			Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+			Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+				Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+			// the image shared by the container and the weights volume is inspected once
+			Expect(architectures.inspections).To(Equal(2))
+			Expect(recorder.Events).To(Receive(ContainSubstring(
+				"with the images of the image volumes models=quay.io/org/models:v1,weights=quay.io/org/app:v1")))
+		})
+
+		It("should name the image volumes whose image cannot be inspected", func() {
+			pod := createGated("quay.io/org/app:v1", "data=quay.io/org/missing:v1")
+			_, err := reconciler.Reconcile(context.Background(),
+				ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).To(MatchError(ContainSubstring(
+				"the image //quay.io/org/missing:v1 of the image volumes data cannot be inspected")))
+		})
+	})
+})
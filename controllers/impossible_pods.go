@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// impossiblePod is the outcome of the check of the architectures of the cached images of a pod against the
+// architectures of the cluster
+type impossiblePod struct {
+	// message explains why the pod cannot run on any node of the cluster. It is empty when the pod can, or when it is
+	// not known.
+	message string
+	// allCached is true when the architectures of all the images of the pod are cached, i.e., the pod is known to be
+	// impossible to run
+	allCached bool
+}
+
+// checkImpossible returns whether the architectures supported by the cached images of the pod intersect the
+// architectures of the schedulable nodes of the cluster, read from the cache of the manager, and of the scalable
+// MachineSets when the PodPlacementConfig objects consider them. Nothing is reported for the pods declaring their
+// architectures, the pods without cached images, or when the architectures of the cluster are not known.
+func (a *PodSchedulingGateMutatingWebHook) checkImpossible(ctx context.Context, pod *corev1.Pod,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) impossiblePod {
+	if a.ArchitecturesCache == nil || a.Instance.hasArchitecturesOverride(pod) {
+		return impossiblePod{}
+	}
+	var supported sets.Set[string]
+	var cached []string
+	allCached := true
+	for imageName := range podImageNames(a.Instance, pod) {
+		result, ok := a.ArchitecturesCache.Cached(imageName)
+		if !ok {
+			allCached = false
+			continue
+		}
+		cached = append(cached, imageName)
+		supported = intersectArchitectures(supported, result.Architectures())
+	}
+	if len(cached) == 0 {
+		return impossiblePod{}
+	}
+	cluster, ok := a.clusterArchitectures(ctx, podPlacementConfigs)
+	if !ok || supported.Intersection(cluster).Len() > 0 {
+		return impossiblePod{}
+	}
+	message := fmt.Sprintf("the images %s support the architectures [%s], but the cluster only has nodes of the "+
+		"architectures [%s]", strings.Join(sets.List(sets.New(cached...)), ", "),
+		strings.Join(sets.List(supported), ", "), strings.Join(sets.List(cluster), ", "))
+	klog.V(3).Infof("Pod %s/%s cannot run on any node: %s", pod.Namespace, pod.Name, message)
+	core.DebugLog(ctx, "The pod cannot run on any node: %s", message)
+	return impossiblePod{message: message, allCached: allCached}
+}
+
+// clusterArchitectures returns the architectures of the schedulable nodes and, when the PodPlacementConfig objects
+// consider the autoscaled capacity, of the scalable MachineSets. ok is false when they are not known, e.g., when no
+// node is schedulable yet or the MachineSets are not read.
+func (a *PodSchedulingGateMutatingWebHook) clusterArchitectures(ctx context.Context,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (architectures sets.Set[string], ok bool) {
+	architectures, err := clusterArchitectures(ctx, a.Client, a.CapacityCache, podPlacementConfigs)
+	if err != nil {
+		klog.Warningf("Unable to list the nodes to check the architectures of the cluster: %v", err)
+		return nil, false
+	}
+	return architectures, architectures.Len() > 0
+}
+
+// clusterArchitectures returns the architectures of the schedulable nodes and, when the PodPlacementConfig objects
+// consider the autoscaled capacity, of the scalable MachineSets provisionable according to the capacity cache. It is
+// empty when they are not known, i.e., when no node is schedulable yet or the MachineSets are not read.
+func clusterArchitectures(ctx context.Context, c client.Reader, capacity *ArchitectureCapacityCache,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (sets.Set[string], error) {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+	architectures := sets.New[string]()
+	for i := range nodes.Items {
+		if arch, ok := schedulableNodeArchitecture(&nodes.Items[i]); ok {
+			architectures.Insert(arch)
+		}
+	}
+	if considersAutoscaledCapacity(podPlacementConfigs) {
+		if capacity == nil {
+			return sets.New[string](), nil
+		}
+		architectures = architectures.Union(capacity.provisionableArchitectures())
+	}
+	return architectures, nil
+}
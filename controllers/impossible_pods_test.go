@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The admission of the impossible pods", func() {
+	var (
+		webhook *PodSchedulingGateMutatingWebHook
+		config  *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	newWebhook := func(objs ...client.Object) {
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs,
+			nodeWithCapacity("arm64-1", "arm64", "8", "32Gi", false),
+			nodeWithCapacity("s390x-1", "s390x", "8", "32Gi", true))...).Build()
+		webhook = &PodSchedulingGateMutatingWebHook{Client: c, ArchitecturesCache: &fakeArchitectures{
+			cached: map[string][]string{
+				"//quay.io/org/app:v1":     {"amd64"},
+				"//quay.io/org/sidecar:v1": {"amd64", "arm64"},
+			},
+		}}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		config = &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+				Admission: &multiarchv1alpha1.AdmissionSettings{DenyImpossiblePods: true},
+			},
+		}
+	})
+
+	admit := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		return webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+
+	It("should deny the pods whose cached images support none of the architectures of the cluster", func() {
+		newWebhook(config)
+		response := admit(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar:v1"))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring(
+			"the images //quay.io/org/app:v1, //quay.io/org/sidecar:v1 support the architectures [amd64], but " +
+				"the cluster only has nodes of the architectures [arm64]"))
+	})
+
+	It("should gate the pods with a warning when the architectures of some images are not cached", func() {
+		newWebhook(config)
+		response := admit(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/unknown:v1"))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		Expect(response.Warnings).To(ConsistOf(ContainSubstring("the pod might not run on any node of the cluster")))
+	})
+
+	It("should place the pods that can run on the architectures of the cluster", func() {
+		newWebhook(config)
+		response := admit(podWithImages("pod", "quay.io/org/sidecar:v1"))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(BeEmpty())
+	})
+
+	It("should consider the architectures of the scalable MachineSets when requested", func() {
+		config.Spec.ConsiderAutoscaledCapacity = true
+		newWebhook(config)
+		webhook.CapacityCache = &ArchitectureCapacityCache{autoscaled: sets.New("amd64")}
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(BeEmpty())
+	})
+
+	It("should not deny the pods declaring their architectures", func() {
+		newWebhook(config)
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64"}
+		Expect(admit(pod).Allowed).To(BeTrue())
+	})
+
+	It("should admit the impossible pods by default", func() {
+		config.Spec.Admission = nil
+		newWebhook(config)
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(BeEmpty())
+	})
+})
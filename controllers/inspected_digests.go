@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/image"
+)
+
+// formatInspectedDigests returns the value of the inspectedDigestsAnnotation annotation for the digests by normalized
+// reference: the sorted, comma-separated list of the references with their digest. The references already pinned by
+// digest are listed as they are.
+func formatInspectedDigests(digests map[string]string) string {
+	values := make([]string, 0, len(digests))
+	for normalized, digest := range digests {
+		value := strings.TrimPrefix(normalized, "//")
+		if !strings.Contains(value, "@") {
+			value += "@" + digest
+		}
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// pinImagesToInspectedDigests rewrites the images of the containers and the init containers of the pod to their
+// normalized reference with the digest inspected for it, e.g., "nginx" to "docker.io/library/nginx:latest@sha256:<hex>".
+// The tag is kept for traceability, the container runtimes pull the image by digest. The images already referenced by
+// digest and the ones without an inspected digest are not changed.
+func pinImagesToInspectedDigests(ctx context.Context, pod *corev1.Pod, digests map[string]string) {
+	pin := func(containers []corev1.Container) {
+		for i := range containers {
+			normalized, err := image.NormalizeReference(containers[i].Image)
+			if err != nil || strings.Contains(normalized, "@") {
+				continue
+			}
+			digest, ok := digests[normalized]
+			if !ok {
+				continue
+			}
+			pinned := strings.TrimPrefix(normalized, "//") + "@" + digest
+			klog.V(4).Infof("Pinning the image %s of the container %s of pod %s/%s to %s", containers[i].Image,
+				containers[i].Name, pod.Namespace, pod.Name, pinned)
+			core.DebugLog(ctx, "Pinning the image %s of the container %s to the inspected digest: %s",
+				containers[i].Image, containers[i].Name, pinned)
+			containers[i].Image = pinned
+		}
+	}
+	pin(pod.Spec.Containers)
+	pin(pod.Spec.InitContainers)
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("The inspected digests", func() {
+	var (
+		appDigest     = "sha256:" + strings.Repeat("a", 64)
+		sidecarDigest = "sha256:" + strings.Repeat("b", 64)
+		ctx           context.Context
+		c             client.Client
+		recorder      *record.FakeRecorder
+		reconciler    *PodReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		architectures := &fakeArchitectures{
+			registry: map[string][]string{
+				"//quay.io/org/app:v1":                         {"amd64", "arm64"},
+				"//quay.io/org/sidecar@" + sidecarDigest:       {"amd64", "arm64"},
+				"//docker.io/library/busybox:latest":           {"amd64", "arm64", "s390x"},
+				"//quay.io/org/unpinned-without-digest:latest": {"amd64", "arm64"},
+			},
+			digests: map[string]string{
+				"//quay.io/org/app:v1":                   appDigest,
+				"//quay.io/org/sidecar@" + sidecarDigest: sidecarDigest,
+				"//docker.io/library/busybox:latest":     appDigest,
+			},
+		}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &PodReconciler{Client: c, Inspector: architectures, Recorder: recorder}
+	})
+
+	reconcile := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(ctx, pod)).To(Succeed())
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		return getPod(c, pod)
+	}
+
+	It("should be recorded in the annotation and the event of the placed pods, without pinning the images", func() {
+		placed := reconcile(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar@"+sidecarDigest))
+		Expect(placed.Annotations).To(HaveKeyWithValue(inspectedDigestsAnnotation,
+			"quay.io/org/app:v1@"+appDigest+",quay.io/org/sidecar@"+sidecarDigest))
+		Expect(placed.Spec.Containers[0].Image).To(Equal("quay.io/org/app:v1"))
+		Expect(recorder.Events).To(Receive(SatisfyAll(
+			ContainSubstring(reasons.ArchitectureConstrained), ContainSubstring("quay.io/org/app:v1@"+appDigest))))
+	})
+
+	It("should pin the images not referenced by digest when requested", func() {
+		Expect(c.Create(ctx, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{PinToInspectedDigest: true},
+		})).To(Succeed())
+		pod := podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar@"+sidecarDigest,
+			"quay.io/org/unpinned-without-digest")
+		pod.Spec.InitContainers = []corev1.Container{{Image: "busybox"}}
+		placed := reconcile(pod)
+		Expect(placed.Spec.Containers[0].Image).To(Equal("quay.io/org/app:v1@" + appDigest))
+		Expect(placed.Spec.Containers[1].Image).To(Equal("quay.io/org/sidecar@" + sidecarDigest))
+		Expect(placed.Spec.Containers[2].Image).To(Equal("quay.io/org/unpinned-without-digest"))
+		Expect(placed.Spec.InitContainers[0].Image).To(Equal("docker.io/library/busybox:latest@" + appDigest))
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+	})
+
+	It("should not pin the images of the pods declaring their architectures", func() {
+		Expect(c.Create(ctx, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{PinToInspectedDigest: true},
+		})).To(Succeed())
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "amd64"}
+		placed := reconcile(pod)
+		Expect(placed.Spec.Containers[0].Image).To(Equal("quay.io/org/app:v1"))
+		Expect(placed.Annotations).NotTo(HaveKey(inspectedDigestsAnnotation))
+	})
+})
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// DefaultAnnotationPrefix is the prefix of the annotations of the default Instance
+	DefaultAnnotationPrefix = "multiarch.openshift.io"
+	// DefaultSchedulingGateName is the name of the scheduling gate of the default Instance
+	DefaultSchedulingGateName = schedulingGateName
+	// DefaultSchedulingGateWebhookPath is the path of the PodSchedulingGateMutatingWebHook of the default Instance
+	DefaultSchedulingGateWebhookPath = "/add-pod-scheduling-gate"
+)
+
+// DefaultLegacySchedulingGateNames are the names the scheduling gate of the default Instance had in the previous
+// releases of the operator. Renaming DefaultSchedulingGateName appends its previous name, so that the pods gated before
+// an upgrade are still placed.
+var DefaultLegacySchedulingGateNames []string
+
+// Instance identifies the pods handled by an instance of the operator: the name of the scheduling gate its webhook adds
+// and its reconciler removes, and the prefix of the annotations it reads and sets on the pods and the namespaces.
+// Several instances can run in the same cluster, e.g., a staging one testing a new version, as long as their webhooks
+// gate the pods of different namespaces: each instance only places the pods carrying its own gate and only reads and
+// sets its own annotations.
+// The nil Instance is the default one, using DefaultSchedulingGateName and DefaultAnnotationPrefix.
+type Instance struct {
+	// SchedulingGateName is the name of the scheduling gate, e.g., multi-arch.openshift.io/scheduling-gate
+	SchedulingGateName string
+	// AnnotationPrefix is the prefix of the annotations, e.g., multiarch.openshift.io
+	AnnotationPrefix string
+	// LegacySchedulingGateNames are the previous names of the scheduling gate, e.g., the ones of the releases before a
+	// rename. The pods gated with them are adopted: they are placed as the ones with the scheduling gate, and their
+	// legacy gate is removed. The webhook never adds them.
+	LegacySchedulingGateNames []string
+}
+
+// NewInstance returns the Instance with the given scheduling gate name, annotation prefix and legacy scheduling gate
+// names, or an error if they are not valid
+func NewInstance(schedulingGateName, annotationPrefix string, legacySchedulingGateNames ...string) (*Instance, error) {
+	if errs := validation.IsQualifiedName(schedulingGateName); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid scheduling gate name %q: %s", schedulingGateName, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(annotationPrefix); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid annotation prefix %q: %s", annotationPrefix, strings.Join(errs, ", "))
+	}
+	for _, name := range legacySchedulingGateNames {
+		if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid legacy scheduling gate name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if name == schedulingGateName {
+			return nil, fmt.Errorf("the legacy scheduling gate name %q is the name of the scheduling gate", name)
+		}
+	}
+	return &Instance{SchedulingGateName: schedulingGateName, AnnotationPrefix: annotationPrefix,
+		LegacySchedulingGateNames: legacySchedulingGateNames}, nil
+}
+
+func (i *Instance) schedulingGateName() string {
+	if i == nil {
+		return DefaultSchedulingGateName
+	}
+	return i.SchedulingGateName
+}
+
+// schedulingGate returns the scheduling gate of the instance
+func (i *Instance) schedulingGate() corev1.PodSchedulingGate {
+	return corev1.PodSchedulingGate{Name: i.schedulingGateName()}
+}
+
+// annotation returns the key of the annotation of the instance for the key of the default instance, e.g.,
+// staging.multiarch.openshift.io/architectures for architecturesOverrideAnnotation
+func (i *Instance) annotation(key string) string {
+	if i == nil || i.AnnotationPrefix == DefaultAnnotationPrefix {
+		return key
+	}
+	return i.AnnotationPrefix + strings.TrimPrefix(key, DefaultAnnotationPrefix)
+}
+
+func (i *Instance) legacySchedulingGateNames() []string {
+	if i == nil {
+		return nil
+	}
+	return i.LegacySchedulingGateNames
+}
+
+// isSchedulingGate returns true if name is the one of the scheduling gate of the instance or of a legacy one
+func (i *Instance) isSchedulingGate(name string) bool {
+	if name == i.schedulingGateName() {
+		return true
+	}
+	for _, legacyName := range i.legacySchedulingGateNames() {
+		if name == legacyName {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSchedulingGate returns true if the pod has the scheduling gate of the instance, or one of its legacy ones
+func (i *Instance) hasSchedulingGate(pod *corev1.Pod) bool {
+	for _, schedulingGate := range pod.Spec.SchedulingGates {
+		if i.isSchedulingGate(schedulingGate.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacySchedulingGate returns the name of the first legacy scheduling gate of the instance the pod has, or an empty
+// string if it has none
+func (i *Instance) legacySchedulingGate(pod *corev1.Pod) string {
+	for _, schedulingGate := range pod.Spec.SchedulingGates {
+		if schedulingGate.Name != i.schedulingGateName() && i.isSchedulingGate(schedulingGate.Name) {
+			return schedulingGate.Name
+		}
+	}
+	return ""
+}
+
+// removeSchedulingGate removes the scheduling gate of the instance and its legacy ones from the pod, keeping the other
+// ones, and the gatedAtAnnotation annotation of the instance: it lives as long as the gate
+func (i *Instance) removeSchedulingGate(pod *corev1.Pod) {
+	if len(pod.Spec.SchedulingGates) == 0 {
+		return
+	}
+	delete(pod.Annotations, i.annotation(gatedAtAnnotation))
+	filtered := make([]corev1.PodSchedulingGate, 0, len(pod.Spec.SchedulingGates))
+	for _, schedulingGate := range pod.Spec.SchedulingGates {
+		if !i.isSchedulingGate(schedulingGate.Name) {
+			filtered = append(filtered, schedulingGate)
+		}
+	}
+	pod.Spec.SchedulingGates = filtered
+}
+
+// hasPlacementDecision returns true if the pod has the placementDecisionAnnotation annotation of the instance
+func (i *Instance) hasPlacementDecision(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[i.annotation(placementDecisionAnnotation)]
+	return ok
+}
+
+// hasArchitecturesOverride returns true if the pod has the architecturesOverrideAnnotation annotation of the instance
+func (i *Instance) hasArchitecturesOverride(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[i.annotation(architecturesOverrideAnnotation)]
+	return ok
+}
+
+// markGatedAt sets the gatedAtAnnotation annotation of the instance to the time the pod is gated
+func (i *Instance) markGatedAt(pod *corev1.Pod, now time.Time) {
+	setPodAnnotation(pod, i.annotation(gatedAtAnnotation), now.UTC().Format(time.RFC3339))
+}
+
+// isExcludedFromPlacement returns true if the pod has the excludePodPlacementAnnotation annotation of the instance set
+// to "true"
+func (i *Instance) isExcludedFromPlacement(pod *corev1.Pod) bool {
+	return pod.Annotations[i.annotation(excludePodPlacementAnnotation)] == "true"
+}
+
+// ExcludePodPlacementAnnotation returns the key of the annotation excluding the pods from the placement of the
+// instance, e.g., to set it on the pod templates of the operands of the operator
+func (i *Instance) ExcludePodPlacementAnnotation() string {
+	return i.annotation(excludePodPlacementAnnotation)
+}
+
+// stampMutatedBy sets the mutatedByAnnotation annotation of the instance to mutatedBy, if not empty
+func (i *Instance) stampMutatedBy(pod *corev1.Pod, mutatedBy string) {
+	if mutatedBy != "" {
+		setPodAnnotation(pod, i.annotation(mutatedByAnnotation), mutatedBy)
+	}
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// schedulingGate is the scheduling gate of the default Instance
+var schedulingGate = corev1.PodSchedulingGate{Name: DefaultSchedulingGateName}
+
+var _ = Describe("The instances of the operator", func() {
+	const (
+		stagingGateName = "staging.multi-arch.openshift.io/scheduling-gate"
+		stagingPrefix   = "staging.multiarch.openshift.io"
+	)
+
+	var (
+		ctx           context.Context
+		architectures *fakeArchitectures
+		c             client.Client
+		staging       *Instance
+		reconcilers   []*PodReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		architectures = &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}
+		var err error
+		staging, err = NewInstance(stagingGateName, stagingPrefix)
+		Expect(err).NotTo(HaveOccurred())
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		reconcilers = []*PodReconciler{
+			{Client: c, Inspector: architectures, MutatedBy: "v1.0.0"},
+			{Client: c, Inspector: architectures, MutatedBy: "v1.1.0-rc.1", Instance: staging},
+		}
+	})
+
+	createGated := func(name string, gates ...string) *corev1.Pod {
+		pod := podWithImages(name, "quay.io/org/app:v1")
+		for _, gate := range gates {
+			pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, corev1.PodSchedulingGate{Name: gate})
+		}
+		// the architectures declared for the other instance are ignored
+		pod.Annotations = map[string]string{
+			architecturesOverrideAnnotation:  "amd64",
+			stagingPrefix + "/architectures": "arm64",
+		}
+		Expect(c.Create(ctx, pod)).To(Succeed())
+		return pod
+	}
+
+	reconcileAll := func(pods ...*corev1.Pod) {
+		for _, reconciler := range reconcilers {
+			for _, pod := range pods {
+				_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+	}
+
+	It("should only place the pods with their own scheduling gate", func() {
+		byDefault := createGated("default", DefaultSchedulingGateName)
+		byStaging := createGated("staging", stagingGateName)
+		ungated := createGated("ungated")
+		reconcileAll(byDefault, byStaging, ungated)
+
+		placed := getPod(c, byDefault)
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64")}))
+		Expect(placed.Annotations).To(HaveKeyWithValue(mutatedByAnnotation, "v1.0.0"))
+		Expect(placed.Annotations).NotTo(HaveKey(stagingPrefix + "/mutated-by"))
+
+		placed = getPod(c, byStaging)
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64")}))
+		Expect(placed.Annotations).To(HaveKeyWithValue(stagingPrefix+"/mutated-by", "v1.1.0-rc.1"))
+		Expect(placed.Annotations).NotTo(HaveKey(mutatedByAnnotation))
+
+		Expect(getPod(c, ungated).Spec.Affinity).To(BeNil())
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should only remove their own scheduling gate", func() {
+		pod := createGated("both", "example.com/other-gate", stagingGateName, DefaultSchedulingGateName)
+		_, err := reconcilers[1].Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPod(c, pod).Spec.SchedulingGates).To(Equal([]corev1.PodSchedulingGate{
+			{Name: "example.com/other-gate"}, schedulingGate,
+		}))
+		Expect(gatedPodsRequests(ctx, c, staging)).To(BeEmpty())
+		Expect(gatedPodsRequests(ctx, c, nil)).To(HaveLen(1))
+	})
+
+	It("should gate the pods with their own scheduling gate", func() {
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c, Instance: staging, RecordGatedAt: true}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		var gates []corev1.PodSchedulingGate
+		patchedValue(response, "/spec/schedulingGates", &gates)
+		Expect(gates).To(Equal([]corev1.PodSchedulingGate{{Name: stagingGateName}}))
+		var annotations map[string]string
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveKey(stagingPrefix + "/gated-at"))
+	})
+
+	DescribeTable("should reject the invalid names", func(gateName, annotationPrefix string) {
+		_, err := NewInstance(gateName, annotationPrefix)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("invalid scheduling gate name", "staging/multi-arch/gate", stagingPrefix),
+		Entry("empty scheduling gate name", "", stagingPrefix),
+		Entry("invalid annotation prefix", stagingGateName, "Staging_Multiarch"),
+		Entry("empty annotation prefix", stagingGateName, ""),
+	)
+
+	It("should reject the invalid legacy scheduling gate names", func() {
+		_, err := NewInstance(stagingGateName, stagingPrefix, "staging/multi-arch/gate")
+		Expect(err).To(HaveOccurred())
+		_, err = NewInstance(stagingGateName, stagingPrefix, stagingGateName)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with legacy scheduling gates", func() {
+		const legacyGateName = "multiarch.openshift.io/scheduling-gate"
+
+		var legacy *Instance
+
+		BeforeEach(func() {
+			var err error
+			legacy, err = NewInstance(DefaultSchedulingGateName, DefaultAnnotationPrefix, legacyGateName)
+			Expect(err).NotTo(HaveOccurred())
+			legacyGatedPodsAdopted.Reset()
+		})
+
+		It("should adopt the pods gated with a legacy scheduling gate", func() {
+			pod := createGated("legacy", "example.com/other-gate", legacyGateName)
+			Expect(gatedPodsRequests(ctx, c, legacy)).To(HaveLen(1))
+			Expect(gatedPodsRequests(ctx, c, nil)).To(BeEmpty())
+			reconciler := &PodReconciler{Client: c, Inspector: architectures, Instance: legacy}
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).NotTo(HaveOccurred())
+			placed := getPod(c, pod)
+			Expect(placed.Spec.SchedulingGates).To(Equal([]corev1.PodSchedulingGate{{Name: "example.com/other-gate"}}))
+			Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+				Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64")}))
+			Expect(counterValue(legacyGatedPodsAdopted, legacyGateName)).To(BeNumerically("==", 1))
+		})
+
+		It("should not count the pods gated with the scheduling gate as adopted", func() {
+			pod := createGated("current", DefaultSchedulingGateName)
+			reconciler := &PodReconciler{Client: c, Inspector: architectures, Instance: legacy}
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getPod(c, pod).Spec.SchedulingGates).To(BeEmpty())
+			Expect(counterValue(legacyGatedPodsAdopted, legacyGateName)).To(BeZero())
+		})
+
+		It("should requeue the pods gated with a legacy scheduling gate at the start", func() {
+			pod := createGated("legacy", legacyGateName)
+			sweeper := NewGatedPodsSweeper(c, legacy, time.Hour, time.Hour)
+			sweepCtx, cancel := context.WithCancel(ctx)
+			DeferCleanup(cancel)
+			go func() {
+				defer GinkgoRecover()
+				Expect(sweeper.Start(sweepCtx)).To(Succeed())
+			}()
+			var requeued event.GenericEvent
+			Eventually(sweeper.Events()).Should(Receive(&requeued))
+			Expect(requeued.Object.GetName()).To(Equal(pod.Name))
+		})
+
+		It("should never add a legacy scheduling gate", func() {
+			webhook := &PodSchedulingGateMutatingWebHook{Client: c, Instance: legacy}
+			Expect(webhook.InjectDecoder(admission.NewDecoder(newTestScheme()))).To(Succeed())
+			raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+			Expect(err).NotTo(HaveOccurred())
+			response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			var gates []corev1.PodSchedulingGate
+			patchedValue(response, "/spec/schedulingGates", &gates)
+			Expect(gates).To(Equal([]corev1.PodSchedulingGate{schedulingGate}))
+		})
+	})
+
+	It("should use the default names when nil", func() {
+		var instance *Instance
+		Expect(instance.schedulingGate()).To(Equal(schedulingGate))
+		Expect(instance.annotation(architecturesOverrideAnnotation)).To(Equal(architecturesOverrideAnnotation))
+		Expect(staging.annotation(architecturesOverrideAnnotation)).To(Equal(stagingPrefix + "/architectures"))
+	})
+})
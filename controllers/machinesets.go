@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/image"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// autoscalerLabelsAnnotation declares the labels of the nodes of a node group scaled from zero to the cluster
+	// autoscaler, e.g., "kubernetes.io/arch=arm64,node-role.kubernetes.io/worker="
+	autoscalerLabelsAnnotation = "capacity.cluster-autoscaler.kubernetes.io/labels"
+	// autoscalerMaxSizeAnnotation is the maximum number of replicas a MachineAutoscaler allows to the MachineSet
+	autoscalerMaxSizeAnnotation = "machine.openshift.io/cluster-api-autoscaler-node-group-max-size"
+)
+
+// machineSetListGVK is the kind of the lists of the MachineSets of the OpenShift Machine API. They are read as
+// unstructured objects, as the Machine API is optional.
+var machineSetListGVK = schema.GroupVersionKind{
+	Group:   "machine.openshift.io",
+	Version: "v1beta1",
+	Kind:    "MachineSetList",
+}
+
+var (
+	// awsArm64InstanceFamilyRegexp matches the families of the AWS Graviton instance types, e.g., m6g, c7gn and t4g
+	awsArm64InstanceFamilyRegexp = regexp.MustCompile(`^(a1|[a-z]+[0-9]+g[a-z]*)$`)
+	// azureArm64VMSizeRegexp matches the Azure VM sizes of the Ampere Altra processors, whose additive features
+	// include p, e.g., Standard_D4ps_v5
+	azureArm64VMSizeRegexp = regexp.MustCompile(`^Standard_[A-Z]+[0-9]+[a-z]*p[a-z]*_v[0-9]+$`)
+	// gcpArm64MachineSeries are the series of the GCP machine types of the Arm processors
+	gcpArm64MachineSeries = sets.New[string]("t2a", "c4a")
+)
+
+//+kubebuilder:rbac:groups=machine.openshift.io,resources=machinesets,verbs=get;list;watch
+
+// autoscaledArchitectures returns the architectures of the nodes the scalable MachineSets of the OpenShift Machine API
+// can provision, even if they have no replicas yet. It returns an empty set when the Machine API is not installed.
+func autoscaledArchitectures(ctx context.Context, c client.Reader) (sets.Set[string], error) {
+	machineSets := &unstructured.UnstructuredList{}
+	machineSets.SetGroupVersionKind(machineSetListGVK)
+	if err := c.List(ctx, machineSets); err != nil {
+		if meta.IsNoMatchError(err) {
+			klog.V(4).Infof("The Machine API is not installed, no architecture is available through MachineSets: %v",
+				err)
+			return sets.New[string](), nil
+		}
+		return nil, err
+	}
+	architectures := sets.New[string]()
+	for i := range machineSets.Items {
+		machineSet := &machineSets.Items[i]
+		if !isScalableMachineSet(machineSet) {
+			continue
+		}
+		architecture, ok := machineSetArchitecture(machineSet)
+		if !ok {
+			klog.V(4).Infof("Unable to tell the architecture of the MachineSet %s/%s", machineSet.GetNamespace(),
+				machineSet.GetName())
+			continue
+		}
+		architectures.Insert(architecture)
+	}
+	return architectures, nil
+}
+
+// isScalableMachineSet returns true if the MachineSet has replicas or a MachineAutoscaler allowing it some
+func isScalableMachineSet(machineSet *unstructured.Unstructured) bool {
+	replicas, found, err := unstructured.NestedInt64(machineSet.Object, "spec", "replicas")
+	if err == nil && found && replicas > 0 {
+		return true
+	}
+	maxSize, err := strconv.Atoi(machineSet.GetAnnotations()[autoscalerMaxSizeAnnotation])
+	return err == nil && maxSize > 0
+}
+
+// machineSetArchitecture returns the architecture of the nodes of the MachineSet, read in order from the
+// archLabel label of the template of its machines, of the MachineSet itself and of the autoscalerLabelsAnnotation
+// annotation, or mapped from the instance type of its providerSpec. ok is false if none of them tells it.
+func machineSetArchitecture(machineSet *unstructured.Unstructured) (architecture string, ok bool) {
+	nodeLabels, _, _ := unstructured.NestedStringMap(machineSet.Object, "spec", "template", "spec", "metadata", "labels")
+	for _, labels := range []map[string]string{nodeLabels, machineSet.GetLabels(),
+		parseAutoscalerLabels(machineSet.GetAnnotations()[autoscalerLabelsAnnotation])} {
+		if value, found := labels[archLabel]; found {
+			return image.NormalizeArchitecture(value), true
+		}
+	}
+	providerSpec, _, _ := unstructured.NestedMap(machineSet.Object, "spec", "template", "spec", "providerSpec", "value")
+	return instanceTypeArchitecture(providerSpec)
+}
+
+// parseAutoscalerLabels parses the comma-separated key=value pairs of the autoscalerLabelsAnnotation annotation
+func parseAutoscalerLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if key, value, found := strings.Cut(strings.TrimSpace(pair), "="); found {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// instanceTypeArchitecture maps the instance type of the providerSpec of the AWS, Azure and GCP MachineSets to its
+// architecture. The instance types of the other architectures of these clouds are amd64.
+func instanceTypeArchitecture(providerSpec map[string]interface{}) (architecture string, ok bool) {
+	if instanceType, found := providerSpec["instanceType"].(string); found && instanceType != "" {
+		family, _, _ := strings.Cut(instanceType, ".")
+		return arm64If(awsArm64InstanceFamilyRegexp.MatchString(family)), true
+	}
+	if vmSize, found := providerSpec["vmSize"].(string); found && vmSize != "" {
+		return arm64If(azureArm64VMSizeRegexp.MatchString(vmSize)), true
+	}
+	if machineType, found := providerSpec["machineType"].(string); found && machineType != "" {
+		series, _, _ := strings.Cut(machineType, "-")
+		return arm64If(gcpArm64MachineSeries.Has(series)), true
+	}
+	return "", false
+}
+
+func arm64If(arm64 bool) string {
+	if arm64 {
+		return "arm64"
+	}
+	return "amd64"
+}
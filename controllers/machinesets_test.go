@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// machineSet returns a MachineSet with the given replicas, annotations and labels of the template of its machines
+func machineSet(name string, replicas int64, annotations, nodeLabels map[string]string,
+	providerSpec map[string]interface{}) unstructured.Unstructured {
+	ms := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "machine.openshift.io/v1beta1",
+		"kind":       "MachineSet",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "openshift-machine-api",
+		},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"providerSpec": map[string]interface{}{"value": providerSpec},
+				},
+			},
+		},
+	}}
+	ms.SetAnnotations(annotations)
+	if nodeLabels != nil {
+		Expect(unstructured.SetNestedStringMap(ms.Object, nodeLabels,
+			"spec", "template", "spec", "metadata", "labels")).To(Succeed())
+	}
+	return ms
+}
+
+// listingMachineSets returns the interceptor functions listing the given MachineSets, or failing with err if not nil
+func listingMachineSets(err error, machineSets ...unstructured.Unstructured) interceptor.Funcs {
+	return interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			machineSetList, ok := list.(*unstructured.UnstructuredList)
+			if !ok {
+				return c.List(ctx, list, opts...)
+			}
+			if err != nil {
+				return err
+			}
+			machineSetList.Items = machineSets
+			return nil
+		},
+	}
+}
+
+var _ = Describe("The MachineSets", func() {
+	DescribeTable("should tell the architecture of their nodes",
+		func(annotations, labels, nodeLabels map[string]string, providerSpec map[string]interface{},
+			expected string, expectedOk bool) {
+			ms := machineSet("ms", 0, annotations, nodeLabels, providerSpec)
+			ms.SetLabels(labels)
+			architecture, ok := machineSetArchitecture(&ms)
+			Expect(ok).To(Equal(expectedOk))
+			Expect(architecture).To(Equal(expected))
+		},
+		Entry("label of the template", nil, nil, map[string]string{archLabel: "arm64"}, nil, "arm64", true),
+		Entry("label of the MachineSet", nil, map[string]string{archLabel: "s390x"}, nil, nil, "s390x", true),
+		Entry("autoscaler labels annotation",
+			map[string]string{autoscalerLabelsAnnotation: "node-role.kubernetes.io/worker=, kubernetes.io/arch=arm64"},
+			nil, nil, nil, "arm64", true),
+		Entry("label of the template over the instance type", nil, nil, map[string]string{archLabel: "amd64"},
+			map[string]interface{}{"instanceType": "m6g.xlarge"}, "amd64", true),
+		Entry("aarch64 label", nil, nil, map[string]string{archLabel: "aarch64"}, nil, "arm64", true),
+		Entry("AWS Graviton instance type", nil, nil, nil, map[string]interface{}{"instanceType": "m6g.xlarge"},
+			"arm64", true),
+		Entry("AWS Graviton instance type with features", nil, nil, nil,
+			map[string]interface{}{"instanceType": "c7gn.large"}, "arm64", true),
+		Entry("AWS A1 instance type", nil, nil, nil, map[string]interface{}{"instanceType": "a1.large"}, "arm64", true),
+		Entry("AWS x86 instance type", nil, nil, nil, map[string]interface{}{"instanceType": "m6i.xlarge"}, "amd64",
+			true),
+		Entry("AWS x86 instance type with a g family", nil, nil, nil,
+			map[string]interface{}{"instanceType": "g4dn.xlarge"}, "amd64", true),
+		Entry("Azure Ampere VM size", nil, nil, nil, map[string]interface{}{"vmSize": "Standard_D4ps_v5"}, "arm64",
+			true),
+		Entry("Azure x86 VM size", nil, nil, nil, map[string]interface{}{"vmSize": "Standard_D4s_v5"}, "amd64", true),
+		Entry("GCP Arm machine type", nil, nil, nil, map[string]interface{}{"machineType": "t2a-standard-4"}, "arm64",
+			true),
+		Entry("GCP x86 machine type", nil, nil, nil, map[string]interface{}{"machineType": "n2-standard-4"}, "amd64",
+			true),
+		Entry("unknown provider", nil, nil, nil, map[string]interface{}{"flavor": "m1.large"}, "", false),
+	)
+
+	DescribeTable("should be scalable when they have replicas or a maximum size",
+		func(replicas int64, annotations map[string]string, expected bool) {
+			ms := machineSet("ms", replicas, annotations, nil, nil)
+			Expect(isScalableMachineSet(&ms)).To(Equal(expected))
+		},
+		Entry("replicas", int64(2), nil, true),
+		Entry("no replicas", int64(0), nil, false),
+		Entry("no replicas and a maximum size", int64(0), map[string]string{autoscalerMaxSizeAnnotation: "3"}, true),
+		Entry("no replicas and a zero maximum size", int64(0), map[string]string{autoscalerMaxSizeAnnotation: "0"},
+			false),
+	)
+
+	It("should provide no architecture when the Machine API is not installed", func() {
+		c := fake.NewClientBuilder().WithInterceptorFuncs(listingMachineSets(&meta.NoKindMatchError{
+			GroupKind: schema.GroupKind{Group: "machine.openshift.io", Kind: "MachineSetList"},
+		})).Build()
+		architectures, err := autoscaledArchitectures(context.Background(), c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(architectures).To(BeEmpty())
+	})
+
+	It("should fail on the other errors of the Machine API", func() {
+		c := fake.NewClientBuilder().WithInterceptorFuncs(listingMachineSets(errors.New("unavailable"))).Build()
+		_, err := autoscaledArchitectures(context.Background(), c)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should provide the architectures of the scalable MachineSets", func() {
+		c := fake.NewClientBuilder().WithInterceptorFuncs(listingMachineSets(nil,
+			machineSet("amd64", 3, nil, nil, map[string]interface{}{"instanceType": "m6i.xlarge"}),
+			machineSet("arm64", 0, map[string]string{autoscalerMaxSizeAnnotation: "3"}, nil,
+				map[string]interface{}{"instanceType": "m6g.xlarge"}),
+			machineSet("s390x", 0, nil, map[string]string{archLabel: "s390x"}, nil),
+			machineSet("unknown", 1, nil, nil, nil),
+		)).Build()
+		architectures, err := autoscaledArchitectures(context.Background(), c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(architectures.UnsortedList()).To(ConsistOf("amd64", "arm64"))
+	})
+})
+
+var _ = Describe("The ArchitectureCapacityCache with the autoscaled capacity", func() {
+	newCache := func(objs ...client.Object) *ArchitectureCapacityCache {
+		scaledToZero := machineSet("arm64", 0, map[string]string{autoscalerMaxSizeAnnotation: "3"},
+			map[string]string{archLabel: "arm64"}, nil)
+		c := NewArchitectureCapacityCache(fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithInterceptorFuncs(listingMachineSets(nil, scaledToZero)).WithObjects(append(objs,
+			nodeWithCapacity("amd64-1", "amd64", "8", "32Gi", false))...).Build(), time.Minute)
+		Expect(c.refresh(context.Background())).To(Succeed())
+		return c
+	}
+
+	It("should exclude the architectures without nodes by default", func() {
+		fitting, excluded := newCache().filterArchitectures(podRequesting("1", "1Gi"), []string{"amd64", "arm64"})
+		Expect(fitting).To(Equal([]string{"amd64"}))
+		Expect(excluded).To(Equal([]string{"arm64"}))
+	})
+
+	It("should keep the architectures of the scalable MachineSets when requested", func() {
+		c := newCache(&multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{ConsiderAutoscaledCapacity: true},
+		})
+		fitting, excluded := c.filterArchitectures(podRequesting("1", "1Gi"), []string{"amd64", "arm64"})
+		Expect(fitting).To(Equal([]string{"amd64", "arm64"}))
+		Expect(excluded).To(BeEmpty())
+		Expect(c.headroom).NotTo(HaveKey("arm64"))
+	})
+})
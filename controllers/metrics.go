@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"multiarch-operator/pkg/version"
+)
+
+const metricsNamespace = "multiarch"
+
+var (
+	workloadsOnBlockedRegistries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "workloads_on_blocked_registries",
+		Help:      "The number of pods using at least one image from a blocked registry",
+	}, []string{"registry"})
+	gatedPodsSweeps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gated_pods_sweeps_total",
+		Help:      "The number of sweeps of the gated pods, by trigger: periodic, pull_secret, registry_certs or startup",
+	}, []string{"trigger"})
+	gatedPodsRequeued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gated_pods_requeued_total",
+		Help:      "The number of gated pods requeued by the sweeps, by trigger",
+	}, []string{"trigger"})
+	gatedAtRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gated_at_repairs_total",
+		Help: "The number of pods whose gated-at annotation was repaired by the sweeps, by direction: added to the " +
+			"gated pods missing it or removed from the pods without the scheduling gate",
+	}, []string{"direction"})
+	oldestGatedPodAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "oldest_gated_pod_age_seconds",
+		Help:      "The age of the oldest gated pod at the last sweep, zero when no pod is gated",
+	})
+	admissionsWithoutSnapshot = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admissions_without_podplacementconfig_snapshot_total",
+		Help: "The number of pods admitted without the scheduling gate because the snapshot of the " +
+			"PodPlacementConfig objects was not ready yet",
+	})
+	eventsDeduplicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_deduplicated_total",
+		Help:      "The number of events dropped because an identical one was emitted for the same owner, by reason",
+	}, []string{"reason"})
+	placementMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "placement_mismatches_total",
+		Help: "The number of audited pods running on a node whose architecture their images do not support, by " +
+			"architecture of the node",
+	}, []string{"architecture"})
+	ownerAnnotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "owner_annotations_total",
+		Help: "The number of the propagations of the placement of the pods to their top-level owner, by result: " +
+			"patched, excluded_namespace, forbidden or failed",
+	}, []string{"result"})
+	pullSecretReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pull_secret_reads_total",
+		Help:      "The number of reads of the pull secrets of the pods, by result: hit of the cache or miss",
+	}, []string{"result"})
+	decisionCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decision_cache_lookups_total",
+		Help:      "The number of lookups of the placement decisions cached by pod template, by result: hit or miss",
+	}, []string{"result"})
+	placementDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "placement_decisions_total",
+		Help: "The number of the placement decisions of the reconciler and of the webhook, by reason, e.g., " +
+			"ArchitectureConstrained or NoCommonArchitecture",
+	}, []string{"reason"})
+	schedulingGateLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "scheduling_gate_latency_seconds",
+		Help: "The time from the gating of the pods by the webhook, or from their creation when not recorded, to the " +
+			"removal of their scheduling gate, by cache_hit, true when the architectures of all the images were " +
+			"cached, and result: placed, gate_removed or paused",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"cache_hit", "result"})
+	legacyGatedPodsAdopted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "legacy_gated_pods_adopted_total",
+		Help:      "The number of pods gated with a legacy scheduling gate whose gate was removed, by gate",
+	}, []string{"gate"})
+	gatedPodsCapExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gated_pods_cap_exceeded_total",
+		Help: "The number of pods admitted without the scheduling gate because their namespace had " +
+			"--max-gated-pods-per-namespace gated pods or more, by namespace",
+	}, []string{"namespace"})
+	recentDecisions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "decisions_recent",
+		Help: "The number of the pods placed by the reconciler requiring each architecture in the window of " +
+			"--recent-decisions-window, by architecture",
+	}, []string{"architecture"})
+	nodesByArchitecture = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "nodes",
+		Help:      "The number of the schedulable nodes, by architecture",
+	}, []string{"architecture"})
+)
+
+func init() {
+	version.MetricsRegisterer().MustRegister(workloadsOnBlockedRegistries, gatedPodsSweeps, gatedPodsRequeued,
+		gatedAtRepairs, oldestGatedPodAge, admissionsWithoutSnapshot, eventsDeduplicated, placementMismatches,
+		legacyGatedPodsAdopted, pullSecretReads, ownerAnnotations, placementDecisions, schedulingGateLatency,
+		decisionCacheLookups, recentDecisions, nodesByArchitecture, gatedPodsCapExceeded)
+}
@@ -0,0 +1,155 @@
+package multiarch
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/testenv"
+)
+
+// envtestTimeout bounds the waits for the reconciler of the harness
+const envtestTimeout = 10 * time.Second
+
+var _ = Describe("The PodPlacementConfig reconciler in the test environment", func() {
+	var (
+		ctx context.Context
+		ppc *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	// create creates the object and deletes it at the end of the spec
+	create := func(obj client.Object) {
+		Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), obj))).To(Succeed())
+		})
+	}
+
+	current := func() *multiarchv1alpha1.PodPlacementConfig {
+		current := &multiarchv1alpha1.PodPlacementConfig{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(ppc), current)).To(Succeed())
+		return current
+	}
+
+	forcedSync := func() *multiarchv1alpha1.ForcedSync {
+		return current().Status.ForcedSync
+	}
+
+	BeforeEach(func() {
+		if harness == nil {
+			Skip("the test environment is not available: KUBEBUILDER_ASSETS is not set")
+		}
+		ctx = context.Background()
+		// the reconciler only reconciles the PodPlacementConfig with this name
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{
+			Name:        "podplacementconfig-sample",
+			Annotations: map[string]string{multiarchv1alpha1.ForceSyncAnnotation: "1"},
+		}}
+	})
+
+	It("should restrict the webhook to the selected namespaces and force the sync of the system config", func() {
+		create(testenv.NewImageContentSourcePolicy("mirrors", "quay.io/org", "mirror.example.com/org"))
+		create(testenv.NewConfigMap(forcedSyncConfigMap.Namespace, forcedSyncConfigMap.Name, nil))
+		selector := &metav1.LabelSelector{MatchLabels: map[string]string{"envtest": "true"}}
+		ppc.Spec.NamespaceSelector = selector
+		create(ppc)
+
+		Eventually(func() (*metav1.LabelSelector, error) {
+			configuration := &admissionregistrationv1.MutatingWebhookConfiguration{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: envtestMutatingWebhookConfigurationName}, configuration)
+			if err != nil {
+				return nil, err
+			}
+			return configuration.Webhooks[0].NamespaceSelector, nil
+		}, envtestTimeout).Should(Equal(selector))
+		Eventually(forcedSync, envtestTimeout).Should(HaveField("Value", "1"))
+		Expect(meta.IsStatusConditionFalse(current().Status.Conditions,
+			multiarchv1alpha1.DegradedConditionType)).To(BeTrue())
+	})
+
+	It("should retry the forced syncs that failed", func() {
+		create(ppc)
+		Consistently(forcedSync, 2*time.Second).Should(BeNil())
+		create(testenv.NewConfigMap(forcedSyncConfigMap.Namespace, forcedSyncConfigMap.Name, nil))
+		Eventually(forcedSync, envtestTimeout).Should(HaveField("Value", "1"))
+	})
+
+	It("should reconcile the PodPlacementConfig with the fields of a newer version without dropping them", func() {
+		// emulate the CustomResourceDefinition of a newer version, keeping the fields unknown to this one
+		crd := &unstructured.Unstructured{}
+		crd.SetAPIVersion("apiextensions.k8s.io/v1")
+		crd.SetKind("CustomResourceDefinition")
+		crd.SetName("podplacementconfigs.multiarch.openshift.io")
+		preserveUnknownFields := func(op string) {
+			const path = "/spec/versions/0/schema/openAPIV3Schema/properties/spec/x-kubernetes-preserve-unknown-fields"
+			patch := `[{"op": "` + op + `", "path": "` + path + `"`
+			if op == "add" {
+				patch += `, "value": true`
+			}
+			Expect(k8sClient.Patch(context.Background(), crd,
+				client.RawPatch(types.JSONPatchType, []byte(patch+"}]")))).To(Succeed())
+		}
+		preserveUnknownFields("add")
+		DeferCleanup(preserveUnknownFields, "remove")
+
+		create(testenv.NewConfigMap(forcedSyncConfigMap.Namespace, forcedSyncConfigMap.Name, nil))
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(multiarchv1alpha1.GroupVersion.WithKind("PodPlacementConfig"))
+		u.SetName(ppc.Name)
+		futureField := func() (interface{}, error) {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(u), u); err != nil {
+				return nil, err
+			}
+			value, _, err := unstructured.NestedFieldNoCopy(u.Object, "spec", "futurePlacement")
+			return value, err
+		}
+		// the API server prunes the field until it serves the new schema
+		Eventually(func() (interface{}, error) {
+			u.Object["spec"] = map[string]interface{}{"futurePlacement": "enabled"}
+			u.SetResourceVersion("")
+			if err := k8sClient.Create(ctx, u); err != nil {
+				return nil, err
+			}
+			value, err := futureField()
+			if value == nil {
+				Expect(k8sClient.Delete(ctx, u)).To(Succeed())
+			}
+			return value, err
+		}, envtestTimeout).Should(Equal("enabled"))
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), u))).To(Succeed())
+		})
+
+		Eventually(func() int64 { return current().Status.ObservedGeneration }, envtestTimeout).
+			Should(Equal(current().Generation))
+		Expect(meta.IsStatusConditionFalse(current().Status.Conditions,
+			multiarchv1alpha1.DegradedConditionType)).To(BeTrue())
+		Expect(futureField()).To(Equal("enabled"))
+	})
+
+	It("should reject the placement settings conflicting with the opt-out", func() {
+		ppc.Spec.OptOut = pointer.Bool(true)
+		ppc.Spec.PlacementMode = multiarchv1alpha1.PlacementModeRequired
+		err := k8sClient.Create(ctx, ppc)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error: %v", err)
+	})
+
+	It("should reject the duplicate allowed architectures through the validating webhook", func() {
+		ppc.Spec.AllowedArchitectures = []string{"arm64", "aarch64"}
+		err := k8sClient.Create(ctx, ppc)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err.Error()).To(ContainSubstring("allowedArchitectures"))
+	})
+})
@@ -0,0 +1,177 @@
+package multiarch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+const (
+	// NodeSyncerMode is the value of the --mode flag running only the system config syncer, in the pods of the node
+	// syncer DaemonSet
+	NodeSyncerMode = "node-syncer"
+	// nodeSyncerName is the name of the node syncer DaemonSet
+	nodeSyncerName = "multiarch-operator-node-syncer"
+	// nodeSyncerProbePort is the port of the health probes of the node syncer pods
+	nodeSyncerProbePort = 8081
+)
+
+// NodeSyncerOptions configures the node syncer DaemonSet managed by the PodPlacementConfigReconciler. Its pods run the
+// operator binary with --mode=node-syncer and write the system config files to the host directories of their node.
+type NodeSyncerOptions struct {
+	// Image is the image of the node syncer pods, usually the one of the operator
+	Image string
+	// Namespace is the namespace of the DaemonSet
+	Namespace string
+	// ServiceAccountName is the service account of the node syncer pods. It must be allowed to watch the objects the
+	// system config is built from, as the node-syncer-role generated from the markers of pkg/system_config does.
+	ServiceAccountName string
+	// HostDir is the directory of the nodes the containers and docker directories of the system config are written to
+	HostDir string
+	// Args are the additional arguments of the node syncer, e.g., the flags of the system config syncer
+	Args []string
+	// PodAnnotations are the annotations of the node syncer pods, e.g., the one opting them out of the pod placement,
+	// so that their admission does not wait for the operator to place them
+	PodAnnotations map[string]string
+}
+
+// nodeSyncerVolumes maps the host subdirectories of HostDir to the directories the system config syncer writes to
+var nodeSyncerVolumes = []struct {
+	name      string
+	hostDir   string
+	mountPath string
+}{
+	{name: "containers", hostDir: "containers", mountPath: "/tmp/containers"},
+	{name: "docker", hostDir: "docker", mountPath: "/tmp/docker"},
+}
+
+// reconcileNodeSyncer creates or updates the node syncer DaemonSet, owned by the PodPlacementConfig, and sets the
+// NodeSyncerAvailable condition of the PodPlacementConfig from its status. It returns true if the condition changed.
+func (r *PodPlacementConfigReconciler) reconcileNodeSyncer(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) (bool, error) {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: nodeSyncerName, Namespace: r.NodeSyncer.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+		r.mutateNodeSyncer(ds)
+		return controllerutil.SetControllerReference(ppc, ds, r.Scheme)
+	}); err != nil {
+		return false, fmt.Errorf("unable to create or update the node syncer DaemonSet: %w", err)
+	}
+	return setNodeSyncerCondition(ppc, ds), nil
+}
+
+// mutateNodeSyncer sets the desired state of the node syncer DaemonSet. The pods tolerate any taint, to run on the
+// nodes of all the roles, and are replaced one node at a time: a node keeps its files while its pod is replaced.
+func (r *PodPlacementConfigReconciler) mutateNodeSyncer(ds *appsv1.DaemonSet) {
+	labels := map[string]string{
+		"app.kubernetes.io/name":       "node-syncer",
+		"app.kubernetes.io/component":  "node-syncer",
+		"app.kubernetes.io/part-of":    "multiarch-operator",
+		"app.kubernetes.io/managed-by": "multiarch-operator",
+	}
+	ds.Labels = labels
+	if ds.Spec.Selector == nil {
+		// the selector is immutable
+		ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "node-syncer"}}
+	}
+	maxUnavailable := intstr.FromInt(1)
+	ds.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateDaemonSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable},
+	}
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	for _, v := range nodeSyncerVolumes {
+		volumes = append(volumes, corev1.Volume{
+			Name: v.name,
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{
+				Path: filepath.Join(r.NodeSyncer.HostDir, v.hostDir),
+				Type: &hostPathType,
+			}},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: v.name, MountPath: v.mountPath})
+	}
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(nodeSyncerProbePort),
+			}},
+			PeriodSeconds: 10,
+		}
+	}
+	allowPrivilegeEscalation := false
+	// the host directories are owned by root
+	runAsUser := int64(0)
+	ds.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: r.NodeSyncer.PodAnnotations},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: r.NodeSyncer.ServiceAccountName,
+			PriorityClassName:  "system-node-critical",
+			Tolerations:        []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			NodeSelector:       map[string]string{corev1.LabelOSStable: "linux"},
+			Containers: []corev1.Container{{
+				Name:    "node-syncer",
+				Image:   r.NodeSyncer.Image,
+				Command: []string{"/manager"},
+				Args: append([]string{
+					"--mode=" + NodeSyncerMode,
+					fmt.Sprintf("--health-probe-bind-address=:%d", nodeSyncerProbePort),
+				}, r.NodeSyncer.Args...),
+				VolumeMounts:   volumeMounts,
+				LivenessProbe:  probe("/healthz"),
+				ReadinessProbe: probe("/readyz"),
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser:                &runAsUser,
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("32Mi"),
+					},
+				},
+			}},
+			Volumes: volumes,
+		},
+	}
+}
+
+// setNodeSyncerCondition sets the NodeSyncerAvailable condition of the PodPlacementConfig: it is true when the pods of
+// all the nodes run the current template and are ready, i.e., their last sync of the system config files succeeded.
+// It returns true if the condition changed.
+func setNodeSyncerCondition(ppc *multiarchv1alpha1.PodPlacementConfig, ds *appsv1.DaemonSet) bool {
+	condition := metav1.Condition{
+		Type:               multiarchv1alpha1.NodeSyncerAvailableConditionType,
+		Status:             metav1.ConditionTrue,
+		Reason:             multiarchv1alpha1.AsExpectedReason,
+		Message:            "The system config files of all the nodes are in sync",
+		ObservedGeneration: ppc.Generation,
+	}
+	status := ds.Status
+	if status.ObservedGeneration < ds.Generation || status.UpdatedNumberScheduled < status.DesiredNumberScheduled ||
+		status.NumberReady < status.DesiredNumberScheduled {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = multiarchv1alpha1.NodeSyncerRolloutInProgressReason
+		condition.Message = fmt.Sprintf("%d of %d nodes run an up-to-date node syncer and %d of them synced the "+
+			"system config files", status.UpdatedNumberScheduled, status.DesiredNumberScheduled, status.NumberReady)
+	}
+	current := meta.FindStatusCondition(ppc.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(&ppc.Status.Conditions, condition)
+	return true
+}
@@ -0,0 +1,122 @@
+package multiarch
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+var _ = Describe("The node syncer DaemonSet", func() {
+	var (
+		ctx context.Context
+		c   client.Client
+		r   *PodPlacementConfigReconciler
+		ppc *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster", UID: "uid"}}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(ppc).Build()
+		r = &PodPlacementConfigReconciler{
+			Client: c,
+			Scheme: scheme,
+			NodeSyncer: &NodeSyncerOptions{
+				Image:              "quay.io/org/multiarch-operator:v1",
+				Namespace:          "operator",
+				ServiceAccountName: "operator",
+				HostDir:            "/etc/multiarch-operator",
+				Args:               []string{"--block-mirrors-of-blocked-registries=true"},
+				PodAnnotations:     map[string]string{"multiarch.openshift.io/exclude-pod-placement": "true"},
+			},
+		}
+	})
+
+	getDaemonSet := func() *appsv1.DaemonSet {
+		ds := &appsv1.DaemonSet{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "operator", Name: nodeSyncerName}, ds)).To(Succeed())
+		return ds
+	}
+
+	It("should run the node syncer on every node", func() {
+		_, err := r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		ds := getDaemonSet()
+		Expect(metav1.IsControlledBy(ds, ppc)).To(BeTrue())
+		Expect(ds.Spec.UpdateStrategy.Type).To(Equal(appsv1.RollingUpdateDaemonSetStrategyType))
+		Expect(ds.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable.IntValue()).To(Equal(1))
+		Expect(ds.Spec.Template.Spec.Tolerations).To(Equal([]corev1.Toleration{{Operator: corev1.TolerationOpExists}}))
+		Expect(ds.Spec.Template.Annotations).To(HaveKeyWithValue("multiarch.openshift.io/exclude-pod-placement", "true"))
+		container := ds.Spec.Template.Spec.Containers[0]
+		Expect(container.Image).To(Equal("quay.io/org/multiarch-operator:v1"))
+		Expect(container.Args).To(Equal([]string{"--mode=node-syncer", "--health-probe-bind-address=:8081",
+			"--block-mirrors-of-blocked-registries=true"}))
+		Expect(container.ReadinessProbe.HTTPGet.Path).To(Equal("/readyz"))
+		Expect(container.VolumeMounts).To(ConsistOf(
+			corev1.VolumeMount{Name: "containers", MountPath: "/tmp/containers"},
+			corev1.VolumeMount{Name: "docker", MountPath: "/tmp/docker"}))
+		Expect(ds.Spec.Template.Spec.Volumes).To(ConsistOf(
+			HaveField("HostPath.Path", "/etc/multiarch-operator/containers"),
+			HaveField("HostPath.Path", "/etc/multiarch-operator/docker")))
+	})
+
+	It("should roll out the changes of the node syncer", func() {
+		_, err := r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		selector := getDaemonSet().Spec.Selector
+		r.NodeSyncer.Image = "quay.io/org/multiarch-operator:v2"
+		_, err = r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		ds := getDaemonSet()
+		Expect(ds.Spec.Template.Spec.Containers[0].Image).To(Equal("quay.io/org/multiarch-operator:v2"))
+		Expect(ds.Spec.Selector).To(Equal(selector))
+	})
+
+	It("should report the nodes whose files are not in sync", func() {
+		changed, err := r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+
+		ds := getDaemonSet()
+		ds.Status = appsv1.DaemonSetStatus{
+			ObservedGeneration:     ds.Generation,
+			DesiredNumberScheduled: 3,
+			UpdatedNumberScheduled: 3,
+			NumberReady:            2,
+		}
+		Expect(c.Status().Update(ctx, ds)).To(Succeed())
+		changed, err = r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		condition := meta.FindStatusCondition(ppc.Status.Conditions, multiarchv1alpha1.NodeSyncerAvailableConditionType)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(multiarchv1alpha1.NodeSyncerRolloutInProgressReason))
+		Expect(condition.Message).To(ContainSubstring("2 of them synced"))
+
+		ds = getDaemonSet()
+		ds.Status.NumberReady = 3
+		Expect(c.Status().Update(ctx, ds)).To(Succeed())
+		changed, err = r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(ppc.Status.Conditions,
+			multiarchv1alpha1.NodeSyncerAvailableConditionType)).To(BeTrue())
+		changed, err = r.reconcileNodeSyncer(ctx, ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+	})
+})
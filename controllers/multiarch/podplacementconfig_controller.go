@@ -21,14 +21,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
@@ -36,13 +50,74 @@ import (
 
 const (
 	replaceWebhooksValueTemplate = `{ "op": "replace", "path": "/webhooks/0/namespaceSelector", "value": %s }`
+	// DefaultMutatingWebhookConfigurationName is the name of the MutatingWebhookConfiguration of the webhook gating the
+	// pods
+	DefaultMutatingWebhookConfigurationName = "multiarch-operator-mutating-webhook-configuration"
+	// WorkloadTemplateWebhookName is the name of the webhook mutating the pod templates of the workloads in the
+	// MutatingWebhookConfiguration of the webhook gating the pods
+	WorkloadTemplateWebhookName = "workload-arch-affinity.multiarch.openshift.io"
+	// replaceWebhookNamespaceSelectorTemplate replaces the namespaceSelector of the webhook at an index, if it is still
+	// the one with the name
+	replaceWebhookNamespaceSelectorTemplate = `{ "op": "test", "path": "/webhooks/%d/name", "value": %q }, ` +
+		`{ "op": "replace", "path": "/webhooks/%d/namespaceSelector", "value": %s }`
+	// betaArchLabel is the deprecated node label reporting the architecture of the node
+	betaArchLabel = "beta.kubernetes.io/arch"
 )
 
 // PodPlacementConfigReconciler reconciles a PodPlacementConfig object
 type PodPlacementConfigReconciler struct {
 	client.Client
-	Scheme    *runtime.Scheme
-	Clientset *kubernetes.Clientset
+	Scheme *runtime.Scheme
+	// APIReader reads the MutatingWebhookConfiguration, so that the webhook configurations are not cached by the
+	// manager. It defaults to the Client.
+	APIReader client.Reader
+	// SchedulingGatesUnsupported is true when the API server does not support the pod scheduling gates and the webhook
+	// does not gate the pods. It is reported by the Degraded condition of the PodPlacementConfig.
+	SchedulingGatesUnsupported bool
+	// WatchNamespaces are the namespaces the operator is restricted to. When not empty, the namespaceSelector of the
+	// webhook only matches them, in addition to the namespaceSelector of the PodPlacementConfig.
+	WatchNamespaces []string
+	// MutatingWebhookConfigurationName is the name of the MutatingWebhookConfiguration whose namespaceSelector is
+	// managed. It defaults to DefaultMutatingWebhookConfigurationName.
+	MutatingWebhookConfigurationName string
+	// GatingLabel is optional. When set, it is the key of the namespace label assigning the namespaces to the
+	// instances of the operator running in the same cluster: the namespaceSelector of the webhook only matches the
+	// namespaces whose label value is InstanceName, or the ones without the label if InstanceName is empty. The
+	// instances sharing the GatingLabel never gate the pods of the same namespace.
+	GatingLabel string
+	// InstanceName is the value of the GatingLabel of the namespaces of the instance
+	InstanceName string
+	// NodeSyncer configures the node syncer DaemonSet writing the system config files to every node. The DaemonSet is
+	// only managed when it is not nil.
+	NodeSyncer *NodeSyncerOptions
+	// ArchitecturesDistribution computes the clusterArchitectures and pendingGatedPods of the status. They are only
+	// reported when it is set and ArchitecturesStatusInterval is positive.
+	ArchitecturesDistribution func(ctx context.Context) ([]multiarchv1alpha1.ArchitectureNodes,
+		[]multiarchv1alpha1.GatedPodsRequirement, error)
+	// ArchitecturesStatusInterval is the minimum interval between two refreshes of the distribution of the
+	// architectures, bounding the lists of the nodes and pods whatever the rate of the reconciles.
+	ArchitecturesStatusInterval time.Duration
+	// ForceSystemConfigSync rebuilds the system config files from the current state of the cluster and writes them. It
+	// is called when the value of the multiarch.openshift.io/force-sync annotation of the PodPlacementConfig changes.
+	// The annotation is ignored when it is nil.
+	ForceSystemConfigSync func(ctx context.Context) error
+	// ClusterArchitectures returns the sorted architectures of the cluster, see controllers.ClusterArchitectures. When
+	// it is set, the EffectivelyDisabled condition reports whether the cluster has a single architecture, and the
+	// changes of the architectures of the nodes trigger a reconcile.
+	ClusterArchitectures func(ctx context.Context) ([]string, error)
+	// architecturesRefreshed is the time of the last refresh of the distribution of the architectures
+	architecturesRefreshed time.Time
+	// unknownFieldsGeneration is the generation of the PodPlacementConfig whose unknown fields were last logged
+	unknownFieldsGeneration int64
+	clock                   clock.PassiveClock
+}
+
+// apiReader returns the APIReader, or the Client if it is not set
+func (r *PodPlacementConfigReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
 }
 
 func generatePatchBytes(ops string) []byte {
@@ -53,6 +128,7 @@ func generatePatchBytes(ops string) []byte {
 //+kubebuilder:rbac:groups=multiarch.openshift.io,resources=podplacementconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=multiarch.openshift.io,resources=podplacementconfigs/finalizers,verbs=update
 //+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -72,19 +148,24 @@ func (r *PodPlacementConfigReconciler) Reconcile(ctx context.Context, req ctrl.R
 		klog.Errorf("unable to fetch PodPlacementConfig %s: %v", req.Name, err)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	podplacementwebhook, err := r.Clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "multiarch-operator-mutating-webhook-configuration", metav1.GetOptions{})
-	if err != nil {
+	webhookConfigurationName := r.MutatingWebhookConfigurationName
+	if webhookConfigurationName == "" {
+		webhookConfigurationName = DefaultMutatingWebhookConfigurationName
+	}
+	podplacementwebhook := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := r.apiReader().Get(ctx, client.ObjectKey{Name: webhookConfigurationName}, podplacementwebhook); err != nil {
 		klog.Errorf("unable to fetch mutating webhook: %v", err)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	if !reflect.DeepEqual(podplacementwebhook.Webhooks[0].NamespaceSelector, podplacementconfig.Spec.NamespaceSelector) {
-		nsselectorbytes, err := json.Marshal(podplacementconfig.Spec.NamespaceSelector)
+	namespaceSelector := r.webhookNamespaceSelector(podplacementconfig)
+	if !reflect.DeepEqual(podplacementwebhook.Webhooks[0].NamespaceSelector, namespaceSelector) {
+		nsselectorbytes, err := json.Marshal(namespaceSelector)
 		if err != nil {
 			klog.Errorf("unable to marshal namespaceselector: %v", err)
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 		op := fmt.Sprintf(replaceWebhooksValueTemplate, string(nsselectorbytes))
-		_, err = r.Clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Patch(ctx, "multiarch-operator-mutating-webhook-configuration", types.JSONPatchType, generatePatchBytes(op), metav1.PatchOptions{})
+		err = r.Patch(ctx, podplacementwebhook, client.RawPatch(types.JSONPatchType, generatePatchBytes(op)))
 		if err != nil {
 			if err != nil {
 				klog.Errorf("unable to update mutatingwebhookconfiguration: %v", err)
@@ -92,18 +173,349 @@ func (r *PodPlacementConfigReconciler) Reconcile(ctx context.Context, req ctrl.R
 			}
 		}
 	}
-	err = r.Client.Update(ctx, podplacementconfig)
+	if err := r.reconcileWorkloadTemplateWebhook(ctx, podplacementwebhook, namespaceSelector); err != nil {
+		klog.Errorf("unable to update the namespaceSelector of the %s webhook: %v", WorkloadTemplateWebhookName, err)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// the PodPlacementConfig written by a newer version of the operator can have fields this version does not know:
+	// they are dropped by the typed object, which is never written back as a whole
+	unknown, err := r.unknownFields(ctx, podplacementconfig)
+	if err != nil {
+		klog.Errorf("unable to look for the unknown fields of the podplacementconfig %s: %v", podplacementconfig.Name,
+			err)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	original := podplacementconfig.DeepCopy()
+	statusChanged := r.setDegradedCondition(podplacementconfig)
+	changed, err := r.setPausedCondition(ctx, podplacementconfig)
+	if err != nil {
+		klog.Errorf("unable to compute the Paused condition: %v", err)
+		return ctrl.Result{}, err
+	}
+	statusChanged = changed || statusChanged
+	if r.ClusterArchitectures != nil {
+		changed, err := r.setEffectivelyDisabledCondition(ctx, podplacementconfig)
+		if err != nil {
+			klog.Errorf("unable to compute the EffectivelyDisabled condition: %v", err)
+			return ctrl.Result{}, err
+		}
+		statusChanged = changed || statusChanged
+	}
+	if r.NodeSyncer != nil {
+		changed, err := r.reconcileNodeSyncer(ctx, podplacementconfig)
+		if err != nil {
+			klog.Errorf("unable to reconcile the node syncer: %v", err)
+			return ctrl.Result{}, err
+		}
+		statusChanged = changed || statusChanged
+	}
+	changed, err = r.forceSystemConfigSync(ctx, podplacementconfig)
 	if err != nil {
-		klog.Errorf("unable to update the podplacementconfig %s: %v", podplacementconfig.Name, err)
+		klog.Errorf("unable to force the sync of the system config: %v", err)
 		return ctrl.Result{}, err
 	}
+	statusChanged = changed || statusChanged
+	var result ctrl.Result
+	if r.ArchitecturesDistribution != nil && r.ArchitecturesStatusInterval > 0 {
+		changed, requeueAfter, err := r.refreshArchitecturesStatus(ctx, podplacementconfig)
+		if err != nil {
+			klog.Errorf("unable to compute the distribution of the architectures: %v", err)
+			return ctrl.Result{}, err
+		}
+		statusChanged = changed || statusChanged
+		result.RequeueAfter = requeueAfter
+	}
+	if podplacementconfig.Status.ObservedGeneration != podplacementconfig.Generation {
+		podplacementconfig.Status.ObservedGeneration = podplacementconfig.Generation
+		statusChanged = true
+	}
+	if statusChanged && r.statusOwnedByNewerVersion(original, unknown) {
+		klog.V(2).Infof("Not updating the status of the podplacementconfig %s: its generation %d was observed by a "+
+			"newer version of the operator", podplacementconfig.Name, podplacementconfig.Generation)
+		statusChanged = false
+	}
+	if statusChanged {
+		// the patch only sets the fields of the status this version knows, leaving the others alone
+		if err = r.Client.Status().Patch(ctx, podplacementconfig, client.MergeFrom(original)); err != nil {
+			klog.Errorf("unable to update the status of the podplacementconfig %s: %v", podplacementconfig.Name, err)
+			// the distribution of the architectures is computed again by the retry
+			r.architecturesRefreshed = time.Time{}
+			return ctrl.Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// refreshArchitecturesStatus sets the distribution of the architectures in the status of the PodPlacementConfig, if
+// ArchitecturesStatusInterval elapsed since the last refresh. It returns true if the status changed: the status is
+// only updated when the distribution changes, not at every refresh. It also returns the time to wait for the next
+// refresh.
+func (r *PodPlacementConfigReconciler) refreshArchitecturesStatus(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) (bool, time.Duration, error) {
+	if r.clock == nil {
+		r.clock = clock.RealClock{}
+	}
+	now := r.clock.Now()
+	if elapsed := now.Sub(r.architecturesRefreshed); elapsed < r.ArchitecturesStatusInterval {
+		return false, r.ArchitecturesStatusInterval - elapsed, nil
+	}
+	clusterArchitectures, pendingGatedPods, err := r.ArchitecturesDistribution(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	r.architecturesRefreshed = now
+	// the empty lists are omitted from the stored status, so that nil and empty lists have to be equal
+	if equality.Semantic.DeepEqual(ppc.Status.ClusterArchitectures, clusterArchitectures) &&
+		equality.Semantic.DeepEqual(ppc.Status.PendingGatedPods, pendingGatedPods) {
+		return false, r.ArchitecturesStatusInterval, nil
+	}
+	ppc.Status.ClusterArchitectures = clusterArchitectures
+	ppc.Status.PendingGatedPods = pendingGatedPods
+	return true, r.ArchitecturesStatusInterval, nil
+}
+
+// forceSystemConfigSync forces the sync of the system config files when the value of the
+// multiarch.openshift.io/force-sync annotation differs from the one of the last forced sync, and records its
+// completion in the status. It returns true if the status changed. A failed sync is retried with the reconcile.
+func (r *PodPlacementConfigReconciler) forceSystemConfigSync(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) (bool, error) {
+	value, ok := ppc.Annotations[multiarchv1alpha1.ForceSyncAnnotation]
+	if !ok || r.ForceSystemConfigSync == nil ||
+		(ppc.Status.ForcedSync != nil && ppc.Status.ForcedSync.Value == value) {
+		return false, nil
+	}
+	if r.clock == nil {
+		r.clock = clock.RealClock{}
+	}
+	klog.Infof("Forcing the sync of the system config, requested by the %s annotation: %q",
+		multiarchv1alpha1.ForceSyncAnnotation, value)
+	if err := r.ForceSystemConfigSync(ctx); err != nil {
+		return false, err
+	}
+	ppc.Status.ForcedSync = &multiarchv1alpha1.ForcedSync{
+		Value:          value,
+		CompletionTime: metav1.NewTime(r.clock.Now()),
+	}
+	return true, nil
+}
+
+// webhookNamespaceSelector returns the namespaceSelector of the PodPlacementConfig, restricted to the WatchNamespaces
+// if any: the pods of the other namespaces would be gated without the pod reconciler ever seeing them. When the
+// GatingLabel is set, it is restricted to the namespaces of the instance too.
+func (r *PodPlacementConfigReconciler) webhookNamespaceSelector(ppc *multiarchv1alpha1.PodPlacementConfig) *metav1.LabelSelector {
+	if len(r.WatchNamespaces) == 0 && r.GatingLabel == "" {
+		return ppc.Spec.NamespaceSelector
+	}
+	selector := &metav1.LabelSelector{}
+	if ppc.Spec.NamespaceSelector != nil {
+		selector = ppc.Spec.NamespaceSelector.DeepCopy()
+	}
+	if len(r.WatchNamespaces) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   sets.List(sets.New(r.WatchNamespaces...)),
+		})
+	}
+	if r.GatingLabel != "" {
+		requirement := metav1.LabelSelectorRequirement{Key: r.GatingLabel, Operator: metav1.LabelSelectorOpDoesNotExist}
+		if r.InstanceName != "" {
+			requirement.Operator = metav1.LabelSelectorOpIn
+			requirement.Values = []string{r.InstanceName}
+		}
+		selector.MatchExpressions = append(selector.MatchExpressions, requirement)
+	}
+	return selector
+}
+
+// reconcileWorkloadTemplateWebhook sets the namespaceSelector of the webhook mutating the pod templates of the
+// workloads, if the MutatingWebhookConfiguration has it: the one of the webhook gating the pods while any
+// PodPlacementConfig sets mutateWorkloadTemplates, or else one matching no namespace, so that the API server does not
+// call the webhook for nothing.
+func (r *PodPlacementConfigReconciler) reconcileWorkloadTemplateWebhook(ctx context.Context,
+	configuration *admissionregistrationv1.MutatingWebhookConfiguration, namespaceSelector *metav1.LabelSelector) error {
+	index := -1
+	for i := range configuration.Webhooks {
+		if configuration.Webhooks[i].Name == WorkloadTemplateWebhookName {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+	podPlacementConfigs := &multiarchv1alpha1.PodPlacementConfigList{}
+	if err := r.List(ctx, podPlacementConfigs); err != nil {
+		return err
+	}
+	mutateWorkloadTemplates := false
+	for _, item := range podPlacementConfigs.Items {
+		mutateWorkloadTemplates = mutateWorkloadTemplates || item.Spec.MutateWorkloadTemplates
+	}
+	if !mutateWorkloadTemplates {
+		// every namespace has the kubernetes.io/metadata.name label
+		namespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		}}}
+	}
+	if reflect.DeepEqual(configuration.Webhooks[index].NamespaceSelector, namespaceSelector) {
+		return nil
+	}
+	selectorBytes, err := json.Marshal(namespaceSelector)
+	if err != nil {
+		return err
+	}
+	ops := fmt.Sprintf(replaceWebhookNamespaceSelectorTemplate, index, WorkloadTemplateWebhookName, index,
+		string(selectorBytes))
+	return r.Patch(ctx, configuration, client.RawPatch(types.JSONPatchType, generatePatchBytes(ops)))
+}
+
+// setDegradedCondition sets the Degraded condition of the PodPlacementConfig according to the capabilities of the
+// cluster. It returns true if the condition changed.
+func (r *PodPlacementConfigReconciler) setDegradedCondition(ppc *multiarchv1alpha1.PodPlacementConfig) bool {
+	condition := metav1.Condition{
+		Type:               multiarchv1alpha1.DegradedConditionType,
+		Status:             metav1.ConditionFalse,
+		Reason:             multiarchv1alpha1.AsExpectedReason,
+		Message:            "The pods are gated until their node affinity is set",
+		ObservedGeneration: ppc.Generation,
+	}
+	if r.SchedulingGatesUnsupported {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = multiarchv1alpha1.SchedulingGatesUnsupportedReason
+		condition.Message = "The cluster does not support the pod scheduling gates: " +
+			"the pods are not gated and no node affinity is set for them"
+	}
+	current := meta.FindStatusCondition(ppc.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(&ppc.Status.Conditions, condition)
+	return true
+}
+
+// setPausedCondition sets the Paused condition of the PodPlacementConfig, true while any PodPlacementConfig pauses the
+// pod placement. It returns true if the condition changed.
+func (r *PodPlacementConfigReconciler) setPausedCondition(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) (bool, error) {
+	podPlacementConfigs := &multiarchv1alpha1.PodPlacementConfigList{}
+	if err := r.List(ctx, podPlacementConfigs); err != nil {
+		return false, err
+	}
+	condition := metav1.Condition{
+		Type:               multiarchv1alpha1.PausedConditionType,
+		Status:             metav1.ConditionFalse,
+		Reason:             multiarchv1alpha1.AsExpectedReason,
+		Message:            "The pod placement is not paused",
+		ObservedGeneration: ppc.Generation,
+	}
+	var pausedBy []string
+	for _, item := range podPlacementConfigs.Items {
+		if item.Spec.Paused {
+			pausedBy = append(pausedBy, item.Name)
+		}
+	}
+	if len(pausedBy) > 0 {
+		sort.Strings(pausedBy)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = multiarchv1alpha1.PlacementPausedReason
+		condition.Message = fmt.Sprintf("The pod placement is paused by the PodPlacementConfig objects %s: the new "+
+			"pods are not gated and the gated pods are released without their node affinity",
+			strings.Join(pausedBy, ", "))
+	}
+	current := meta.FindStatusCondition(ppc.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false, nil
+	}
+	meta.SetStatusCondition(&ppc.Status.Conditions, condition)
+	return true, nil
+}
+
+// setEffectivelyDisabledCondition sets the EffectivelyDisabled condition of the PodPlacementConfig, true while the
+// cluster only has nodes of a single architecture. It returns true if the condition changed.
+func (r *PodPlacementConfigReconciler) setEffectivelyDisabledCondition(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) (bool, error) {
+	architectures, err := r.ClusterArchitectures(ctx)
+	if err != nil {
+		return false, err
+	}
+	podPlacementConfigs := &multiarchv1alpha1.PodPlacementConfigList{}
+	if err := r.List(ctx, podPlacementConfigs); err != nil {
+		return false, err
+	}
+	condition := metav1.Condition{
+		Type:               multiarchv1alpha1.EffectivelyDisabledConditionType,
+		Status:             metav1.ConditionFalse,
+		Reason:             multiarchv1alpha1.AsExpectedReason,
+		Message:            fmt.Sprintf("The cluster has nodes of the architectures %s", strings.Join(architectures, ", ")),
+		ObservedGeneration: ppc.Generation,
+	}
+	switch len(architectures) {
+	case 0:
+		condition.Message = "The architectures of the cluster are not known yet"
+	case 1:
+		skipped := false
+		for _, item := range podPlacementConfigs.Items {
+			skipped = skipped || item.Spec.SkipSingleArchCluster
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = multiarchv1alpha1.SingleArchitectureClusterReason
+		condition.Message = fmt.Sprintf("The cluster only has nodes of the architecture %s: the pods are gated "+
+			"with no benefit. Set skipSingleArchCluster to stop gating them", architectures[0])
+		if skipped {
+			condition.Message = fmt.Sprintf("The cluster only has nodes of the architecture %s: the pods are not "+
+				"gated until a node of another architecture joins the cluster", architectures[0])
+		}
+	}
+	current := meta.FindStatusCondition(ppc.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false, nil
+	}
+	meta.SetStatusCondition(&ppc.Status.Conditions, condition)
+	return true, nil
+}
 
-	return ctrl.Result{}, nil
+// nodeArchitectureChanged returns true if the update of the node can change the architectures of the cluster, i.e.,
+// its architecture labels or whether it is schedulable
+func nodeArchitectureChanged(e event.UpdateEvent) bool {
+	oldNode, newNode := e.ObjectOld.(*corev1.Node), e.ObjectNew.(*corev1.Node)
+	return oldNode.Labels[corev1.LabelArchStable] != newNode.Labels[corev1.LabelArchStable] ||
+		oldNode.Labels[betaArchLabel] != newNode.Labels[betaArchLabel] ||
+		oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodPlacementConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&multiarchv1alpha1.PodPlacementConfig{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&multiarchv1alpha1.PodPlacementConfig{})
+	if r.NodeSyncer != nil {
+		// the changes of the status of the DaemonSet update the NodeSyncerAvailable condition
+		b = b.Owns(&appsv1.DaemonSet{})
+	}
+	if r.ClusterArchitectures != nil {
+		// the changes of the architectures of the nodes update the EffectivelyDisabled condition
+		b = b.Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.podPlacementConfigRequests),
+			builder.WithPredicates(predicate.Funcs{UpdateFunc: nodeArchitectureChanged}))
+	}
+	return b.Complete(r)
+}
+
+// podPlacementConfigRequests returns the requests of all the PodPlacementConfig objects
+func (r *PodPlacementConfigReconciler) podPlacementConfigRequests(ctx context.Context,
+	_ client.Object) []reconcile.Request {
+	podPlacementConfigs := &multiarchv1alpha1.PodPlacementConfigList{}
+	if err := r.List(ctx, podPlacementConfigs); err != nil {
+		klog.Errorf("unable to list the PodPlacementConfig objects: %v", err)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(podPlacementConfigs.Items))
+	for _, item := range podPlacementConfigs.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+	}
+	return requests
 }
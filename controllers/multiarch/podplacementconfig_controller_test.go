@@ -0,0 +1,441 @@
+package multiarch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+var _ = Describe("The PodPlacementConfig Degraded condition", func() {
+	var ppc *multiarchv1alpha1.PodPlacementConfig
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster", Generation: 2},
+		}
+	})
+
+	It("should report the missing support of the scheduling gates", func() {
+		r := &PodPlacementConfigReconciler{SchedulingGatesUnsupported: true}
+		Expect(r.setDegradedCondition(ppc)).To(BeTrue())
+		condition := meta.FindStatusCondition(ppc.Status.Conditions, multiarchv1alpha1.DegradedConditionType)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(multiarchv1alpha1.SchedulingGatesUnsupportedReason))
+		Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+		Expect(r.setDegradedCondition(ppc)).To(BeFalse())
+	})
+
+	It("should clear the condition when the scheduling gates are supported", func() {
+		Expect((&PodPlacementConfigReconciler{SchedulingGatesUnsupported: true}).setDegradedCondition(ppc)).To(BeTrue())
+		Expect((&PodPlacementConfigReconciler{}).setDegradedCondition(ppc)).To(BeTrue())
+		Expect(ppc.Status.Conditions).To(HaveLen(1))
+		Expect(meta.IsStatusConditionFalse(ppc.Status.Conditions, multiarchv1alpha1.DegradedConditionType)).To(BeTrue())
+	})
+})
+
+var _ = Describe("The PodPlacementConfig Paused condition", func() {
+	var (
+		r   *PodPlacementConfigReconciler
+		ppc *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Generation: 2}}
+		scheme := runtime.NewScheme()
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		r = &PodPlacementConfigReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(ppc).Build()}
+	})
+
+	setPausedCondition := func() bool {
+		changed, err := r.setPausedCondition(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		return changed
+	}
+
+	It("should be false when no PodPlacementConfig is paused", func() {
+		Expect(setPausedCondition()).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(ppc.Status.Conditions, multiarchv1alpha1.PausedConditionType)).To(BeTrue())
+		Expect(setPausedCondition()).To(BeFalse())
+	})
+
+	It("should report the PodPlacementConfig objects pausing the pod placement", func() {
+		Expect(setPausedCondition()).To(BeTrue())
+		Expect(r.Create(context.Background(), &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "incident"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Paused: true},
+		})).To(Succeed())
+		Expect(setPausedCondition()).To(BeTrue())
+		condition := meta.FindStatusCondition(ppc.Status.Conditions, multiarchv1alpha1.PausedConditionType)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(multiarchv1alpha1.PlacementPausedReason))
+		Expect(condition.Message).To(ContainSubstring("incident"))
+		Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+		Expect(setPausedCondition()).To(BeFalse())
+	})
+})
+
+var _ = Describe("The PodPlacementConfig EffectivelyDisabled condition", func() {
+	var (
+		r             *PodPlacementConfigReconciler
+		ppc           *multiarchv1alpha1.PodPlacementConfig
+		architectures []string
+	)
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Generation: 2}}
+		scheme := runtime.NewScheme()
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		architectures = []string{"amd64"}
+		r = &PodPlacementConfigReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(ppc).Build(),
+			ClusterArchitectures: func(context.Context) ([]string, error) {
+				return architectures, nil
+			},
+		}
+	})
+
+	setEffectivelyDisabledCondition := func() *metav1.Condition {
+		_, err := r.setEffectivelyDisabledCondition(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		return meta.FindStatusCondition(ppc.Status.Conditions, multiarchv1alpha1.EffectivelyDisabledConditionType)
+	}
+
+	It("should be true while the cluster has a single architecture, in both directions", func() {
+		condition := setEffectivelyDisabledCondition()
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(multiarchv1alpha1.SingleArchitectureClusterReason))
+		Expect(condition.Message).To(ContainSubstring("Set skipSingleArchCluster"))
+		Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+
+		architectures = []string{"amd64", "arm64"}
+		condition = setEffectivelyDisabledCondition()
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(multiarchv1alpha1.AsExpectedReason))
+		Expect(condition.Message).To(ContainSubstring("amd64, arm64"))
+
+		architectures = []string{"arm64"}
+		Expect(setEffectivelyDisabledCondition().Status).To(Equal(metav1.ConditionTrue))
+	})
+
+	It("should report that the pods are not gated when the single-architecture clusters are skipped", func() {
+		Expect(r.Create(context.Background(), &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "skip"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{SkipSingleArchCluster: true},
+		})).To(Succeed())
+		Expect(setEffectivelyDisabledCondition().Message).To(ContainSubstring("the pods are not gated"))
+	})
+
+	It("should be false while the architectures of the cluster are not known", func() {
+		architectures = nil
+		Expect(setEffectivelyDisabledCondition().Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("should only change when the architectures change", func() {
+		changed, err := r.setEffectivelyDisabledCondition(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		changed, err = r.setEffectivelyDisabledCondition(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+	})
+
+	It("should fail when the architectures of the cluster cannot be read", func() {
+		r.ClusterArchitectures = func(context.Context) ([]string, error) {
+			return nil, errors.New("the nodes cannot be listed")
+		}
+		_, err := r.setEffectivelyDisabledCondition(context.Background(), ppc)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("The webhook namespaceSelector", func() {
+	var ppc *multiarchv1alpha1.PodPlacementConfig
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{
+			Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      "multiarch.openshift.io/excludeNamespace",
+						Operator: metav1.LabelSelectorOpDoesNotExist,
+					}},
+				},
+			},
+		}
+	})
+
+	It("should be the one of the PodPlacementConfig when all the namespaces are watched", func() {
+		Expect((&PodPlacementConfigReconciler{}).webhookNamespaceSelector(ppc)).To(Equal(ppc.Spec.NamespaceSelector))
+	})
+
+	It("should only match the watched namespaces", func() {
+		r := &PodPlacementConfigReconciler{WatchNamespaces: []string{"team-b", "team-a"}}
+		selector := r.webhookNamespaceSelector(ppc)
+		Expect(selector.MatchExpressions).To(Equal([]metav1.LabelSelectorRequirement{
+			ppc.Spec.NamespaceSelector.MatchExpressions[0],
+			{
+				Key:      "kubernetes.io/metadata.name",
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{"team-a", "team-b"},
+			},
+		}))
+		// the PodPlacementConfig is not modified
+		Expect(ppc.Spec.NamespaceSelector.MatchExpressions).To(HaveLen(1))
+	})
+
+	It("should only match the watched namespaces when the PodPlacementConfig has no selector", func() {
+		ppc.Spec.NamespaceSelector = nil
+		r := &PodPlacementConfigReconciler{WatchNamespaces: []string{"team-a"}}
+		Expect(r.webhookNamespaceSelector(ppc).MatchExpressions).To(HaveLen(1))
+	})
+
+	It("should not match the namespaces of the other instances", func() {
+		r := &PodPlacementConfigReconciler{GatingLabel: "multiarch.openshift.io/instance"}
+		Expect(r.webhookNamespaceSelector(ppc).MatchExpressions).To(Equal([]metav1.LabelSelectorRequirement{
+			ppc.Spec.NamespaceSelector.MatchExpressions[0],
+			{Key: "multiarch.openshift.io/instance", Operator: metav1.LabelSelectorOpDoesNotExist},
+		}))
+		r.InstanceName = "staging"
+		r.WatchNamespaces = []string{"team-a"}
+		Expect(r.webhookNamespaceSelector(ppc).MatchExpressions).To(Equal([]metav1.LabelSelectorRequirement{
+			ppc.Spec.NamespaceSelector.MatchExpressions[0],
+			{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpIn, Values: []string{"team-a"}},
+			{Key: "multiarch.openshift.io/instance", Operator: metav1.LabelSelectorOpIn, Values: []string{"staging"}},
+		}))
+		Expect(ppc.Spec.NamespaceSelector.MatchExpressions).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("The namespaceSelector of the workload template webhook", func() {
+	var (
+		r             *PodPlacementConfigReconciler
+		configuration *admissionregistrationv1.MutatingWebhookConfiguration
+		selector      *metav1.LabelSelector
+	)
+
+	BeforeEach(func() {
+		configuration = &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultMutatingWebhookConfigurationName},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "pod-placement-scheduling-gate.multiarch.openshift.io"},
+				{Name: WorkloadTemplateWebhookName},
+			},
+		}
+		selector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		r = &PodPlacementConfigReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			configuration,
+			&multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}},
+		).Build()}
+	})
+
+	workloadTemplateSelector := func() *metav1.LabelSelector {
+		Expect(r.reconcileWorkloadTemplateWebhook(context.Background(), configuration, selector)).To(Succeed())
+		updated := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(r.Get(context.Background(), client.ObjectKeyFromObject(configuration), updated)).To(Succeed())
+		Expect(updated.Webhooks[0].NamespaceSelector).To(BeNil())
+		return updated.Webhooks[1].NamespaceSelector
+	}
+
+	It("should match no namespace unless a PodPlacementConfig sets mutateWorkloadTemplates", func() {
+		Expect(workloadTemplateSelector()).To(Equal(&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpDoesNotExist},
+			},
+		}))
+	})
+
+	It("should be the one of the webhook gating the pods while a PodPlacementConfig sets mutateWorkloadTemplates",
+		func() {
+			Expect(r.Create(context.Background(), &multiarchv1alpha1.PodPlacementConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "workloads"},
+				Spec:       multiarchv1alpha1.PodPlacementConfigSpec{MutateWorkloadTemplates: true},
+			})).To(Succeed())
+			Expect(workloadTemplateSelector()).To(Equal(selector))
+		})
+
+	It("should ignore the configurations without the webhook", func() {
+		configuration.Webhooks = configuration.Webhooks[:1]
+		Expect(r.reconcileWorkloadTemplateWebhook(context.Background(), configuration, selector)).To(Succeed())
+	})
+})
+
+var _ = Describe("The distribution of the architectures in the PodPlacementConfig status", func() {
+	var (
+		r            *PodPlacementConfigReconciler
+		ppc          *multiarchv1alpha1.PodPlacementConfig
+		fakeClock    *clocktesting.FakeClock
+		computations int
+		amd64Nodes   int32
+	)
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		computations = 0
+		amd64Nodes = 3
+		r = &PodPlacementConfigReconciler{
+			ArchitecturesStatusInterval: time.Minute,
+			ArchitecturesDistribution: func(context.Context) ([]multiarchv1alpha1.ArchitectureNodes,
+				[]multiarchv1alpha1.GatedPodsRequirement, error) {
+				computations++
+				return []multiarchv1alpha1.ArchitectureNodes{{Architecture: "amd64", Nodes: amd64Nodes}},
+					[]multiarchv1alpha1.GatedPodsRequirement{}, nil
+			},
+			clock: fakeClock,
+		}
+	})
+
+	It("should only be computed once per interval", func() {
+		changed, requeueAfter, err := r.refreshArchitecturesStatus(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(requeueAfter).To(Equal(time.Minute))
+		Expect(ppc.Status.ClusterArchitectures).To(Equal([]multiarchv1alpha1.ArchitectureNodes{
+			{Architecture: "amd64", Nodes: 3}}))
+
+		amd64Nodes = 4
+		fakeClock.Step(20 * time.Second)
+		changed, requeueAfter, err = r.refreshArchitecturesStatus(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(requeueAfter).To(Equal(40 * time.Second))
+		Expect(computations).To(Equal(1))
+
+		fakeClock.Step(40 * time.Second)
+		changed, _, err = r.refreshArchitecturesStatus(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(ppc.Status.ClusterArchitectures[0].Nodes).To(BeNumerically("==", 4))
+	})
+
+	It("should only change the status when the distribution changes", func() {
+		changed, _, err := r.refreshArchitecturesStatus(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		// the empty lists are omitted by the stored status
+		ppc.Status.PendingGatedPods = nil
+		fakeClock.Step(time.Minute)
+		changed, requeueAfter, err := r.refreshArchitecturesStatus(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(requeueAfter).To(Equal(time.Minute))
+		Expect(computations).To(Equal(2))
+	})
+})
+
+var _ = Describe("The forced sync of the system config", func() {
+	var (
+		r         *PodPlacementConfigReconciler
+		ppc       *multiarchv1alpha1.PodPlacementConfig
+		fakeClock *clocktesting.FakeClock
+		syncs     int
+		syncErr   error
+	)
+
+	BeforeEach(func() {
+		ppc = &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		syncs = 0
+		syncErr = nil
+		r = &PodPlacementConfigReconciler{
+			ForceSystemConfigSync: func(context.Context) error {
+				syncs++
+				return syncErr
+			},
+			clock: fakeClock,
+		}
+	})
+
+	forceSync := func(value string) bool {
+		ppc.Annotations = map[string]string{multiarchv1alpha1.ForceSyncAnnotation: value}
+		changed, err := r.forceSystemConfigSync(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		return changed
+	}
+
+	It("should sync once per value of the annotation and record it in the status", func() {
+		Expect(forceSync("2023-05-04T10:00:00Z")).To(BeTrue())
+		Expect(syncs).To(Equal(1))
+		Expect(ppc.Status.ForcedSync).To(Equal(&multiarchv1alpha1.ForcedSync{
+			Value:          "2023-05-04T10:00:00Z",
+			CompletionTime: metav1.NewTime(fakeClock.Now()),
+		}))
+
+		fakeClock.Step(time.Minute)
+		Expect(forceSync("2023-05-04T10:00:00Z")).To(BeFalse())
+		Expect(syncs).To(Equal(1))
+
+		Expect(forceSync("2023-05-04T11:00:00Z")).To(BeTrue())
+		Expect(syncs).To(Equal(2))
+		Expect(ppc.Status.ForcedSync.CompletionTime).To(Equal(metav1.NewTime(fakeClock.Now())))
+	})
+
+	It("should not sync without the annotation", func() {
+		changed, err := r.forceSystemConfigSync(context.Background(), ppc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(syncs).To(BeZero())
+	})
+
+	It("should retry the failed syncs", func() {
+		syncErr = errors.New("unable to write registries.conf")
+		ppc.Annotations = map[string]string{multiarchv1alpha1.ForceSyncAnnotation: "1"}
+		_, err := r.forceSystemConfigSync(context.Background(), ppc)
+		Expect(err).To(MatchError(syncErr))
+		Expect(ppc.Status.ForcedSync).To(BeNil())
+
+		syncErr = nil
+		Expect(forceSync("1")).To(BeTrue())
+		Expect(syncs).To(Equal(2))
+	})
+})
+
+var _ = Describe("The fields of the PodPlacementConfig unknown to the operator", func() {
+	It("should list the fields missing from the typed object", func() {
+		object := map[string]interface{}{
+			"paused":    true,
+			"future":    "enabled",
+			"admission": map[string]interface{}{"denyImpossiblePods": true, "futureMode": "Strict"},
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Degraded", "futureDetail": "none"},
+			},
+		}
+		typed := map[string]interface{}{
+			"paused":     true,
+			"admission":  map[string]interface{}{"denyImpossiblePods": true},
+			"conditions": []interface{}{map[string]interface{}{"type": "Degraded"}},
+		}
+		Expect(appendUnknownFields(nil, "spec", object, typed)).To(ConsistOf("spec.future",
+			"spec.admission.futureMode", "spec.conditions[0].futureDetail"))
+		Expect(appendUnknownFields(nil, "spec", typed, typed)).To(BeEmpty())
+	})
+
+	It("should only leave the status of the observed generations to the newer versions", func() {
+		r := &PodPlacementConfigReconciler{}
+		ppc := &multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Generation: 3}}
+		ppc.Status.ObservedGeneration = 2
+		Expect(r.statusOwnedByNewerVersion(ppc, []string{"spec.future"})).To(BeFalse())
+		ppc.Status.ObservedGeneration = 3
+		Expect(r.statusOwnedByNewerVersion(ppc, []string{"spec.future"})).To(BeTrue())
+		Expect(r.statusOwnedByNewerVersion(ppc, nil)).To(BeFalse())
+	})
+})
@@ -0,0 +1,151 @@
+package multiarch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-multiarch-openshift-io-v1alpha1-podplacementconfig,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups=multiarch.openshift.io,resources=podplacementconfigs,verbs=create;update,versions=v1alpha1,name=validate-podplacementconfig.multiarch.openshift.io
+
+// PodPlacementConfigValidator rejects the PodPlacementConfig objects with invalid or conflicting fields. The error
+// names all the fields to fix.
+type PodPlacementConfigValidator struct{}
+
+var _ admission.CustomValidator = &PodPlacementConfigValidator{}
+
+// ValidateCreate validates the config
+func (v *PodPlacementConfigValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, validatePodPlacementConfig(obj)
+}
+
+// ValidateUpdate validates the updated config
+func (v *PodPlacementConfigValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, validatePodPlacementConfig(newObj)
+}
+
+// ValidateDelete allows the deletion of any config
+func (v *PodPlacementConfigValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validatePodPlacementConfig(obj runtime.Object) error {
+	ppc, ok := obj.(*multiarchv1alpha1.PodPlacementConfig)
+	if !ok {
+		return fmt.Errorf("expected a PodPlacementConfig, got %T", obj)
+	}
+	if errs := ValidatePodPlacementConfigSpec(&ppc.Spec); len(errs) > 0 {
+		return apierrors.NewInvalid(multiarchv1alpha1.GroupVersion.WithKind("PodPlacementConfig").GroupKind(),
+			ppc.Name, errs)
+	}
+	return nil
+}
+
+// ValidatePodPlacementConfigSpec returns the errors of the invalid or conflicting fields of the spec of a
+// PodPlacementConfig, including the ones of its placement settings
+func ValidatePodPlacementConfigSpec(spec *multiarchv1alpha1.PodPlacementConfigSpec) field.ErrorList {
+	specPath := field.NewPath("spec")
+	errs := validatePlacementPolicy(&spec.PlacementPolicy, specPath)
+	if spec.NamespaceSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(spec.NamespaceSelector,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("namespaceSelector"))...)
+	}
+	errs = append(errs, validateExtendedResourceArchitectures(spec.ExtendedResourceArchitectures,
+		specPath.Child("extendedResourceArchitectures"))...)
+	if spec.Tuning != nil {
+		errs = append(errs, validateTuning(spec.Tuning, specPath.Child("tuning"))...)
+	}
+	if spec.KeepDecisionAnnotations && !spec.CleanupAnnotationsAfterScheduling {
+		errs = append(errs, field.Invalid(specPath.Child("keepDecisionAnnotations"), spec.KeepDecisionAnnotations,
+			"requires cleanupAnnotationsAfterScheduling to be true: the annotations are only removed when it is set"))
+	}
+	return errs
+}
+
+// validateExtendedResourceArchitectures returns the errors of the mapping of the extended resources to their
+// architectures: the names must be extended resource names, each one listed once
+func validateExtendedResourceArchitectures(mapping []multiarchv1alpha1.ExtendedResourceArchitectures,
+	fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := sets.New[string]()
+	for i, resource := range mapping {
+		namePath := fldPath.Index(i).Child("name")
+		if !isExtendedResourceName(resource.Name) {
+			errs = append(errs, field.Invalid(namePath, resource.Name, "must be the name of an extended resource, "+
+				"qualified by a domain other than kubernetes.io, e.g., nvidia.com/gpu"))
+		} else if names.Has(resource.Name) {
+			errs = append(errs, field.Duplicate(namePath, resource.Name))
+		}
+		names.Insert(resource.Name)
+		errs = append(errs, validateArchitectures(resource.Architectures, fldPath.Index(i).Child("architectures"))...)
+	}
+	return errs
+}
+
+// validateTuning returns the errors of the tunables out of their range. The TTLs of the caches of the inspections can
+// also be zero, as their flags.
+func validateTuning(tuning *multiarchv1alpha1.TuningSettings, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, d := range []struct {
+		name     string
+		value    *metav1.Duration
+		min, max time.Duration
+		zero     bool
+	}{
+		{"inspectionTimeout", tuning.InspectionTimeout, time.Second, time.Hour, false},
+		{"architecturesCacheTTL", tuning.ArchitecturesCacheTTL, time.Minute, 168 * time.Hour, true},
+		{"notFoundCacheTTL", tuning.NotFoundCacheTTL, time.Second, 24 * time.Hour, true},
+		{"unauthorizedCacheTTL", tuning.UnauthorizedCacheTTL, time.Second, 24 * time.Hour, true},
+		{"decisionCacheTTL", tuning.DecisionCacheTTL, time.Second, 24 * time.Hour, false},
+		{"maxRetryableBackoff", tuning.MaxRetryableBackoff, time.Second, time.Hour, false},
+		{"maxPermanentFailureBackoff", tuning.MaxPermanentFailureBackoff, time.Minute, 24 * time.Hour, false},
+		{"gatedPodsSweepInterval", tuning.GatedPodsSweepInterval, 10 * time.Second, 24 * time.Hour, false},
+	} {
+		if d.value == nil || (d.zero && d.value.Duration == 0) {
+			continue
+		}
+		if d.value.Duration < d.min || d.value.Duration > d.max {
+			detail := fmt.Sprintf("must be between %v and %v", d.min, d.max)
+			if d.zero {
+				detail += ", or zero"
+			}
+			errs = append(errs, field.Invalid(fldPath.Child(d.name), d.value.Duration.String(), detail))
+		}
+	}
+	if n := tuning.MaxParallelInspections; n != nil && (*n < 1 || *n > 1000) {
+		errs = append(errs, field.Invalid(fldPath.Child("maxParallelInspections"), *n, "must be between 1 and 1000"))
+	}
+	return errs
+}
+
+// isExtendedResourceName returns true if the name is the one of an extended resource, as the API server validates it:
+// a qualified name with a domain other than kubernetes.io and its subdomains, that is still a qualified name with the
+// requests. prefix of the resource quotas
+func isExtendedResourceName(name string) bool {
+	domain, _, ok := strings.Cut(name, "/")
+	if !ok || domain == "kubernetes.io" || strings.HasSuffix(domain, ".kubernetes.io") ||
+		strings.HasPrefix(name, "requests.") {
+		return false
+	}
+	return len(validation.IsQualifiedName("requests."+name)) == 0
+}
+
+// SetupWebhookWithManager registers the validating webhook of the PodPlacementConfig objects with the Manager
+func (v *PodPlacementConfigValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&multiarchv1alpha1.PodPlacementConfig{}).
+		WithValidator(v).
+		Complete()
+}
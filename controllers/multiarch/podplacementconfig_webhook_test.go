@@ -0,0 +1,166 @@
+package multiarch
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+func podPlacementConfig(spec multiarchv1alpha1.PodPlacementConfigSpec) *multiarchv1alpha1.PodPlacementConfig {
+	return &multiarchv1alpha1.PodPlacementConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Spec:       spec,
+	}
+}
+
+func placementSpec(policy multiarchv1alpha1.PlacementPolicy) multiarchv1alpha1.PodPlacementConfigSpec {
+	return multiarchv1alpha1.PodPlacementConfigSpec{PlacementPolicy: policy}
+}
+
+func namespaceSelectorSpec(selector *metav1.LabelSelector) multiarchv1alpha1.PodPlacementConfigSpec {
+	return multiarchv1alpha1.PodPlacementConfigSpec{NamespaceSelector: selector}
+}
+
+var _ = Describe("The PodPlacementConfig validating webhook", func() {
+	ctx := context.Background()
+	v := &PodPlacementConfigValidator{}
+
+	DescribeTable("should accept the valid specs",
+		func(spec multiarchv1alpha1.PodPlacementConfigSpec) {
+			_, err := v.ValidateCreate(ctx, podPlacementConfig(spec))
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("empty", multiarchv1alpha1.PodPlacementConfigSpec{}),
+		Entry("all the placement settings", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			PlacementMode:        multiarchv1alpha1.PlacementModePreferred,
+			AllowedArchitectures: []string{"amd64", "aarch64"},
+			FailurePolicy:        multiarchv1alpha1.PlacementFailurePolicyFail,
+		})),
+		Entry("opted out", placementSpec(multiarchv1alpha1.PlacementPolicy{OptOut: pointer.Bool(false)})),
+		Entry("namespace selector", namespaceSelectorSpec(&metav1.LabelSelector{
+			MatchLabels: map[string]string{"environment": "prod"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "runlevel", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"0", "1"}},
+			},
+		})),
+		Entry("decision annotations kept by the cleanup", multiarchv1alpha1.PodPlacementConfigSpec{
+			CleanupAnnotationsAfterScheduling: true,
+			KeepDecisionAnnotations:           true,
+		}),
+		Entry("extended resources mapped to their architectures", multiarchv1alpha1.PodPlacementConfigSpec{
+			ExtendedResourceArchitectures: []multiarchv1alpha1.ExtendedResourceArchitectures{
+				{Name: "nvidia.com/gpu", Architectures: []string{"amd64", "aarch64"}},
+				{Name: "example.com/fpga"},
+			},
+		}),
+		Entry("tuning", multiarchv1alpha1.PodPlacementConfigSpec{Tuning: &multiarchv1alpha1.TuningSettings{
+			InspectionTimeout:      &metav1.Duration{Duration: 30 * time.Second},
+			MaxParallelInspections: pointer.Int32(8),
+			ArchitecturesCacheTTL:  &metav1.Duration{},
+			NotFoundCacheTTL:       &metav1.Duration{Duration: time.Minute},
+			GatedPodsSweepInterval: &metav1.Duration{Duration: 5 * time.Minute},
+		}}),
+	)
+
+	DescribeTable("should reject the invalid specs naming the fields to fix",
+		func(spec multiarchv1alpha1.PodPlacementConfigSpec, messages ...string) {
+			_, err := v.ValidateCreate(ctx, podPlacementConfig(spec))
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			for _, message := range messages {
+				Expect(err.Error()).To(ContainSubstring(message))
+			}
+			_, err = v.ValidateUpdate(ctx, podPlacementConfig(multiarchv1alpha1.PodPlacementConfigSpec{}),
+				podPlacementConfig(spec))
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		},
+		Entry("invalid placement mode", placementSpec(multiarchv1alpha1.PlacementPolicy{PlacementMode: "Sometimes"}),
+			`spec.placementMode: Unsupported value: "Sometimes": supported values: "Required", "Preferred"`),
+		Entry("invalid failure policy", placementSpec(multiarchv1alpha1.PlacementPolicy{FailurePolicy: "Hold"}),
+			`spec.failurePolicy: Unsupported value: "Hold": supported values: "Ignore", "Fail"`),
+		Entry("unknown architecture", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			AllowedArchitectures: []string{"amd64", "vax"},
+		}), `spec.allowedArchitectures[1]: Invalid value: "vax": unknown architecture "vax"`),
+		Entry("empty architecture", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			AllowedArchitectures: []string{""},
+		}), `spec.allowedArchitectures[0]: Invalid value: "": unknown architecture ""`),
+		Entry("duplicate architecture", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			AllowedArchitectures: []string{"arm64", "amd64", "arm64"},
+		}), `spec.allowedArchitectures[2]: Duplicate value: "arm64"`),
+		Entry("duplicate architecture through its alias", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			AllowedArchitectures: []string{"arm64", "aarch64"},
+		}), `spec.allowedArchitectures[1]: Duplicate value: "aarch64"`),
+		Entry("duplicate default architecture through its alias", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			DefaultArchitectures: []string{"amd64", "x86_64"},
+		}), `spec.defaultArchitectures[1]: Duplicate value: "x86_64"`),
+		Entry("placement mode of the opted-out pods", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			OptOut:        pointer.Bool(true),
+			PlacementMode: multiarchv1alpha1.PlacementModeRequired,
+		}), "spec.placementMode: Forbidden: must not be set when optOut is true"),
+		Entry("allowed architectures of the opted-out pods", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			OptOut:               pointer.Bool(true),
+			AllowedArchitectures: []string{"amd64"},
+		}), "spec.allowedArchitectures: Forbidden: must not be set when optOut is true"),
+		Entry("failure policy of the opted-out pods", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			OptOut:        pointer.Bool(true),
+			FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+		}), "spec.failurePolicy: Forbidden: must not be set when optOut is true"),
+		Entry("default architectures of the opted-out pods", placementSpec(multiarchv1alpha1.PlacementPolicy{
+			OptOut:               pointer.Bool(true),
+			DefaultArchitectures: []string{"amd64"},
+		}), "spec.defaultArchitectures: Forbidden: must not be set when optOut is true"),
+		Entry("decision annotations kept without the cleanup", multiarchv1alpha1.PodPlacementConfigSpec{
+			KeepDecisionAnnotations: true,
+		}, "spec.keepDecisionAnnotations: Invalid value: true: requires cleanupAnnotationsAfterScheduling to be true"),
+		Entry("invalid namespace selector operator", namespaceSelectorSpec(&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "runlevel", Operator: "Maybe"}},
+		}), `spec.namespaceSelector.matchExpressions[0].operator: Invalid value: "Maybe": not a valid selector `+
+			`operator`),
+		Entry("namespace selector without values", namespaceSelectorSpec(&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "runlevel", Operator: metav1.LabelSelectorOpIn}},
+		}), "spec.namespaceSelector.matchExpressions[0].values: Required value"),
+		Entry("invalid namespace selector label", namespaceSelectorSpec(&metav1.LabelSelector{
+			MatchLabels: map[string]string{"environment": "prod/eu"},
+		}), `spec.namespaceSelector.matchLabels: Invalid value: "prod/eu"`),
+		Entry("native resource mapped to architectures", multiarchv1alpha1.PodPlacementConfigSpec{
+			ExtendedResourceArchitectures: []multiarchv1alpha1.ExtendedResourceArchitectures{{Name: "cpu"}},
+		}, `spec.extendedResourceArchitectures[0].name: Invalid value: "cpu": must be the name of an extended resource`),
+		Entry("resource of the kubernetes.io domain", multiarchv1alpha1.PodPlacementConfigSpec{
+			ExtendedResourceArchitectures: []multiarchv1alpha1.ExtendedResourceArchitectures{
+				{Name: "hugepages.kubernetes.io/2Mi"},
+			},
+		}, `spec.extendedResourceArchitectures[0].name: Invalid value: "hugepages.kubernetes.io/2Mi"`),
+		Entry("duplicate extended resource", multiarchv1alpha1.PodPlacementConfigSpec{
+			ExtendedResourceArchitectures: []multiarchv1alpha1.ExtendedResourceArchitectures{
+				{Name: "nvidia.com/gpu"}, {Name: "nvidia.com/gpu", Architectures: []string{"vax"}},
+			},
+		}, `spec.extendedResourceArchitectures[1].name: Duplicate value: "nvidia.com/gpu"`,
+			`spec.extendedResourceArchitectures[1].architectures[0]: Invalid value: "vax"`),
+		Entry("tunables out of their range", multiarchv1alpha1.PodPlacementConfigSpec{
+			Tuning: &multiarchv1alpha1.TuningSettings{
+				InspectionTimeout:          &metav1.Duration{},
+				MaxParallelInspections:     pointer.Int32(0),
+				ArchitecturesCacheTTL:      &metav1.Duration{Duration: time.Second},
+				MaxPermanentFailureBackoff: &metav1.Duration{Duration: 48 * time.Hour},
+			},
+		}, `spec.tuning.inspectionTimeout: Invalid value: "0s": must be between 1s and 1h0m0s`,
+			"spec.tuning.maxParallelInspections: Invalid value: 0: must be between 1 and 1000",
+			`spec.tuning.architecturesCacheTTL: Invalid value: "1s": must be between 1m0s and 168h0m0s, or zero`,
+			`spec.tuning.maxPermanentFailureBackoff: Invalid value: "48h0m0s"`),
+		Entry("several invalid fields", multiarchv1alpha1.PodPlacementConfigSpec{
+			PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+				PlacementMode:        "Sometimes",
+				AllowedArchitectures: []string{"amd64", "x86_64"},
+			},
+			KeepDecisionAnnotations: true,
+		}, "spec.placementMode: Unsupported value", `spec.allowedArchitectures[1]: Duplicate value: "x86_64"`,
+			"spec.keepDecisionAnnotations: Invalid value"),
+	)
+})
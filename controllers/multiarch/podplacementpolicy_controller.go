@@ -0,0 +1,182 @@
+package multiarch
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodPlacementPolicyReconciler reconciles the PodPlacementPolicy objects. It reports through their Valid condition
+// whether they are applied to the pods of their namespace.
+type PodPlacementPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=multiarch.openshift.io,resources=podplacementpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=multiarch.openshift.io,resources=podplacementpolicies/status,verbs=get;update;patch
+
+// Reconcile sets the Valid condition of the PodPlacementPolicy objects of the namespace of the request. The validity
+// of a policy depends on the other policies of its namespace, so that they are all reconciled together.
+func (r *PodPlacementPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policies := &multiarchv1alpha1.PodPlacementPolicyList{}
+	if err := r.List(ctx, policies, client.InNamespace(req.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	active := ActivePodPlacementPolicy(policies.Items)
+	var errs []error
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.DeletionTimestamp != nil || !setValidCondition(policy, active) {
+			continue
+		}
+		if err := r.Status().Update(ctx, policy); err != nil {
+			klog.Errorf("unable to update the status of the podplacementpolicy %s/%s: %v", policy.Namespace,
+				policy.Name, err)
+			errs = append(errs, err)
+		}
+	}
+	return ctrl.Result{}, utilerrors.NewAggregate(errs)
+}
+
+// setValidCondition sets the Valid condition of the policy, given the active policy of its namespace. The active policy
+// is not nil if the policy is valid and not being deleted. It returns true if the condition changed.
+func setValidCondition(policy, active *multiarchv1alpha1.PodPlacementPolicy) bool {
+	condition := metav1.Condition{
+		Type:               multiarchv1alpha1.ValidConditionType,
+		Status:             metav1.ConditionTrue,
+		Reason:             multiarchv1alpha1.AsExpectedReason,
+		Message:            "The policy applies to the pods of the namespace",
+		ObservedGeneration: policy.Generation,
+	}
+	if err := ValidatePlacementPolicy(&policy.Spec.PlacementPolicy); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = multiarchv1alpha1.InvalidSpecReason
+		condition.Message = err.Error()
+	} else if active.UID != policy.UID {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = multiarchv1alpha1.MultiplePoliciesReason
+		condition.Message = fmt.Sprintf("The older PodPlacementPolicy %s applies to the pods of the namespace",
+			active.Name)
+	}
+	current := meta.FindStatusCondition(policy.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	return true
+}
+
+// ActivePodPlacementPolicy returns the policy applied to the pods of a namespace, given all the policies of the
+// namespace: the oldest valid one. The validating webhook rejects the creation of more than one policy per namespace,
+// but it does not prevent concurrent creations, nor the ones happening while it is unavailable.
+// It returns nil if no policy is valid.
+func ActivePodPlacementPolicy(policies []multiarchv1alpha1.PodPlacementPolicy) *multiarchv1alpha1.PodPlacementPolicy {
+	var valid []*multiarchv1alpha1.PodPlacementPolicy
+	for i := range policies {
+		if ValidatePlacementPolicy(&policies[i].Spec.PlacementPolicy) == nil && policies[i].DeletionTimestamp == nil {
+			valid = append(valid, &policies[i])
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	sort.Slice(valid, func(i, j int) bool {
+		if !valid[i].CreationTimestamp.Equal(&valid[j].CreationTimestamp) {
+			return valid[i].CreationTimestamp.Before(&valid[j].CreationTimestamp)
+		}
+		return valid[i].Name < valid[j].Name
+	})
+	return valid[0]
+}
+
+// ValidatePlacementPolicy returns an error if the placement settings are invalid or conflicting. The error lists all
+// the invalid fields of the spec.
+func ValidatePlacementPolicy(policy *multiarchv1alpha1.PlacementPolicy) error {
+	return validatePlacementPolicy(policy, field.NewPath("spec")).ToAggregate()
+}
+
+// validatePlacementPolicy returns the errors of the invalid or conflicting fields of the placement settings, whose
+// fields are children of fldPath
+func validatePlacementPolicy(policy *multiarchv1alpha1.PlacementPolicy, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch policy.PlacementMode {
+	case "", multiarchv1alpha1.PlacementModeRequired, multiarchv1alpha1.PlacementModePreferred:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("placementMode"), string(policy.PlacementMode), []string{
+			string(multiarchv1alpha1.PlacementModeRequired), string(multiarchv1alpha1.PlacementModePreferred)}))
+	}
+	switch policy.FailurePolicy {
+	case "", multiarchv1alpha1.PlacementFailurePolicyIgnore, multiarchv1alpha1.PlacementFailurePolicyFail:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("failurePolicy"), string(policy.FailurePolicy), []string{
+			string(multiarchv1alpha1.PlacementFailurePolicyIgnore),
+			string(multiarchv1alpha1.PlacementFailurePolicyFail),
+		}))
+	}
+	errs = append(errs, validateArchitectures(policy.AllowedArchitectures, fldPath.Child("allowedArchitectures"))...)
+	errs = append(errs, validateArchitectures(policy.DefaultArchitectures, fldPath.Child("defaultArchitectures"))...)
+	for i, name := range policy.ExcludedContainerNames {
+		if _, err := path.Match(name, ""); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("excludedContainerNames").Index(i), name, err.Error()))
+		}
+	}
+	if policy.OptOut != nil && *policy.OptOut {
+		// the pods of the opted-out namespaces are not placed
+		const optedOut = "must not be set when optOut is true: the pods are not placed"
+		if policy.PlacementMode != "" {
+			errs = append(errs, field.Forbidden(fldPath.Child("placementMode"), optedOut))
+		}
+		if len(policy.AllowedArchitectures) > 0 {
+			errs = append(errs, field.Forbidden(fldPath.Child("allowedArchitectures"), optedOut))
+		}
+		if policy.FailurePolicy != "" {
+			errs = append(errs, field.Forbidden(fldPath.Child("failurePolicy"), optedOut))
+		}
+		if len(policy.DefaultArchitectures) > 0 {
+			errs = append(errs, field.Forbidden(fldPath.Child("defaultArchitectures"), optedOut))
+		}
+	}
+	return errs
+}
+
+// validateArchitectures returns the errors of the invalid or duplicate architectures of the list at fldPath. The
+// aliases of an architecture, e.g., aarch64 and arm64, are duplicates.
+func validateArchitectures(values []string, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	architectures := sets.New[string]()
+	for i, architecture := range values {
+		architecturePath := fldPath.Index(i)
+		normalized, err := image.ParseArchitecture(architecture)
+		if err != nil {
+			errs = append(errs, field.Invalid(architecturePath, architecture, err.Error()))
+			continue
+		}
+		if architectures.Has(normalized) {
+			errs = append(errs, field.Duplicate(architecturePath, architecture))
+		}
+		architectures.Insert(normalized)
+	}
+	return errs
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PodPlacementPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&multiarchv1alpha1.PodPlacementPolicy{}).
+		Complete(r)
+}
@@ -0,0 +1,160 @@
+package multiarch
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+func podPlacementPolicy(name string, created time.Time, policy multiarchv1alpha1.PlacementPolicy) *multiarchv1alpha1.PodPlacementPolicy {
+	return &multiarchv1alpha1.PodPlacementPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "team-a",
+			UID:               types.UID(name),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: policy},
+	}
+}
+
+func newPodPlacementPolicyClient(objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithStatusSubresource(&multiarchv1alpha1.PodPlacementPolicy{}).Build()
+}
+
+var _ = Describe("The placement settings validation", func() {
+	DescribeTable("should validate the settings",
+		func(policy multiarchv1alpha1.PlacementPolicy, valid bool) {
+			err := ValidatePlacementPolicy(&policy)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("empty", multiarchv1alpha1.PlacementPolicy{}, true),
+		Entry("all the fields", multiarchv1alpha1.PlacementPolicy{
+			PlacementMode:        multiarchv1alpha1.PlacementModePreferred,
+			AllowedArchitectures: []string{"amd64", "aarch64"},
+			FailurePolicy:        multiarchv1alpha1.PlacementFailurePolicyFail,
+		}, true),
+		Entry("opted out", multiarchv1alpha1.PlacementPolicy{OptOut: pointer.Bool(true)}, true),
+		Entry("invalid placement mode", multiarchv1alpha1.PlacementPolicy{PlacementMode: "Sometimes"}, false),
+		Entry("invalid failure policy", multiarchv1alpha1.PlacementPolicy{FailurePolicy: "Retry"}, false),
+		Entry("invalid architecture", multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"vax"}}, false),
+		Entry("excluded container names", multiarchv1alpha1.PlacementPolicy{
+			ExcludedContainerNames: []string{"istio-proxy", "*-sidecar"},
+		}, true),
+		Entry("malformed excluded container name", multiarchv1alpha1.PlacementPolicy{
+			ExcludedContainerNames: []string{"sidecar-["},
+		}, false),
+		Entry("opted out with other fields", multiarchv1alpha1.PlacementPolicy{
+			OptOut:               pointer.Bool(true),
+			AllowedArchitectures: []string{"amd64"},
+		}, false),
+	)
+})
+
+var _ = Describe("The active PodPlacementPolicy", func() {
+	now := time.Now()
+
+	It("should be the oldest valid policy", func() {
+		policies := []multiarchv1alpha1.PodPlacementPolicy{
+			*podPlacementPolicy("newer", now, multiarchv1alpha1.PlacementPolicy{}),
+			*podPlacementPolicy("invalid", now.Add(-2*time.Hour), multiarchv1alpha1.PlacementPolicy{PlacementMode: "x"}),
+			*podPlacementPolicy("older", now.Add(-time.Hour), multiarchv1alpha1.PlacementPolicy{}),
+		}
+		Expect(ActivePodPlacementPolicy(policies).Name).To(Equal("older"))
+	})
+
+	It("should break the ties by name and ignore the policies being deleted", func() {
+		deleting := podPlacementPolicy("a", now, multiarchv1alpha1.PlacementPolicy{})
+		deleting.DeletionTimestamp = &metav1.Time{Time: now}
+		policies := []multiarchv1alpha1.PodPlacementPolicy{
+			*podPlacementPolicy("c", now, multiarchv1alpha1.PlacementPolicy{}),
+			*deleting,
+			*podPlacementPolicy("b", now, multiarchv1alpha1.PlacementPolicy{}),
+		}
+		Expect(ActivePodPlacementPolicy(policies).Name).To(Equal("b"))
+	})
+
+	It("should be nil without valid policies", func() {
+		Expect(ActivePodPlacementPolicy(nil)).To(BeNil())
+	})
+})
+
+var _ = Describe("The PodPlacementPolicy reconciler", func() {
+	It("should report which policy applies to the namespace", func() {
+		now := time.Now()
+		c := newPodPlacementPolicyClient(
+			podPlacementPolicy("older", now.Add(-time.Hour), multiarchv1alpha1.PlacementPolicy{}),
+			podPlacementPolicy("newer", now, multiarchv1alpha1.PlacementPolicy{}),
+			podPlacementPolicy("invalid", now, multiarchv1alpha1.PlacementPolicy{FailurePolicy: "Retry"}),
+		)
+		r := &PodPlacementPolicyReconciler{Client: c}
+		ctx := context.Background()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "newer"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		expectedReasons := map[string]string{
+			"older":   multiarchv1alpha1.AsExpectedReason,
+			"newer":   multiarchv1alpha1.MultiplePoliciesReason,
+			"invalid": multiarchv1alpha1.InvalidSpecReason,
+		}
+		for name, reason := range expectedReasons {
+			policy := &multiarchv1alpha1.PodPlacementPolicy{}
+			Expect(c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: name}, policy)).To(Succeed())
+			condition := meta.FindStatusCondition(policy.Status.Conditions, multiarchv1alpha1.ValidConditionType)
+			Expect(condition).NotTo(BeNil(), name)
+			Expect(condition.Reason).To(Equal(reason), name)
+			Expect(condition.Status == metav1.ConditionTrue).To(Equal(name == "older"), name)
+		}
+	})
+})
+
+var _ = Describe("The PodPlacementPolicy validating webhook", func() {
+	ctx := context.Background()
+
+	It("should reject a second policy in the namespace", func() {
+		v := &PodPlacementPolicyValidator{Reader: newPodPlacementPolicyClient(
+			podPlacementPolicy("existing", time.Now(), multiarchv1alpha1.PlacementPolicy{}))}
+		_, err := v.ValidateCreate(ctx, podPlacementPolicy("second", time.Now(), multiarchv1alpha1.PlacementPolicy{}))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+
+		other := podPlacementPolicy("other", time.Now(), multiarchv1alpha1.PlacementPolicy{})
+		other.Namespace = "team-b"
+		_, err = v.ValidateCreate(ctx, other)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject the invalid and the conflicting settings", func() {
+		v := &PodPlacementPolicyValidator{Reader: newPodPlacementPolicyClient()}
+		_, err := v.ValidateCreate(ctx, podPlacementPolicy("invalid", time.Now(),
+			multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"vax"}}))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		conflicting := podPlacementPolicy("conflicting", time.Now(), multiarchv1alpha1.PlacementPolicy{
+			OptOut:        pointer.Bool(true),
+			PlacementMode: multiarchv1alpha1.PlacementModeRequired,
+		})
+		_, err = v.ValidateUpdate(ctx, conflicting, conflicting)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+})
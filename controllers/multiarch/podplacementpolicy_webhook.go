@@ -0,0 +1,79 @@
+package multiarch
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-multiarch-openshift-io-v1alpha1-podplacementpolicy,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups=multiarch.openshift.io,resources=podplacementpolicies,verbs=create;update,versions=v1alpha1,name=validate-podplacementpolicy.multiarch.openshift.io
+
+// PodPlacementPolicyValidator rejects the invalid or conflicting PodPlacementPolicy objects and the creation of a
+// second PodPlacementPolicy in a namespace
+type PodPlacementPolicyValidator struct {
+	// Reader is used to list the policies of the namespaces. It is expected not to be restricted to the watched
+	// namespaces, e.g., the API reader of the manager.
+	Reader client.Reader
+}
+
+var _ admission.CustomValidator = &PodPlacementPolicyValidator{}
+
+// ValidateCreate validates the policy and rejects it if another policy exists in its namespace
+func (v *PodPlacementPolicyValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	policy, ok := obj.(*multiarchv1alpha1.PodPlacementPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a PodPlacementPolicy, got %T", obj)
+	}
+	if err := validatePodPlacementPolicy(policy); err != nil {
+		return nil, err
+	}
+	policies := &multiarchv1alpha1.PodPlacementPolicyList{}
+	if err := v.Reader.List(ctx, policies, client.InNamespace(policy.Namespace)); err != nil {
+		return nil, err
+	}
+	for _, existing := range policies.Items {
+		if existing.Name != policy.Name && existing.DeletionTimestamp == nil {
+			return nil, apierrors.NewForbidden(multiarchv1alpha1.GroupVersion.WithResource("podplacementpolicies").GroupResource(),
+				policy.Name, fmt.Errorf("the PodPlacementPolicy %s already exists in the namespace %s: "+
+					"only one PodPlacementPolicy per namespace is allowed", existing.Name, policy.Namespace))
+		}
+	}
+	return nil, nil
+}
+
+// ValidateUpdate validates the updated policy
+func (v *PodPlacementPolicyValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	policy, ok := newObj.(*multiarchv1alpha1.PodPlacementPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a PodPlacementPolicy, got %T", newObj)
+	}
+	return nil, validatePodPlacementPolicy(policy)
+}
+
+// ValidateDelete allows the deletion of any policy
+func (v *PodPlacementPolicyValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validatePodPlacementPolicy(policy *multiarchv1alpha1.PodPlacementPolicy) error {
+	if errs := validatePlacementPolicy(&policy.Spec.PlacementPolicy, field.NewPath("spec")); len(errs) > 0 {
+		return apierrors.NewInvalid(multiarchv1alpha1.GroupVersion.WithKind("PodPlacementPolicy").GroupKind(),
+			policy.Name, errs)
+	}
+	return nil
+}
+
+// SetupWebhookWithManager registers the validating webhook of the PodPlacementPolicy objects with the Manager
+func (v *PodPlacementPolicyValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&multiarchv1alpha1.PodPlacementPolicy{}).
+		WithValidator(v).
+		Complete()
+}
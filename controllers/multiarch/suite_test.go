@@ -17,20 +17,23 @@ limitations under the License.
 package multiarch
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	"k8s.io/client-go/kubernetes/scheme"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/testenv"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -39,7 +42,23 @@ import (
 
 var cfg *rest.Config
 var k8sClient client.Client
-var testEnv *envtest.Environment
+var harness *testenv.Harness
+
+// envtestMutatingWebhookConfigurationName is the name of the MutatingWebhookConfiguration of config/webhook, installed
+// by the harness
+const envtestMutatingWebhookConfigurationName = "mutating-webhook-configuration"
+
+// forcedSyncConfigMap is the ConfigMap read by the forced syncs of the harness, as the ones of the operator read the
+// ConfigMap of the registry certificates. The forced syncs fail while it does not exist.
+var forcedSyncConfigMap = types.NamespacedName{Namespace: "default", Name: "envtest-registry-certs"}
+
+// forceSystemConfigSync reads the ImageContentSourcePolicy objects and the forcedSyncConfigMap with the reader
+func forceSystemConfigSync(ctx context.Context, reader client.Reader) error {
+	if err := reader.List(ctx, &operatorv1alpha1.ImageContentSourcePolicyList{}); err != nil {
+		return err
+	}
+	return reader.Get(ctx, forcedSyncConfigMap, &corev1.ConfigMap{})
+}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -50,31 +69,43 @@ func TestAPIs(t *testing.T) {
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
-	By("bootstrapping test environment")
-	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
-		ErrorIfCRDPathMissing: true,
+	if !testenv.Available() {
+		// The unit tests in this suite do not need the test environment.
+		By("skipping the test environment bootstrap: KUBEBUILDER_ASSETS is not set")
+		return
 	}
-
+	By("bootstrapping test environment")
 	var err error
-	// cfg is defined in this file globally.
-	cfg, err = testEnv.Start()
-	Expect(err).NotTo(HaveOccurred())
-	Expect(cfg).NotTo(BeNil())
-
-	err = multiarchv1alpha1.AddToScheme(scheme.Scheme)
+	harness, err = testenv.Start(testenv.Options{
+		RootDir: filepath.Join("..", ".."),
+		CRDs:    []*apiextensionsv1.CustomResourceDefinition{testenv.ImageContentSourcePolicyCRD()},
+	})
 	Expect(err).NotTo(HaveOccurred())
+	// cfg and k8sClient are defined in this file globally.
+	cfg = harness.Config
+	k8sClient = harness.Client
 
 	//+kubebuilder:scaffold:scheme
 
-	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
-	Expect(err).NotTo(HaveOccurred())
-	Expect(k8sClient).NotTo(BeNil())
-
+	mgr := harness.Manager
+	Expect((&PodPlacementConfigReconciler{
+		Client:                           mgr.GetClient(),
+		Scheme:                           mgr.GetScheme(),
+		APIReader:                        mgr.GetAPIReader(),
+		MutatingWebhookConfigurationName: envtestMutatingWebhookConfigurationName,
+		ForceSystemConfigSync: func(ctx context.Context) error {
+			return forceSystemConfigSync(ctx, mgr.GetAPIReader())
+		},
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&PodPlacementConfigValidator{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&PodPlacementPolicyValidator{Reader: mgr.GetAPIReader()}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect(harness.StartManager()).To(Succeed())
 })
 
 var _ = AfterSuite(func() {
+	if harness == nil {
+		return
+	}
 	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).NotTo(HaveOccurred())
+	Expect(harness.Stop()).To(Succeed())
 })
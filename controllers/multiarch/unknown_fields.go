@@ -0,0 +1,80 @@
+package multiarch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// unknownFields returns the sorted paths of the fields of the spec and of the status of the PodPlacementConfig that
+// its types do not know, e.g., the ones written by a newer version of the operator during an upgrade. The typed object
+// is decoded without them, and the object is read again as unstructured to find them.
+func (r *PodPlacementConfigReconciler) unknownFields(ctx context.Context,
+	ppc *multiarchv1alpha1.PodPlacementConfig) ([]string, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(multiarchv1alpha1.GroupVersion.WithKind("PodPlacementConfig"))
+	if err := r.Get(ctx, client.ObjectKeyFromObject(ppc), u); err != nil {
+		return nil, err
+	}
+	if u.GetResourceVersion() != ppc.ResourceVersion {
+		// the caches are not in sync yet: the next reconcile compares the same version
+		return nil, nil
+	}
+	typed, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ppc)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, field := range []string{"spec", "status"} {
+		paths = appendUnknownFields(paths, field, u.Object[field], typed[field])
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// appendUnknownFields appends the paths of the fields of the object missing from the typed one, recursively
+func appendUnknownFields(paths []string, path string, object, typed interface{}) []string {
+	switch object := object.(type) {
+	case map[string]interface{}:
+		typedMap, _ := typed.(map[string]interface{})
+		for key, value := range object {
+			typedValue, ok := typedMap[key]
+			if !ok {
+				paths = append(paths, path+"."+key)
+				continue
+			}
+			paths = appendUnknownFields(paths, path+"."+key, value, typedValue)
+		}
+	case []interface{}:
+		typedList, _ := typed.([]interface{})
+		for i := range object {
+			if i < len(typedList) {
+				paths = appendUnknownFields(paths, fmt.Sprintf("%s[%d]", path, i), object[i], typedList[i])
+			}
+		}
+	}
+	return paths
+}
+
+// statusOwnedByNewerVersion returns true if the status of the PodPlacementConfig is left to a newer version of the
+// operator: the object has fields this version does not know and its current generation was already observed. The
+// unknown fields are logged once per generation.
+func (r *PodPlacementConfigReconciler) statusOwnedByNewerVersion(ppc *multiarchv1alpha1.PodPlacementConfig,
+	unknown []string) bool {
+	if len(unknown) == 0 {
+		return false
+	}
+	if r.unknownFieldsGeneration != ppc.Generation {
+		r.unknownFieldsGeneration = ppc.Generation
+		klog.Warningf("The PodPlacementConfig %s has the fields %v, unknown to this version of the operator: they are "+
+			"ignored. They are likely written by a newer version of the operator", ppc.Name, unknown)
+	}
+	return ppc.Status.ObservedGeneration >= ppc.Generation
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// protectedNamespacePrefixes are the prefixes of the namespaces of the infrastructure components: their pods are
+// never gated
+var protectedNamespacePrefixes = []string{"openshift-", "hypershift-", "kube-"}
+
+// isProtectedNamespace returns true if the pods in the namespace must not be gated
+func isProtectedNamespace(namespace string) bool {
+	for _, prefix := range protectedNamespacePrefixes {
+		if strings.HasPrefix(namespace, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedNamespace returns true if the namespace is protected or matches one of the glob patterns of the excluded
+// namespaces, e.g., team-*-infra, see path.Match
+func isExcludedNamespace(namespace string, excludedNamespaces []string) bool {
+	if isProtectedNamespace(namespace) {
+		return true
+	}
+	for _, pattern := range excludedNamespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExcludedNamespaces returns an error if any of the glob patterns of the excluded namespaces is malformed
+func ValidateExcludedNamespaces(excludedNamespaces []string) error {
+	for _, pattern := range excludedNamespaces {
+		if pattern == "" {
+			return fmt.Errorf("empty excluded namespace pattern")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid excluded namespace pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ValidateWatchNamespaces returns an error if any of the namespaces is not a valid namespace name, is protected or
+// matches one of the glob patterns of the excluded namespaces, expected to be validated by ValidateExcludedNamespaces:
+// watching an excluded namespace would cache its pods without ever gating them.
+func ValidateWatchNamespaces(namespaces, excludedNamespaces []string) error {
+	for _, namespace := range namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		if isProtectedNamespace(namespace) {
+			return fmt.Errorf("the namespace %q is protected: the pods in the namespaces prefixed by %s are never gated",
+				namespace, strings.Join(protectedNamespacePrefixes, ", "))
+		}
+		for _, pattern := range excludedNamespaces {
+			if matched, _ := path.Match(pattern, namespace); matched {
+				return fmt.Errorf("the namespace %q is excluded by the pattern %q: its pods are never gated",
+					namespace, pattern)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The watched namespaces validation", func() {
+	It("should accept the valid namespaces", func() {
+		Expect(ValidateWatchNamespaces(nil, nil)).To(Succeed())
+		Expect(ValidateWatchNamespaces([]string{"team-a", "team-b", "openshift", "kubeflow"}, nil)).To(Succeed())
+		Expect(ValidateWatchNamespaces([]string{"team-a-apps", "ci-12"}, []string{"team-*-infra", "ci-?"})).To(
+			Succeed())
+	})
+
+	DescribeTable("should reject the invalid namespaces",
+		func(namespace, message string) {
+			Expect(ValidateWatchNamespaces([]string{"team-a", namespace}, []string{"team-*-infra", "cert-manager"})).To(
+				MatchError(ContainSubstring(message)))
+		},
+		Entry("OpenShift namespace", "openshift-monitoring", "protected"),
+		Entry("HyperShift namespace", "hypershift-clusters", "protected"),
+		Entry("Kubernetes namespace", "kube-system", "protected"),
+		Entry("empty name", "", "invalid namespace"),
+		Entry("upper case name", "Team-A", "invalid namespace"),
+		Entry("list syntax", "team-a,team-b", "invalid namespace"),
+		Entry("namespace matching an excluded pattern", "team-a-infra", `excluded by the pattern "team-*-infra"`),
+		Entry("excluded namespace", "cert-manager", "excluded"),
+	)
+})
+
+var _ = Describe("The excluded namespaces", func() {
+	DescribeTable("should match the protected namespaces and the glob patterns",
+		func(namespace string, expectedExcluded bool) {
+			Expect(isExcludedNamespace(namespace, []string{"team-*-infra", "cert-manager", "ci-?"})).To(
+				Equal(expectedExcluded))
+		},
+		Entry("a protected namespace", "kube-system", true),
+		Entry("an OpenShift namespace", "openshift-monitoring", true),
+		Entry("a namespace matching a pattern", "team-a-infra", true),
+		Entry("a namespace listed by name", "cert-manager", true),
+		Entry("a namespace matching a single character", "ci-1", true),
+		Entry("a namespace prefixed by a listed one", "cert-manager-webhooks", false),
+		Entry("a namespace partially matching a pattern", "team-a-apps", false),
+		Entry("a namespace with more characters than a pattern", "ci-12", false),
+		Entry("another namespace", "default", false),
+	)
+
+	It("should reject the malformed patterns", func() {
+		Expect(ValidateExcludedNamespaces(nil)).To(Succeed())
+		Expect(ValidateExcludedNamespaces([]string{"team-*", "ci-?", "team-[ab]"})).To(Succeed())
+		Expect(ValidateExcludedNamespaces([]string{"team-[a"})).To(MatchError(ContainSubstring("invalid")))
+		Expect(ValidateExcludedNamespaces([]string{""})).To(MatchError(ContainSubstring("empty")))
+	})
+
+	It("should not be gated by the webhook", func() {
+		ctx := context.Background()
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{
+			Client:             fake.NewClientBuilder().WithScheme(scheme).Build(),
+			ExcludedNamespaces: []string{"team-*-infra"},
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		admit := func(namespace string) admission.Response {
+			pod := podWithImages("pod", "quay.io/org/app:v1")
+			pod.Namespace = namespace
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			return webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+		}
+		for _, namespace := range []string{"team-a-infra", "kube-system"} {
+			Expect(admit(namespace).Patches).NotTo(ContainElement(HaveField("Path", "/spec/schedulingGates")),
+				"the pod of the namespace %s is gated", namespace)
+		}
+		Expect(admit("team-a-apps").Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+})
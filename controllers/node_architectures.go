@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// nodeArchitecturesRequest is the only request of the NodeArchitecturesReconciler: the architectures of the cluster
+// are computed from all the nodes at once, so that the events of many nodes are processed by a single reconcile.
+var nodeArchitecturesRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "node-architectures"}}
+
+// NodeArchitecturesReconciler watches the architectures of the schedulable nodes. When an architecture appears in or
+// disappears from the cluster, it re-evaluates the pods that are still pending and not scheduled yet:
+//   - the gated pods whose cached supported architectures, see cachedArchitectures, include an added architecture are
+//     requeued to the PodReconciler through the channel returned by Events, ahead of their backoff, so that their node
+//     affinity requirement is computed again with the new nodes. The gated pods whose images are not cached yet are
+//     left to their inspection, reading the nodes when it completes;
+//   - when the capacity of the architectures is considered, the pods placed according to it whose allowed
+//     architectures change, e.g., because the first node of an architecture their images support joined the cluster,
+//     are reported through a Warning event. The node affinity of a pod cannot be changed once its scheduling gates are
+//     removed: the pod is placed again when it is recreated, e.g., by its owner or by the user.
+//
+// The pods are never deleted by the operator.
+type NodeArchitecturesReconciler struct {
+	client.Client
+	// CapacityCache is optional. When set, the pending pods placed according to the capacity of the architectures are
+	// reported when their allowed architectures change.
+	CapacityCache *ArchitectureCapacityCache
+	Recorder      record.EventRecorder
+	// Instance is optional. When set, only the pods it placed or gated are re-evaluated.
+	Instance *Instance
+	// Inspector is optional. It returns the cached architectures of the images of the gated pods. It defaults to
+	// inspect.Singleton().
+	Inspector inspect.CachedInspector
+	// architectures are the architectures of the schedulable nodes at the last reconcile. They are nil until the first
+	// reconcile, whose architectures are the baseline and trigger no re-evaluation.
+	architectures sets.Set[string]
+	events        chan event.GenericEvent
+}
+
+// NewNodeArchitecturesReconciler returns a NodeArchitecturesReconciler reading the objects with the client and
+// reporting the pending pods through the recorder
+func NewNodeArchitecturesReconciler(c client.Client, recorder record.EventRecorder,
+	instance *Instance) *NodeArchitecturesReconciler {
+	return &NodeArchitecturesReconciler{
+		Client:   c,
+		Recorder: recorder,
+		Instance: instance,
+		events:   make(chan event.GenericEvent),
+	}
+}
+
+// Events returns the channel of the gated pods to requeue
+func (r *NodeArchitecturesReconciler) Events() <-chan event.GenericEvent {
+	return r.events
+}
+
+// Reconcile re-evaluates the pending pods when the architectures of the schedulable nodes change
+func (r *NodeArchitecturesReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return ctrl.Result{}, err
+	}
+	architectures := sets.New[string]()
+	for i := range nodes.Items {
+		if arch, ok := schedulableNodeArchitecture(&nodes.Items[i]); ok {
+			architectures.Insert(arch)
+		}
+	}
+	if r.architectures == nil {
+		klog.V(3).Infof("The architectures of the cluster are %v", sets.List(architectures))
+		r.architectures = architectures
+		return ctrl.Result{}, nil
+	}
+	if architectures.Equal(r.architectures) {
+		return ctrl.Result{}, nil
+	}
+	added := architectures.Difference(r.architectures)
+	klog.Infof("The architectures of the cluster changed: added %v, removed %v. Re-evaluating the pending pods",
+		sets.List(added), sets.List(r.architectures.Difference(architectures)))
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pausedBy := placementPausedBy(podPlacementConfigs); pausedBy != "" {
+		// the pending pods are not re-evaluated while the pod placement is paused, nor after it is unpaused: the gated
+		// ones are released without their node affinity, and the placed ones would not be gated when recreated
+		klog.Infof("Not re-evaluating the pending pods: the pod placement is paused by the PodPlacementConfig %s",
+			pausedBy)
+		r.architectures = architectures
+		return ctrl.Result{}, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods); err != nil {
+		return ctrl.Result{}, err
+	}
+	if r.CapacityCache != nil {
+		// the decisions of the pending pods have to be compared with the capacity of the new architectures
+		if err := r.CapacityCache.refresh(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.reportPendingPods(pods)
+	}
+	if added.Len() > 0 {
+		if err := r.requeueGatedPods(ctx, pods, added); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	r.architectures = architectures
+	return ctrl.Result{}, nil
+}
+
+// reportPendingPods reports the pending pods whose allowed architectures changed through a Warning event
+func (r *NodeArchitecturesReconciler) reportPendingPods(pods *corev1.PodList) {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !r.isPlacedAndUnscheduled(pod) {
+			continue
+		}
+		current, updated, changed := r.allowedArchitecturesChange(pod)
+		if !changed {
+			continue
+		}
+		message := fmt.Sprintf("The architectures allowed by the capacity of the cluster changed from %s to %s",
+			strings.Join(current, ","), strings.Join(updated, ","))
+		klog.V(3).Infof("%s for pod %s/%s", message, pod.Namespace, pod.Name)
+		r.Recorder.Event(pod, corev1.EventTypeWarning, reasons.ArchitecturesChanged,
+			versionedEventMessage(message+". Recreate the pod to update its node affinity"))
+	}
+}
+
+// requeueGatedPods requeues the gated pods whose cached supported architectures include an added architecture. It
+// blocks until the PodReconciler receives them or the context is done.
+func (r *NodeArchitecturesReconciler) requeueGatedPods(ctx context.Context, pods *corev1.PodList,
+	added sets.Set[string]) error {
+	inspector := r.Inspector
+	if inspector == nil {
+		inspector = inspect.Singleton()
+	}
+	requeued := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !r.Instance.hasSchedulingGate(pod) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		supported, ok := cachedArchitectures(ctx, inspector, r.Instance, pod)
+		if !ok || !supported.HasAny(sets.List(added)...) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r.events <- event.GenericEvent{Object: pod}:
+		}
+		requeued++
+	}
+	klog.V(2).Infof("Requeued %d gated pods supporting the architectures %v", requeued, sets.List(added))
+	return nil
+}
+
+// isPlacedAndUnscheduled returns true if the pod is pending, was placed according to the capacity of the
+// architectures and is not bound to a node yet
+func (r *NodeArchitecturesReconciler) isPlacedAndUnscheduled(pod *corev1.Pod) bool {
+	return len(r.Instance.supportedArchitectures(pod)) > 0 && pod.Status.Phase == corev1.PodPending &&
+		pod.Spec.NodeName == "" && pod.DeletionTimestamp == nil && !r.Instance.hasSchedulingGate(pod)
+}
+
+// allowedArchitecturesChange returns the architectures the pod was allowed to run on when it was placed and the ones
+// it would be allowed to run on now, according to the capacity of the architectures
+func (r *NodeArchitecturesReconciler) allowedArchitecturesChange(pod *corev1.Pod) (current, updated []string,
+	changed bool) {
+	supported := r.Instance.supportedArchitectures(pod)
+	excluded := sets.New(r.Instance.capacityExcludedArchitectures(pod)...)
+	current = sets.List(sets.New(supported...).Difference(excluded))
+	fitting, _ := r.CapacityCache.filterArchitectures(pod, supported)
+	updated = sets.List(sets.New(fitting...))
+	return current, updated, !sets.New(current...).Equal(sets.New(updated...))
+}
+
+func splitArchitectures(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// schedulableNodeArchitecture returns the architecture of the node, if it is schedulable. The architecture of the nodes
+// without the archLabel label is read from the deprecated betaArchLabel one.
+func schedulableNodeArchitecture(node *corev1.Node) (string, bool) {
+	arch, ok := node.Labels[archLabel]
+	if !ok {
+		arch, ok = node.Labels[betaArchLabel]
+	}
+	if !ok || node.Spec.Unschedulable {
+		return "", false
+	}
+	return arch, true
+}
+
+// betaOnlyArchNodesSelector matches the nodes reporting their architecture only through the betaArchLabel label
+var betaOnlyArchNodesSelector = func() labels.Selector {
+	betaLabel, _ := labels.NewRequirement(betaArchLabel, selection.Exists, nil)
+	noLabel, _ := labels.NewRequirement(archLabel, selection.DoesNotExist, nil)
+	return labels.NewSelector().Add(*betaLabel, *noLabel)
+}()
+
+// hasBetaOnlyArchNodes returns true if some nodes report their architecture only through the betaArchLabel label,
+// so that the node affinity of the pods has to match it too. On errors, it returns false: the node affinity only
+// matches the archLabel label, as it did before the nodes were checked.
+func hasBetaOnlyArchNodes(ctx context.Context, c client.Reader) bool {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes, client.MatchingLabelsSelector{Selector: betaOnlyArchNodesSelector}); err != nil {
+		klog.Warningf("Unable to look for the nodes without the %s label: %v", archLabel, err)
+		return false
+	}
+	return len(nodes.Items) > 0
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the node events that can change the architectures of
+// the cluster are processed.
+func (r *NodeArchitecturesReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node-architectures").
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(
+			func(context.Context, client.Object) []reconcile.Request {
+				return []reconcile.Request{nodeArchitecturesRequest}
+			}), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldArch, oldOk := schedulableNodeArchitecture(e.ObjectOld.(*corev1.Node))
+				newArch, newOk := schedulableNodeArchitecture(e.ObjectNew.(*corev1.Node))
+				return oldArch != newArch || oldOk != newOk
+			},
+		})).
+		Complete(r)
+}
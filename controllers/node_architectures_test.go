@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// placedPod returns a pending pod placed on the supported architectures, excluding the given ones for capacity
+func placedPod(name, supported, excluded string, owned bool) *corev1.Pod {
+	pod := podRequesting("1", "1Gi")
+	pod.Name = name
+	pod.Namespace = "test"
+	pod.Annotations = map[string]string{
+		supportedArchitecturesAnnotation:        supported,
+		capacityExcludedArchitecturesAnnotation: excluded,
+	}
+	pod.Status.Phase = corev1.PodPending
+	if owned {
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "ReplicaSet",
+			Name:       "rs",
+			UID:        "rs-uid",
+			Controller: pointer.Bool(true),
+		}}
+	}
+	return pod
+}
+
+var _ = Describe("The node architectures reconciler", func() {
+	var (
+		c         client.Client
+		r         *NodeArchitecturesReconciler
+		recorder  *record.FakeRecorder
+		ctx       context.Context
+		reconcile func()
+		// deletions counts the deletions of the pods
+		deletions int
+	)
+
+	gatedWithImage := func(name, image string) *corev1.Pod {
+		pod := podWithImages(name, image)
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		pod.Status.Phase = corev1.PodPending
+		return pod
+	}
+
+	// requeued returns the names of the pods requeued by the last reconcile
+	requeued := func() []string {
+		var names []string
+		for {
+			select {
+			case e := <-r.Events():
+				names = append(names, e.Object.GetName())
+			default:
+				return names
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		deletions = 0
+		scheme := newTestScheme()
+		gated := placedPod("gated", "amd64,arm64", "arm64", true)
+		gated.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		scheduled := placedPod("scheduled", "amd64,arm64", "arm64", true)
+		scheduled.Spec.NodeName = "amd64-node"
+		running := placedPod("running", "amd64,arm64", "arm64", true)
+		running.Spec.NodeName = "amd64-node"
+		running.Status.Phase = corev1.PodRunning
+		c = fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				deletions++
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).WithObjects(
+			nodeWithCapacity("amd64-node", "amd64", "16", "64Gi", false),
+			placedPod("owned", "amd64,arm64", "arm64", true),
+			placedPod("unowned", "amd64,arm64", "arm64", false),
+			placedPod("amd64-only", "amd64", "", true),
+			gated, scheduled, running,
+			gatedWithImage("gated-multiarch", "quay.io/org/multiarch:v1"),
+			gatedWithImage("gated-amd64", "quay.io/org/amd64:v1"),
+			gatedWithImage("gated-uncached", "quay.io/org/app:v1"),
+		).Build()
+		recorder = record.NewFakeRecorder(10)
+		r = NewNodeArchitecturesReconciler(c, recorder, nil)
+		r.CapacityCache = NewArchitectureCapacityCache(c, 0)
+		r.Inspector = &fakeArchitectures{cached: map[string][]string{
+			"//quay.io/org/multiarch:v1": {"amd64", "arm64"},
+			"//quay.io/org/amd64:v1":     {"amd64"},
+		}}
+		// the specs read the requeued pods after the reconcile returns
+		r.events = make(chan event.GenericEvent, 10)
+		Expect(r.CapacityCache.refresh(ctx)).To(Succeed())
+		reconcile = func() {
+			_, err := r.Reconcile(ctx, nodeArchitecturesRequest)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		// the first reconcile records the baseline architectures
+		reconcile()
+	})
+
+	It("should not touch any pod when the architectures do not change", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("another-amd64-node", "amd64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(requeued()).To(BeEmpty())
+	})
+
+	It("should ignore the architectures of the unschedulable nodes", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", true))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(requeued()).To(BeEmpty())
+	})
+
+	It("should not report the pending pods while the pod placement is paused", func() {
+		Expect(c.Create(ctx, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Paused: true},
+		})).To(Succeed())
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(requeued()).To(BeEmpty())
+	})
+
+	It("should requeue the gated pods whose cached images support an architecture joining the cluster", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(requeued()).To(ConsistOf("gated-multiarch"))
+	})
+
+	It("should not requeue the gated pods when an architecture leaves the cluster", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(requeued()).To(ConsistOf("gated-multiarch"))
+		Expect(c.Delete(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(requeued()).To(BeEmpty())
+	})
+
+	It("should requeue the gated pods without the capacity of the architectures", func() {
+		r.CapacityCache = nil
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(requeued()).To(ConsistOf("gated-multiarch"))
+		// the placed pods are only reported according to the capacity
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should report the pending pods when an architecture joins the cluster", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(HaveLen(2))
+		events := []string{<-recorder.Events, <-recorder.Events}
+		Expect(events).To(HaveEach(ContainSubstring("Warning ArchitecturesChanged The architectures allowed by the " +
+			"capacity of the cluster changed from amd64 to amd64,arm64. Recreate the pod")))
+		// the pods are never deleted, with or without a controller
+		Expect(deletions).To(BeZero())
+		pods := &corev1.PodList{}
+		Expect(c.List(ctx, pods)).To(Succeed())
+		Expect(pods.Items).To(HaveLen(9))
+	})
+
+	It("should not re-evaluate the pods again for the same architectures", func() {
+		Expect(c.Create(ctx, nodeWithCapacity("arm64-node", "arm64", "16", "64Gi", false))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(HaveLen(2))
+		<-recorder.Events
+		<-recorder.Events
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
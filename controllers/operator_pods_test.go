@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The pods of the operator and of its operands", func() {
+	var (
+		ctx     context.Context
+		webhook *PodSchedulingGateMutatingWebHook
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := newTestScheme()
+		// the operator runs in a namespace that is not protected, with a single replica: no other one would remove
+		// the scheduling gate of its new pods
+		webhook = &PodSchedulingGateMutatingWebHook{
+			Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+			ExemptServiceAccounts: []types.NamespacedName{
+				{Namespace: "operator", Name: "controller-manager"},
+				{Namespace: "operator", Name: "node-syncer"},
+			},
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+	})
+
+	// gated returns true if the webhook gates the pod
+	gated := func(pod *corev1.Pod) bool {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		for _, patch := range response.Patches {
+			if patch.Path == "/spec/schedulingGates" {
+				return true
+			}
+		}
+		return false
+	}
+
+	DescribeTable("should not be gated", func(namespace, serviceAccount string, annotations map[string]string,
+		expectedGated bool) {
+		pod := podWithImages("pod", "quay.io/org/multiarch-operator:v1")
+		pod.Namespace = namespace
+		pod.Spec.ServiceAccountName = serviceAccount
+		pod.Annotations = annotations
+		Expect(gated(pod)).To(Equal(expectedGated))
+	},
+		Entry("the pods of the operator", "operator", "controller-manager", nil, false),
+		Entry("the pods of the node syncer", "operator", "node-syncer", nil, false),
+		Entry("the pods excluded from the placement", "test", "default",
+			map[string]string{excludePodPlacementAnnotation: "true"}, false),
+		Entry("but the pods not excluded from the placement", "test", "default",
+			map[string]string{excludePodPlacementAnnotation: "false"}, true),
+		Entry("but the other pods of the namespace of the operator", "operator", "default", nil, true),
+		Entry("but the pods of a service account of the same name in another namespace", "test",
+			"controller-manager", nil, true),
+	)
+
+	It("should only be excluded by the annotation of the instance", func() {
+		instance, err := NewInstance("staging.multiarch.openshift.io/scheduling-gate", "staging.multiarch.openshift.io")
+		Expect(err).NotTo(HaveOccurred())
+		webhook.Instance = instance
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{excludePodPlacementAnnotation: "true"}
+		Expect(gated(pod)).To(BeTrue())
+		pod.Annotations = map[string]string{instance.ExcludePodPlacementAnnotation(): "true"}
+		Expect(gated(pod)).To(BeFalse())
+	})
+})
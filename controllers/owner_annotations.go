@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxOwnerDepth bounds the walk of the owner references from a pod to its top-level owner, e.g., the pod, its
+	// ReplicaSet and its Deployment
+	maxOwnerDepth = 5
+
+	// ownerAnnotationsPatched is the result of the owners annotated with the summary of the placement of their pods
+	ownerAnnotationsPatched = "patched"
+	// ownerAnnotationsExcludedNamespace is the result of the owners skipped for their namespace
+	ownerAnnotationsExcludedNamespace = "excluded_namespace"
+	// ownerAnnotationsForbidden is the result of the owners the operator lacks the permission to read or patch
+	ownerAnnotationsForbidden = "forbidden"
+	// ownerAnnotationsFailed is the result of the owners that could not be read or patched for any other reason, e.g.,
+	// the owners deleted before the patch
+	ownerAnnotationsFailed = "failed"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets;replicasets,verbs=get;patch
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;patch
+
+// OwnerAnnotator propagates the placement of the pods to their top-level owner, e.g., their Deployment, StatefulSet or
+// Job, found by walking the controllers in their owner references, for the teams looking at the workloads rather than
+// at the pods. The ownerPlacementAnnotation annotation of the owner summarizes the architectures supported by the last
+// placed pod and the time of its placement. It is only informational: the owners are never read back.
+// The placements are debounced by the controller of the pods: the owner is patched once no pod of the same controller
+// was placed for debounce, so that a rollout patches the owner once. The owners in the protected or the excluded
+// namespaces, and the ones the operator lacks the permission to patch, are skipped. All the outcomes are counted by
+// the multiarch_owner_annotations_total metric.
+// It implements the manager.Runnable interface.
+type OwnerAnnotator struct {
+	client             client.Client
+	instance           *Instance
+	debounce           time.Duration
+	excludedNamespaces sets.Set[string]
+	clock              clock.WithTicker
+	// pending are the last placements not yet propagated, by the UID of the controller of the pods
+	pending map[types.UID]ownerPlacement
+	// mutex is used to protect the pending map from concurrent access
+	mutex sync.Mutex
+}
+
+// ownerPlacement is the last placement of the pods of a controller
+type ownerPlacement struct {
+	namespace     string
+	controller    metav1.OwnerReference
+	architectures []string
+	decided       time.Time
+}
+
+func NewOwnerAnnotator(c client.Client, instance *Instance, debounce time.Duration,
+	excludedNamespaces []string) *OwnerAnnotator {
+	return &OwnerAnnotator{
+		client:             c,
+		instance:           instance,
+		debounce:           debounce,
+		excludedNamespaces: sets.New(excludedNamespaces...),
+		clock:              clock.RealClock{},
+		pending:            map[types.UID]ownerPlacement{},
+	}
+}
+
+// observe records the placement of the pod on the architectures, to be propagated to its top-level owner once the
+// placements of its controller are debounced. The pods without a controller are ignored. It is a no-op on the nil
+// OwnerAnnotator.
+func (a *OwnerAnnotator) observe(pod *corev1.Pod, architectures []string) {
+	if a == nil {
+		return
+	}
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil || len(architectures) == 0 {
+		return
+	}
+	if a.isExcludedNamespace(pod.Namespace) {
+		ownerAnnotations.WithLabelValues(ownerAnnotationsExcludedNamespace).Inc()
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pending[controller.UID] = ownerPlacement{
+		namespace:     pod.Namespace,
+		controller:    *controller,
+		architectures: architectures,
+		decided:       a.clock.Now(),
+	}
+}
+
+// observePlacement records the placement of the pod for its top-level owner, if the OwnerAnnotator is set and the
+// decision sets a node affinity. The architectures supported by the pod are the ones its images support, before the
+// capacity refines them.
+func (r *PodReconciler) observePlacement(pod *corev1.Pod, decision placementDecision) {
+	if r.OwnerAnnotator == nil || decision.requirement == nil {
+		return
+	}
+	supported := r.Instance.supportedArchitectures(pod)
+	if len(supported) == 0 {
+		supported = decision.requirement.Values
+	}
+	r.OwnerAnnotator.observe(pod, supported)
+}
+
+// isExcludedNamespace returns true if the owners in the namespace are not annotated
+func (a *OwnerAnnotator) isExcludedNamespace(namespace string) bool {
+	return isProtectedNamespace(namespace) || a.excludedNamespaces.Has(namespace)
+}
+
+// Start propagates the debounced placements every debounce, until the context is done.
+func (a *OwnerAnnotator) Start(ctx context.Context) error {
+	ticker := a.clock.NewTicker(a.debounce)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			a.flush(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection returns true: the placements are observed by the reconciler of the leader.
+func (a *OwnerAnnotator) NeedLeaderElection() bool {
+	return true
+}
+
+// flush annotates the owners of the controllers that placed no pod for debounce
+func (a *OwnerAnnotator) flush(ctx context.Context) {
+	now := a.clock.Now()
+	var due []ownerPlacement
+	a.mutex.Lock()
+	for uid, placement := range a.pending {
+		if now.Sub(placement.decided) >= a.debounce {
+			due = append(due, placement)
+			delete(a.pending, uid)
+		}
+	}
+	a.mutex.Unlock()
+	for _, placement := range due {
+		ownerAnnotations.WithLabelValues(a.annotate(ctx, placement)).Inc()
+	}
+}
+
+// annotate sets the ownerPlacementAnnotation annotation of the top-level owner of the placement and returns the
+// result, e.g., ownerAnnotationsPatched
+func (a *OwnerAnnotator) annotate(ctx context.Context, placement ownerPlacement) string {
+	owner, err := a.topLevelOwner(ctx, placement.namespace, placement.controller)
+	if err != nil {
+		return ownerAnnotationsResult(placement, err)
+	}
+	annotation := a.instance.annotation(ownerPlacementAnnotation)
+	value := formatOwnerPlacement(placement)
+	original := owner.DeepCopy()
+	annotations := owner.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotation] = value
+	owner.SetAnnotations(annotations)
+	if err := a.client.Patch(ctx, owner, client.MergeFrom(original), client.FieldOwner(FieldManager)); err != nil {
+		return ownerAnnotationsResult(placement, err)
+	}
+	klog.V(4).Infof("Annotated the %s %s/%s with the placement of its pods: %s", owner.GetKind(),
+		owner.GetNamespace(), owner.GetName(), value)
+	return ownerAnnotationsPatched
+}
+
+// topLevelOwner returns the top-level owner of the controller, walking the controllers of its owner references
+func (a *OwnerAnnotator) topLevelOwner(ctx context.Context, namespace string,
+	controller metav1.OwnerReference) (*unstructured.Unstructured, error) {
+	var owner *unstructured.Unstructured
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		owner = &unstructured.Unstructured{}
+		owner.SetAPIVersion(controller.APIVersion)
+		owner.SetKind(controller.Kind)
+		if err := a.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: controller.Name}, owner); err != nil {
+			return nil, err
+		}
+		next := metav1.GetControllerOf(owner)
+		if next == nil {
+			break
+		}
+		controller = *next
+	}
+	return owner, nil
+}
+
+// ownerAnnotationsResult returns the result of the failed propagation of the placement. The owners the operator lacks
+// the permission to read or patch are skipped silently.
+func ownerAnnotationsResult(placement ownerPlacement, err error) string {
+	if apierrors.IsForbidden(err) {
+		klog.V(4).Infof("Not annotating the owner of the %s %s/%s: %v", placement.controller.Kind,
+			placement.namespace, placement.controller.Name, err)
+		return ownerAnnotationsForbidden
+	}
+	klog.V(3).Infof("Unable to annotate the owner of the %s %s/%s: %v", placement.controller.Kind,
+		placement.namespace, placement.controller.Name, err)
+	return ownerAnnotationsFailed
+}
+
+// formatOwnerPlacement returns the value of the ownerPlacementAnnotation annotation for the placement, e.g.,
+// "architectures=amd64,arm64;decided=2023-06-01T10:00:00Z"
+func formatOwnerPlacement(placement ownerPlacement) string {
+	return fmt.Sprintf("architectures=%s;decided=%s", strings.Join(placement.architectures, ","),
+		placement.decided.UTC().Format(time.RFC3339))
+}
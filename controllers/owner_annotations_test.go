@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// controllerReference returns the reference to the object as the controller of its dependents
+func controllerReference(apiVersion, kind, name string) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(kind + "/" + name),
+		Controller: pointer.Bool(true)}
+}
+
+var _ = Describe("The owner annotator", func() {
+	var (
+		ctx        context.Context
+		fakeClock  *clocktesting.FakeClock
+		deployment *appsv1.Deployment
+		replicaSet *appsv1.ReplicaSet
+		patchErr   error
+		patches    int
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fakeClock = clocktesting.NewFakeClock(time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC))
+		deployment = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test",
+			UID: "Deployment/app"}}
+		replicaSet = &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "test",
+			UID:             "ReplicaSet/app-1",
+			OwnerReferences: []metav1.OwnerReference{controllerReference("apps/v1", "Deployment", "app")}}}
+		patchErr, patches = nil, 0
+	})
+
+	newAnnotator := func(excludedNamespaces ...string) (*OwnerAnnotator, client.Client) {
+		scheme := newTestScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment, replicaSet).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+					opts ...client.PatchOption) error {
+					patches++
+					if patchErr != nil {
+						return patchErr
+					}
+					return c.Patch(ctx, obj, patch, opts...)
+				},
+			}).Build()
+		a := NewOwnerAnnotator(c, nil, time.Minute, excludedNamespaces)
+		a.clock = fakeClock
+		return a, c
+	}
+
+	replicaSetPod := func(name, namespace string) *corev1.Pod {
+		pod := podWithImages(name, "quay.io/org/app:v1")
+		pod.Namespace = namespace
+		pod.OwnerReferences = []metav1.OwnerReference{controllerReference("apps/v1", "ReplicaSet", "app-1")}
+		return pod
+	}
+
+	It("should annotate the top-level owner once per rollout", func() {
+		a, c := newAnnotator()
+		before := counterValue(ownerAnnotations, ownerAnnotationsPatched)
+		for i := 0; i < 10; i++ {
+			a.observe(replicaSetPod(fmt.Sprintf("pod-%d", i), "test"), []string{"amd64", "arm64"})
+			fakeClock.Step(10 * time.Second)
+			a.flush(ctx)
+		}
+		Expect(patches).To(BeZero())
+		fakeClock.Step(time.Minute)
+		a.flush(ctx)
+		a.flush(ctx)
+		Expect(patches).To(Equal(1))
+		Expect(counterValue(ownerAnnotations, ownerAnnotationsPatched) - before).To(Equal(1.0))
+		annotated := &appsv1.Deployment{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(deployment), annotated)).To(Succeed())
+		Expect(annotated.Annotations).To(HaveKeyWithValue(ownerPlacementAnnotation,
+			"architectures=amd64,arm64;decided=2023-06-01T10:01:30Z"))
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(replicaSet), replicaSet)).To(Succeed())
+		Expect(replicaSet.Annotations).NotTo(HaveKey(ownerPlacementAnnotation))
+	})
+
+	It("should skip the owners it lacks the permission to patch", func() {
+		a, _ := newAnnotator()
+		patchErr = apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "app",
+			fmt.Errorf("denied"))
+		before := counterValue(ownerAnnotations, ownerAnnotationsForbidden)
+		a.observe(replicaSetPod("pod", "test"), []string{"amd64"})
+		fakeClock.Step(time.Minute)
+		a.flush(ctx)
+		Expect(patches).To(Equal(1))
+		Expect(counterValue(ownerAnnotations, ownerAnnotationsForbidden) - before).To(Equal(1.0))
+	})
+
+	It("should skip the owners in the excluded namespaces", func() {
+		a, _ := newAnnotator("excluded")
+		before := counterValue(ownerAnnotations, ownerAnnotationsExcludedNamespace)
+		a.observe(replicaSetPod("pod", "excluded"), []string{"amd64"})
+		a.observe(replicaSetPod("pod", "openshift-monitoring"), []string{"amd64"})
+		fakeClock.Step(time.Minute)
+		a.flush(ctx)
+		Expect(patches).To(BeZero())
+		Expect(counterValue(ownerAnnotations, ownerAnnotationsExcludedNamespace) - before).To(Equal(2.0))
+	})
+
+	It("should ignore the pods without a controller", func() {
+		a, _ := newAnnotator()
+		a.observe(podWithImages("pod", "quay.io/org/app:v1"), []string{"amd64"})
+		fakeClock.Step(time.Minute)
+		a.flush(ctx)
+		Expect(patches).To(BeZero())
+	})
+
+	It("should be a no-op when disabled", func() {
+		var a *OwnerAnnotator
+		a.observe(replicaSetPod("pod", "test"), []string{"amd64"})
+	})
+})
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PlacementAuditor verifies that the placed pods actually run on an architecture their images support, e.g., to detect
+// the node affinities edited by hand or the scheduler bugs. Every interval, it samples sampleRate of the running pods
+// with the placementDecisionAnnotation annotation of the instance and the supported architectures recorded by their
+// decision, see Instance.supportedArchitectures, and compares the architecture of their node with the supported ones.
+// Each mismatch is reported once through a Warning event on the pod and the multiarch_placement_mismatches_total
+// metric.
+// It only reads the pods and the nodes: the pods are never mutated.
+// It implements the manager.Runnable interface.
+type PlacementAuditor struct {
+	client     client.Reader
+	recorder   record.EventRecorder
+	instance   *Instance
+	interval   time.Duration
+	sampleRate float64
+	clock      clock.WithTicker
+	// sample returns a pseudo-random number in [0.0,1.0), a pod being audited when it is lower than sampleRate
+	sample func() float64
+	// reported are the UIDs of the mismatched pods already reported. They are pruned to the running pods at each audit.
+	reported sets.Set[types.UID]
+}
+
+func NewPlacementAuditor(c client.Reader, recorder record.EventRecorder, instance *Instance, interval time.Duration,
+	sampleRate float64) *PlacementAuditor {
+	return &PlacementAuditor{
+		client:     c,
+		recorder:   recorder,
+		instance:   instance,
+		interval:   interval,
+		sampleRate: sampleRate,
+		clock:      clock.RealClock{},
+		sample:     rand.Float64,
+		reported:   sets.New[types.UID](),
+	}
+}
+
+// Start audits the placed pods every interval, until the context is done.
+func (a *PlacementAuditor) Start(ctx context.Context) error {
+	ticker := a.clock.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			a.audit(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection returns true: the mismatches are reported by a single replica.
+func (a *PlacementAuditor) NeedLeaderElection() bool {
+	return true
+}
+
+// audit compares the architecture of the nodes of a sample of the running placed pods with the architectures
+// supported by their images
+func (a *PlacementAuditor) audit(ctx context.Context) {
+	pods := &corev1.PodList{}
+	if err := a.client.List(ctx, pods); err != nil {
+		klog.Warningf("Unable to list the pods to audit their placement: %v", err)
+		return
+	}
+	running := sets.New[types.UID]()
+	nodeArchitectures := map[string]string{}
+	audited, mismatched := 0, 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		supported, ok := a.supportedArchitectures(pod)
+		if !ok {
+			continue
+		}
+		running.Insert(pod.UID)
+		if a.reported.Has(pod.UID) || a.sample() >= a.sampleRate {
+			continue
+		}
+		arch, ok := nodeArchitectures[pod.Spec.NodeName]
+		if !ok {
+			node := &corev1.Node{}
+			if err := a.client.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil {
+				klog.V(3).Infof("Unable to get the node %s of pod %s/%s to audit its placement: %v",
+					pod.Spec.NodeName, pod.Namespace, pod.Name, err)
+				continue
+			}
+			arch = node.Labels[archLabel]
+			nodeArchitectures[pod.Spec.NodeName] = arch
+		}
+		audited++
+		if arch == "" || supported.Has(arch) {
+			continue
+		}
+		mismatched++
+		a.reported.Insert(pod.UID)
+		placementMismatches.WithLabelValues(arch).Inc()
+		message := fmt.Sprintf("The pod runs on the %s node %s, but its images only support %s", arch,
+			pod.Spec.NodeName, strings.Join(sets.List(supported), ","))
+		klog.Warningf("%s for pod %s/%s", message, pod.Namespace, pod.Name)
+		a.recorder.Event(pod, corev1.EventTypeWarning, reasons.PlacementMismatch, versionedEventMessage(message))
+	}
+	a.reported = a.reported.Intersection(running)
+	klog.V(2).Infof("Audited the placement of %d pods: %d mismatches", audited, mismatched)
+}
+
+// supportedArchitectures returns the architectures supported by the images of the pod, if it is running and was
+// placed by the instance
+func (a *PlacementAuditor) supportedArchitectures(pod *corev1.Pod) (sets.Set[string], bool) {
+	if pod.Status.Phase != corev1.PodRunning || pod.Spec.NodeName == "" || !a.instance.hasPlacementDecision(pod) {
+		return nil, false
+	}
+	supported := a.instance.supportedArchitectures(pod)
+	if len(supported) == 0 {
+		return nil, false
+	}
+	return sets.New(supported...), true
+}
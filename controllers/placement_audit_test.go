@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// runningPlacedPod returns a pod placed by the webhook on the supported architectures and running on the node
+func runningPlacedPod(name, supported, nodeName string) *corev1.Pod {
+	pod := podWithImages(name, "quay.io/org/app:v1")
+	pod.UID = types.UID(name)
+	pod.Annotations = map[string]string{
+		placementDecisionAnnotation:      placedByWebhook,
+		supportedArchitecturesAnnotation: supported,
+	}
+	pod.Spec.NodeName = nodeName
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+var _ = Describe("The placement auditor", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		objects  []client.Object
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		objects = []client.Object{
+			nodeWithCapacity("amd64-node", "amd64", "4", "8Gi", false),
+			nodeWithCapacity("arm64-node", "arm64", "4", "8Gi", false),
+		}
+	})
+
+	newAuditor := func(sampleRate float64) *PlacementAuditor {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(objects...).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Update: func(context.Context, client.WithWatch, client.Object, ...client.UpdateOption) error {
+					Fail("the auditor must never update the objects")
+					return nil
+				},
+				Patch: func(context.Context, client.WithWatch, client.Object, client.Patch,
+					...client.PatchOption) error {
+					Fail("the auditor must never patch the objects")
+					return nil
+				},
+			}).Build()
+		return NewPlacementAuditor(c, recorder, nil, time.Minute, sampleRate)
+	}
+
+	It("should report each pod running on an unsupported architecture once", func() {
+		objects = append(objects, runningPlacedPod("mismatched", "amd64", "arm64-node"),
+			runningPlacedPod("matching", "amd64,arm64", "arm64-node"))
+		before := counterValue(placementMismatches, "arm64")
+		a := newAuditor(1)
+		a.audit(ctx)
+		Expect(recorder.Events).To(Receive(And(ContainSubstring(reasons.PlacementMismatch),
+			ContainSubstring("The pod runs on the arm64 node arm64-node, but its images only support amd64"))))
+		Expect(counterValue(placementMismatches, "arm64") - before).To(Equal(1.0))
+		a.audit(ctx)
+		Expect(recorder.Events).NotTo(Receive())
+		Expect(counterValue(placementMismatches, "arm64") - before).To(Equal(1.0))
+	})
+
+	It("should not audit the pods not running or not placed by the operator", func() {
+		pending := runningPlacedPod("pending", "amd64", "")
+		pending.Status.Phase = corev1.PodPending
+		notPlaced := runningPlacedPod("not-placed", "amd64", "arm64-node")
+		delete(notPlaced.Annotations, placementDecisionAnnotation)
+		withoutSupported := runningPlacedPod("without-supported", "", "arm64-node")
+		objects = append(objects, pending, notPlaced, withoutSupported)
+		newAuditor(1).audit(ctx)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should only audit the sampled pods", func() {
+		objects = append(objects, runningPlacedPod("mismatched", "amd64", "arm64-node"))
+		a := newAuditor(0.5)
+		a.sample = func() float64 { return 0.7 }
+		a.audit(ctx)
+		Expect(recorder.Events).NotTo(Receive())
+		a.sample = func() float64 { return 0.3 }
+		a.audit(ctx)
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasons.PlacementMismatch)))
+	})
+
+	It("should forget the reported pods that are no longer running", func() {
+		objects = append(objects, runningPlacedPod("mismatched", "amd64", "arm64-node"))
+		a := newAuditor(1)
+		a.audit(ctx)
+		Expect(a.reported.UnsortedList()).To(ConsistOf(types.UID("mismatched")))
+		Expect(a.client.(client.Client).Delete(ctx, runningPlacedPod("mismatched", "amd64", "arm64-node"))).
+			To(Succeed())
+		a.audit(ctx)
+		Expect(a.reported.UnsortedList()).To(BeEmpty())
+	})
+})
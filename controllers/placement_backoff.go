@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image/inspect"
+)
+
+const (
+	// permanentFailureBaseDelay and permanentFailureMaxDelay bound the exponential backoff of the gated pods whose
+	// placement failed permanently, e.g., because one of their images is missing from its registry
+	permanentFailureBaseDelay = time.Minute
+	permanentFailureMaxDelay  = 30 * time.Minute
+	// retryableFailureBaseDelay and retryableFailureMaxDelay bound the exponential backoff of the gated pods whose
+	// placement failed for a retryable reason, as the default backoff of the controllers does
+	retryableFailureBaseDelay = 5 * time.Millisecond
+	retryableFailureMaxDelay  = 1000 * time.Second
+	// tunedBackoffCeiling is the highest delay the exponential backoffs reach before their tuned cap applies
+	tunedBackoffCeiling = 24 * time.Hour
+)
+
+// PlacementBackoff is the rate limiter of the workqueue of the PodReconciler. It owns the backoff of the gated pods
+// whose placement failed: the inspections try each pull source of the images once, and their failures are classified
+// by inspect.IsRetryable. After a retryable failure, e.g., a registry answering 503 or a DNS failure, the pod is
+// retried with the default backoff of the controllers, exponential from 5ms to 1000s. After a permanent one, e.g., an
+// image missing from its registry or credentials rejected by it, that only a change of the image, of the pull secrets
+// or of the cluster configuration can fix, the pod is retried with an exponential backoff from
+// permanentFailureBaseDelay to permanentFailureMaxDelay. The maximum delays of both backoffs can be tuned by the
+// PodPlacementConfig objects. The GatedPodsSweeper and the watches of the placement settings requeue the pods ahead of
+// their backoff.
+// It implements the ratelimiter.RateLimiter interface.
+type PlacementBackoff struct {
+	retryable workqueue.RateLimiter
+	permanent workqueue.RateLimiter
+	// permanentFailures are the requests of the pods whose last placement failed permanently
+	permanentFailures sets.Set[reconcile.Request]
+	// mutex is used to protect the permanentFailures set from concurrent access
+	mutex sync.Mutex
+}
+
+var _ ratelimiter.RateLimiter = &PlacementBackoff{}
+
+func NewPlacementBackoff() *PlacementBackoff {
+	return &PlacementBackoff{
+		// the default rate limiter of the controllers, up to the ceiling of the tuned caps
+		retryable: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(retryableFailureBaseDelay, tunedBackoffCeiling),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		),
+		permanent:         workqueue.NewItemExponentialFailureRateLimiter(permanentFailureBaseDelay, tunedBackoffCeiling),
+		permanentFailures: sets.New[reconcile.Request](),
+	}
+}
+
+// observe records whether the failed placement of the pod of the request can be retried soon. It does nothing on a
+// nil PlacementBackoff.
+func (b *PlacementBackoff) observe(req reconcile.Request, err error) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if inspect.IsRetryable(err) {
+		b.permanentFailures.Delete(req)
+		return
+	}
+	klog.V(4).Infof("The placement of pod %s failed permanently, backing off from %v: %v", req, permanentFailureBaseDelay,
+		err)
+	b.permanentFailures.Insert(req)
+}
+
+// When returns the delay before the request is processed again, according to the class of its last failure and capped
+// by the tuning in effect
+func (b *PlacementBackoff) When(item interface{}) time.Duration {
+	if b.failedPermanently(item) {
+		return capDelay(b.permanent.When(item), tunedDuration(permanentFailureMaxDelay,
+			func(t *multiarchv1alpha1.TuningSettings) *metav1.Duration { return t.MaxPermanentFailureBackoff }))
+	}
+	return capDelay(b.retryable.When(item), tunedDuration(retryableFailureMaxDelay,
+		func(t *multiarchv1alpha1.TuningSettings) *metav1.Duration { return t.MaxRetryableBackoff }))
+}
+
+func capDelay(delay, max time.Duration) time.Duration {
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Forget resets the backoff of the request, once its pod is placed or deleted
+func (b *PlacementBackoff) Forget(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		b.mutex.Lock()
+		b.permanentFailures.Delete(req)
+		b.mutex.Unlock()
+	}
+	b.retryable.Forget(item)
+	b.permanent.Forget(item)
+}
+
+// NumRequeues returns the number of failures of the request
+func (b *PlacementBackoff) NumRequeues(item interface{}) int {
+	return b.retryable.NumRequeues(item) + b.permanent.NumRequeues(item)
+}
+
+func (b *PlacementBackoff) failedPermanently(item interface{}) bool {
+	req, ok := item.(reconcile.Request)
+	if !ok {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.permanentFailures.Has(req)
+}
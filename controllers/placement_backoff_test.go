@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containers/image/v5/docker"
+	corev1 "k8s.io/api/core/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image/inspect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("The PlacementBackoff", func() {
+	var (
+		backoff *PlacementBackoff
+		req     ctrl.Request
+	)
+
+	BeforeEach(func() {
+		backoff = NewPlacementBackoff()
+		req = ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "test", Name: "pod"}}
+	})
+
+	It("should back off the pods whose placement failed permanently from a minute", func() {
+		backoff.observe(req, &inspect.Error{Kind: inspect.NotFound, Reference: "quay.io/org/missing:v1",
+			Err: errors.New("manifest unknown")})
+		Expect(backoff.When(req)).To(Equal(permanentFailureBaseDelay))
+		Expect(backoff.When(req)).To(Equal(2 * permanentFailureBaseDelay))
+		Expect(backoff.NumRequeues(req)).To(Equal(2))
+	})
+
+	It("should back off the pods whose placement can succeed on a retry as the controllers do", func() {
+		backoff.observe(req, &inspect.Error{Kind: inspect.Transient, Reference: "quay.io/org/app:v1",
+			Err: docker.ErrTooManyRequests})
+		Expect(backoff.When(req)).To(Equal(5 * time.Millisecond))
+		backoff.observe(req, errors.New("none of the architectures supported by the images is allowed"))
+		Expect(backoff.When(req)).To(Equal(10 * time.Millisecond))
+	})
+
+	It("should reset the backoff of the placed pods", func() {
+		backoff.observe(req, &inspect.Error{Kind: inspect.Unauthorized, Reference: "quay.io/org/app:v1",
+			Err: errors.New("unauthorized")})
+		Expect(backoff.When(req)).To(Equal(permanentFailureBaseDelay))
+		backoff.Forget(req)
+		Expect(backoff.NumRequeues(req)).To(BeZero())
+		Expect(backoff.When(req)).To(Equal(5 * time.Millisecond))
+	})
+
+	It("should be informed of the failures of the PodReconciler", func() {
+		pod := podWithImages("pod", "quay.io/org/missing:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod,
+			namespacePlacementPolicy("policy", time.Now(), multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+			})).Build()
+		r := &PodReconciler{Client: c, Inspector: &fakeArchitectures{}, Backoff: backoff}
+		req = ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+		_, err := r.Reconcile(context.Background(), req)
+		Expect(err).To(HaveOccurred())
+		Expect(backoff.When(req)).To(Equal(permanentFailureBaseDelay))
+	})
+})
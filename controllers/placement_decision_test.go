@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/decision"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// fakeArchitectures is the inspection cache of the images: cached are the architectures known at admission, registry
+// the ones returned by the inspections, along with the digests, if any
+type fakeArchitectures struct {
+	cached      map[string][]string
+	registry    map[string][]string
+	digests     map[string]string
+	inspections int
+}
+
+func (f *fakeArchitectures) Cached(imageReference string) (*inspect.InspectionResult, bool) {
+	architectures, ok := f.cached[imageReference]
+	return inspectionResult(imageReference, architectures), ok
+}
+
+func (f *fakeArchitectures) Inspect(_ context.Context, imageReference string,
+	_ [][]byte) (*inspect.InspectionResult, error) {
+	f.inspections++
+	architectures, ok := f.registry[imageReference]
+	if !ok {
+		return nil, &inspect.Error{Kind: inspect.NotFound, Reference: imageReference,
+			Err: fmt.Errorf("the image %s does not exist", imageReference)}
+	}
+	result := inspectionResult(imageReference, architectures)
+	result.Digest = f.digests[imageReference]
+	return result, nil
+}
+
+func (f *fakeArchitectures) PullSources(imageReference string) ([]string, error) {
+	return []string{imageReference}, nil
+}
+
+// noInspections is the Inspector of the specs expecting no inspection: it caches no image and panics when an image is
+// inspected
+type noInspections struct{}
+
+func (noInspections) Cached(string) (*inspect.InspectionResult, bool) {
+	return nil, false
+}
+
+func (noInspections) Inspect(_ context.Context, imageReference string, _ [][]byte) (*inspect.InspectionResult, error) {
+	panic(fmt.Sprintf("unexpected inspection of the image %s", imageReference))
+}
+
+func (noInspections) PullSources(imageReference string) ([]string, error) {
+	return []string{imageReference}, nil
+}
+
+// inspectionResult returns the result of the inspection of an image supporting the linux platforms of the architectures
+func inspectionResult(imageReference string, architectures []string) *inspect.InspectionResult {
+	result := &inspect.InspectionResult{APIVersion: inspect.APIVersion, Reference: imageReference}
+	for _, architecture := range architectures {
+		result.Platforms = append(result.Platforms, inspect.Platform{OS: "linux", Architecture: architecture})
+	}
+	return result
+}
+
+// patchedValue decodes into obj the value of the patch operation of the response for the path
+func patchedValue(response admission.Response, path string, obj interface{}) {
+	for _, operation := range response.Patches {
+		if operation.Path == path {
+			raw, err := json.Marshal(operation.Value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(raw, obj)).To(Succeed())
+			return
+		}
+	}
+	Fail(fmt.Sprintf("no patch operation for %s", path))
+}
+
+var _ = Describe("The placement decision guard", func() {
+	var (
+		architectures *fakeArchitectures
+		c             client.Client
+		webhook       *PodSchedulingGateMutatingWebHook
+		reconciler    *PodReconciler
+	)
+
+	BeforeEach(func() {
+		architectures = &fakeArchitectures{
+			cached:   map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}
+		scheme := newTestScheme()
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		webhook = &PodSchedulingGateMutatingWebHook{Client: c, ArchitecturesCache: architectures}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		// the Clientset is nil: the pods do not use pull secrets
+		reconciler = &PodReconciler{Client: c, Inspector: architectures}
+	})
+
+	admit := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		return response
+	}
+
+	reconcile := func(pod *corev1.Pod) *corev1.Pod {
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		return getPod(c, pod)
+	}
+
+	It("should place the pods at admission when the architectures of all their images are cached", func() {
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Patches).NotTo(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		affinity := &corev1.Affinity{}
+		patchedValue(response, "/spec/affinity", affinity)
+		Expect(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal(
+			[]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveLen(3))
+		Expect(annotations).To(HaveKeyWithValue(placementDecisionAnnotation, placedByWebhook))
+		Expect(annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.ArchitectureConstrained))
+		document, err := decision.Parse(annotations[decisionAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(document.Architectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(document.Reason).To(Equal(reasons.ArchitectureConstrained))
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should gate the pods when the architectures of some images are not cached", func() {
+		response := admit(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		Expect(response.Patches).NotTo(ContainElement(HaveField("Path", "/metadata/annotations")))
+	})
+
+	It("should gate the pods when the fast path is disabled", func() {
+		webhook.ArchitecturesCache = nil
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should not gate nor patch the pods already placed when the webhook is invoked again", func() {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{placementDecisionAnnotation: placedByWebhook}
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")},
+			},
+		}}
+		Expect(admit(pod).Patches).To(BeEmpty())
+	})
+
+	It("should not gate nor patch the gated pods again when the webhook is invoked again", func() {
+		webhook.MutatedBy = "v1.2.3"
+		pod := podWithImages("pod", "quay.io/org/sidecar:v1")
+		var gates []corev1.PodSchedulingGate
+		patchedValue(admit(pod), "/spec/schedulingGates", &gates)
+		Expect(gates).To(Equal([]corev1.PodSchedulingGate{schedulingGate}))
+
+		// another webhook gated the mutated pod and the API server invokes the webhook again
+		pod.Annotations = map[string]string{mutatedByAnnotation: "v1.2.3"}
+		pod.Spec.SchedulingGates = append(gates, corev1.PodSchedulingGate{Name: "example.com/other-gate"})
+		pod.Spec.Affinity = &corev1.Affinity{}
+		// the architectures of the image were cached in the meantime: the gated pod is left to the reconciler
+		architectures.cached["//quay.io/org/sidecar:v1"] = []string{"amd64"}
+		response := admit(pod)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("should only remove the scheduling gate of the gated pods placed at admission", func() {
+		// the pod placed at admission was gated afterwards, e.g., by a webhook invoked after this one
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{placementDecisionAnnotation: placedByWebhook}
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")},
+			},
+		}}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		// the registry would now report other architectures: the decision taken at admission is kept
+		architectures.registry["//quay.io/org/app:v1"] = []string{"s390x"}
+		updated := reconcile(pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity).To(Equal(pod.Spec.Affinity))
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should let the reconciler place the pods gated for a stale cache entry at admission", func() {
+		// the entry of the image expired: the tag now points to an image for other architectures
+		delete(architectures.cached, "//quay.io/org/app:v1")
+		architectures.registry["//quay.io/org/app:v1"] = []string{"arm64", "s390x"}
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		response := admit(pod)
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		updated := reconcile(pod)
+		Expect(architectures.inspections).To(Equal(1))
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Annotations).NotTo(HaveKey(placementDecisionAnnotation))
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "arm64", "s390x")}))
+	})
+
+	It("should stamp the version in the patches of the pods placed at admission when enabled", func() {
+		webhook.MutatedBy = "v1.2.3"
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveKeyWithValue(mutatedByAnnotation, "v1.2.3"))
+	})
+
+	It("should stamp the version in the patches of the gated pods when enabled", func() {
+		webhook.MutatedBy = "v1.2.3"
+		response := admit(podWithImages("pod", "quay.io/org/sidecar:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(Equal(map[string]string{mutatedByAnnotation: "v1.2.3"}))
+	})
+
+	It("should stamp the version of the reconciler on the pods it places when enabled", func() {
+		webhook.MutatedBy = "v1.2.3"
+		reconciler.MutatedBy = "v1.2.4"
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{mutatedByAnnotation: "v1.2.3"}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		updated := reconcile(pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Annotations).To(HaveKeyWithValue(mutatedByAnnotation, "v1.2.4"))
+	})
+
+	It("should not stamp the version by default", func() {
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).NotTo(HaveKey(mutatedByAnnotation))
+		Expect(admit(podWithImages("gated", "quay.io/org/sidecar:v1")).Patches).NotTo(
+			ContainElement(HaveField("Path", "/metadata/annotations")))
+
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(reconcile(pod).Annotations).NotTo(HaveKey(mutatedByAnnotation))
+	})
+
+	It("should gate the pods whose cached architectures are not allowed, for the reconciler to report them", func() {
+		Expect(c.Create(context.Background(), clusterPlacementPolicy("cluster", multiarchv1alpha1.PlacementPolicy{
+			AllowedArchitectures: []string{"s390x"},
+		}))).To(Succeed())
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+})
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The paused pod placement", func() {
+	var (
+		ctx           context.Context
+		architectures *fakeArchitectures
+		c             client.Client
+		recorder      *record.FakeRecorder
+		snapshot      *PodPlacementConfigSnapshot
+		webhook       *PodSchedulingGateMutatingWebHook
+		reconciler    *PodReconciler
+		config        *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		architectures = &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}
+		scheme := newTestScheme()
+		config = clusterPlacementPolicy("cluster", multiarchv1alpha1.PlacementPolicy{
+			FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+		})
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(config).Build()
+		recorder = record.NewFakeRecorder(10)
+		snapshot = NewPodPlacementConfigSnapshot(nil, c)
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		webhook = &PodSchedulingGateMutatingWebHook{Client: c, PodPlacementConfigs: snapshot}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		reconciler = &PodReconciler{Client: c, Inspector: architectures, Recorder: recorder}
+	})
+
+	admit := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		return response
+	}
+
+	createGated := func(name string, images ...string) *corev1.Pod {
+		pod := podWithImages(name, images...)
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(ctx, pod)).To(Succeed())
+		return pod
+	}
+
+	setPaused := func(paused bool) {
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(config), config)).To(Succeed())
+		config.Spec.Paused = paused
+		Expect(c.Update(ctx, config)).To(Succeed())
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+	}
+
+	It("should release the pods gated before the pause in one pass, without their node affinity", func() {
+		Expect(admit(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(
+			ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		gated := []*corev1.Pod{
+			createGated("app", "quay.io/org/app:v1"),
+			// the pods kept gated by the failure policy are released too
+			createGated("missing", "quay.io/org/missing:v1"),
+		}
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(gated[1])})
+		Expect(err).To(HaveOccurred())
+		Expect(getPod(c, gated[1]).Spec.SchedulingGates).NotTo(BeEmpty())
+		inspections := architectures.inspections
+
+		setPaused(true)
+		// the changes of the PodPlacementConfig objects enqueue all the gated pods
+		requests := gatedPodsRequests(ctx, c, nil)
+		Expect(requests).To(HaveLen(len(gated)))
+		for _, request := range requests {
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		for _, pod := range gated {
+			released := getPod(c, pod)
+			Expect(released.Spec.SchedulingGates).To(BeEmpty())
+			Expect(released.Spec.Affinity).To(BeNil())
+			Expect(released.Annotations).To(BeEmpty())
+		}
+		Expect(architectures.inspections).To(Equal(inspections))
+		Expect(gatedPodsRequests(ctx, c, nil)).To(BeEmpty())
+	})
+
+	It("should not gate the new pods while paused", func() {
+		setPaused(true)
+		Expect(admit(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(BeEmpty())
+	})
+
+	It("should only place the pods created after the pod placement is unpaused", func() {
+		released := createGated("released", "quay.io/org/app:v1")
+		setPaused(true)
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(released)})
+		Expect(err).NotTo(HaveOccurred())
+
+		setPaused(false)
+		Expect(admit(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(
+			ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		placed := createGated("placed", "quay.io/org/app:v1")
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(placed)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPod(c, placed).Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+		Expect(getPod(c, released).Spec.Affinity).To(BeNil())
+	})
+
+	It("should be paused by any PodPlacementConfig", func() {
+		Expect(c.Create(ctx, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "incident"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Paused: true},
+		})).To(Succeed())
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		Expect(admit(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(BeEmpty())
+	})
+})
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/multiarch"
+	"multiarch-operator/pkg/image"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// effectivePlacementPolicy returns the placement settings of the pods of the namespace: the ones of the active
+// PodPlacementPolicy of the namespace, if any, and the ones of the PodPlacementConfig objects for the fields it does not
+// set. The PodPlacementConfig objects are merged in name order, the first one setting a field wins.
+func effectivePlacementPolicy(ctx context.Context, c client.Reader, namespace string) (multiarchv1alpha1.PlacementPolicy, error) {
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, c)
+	if err != nil {
+		return multiarchv1alpha1.PlacementPolicy{}, err
+	}
+	return mergePlacementPolicy(ctx, c, namespace, podPlacementConfigs)
+}
+
+// listPodPlacementConfigs returns the PodPlacementConfig objects sorted by name. No object is returned when their
+// CustomResourceDefinition is not installed: the built-in default placement settings apply.
+func listPodPlacementConfigs(ctx context.Context, c client.Reader) ([]multiarchv1alpha1.PodPlacementConfig, error) {
+	podPlacementConfigs := &multiarchv1alpha1.PodPlacementConfigList{}
+	if err := c.List(ctx, podPlacementConfigs); err != nil {
+		if meta.IsNoMatchError(err) {
+			klog.V(4).Infof("The PodPlacementConfig objects are not served, using the default placement settings: %v",
+				err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(podPlacementConfigs.Items, func(i, j int) bool {
+		return podPlacementConfigs.Items[i].Name < podPlacementConfigs.Items[j].Name
+	})
+	return podPlacementConfigs.Items, nil
+}
+
+// placementPausedBy returns the name of the first paused PodPlacementConfig, or an empty string if the pod placement is
+// not paused
+func placementPausedBy(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) string {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.Paused {
+			return ppc.Name
+		}
+	}
+	return ""
+}
+
+// pinsToInspectedDigest returns true if any PodPlacementConfig requests the images of the placed pods to be pinned to
+// their inspected digests
+func pinsToInspectedDigest(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) bool {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.PinToInspectedDigest {
+			return true
+		}
+	}
+	return false
+}
+
+// deniesImpossiblePods returns true if any PodPlacementConfig requests the webhook to deny the pods whose images
+// support none of the architectures of the cluster
+func deniesImpossiblePods(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) bool {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.Admission != nil && ppc.Spec.Admission.DenyImpossiblePods {
+			return true
+		}
+	}
+	return false
+}
+
+// skipsSingleArchCluster returns true if any PodPlacementConfig requests the webhook not to gate the pods while the
+// cluster only has nodes of a single architecture
+func skipsSingleArchCluster(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) bool {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.SkipSingleArchCluster {
+			return true
+		}
+	}
+	return false
+}
+
+// mutatesWorkloadTemplates returns true if any PodPlacementConfig requests the node affinity of the pod templates of
+// the workloads to be set at their admission
+func mutatesWorkloadTemplates(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) bool {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.MutateWorkloadTemplates {
+			return true
+		}
+	}
+	return false
+}
+
+// considersAutoscaledCapacity returns true if any PodPlacementConfig requests the architectures of the scalable
+// MachineSets to be considered as feasible
+func considersAutoscaledCapacity(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) bool {
+	for _, ppc := range podPlacementConfigs {
+		if ppc.Spec.ConsiderAutoscaledCapacity {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePlacementPolicy returns the placement settings of the pods of the namespace, as effectivePlacementPolicy does,
+// for the PodPlacementConfig objects sorted by name. Only the PodPlacementPolicy objects of the namespace are read,
+// if their CustomResourceDefinition is installed.
+func mergePlacementPolicy(ctx context.Context, c client.Reader, namespace string,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (multiarchv1alpha1.PlacementPolicy, error) {
+	policies := &multiarchv1alpha1.PodPlacementPolicyList{}
+	if err := c.List(ctx, policies, client.InNamespace(namespace)); err != nil && !meta.IsNoMatchError(err) {
+		return multiarchv1alpha1.PlacementPolicy{}, err
+	}
+	var effective multiarchv1alpha1.PlacementPolicy
+	if active := multiarch.ActivePodPlacementPolicy(policies.Items); active != nil {
+		effective = *active.Spec.PlacementPolicy.DeepCopy()
+	}
+	for _, ppc := range podPlacementConfigs {
+		if err := multiarch.ValidatePlacementPolicy(&ppc.Spec.PlacementPolicy); err != nil {
+			klog.Warningf("Ignoring the placement settings of the PodPlacementConfig %s: %v", ppc.Name, err)
+			continue
+		}
+		inheritPlacementPolicy(&effective, &ppc.Spec.PlacementPolicy)
+	}
+	return effective, nil
+}
+
+// inheritPlacementPolicy sets the fields of policy that are not set to the value they have in parent
+func inheritPlacementPolicy(policy, parent *multiarchv1alpha1.PlacementPolicy) {
+	if policy.PlacementMode == "" {
+		policy.PlacementMode = parent.PlacementMode
+	}
+	if len(policy.AllowedArchitectures) == 0 {
+		policy.AllowedArchitectures = parent.AllowedArchitectures
+	}
+	if policy.FailurePolicy == "" {
+		policy.FailurePolicy = parent.FailurePolicy
+	}
+	if len(policy.DefaultArchitectures) == 0 {
+		policy.DefaultArchitectures = parent.DefaultArchitectures
+	}
+	if len(policy.ExcludedContainerNames) == 0 {
+		policy.ExcludedContainerNames = parent.ExcludedContainerNames
+	}
+	if policy.OptOut == nil {
+		policy.OptOut = parent.OptOut
+	}
+}
+
+func isOptedOut(policy multiarchv1alpha1.PlacementPolicy) bool {
+	return policy.OptOut != nil && *policy.OptOut
+}
+
+// allowedArchitectures returns the architectures of the values allowed by the policy, in the same order
+func allowedArchitectures(policy multiarchv1alpha1.PlacementPolicy, values []string) []string {
+	if len(policy.AllowedArchitectures) == 0 {
+		return values
+	}
+	allowed := sets.New[string]()
+	for _, architecture := range policy.AllowedArchitectures {
+		// the policies are validated before being used
+		architecture, _ = image.ParseArchitecture(architecture)
+		allowed.Insert(architecture)
+	}
+	filtered := make([]string, 0, len(values))
+	for _, value := range values {
+		if allowed.Has(value) {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
+
+// gatedPodsRequests returns the requests of the pods gated by the instance matching the list options, e.g., for them
+// to be placed again when the placement settings change
+func gatedPodsRequests(ctx context.Context, c client.Reader, instance *Instance,
+	opts ...client.ListOption) []reconcile.Request {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, opts...); err != nil {
+		klog.Warningf("Unable to list the gated pods to place them with the updated placement settings: %v", err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range pods.Items {
+		if instance.hasSchedulingGate(&pods.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: pods.Items[i].Namespace,
+				Name:      pods.Items[i].Name,
+			}})
+		}
+	}
+	return requests
+}
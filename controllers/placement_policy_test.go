@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func namespacePlacementPolicy(name string, created time.Time,
+	policy multiarchv1alpha1.PlacementPolicy) *multiarchv1alpha1.PodPlacementPolicy {
+	return &multiarchv1alpha1.PodPlacementPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: policy},
+	}
+}
+
+func clusterPlacementPolicy(name string, policy multiarchv1alpha1.PlacementPolicy) *multiarchv1alpha1.PodPlacementConfig {
+	return &multiarchv1alpha1.PodPlacementConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       multiarchv1alpha1.PodPlacementConfigSpec{PlacementPolicy: policy},
+	}
+}
+
+var _ = Describe("The effective placement settings", func() {
+	It("should inherit the fields not set by the namespace policy from the PodPlacementConfig objects", func() {
+		now := time.Now()
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			clusterPlacementPolicy("b", multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy:        multiarchv1alpha1.PlacementFailurePolicyIgnore,
+				AllowedArchitectures: []string{"s390x"},
+				DefaultArchitectures: []string{"amd64"},
+			}),
+			clusterPlacementPolicy("a", multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+				PlacementMode: multiarchv1alpha1.PlacementModeRequired,
+			}),
+			clusterPlacementPolicy("0-invalid", multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"vax"}}),
+			namespacePlacementPolicy("older", now.Add(-time.Hour), multiarchv1alpha1.PlacementPolicy{
+				PlacementMode: multiarchv1alpha1.PlacementModePreferred,
+			}),
+			namespacePlacementPolicy("newer", now, multiarchv1alpha1.PlacementPolicy{
+				AllowedArchitectures: []string{"ppc64le"},
+			}),
+		).Build()
+		policy, err := effectivePlacementPolicy(context.Background(), c, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy).To(Equal(multiarchv1alpha1.PlacementPolicy{
+			PlacementMode:        multiarchv1alpha1.PlacementModePreferred,
+			AllowedArchitectures: []string{"s390x"},
+			FailurePolicy:        multiarchv1alpha1.PlacementFailurePolicyFail,
+			DefaultArchitectures: []string{"amd64"},
+		}))
+
+		policy, err = effectivePlacementPolicy(context.Background(), c, "other")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.PlacementMode).To(Equal(multiarchv1alpha1.PlacementModeRequired))
+	})
+
+	It("should filter the allowed architectures by their GOARCH names", func() {
+		policy := multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"x86_64", "s390x"}}
+		Expect(allowedArchitectures(policy, []string{"amd64", "arm64", "s390x"})).To(Equal([]string{"amd64", "s390x"}))
+		Expect(allowedArchitectures(multiarchv1alpha1.PlacementPolicy{}, []string{"arm64"})).To(Equal([]string{"arm64"}))
+	})
+})
+
+var _ = Describe("The placement of the pods by the namespace policy", func() {
+	var (
+		recorder *record.FakeRecorder
+		pod      *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		pod = podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64,amd64"}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+	})
+
+	reconcile := func(policy multiarchv1alpha1.PlacementPolicy) (client.Client, error) {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod,
+			namespacePlacementPolicy("policy", time.Now(), policy)).Build()
+		// any inspection would panic
+		r := &PodReconciler{Client: c, Recorder: recorder, Inspector: noInspections{}}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		return c, err
+	}
+
+	It("should set a preferred node affinity term in the Preferred mode", func() {
+		c, err := reconcile(multiarchv1alpha1.PlacementPolicy{PlacementMode: multiarchv1alpha1.PlacementModePreferred})
+		Expect(err).NotTo(HaveOccurred())
+		updated := getPod(c, pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(BeNil())
+		Expect(updated.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(ConsistOf(
+			corev1.PreferredSchedulingTerm{
+				Weight: preferredArchitecturesWeight,
+				Preference: corev1.NodeSelectorTerm{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      archLabel,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"amd64", "arm64"},
+					}},
+				},
+			}))
+	})
+
+	It("should restrict the node affinity to the allowed architectures", func() {
+		c, err := reconcile(multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"aarch64", "s390x"}})
+		Expect(err).NotTo(HaveOccurred())
+		updated := getPod(c, pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			ConsistOf(corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      archLabel,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{"arm64"},
+				}},
+			}))
+	})
+
+	It("should keep the pod gated when no architecture is allowed", func() {
+		c, err := reconcile(multiarchv1alpha1.PlacementPolicy{AllowedArchitectures: []string{"s390x"}})
+		Expect(err).To(HaveOccurred())
+		Expect(getPod(c, pod).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning + " " + reasons.NoCommonArchitecture)))
+	})
+
+	It("should report the images of the other transports", func() {
+		pod = podWithImages("pod", "oci:/var/lib/layouts/app")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod,
+			namespacePlacementPolicy("policy", time.Now(), multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+			})).Build()
+		inspector := &fakeArchitectures{}
+		r := &PodReconciler{Client: c, Recorder: recorder, Inspector: inspector}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).To(HaveOccurred())
+		Expect(inspector.inspections).To(BeZero())
+		Expect(getPod(c, pod).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(recorder.Events).To(Receive(And(
+			HavePrefix(corev1.EventTypeWarning+" "+reasons.UnsupportedImageTransport),
+			ContainSubstring("the oci transport"))))
+		Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning + " " +
+			reasons.InspectionFailedInvalidReference)))
+	})
+
+	Context("with default architectures", func() {
+		reconcileImage := func(image string, policy multiarchv1alpha1.PlacementPolicy) (client.Client, error) {
+			pod = podWithImages("pod", image)
+			pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod,
+				namespacePlacementPolicy("policy", time.Now(), policy)).Build()
+			inspector := &fakeArchitectures{registry: map[string][]string{"//quay.io/org/app:v1": {"arm64"}}}
+			r := &PodReconciler{Client: c, Recorder: recorder, Inspector: inspector}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			return c, err
+		}
+
+		It("should place the pods whose images cannot be inspected on them, over the Fail policy", func() {
+			c, err := reconcileImage("quay.io/org/internal:v1", multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy:        multiarchv1alpha1.PlacementFailurePolicyFail,
+				DefaultArchitectures: []string{"x86_64"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			updated := getPod(c, pod)
+			Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+			Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+				To(ConsistOf(corev1.NodeSelectorTerm{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      archLabel,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"amd64"},
+					}},
+				}))
+			Expect(updated.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.DefaultedArchitectures))
+			Expect(recorder.Events).To(Receive(And(
+				HavePrefix(corev1.EventTypeNormal+" "+reasons.DefaultedArchitectures),
+				ContainSubstring("default architectures amd64"))))
+		})
+
+		It("should restrict them to the allowed architectures", func() {
+			c, err := reconcileImage("quay.io/org/internal:v1", multiarchv1alpha1.PlacementPolicy{
+				AllowedArchitectures: []string{"arm64"},
+				DefaultArchitectures: []string{"amd64"},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(getPod(c, pod).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning + " " + reasons.NoCommonArchitecture)))
+		})
+
+		It("should not replace the architectures of the images that can be inspected", func() {
+			c, err := reconcileImage("quay.io/org/app:v1", multiarchv1alpha1.PlacementPolicy{
+				DefaultArchitectures: []string{"amd64"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			updated := getPod(c, pod)
+			Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+				To(ConsistOf(HaveField("MatchExpressions", ConsistOf(HaveField("Values", []string{"arm64"})))))
+			Expect(updated.Annotations).To(HaveKeyWithValue(placementReasonAnnotation,
+				reasons.ArchitectureConstrained))
+		})
+	})
+
+	It("should ungate the pods of the opted-out namespaces without node affinity", func() {
+		c, err := reconcile(multiarchv1alpha1.PlacementPolicy{OptOut: pointer.Bool(true)})
+		Expect(err).NotTo(HaveOccurred())
+		updated := getPod(c, pod)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity).To(BeNil())
+	})
+})
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
+)
+
+var _ = Describe("The reasons of the placement decisions", func() {
+	failing := multiarchv1alpha1.PlacementPolicy{FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail}
+	inspectionErr := errors.New("connection reset by peer")
+
+	DescribeTable("should be defined for every outcome of the evaluation",
+		func(evaluation placementEvaluation, expected string) {
+			Expect(evaluation.reason()).To(Equal(expected))
+			Expect(reasons.IsDefined(evaluation.reason())).To(BeTrue())
+		},
+		Entry("opted out", placementEvaluation{skipped: skippedOptedOut}, reasons.OptedOut),
+		Entry("trusted images", placementEvaluation{skipped: skippedTrustedImages}, reasons.TrustedImages),
+		Entry("all containers excluded", placementEvaluation{skipped: skippedAllContainersExcluded},
+			reasons.AllContainersExcluded),
+		Entry("inspection failed with the Ignore policy", placementEvaluation{inspectionErr: inspectionErr},
+			reasons.GateRemovedByPolicy),
+		Entry("inspection failed with the Fail policy", placementEvaluation{policy: failing,
+			inspectionErr: inspectionErr}, reasons.InspectionFailed),
+		Entry("no allowed architecture", placementEvaluation{disallowed: true}, reasons.NoCommonArchitecture),
+		Entry("placed", placementEvaluation{decision: placementDecision{reason: reasons.ArchitectureConstrained}},
+			reasons.ArchitectureConstrained),
+	)
+
+	DescribeTable("should be defined for every kind of the failed inspections",
+		func(kind inspect.ErrorKind, expected string) {
+			reason := inspectionFailedReason(fmt.Errorf("the image cannot be inspected: %w",
+				&inspect.Error{Kind: kind, Reference: "//quay.io/org/app:v1", Err: errors.New("failed")}))
+			Expect(reason).To(Equal(expected))
+			Expect(reasons.IsDefined(reason)).To(BeTrue())
+		},
+		Entry("invalid reference", inspect.InvalidReference, reasons.InspectionFailedInvalidReference),
+		Entry("unsupported transport", inspect.UnsupportedTransport, reasons.InspectionFailedInvalidReference),
+		Entry("not found", inspect.NotFound, reasons.InspectionFailedNotFound),
+		Entry("unauthorized", inspect.Unauthorized, reasons.InspectionFailedAuth),
+		Entry("TLS policy", inspect.TLSPolicy, reasons.InspectionFailedTLS),
+		Entry("transient", inspect.Transient, reasons.InspectionFailed),
+	)
+
+	DescribeTable("should be defined for every class of the transient failures",
+		func(err error, expected string) {
+			reason := inspectionFailedReason(&inspect.Error{Kind: inspect.Transient, Err: err})
+			Expect(reason).To(Equal(expected))
+			Expect(reason).To(HavePrefix(reasons.InspectionFailed))
+			Expect(reasons.IsDefined(reason)).To(BeTrue())
+		},
+		Entry("connection refused", syscall.ECONNREFUSED, reasons.InspectionFailedNetwork),
+		Entry("rate limited", errors.New("invalid status code from registry 429"), reasons.InspectionFailedRateLimited),
+		Entry("server error", errors.New("invalid status code from registry 503"),
+			reasons.InspectionFailedRegistryError),
+		Entry("manifest too large", &image.ManifestTooLargeError{Max: 1}, reasons.InspectionFailedManifestTooLarge),
+		Entry("other", errors.New("connection reset by peer"), reasons.InspectionFailed),
+	)
+
+	It("should be defined for the conditions of the custom resources", func() {
+		for _, reason := range []string{multiarchv1alpha1.AsExpectedReason,
+			multiarchv1alpha1.SchedulingGatesUnsupportedReason, multiarchv1alpha1.NodeSyncerRolloutInProgressReason,
+			multiarchv1alpha1.PlacementPausedReason, multiarchv1alpha1.InvalidSpecReason,
+			multiarchv1alpha1.MultiplePoliciesReason} {
+			Expect(reasons.IsDefined(reason)).To(BeTrue(), reason)
+		}
+	})
+})
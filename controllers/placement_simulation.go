@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+const (
+	// PlacementSimulationPath is the path of the endpoint of the PlacementSimulator
+	PlacementSimulationPath = "/v1/simulate"
+	// placementSimulationPodName is the name of the simulated pods whose template has none
+	placementSimulationPodName = "placement-simulation"
+	// maxPlacementSimulationRequestBytes is the maximum size of the body of the simulation requests
+	maxPlacementSimulationRequestBytes = 1 << 20
+)
+
+// PlacementSimulationRequest is the body of the requests of the PlacementSimulator. Exactly one of Spec and Template
+// must be set.
+type PlacementSimulationRequest struct {
+	// Namespace is the namespace the pod would be created in: its placement settings and pull secrets are used
+	Namespace string                  `json:"namespace"`
+	Spec      *corev1.PodSpec         `json:"spec,omitempty"`
+	Template  *corev1.PodTemplateSpec `json:"template,omitempty"`
+}
+
+// PlacementSimulationResponse is the outcome of the simulation of the placement of a pod
+type PlacementSimulationResponse struct {
+	// Architectures are the architectures the pod would be placed on. They are empty when the operator would not
+	// restrict them, see Skipped, or when the pod would stay gated.
+	Architectures []string `json:"architectures"`
+	// SupportedArchitectures are the architectures supported by the images, or declared by the pod, before the
+	// placement settings restrict them
+	SupportedArchitectures []string `json:"supportedArchitectures,omitempty"`
+	// Affinity is the affinity of the pod once placed, including the node affinity injected by the operator
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// Gated is true when the pod would stay gated, e.g., when its images cannot be inspected and the failure
+	// policy is Fail
+	Gated bool `json:"gated"`
+	// Schedulable is true when at least one schedulable node currently satisfies the node selector and the required
+	// node affinity of the placed pod. It is false for the gated pods.
+	Schedulable bool `json:"schedulable"`
+	// Skipped is the reason the operator would not restrict the architectures of the pod: OptedOut,
+	// TrustedImages or AllContainersExcluded
+	Skipped string `json:"skipped,omitempty"`
+	// Reason is the stable reason of the decision, e.g., ArchitectureConstrained, OptedOut or NoCommonArchitecture,
+	// see the reasons package
+	Reason string `json:"reason"`
+	// Errors are the errors of the inspection of the images
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PlacementSimulator serves the simulation of the placement of the pods on POST PlacementSimulationPath, e.g., for the
+// pipelines to check whether their workloads would be schedulable before deploying them. The placement is evaluated
+// as the Reconciler does, with the same placement settings, image stream resolver, inspector and capacity cache, but
+// nothing is created nor changed in the cluster and no event is recorded.
+// The requests are authenticated by a TokenReview of their bearer token, and the user must be allowed to create pods
+// in the namespace of the simulation, as its pull secrets are used to inspect the images.
+type PlacementSimulator struct {
+	Reconciler *PodReconciler
+	// Client creates the TokenReview and SubjectAccessReview objects authorizing the requests
+	Client client.Client
+}
+
+func (s *PlacementSimulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only the POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request := &PlacementSimulationRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPlacementSimulationRequestBytes)).Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid simulation request: %v", err), http.StatusBadRequest)
+		return
+	}
+	pod, err := request.pod()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid simulation request: %v", err), http.StatusBadRequest)
+		return
+	}
+	authorizer := &requestAuthorizer{client: s.Client}
+	if status, err := authorizer.authorize(r.Context(), r, authorizationv1.ResourceAttributes{
+		Namespace: pod.Namespace,
+		Verb:      "create",
+		Resource:  "pods",
+	}); err != nil {
+		klog.V(3).Infof("Rejecting the placement simulation request: %v", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	response, err := s.simulate(r, pod)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		klog.Warningf("Unable to write the placement simulation response: %v", err)
+	}
+}
+
+// simulate evaluates the placement of the pod as the Reconciler does, without recording events
+func (s *PlacementSimulator) simulate(r *http.Request, pod *corev1.Pod) (*PlacementSimulationResponse, error) {
+	reconciler := *s.Reconciler
+	reconciler.Recorder = nil
+	evaluation, err := reconciler.evaluate(r.Context(), pod)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the placement settings of the namespace %s: %w", pod.Namespace, err)
+	}
+	response := &PlacementSimulationResponse{
+		Architectures:          []string{},
+		SupportedArchitectures: evaluation.supported,
+		Skipped:                evaluation.skipped,
+		Reason:                 evaluation.reason(),
+	}
+	if evaluation.defaultedFrom != nil {
+		response.Errors = []string{evaluation.defaultedFrom.Error()}
+	}
+	switch {
+	case evaluation.inspectionErr != nil:
+		response.Errors = []string{evaluation.inspectionErr.Error()}
+		response.Gated = evaluation.policy.FailurePolicy == multiarchv1alpha1.PlacementFailurePolicyFail
+	case evaluation.disallowed:
+		response.Gated = true
+	case evaluation.decision.requirement != nil:
+		response.Architectures = evaluation.decision.requirement.Values
+	}
+	if response.Gated {
+		return response, nil
+	}
+	evaluation.decision.apply(r.Context(), pod, reconciler.Instance)
+	response.Affinity = pod.Spec.Affinity
+	nodes := &corev1.NodeList{}
+	if err := reconciler.List(r.Context(), nodes); err != nil {
+		return nil, fmt.Errorf("unable to list the nodes: %w", err)
+	}
+	for i := range nodes.Items {
+		if !nodes.Items[i].Spec.Unschedulable && nodeSatisfiesPod(&nodes.Items[i], pod) {
+			response.Schedulable = true
+			break
+		}
+	}
+	return response, nil
+}
+
+// pod returns the pod of the simulation request
+func (r *PlacementSimulationRequest) pod() (*corev1.Pod, error) {
+	if (r.Spec == nil) == (r.Template == nil) {
+		return nil, errors.New("exactly one of spec and template must be set")
+	}
+	if errs := validation.IsDNS1123Label(r.Namespace); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid namespace %q: %v", r.Namespace, errs)
+	}
+	pod := &corev1.Pod{}
+	if r.Template != nil {
+		pod.ObjectMeta = *r.Template.ObjectMeta.DeepCopy()
+		pod.Spec = *r.Template.Spec.DeepCopy()
+	} else {
+		pod.Spec = *r.Spec.DeepCopy()
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return nil, errors.New("the pod has no containers")
+	}
+	pod.ObjectMeta = metav1.ObjectMeta{
+		Name:        placementSimulationPodName,
+		Namespace:   r.Namespace,
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+	}
+	if r.Template != nil && r.Template.Name != "" {
+		pod.Name = r.Template.Name
+	}
+	return pod, nil
+}
+
+// nodeSatisfiesPod returns true if the node matches the node selector and the required node affinity of the pod
+func nodeSatisfiesPod(node *corev1.Node, pod *corev1.Pod) bool {
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	// the terms are ORed
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if nodeSelectorTermMatches(term, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeSelectorTermMatches returns true if the node matches all the requirements of the term. As for the scheduler, an
+// empty term matches no node, and metadata.name is the only field matchFields supports.
+func nodeSelectorTermMatches(term corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, requirement := range term.MatchExpressions {
+		if !nodeSelectorRequirementMatches(requirement, labels.Set(node.Labels)) {
+			return false
+		}
+	}
+	for _, requirement := range term.MatchFields {
+		if requirement.Key != metav1.ObjectNameField ||
+			!nodeSelectorRequirementMatches(requirement, labels.Set{metav1.ObjectNameField: node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+func nodeSelectorRequirementMatches(requirement corev1.NodeSelectorRequirement, set labels.Set) bool {
+	operator, ok := nodeSelectorOperators[requirement.Operator]
+	if !ok {
+		return false
+	}
+	parsed, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+	if err != nil {
+		return false
+	}
+	return parsed.Matches(set)
+}
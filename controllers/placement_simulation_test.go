@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const (
+	simulationToken = "valid-token"
+	simulationUser  = "pipeline"
+)
+
+// reviewingTokens returns the interceptor functions authenticating the simulationToken as the simulationUser, allowed
+// the actions in the allowedNamespace only, and recording the SubjectAccessReview objects in reviews
+func reviewingTokens(allowedNamespace string, reviews *[]authorizationv1.SubjectAccessReviewSpec) interceptor.Funcs {
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			switch review := obj.(type) {
+			case *authenticationv1.TokenReview:
+				if review.Spec.Token == simulationToken {
+					review.Status.Authenticated = true
+					review.Status.User = authenticationv1.UserInfo{Username: simulationUser, Groups: []string{"ci"}}
+				}
+				return nil
+			case *authorizationv1.SubjectAccessReview:
+				*reviews = append(*reviews, review.Spec)
+				review.Status.Allowed = review.Spec.ResourceAttributes.Namespace == allowedNamespace
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}
+}
+
+var _ = Describe("The placement simulator", func() {
+	var (
+		architectures *fakeArchitectures
+		c             client.Client
+		recorder      *record.FakeRecorder
+		reviews       []authorizationv1.SubjectAccessReviewSpec
+		simulator     *PlacementSimulator
+	)
+
+	BeforeEach(func() {
+		reviews = nil
+		architectures = &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+		}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithObjects(nodeWithCapacity("worker", "arm64", "4", "8Gi", false)).
+			WithInterceptorFuncs(reviewingTokens("test", &reviews)).Build()
+		recorder = record.NewFakeRecorder(10)
+		simulator = &PlacementSimulator{
+			Reconciler: &PodReconciler{Client: c, Inspector: architectures, Recorder: recorder},
+			Client:     c,
+		}
+	})
+
+	post := func(token string, body interface{}) *httptest.ResponseRecorder {
+		raw, err := json.Marshal(body)
+		Expect(err).NotTo(HaveOccurred())
+		request := httptest.NewRequest(http.MethodPost, PlacementSimulationPath, bytes.NewReader(raw))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		simulator.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	simulate := func(body interface{}) *PlacementSimulationResponse {
+		recorder := post(simulationToken, body)
+		Expect(recorder.Code).To(Equal(http.StatusOK), recorder.Body.String())
+		response := &PlacementSimulationResponse{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), response)).To(Succeed())
+		return response
+	}
+
+	specOf := func(images ...string) *PlacementSimulationRequest {
+		return &PlacementSimulationRequest{Namespace: "test", Spec: &podWithImages("pod", images...).Spec}
+	}
+
+	It("should return the architectures and the affinity of the pod without changing the cluster", func() {
+		response := simulate(specOf("quay.io/org/app:v1"))
+		Expect(response.Architectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(response.Reason).To(Equal(reasons.ArchitectureConstrained))
+		Expect(response.SupportedArchitectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(response.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+		Expect(response.Schedulable).To(BeTrue())
+		Expect(response.Gated).To(BeFalse())
+		Expect(response.Errors).To(BeEmpty())
+
+		Expect(reviews).To(ConsistOf(HaveField("ResourceAttributes", Equal(&authorizationv1.ResourceAttributes{
+			Namespace: "test", Verb: "create", Resource: "pods",
+		}))))
+		pods := &corev1.PodList{}
+		Expect(c.List(context.Background(), pods)).To(Succeed())
+		Expect(pods.Items).To(BeEmpty())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should simulate the pod templates", func() {
+		template := &corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{"app": "app"}},
+			Spec:       podWithImages("pod", "quay.io/org/app:v1").Spec,
+		}
+		template.Spec.NodeSelector = map[string]string{"node-role.kubernetes.io/infra": ""}
+		response := simulate(&PlacementSimulationRequest{Namespace: "test", Template: template})
+		Expect(response.Architectures).To(Equal([]string{"amd64", "arm64"}))
+		Expect(response.Schedulable).To(BeFalse())
+	})
+
+	It("should report no schedulable node when no node has the supported architectures", func() {
+		architectures.registry["//quay.io/org/app:v1"] = []string{"amd64"}
+		response := simulate(specOf("quay.io/org/app:v1"))
+		Expect(response.Architectures).To(Equal([]string{"amd64"}))
+		Expect(response.Schedulable).To(BeFalse())
+	})
+
+	It("should report the inspection errors and the gating of the pods with the Fail policy", func() {
+		response := simulate(specOf("quay.io/org/missing:v1"))
+		Expect(response.Errors).To(ConsistOf(ContainSubstring("quay.io/org/missing:v1")))
+		Expect(response.Gated).To(BeFalse())
+		Expect(response.Reason).To(Equal(reasons.GateRemovedByPolicy))
+		Expect(response.Architectures).To(BeEmpty())
+		Expect(response.Schedulable).To(BeTrue())
+
+		Expect(c.Create(context.Background(), &multiarchv1alpha1.PodPlacementPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "test"},
+			Spec: multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+			}},
+		})).To(Succeed())
+		response = simulate(specOf("quay.io/org/missing:v1"))
+		Expect(response.Errors).To(HaveLen(1))
+		Expect(response.Gated).To(BeTrue())
+		Expect(response.Reason).To(Equal(reasons.InspectionFailedNotFound))
+		Expect(response.Affinity).To(BeNil())
+		Expect(response.Schedulable).To(BeFalse())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should report the pods skipped by the placement", func() {
+		optOut := true
+		Expect(c.Create(context.Background(), &multiarchv1alpha1.PodPlacementPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "test"},
+			Spec: multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+				OptOut: &optOut,
+			}},
+		})).To(Succeed())
+		response := simulate(specOf("quay.io/org/app:v1"))
+		Expect(response.Skipped).To(Equal(skippedOptedOut))
+		Expect(response.Reason).To(Equal(reasons.OptedOut))
+		Expect(response.Architectures).To(BeEmpty())
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should not inspect the images of the excluded containers", func() {
+		Expect(c.Create(context.Background(), &multiarchv1alpha1.PodPlacementPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "test"},
+			Spec: multiarchv1alpha1.PodPlacementPolicySpec{PlacementPolicy: multiarchv1alpha1.PlacementPolicy{
+				ExcludedContainerNames: []string{"*-sidecar"},
+			}},
+		})).To(Succeed())
+		request := specOf("quay.io/org/proxy:v1")
+		request.Spec.Containers[0].Name = "proxy-sidecar"
+		response := simulate(request)
+		Expect(response.Skipped).To(Equal(skippedAllContainersExcluded))
+		Expect(response.Reason).To(Equal(reasons.AllContainersExcluded))
+		Expect(response.Architectures).To(BeEmpty())
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should reject the requests without a valid token", func() {
+		Expect(post("", specOf("quay.io/org/app:v1")).Code).To(Equal(http.StatusUnauthorized))
+		Expect(post("invalid-token", specOf("quay.io/org/app:v1")).Code).To(Equal(http.StatusUnauthorized))
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should reject the users not allowed to create pods in the namespace", func() {
+		request := specOf("quay.io/org/app:v1")
+		request.Namespace = "other"
+		Expect(post(simulationToken, request).Code).To(Equal(http.StatusForbidden))
+		Expect(reviews).To(ConsistOf(HaveField("User", simulationUser)))
+		Expect(architectures.inspections).To(BeZero())
+	})
+
+	It("should reject the invalid requests", func() {
+		Expect(post(simulationToken, &PlacementSimulationRequest{Namespace: "test"}).Code).To(
+			Equal(http.StatusBadRequest))
+		request := specOf("quay.io/org/app:v1")
+		request.Template = &corev1.PodTemplateSpec{Spec: *request.Spec}
+		Expect(post(simulationToken, request).Code).To(Equal(http.StatusBadRequest))
+		request = specOf("quay.io/org/app:v1")
+		request.Namespace = "Invalid_Namespace"
+		Expect(post(simulationToken, request).Code).To(Equal(http.StatusBadRequest))
+		Expect(post(simulationToken, "not a request").Code).To(Equal(http.StatusBadRequest))
+		Expect(reviews).To(BeEmpty())
+	})
+
+	It("should only allow the POST method", func() {
+		recorder := httptest.NewRecorder()
+		simulator.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, PlacementSimulationPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal(http.MethodPost))
+	})
+})
+
+var _ = Describe("The matching of the nodes", func() {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker", Labels: map[string]string{archLabel: "arm64"}}}
+
+	DescribeTable("should match the node selector and the required node affinity",
+		func(nodeSelector map[string]string, terms []corev1.NodeSelectorTerm, expected bool) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: nodeSelector}}
+			if terms != nil {
+				pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+				}}
+			}
+			Expect(nodeSatisfiesPod(node, pod)).To(Equal(expected))
+		},
+		Entry("no constraints", nil, nil, true),
+		Entry("matching node selector", map[string]string{archLabel: "arm64"}, nil, true),
+		Entry("non-matching node selector", map[string]string{archLabel: "amd64"}, nil, false),
+		Entry("matching term", nil, []corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}, true),
+		Entry("ORed terms", nil, []corev1.NodeSelectorTerm{archTerm(archLabel, "amd64"),
+			archTerm(archLabel, "arm64")}, true),
+		Entry("non-matching term", nil, []corev1.NodeSelectorTerm{archTerm(archLabel, "amd64")}, false),
+		Entry("empty term", nil, []corev1.NodeSelectorTerm{{}}, false),
+		Entry("matching field", nil, []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{{
+			Key: metav1.ObjectNameField, Operator: corev1.NodeSelectorOpIn, Values: []string{"worker"},
+		}}}}, true),
+		Entry("unsupported field", nil, []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{{
+			Key: "spec.unschedulable", Operator: corev1.NodeSelectorOpDoesNotExist,
+		}}}}, false),
+		Entry("DoesNotExist", nil, []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{
+			Key: "node-role.kubernetes.io/infra", Operator: corev1.NodeSelectorOpDoesNotExist,
+		}}}}, true),
+	)
+})
@@ -0,0 +1,478 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podTemplateHashLabels are the labels identifying the pod template revision of the pods of the workload controllers:
+// the StatefulSets and DaemonSets set the controller-revision-hash label, the ReplicaSets the pod-template-hash one.
+var podTemplateHashLabels = []string{"controller-revision-hash", "pod-template-hash"}
+
+// placementDecision is the outcome of the processing of a gated pod
+type placementDecision struct {
+	// requirement is nil when no node affinity has to be set
+	requirement *corev1.NodeSelectorRequirement
+	// preferred is true when the requirement is set as a preferred node affinity term instead of a required one
+	preferred bool
+	// betaArchLabelFallback is true when the requirement also has to match the nodes by the betaArchLabel label, as
+	// some nodes do not have the archLabel one
+	betaArchLabelFallback bool
+	annotations           map[string]string
+	// inspectedDigests are the digests of the images inspected in their registry, by normalized reference
+	inspectedDigests map[string]string
+	// pinToInspectedDigest is true when the references of the images are rewritten to their inspected digests
+	pinToInspectedDigest bool
+	// additionalRequirements are the requirements ANDed with the requirement for the nodes of each architecture, see
+	// the additionalNodeSelectorTerms of the PodPlacementConfig objects
+	additionalRequirements map[string][]corev1.NodeSelectorRequirement
+	// reason is the reason of the decision, e.g., reasons.ArchitectureConstrained, also set on the gated pods
+	reason string
+}
+
+// placementEvaluation is the evaluation of the placement settings and of the images of a pod. It is computed without
+// side effects, so that it is shared by the reconciler and the simulation of the placement.
+type placementEvaluation struct {
+	policy multiarchv1alpha1.PlacementPolicy
+	// skipped is the reason no node affinity is needed for the pod, e.g., skippedOptedOut, or empty
+	skipped string
+	// excluded are the names of the containers excluded from the placement by the policy
+	excluded []string
+	// supported are the architectures supported by the images, or declared by the pod, before the placement settings
+	// restrict them
+	supported []string
+	// inspectionErr is the error of the inspection of the images, if any, when the policy has no default architectures
+	inspectionErr error
+	// defaultedFrom is the error of the inspection of the images when the pod is placed on the default architectures
+	// of the policy instead
+	defaultedFrom error
+	// disallowed is true when the placement settings allow none of the supported architectures
+	disallowed bool
+	decision   placementDecision
+}
+
+const (
+	skippedOptedOut              = reasons.OptedOut
+	skippedTrustedImages         = reasons.TrustedImages
+	skippedAllContainersExcluded = reasons.AllContainersExcluded
+)
+
+// evaluate evaluates the placement settings of the namespace of the pod and the architectures supported by its images.
+// The error is the one of the read of the placement settings or of the owners of the pod: the errors of the inspection
+// are reported in the evaluation, as their handling depends on the failure policy.
+func (r *PodReconciler) evaluate(ctx context.Context, pod *corev1.Pod) (placementEvaluation, error) {
+	policy, err := effectivePlacementPolicy(ctx, r.Client, pod.Namespace)
+	if err != nil {
+		return placementEvaluation{}, err
+	}
+	debugLogPolicy(ctx, policy)
+	evaluation := placementEvaluation{policy: policy}
+	if isOptedOut(policy) {
+		evaluation.skipped = skippedOptedOut
+		return evaluation, nil
+	}
+	optedOut, err := isExcludedFromPlacement(ctx, r.Client, r.Instance, pod)
+	if err != nil {
+		return placementEvaluation{}, err
+	}
+	if optedOut {
+		evaluation.skipped = skippedOptedOut
+		return evaluation, nil
+	}
+	// the images of the excluded containers neither restrict the architectures of the pod nor are inspected
+	override := r.Instance.hasArchitecturesOverride(pod)
+	placed, excluded := withoutExcludedContainers(pod, policy)
+	evaluation.excluded = excluded
+	if len(excluded) > 0 {
+		core.DebugLog(ctx, "The containers %v are excluded from the placement", excluded)
+		if !override && len(r.Instance.podImageReferences(placed)) == 0 {
+			evaluation.skipped = skippedAllContainersExcluded
+			return evaluation, nil
+		}
+	}
+	if !override && usesOnlyTrustedImages(ctx, r.Client, r.Instance, placed) {
+		evaluation.skipped = skippedTrustedImages
+		return evaluation, nil
+	}
+	requirement, digests, err := r.prepareRequirement(ctx, placed)
+	if err != nil {
+		if len(policy.DefaultArchitectures) == 0 {
+			evaluation.inspectionErr = err
+			return evaluation, nil
+		}
+		// the default architectures take precedence over the failure policy
+		requirement = defaultArchitecturesRequirement(policy)
+		evaluation.defaultedFrom = err
+	}
+	evaluation.supported = requirement.Values
+	decision, ok := placeRequirement(ctx, r.Client, r.CapacityCache, r.ExtendedResources, r.Instance, pod, policy,
+		requirement)
+	if ok && evaluation.defaultedFrom != nil {
+		decision.reason = reasons.DefaultedArchitectures
+		decision.annotations[r.Instance.annotation(placementReasonAnnotation)] = decision.reason
+	}
+	if ok && len(digests) > 0 {
+		decision.inspectedDigests = digests
+		decision.annotations[r.Instance.annotation(inspectedDigestsAnnotation)] = formatInspectedDigests(digests)
+	}
+	if ok && len(excluded) > 0 {
+		decision.annotations[r.Instance.annotation(excludedContainersAnnotation)] = strings.Join(excluded, ",")
+	}
+	evaluation.disallowed = !ok
+	evaluation.decision = decision
+	return evaluation, nil
+}
+
+// decide computes the placement decision for the pod according to the placement settings of its namespace: no
+// requirement is set if the pod is opted out, only uses trusted multi-arch images or its images cannot be inspected,
+// the placement settings have no default architectures and the failure policy is Ignore. An error is returned when the
+// pod has to stay gated. The decision has the reason of the evaluation, recorded by the placementReasonAnnotation
+// annotation of the placed pods, also when the pod stays gated.
+// The pods placed at admission only need their scheduling gate removed: their node affinity is not changed, see
+// placementDecisionAnnotation.
+func (r *PodReconciler) decide(ctx context.Context, pod *corev1.Pod) (placementDecision, error) {
+	if r.Instance.hasPlacementDecision(pod) {
+		klog.V(4).Infof("pod %s/%s was placed by the %s. Skipping the inspection", pod.Namespace, pod.Name,
+			pod.Annotations[r.Instance.annotation(placementDecisionAnnotation)])
+		return placementDecision{reason: reasons.PlacedAtAdmission}, nil
+	}
+	evaluation, err := r.evaluate(ctx, pod)
+	if err != nil {
+		klog.Errorf("unable to evaluate the placement of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return placementDecision{}, err
+	}
+	reason := evaluation.reason()
+	// the decision removing the scheduling gate only
+	gateOnly := placementDecision{
+		annotations: map[string]string{r.Instance.annotation(placementReasonAnnotation): reason},
+		reason:      reason,
+	}
+	if len(evaluation.excluded) > 0 {
+		gateOnly.annotations[r.Instance.annotation(excludedContainersAnnotation)] = strings.Join(evaluation.excluded, ",")
+	}
+	switch {
+	case evaluation.skipped == skippedOptedOut:
+		klog.V(4).Infof("pod %s/%s is opted out of the placement", pod.Namespace, pod.Name)
+		core.DebugLog(ctx, "The pod is opted out of the placement: removing the scheduling gate only")
+		return gateOnly, nil
+	case evaluation.skipped == skippedAllContainersExcluded:
+		klog.V(4).Infof("All the containers of pod %s/%s are excluded from the placement", pod.Namespace, pod.Name)
+		core.DebugLog(ctx, "All the containers are excluded from the placement: removing the scheduling gate only")
+		return gateOnly, nil
+	case evaluation.skipped == skippedTrustedImages:
+		// the pod was gated before its images were trusted: no node affinity is needed
+		klog.V(4).Infof("pod %s/%s only uses trusted multi-arch images. Skipping the inspection", pod.Namespace, pod.Name)
+		core.DebugLog(ctx, "The pod only uses trusted multi-arch images: removing the scheduling gate only")
+		return gateOnly, nil
+	case evaluation.inspectionErr != nil:
+		err := evaluation.inspectionErr
+		var transportErr *image.UnsupportedTransportError
+		if errors.As(err, &transportErr) {
+			r.recordWarning(pod, reasons.UnsupportedImageTransport, "%v", err)
+		}
+		if evaluation.policy.FailurePolicy == multiarchv1alpha1.PlacementFailurePolicyFail {
+			r.recordWarning(pod, reason, "The images cannot be inspected and the failure policy is Fail: the pod "+
+				"stays gated. %v", err)
+			return placementDecision{reason: reason}, err
+		}
+		klog.Errorf("unable to get the architecture requirements for pod %s/%s: %v. "+
+			"The nodeAffinity for this pod will not be set.", pod.Namespace, pod.Name, err)
+		core.DebugLog(ctx, "The images cannot be inspected and the failure policy is Ignore: removing the "+
+			"scheduling gate only")
+		// we still need to remove the scheduling gate.
+		return gateOnly, nil
+	case evaluation.disallowed:
+		r.recordWarning(pod, reason, "None of the architectures supported by the images is "+
+			"allowed by the placement settings (%s): the pod stays gated",
+			strings.Join(evaluation.policy.AllowedArchitectures, ","))
+		core.DebugLog(ctx, "None of the architectures %v supported by the images is allowed: the pod stays gated",
+			evaluation.supported)
+		return placementDecision{reason: reason}, fmt.Errorf("none of the architectures supported by the images "+
+			"of pod %s/%s is allowed", pod.Namespace, pod.Name)
+	case evaluation.defaultedFrom != nil:
+		klog.V(3).Infof("The images of pod %s/%s cannot be inspected, placing it on the default architectures %v: %v",
+			pod.Namespace, pod.Name, evaluation.policy.DefaultArchitectures, evaluation.defaultedFrom)
+		core.DebugLog(ctx, "The images cannot be inspected: placing the pod on the default architectures %v. %v",
+			evaluation.supported, evaluation.defaultedFrom)
+	}
+	return evaluation.decision, nil
+}
+
+// defaultArchitecturesRequirement returns the requirement for the default architectures of the policy, normalized
+// and sorted as the architectures supported by the images
+func defaultArchitecturesRequirement(policy multiarchv1alpha1.PlacementPolicy) corev1.NodeSelectorRequirement {
+	architectures := sets.New[string]()
+	for _, architecture := range policy.DefaultArchitectures {
+		// the policies are validated before being used
+		architecture, _ = image.ParseArchitecture(architecture)
+		architectures.Insert(architecture)
+	}
+	return corev1.NodeSelectorRequirement{
+		Key:      archLabel,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   sets.List(architectures),
+	}
+}
+
+// reason returns the reason of the decision of the evaluation, e.g., reasons.NoCommonArchitecture when the placement
+// settings allow none of the supported architectures
+func (e placementEvaluation) reason() string {
+	switch {
+	case e.skipped != "":
+		return e.skipped
+	case e.inspectionErr != nil && e.policy.FailurePolicy == multiarchv1alpha1.PlacementFailurePolicyFail:
+		return inspectionFailedReason(e.inspectionErr)
+	case e.inspectionErr != nil:
+		return reasons.GateRemovedByPolicy
+	case e.disallowed:
+		return reasons.NoCommonArchitecture
+	}
+	return e.decision.reason
+}
+
+// inspectionFailedReason returns the InspectionFailed reason of the failed inspection of the images of a pod, by the
+// kind of the failure or, for the transient ones, by its image.ErrorClass
+func inspectionFailedReason(err error) string {
+	switch inspect.KindOf(err) {
+	case inspect.InvalidReference, inspect.UnsupportedTransport:
+		return reasons.InspectionFailedInvalidReference
+	case inspect.NotFound:
+		return reasons.InspectionFailedNotFound
+	case inspect.Unauthorized:
+		return reasons.InspectionFailedAuth
+	case inspect.TLSPolicy:
+		return reasons.InspectionFailedTLS
+	}
+	switch image.ClassifyError(err) {
+	case image.ErrorClassDNS, image.ErrorClassConnectionRefused:
+		return reasons.InspectionFailedNetwork
+	case image.ErrorClassUnauthorized, image.ErrorClassForbidden, image.ErrorClassTokenRejected:
+		return reasons.InspectionFailedAuth
+	case image.ErrorClassNotFound:
+		return reasons.InspectionFailedNotFound
+	case image.ErrorClassTooManyRequests:
+		return reasons.InspectionFailedRateLimited
+	case image.ErrorClassServerError:
+		return reasons.InspectionFailedRegistryError
+	case image.ErrorClassTLS:
+		return reasons.InspectionFailedTLS
+	case image.ErrorClassInvalidReference:
+		return reasons.InspectionFailedInvalidReference
+	case image.ErrorClassManifestTooLarge:
+		return reasons.InspectionFailedManifestTooLarge
+	}
+	return reasons.InspectionFailed
+}
+
+// debugLogPolicy traces the placement settings applied to the pod of the context
+func debugLogPolicy(ctx context.Context, policy multiarchv1alpha1.PlacementPolicy) {
+	core.DebugLog(ctx, "Placement settings: allowed architectures %v, failure policy %q, default architectures %v, "+
+		"placement mode %q, opted out %t", policy.AllowedArchitectures, policy.FailurePolicy,
+		policy.DefaultArchitectures, policy.PlacementMode, isOptedOut(policy))
+}
+
+// placeRequirement returns the decision setting the requirement for the architectures supported by the images of the
+// pod, restricted to the architectures allowed by the policy, if extendedResources is not nil, to the ones of the
+// nodes providing the extended resources the pod requests and, if capacity is not nil, to the ones that can fit the
+// pod. The annotations of the decision are the ones of the instance. ok is false if the policy allows none of the
+// architectures.
+func placeRequirement(ctx context.Context, c client.Reader, capacity *ArchitectureCapacityCache,
+	extendedResources *ExtendedResourcesIndex, instance *Instance, pod *corev1.Pod,
+	policy multiarchv1alpha1.PlacementPolicy, requirement corev1.NodeSelectorRequirement) (placementDecision, bool) {
+	if requirement.Values = allowedArchitectures(policy, requirement.Values); len(requirement.Values) == 0 {
+		return placementDecision{}, false
+	}
+	if extendedResources != nil {
+		refineRequirementByExtendedResources(ctx, extendedResources, pod, &requirement)
+	}
+	decision := placementDecision{
+		requirement:           &requirement,
+		preferred:             policy.PlacementMode == multiarchv1alpha1.PlacementModePreferred,
+		betaArchLabelFallback: hasBetaOnlyArchNodes(ctx, c),
+		annotations:           map[string]string{},
+		reason:                reasons.ArchitectureConstrained,
+	}
+	decision.annotations[instance.annotation(placementReasonAnnotation)] = decision.reason
+	if capacity != nil {
+		refineRequirementByCapacity(capacity, instance, pod, decision.requirement)
+		core.DebugLog(ctx, "Architectures fitting the requests of the pod: %v", decision.requirement.Values)
+		for _, key := range []string{supportedArchitecturesAnnotation, capacityExcludedArchitecturesAnnotation} {
+			key = instance.annotation(key)
+			if value, ok := pod.Annotations[key]; ok {
+				decision.annotations[key] = value
+			}
+		}
+	}
+	core.DebugLog(ctx, "Placing the pod on the architectures %v: preferred %t, matching the %s label too %t",
+		decision.requirement.Values, decision.preferred, betaArchLabel, decision.betaArchLabelFallback)
+	return decision, true
+}
+
+// apply sets the node affinity and the annotations of the decision, pins the images to their inspected digests if
+// requested, and removes the scheduling gate of the instance. It returns the keys of the additional requirements that
+// were not added because the pod already selects them.
+func (d placementDecision) apply(ctx context.Context, pod *corev1.Pod, instance *Instance) (conflicts []string) {
+	for key, value := range d.annotations {
+		setPodAnnotation(pod, key, value)
+	}
+	if d.pinToInspectedDigest {
+		pinImagesToInspectedDigests(ctx, pod, d.inspectedDigests)
+	}
+	if d.requirement != nil {
+		var additional map[string][]corev1.NodeSelectorRequirement
+		additional, conflicts = withoutSelectedKeys(pod, d.additionalRequirementsOf(*d.requirement))
+		if d.preferred {
+			setPodPreferredNodeAffinity(pod, *d.requirement, additional, d.betaArchLabelFallback)
+		} else {
+			setPodNodeAffinityRequirement(ctx, pod, *d.requirement, additional, d.betaArchLabelFallback)
+		}
+	}
+	klog.V(4).Infof("Removing the scheduling gate from pod %s/%s", pod.Namespace, pod.Name)
+	instance.removeSchedulingGate(pod)
+	return conflicts
+}
+
+// additionalRequirementsOf returns the additional requirements of the architectures of the requirement only: the
+// ones of the other architectures never apply to the pod
+func (d placementDecision) additionalRequirementsOf(
+	requirement corev1.NodeSelectorRequirement) map[string][]corev1.NodeSelectorRequirement {
+	var additional map[string][]corev1.NodeSelectorRequirement
+	for _, architecture := range requirement.Values {
+		if requirements, ok := d.additionalRequirements[architecture]; ok {
+			if additional == nil {
+				additional = map[string][]corev1.NodeSelectorRequirement{}
+			}
+			additional[architecture] = requirements
+		}
+	}
+	return additional
+}
+
+// applyToSiblings applies the decision computed for pod to its gated siblings, updating them concurrently with
+// r.BatchWorkers workers. The siblings that fail to update keep the scheduling gate and are processed on their own.
+func (r *PodReconciler) applyToSiblings(ctx context.Context, pod *corev1.Pod, decision placementDecision) {
+	siblings, err := r.gatedSiblings(ctx, pod)
+	if err != nil {
+		klog.Warningf("Unable to list the siblings of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	if len(siblings) == 0 {
+		return
+	}
+	klog.V(3).Infof("Applying the decision for pod %s/%s to %d sibling pods", pod.Namespace, pod.Name, len(siblings))
+	work := make(chan *corev1.Pod)
+	var wg sync.WaitGroup
+	workers := r.BatchWorkers
+	if workers > len(siblings) {
+		workers = len(siblings)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sibling := range work {
+				original := sibling.DeepCopy()
+				conflicts := decision.apply(ctx, sibling, r.Instance)
+				r.Instance.stampMutatedBy(sibling, r.MutatedBy)
+				r.recordDecisionDocument(sibling, original, decision)
+				if err := r.updatePod(ctx, sibling, original); err != nil {
+					klog.V(3).Infof("unable to update the pod %s/%s, it will be processed on its own: %v",
+						sibling.Namespace, sibling.Name, err)
+					continue
+				}
+				observeDecision(decision.reason)
+				r.recordPlaced(sibling, decision)
+				r.recordAdditionalRequirementsConflicts(sibling, conflicts)
+				r.observePlacement(sibling, decision)
+				r.observeRecentDecision(decision)
+				// the decision is reused: no image of the sibling is inspected
+				r.observeSchedulingLatency(original, schedulingLatencyResult(decision), true)
+			}
+		}()
+	}
+	for i := range siblings {
+		work <- &siblings[i]
+	}
+	close(work)
+	wg.Wait()
+}
+
+// gatedSiblings returns the gated pods of the same controller and pod template revision as pod whose placement
+// decision is computed from the same inputs. It returns no pods if pod has no controller or template revision label.
+func (r *PodReconciler) gatedSiblings(ctx context.Context, pod *corev1.Pod) ([]corev1.Pod, error) {
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil {
+		return nil, nil
+	}
+	for _, label := range podTemplateHashLabels {
+		hash, ok := pod.Labels[label]
+		if !ok {
+			continue
+		}
+		pods := &corev1.PodList{}
+		if err := r.Client.List(ctx, pods, client.InNamespace(pod.Namespace),
+			client.MatchingLabels{label: hash}); err != nil {
+			return nil, err
+		}
+		siblings := make([]corev1.Pod, 0, len(pods.Items))
+		for _, candidate := range pods.Items {
+			candidateController := metav1.GetControllerOf(&candidate)
+			if candidate.Name == pod.Name || candidateController == nil || candidateController.UID != controller.UID ||
+				!r.Instance.hasSchedulingGate(&candidate) || !r.haveSameDecisionInputs(pod, &candidate) {
+				continue
+			}
+			siblings = append(siblings, candidate)
+		}
+		return siblings, nil
+	}
+	return nil, nil
+}
+
+// decisionInputs are the fields of a pod the placement decision depends on. The fields are exported for
+// equality.Semantic to compare them.
+type decisionInputs struct {
+	Images                []string
+	Requests              []corev1.ResourceList
+	ImagePullSecrets      []corev1.LocalObjectReference
+	ArchitecturesOverride *string
+	ExcludedFromPlacement bool
+}
+
+func (r *PodReconciler) podDecisionInputs(pod *corev1.Pod) decisionInputs {
+	inputs := decisionInputs{
+		ImagePullSecrets:      pod.Spec.ImagePullSecrets,
+		ExcludedFromPlacement: r.Instance.isExcludedFromPlacement(pod),
+	}
+	// a fresh slice: appending to the containers of the pod could write to their backing array
+	containers := make([]corev1.Container, 0, len(pod.Spec.Containers)+len(pod.Spec.InitContainers))
+	containers = append(append(containers, pod.Spec.Containers...), pod.Spec.InitContainers...)
+	for _, container := range containers {
+		inputs.Images = append(inputs.Images, container.Image)
+		inputs.Requests = append(inputs.Requests, container.Resources.Requests)
+	}
+	if value, ok := pod.Annotations[r.Instance.annotation(architecturesOverrideAnnotation)]; ok {
+		inputs.ArchitecturesOverride = &value
+	}
+	return inputs
+}
+
+// haveSameDecisionInputs returns true if the placement decision computed for a applies to b too. The pods of the same
+// template can differ in other fields, e.g., the volume mounts injected by the admission plugins.
+func (r *PodReconciler) haveSameDecisionInputs(a, b *corev1.Pod) bool {
+	return equality.Semantic.DeepEqual(r.podDecisionInputs(a), r.podDecisionInputs(b))
+}
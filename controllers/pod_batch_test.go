@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// gatedReplica returns a gated pod of the ReplicaSet with the given UID, declaring its architectures so that the
+// reconciler does not inspect the images
+func gatedReplica(name, replicaSetUID, templateHash string, images ...string) *corev1.Pod {
+	pod := podWithImages(name, images...)
+	pod.Labels = map[string]string{"pod-template-hash": templateHash}
+	pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64,amd64"}
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "app-" + templateHash,
+		UID:        types.UID(replicaSetUID),
+		Controller: &controller,
+	}}
+	pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+	return pod
+}
+
+func reconcilePods(r *PodReconciler, pods ...*corev1.Pod) {
+	for _, pod := range pods {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+	}
+}
+
+func getPod(c client.Client, pod *corev1.Pod) *corev1.Pod {
+	updated := &corev1.Pod{}
+	Expect(c.Get(context.Background(), client.ObjectKeyFromObject(pod), updated)).To(Succeed())
+	return updated
+}
+
+// countingUpdates returns the interceptor functions counting the updates, and the most run concurrently, in updates
+// and maxInFlight. Each update waits for the latency before it is run.
+func countingUpdates(latency time.Duration, updates, maxInFlight *atomic.Int32) interceptor.Funcs {
+	var inFlight atomic.Int32
+	return interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			updates.Add(1)
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for previous := maxInFlight.Load(); current > previous; previous = maxInFlight.Load() {
+				if maxInFlight.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(latency)
+			return c.Update(ctx, obj, opts...)
+		},
+	}
+}
+
+var _ = Describe("The batched pod updates", func() {
+	var updates, maxInFlight atomic.Int32
+
+	newReconciler := func(batchWorkers int, updateLatency time.Duration, pods ...*corev1.Pod) *PodReconciler {
+		builder := fake.NewClientBuilder().WithScheme(newTestScheme()).WithInterceptorFuncs(
+			countingUpdates(updateLatency, &updates, &maxInFlight))
+		for _, pod := range pods {
+			builder = builder.WithObjects(pod)
+		}
+		// any inspection would panic
+		return &PodReconciler{Client: builder.Build(), BatchWorkers: batchWorkers, Inspector: noInspections{}}
+	}
+
+	BeforeEach(func() {
+		updates.Store(0)
+		maxInFlight.Store(0)
+	})
+
+	It("should apply the decision to the gated pods of the same template", func() {
+		first := gatedReplica("app-1", "rs-uid", "abc", "quay.io/org/app:v1")
+		sibling := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+		r := newReconciler(4, 0, first, sibling)
+		reconcilePods(r, first)
+		Expect(updates.Load()).To(BeEquivalentTo(2))
+		for _, pod := range []*corev1.Pod{first, sibling} {
+			updated := getPod(r.Client, pod)
+			Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+			Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].
+				MatchExpressions).To(ConsistOf(corev1.NodeSelectorRequirement{
+				Key:      archLabel,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"amd64", "arm64"},
+			}))
+		}
+		// the sibling is not gated anymore: its own reconciliation is a no-op
+		reconcilePods(r, sibling)
+		Expect(updates.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should consider the requests equal by their value", func() {
+		first := gatedReplica("app-1", "rs-uid", "abc", "quay.io/org/app:v1")
+		first.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+		sibling := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+		sibling.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1000m")}
+		Expect((&PodReconciler{}).haveSameDecisionInputs(first, sibling)).To(BeTrue())
+	})
+
+	It("should not write the init containers to the spare capacity of the containers of the pods", func() {
+		pod := gatedReplica("app-1", "rs-uid", "abc", "quay.io/org/app:v1")
+		containers := make([]corev1.Container, 1, 2)
+		containers[0] = pod.Spec.Containers[0]
+		pod.Spec.Containers = containers
+		pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "quay.io/org/init:v1"}}
+		Expect((&PodReconciler{}).podDecisionInputs(pod).Images).To(Equal([]string{"quay.io/org/app:v1",
+			"quay.io/org/init:v1"}))
+		Expect(containers[:2][1]).To(Equal(corev1.Container{}))
+	})
+
+	DescribeTable("should leave the pods that do not share the decision inputs to their own reconciliation",
+		func(other *corev1.Pod) {
+			first := gatedReplica("app-1", "rs-uid", "abc", "quay.io/org/app:v1")
+			r := newReconciler(4, 0, first, other)
+			reconcilePods(r, first)
+			Expect(getPod(r.Client, other).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		},
+		Entry("different controller", gatedReplica("other-1", "other-rs-uid", "abc", "quay.io/org/app:v1")),
+		Entry("different template", gatedReplica("app-2", "rs-uid", "def", "quay.io/org/app:v1")),
+		Entry("different images", gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v2")),
+		Entry("different pull secrets", func() *corev1.Pod {
+			pod := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+			pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "other"}}
+			return pod
+		}()),
+		Entry("different architectures override", func() *corev1.Pod {
+			pod := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+			pod.Annotations[architecturesOverrideAnnotation] = "s390x"
+			return pod
+		}()),
+		Entry("excluded from the placement", func() *corev1.Pod {
+			pod := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+			pod.Annotations[excludePodPlacementAnnotation] = "true"
+			return pod
+		}()),
+		Entry("different requests", func() *corev1.Pod {
+			pod := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+			return pod
+		}()),
+	)
+
+	It("should not batch the pods when it is disabled", func() {
+		first := gatedReplica("app-1", "rs-uid", "abc", "quay.io/org/app:v1")
+		sibling := gatedReplica("app-2", "rs-uid", "abc", "quay.io/org/app:v1")
+		r := newReconciler(0, 0, first, sibling)
+		reconcilePods(r, first)
+		Expect(getPod(r.Client, sibling).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+	})
+
+	It("should inspect the images once and bound the concurrent updates of the pods of a ReplicaSet", func() {
+		const replicas, batchWorkers = 100, 10
+		pods := make([]*corev1.Pod, 0, replicas)
+		for i := 0; i < replicas; i++ {
+			pod := gatedReplica(fmt.Sprintf("app-%d", i), "rs-uid", "abc", "quay.io/org/app:v1")
+			delete(pod.Annotations, architecturesOverrideAnnotation)
+			pods = append(pods, pod)
+		}
+		inspector := &fakeArchitectures{registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}}}
+		r := newReconciler(batchWorkers, time.Millisecond, pods...)
+		r.Inspector = inspector
+		reconcilePods(r, pods...)
+		Expect(inspector.inspections).To(Equal(1))
+		Expect(updates.Load()).To(BeEquivalentTo(replicas))
+		Expect(maxInFlight.Load()).To(BeNumerically("<=", batchWorkers))
+		for _, pod := range pods {
+			Expect(getPod(r.Client, pod).Spec.SchedulingGates).To(BeEmpty())
+		}
+	})
+})
+
+// BenchmarkBatchedPodUpdates places the 300 gated pods of a ReplicaSet, each update taking 2ms, with and without the
+// batched updates
+func BenchmarkBatchedPodUpdates(b *testing.B) {
+	const replicas = 300
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{corev1.AddToScheme, appsv1.AddToScheme,
+		multiarchv1alpha1.AddToScheme} {
+		if err := addToScheme(scheme); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, batchWorkers := range []int{0, 10} {
+		b.Run(fmt.Sprintf("batch workers: %d", batchWorkers), func(b *testing.B) {
+			var updates, maxInFlight atomic.Int32
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				builder := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(
+					countingUpdates(2*time.Millisecond, &updates, &maxInFlight))
+				pods := make([]*corev1.Pod, 0, replicas)
+				for i := 0; i < replicas; i++ {
+					pod := gatedReplica(fmt.Sprintf("app-%d", i), "rs-uid", "abc", "quay.io/org/app:v1")
+					builder = builder.WithObjects(pod)
+					pods = append(pods, pod)
+				}
+				r := &PodReconciler{Client: builder.Build(), BatchWorkers: batchWorkers, Inspector: noInspections{}}
+				b.StartTimer()
+				for _, pod := range pods {
+					if _, err := r.Reconcile(context.Background(),
+						ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var (
+	// bookkeepingAnnotations are the annotations the operator sets on the pods to track its own mutations
+	bookkeepingAnnotations = []string{placementDecisionAnnotation, mutatedByAnnotation}
+	// decisionAnnotations are the annotations reporting the inputs of the placement of the pods, e.g., to auditors
+	decisionAnnotations = []string{supportedArchitecturesAnnotation, capacityExcludedArchitecturesAnnotation,
+		inspectedDigestsAnnotation, imageVolumesAnnotation, placementReasonAnnotation, excludedContainersAnnotation,
+		decisionAnnotation}
+)
+
+// PodMetadataCleanupReconciler removes the annotations the operator set on the pods once they are running, when a
+// PodPlacementConfig sets cleanupAnnotationsAfterScheduling. The decision annotations are kept while any
+// PodPlacementConfig sets keepDecisionAnnotations. The annotations set by the users, e.g.,
+// architecturesOverrideAnnotation, are never removed.
+// It never races with the PodReconciler: the running pods are not gated, and the annotations are removed by a single
+// patch failing on conflicts, so that the changes made in the meantime are never overwritten.
+type PodMetadataCleanupReconciler struct {
+	client.Client
+	// Instance is optional. When set, only its annotations are removed.
+	Instance *Instance
+}
+
+// Reconcile removes the annotations of the operator from the running pod, if requested by the PodPlacementConfigs
+func (r *PodMetadataCleanupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !r.isRunningWithOperatorAnnotations(pod) {
+		return ctrl.Result{}, nil
+	}
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	keys := r.cleanedUpAnnotations(podPlacementConfigs)
+	if len(keys) == 0 {
+		return ctrl.Result{}, nil
+	}
+	patch := client.MergeFromWithOptions(pod.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	removed := 0
+	for _, key := range keys {
+		if _, ok := pod.Annotations[key]; ok {
+			delete(pod.Annotations, key)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		if apierrors.IsConflict(err) {
+			// the pod changed in the meantime: the cleanup is retried on its latest version
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	klog.V(4).Infof("Removed %d annotations of the operator from the running pod %s/%s", removed, pod.Namespace,
+		pod.Name)
+	return ctrl.Result{}, nil
+}
+
+// cleanedUpAnnotations returns the keys of the annotations of the instance to remove from the running pods according
+// to the PodPlacementConfigs, or none if no PodPlacementConfig sets cleanupAnnotationsAfterScheduling
+func (r *PodMetadataCleanupReconciler) cleanedUpAnnotations(
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) []string {
+	cleanup, keepDecision := false, false
+	for _, ppc := range podPlacementConfigs {
+		cleanup = cleanup || ppc.Spec.CleanupAnnotationsAfterScheduling
+		keepDecision = keepDecision || ppc.Spec.KeepDecisionAnnotations
+	}
+	if !cleanup {
+		return nil
+	}
+	keys := make([]string, 0, len(bookkeepingAnnotations)+len(decisionAnnotations))
+	for _, key := range bookkeepingAnnotations {
+		keys = append(keys, r.Instance.annotation(key))
+	}
+	if !keepDecision {
+		for _, key := range decisionAnnotations {
+			keys = append(keys, r.Instance.annotation(key))
+		}
+	}
+	return keys
+}
+
+// isRunningWithOperatorAnnotations returns true if the pod is running and carries any annotation the operator sets
+func (r *PodMetadataCleanupReconciler) isRunningWithOperatorAnnotations(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil || r.Instance.hasSchedulingGate(pod) {
+		return false
+	}
+	for _, keys := range [][]string{bookkeepingAnnotations, decisionAnnotations} {
+		for _, key := range keys {
+			if _, ok := pod.Annotations[r.Instance.annotation(key)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the running pods carrying the annotations of the
+// operator are processed.
+func (r *PodMetadataCleanupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isCandidate := func(obj client.Object) bool {
+		pod, ok := obj.(*corev1.Pod)
+		return ok && r.isRunningWithOperatorAnnotations(pod)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("pod-metadata-cleanup").
+		For(&corev1.Pod{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc:  func(e event.CreateEvent) bool { return isCandidate(e.Object) },
+			UpdateFunc:  func(e event.UpdateEvent) bool { return isCandidate(e.ObjectNew) },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		})).
+		Complete(r)
+}
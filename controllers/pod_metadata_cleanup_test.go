@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("The PodMetadataCleanupReconciler", func() {
+	var (
+		ctx     context.Context
+		pod     *corev1.Pod
+		configs []client.Object
+		funcs   interceptor.Funcs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		pod = podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{
+			placementDecisionAnnotation:             placedByWebhook,
+			mutatedByAnnotation:                     "v1.2.3",
+			supportedArchitecturesAnnotation:        "amd64,arm64",
+			capacityExcludedArchitecturesAnnotation: "arm64",
+			inspectedDigestsAnnotation:              "quay.io/org/app:v1@sha256:0123",
+			architecturesOverrideAnnotation:         "amd64,arm64",
+			"example.com/owner":                     "team",
+		}
+		pod.Spec.NodeName = "amd64-node"
+		pod.Status.Phase = corev1.PodRunning
+		configs = nil
+		funcs = interceptor.Funcs{}
+	})
+
+	cleaningUp := func(keepDecision bool) {
+		configs = append(configs, &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+				CleanupAnnotationsAfterScheduling: true,
+				KeepDecisionAnnotations:           keepDecision,
+			},
+		})
+	}
+
+	reconcile := func() (client.Client, ctrl.Result) {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithInterceptorFuncs(funcs).
+			WithObjects(append(configs, pod)...).Build()
+		r := &PodMetadataCleanupReconciler{Client: c}
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		return c, result
+	}
+
+	It("should keep all the annotations by default", func() {
+		c, _ := reconcile()
+		Expect(getPod(c, pod).Annotations).To(Equal(pod.Annotations))
+	})
+
+	It("should remove the annotations of the operator from the running pods when requested", func() {
+		cleaningUp(false)
+		c, _ := reconcile()
+		Expect(getPod(c, pod).Annotations).To(Equal(map[string]string{
+			architecturesOverrideAnnotation: "amd64,arm64",
+			"example.com/owner":             "team",
+		}))
+	})
+
+	It("should keep the decision annotations when requested", func() {
+		cleaningUp(true)
+		c, _ := reconcile()
+		Expect(getPod(c, pod).Annotations).To(Equal(map[string]string{
+			supportedArchitecturesAnnotation:        "amd64,arm64",
+			capacityExcludedArchitecturesAnnotation: "arm64",
+			inspectedDigestsAnnotation:              "quay.io/org/app:v1@sha256:0123",
+			architecturesOverrideAnnotation:         "amd64,arm64",
+			"example.com/owner":                     "team",
+		}))
+	})
+
+	It("should not clean up the pods that are not running yet", func() {
+		cleaningUp(false)
+		pod.Spec.NodeName = ""
+		pod.Status.Phase = corev1.PodPending
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c, _ := reconcile()
+		Expect(getPod(c, pod).Annotations).To(Equal(pod.Annotations))
+	})
+
+	It("should only remove the annotations of its own instance", func() {
+		cleaningUp(false)
+		staging := &Instance{SchedulingGateName: "staging.multi-arch.openshift.io/scheduling-gate",
+			AnnotationPrefix: "staging.multiarch.openshift.io"}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(append(configs, pod)...).Build()
+		r := &PodMetadataCleanupReconciler{Client: c, Instance: staging}
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPod(c, pod).Annotations).To(Equal(pod.Annotations))
+	})
+
+	It("should never overwrite the annotations set while cleaning up", func() {
+		cleaningUp(false)
+		written := false
+		funcs.Patch = func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+			opts ...client.PatchOption) error {
+			if !written {
+				// another writer updates the pod between the read and the patch of the cleanup
+				written = true
+				latest := &corev1.Pod{}
+				Expect(c.Get(ctx, client.ObjectKeyFromObject(obj), latest)).To(Succeed())
+				latest.Annotations[inspectedDigestsAnnotation] = "quay.io/org/app:v1@sha256:4567"
+				Expect(c.Update(ctx, latest)).To(Succeed())
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		}
+		c, result := reconcile()
+		Expect(result.Requeue).To(BeTrue())
+		Expect(getPod(c, pod).Annotations).To(HaveKeyWithValue(inspectedDigestsAnnotation,
+			"quay.io/org/app:v1@sha256:4567"))
+		Expect(getPod(c, pod).Annotations).To(HaveKey(placementDecisionAnnotation))
+
+		// the cleanup is retried on the latest version of the pod
+		r := &PodMetadataCleanupReconciler{Client: c}
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeFalse())
+		Expect(getPod(c, pod).Annotations).NotTo(HaveKey(inspectedDigestsAnnotation))
+		Expect(getPod(c, pod).Annotations).NotTo(HaveKey(placementDecisionAnnotation))
+	})
+})
@@ -20,27 +20,121 @@ import (
 	"context"
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
 	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
+	"multiarch-operator/pkg/version"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+	"strings"
+	"time"
 )
 
+// podUpdateTimeout bounds the update of a pod once its placement is decided. The update is not interrupted by the
+// shutdown of the manager, that waits for the in-flight reconciles up to its graceful shutdown timeout.
+const podUpdateTimeout = 10 * time.Second
+
+// FieldManager is the field manager of the writes of the operator. The managedFields of the pods record the node
+// affinity set at their placement as owned by it, apart from the fields of the managers creating and applying the pods.
+//
+// The pods are placed with an update rather than a server-side apply: the scheduling gate is owned by the manager
+// creating the pod, as the webhook adds it at admission, and an applied configuration cannot remove the fields of the
+// other managers, while the node affinity and the removal of the gate must be written in the same request.
+// The update makes FieldManager the owner of spec.affinity.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution.
+// Its nodeSelectorTerms list is atomic, so it cannot be co-owned entry by entry: the terms are owned as a whole by
+// FieldManager, which merges its requirement into the terms declared by the pod's creator (see
+// setPodNodeAffinityRequirement). A GitOps controller applying a configuration without the node affinity, e.g., Argo CD
+// with server-side apply, keeps FieldManager's terms, as it only removes the fields it owned. One applying its own
+// terms conflicts with FieldManager unless it forces the ownership, and the node affinity of the scheduled pods is
+// immutable anyway.
+const FieldManager = "multiarch-operator"
+
+// preferredArchitecturesWeight is the weight of the preferred node affinity term set in the Preferred placement mode
+const preferredArchitecturesWeight = 100
+
 // PodReconciler reconciles a Pod object
 type PodReconciler struct {
 	client.Client
-	Scheme    *runtime.Scheme
+	Scheme *runtime.Scheme
+	// Clientset is optional. It reads the pull secrets of the pods, without caching them, when PullSecrets is not set.
 	Clientset *kubernetes.Clientset
+	// PullSecrets is optional. When set, the pull secrets of the pods are read through it, caching them, instead of
+	// the Clientset.
+	PullSecrets *PullSecretsCache
+	// CapacityCache is optional. When set, the architectures that cannot currently fit the pod's requests are
+	// dropped from the node affinity requirement, as long as at least one architecture remains.
+	CapacityCache *ArchitectureCapacityCache
+	// ExtendedResources is optional. When set, the architectures of the nodes not providing the mapped extended
+	// resources the pod requests are dropped from the node affinity requirement, as long as at least one architecture
+	// remains.
+	ExtendedResources *ExtendedResourcesIndex
+	// ImageStreamResolver is optional. When set, the architectures of the images pulled from the OpenShift internal
+	// registry are read from their ImageStreamTag or ImageStreamImage objects, falling back to the registry
+	// inspection on any error.
+	ImageStreamResolver *image.ImageStreamResolver
+	// Inspector returns the platforms supported by the images. It defaults to inspect.Singleton().
+	Inspector inspect.Inspector
+	// BatchWorkers is the number of workers applying the decision computed for a gated pod to the other gated pods of the
+	// same controller and pod template. When it is zero, every pod is processed on its own.
+	BatchWorkers int
+	// Recorder is optional. When set, it reports the invalid values of the architecturesOverrideAnnotation annotation
+	// and the pods kept gated by their placement settings through Warning events on the pods, and the placed pods
+	// through Normal events.
+	Recorder record.EventRecorder
+	// DebugLogging is optional. When set, the decisions for the pods of the namespaces with the debugAnnotation
+	// annotation are traced, including the inspection of their images.
+	DebugLogging *core.DebugLogging
+	// GatedPodsSweeper is optional. When set, the gated pods it sweeps are requeued ahead of their backoff.
+	GatedPodsSweeper *GatedPodsSweeper
+	// NodeArchitectures is optional. When set, the gated pods it re-evaluates when the architectures of the cluster
+	// change are requeued ahead of their backoff.
+	NodeArchitectures *NodeArchitecturesReconciler
+	// MutatedBy is optional. When set, it is the value of the mutatedByAnnotation annotation of the pods the reconciler
+	// places, e.g., the version of the operator.
+	MutatedBy string
+	// OmitLegacyAnnotations is true when the decisions are only recorded by the decisionAnnotation annotation, without
+	// the legacyDecisionAnnotations.
+	OmitLegacyAnnotations bool
+	// Instance is optional. When set, only the pods with its scheduling gate are placed, and its annotations are read
+	// and set instead of the default ones.
+	Instance *Instance
+	// Backoff is optional. When set, it is the rate limiter of the workqueue, backing off the pods whose placement failed
+	// permanently longer than the ones whose placement can succeed on a retry.
+	Backoff *PlacementBackoff
+	// OwnerAnnotator is optional. When set, the placement of the pods is propagated to their top-level owner.
+	OwnerAnnotator *OwnerAnnotator
+	// CustomResourcesMissing is true when the CustomResourceDefinitions of the operator are not installed: the
+	// PodPlacementConfig and PodPlacementPolicy objects are not watched and the default placement settings apply.
+	CustomResourcesMissing bool
+	// DecisionCache is optional. When set, the decisions computed for the gated pods are reused for the next pods of
+	// the same controller and pod template revision.
+	DecisionCache *DecisionCache
+	// LatencyWarningThreshold is optional. When positive, the pods whose scheduling gate is removed more than this
+	// time after they were gated are logged with a warning.
+	LatencyWarningThreshold time.Duration
+	// RecentDecisions is optional. When set, the architectures required by the pods placed are aggregated over its
+	// window.
+	RecentDecisions *RecentDecisions
+	// clock is the clock measuring the scheduling gate latency and timestamping the decision documents. It defaults to
+	// the real clock, see passiveClock.
+	clock clock.PassiveClock
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;update;patch
-//+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
-//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -50,7 +144,7 @@ type PodReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
-// Reconcile has to watch the pod object if it has the scheduling gate with name schedulingGateName,
+// Reconcile has to watch the pod object if it has the scheduling gate of the Instance,
 // inspect the images in the pod spec, update the nodeAffinity accordingly and remove the scheduling gate.
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
@@ -64,57 +158,262 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	// verify whether the pod is in the proper phase to add a schedulingGate
 
 	// verify whether the pod has the scheduling gate
-	if !hasSchedulingGate(pod) {
+	if !r.Instance.hasSchedulingGate(pod) {
 		klog.V(4).Infof("pod %s/%s does not have the scheduling gate. Ignoring...", pod.Namespace, pod.Name)
 		// if not, return
 		return ctrl.Result{}, nil
 	}
 
 	klog.V(4).Infof("Processing pod %s/%s", pod.Namespace, pod.Name)
+	ctx = debugContext(ctx, r.Client, r.DebugLogging, r.Instance, pod)
+	core.DebugLog(ctx, "Processing the gated pod: images %v, annotations %v", sets.List(podImageNames(r.Instance, pod)),
+		pod.Annotations)
 	// The scheduling gate is found.
-	var err error
-
-	// Prepare the requirement for the node affinity.
-	architectureRequirement, err := prepareRequirement(ctx, r.Clientset, pod)
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, r.Client)
 	if err != nil {
-		klog.Errorf("unable to get the architecture requirements for pod %s/%s: %v. "+
-			"The nodeAffinity for this pod will not be set.", pod.Namespace, pod.Name, err)
-		// we still need to remove the scheduling gate. Therefore, we do not return here.
-	} else {
-		// Update the node affinity
-		setPodNodeAffinityRequirement(ctx, pod, architectureRequirement)
+		klog.Errorf("unable to list the PodPlacementConfig objects: %v", err)
+		return ctrl.Result{}, err
 	}
-
-	// Remove the scheduling gate
-	klog.V(4).Infof("Removing the scheduling gate from pod %s/%s", pod.Namespace, pod.Name)
-	removeSchedulingGate(pod)
-
-	err = r.Client.Update(ctx, pod)
-	if err != nil {
+	if pausedBy := placementPausedBy(podPlacementConfigs); pausedBy != "" {
+		return ctrl.Result{}, r.releasePaused(ctx, pod, pausedBy)
+	}
+	// the cache is read before the decision, which caches the inspected images
+	cacheHit := r.imagesCached(pod)
+	decision, reused, decideErr := r.decideCached(ctx, pod, podPlacementConfigs)
+	cacheHit = cacheHit || reused
+	if err := ctx.Err(); err != nil {
+		// The manager is shutting down: the decision might come from an inspection interrupted by the cancellation and
+		// lack the node affinity. The pod keeps the scheduling gate and is processed after the restart.
+		klog.Warningf("Not updating pod %s/%s, the reconcile has been cancelled: %v", pod.Namespace, pod.Name, err)
+		return ctrl.Result{}, err
+	}
+	observeDecision(decision.reason)
+	if decideErr != nil {
+		// The pod stays gated and its placement is retried with the backoff of the class of the failure
+		r.Backoff.observe(req, decideErr)
+		return ctrl.Result{}, decideErr
+	}
+	decision.pinToInspectedDigest = pinsToInspectedDigest(podPlacementConfigs)
+	decision.additionalRequirements = additionalNodeSelectorRequirements(podPlacementConfigs)
+	// the siblings are matched against the pod as gated, before its images are pinned to their digests
+	gated := pod.DeepCopy()
+	// Update the node affinity and remove the scheduling gate. They are written in the same request, so that the pod
+	// cannot be scheduled without the node affinity, nor with images other than the inspected ones when they are
+	// pinned.
+	conflicts := decision.apply(ctx, pod, r.Instance)
+	r.Instance.stampMutatedBy(pod, r.MutatedBy)
+	r.recordDecisionDocument(pod, gated, decision)
+	updateCtx, cancel := context.WithTimeout(context.Background(), podUpdateTimeout)
+	defer cancel()
+	if err := r.updatePod(updateCtx, pod, gated); err != nil {
 		klog.Errorf("unable to update the pod %s/%s: %v", pod.Namespace, pod.Name, err)
 		return ctrl.Result{}, err
 	}
+	r.recordPlaced(pod, decision)
+	r.recordAdditionalRequirementsConflicts(pod, conflicts)
+	r.observePlacement(pod, decision)
+	r.observeRecentDecision(decision)
+	r.observeSchedulingLatency(gated, schedulingLatencyResult(decision), cacheHit)
 
+	// the decision for the pods placed at admission only removes the gate: it does not apply to the siblings
+	if r.BatchWorkers > 0 && !r.Instance.hasPlacementDecision(gated) {
+		r.applyToSiblings(ctx, gated, decision)
+	}
 	return ctrl.Result{}, nil
 }
 
-func prepareRequirement(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) (corev1.NodeSelectorRequirement, error) {
-	values, err := inspectImages(ctx, clientset, pod)
-	// if an error occurs, we return an empty NodeSelectorRequirement and the error.
+// releasePaused removes the scheduling gate of the pod, gated before the pod placement was paused, without changing
+// its node affinity nor its annotations, but for the gatedAtAnnotation one removed with the gate
+func (r *PodReconciler) releasePaused(ctx context.Context, pod *corev1.Pod, pausedBy string) error {
+	klog.V(3).Infof("Removing the scheduling gate from pod %s/%s without placing it: the pod placement is paused by "+
+		"the PodPlacementConfig %s", pod.Namespace, pod.Name, pausedBy)
+	core.DebugLog(ctx, "The pod placement is paused by the PodPlacementConfig %s: removing the scheduling gate only",
+		pausedBy)
+	original := pod.DeepCopy()
+	r.Instance.removeSchedulingGate(pod)
+	updateCtx, cancel := context.WithTimeout(context.Background(), podUpdateTimeout)
+	defer cancel()
+	if err := r.updatePod(updateCtx, pod, original); err != nil {
+		klog.Errorf("unable to update the pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return err
+	}
+	observeDecision(reasons.PlacementPaused)
+	r.observeSchedulingLatency(original, schedulingLatencyPaused, false)
+	return nil
+}
+
+// recordDecisionDocument records the decision applied to the pod, read as original, by the decisionAnnotation
+// annotation, see Instance.recordDecision
+func (r *PodReconciler) recordDecisionDocument(pod, original *corev1.Pod, decision placementDecision) {
+	if document, ok := decision.document(r.Instance, r.passiveClock().Now(), r.MutatedBy); ok {
+		r.Instance.recordDecision(pod, original, document, r.OmitLegacyAnnotations)
+	}
+}
+
+// updatePod writes the changes made to the pod since it was read as original. The pods with image volumes are patched
+// instead of updated: an update would drop their image volume sources, missing from the API types of the operator, and
+// be rejected by the API server. The patch fails on conflicts, as the updates do. The pods whose legacy scheduling
+// gate is removed are counted as adopted. The annotations of the placement are left out of the update when the pod
+// would exceed the size limits of the API server with them, see trimToSizeLimits: the pod is never kept gated for
+// them.
+func (r *PodReconciler) updatePod(ctx context.Context, pod, original *corev1.Pod) error {
+	trimmed := r.trimToSizeLimits(pod, original)
+	var err error
+	if len(r.Instance.imageVolumes(original)) == 0 {
+		err = r.Client.Update(ctx, pod, client.FieldOwner(FieldManager))
+	} else {
+		err = r.Client.Patch(ctx, pod, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}),
+			client.FieldOwner(FieldManager))
+	}
 	if err != nil {
-		return corev1.NodeSelectorRequirement{}, err
+		return err
+	}
+	r.recordTrimmedAnnotations(pod, trimmed)
+	if legacyGate := r.Instance.legacySchedulingGate(original); legacyGate != "" &&
+		r.Instance.legacySchedulingGate(pod) == "" {
+		klog.V(2).Infof("Adopted pod %s/%s gated with the legacy scheduling gate %s", pod.Namespace, pod.Name,
+			legacyGate)
+		legacyGatedPodsAdopted.WithLabelValues(legacyGate).Inc()
+	}
+	return nil
+}
+
+// prepareRequirement returns the requirement for the architectures declared by the architecturesOverrideAnnotation
+// annotation, if valid, or for the architectures supported by the pod's images otherwise. The digests are the ones of
+// the images inspected in their registry, by normalized reference.
+func (r *PodReconciler) prepareRequirement(ctx context.Context,
+	pod *corev1.Pod) (requirement corev1.NodeSelectorRequirement, digests map[string]string, err error) {
+	values, ok := r.architecturesOverride(pod)
+	if !ok {
+		values, digests, err = inspectImages(ctx, r.pullSecrets(), r.ImageStreamResolver, r.inspector(), r.Instance,
+			pod)
+		// if an error occurs, we return an empty NodeSelectorRequirement and the error.
+		if err != nil {
+			return corev1.NodeSelectorRequirement{}, nil, err
+		}
 	}
 	return corev1.NodeSelectorRequirement{
-		Key:      "kubernetes.io/arch",
+		Key:      archLabel,
 		Operator: corev1.NodeSelectorOpIn,
 		Values:   values,
-	}, nil
+	}, digests, nil
+}
+
+// architecturesOverride returns the sorted architectures declared by the architecturesOverrideAnnotation annotation.
+// ok is false if the annotation is not set or its value is invalid: invalid values are reported through a Warning
+// event and ignored.
+func (r *PodReconciler) architecturesOverride(pod *corev1.Pod) (architectures []string, ok bool) {
+	annotation := r.Instance.annotation(architecturesOverrideAnnotation)
+	value, found := pod.Annotations[annotation]
+	if !found {
+		return nil, false
+	}
+	architectures, err := parseArchitectures(value)
+	if err != nil {
+		klog.Warningf("Ignoring the %s annotation of pod %s/%s: %v", annotation, pod.Namespace, pod.Name, err)
+		r.recordWarning(pod, reasons.InvalidArchitecturesOverride,
+			"Ignoring the %s annotation, the images will be inspected: %v", annotation, err)
+		return nil, false
+	}
+	klog.V(4).Infof("Using the architectures %v declared by pod %s/%s", architectures, pod.Namespace, pod.Name)
+	return architectures, true
+}
+
+// observeDecision counts the placement decision by its reason. The decisions without a reason, e.g., when the
+// placement settings cannot be read, are not counted.
+func observeDecision(reason string) {
+	if reason != "" {
+		placementDecisions.WithLabelValues(reason).Inc()
+	}
+}
+
+// recordWarning reports a Warning event on the pod, if the Recorder is set
+func (r *PodReconciler) recordWarning(pod *corev1.Pod, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Event(pod, corev1.EventTypeWarning, reason, versionedEventMessage(fmt.Sprintf(messageFmt, args...)))
+	}
+}
+
+// recordPlaced reports a Normal event on the pod with the architectures the decision placed it on, the digests of
+// the inspected images and its image volumes, if the Recorder is set and the decision sets a node affinity
+func (r *PodReconciler) recordPlaced(pod *corev1.Pod, decision placementDecision) {
+	if r.Recorder == nil || decision.requirement == nil {
+		return
+	}
+	message := fmt.Sprintf("Placed on the architectures %s", strings.Join(decision.requirement.Values, ","))
+	if decision.reason == reasons.DefaultedArchitectures {
+		message = fmt.Sprintf("Placed on the default architectures %s of the placement settings: the images "+
+			"cannot be inspected", strings.Join(decision.requirement.Values, ","))
+	}
+	if len(decision.inspectedDigests) > 0 {
+		message += fmt.Sprintf(", supported by the inspected images %s",
+			formatInspectedDigests(decision.inspectedDigests))
+	}
+	if volumes := r.Instance.imageVolumes(pod); len(volumes) > 0 {
+		message += fmt.Sprintf(", with the images of the image volumes %s", formatImageVolumes(volumes))
+	}
+	if decision.pinToInspectedDigest {
+		message += ": the images are pinned to the inspected digests"
+	}
+	r.Recorder.Event(pod, corev1.EventTypeNormal, decision.reason, versionedEventMessage(message))
+}
+
+// recordAdditionalRequirementsConflicts reports a Warning event on the pod with the keys of the additional node
+// selector requirements that were not added to its node affinity, as the pod already selects them
+func (r *PodReconciler) recordAdditionalRequirementsConflicts(pod *corev1.Pod, conflicts []string) {
+	if len(conflicts) == 0 {
+		return
+	}
+	klog.V(3).Infof("Not adding the additional node selector requirements on the keys %v to pod %s/%s: the pod "+
+		"already selects them", conflicts, pod.Namespace, pod.Name)
+	r.recordWarning(pod, reasons.AdditionalNodeSelectorTermsConflict, "The additional node selector terms on the "+
+		"keys %s are not added to the node affinity: the pod already selects them", strings.Join(conflicts, ","))
+}
+
+// versionedEventMessage appends the version of the operator to the message of the events reporting its decisions,
+// e.g., to tell the replicas apart during the upgrades
+func versionedEventMessage(message string) string {
+	return fmt.Sprintf("%s (multiarch-operator %s)", message, version.Version)
+}
+
+// parseArchitectures parses a comma-separated list of architecture names, e.g., "arm64, amd64". The aliases of the
+// architectures are normalized, e.g., "aarch64, x86_64" is parsed as arm64 and amd64.
+func parseArchitectures(value string) ([]string, error) {
+	architectures := sets.New[string]()
+	for _, architecture := range strings.Split(value, ",") {
+		architecture, err := image.ParseArchitecture(strings.TrimSpace(architecture))
+		if err != nil {
+			return nil, err
+		}
+		architectures.Insert(architecture)
+	}
+	return sets.List(architectures), nil
+}
+
+// refineRequirementByCapacity drops from the requirement the architectures whose allocatable headroom cannot fit the
+// pod's requests. The architectures supported by the pod's images are reported in the supportedArchitecturesAnnotation
+// annotation and the excluded ones in the capacityExcludedArchitecturesAnnotation annotation, of the instance.
+func refineRequirementByCapacity(capacity *ArchitectureCapacityCache, instance *Instance, pod *corev1.Pod,
+	requirement *corev1.NodeSelectorRequirement) {
+	setPodAnnotation(pod, instance.annotation(supportedArchitecturesAnnotation), strings.Join(requirement.Values, ","))
+	fitting, excluded := capacity.filterArchitectures(pod, requirement.Values)
+	if len(excluded) == 0 {
+		return
+	}
+	klog.V(3).Infof("Excluding the architectures %v for pod %s/%s: not enough allocatable capacity",
+		excluded, pod.Namespace, pod.Name)
+	requirement.Values = fitting
+	setPodAnnotation(pod, instance.annotation(capacityExcludedArchitecturesAnnotation), strings.Join(excluded, ","))
 }
 
 // setPodNodeAffinityRequirement sets the node affinity for the pod to the given requirement based on the rules in
 // the sig-scheduling's KEP-3838: https://github.com/kubernetes/enhancements/tree/master/keps/sig-scheduling/3838-pod-mutable-scheduling-directives.
-func setPodNodeAffinityRequirement(ctx context.Context, pod *corev1.Pod,
-	requirement corev1.NodeSelectorRequirement) {
+// When betaArchLabelFallback is true and the pod has no nodeSelectorTerms, a second term matches the nodes by the
+// betaArchLabel label. The terms of the pods that have some cannot be extended: the pods are only placed on the nodes
+// with the archLabel label. The additional requirements of the architectures are ANDed with the requirement for each
+// of them, see architectureTerms.
+func setPodNodeAffinityRequirement(ctx context.Context, pod *corev1.Pod, requirement corev1.NodeSelectorRequirement,
+	additional map[string][]corev1.NodeSelectorRequirement, betaArchLabelFallback bool) {
 	// We are ignoring the podSpec.nodeSelector field,
 	// TODO: validate this is ok when a pod has both nodeSelector and (our) nodeAffinity
 	if pod.Spec.Affinity == nil {
@@ -129,35 +428,98 @@ func setPodNodeAffinityRequirement(ctx context.Context, pod *corev1.Pod,
 
 	// the .requiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms are ORed
 	if len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
-		// We create a new array of NodeSelectorTerm of length one so that we can always iterate it in the next.
-		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = make([]corev1.NodeSelectorTerm, 1)
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms =
+			architectureTerms(requirement, additional, betaArchLabelFallback)
+		return
+	}
+	if betaArchLabelFallback {
+		klog.V(3).Infof("pod %s/%s has nodeSelectorTerms: it will not be placed on the nodes without the %s label",
+			pod.Namespace, pod.Name, archLabel)
 	}
 	nodeSelectorTerms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	architectures := architectureTerms(requirement, additional, false)
 
 	// The expressions within the nodeSelectorTerms are ANDed.
-	// Therefore, we iterate over the nodeSelectorTerms and add an expression to each of the terms to verify the
-	// kubernetes.io/arch label has compatible values.
-	// Note that the NodeSelectorTerms will always be long at least 1, because we (re-)created it with size 1 above if it was nil (or having 0 length).
+	// Therefore, we iterate over the nodeSelectorTerms and add the expressions of the terms of the architectures to
+	// each of them to verify the kubernetes.io/arch label has compatible values. A term is replaced by one term for
+	// each term of the architectures.
+	terms := make([]corev1.NodeSelectorTerm, 0, len(nodeSelectorTerms)*len(architectures))
 	var skipMatchExpressionPatch bool
 	for i := range nodeSelectorTerms {
 		skipMatchExpressionPatch = false
 		if nodeSelectorTerms[i].MatchExpressions == nil {
 			nodeSelectorTerms[i].MatchExpressions = make([]corev1.NodeSelectorRequirement, 0, 1)
 		}
-		// Check if the nodeSelectorTerm already has a matchExpression for the kubernetes.io/arch label.
-		// if yes, we ignore to add it.
+		// Check if the nodeSelectorTerm already has a matchExpression for the kubernetes.io/arch label, or the
+		// deprecated beta one. If yes, we ignore to add it.
 		for _, expression := range nodeSelectorTerms[i].MatchExpressions {
-			if expression.Key == requirement.Key {
-				klog.V(4).Infof("the current nodeSelectorTerm already has a matchExpression for the kubernetes.io/arch label. Ignoring...")
+			if isArchLabel(expression.Key) {
+				klog.V(4).Infof("the current nodeSelectorTerm already has a matchExpression for the %s label. Ignoring...",
+					expression.Key)
 				skipMatchExpressionPatch = true
 				break
 			}
 		}
 		// if skipMatchExpressionPatch is true, we skip to add the matchExpression so that conflictual matchExpressions provided by the user are not overwritten.
-		if !skipMatchExpressionPatch {
-			nodeSelectorTerms[i].MatchExpressions = append(nodeSelectorTerms[i].MatchExpressions, requirement)
+		if skipMatchExpressionPatch {
+			terms = append(terms, nodeSelectorTerms[i])
+			continue
+		}
+		for _, architectureTerm := range architectures {
+			term := *nodeSelectorTerms[i].DeepCopy()
+			term.MatchExpressions = append(term.MatchExpressions, architectureTerm.MatchExpressions...)
+			terms = append(terms, term)
 		}
 	}
+	pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms
+}
+
+// setPodPreferredNodeAffinity adds a preferred node affinity term for the given requirement, unless the pod already
+// prefers some architectures. When betaArchLabelFallback is true, a second term prefers the nodes by the
+// betaArchLabel label. The architectures with additional requirements get their own terms, see architectureTerms.
+// The preferred terms of the gated pods can be changed freely, see KEP-3838.
+func setPodPreferredNodeAffinity(pod *corev1.Pod, requirement corev1.NodeSelectorRequirement,
+	additional map[string][]corev1.NodeSelectorRequirement, betaArchLabelFallback bool) {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	for _, term := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		for _, expression := range term.Preference.MatchExpressions {
+			if isArchLabel(expression.Key) {
+				klog.V(4).Infof("the pod already prefers some values of the %s label. Ignoring...", expression.Key)
+				return
+			}
+		}
+	}
+	for _, term := range architectureTerms(requirement, additional, betaArchLabelFallback) {
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+				Weight:     preferredArchitecturesWeight,
+				Preference: term,
+			})
+	}
+}
+
+// betaArchRequirement returns the requirement matching the same architectures by the betaArchLabel label
+func betaArchRequirement(requirement corev1.NodeSelectorRequirement) corev1.NodeSelectorRequirement {
+	beta := *requirement.DeepCopy()
+	beta.Key = betaArchLabel
+	return beta
+}
+
+func isArchLabel(key string) bool {
+	return key == archLabel || key == betaArchLabel
+}
+
+func setPodAnnotation(pod *corev1.Pod, key, value string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[key] = value
 }
 
 func getPodImagePullSecrets(pod *corev1.Pod) []string {
@@ -172,91 +534,171 @@ func getPodImagePullSecrets(pod *corev1.Pod) []string {
 	return secretRefs
 }
 
-func hasSchedulingGate(pod *corev1.Pod) bool {
-	if pod.Spec.SchedulingGates == nil {
-		// If the schedulingGates array is nil, we return false
-		return false
-	}
-	for _, condition := range pod.Spec.SchedulingGates {
-		if condition.Name == schedulingGateName {
-			return true
-		}
-	}
-	// the scheduling gate is not found.
-	return false
-}
-
-func removeSchedulingGate(pod *corev1.Pod) {
-	if len(pod.Spec.SchedulingGates) == 0 {
-		// If the schedulingGates array is nil, we return
-		return
-	}
-	filtered := make([]corev1.PodSchedulingGate, 0, len(pod.Spec.SchedulingGates))
-	for _, schedulingGate := range pod.Spec.SchedulingGates {
-		if schedulingGate.Name != schedulingGateName {
-			filtered = append(filtered, schedulingGate)
-		}
-	}
-	pod.Spec.SchedulingGates = filtered
-}
-
-// inspectImages returns the list of supported architectures for the images used by the pod.
+// inspectImages returns the list of supported architectures for the images used by the pod, and the digests of the
+// images inspected in their registry by normalized reference, e.g., "//quay.io/org/app:v1".
+// The images of the image volumes recorded by the instance are inspected with the ones of the containers. Each image is
+// inspected once, however many containers and volumes use it and however they reference it. The errors name the
+// containers and the volumes using the image that cannot be inspected.
 // if an error occurs, it returns the error and a nil slice of strings.
-func inspectImages(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) (supportedArchitectures []string, err error) {
-	// Build a set of all the images used by the pod
-	imageNamesSet := sets.New[string]()
-	for _, container := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
-		imageNamesSet.Insert(fmt.Sprintf("//%s", container.Image))
+// The resolver is optional: when it is not nil, it is given precedence over the registry inspection.
+func inspectImages(ctx context.Context, pullSecrets *PullSecretsCache, resolver *image.ImageStreamResolver,
+	inspector inspect.Inspector, instance *Instance, pod *corev1.Pod) (supportedArchitectures []string,
+	digests map[string]string, err error) {
+	imageUsers, err := podImageUsers(instance, pod)
+	if err != nil {
+		klog.Warningf("Error parsing the image reference for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		core.DebugLog(ctx, "The image reference cannot be parsed: %v", err)
+		return nil, nil, err
 	}
-	klog.V(3).Infof("Images list for pod %s/%s: %+v", pod.Namespace, pod.Name, imageNamesSet)
+	imageNames := make([]string, 0, len(imageUsers))
+	for imageName := range imageUsers {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+	klog.V(3).Infof("Images list for pod %s/%s: %+v", pod.Namespace, pod.Name, imageNames)
 	// https://github.com/containers/skopeo/blob/v1.11.1/cmd/skopeo/inspect.go#L72
 	// Iterate over the images, get their architectures and intersect (as in set intersection) them each other
 	var supportedArchitecturesSet sets.Set[string]
-	for imageName := range imageNamesSet {
-		secretAuths, err := pullSecretAuthList(ctx, clientset, pod)
-		if err != nil {
-			klog.Warningf("Error consolidating pull secrets for pod %s ns: %s", pod.Name, pod.Namespace)
-			return nil, err
+	var secretAuths [][]byte
+	digests = map[string]string{}
+	for _, imageName := range imageNames {
+		if currentImageSupportedArchitectures, ok := resolveFromImageStream(ctx, resolver, imageName); ok {
+			core.DebugLog(ctx, "The image stream of the image %s reports the architectures %v", imageName,
+				sets.List(currentImageSupportedArchitectures))
+			supportedArchitecturesSet = intersectArchitectures(supportedArchitecturesSet, currentImageSupportedArchitectures)
+			continue
+		}
+		if secretAuths == nil {
+			if secretAuths, err = pullSecretAuthList(ctx, pullSecrets, pod); err != nil {
+				klog.Warningf("Error consolidating pull secrets for pod %s ns: %s", pod.Name, pod.Namespace)
+				return nil, nil, err
+			}
 		}
 		klog.V(5).Infof("Checking image %s", imageName)
-		currentImageSupportedArchitectures, err := image.FacadeSingleton().GetCompatibleArchitecturesSet(ctx, imageName, secretAuths)
+		result, err := inspector.Inspect(ctx, imageName, secretAuths)
 		if err != nil {
 			// The image cannot be inspected, we skip from adding the nodeAffinity
-			klog.Warningf("Error inspecting the image %s: %v", imageName, err)
-			return nil, err
+			users := imageUsers[imageName].String()
+			klog.Warningf("Error inspecting the image %s of %s: %v", imageName, users, err)
+			core.DebugLog(ctx, "The inspection of the image %s of %s failed: %v", imageName, users, err)
+			return nil, nil, fmt.Errorf("the image %s of %s cannot be inspected: %w", imageName, users, err)
 		}
-		if supportedArchitecturesSet == nil {
-			supportedArchitecturesSet = currentImageSupportedArchitectures
-		} else {
-			supportedArchitecturesSet = supportedArchitecturesSet.Intersection(currentImageSupportedArchitectures)
+		currentImageSupportedArchitectures := result.Architectures()
+		core.DebugLog(ctx, "The image %s with digest %q supports the architectures %v", imageName, result.Digest,
+			sets.List(currentImageSupportedArchitectures))
+		supportedArchitecturesSet = intersectArchitectures(supportedArchitecturesSet, currentImageSupportedArchitectures)
+		if result.Digest != "" {
+			digests[imageName] = result.Digest
 		}
 	}
-	return sets.List(supportedArchitecturesSet), nil
+	return sets.List(supportedArchitecturesSet), digests, nil
+}
+
+// podImageNames returns the references of all the images used by the pod, image volumes included, as inspected
+func podImageNames(instance *Instance, pod *corev1.Pod) sets.Set[string] {
+	imageNamesSet := sets.New[string]()
+	for _, reference := range instance.podImageReferences(pod) {
+		imageNamesSet.Insert(fmt.Sprintf("//%s", reference))
+	}
+	return imageNamesSet
 }
 
-// pullSecretAuthList returns the list of secrets data for the given pod given its imagePullSecrets field
-func pullSecretAuthList(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) ([][]byte, error) {
-	secretAuths := make([][]byte, 0)
-	secretList := getPodImagePullSecrets(pod)
-	for _, pullsecret := range secretList {
-		secret, err := clientset.CoreV1().Secrets(pod.Namespace).Get(ctx, pullsecret, metav1.GetOptions{})
+// podImageUsers returns the names of the containers, init containers included, and of the image volumes using each
+// image of the pod, by normalized reference. The containers and volumes referencing the same image in different ways,
+// e.g., nginx and docker.io/library/nginx:latest, or with different pull policies, share the same entry.
+func podImageUsers(instance *Instance, pod *corev1.Pod) (map[string]*imageUsers, error) {
+	users := map[string]*imageUsers{}
+	usersOf := func(imageName string) *imageUsers {
+		if users[imageName] == nil {
+			users[imageName] = &imageUsers{}
+		}
+		return users[imageName]
+	}
+	for _, container := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
+		imageName, err := image.NormalizeReference(container.Image)
 		if err != nil {
-			klog.Warningf("Error getting secret: %s namespace: %s", pullsecret, pod.Namespace)
-			continue
+			return nil, fmt.Errorf("the image of the container %s cannot be parsed: %w", container.Name, err)
 		}
-		if secretData, err := image.ExtractAuthFromSecret(secret); err != nil {
-			klog.Warningf("Error extracting auth from secret: %s namespace: %s", pullsecret, pod.Namespace)
-			continue
-		} else {
-			secretAuths = append(secretAuths, secretData)
+		usersOf(imageName).containers = append(usersOf(imageName).containers, container.Name)
+	}
+	for _, volume := range instance.imageVolumes(pod) {
+		imageName, err := image.NormalizeReference(volume.reference)
+		if err != nil {
+			return nil, fmt.Errorf("the image of the image volume %s cannot be parsed: %w", volume.name, err)
 		}
+		usersOf(imageName).volumes = append(usersOf(imageName).volumes, volume.name)
 	}
-	return secretAuths, nil
+	return users, nil
+}
+
+// resolveFromImageStream returns the architectures of imageName as reported by its image stream, if the resolver is
+// set and imageName refers to an image stream of the internal registry. ok is false when the caller has to fall back
+// to the registry inspection.
+func resolveFromImageStream(ctx context.Context, resolver *image.ImageStreamResolver,
+	imageName string) (supportedArchitectures sets.Set[string], ok bool) {
+	if resolver == nil {
+		return nil, false
+	}
+	supportedArchitectures, err := resolver.GetCompatibleArchitecturesSet(ctx, imageName)
+	if err != nil {
+		klog.V(4).Infof("Unable to resolve the image %s through its image stream, inspecting it: %v", imageName, err)
+		return nil, false
+	}
+	return supportedArchitectures, true
+}
+
+// intersectArchitectures returns the intersection of the two sets, or current if acc is nil
+func intersectArchitectures(acc, current sets.Set[string]) sets.Set[string] {
+	if acc == nil {
+		return current
+	}
+	return acc.Intersection(current)
+}
+
+// inspector returns the Inspector, or inspect.Singleton() if it is not set
+func (r *PodReconciler) inspector() inspect.Inspector {
+	if r.Inspector != nil {
+		return r.Inspector
+	}
+	return inspect.Singleton()
+}
+
+// pullSecrets returns the PullSecrets, if set, or else a PullSecretsCache reading the secrets through the Clientset
+// without caching them
+func (r *PodReconciler) pullSecrets() *PullSecretsCache {
+	if r.PullSecrets != nil {
+		return r.PullSecrets
+	}
+	if r.Clientset == nil {
+		return nil
+	}
+	return NewPullSecretsCache(r.Clientset.CoreV1(), 0)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Pod{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Pod{})
+	if !r.CustomResourcesMissing {
+		// the gated pods are placed again when their placement settings change, e.g., when their allowed
+		// architectures or failure policy kept them gated
+		b = b.Watches(&multiarchv1alpha1.PodPlacementPolicy{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return gatedPodsRequests(ctx, r.Client, r.Instance, client.InNamespace(obj.GetNamespace()))
+			}))
+		b = b.Watches(&multiarchv1alpha1.PodPlacementConfig{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, _ client.Object) []reconcile.Request {
+				return gatedPodsRequests(ctx, r.Client, r.Instance)
+			}))
+	}
+	if r.Backoff != nil {
+		b = b.WithOptions(controller.Options{RateLimiter: r.Backoff})
+	}
+	if r.GatedPodsSweeper != nil {
+		b = b.WatchesRawSource(&source.Channel{Source: r.GatedPodsSweeper.Events()}, &handler.EnqueueRequestForObject{})
+	}
+	if r.NodeArchitectures != nil {
+		b = b.WatchesRawSource(&source.Channel{Source: r.NodeArchitectures.Events()}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(r)
 }
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"multiarch-operator/pkg/version"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("The architectures override annotation", func() {
+	var (
+		recorder   *record.FakeRecorder
+		reconciler *PodReconciler
+	)
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &PodReconciler{Recorder: recorder, Inspector: noInspections{}}
+	})
+
+	podWithOverride := func(value string) *corev1.Pod {
+		pod := podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: value}
+		return pod
+	}
+
+	DescribeTable("should parse the valid values",
+		func(value string, expected []string) {
+			architectures, ok := reconciler.architecturesOverride(podWithOverride(value))
+			Expect(ok).To(BeTrue())
+			Expect(architectures).To(Equal(expected))
+			Expect(recorder.Events).To(BeEmpty())
+		},
+		Entry("single architecture", "arm64", []string{"arm64"}),
+		Entry("multiple architectures", "arm64,amd64", []string{"amd64", "arm64"}),
+		Entry("spaces and duplicates", " s390x, ppc64le ,s390x", []string{"ppc64le", "s390x"}),
+		Entry("aliases", "x86_64,aarch64", []string{"amd64", "arm64"}),
+		Entry("alias and its GOARCH name", "aarch64,arm64", []string{"arm64"}),
+	)
+
+	DescribeTable("should ignore the invalid values with a Warning event",
+		func(value string) {
+			_, ok := reconciler.architecturesOverride(podWithOverride(value))
+			Expect(ok).To(BeFalse())
+			Expect(recorder.Events).To(Receive(And(
+				HavePrefix(corev1.EventTypeWarning+" "+reasons.InvalidArchitecturesOverride),
+				ContainSubstring(architecturesOverrideAnnotation),
+				HaveSuffix("(multiarch-operator "+version.Version+")"))))
+		},
+		Entry("empty value", ""),
+		Entry("empty item", "arm64,,amd64"),
+		Entry("trailing comma", "arm64,"),
+		Entry("upper case", "ARM64"),
+		Entry("node selector syntax", "kubernetes.io/arch=arm64"),
+		Entry("unknown architecture", "arm64,sparc"),
+	)
+
+	It("should not report the pods without the annotation", func() {
+		_, ok := reconciler.architecturesOverride(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(ok).To(BeFalse())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should set the node affinity without inspecting the images", func() {
+		pod := podWithOverride("arm64,amd64")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			podPlacementConfigTrusting("quay.io/org"), pod).Build()
+		// any inspection would panic
+		reconciler.Client = c
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		updated := &corev1.Pod{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(pod), updated)).To(Succeed())
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			ConsistOf(corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      archLabel,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{"amd64", "arm64"},
+				}},
+			}))
+	})
+})
+
+var _ = Describe("The pod reconciler shutdown", func() {
+	var (
+		pod    *corev1.Pod
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		pod = podWithImages("pod", "quay.io/org/app:v1")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64"}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+	})
+
+	reconcile := func(funcs interceptor.Funcs) (client.Client, error) {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod).
+			WithInterceptorFuncs(funcs).Build()
+		// any inspection would panic
+		reconciler := &PodReconciler{Client: c, Inspector: noInspections{}}
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		return c, err
+	}
+
+	getPod := func(c client.Client) *corev1.Pod {
+		updated := &corev1.Pod{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(pod), updated)).To(Succeed())
+		return updated
+	}
+
+	It("should keep the pod gated when the context is cancelled while deciding", func() {
+		c, err := reconcile(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+				// the shutdown is requested once the pod is read, before its placement is decided
+				cancel()
+				return c.Get(ctx, key, obj, opts...)
+			},
+		})
+		Expect(err).To(MatchError(context.Canceled))
+		updated := getPod(c)
+		Expect(updated.Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(updated.Spec.Affinity).To(BeNil())
+	})
+
+	It("should complete the update of a decided pod when the context is cancelled", func() {
+		c, err := reconcile(interceptor.Funcs{
+			Update: func(updateCtx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				// the shutdown is requested while the pod is updated
+				cancel()
+				Expect(updateCtx.Err()).NotTo(HaveOccurred())
+				return c.Update(updateCtx, obj, opts...)
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		updated := getPod(c)
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(
+			ConsistOf(corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      archLabel,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{"arm64"},
+				}},
+			}))
+	})
+})
+
+var _ = Describe("The inspection of the images of a pod", func() {
+	var (
+		pod       *corev1.Pod
+		recorder  *record.FakeRecorder
+		inspector *fakeArchitectures
+	)
+
+	BeforeEach(func() {
+		// five containers sharing two images, referenced in different ways and with different pull policies
+		pod = podWithImages("pod")
+		pod.Spec.Containers = []corev1.Container{
+			{Name: "app", Image: "quay.io/org/app:v1", ImagePullPolicy: corev1.PullAlways},
+			{Name: "sidecar", Image: "quay.io/org/app:v1", ImagePullPolicy: corev1.PullIfNotPresent},
+			{Name: "proxy", Image: "nginx", ImagePullPolicy: corev1.PullAlways},
+			{Name: "proxy-tls", Image: "docker.io/library/nginx:latest", ImagePullPolicy: corev1.PullIfNotPresent},
+		}
+		pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "quay.io/org/app:v1"}}
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		recorder = record.NewFakeRecorder(10)
+		inspector = &fakeArchitectures{registry: map[string][]string{
+			"//quay.io/org/app:v1":             {"amd64", "arm64", "s390x"},
+			"//docker.io/library/nginx:latest": {"amd64", "arm64"},
+		}}
+	})
+
+	reconcile := func() (client.Client, error) {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pod,
+			namespacePlacementPolicy("policy", time.Now(), multiarchv1alpha1.PlacementPolicy{
+				FailurePolicy: multiarchv1alpha1.PlacementFailurePolicyFail,
+			})).Build()
+		r := &PodReconciler{Client: c, Recorder: recorder, Inspector: inspector}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		return c, err
+	}
+
+	It("should inspect each image once and intersect their architectures", func() {
+		c, err := reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inspector.inspections).To(Equal(2))
+		Expect(getPod(c, pod).Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms[0].MatchExpressions[0].Values).To(Equal([]string{"amd64", "arm64"}))
+	})
+
+	It("should name all the containers of the image that cannot be inspected", func() {
+		delete(inspector.registry, "//docker.io/library/nginx:latest")
+		c, err := reconcile()
+		Expect(err).To(HaveOccurred())
+		Expect(inspector.inspections).To(BeNumerically("<=", 2))
+		Expect(getPod(c, pod).Spec.SchedulingGates).To(ConsistOf(schedulingGate))
+		Expect(recorder.Events).To(Receive(And(
+			HavePrefix(corev1.EventTypeWarning+" "+reasons.InspectionFailedNotFound),
+			ContainSubstring("the image //docker.io/library/nginx:latest of the containers proxy, proxy-tls "+
+				"cannot be inspected"))))
+	})
+})
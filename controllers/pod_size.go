@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/reasons"
+)
+
+// maxPodObjectSize is the size of the JSON serialization of the pods the updates of the reconciler are bounded to:
+// the default limit of the requests to etcd is 1.5 MiB, and the pods are stored in the more compact protobuf
+// serialization
+const maxPodObjectSize = 1536 * 1024
+
+// trimmableAnnotations are the annotations the reconciler sets on the pods that can be left out of their update when
+// the pods would exceed the size limits, in the order they are left out: they report the details of the placement, as
+// opposed to the node affinity and the removal of the scheduling gate, which are never left out.
+var trimmableAnnotations = []string{
+	inspectedDigestsAnnotation,
+	capacityExcludedArchitecturesAnnotation,
+	supportedArchitecturesAnnotation,
+	mutatedByAnnotation,
+	placementReasonAnnotation,
+	decisionAnnotation,
+}
+
+// trimToSizeLimits reverts the trimmableAnnotations changed on the pod since it was read as original, one at a time,
+// until the pod fits the size limits of the API server: the total size of its annotations and the size of the object.
+// It returns the annotations reverted. The pods exceeding the limits once all of them are reverted are left to fail
+// their update.
+func (r *PodReconciler) trimToSizeLimits(pod, original *corev1.Pod) (trimmed []string) {
+	for _, key := range trimmableAnnotations {
+		if fitsSizeLimits(pod) {
+			return trimmed
+		}
+		key = r.Instance.annotation(key)
+		value, found := pod.Annotations[key]
+		originalValue, originalFound := original.Annotations[key]
+		if !found || (originalFound && value == originalValue) {
+			continue
+		}
+		if originalFound {
+			pod.Annotations[key] = originalValue
+		} else {
+			delete(pod.Annotations, key)
+		}
+		trimmed = append(trimmed, key)
+	}
+	if !fitsSizeLimits(pod) {
+		klog.Warningf("Pod %s/%s exceeds the size limits of the API server even without the annotations of its "+
+			"placement: its update will fail", pod.Namespace, pod.Name)
+	}
+	return trimmed
+}
+
+// fitsSizeLimits returns true if the annotations and the object of the pod are within the size limits of the API
+// server
+func fitsSizeLimits(pod *corev1.Pod) bool {
+	if apivalidation.ValidateAnnotationsSize(pod.Annotations) != nil {
+		return false
+	}
+	data, err := json.Marshal(pod)
+	// the pods that cannot be serialized fail their update anyway
+	return err != nil || len(data) <= maxPodObjectSize
+}
+
+// recordTrimmedAnnotations reports a Warning event on the pod with the annotations left out of its update to fit the
+// size limits of the API server
+func (r *PodReconciler) recordTrimmedAnnotations(pod *corev1.Pod, trimmed []string) {
+	if len(trimmed) == 0 {
+		return
+	}
+	klog.V(2).Infof("Left the annotations %v out of the update of pod %s/%s to fit the size limits of the API server",
+		trimmed, pod.Namespace, pod.Name)
+	r.recordWarning(pod, reasons.AnnotationsTrimmed, "The annotations %s are not set: the pod is close to the size "+
+		"limits of the API server", strings.Join(trimmed, ","))
+}
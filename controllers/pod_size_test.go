@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/client-go/tools/record"
+	"multiarch-operator/pkg/decision"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// enforcingSizeLimits rejects the updates of the objects exceeding the size limits, as the API server does
+var enforcingSizeLimits = interceptor.Funcs{
+	Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+		if err := apivalidation.ValidateAnnotationsSize(obj.GetAnnotations()); err != nil {
+			return apierrors.NewBadRequest(err.Error())
+		}
+		if data, err := json.Marshal(obj); err != nil || len(data) > maxPodObjectSize {
+			return apierrors.NewRequestEntityTooLargeError("the object exceeds the limit of the requests to etcd")
+		}
+		return c.Update(ctx, obj, opts...)
+	},
+}
+
+var _ = Describe("The size limits of the pods", func() {
+	const paddingAnnotation = "example.com/padding"
+	var (
+		c          client.Client
+		recorder   *record.FakeRecorder
+		reconciler *PodReconciler
+	)
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).WithInterceptorFuncs(enforcingSizeLimits).
+			Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &PodReconciler{Client: c, Recorder: recorder, MutatedBy: "v1.2.3", Inspector: &fakeArchitectures{
+			registry: map[string][]string{"//quay.io/org/app:v1": {"amd64", "arm64"}},
+			digests:  map[string]string{"//quay.io/org/app:v1": "sha256:" + strings.Repeat("a", 64)},
+		}}
+	})
+
+	// gatedWithAnnotationsRoom returns a gated pod whose annotations can grow by room bytes only
+	gatedWithAnnotationsRoom := func(room int) *corev1.Pod {
+		pod := podWithImages("padded", "quay.io/org/app:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		pod.Annotations = map[string]string{paddingAnnotation: strings.Repeat("x",
+			apivalidation.TotalAnnotationSizeLimitB-len(paddingAnnotation)-room)}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		return pod
+	}
+
+	// documentSize returns the size of the decisionAnnotation annotation of the placed pods
+	documentSize := func() int {
+		value, err := decision.Format(decision.Decision{
+			Architectures: []string{"amd64", "arm64"},
+			Images: []decision.Image{{Reference: "quay.io/org/app:v1",
+				Digest: "sha256:" + strings.Repeat("a", 64)}},
+			Reason:     reasons.ArchitectureConstrained,
+			MutatedBy:  "v1.2.3",
+			Generation: 1,
+			Timestamp:  time.Now(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return len(decisionAnnotation) + len(value)
+	}
+
+	expectPlaced := func(pod *corev1.Pod) *corev1.Pod {
+		placed := getPod(c, pod)
+		Expect(placed.Spec.SchedulingGates).To(BeEmpty())
+		Expect(placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+			To(Equal([]corev1.NodeSelectorTerm{archTerm(archLabel, "amd64", "arm64")}))
+		return placed
+	}
+
+	It("should leave the annotations out of the update of the pods at the limit of the size of the annotations", func() {
+		pod := gatedWithAnnotationsRoom(10)
+		reconcilePods(reconciler, pod)
+		placed := expectPlaced(pod)
+		Expect(placed.Annotations).To(HaveLen(1))
+		Expect(placed.Annotations).To(HaveKey(paddingAnnotation))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring(reasons.AnnotationsTrimmed),
+			ContainSubstring(inspectedDigestsAnnotation), ContainSubstring(mutatedByAnnotation),
+			ContainSubstring(placementReasonAnnotation))))
+	})
+
+	It("should leave out the details of the placement first", func() {
+		pod := gatedWithAnnotationsRoom(len(placementReasonAnnotation) + len(reasons.ArchitectureConstrained) +
+			len(mutatedByAnnotation) + len("v1.2.3") + documentSize() + 10)
+		reconcilePods(reconciler, pod)
+		placed := expectPlaced(pod)
+		Expect(placed.Annotations).To(HaveKey(decisionAnnotation))
+		Expect(placed.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.ArchitectureConstrained))
+		Expect(placed.Annotations).To(HaveKeyWithValue(mutatedByAnnotation, "v1.2.3"))
+		Expect(placed.Annotations).NotTo(HaveKey(inspectedDigestsAnnotation))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring(reasons.AnnotationsTrimmed),
+			ContainSubstring(inspectedDigestsAnnotation), Not(ContainSubstring(placementReasonAnnotation)))))
+	})
+
+	It("should remove the scheduling gate of the pods padded near the limit of the size of the objects", func() {
+		pod := podWithImages("padded", "quay.io/org/app:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "PADDING"}}
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		// pad the pod to 300 bytes from the limit, besides the decision: less than the node affinity and all the
+		// annotations of the placement
+		pod = getPod(c, pod)
+		data, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		pod.Spec.Containers[0].Env[0].Value = strings.Repeat("x", maxPodObjectSize-len(data)-documentSize()-300)
+		Expect(c.Update(context.Background(), pod)).To(Succeed())
+
+		reconcilePods(reconciler, pod)
+		placed := expectPlaced(pod)
+		Expect(placed.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.ArchitectureConstrained))
+		Expect(placed.Annotations).NotTo(HaveKey(inspectedDigestsAnnotation))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasons.AnnotationsTrimmed)))
+	})
+
+	It("should not leave out the annotations of the pods within the limits", func() {
+		pod := gatedWithAnnotationsRoom(1024)
+		reconcilePods(reconciler, pod)
+		Expect(expectPlaced(pod).Annotations).To(HaveKey(inspectedDigestsAnnotation))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring(reasons.AnnotationsTrimmed)))
+	})
+})
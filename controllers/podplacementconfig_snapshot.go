@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// snapshotRetryInterval is the time the PodPlacementConfigSnapshot waits before listing the PodPlacementConfig
+// objects again after a failure
+const snapshotRetryInterval = time.Second
+
+// podPlacementConfigs is an immutable snapshot of the PodPlacementConfig objects
+type podPlacementConfigs struct {
+	// items are sorted by name, as merged by effectivePlacementPolicy
+	items []multiarchv1alpha1.PodPlacementConfig
+	// trustedPrefixes are the valid trusted multi-arch prefixes of the items
+	trustedPrefixes []trustedPrefix
+	// pausedBy is the name of the item pausing the pod placement, if any
+	pausedBy string
+	// denyImpossiblePods is true when the pods that cannot run on any node of the cluster are denied
+	denyImpossiblePods bool
+	// skipSingleArchCluster is true when the pods are not gated while the cluster has a single architecture
+	skipSingleArchCluster bool
+	// mutateWorkloadTemplates is true when the pod templates of the workloads are placed at their admission
+	mutateWorkloadTemplates bool
+	// features are the toggles of the items, see podPlacementConfigFeatures
+	features map[string]bool
+	// tuning is the tuning of the items, see mergedTuning
+	tuning multiarchv1alpha1.TuningSettings
+}
+
+// newPodPlacementConfigs returns the snapshot of the PodPlacementConfig objects sorted by name
+func newPodPlacementConfigs(items []multiarchv1alpha1.PodPlacementConfig) *podPlacementConfigs {
+	return &podPlacementConfigs{
+		items:                   items,
+		trustedPrefixes:         parseTrustedMultiArchPrefixes(items),
+		pausedBy:                placementPausedBy(items),
+		denyImpossiblePods:      deniesImpossiblePods(items),
+		skipSingleArchCluster:   skipsSingleArchCluster(items),
+		mutateWorkloadTemplates: mutatesWorkloadTemplates(items),
+		features:                podPlacementConfigFeatures(items),
+		tuning:                  mergedTuning(items),
+	}
+}
+
+// PodPlacementConfigSnapshot keeps a snapshot of the PodPlacementConfig objects of the manager cache, replaced every
+// time the informer of the cache reports a change, so that the admission of the pods never reads them from the API
+// server nor waits for the cache. The snapshot is missing until the first list of the objects succeeds. Every snapshot
+// applies the tuning of the objects to the replica, see applyTuning.
+// It implements the manager.Runnable interface.
+type PodPlacementConfigSnapshot struct {
+	informers cache.Informers
+	reader    client.Reader
+	// retryInterval is the time to wait before listing the objects again after a failure
+	retryInterval time.Duration
+	// changes carries the changes notified by the informer. It has a buffer of one: a pending refresh covers any
+	// later change, as it lists the objects at the time it runs.
+	changes  chan struct{}
+	snapshot atomic.Pointer[podPlacementConfigs]
+}
+
+// NewPodPlacementConfigSnapshot returns a PodPlacementConfigSnapshot watching the PodPlacementConfig objects through
+// the informers and listing them with the reader, e.g., both the cache of the manager.
+func NewPodPlacementConfigSnapshot(informers cache.Informers, reader client.Reader) *PodPlacementConfigSnapshot {
+	return &PodPlacementConfigSnapshot{
+		informers:     informers,
+		reader:        reader,
+		retryInterval: snapshotRetryInterval,
+		changes:       make(chan struct{}, 1),
+	}
+}
+
+// NewDefaultPodPlacementConfigSnapshot returns a PodPlacementConfigSnapshot without any PodPlacementConfig object,
+// e.g., when their CustomResourceDefinition is not installed: the default placement settings apply. It is never
+// refreshed and must not be added to the manager.
+func NewDefaultPodPlacementConfigSnapshot() *PodPlacementConfigSnapshot {
+	s := &PodPlacementConfigSnapshot{}
+	snapshot := newPodPlacementConfigs(nil)
+	s.snapshot.Store(snapshot)
+	recordPodPlacementConfigFeatures(snapshot)
+	applyTuning(snapshot.tuning)
+	return s
+}
+
+// Start refreshes the snapshot at every change of the PodPlacementConfig objects until the context is done. The
+// failed refreshes are retried every retryInterval, keeping the previous snapshot meanwhile.
+func (s *PodPlacementConfigSnapshot) Start(ctx context.Context) error {
+	informer, err := s.informers.GetInformer(ctx, &multiarchv1alpha1.PodPlacementConfig{})
+	if err != nil {
+		return err
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { s.changed() },
+		UpdateFunc: func(interface{}, interface{}) { s.changed() },
+		DeleteFunc: func(interface{}) { s.changed() },
+	}); err != nil {
+		return err
+	}
+	var retry <-chan time.Time
+	for {
+		if err := s.refresh(ctx); err != nil {
+			klog.Warningf("Unable to refresh the snapshot of the PodPlacementConfig objects: %v", err)
+			retry = time.After(s.retryInterval)
+		} else {
+			retry = nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.changes:
+		case <-retry:
+		}
+	}
+}
+
+// NeedLeaderElection returns false: the webhook is served by every replica of the operator.
+func (s *PodPlacementConfigSnapshot) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *PodPlacementConfigSnapshot) changed() {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (s *PodPlacementConfigSnapshot) refresh(ctx context.Context) error {
+	items, err := listPodPlacementConfigs(ctx, s.reader)
+	if err != nil {
+		return err
+	}
+	snapshot := newPodPlacementConfigs(items)
+	s.snapshot.Store(snapshot)
+	recordPodPlacementConfigFeatures(snapshot)
+	applyTuning(snapshot.tuning)
+	klog.V(4).Infof("Refreshed the snapshot of the %d PodPlacementConfig objects", len(items))
+	return nil
+}
+
+// load returns the current snapshot. ok is false until the first refresh succeeds.
+func (s *PodPlacementConfigSnapshot) load() (snapshot *podPlacementConfigs, ok bool) {
+	snapshot = s.snapshot.Load()
+	return snapshot, snapshot != nil
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// fakeInformers returns the same fake informer for all the objects
+type fakeInformers struct {
+	cache.Informers
+	informer *controllertest.FakeInformer
+}
+
+func (f *fakeInformers) GetInformer(context.Context, client.Object) (cache.Informer, error) {
+	return f.informer, nil
+}
+
+// countingPodPlacementConfigLists returns the interceptor functions counting the lists of the PodPlacementConfig
+// objects in lists, and failing them while failing is set
+func countingPodPlacementConfigLists(lists *atomic.Int32, failing *atomic.Bool) interceptor.Funcs {
+	return interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*multiarchv1alpha1.PodPlacementConfigList); ok {
+				lists.Add(1)
+				if failing.Load() {
+					return errors.New("the API server is unavailable")
+				}
+			}
+			return c.List(ctx, list, opts...)
+		},
+	}
+}
+
+func counterValueOf(counter prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	Expect(counter.Write(m)).To(Succeed())
+	return m.GetCounter().GetValue()
+}
+
+func namedPodPlacementConfig(name string, prefixes ...string) *multiarchv1alpha1.PodPlacementConfig {
+	ppc := podPlacementConfigTrusting(prefixes...)
+	ppc.Name = name
+	return ppc
+}
+
+// snapshotNames returns the names of the PodPlacementConfig objects of the snapshot, or nil if it is missing
+func snapshotNames(s *PodPlacementConfigSnapshot) []string {
+	snapshot, ok := s.load()
+	if !ok {
+		return nil
+	}
+	names := []string{}
+	for _, ppc := range snapshot.items {
+		names = append(names, ppc.Name)
+	}
+	return names
+}
+
+var _ = Describe("The snapshot of the PodPlacementConfig objects", func() {
+	var (
+		ctx      context.Context
+		informer *controllertest.FakeInformer
+		c        client.Client
+		lists    atomic.Int32
+		failing  atomic.Bool
+		snapshot *PodPlacementConfigSnapshot
+	)
+
+	start := func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			Expect(snapshot.Start(ctx)).To(Succeed())
+		}()
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+	}
+
+	BeforeEach(func() {
+		lists.Store(0)
+		failing.Store(false)
+		informer = &controllertest.FakeInformer{Synced: true}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithObjects(namedPodPlacementConfig("b"), namedPodPlacementConfig("a", "quay.io/org")).
+			WithInterceptorFuncs(countingPodPlacementConfigLists(&lists, &failing)).Build()
+		snapshot = NewPodPlacementConfigSnapshot(&fakeInformers{informer: informer}, c)
+	})
+
+	It("should run in every replica", func() {
+		Expect(snapshot.NeedLeaderElection()).To(BeFalse())
+	})
+
+	It("should snapshot the objects sorted by name and refresh the snapshot at every change", func() {
+		start()
+		Eventually(func() []string { return snapshotNames(snapshot) }).Should(Equal([]string{"a", "b"}))
+		current, _ := snapshot.load()
+		Expect(current.trustedPrefixes).To(HaveLen(1))
+
+		added := namedPodPlacementConfig("c")
+		Expect(c.Create(context.Background(), added)).To(Succeed())
+		informer.Add(added)
+		Eventually(func() []string { return snapshotNames(snapshot) }).Should(Equal([]string{"a", "b", "c"}))
+
+		Expect(c.Delete(context.Background(), added)).To(Succeed())
+		informer.Delete(added)
+		Eventually(func() []string { return snapshotNames(snapshot) }).Should(Equal([]string{"a", "b"}))
+	})
+
+	It("should retry the failed refreshes and keep the previous snapshot meanwhile", func() {
+		failing.Store(true)
+		snapshot.retryInterval = 10 * time.Millisecond
+		start()
+		Eventually(lists.Load).Should(BeNumerically(">", 1))
+		Expect(snapshotNames(snapshot)).To(BeNil())
+
+		failing.Store(false)
+		Eventually(func() []string { return snapshotNames(snapshot) }).Should(Equal([]string{"a", "b"}))
+
+		failing.Store(true)
+		informer.Add(namedPodPlacementConfig("c"))
+		Consistently(func() []string { return snapshotNames(snapshot) }, 100*time.Millisecond).
+			Should(Equal([]string{"a", "b"}))
+	})
+})
+
+var _ = Describe("The admission with the snapshot of the PodPlacementConfig objects", func() {
+	var (
+		webhook  *PodSchedulingGateMutatingWebHook
+		lists    atomic.Int32
+		failing  atomic.Bool
+		snapshot *PodPlacementConfigSnapshot
+		request  func(pod *corev1.Pod) admission.Request
+	)
+
+	BeforeEach(func() {
+		lists.Store(0)
+		failing.Store(false)
+		scheme := newTestScheme()
+		optOut := true
+		objects := []client.Object{
+			namedPodPlacementConfig("cluster", "registry.access.redhat.com/ubi9"),
+			&multiarchv1alpha1.PodPlacementPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "opted-out"},
+				Spec: multiarchv1alpha1.PodPlacementPolicySpec{
+					PlacementPolicy: multiarchv1alpha1.PlacementPolicy{OptOut: &optOut},
+				},
+			},
+		}
+		// the snapshot lists the objects from the cache, the webhook must never list them
+		reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		snapshot = NewPodPlacementConfigSnapshot(&fakeInformers{informer: &controllertest.FakeInformer{}}, reader)
+		webhook = &PodSchedulingGateMutatingWebHook{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).
+				WithInterceptorFuncs(countingPodPlacementConfigLists(&lists, &failing)).Build(),
+			PodPlacementConfigs: snapshot,
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		request = func(pod *corev1.Pod) admission.Request {
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			return admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+		}
+	})
+
+	handle := func(pod *corev1.Pod) admission.Response {
+		response := webhook.Handle(context.Background(), request(pod))
+		Expect(response.Allowed).To(BeTrue())
+		return response
+	}
+
+	It("should not gate the pods until the first snapshot", func() {
+		before := counterValueOf(admissionsWithoutSnapshot)
+		Expect(handle(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(BeEmpty())
+		Expect(counterValueOf(admissionsWithoutSnapshot)).To(Equal(before + 1))
+		Expect(lists.Load()).To(BeZero())
+	})
+
+	It("should export the metrics with the version of the operator", func() {
+		handle(podWithImages("pod", "quay.io/org/app:v1"))
+		families, err := metrics.Registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(families).To(ContainElement(And(
+			HaveField("GetName()", "multiarch_admissions_without_podplacementconfig_snapshot_total"),
+			HaveField("GetMetric()", ConsistOf(HaveField("GetLabel()", ContainElement(And(
+				HaveField("GetName()", "version"), HaveField("GetValue()", version.Version)))))))))
+	})
+
+	It("should read the placement settings from the snapshot only", func() {
+		Expect(snapshot.refresh(context.Background())).To(Succeed())
+		failing.Store(true)
+		Expect(handle(podWithImages("pod", "quay.io/org/app:v1")).Patches).To(
+			ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		Expect(handle(podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122")).Patches).To(BeEmpty())
+		optedOut := podWithImages("pod", "quay.io/org/app:v1")
+		optedOut.Namespace = "opted-out"
+		Expect(handle(optedOut).Patches).To(BeEmpty())
+		Expect(lists.Load()).To(BeZero())
+	})
+
+	It("should not list the PodPlacementConfig objects at the admissions with a warm snapshot", func() {
+		Expect(snapshot.refresh(context.Background())).To(Succeed())
+		req := request(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar:v1"))
+		for i := 0; i < 100; i++ {
+			Expect(webhook.Handle(context.Background(), req).Allowed).To(BeTrue())
+		}
+		Expect(lists.Load()).To(BeZero())
+	})
+})
+
+// BenchmarkAdmissionWithSnapshot admits a pod with a warm snapshot of the PodPlacementConfig objects
+func BenchmarkAdmissionWithSnapshot(b *testing.B) {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{corev1.AddToScheme, multiarchv1alpha1.AddToScheme} {
+		if err := addToScheme(scheme); err != nil {
+			b.Fatal(err)
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		namedPodPlacementConfig("cluster", "registry.access.redhat.com/ubi9")).Build()
+	snapshot := NewPodPlacementConfigSnapshot(&fakeInformers{informer: &controllertest.FakeInformer{}}, c)
+	if err := snapshot.refresh(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	webhook := &PodSchedulingGateMutatingWebHook{Client: c, PodPlacementConfigs: snapshot}
+	if err := webhook.InjectDecoder(admission.NewDecoder(scheme)); err != nil {
+		b.Fatal(err)
+	}
+	raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1", "quay.io/org/sidecar:v1"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		webhook.Handle(context.Background(), req)
+	}
+}
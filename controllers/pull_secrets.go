@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"multiarch-operator/pkg/image"
+)
+
+const (
+	// DefaultPullSecretsCacheTTL is the default time the auths of the pull secrets of the pods are cached
+	DefaultPullSecretsCacheTTL = 30 * time.Second
+	// pullSecretsCacheSize bounds the number of pull secrets cached
+	pullSecretsCacheSize = 1024
+	// lookupTimeout bounds each read of an object on the reconcile path, e.g., a pull secret
+	lookupTimeout = 10 * time.Second
+)
+
+// errNoSecretsClient is returned when the pull secrets of a pod are read without a client
+var errNoSecretsClient = errors.New("no client to read the pull secrets")
+
+// PullSecretsCache reads the auths of the pull secrets of the pods, caching them for a TTL, so that the pods of the
+// same workload, sharing the same pull secrets, are placed with a single read of each secret. The secrets are read
+// from the API server rather than through the cache of the manager, which would cache all the secrets of the cluster:
+// only the ones referenced by the gated pods are cached, at most pullSecretsCacheSize, the least recently used ones
+// being evicted first. The secrets that cannot be read are not cached.
+// The nil PullSecretsCache reads nothing.
+type PullSecretsCache struct {
+	secrets corev1client.SecretsGetter
+	// auths are the auths of the pull secrets by namespaced name. It is nil when the auths are not cached.
+	auths *cache.LRUExpireCache
+	ttl   time.Duration
+}
+
+// NewPullSecretsCache returns a PullSecretsCache reading the secrets with the getter and caching their auths for ttl.
+// A zero ttl disables the cache.
+func NewPullSecretsCache(secrets corev1client.SecretsGetter, ttl time.Duration) *PullSecretsCache {
+	return newPullSecretsCache(secrets, ttl, clock.RealClock{})
+}
+
+func newPullSecretsCache(secrets corev1client.SecretsGetter, ttl time.Duration, c clock.Clock) *PullSecretsCache {
+	p := &PullSecretsCache{secrets: secrets, ttl: ttl}
+	if ttl > 0 {
+		p.auths = cache.NewLRUExpireCacheWithClock(pullSecretsCacheSize, c)
+	}
+	return p
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+
+// auth returns the auths of the pull secret
+func (p *PullSecretsCache) auth(ctx context.Context, key types.NamespacedName) ([]byte, error) {
+	if p == nil || p.secrets == nil {
+		return nil, errNoSecretsClient
+	}
+	if p.auths != nil {
+		if auth, ok := p.auths.Get(key); ok {
+			pullSecretReads.WithLabelValues("hit").Inc()
+			return auth.([]byte), nil
+		}
+	}
+	pullSecretReads.WithLabelValues("miss").Inc()
+	getCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	secret, err := p.secrets.Secrets(key.Namespace).Get(getCtx, key.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	auth, err := image.ExtractAuthFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	if p.auths != nil {
+		p.auths.Add(key, auth, p.ttl)
+	}
+	return auth, nil
+}
+
+// pullSecretAuthList returns the list of secrets data for the given pod given its imagePullSecrets field. The secrets
+// that cannot be read are skipped.
+func pullSecretAuthList(ctx context.Context, secrets *PullSecretsCache, pod *corev1.Pod) ([][]byte, error) {
+	secretAuths := make([][]byte, 0)
+	for _, pullSecret := range getPodImagePullSecrets(pod) {
+		auth, err := secrets.auth(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pullSecret})
+		if err != nil {
+			klog.Warningf("Error getting the auths of the secret %s/%s: %v", pod.Namespace, pullSecret, err)
+			continue
+		}
+		secretAuths = append(secretAuths, auth)
+	}
+	return secretAuths, nil
+}
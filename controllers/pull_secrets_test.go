@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// pullSecret returns a dockerconfigjson pull secret of the test namespace
+func pullSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`),
+		},
+	}
+}
+
+// podWithPullSecret returns a pod of the test namespace with the pull secret
+func podWithPullSecret(name, secret string) *corev1.Pod {
+	pod := podWithImages(name, "quay.io/org/app:v1")
+	pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: secret}}
+	return pod
+}
+
+// countSecretGets counts the reads of the secrets through the clientset
+func countSecretGets(clientset *kubefake.Clientset) *int {
+	gets := new(int)
+	clientset.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		*gets++
+		return false, nil, nil
+	})
+	return gets
+}
+
+var _ = Describe("The pull secrets cache", func() {
+	var (
+		clientset *kubefake.Clientset
+		gets      *int
+	)
+
+	BeforeEach(func() {
+		clientset = kubefake.NewSimpleClientset(pullSecret("pull-secret"))
+		gets = countSecretGets(clientset)
+	})
+
+	It("should read each pull secret once for the pods sharing it", func() {
+		secrets := NewPullSecretsCache(clientset.CoreV1(), time.Minute)
+		for i := 0; i < 3; i++ {
+			auths, err := pullSecretAuthList(context.Background(), secrets,
+				podWithPullSecret(fmt.Sprintf("pod-%d", i), "pull-secret"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(auths).To(ConsistOf(MatchJSON(`{"quay.io":{"auth":"dXNlcjpwYXNz"}}`)))
+		}
+		Expect(*gets).To(Equal(1))
+	})
+
+	It("should read the pull secrets again once their TTL expired", func() {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		secrets := newPullSecretsCache(clientset.CoreV1(), time.Minute, fakeClock)
+		pod := podWithPullSecret("pod", "pull-secret")
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		fakeClock.Step(30 * time.Second)
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		Expect(*gets).To(Equal(1))
+		fakeClock.Step(time.Minute)
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		Expect(*gets).To(Equal(2))
+	})
+
+	It("should not cache the pull secrets with a zero TTL", func() {
+		secrets := NewPullSecretsCache(clientset.CoreV1(), 0)
+		pod := podWithPullSecret("pod", "pull-secret")
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		Expect(*gets).To(Equal(2))
+	})
+
+	It("should skip and not cache the missing pull secrets", func() {
+		secrets := NewPullSecretsCache(clientset.CoreV1(), time.Minute)
+		pod := podWithPullSecret("pod", "missing")
+		auths, err := pullSecretAuthList(context.Background(), secrets, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auths).To(BeEmpty())
+		_, _ = pullSecretAuthList(context.Background(), secrets, pod)
+		Expect(*gets).To(Equal(2))
+	})
+
+	It("should read nothing without a client", func() {
+		auths, err := pullSecretAuthList(context.Background(), nil, podWithPullSecret("pod", "pull-secret"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auths).To(BeEmpty())
+	})
+})
+
+// BenchmarkPullSecretAuthList reads the pull secret of 200 pods of the same namespace sharing it, with and without the
+// cache
+func BenchmarkPullSecretAuthList(b *testing.B) {
+	pods := make([]*corev1.Pod, 200)
+	for i := range pods {
+		pods[i] = podWithPullSecret(fmt.Sprintf("pod-%d", i), "pull-secret")
+	}
+	for _, ttl := range []time.Duration{0, DefaultPullSecretsCacheTTL} {
+		b.Run(fmt.Sprintf("ttl=%s", ttl), func(b *testing.B) {
+			clientset := kubefake.NewSimpleClientset(pullSecret("pull-secret"))
+			gets := countSecretGets(clientset)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				secrets := NewPullSecretsCache(clientset.CoreV1(), ttl)
+				for _, pod := range pods {
+					if _, err := pullSecretAuthList(context.Background(), secrets, pod); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(*gets)/float64(b.N), "gets/op")
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ocpv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/testenv"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The manager-role is generated from the RBAC markers of all the packages, see the manifests target of the Makefile:
+// the requests of the components must be allowed by the markers, so that the role of the operator covers them.
+var _ = Describe("The RBAC markers of the manager", func() {
+	var (
+		ctx      context.Context
+		requests *testenv.APIRequests
+		c        client.WithWatch
+		pod      *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ocpv1.AddToScheme(scheme)).To(Succeed())
+		Expect(operatorv1alpha1.Install(scheme)).To(Succeed())
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		requests = &testenv.APIRequests{}
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test",
+			UID: "Deployment/app"}}
+		replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "test",
+			UID:             "ReplicaSet/app-1",
+			OwnerReferences: []metav1.OwnerReference{controllerReference("apps/v1", "Deployment", "app")}}}
+		pod = podWithImages("pod", "quay.io/org/app:v1")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		pod.OwnerReferences = []metav1.OwnerReference{controllerReference("apps/v1", "ReplicaSet", "app-1")}
+		c = testenv.RecordingClient(fake.NewClientBuilder().WithScheme(scheme).
+			WithInterceptorFuncs(listingMachineSets(nil)).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+			&ocpv1.Image{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}},
+			nodeWithCapacity("arm64-1", "arm64", "8", "32Gi", false),
+			&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "mutating"},
+				Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "a"}},
+			},
+			servingCertificateSecret("webhook-service.operator.svc"),
+			deployment, replicaSet, pod,
+		).Build(), requests)
+	})
+
+	AfterEach(func() {
+		rules, err := testenv.RBACRules("..")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests.List()).NotTo(BeEmpty())
+		for _, request := range requests.List() {
+			Expect(testenv.Allows(rules, request)).To(BeTrue(), "no RBAC marker allows the request %s", request)
+		}
+	})
+
+	It("should allow the placement of the pods", func() {
+		reconciler := &PodReconciler{
+			Client: c,
+			Inspector: &fakeArchitectures{
+				registry: map[string][]string{"//quay.io/org/app:v1": {"arm64"}},
+			},
+			Recorder:          record.NewFakeRecorder(10),
+			CapacityCache:     NewArchitectureCapacityCache(c, time.Minute),
+			ExtendedResources: NewExtendedResourcesIndex(c, time.Minute),
+			RecentDecisions:   NewRecentDecisions(c, time.Minute),
+			OwnerAnnotator:    NewOwnerAnnotator(c, nil, 0, nil),
+			DebugLogging:      core.NewDebugLogging(100, 100),
+		}
+		Expect(reconciler.CapacityCache.refresh(ctx)).To(Succeed())
+		Expect(reconciler.ExtendedResources.refresh(ctx)).To(Succeed())
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPod(c, pod).Spec.SchedulingGates).To(BeEmpty())
+		reconciler.OwnerAnnotator.flush(ctx)
+		Expect(reconciler.RecentDecisions.refresh(ctx)).To(Succeed())
+	})
+
+	It("should allow the admission of the pods", func() {
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(c.Scheme()))).To(Succeed())
+		admitted := podWithImages("admitted", "quay.io/org/app:v1")
+		raw, err := json.Marshal(admitted)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+	})
+
+	It("should allow the maintenance of the gated and placed pods", func() {
+		// the requeued pods are not consumed: the sweep stops at the first one once the context is canceled
+		sweepCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		NewGatedPodsSweeper(c, nil, time.Minute, 0).sweep(sweepCtx, sweepTriggerPeriodic, 0)
+		r := &NodeArchitecturesReconciler{
+			Client:        c,
+			CapacityCache: NewArchitectureCapacityCache(c, 0),
+			Recorder:      record.NewFakeRecorder(10),
+		}
+		Expect(r.CapacityCache.refresh(ctx)).To(Succeed())
+		_, err := r.Reconcile(ctx, nodeArchitecturesRequest)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = (&SingleArchitectureReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}).Reconcile(ctx,
+			singleArchitectureRequest)
+		Expect(err).NotTo(HaveOccurred())
+		// the pods whose architectures changed are reported, never deleted
+		rules, err := testenv.RBACRules("..")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testenv.Allows(rules, testenv.APIRequest{Resource: "pods", Verb: "delete"})).To(BeFalse())
+	})
+
+	It("should allow the webhook certificate issued by cert-manager", func() {
+		certificate, err := NewWebhookCertificate(c, types.NamespacedName{Namespace: "operator",
+			Name: "webhook-service"}, "serving-cert", "ca")
+		Expect(err).NotTo(HaveOccurred())
+		certificate.MutatingWebhookConfigurations = []string{"mutating"}
+		certificate.ValidatingWebhookConfigurations = []string{"validating"}
+		Expect(certificate.Request(ctx, time.Minute)).To(Succeed())
+	})
+
+	It("should allow the reports of the operator", func() {
+		reconciler := &ICSPMigrationReconciler{Client: c, Reader: c, Recorder: record.NewFakeRecorder(10),
+			ConfigMap: types.NamespacedName{Namespace: "openshift-multiarch-operator", Name: "icsp-migration"}}
+		_, err := reconciler.Reconcile(ctx, icspMigrationRequest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(NewBlockedRegistriesAnalyzer(c, record.NewFakeRecorder(10), 0).analyze(ctx,
+			blockedRegistriesChange{blocked: []string{"quay.io"}, added: []string{"quay.io"}})).To(Succeed())
+		request := httptest.NewRequest("GET", "/summary", nil)
+		request.Header.Set("Authorization", "Bearer token")
+		_, _ = (&requestAuthorizer{client: c}).authorize(ctx, request, authorizationv1.ResourceAttributes{})
+	})
+})
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// recentDecisionsBuckets is the number of the buckets of the window of the RecentDecisions: the decisions leave
+	// the window by bucket, i.e., up to window/recentDecisionsBuckets late
+	recentDecisionsBuckets = 60
+	// recentDecisionsMinBucketWidth is the minimum width of the buckets of the window
+	recentDecisionsMinBucketWidth = time.Second
+)
+
+// decisionsBucket counts the decisions observed from its start, by architecture
+type decisionsBucket struct {
+	start  time.Time
+	counts map[string]int
+}
+
+// RecentDecisions aggregates the architectures required by the placement decisions of the PodReconciler over a
+// sliding window, exported by the decisions_recent metric, along with the schedulable nodes of each architecture,
+// exported by the nodes metric, e.g., to compare the demand for an architecture with the nodes providing it.
+// A decision requiring several architectures counts for each of them.
+// It implements the manager.Runnable interface and is expected to be added to the manager.
+type RecentDecisions struct {
+	client      client.Reader
+	window      time.Duration
+	bucketWidth time.Duration
+	clock       clock.WithTicker
+	// buckets are the buckets of the window, the oldest first
+	buckets []decisionsBucket
+	// architectures are the architectures of the decisions exported so far: they are exported as zero once their
+	// decisions leave the window
+	architectures sets.Set[string]
+	// mutex is used to protect the buckets and the architectures from concurrent access
+	mutex sync.Mutex
+}
+
+// NewRecentDecisions returns the RecentDecisions aggregating the decisions over the window, counting the nodes read
+// through the client, e.g., the cached client of the manager
+func NewRecentDecisions(c client.Reader, window time.Duration) *RecentDecisions {
+	return newRecentDecisions(c, window, clock.RealClock{})
+}
+
+func newRecentDecisions(c client.Reader, window time.Duration, clk clock.WithTicker) *RecentDecisions {
+	bucketWidth := window / recentDecisionsBuckets
+	if bucketWidth < recentDecisionsMinBucketWidth {
+		bucketWidth = recentDecisionsMinBucketWidth
+	}
+	return &RecentDecisions{
+		client:        c,
+		window:        window,
+		bucketWidth:   bucketWidth,
+		clock:         clk,
+		architectures: sets.New[string](),
+	}
+}
+
+// Start refreshes the metrics every bucket width, expiring the decisions leaving the window, until the context is
+// done.
+func (d *RecentDecisions) Start(ctx context.Context) error {
+	ticker := d.clock.NewTicker(d.bucketWidth)
+	defer ticker.Stop()
+	for {
+		if err := d.refresh(ctx); err != nil {
+			klog.Warningf("unable to count the nodes by architecture: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// observe counts a decision requiring the architectures. It is a no-op on the nil RecentDecisions.
+func (d *RecentDecisions) observe(architectures []string) {
+	if d == nil || len(architectures) == 0 {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	start := d.clock.Now().Truncate(d.bucketWidth)
+	if n := len(d.buckets); n == 0 || d.buckets[n-1].start.Before(start) {
+		d.buckets = append(d.buckets, decisionsBucket{start: start, counts: map[string]int{}})
+	}
+	bucket := d.buckets[len(d.buckets)-1]
+	for _, arch := range architectures {
+		bucket.counts[arch]++
+	}
+	d.exportLocked()
+}
+
+// counts returns the decisions of the window by architecture
+func (d *RecentDecisions) counts() map[string]int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expireLocked()
+	return d.countsLocked()
+}
+
+// refresh exports the decisions of the window and the schedulable nodes by architecture
+func (d *RecentDecisions) refresh(ctx context.Context) error {
+	d.mutex.Lock()
+	d.exportLocked()
+	d.mutex.Unlock()
+	nodes := &corev1.NodeList{}
+	if err := d.client.List(ctx, nodes); err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for i := range nodes.Items {
+		if arch, ok := schedulableNodeArchitecture(&nodes.Items[i]); ok {
+			counts[arch]++
+		}
+	}
+	nodesByArchitecture.Reset()
+	for arch, count := range counts {
+		nodesByArchitecture.WithLabelValues(arch).Set(float64(count))
+	}
+	return nil
+}
+
+// expireLocked drops the buckets that left the window. The mutex must be held.
+func (d *RecentDecisions) expireLocked() {
+	cutoff := d.clock.Now().Add(-d.window)
+	expired := 0
+	for expired < len(d.buckets) && !d.buckets[expired].start.Add(d.bucketWidth).After(cutoff) {
+		expired++
+	}
+	d.buckets = d.buckets[expired:]
+}
+
+// countsLocked sums the buckets by architecture. The mutex must be held.
+func (d *RecentDecisions) countsLocked() map[string]int {
+	counts := map[string]int{}
+	for _, bucket := range d.buckets {
+		for arch, count := range bucket.counts {
+			counts[arch] += count
+		}
+	}
+	return counts
+}
+
+// exportLocked exports the decisions of the window. The mutex must be held.
+func (d *RecentDecisions) exportLocked() {
+	d.expireLocked()
+	counts := d.countsLocked()
+	for arch := range counts {
+		d.architectures.Insert(arch)
+	}
+	for arch := range d.architectures {
+		recentDecisions.WithLabelValues(arch).Set(float64(counts[arch]))
+	}
+}
+
+// observeRecentDecision counts the decision in the RecentDecisions, if set and the decision sets a node affinity
+func (r *PodReconciler) observeRecentDecision(decision placementDecision) {
+	if decision.requirement == nil {
+		return
+	}
+	r.RecentDecisions.observe(decision.requirement.Values)
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("The recent decisions", func() {
+	var (
+		fakeClock *clocktesting.FakeClock
+		decisions *RecentDecisions
+	)
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakeClock(time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC))
+		nodes := []corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "amd64-1", Labels: map[string]string{archLabel: "amd64"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "amd64-2", Labels: map[string]string{archLabel: "amd64"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "arm64-1", Labels: map[string]string{archLabel: "arm64"}}},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "arm64-2", Labels: map[string]string{archLabel: "arm64"}},
+				Spec:       corev1.NodeSpec{Unschedulable: true},
+			},
+		}
+		builder := fake.NewClientBuilder()
+		for i := range nodes {
+			builder = builder.WithObjects(&nodes[i])
+		}
+		decisions = newRecentDecisions(builder.Build(), 10*time.Minute, fakeClock)
+		DeferCleanup(func() {
+			recentDecisions.Reset()
+			nodesByArchitecture.Reset()
+		})
+	})
+
+	It("should count the architectures of the decisions in the window", func() {
+		decisions.observe([]string{"amd64", "arm64"})
+		fakeClock.Step(4 * time.Minute)
+		decisions.observe([]string{"arm64"})
+		decisions.observe(nil)
+		Expect(decisions.counts()).To(Equal(map[string]int{"amd64": 1, "arm64": 2}))
+		Expect(gaugeValue(recentDecisions.WithLabelValues("arm64"))).To(Equal(2.0))
+
+		fakeClock.Step(7 * time.Minute)
+		Expect(decisions.counts()).To(Equal(map[string]int{"arm64": 1}))
+		fakeClock.Step(4 * time.Minute)
+		Expect(decisions.counts()).To(BeEmpty())
+	})
+
+	It("should export the architectures whose decisions left the window as zero", func() {
+		decisions.observe([]string{"amd64"})
+		Expect(decisions.refresh(context.Background())).To(Succeed())
+		Expect(gaugeValue(recentDecisions.WithLabelValues("amd64"))).To(Equal(1.0))
+		fakeClock.Step(11 * time.Minute)
+		Expect(decisions.refresh(context.Background())).To(Succeed())
+		Expect(gaugeValue(recentDecisions.WithLabelValues("amd64"))).To(Equal(0.0))
+	})
+
+	It("should export the schedulable nodes by architecture", func() {
+		Expect(decisions.refresh(context.Background())).To(Succeed())
+		Expect(gaugeValue(nodesByArchitecture.WithLabelValues("amd64"))).To(Equal(2.0))
+		Expect(gaugeValue(nodesByArchitecture.WithLabelValues("arm64"))).To(Equal(1.0))
+	})
+
+	It("should not count the decisions without a node affinity", func() {
+		r := &PodReconciler{RecentDecisions: decisions}
+		r.observeRecentDecision(placementDecision{})
+		r.observeRecentDecision(placementDecision{requirement: &corev1.NodeSelectorRequirement{
+			Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"s390x"},
+		}})
+		Expect(decisions.counts()).To(Equal(map[string]int{"s390x": 1}))
+		(&PodReconciler{}).observeRecentDecision(placementDecision{requirement: &corev1.NodeSelectorRequirement{
+			Values: []string{"s390x"},
+		}})
+	})
+})
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/docker/reference"
+	ocpv1 "github.com/openshift/api/config/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// implicitTrustedPrefixes are trusted in addition to the trustedMultiArchPrefixes of the PodPlacementConfig
+	// objects, e.g., the repository of the release image of a multi-arch cluster
+	implicitTrustedPrefixes []trustedPrefix
+	// implicitTrustedPrefixesMutex is used to protect implicitTrustedPrefixes from concurrent access
+	implicitTrustedPrefixesMutex sync.RWMutex
+)
+
+// SetImplicitTrustedMultiArchPrefixes sets the prefixes trusted in addition to the trustedMultiArchPrefixes of the
+// PodPlacementConfig objects, with the same syntax. It is expected to be called once, before the pods are admitted.
+func SetImplicitTrustedMultiArchPrefixes(prefixes ...string) error {
+	parsed := make([]trustedPrefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		p, err := parseTrustedPrefix(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid trusted multi-arch prefix %q: %w", prefix, err)
+		}
+		parsed = append(parsed, p)
+	}
+	implicitTrustedPrefixesMutex.Lock()
+	defer implicitTrustedPrefixesMutex.Unlock()
+	implicitTrustedPrefixes = parsed
+	return nil
+}
+
+func currentImplicitTrustedPrefixes() []trustedPrefix {
+	implicitTrustedPrefixesMutex.RLock()
+	defer implicitTrustedPrefixesMutex.RUnlock()
+	return implicitTrustedPrefixes
+}
+
+//+kubebuilder:rbac:groups=config.openshift.io,resources=clusterversions,verbs=get
+
+// ReleaseImagePrefix returns the prefix of the images of the release payload of an OpenShift cluster, i.e., the
+// organization of the repository of its desired release image in the ClusterVersion object, e.g.,
+// quay.io/openshift-release-dev for quay.io/openshift-release-dev/ocp-release@sha256:..., that also hosts the
+// ocp-v4.0-art-dev repository of the images of the components. It returns an empty prefix when the payload is not
+// known to be multi-arch, i.e., when multiArchPayload is false and the desired update of the ClusterVersion object
+// does not set the Multi architecture. The ClusterVersion object does not report the architecture of the payload it
+// runs: multiArchPayload covers the clusters installed with a multi-arch payload.
+func ReleaseImagePrefix(ctx context.Context, reader client.Reader, multiArchPayload bool) (string, error) {
+	clusterVersion := &ocpv1.ClusterVersion{}
+	if err := reader.Get(ctx, client.ObjectKey{Name: "version"}, clusterVersion); err != nil {
+		return "", err
+	}
+	if !multiArchPayload && (clusterVersion.Spec.DesiredUpdate == nil ||
+		clusterVersion.Spec.DesiredUpdate.Architecture != ocpv1.ClusterVersionArchitectureMulti) {
+		return "", nil
+	}
+	releaseImage := clusterVersion.Status.Desired.Image
+	if releaseImage == "" {
+		return "", errors.New("the ClusterVersion object does not report its desired release image")
+	}
+	named, err := reference.ParseNormalizedNamed(releaseImage)
+	if err != nil {
+		return "", fmt.Errorf("invalid release image %q: %w", releaseImage, err)
+	}
+	organization, _, ok := strings.Cut(reference.Path(named), "/")
+	if !ok {
+		// the repository is at the root of the registry: only the repository itself is trusted
+		return named.Name(), nil
+	}
+	return reference.Domain(named) + "/" + organization, nil
+}
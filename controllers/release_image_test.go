@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ocpv1 "github.com/openshift/api/config/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const testReleaseImage = "quay.io/openshift-release-dev/ocp-release@sha256:" +
+	"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+var _ = Describe("The release image prefix", func() {
+	var c client.Client
+
+	clusterVersion := func(architecture ocpv1.ClusterVersionArchitecture, image string) *ocpv1.ClusterVersion {
+		cv := &ocpv1.ClusterVersion{ObjectMeta: metav1.ObjectMeta{Name: "version"}}
+		if architecture != "" {
+			cv.Spec.DesiredUpdate = &ocpv1.Update{Architecture: architecture, Version: "4.13.0"}
+		}
+		cv.Status.Desired.Image = image
+		return cv
+	}
+
+	newClient := func(objects ...client.Object) client.Client {
+		scheme := newTestScheme()
+		Expect(ocpv1.AddToScheme(scheme)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	}
+
+	It("should be the organization of the release image of the multi-arch payloads", func() {
+		c = newClient(clusterVersion(ocpv1.ClusterVersionArchitectureMulti, testReleaseImage))
+		Expect(ReleaseImagePrefix(context.Background(), c, false)).To(Equal("quay.io/openshift-release-dev"))
+	})
+
+	It("should be empty unless the payload is known to be multi-arch", func() {
+		c = newClient(clusterVersion("", testReleaseImage))
+		Expect(ReleaseImagePrefix(context.Background(), c, false)).To(BeEmpty())
+		Expect(ReleaseImagePrefix(context.Background(), c, true)).To(Equal("quay.io/openshift-release-dev"))
+	})
+
+	It("should be the repository of the release images at the root of their registry", func() {
+		c = newClient(clusterVersion("", "registry.example.com:5000/ocp-release:4.13.0-multi"))
+		Expect(ReleaseImagePrefix(context.Background(), c, true)).To(Equal("registry.example.com:5000/ocp-release"))
+	})
+
+	It("should fail without a release image or a ClusterVersion object", func() {
+		c = newClient(clusterVersion(ocpv1.ClusterVersionArchitectureMulti, ""))
+		_, err := ReleaseImagePrefix(context.Background(), c, false)
+		Expect(err).To(HaveOccurred())
+		_, err = ReleaseImagePrefix(context.Background(), newClient(), true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should let the pods using only the images of the release payload bypass the scheduling gate", func() {
+		c = newClient(clusterVersion(ocpv1.ClusterVersionArchitectureMulti, testReleaseImage))
+		prefix, err := ReleaseImagePrefix(context.Background(), c, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(SetImplicitTrustedMultiArchPrefixes(prefix)).To(Succeed())
+		DeferCleanup(SetImplicitTrustedMultiArchPrefixes)
+
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(newTestScheme()))).To(Succeed())
+		handle := func(images ...string) admission.Response {
+			raw, err := json.Marshal(podWithImages("pod", images...))
+			Expect(err).NotTo(HaveOccurred())
+			response := webhook.Handle(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			Expect(response.Allowed).To(BeTrue())
+			return response
+		}
+		Expect(handle("quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:" +
+			"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef").Patches).To(BeEmpty())
+		Expect(handle("quay.io/openshift-release-dev/ocp-v4.0-art-dev:4.13", "quay.io/org/app").Patches).
+			To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should reject the invalid implicit prefixes", func() {
+		Expect(SetImplicitTrustedMultiArchPrefixes("quay.io/org/app:v1")).NotTo(Succeed())
+	})
+})
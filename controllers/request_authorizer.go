@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// requestAuthorizer authorizes the requests of the HTTP endpoints of the operator: their bearer token is authenticated
+// by a TokenReview and the authenticated user must be allowed the action by a SubjectAccessReview, as the
+// kube-rbac-proxy does for the metrics endpoint.
+type requestAuthorizer struct {
+	client client.Client
+}
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// authorize returns nil if the request is allowed the action, or else the HTTP status code to answer with and the
+// reason: http.StatusUnauthorized when the token is missing or rejected, http.StatusForbidden when the user is not
+// allowed the action.
+func (a *requestAuthorizer) authorize(ctx context.Context, r *http.Request,
+	action authorizationv1.ResourceAttributes) (int, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return http.StatusUnauthorized, errors.New("the request has no bearer token")
+	}
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := a.client.Create(ctx, review); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("unable to review the token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, fmt.Errorf("the token is not valid: %s", review.Status.Error)
+	}
+	user := review.Status.User
+	accessReview := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &action,
+		User:               user.Username,
+		Groups:             user.Groups,
+		UID:                user.UID,
+		Extra:              subjectAccessReviewExtra(user.Extra),
+	}}
+	if err := a.client.Create(ctx, accessReview); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("unable to review the access of the user: %w", err)
+	}
+	if !accessReview.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("the user %s cannot %s %s in the namespace %s", user.Username,
+			action.Verb, action.Resource, action.Namespace)
+	}
+	return http.StatusOK, nil
+}
+
+// bearerToken returns the token of the Authorization header of the request
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func subjectAccessReviewExtra(extra map[string]authenticationv1.ExtraValue) map[string]authorizationv1.ExtraValue {
+	if extra == nil {
+		return nil
+	}
+	converted := make(map[string]authorizationv1.ExtraValue, len(extra))
+	for key, values := range extra {
+		converted[key] = authorizationv1.ExtraValue(values)
+	}
+	return converted
+}
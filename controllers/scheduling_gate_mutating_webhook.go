@@ -2,30 +2,184 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/decision"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/reasons"
 	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	// SchedulingGateName is the name of the Scheduling Gate
+	// schedulingGateName is the name of the scheduling gate of the default Instance
 	schedulingGateName = "multi-arch.openshift.io/scheduling-gate"
-)
 
-var schedulingGate = corev1.PodSchedulingGate{
-	Name: schedulingGateName,
-}
+	// archLabel is the node label reporting the architecture of the node
+	archLabel = "kubernetes.io/arch"
+	// betaArchLabel is the deprecated node label reporting the architecture of the node. Some old or edge nodes only
+	// set this one.
+	betaArchLabel = "beta.kubernetes.io/arch"
+
+	// supportedArchitecturesAnnotation reports the architectures supported by all the images of the pod.
+	// It is only set when the reconciler refines the node affinity by capacity.
+	supportedArchitecturesAnnotation = "multiarch.openshift.io/supported-architectures"
+	// capacityExcludedArchitecturesAnnotation reports the architectures compatible with the pod's images that the
+	// reconciler excluded from the node affinity because they had not enough allocatable capacity
+	capacityExcludedArchitecturesAnnotation = "multiarch.openshift.io/capacity-excluded-architectures"
+	// architecturesOverrideAnnotation declares the comma-separated list of the architectures supported by all the images
+	// of the pod, e.g., "arm64,amd64". When valid, the reconciler uses it instead of inspecting the images.
+	architecturesOverrideAnnotation = "multiarch.openshift.io/architectures"
+	// placementDecisionAnnotation records the component that finalized the placement of the pod. It is the guard
+	// between the webhook and the reconciler: the webhook does not gate the pods carrying it, e.g., when it is invoked
+	// again on a pod it already placed, and the reconciler only removes the scheduling gate of the gated ones, without
+	// inspecting their images nor changing their node affinity.
+	// The webhook sets it to placedByWebhook when it places a pod at admission, see
+	// PodSchedulingGateMutatingWebHook.ArchitecturesCache.
+	placementDecisionAnnotation = "multiarch.openshift.io/placement-decision"
+	// placementReasonAnnotation records the reason of the placement of the pod, one of the stable reasons of the
+	// reasons package, e.g., ArchitectureConstrained or OptedOut
+	placementReasonAnnotation = "multiarch.openshift.io/placement-reason"
+	// inspectedDigestsAnnotation records the digests of the images the reconciler inspected to place the pod, as the
+	// comma-separated list of their normalized references with the digest, e.g., "quay.io/org/app:v1@sha256:<hex>".
+	// The tags can move to other images between the inspection and the pull of the images.
+	inspectedDigestsAnnotation = "multiarch.openshift.io/inspected-digests"
+	// excludedContainersAnnotation records the comma-separated names of the containers of the pod excluded from its
+	// placement by the excludedContainerNames of its placement settings, e.g., "istio-proxy,istio-init"
+	excludedContainersAnnotation = "multiarch.openshift.io/excluded-containers"
+	// imageVolumesAnnotation records the image volumes of the pod, see KEP-4639, as the comma-separated list of their
+	// names and image references, e.g., "models=quay.io/org/models:v1". The webhook sets it from the raw pod, as the
+	// image volume source is missing from the API types of the operator, so that their images are inspected too.
+	imageVolumesAnnotation = "multiarch.openshift.io/image-volumes"
+	// decisionAnnotation records the placement decision of the pod as a versioned JSON document, see the decision
+	// package. It is set by the component deciding the placement, with the legacyDecisionAnnotations unless they are
+	// omitted.
+	decisionAnnotation = "multiarch.openshift.io/decision"
+	// placedByWebhook is the placementDecisionAnnotation value of the pods placed at admission
+	placedByWebhook = "webhook"
+	// placedByWorkloadTemplate is the placementDecisionAnnotation value of the pod templates of the workloads placed
+	// at admission, see WorkloadTemplateMutatingWebHook. The pods created from them inherit it: they are admitted
+	// without being gated.
+	placedByWorkloadTemplate = "workload-template"
+	// workloadTemplateArchitecturesAnnotation records the architectures of the node affinity requirement set on the
+	// pod templates of the workloads, e.g., "amd64,arm64", so that the requirement is replaced instead of being added
+	// again when the templates are updated
+	workloadTemplateArchitecturesAnnotation = "multiarch.openshift.io/workload-template-architectures"
+	// mutatedByAnnotation records the version of the operator that last mutated the pod, i.e., the webhook that gated
+	// or placed it, or the reconciler that placed it. It is only set when enabled, see
+	// PodSchedulingGateMutatingWebHook.MutatedBy and PodReconciler.MutatedBy.
+	mutatedByAnnotation = "multiarch.openshift.io/mutated-by"
+	// gatedAtAnnotation records the time the webhook gated the pod, in the RFC 3339 format. The latency of the
+	// placement is measured from it instead of the creation of the pod, see PodReconciler.observeSchedulingLatency. It
+	// is removed together with the scheduling gate, see Instance.removeSchedulingGate, and repaired by the
+	// GatedPodsSweeper when it drifts from it. It is only set when enabled, see
+	// PodSchedulingGateMutatingWebHook.RecordGatedAt.
+	gatedAtAnnotation = "multiarch.openshift.io/gated-at"
+	// ownerPlacementAnnotation summarizes the placement of the pods on their top-level owner, e.g., their Deployment,
+	// as the architectures supported by the last placed pod and the time of its placement, e.g.,
+	// "architectures=amd64,arm64;decided=2023-06-01T10:00:00Z". It is only set when enabled, see OwnerAnnotator.
+	ownerPlacementAnnotation = "multiarch.openshift.io/placement-summary"
+	// excludePodPlacementAnnotation excludes the pod from the placement when set to "true" on the pod or on the pod
+	// template of its ReplicaSet or Deployment: the webhook admits it unchanged and the reconciler removes its
+	// scheduling gate without node affinity. It is set on the pods of the operator and of its operands, whose
+	// admission must not wait for the operator to place them.
+	excludePodPlacementAnnotation = "multiarch.openshift.io/exclude-pod-placement"
+	// debugAnnotation is the namespace annotation enabling the decision traces of the pods of the namespace when set
+	// to "true", see core.DebugLogging
+	debugAnnotation = "multiarch.openshift.io/debug"
+)
 
 // +kubebuilder:webhook:path=/add-pod-scheduling-gate,mutating=true,sideEffects=None,admissionReviewVersions=v1,failurePolicy=ignore,groups="",resources=pods,verbs=create,versions=v1,name=pod-placement-scheduling-gate.multiarch.openshift.io
 // TODO: failurePolicy?
 
 // PodSchedulingGateMutatingWebHook annotates Pods
 type PodSchedulingGateMutatingWebHook struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client client.Client
+	// SkipGating disables the scheduling gate, e.g., when the API server does not support it and would reject the
+	// gated pods. The pods are then admitted unchanged and scheduled without the architecture-aware node affinity.
+	SkipGating bool
+	// ArchitecturesCache is optional. When set, the pods whose images all have their architectures cached get their
+	// node affinity at admission, instead of being gated until the reconciler inspects their images. Only the
+	// architectures that did not expire are used: the pods with images missing from the cache are gated.
+	ArchitecturesCache inspect.CachedInspector
+	// CapacityCache is optional, as the PodReconciler's one. It refines the node affinity set at admission.
+	CapacityCache *ArchitectureCapacityCache
+	// ExtendedResources is optional, as the PodReconciler's one. It refines the node affinity set at admission.
+	ExtendedResources *ExtendedResourcesIndex
+	// DebugLogging is optional. When set, the decisions for the pods of the namespaces with the debugAnnotation
+	// annotation are traced.
+	DebugLogging *core.DebugLogging
+	// PodPlacementConfigs is optional. When set, the PodPlacementConfig objects are read from its snapshot instead of
+	// the Client, and the pods admitted before the first snapshot are not gated.
+	PodPlacementConfigs *PodPlacementConfigSnapshot
+	// MutatedBy is optional. When set, it is the value of the mutatedByAnnotation annotation of the pods the webhook
+	// gates or places, e.g., the version of the operator.
+	MutatedBy string
+	// OmitLegacyAnnotations is true when the decisions of the pods placed at admission are only recorded by the
+	// decisionAnnotation annotation, without the legacyDecisionAnnotations.
+	OmitLegacyAnnotations bool
+	// RecordGatedAt records the time the pods are gated in their gatedAtAnnotation annotation, so that the latency of
+	// their placement excludes the time they spent in the admission before being gated.
+	RecordGatedAt bool
+	// Instance is optional. When set, the pods are gated with its scheduling gate, and its annotations are read and
+	// set instead of the default ones.
+	Instance *Instance
+	// GatedPodsIndex is optional. When set along with MaxGatedPodsPerNamespace, it counts the gated pods of the
+	// namespaces.
+	GatedPodsIndex *GatedPodsIndex
+	// MaxGatedPodsPerNamespace is optional. When positive, the pods of the namespaces with as many gated pods are
+	// admitted without the scheduling gate, with a warning, until the backlog drains, so that a controller creating
+	// pods that cannot be placed, e.g., whose images are pulled from an unreachable registry, does not grow it
+	// unbounded.
+	MaxGatedPodsPerNamespace int
+	// ExcludedNamespaces are the glob patterns of the namespaces whose pods are never gated, in addition to the
+	// protected ones, e.g., team-*-infra, see path.Match
+	ExcludedNamespaces []string
+	// ExemptServiceAccounts are the service accounts whose pods are never gated, even without the
+	// excludePodPlacementAnnotation annotation, e.g., the ones of the operator and of its operands: a pod of the
+	// operator gated while no other replica runs would wait for itself to be placed.
+	ExemptServiceAccounts []types.NamespacedName
+	// cappedNamespaces are the namespaces whose pods are not gated because of MaxGatedPodsPerNamespace, so that the cap
+	// is logged once when it engages and once when it releases
+	cappedNamespaces sync.Map
+	decoder          *admission.Decoder
+}
+
+// errNoPodPlacementConfigSnapshot is returned by podPlacementConfigs until the PodPlacementConfigSnapshot is ready
+var errNoPodPlacementConfigSnapshot = errors.New("the snapshot of the PodPlacementConfig objects is not ready")
+
+// podPlacementConfigs returns the PodPlacementConfig objects from the snapshot, if the webhook has one, or else from
+// the Client
+func (a *PodSchedulingGateMutatingWebHook) podPlacementConfigs(ctx context.Context) (*podPlacementConfigs, error) {
+	return admissionPodPlacementConfigs(ctx, a.PodPlacementConfigs, a.Client)
+}
+
+// admissionPodPlacementConfigs returns the PodPlacementConfig objects from the snapshot, if not nil, or else from the
+// reader
+func admissionPodPlacementConfigs(ctx context.Context, snapshot *PodPlacementConfigSnapshot,
+	c client.Reader) (*podPlacementConfigs, error) {
+	if snapshot != nil {
+		if configs, ok := snapshot.load(); ok {
+			return configs, nil
+		}
+		return nil, errNoPodPlacementConfigSnapshot
+	}
+	items, err := listPodPlacementConfigs(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return newPodPlacementConfigs(items), nil
 }
 
 func (a *PodSchedulingGateMutatingWebHook) InjectDecoder(d *admission.Decoder) error {
@@ -33,12 +187,23 @@ func (a *PodSchedulingGateMutatingWebHook) InjectDecoder(d *admission.Decoder) e
 	return nil
 }
 
+// patchedPodResponse returns the patch from the pod of the request to the mutated pod. The patch is computed against
+// the pod of the request decoded into the API types too, so that it never removes the fields unknown to them, e.g.,
+// the image volume source of the clusters more recent than the operator.
 func (a *PodSchedulingGateMutatingWebHook) patchedPodResponse(pod *corev1.Pod, req admission.Request) admission.Response {
+	original := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, original); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	marshaledOriginal, err := json.Marshal(original)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 	marshaledPod, err := json.Marshal(pod)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return admission.PatchResponseFromRaw(marshaledOriginal, marshaledPod)
 }
 
 func (a *PodSchedulingGateMutatingWebHook) Handle(ctx context.Context, req admission.Request) admission.Response {
@@ -48,29 +213,262 @@ func (a *PodSchedulingGateMutatingWebHook) Handle(ctx context.Context, req admis
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	// ignore the openshift-* namespace as those are infra components
-	if strings.HasPrefix(pod.Namespace, "openshift-") || strings.HasPrefix(pod.Namespace, "hypershift-") || strings.HasPrefix(pod.Namespace, "kube-") {
+	if a.SkipGating {
 		return a.patchedPodResponse(pod, req)
 	}
 
-	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-scheduling/3521-pod-scheduling-readiness
-	if pod.Spec.SchedulingGates == nil {
-		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{}
+	// ignore the openshift-* namespace as those are infra components, and the excluded ones. They are checked here, and
+	// not only by the namespaceSelector of the webhook configuration, so that a misconfigured selector does not gate
+	// their pods.
+	if isExcludedNamespace(pod.Namespace, a.ExcludedNamespaces) {
+		return a.patchedPodResponse(pod, req)
+	}
+
+	// the pods excluded by their annotation, e.g., the ones of the operator and of its operands, are never gated. The
+	// controllers copy the annotations of the pod templates to their pods: the ones of the templates annotated after
+	// the pods were created are released by the reconciler.
+	if a.Instance.isExcludedFromPlacement(pod) || a.isExempt(pod) {
+		klog.V(5).Infof("Not gating pod %s/%s: it is excluded from the placement", pod.Namespace, pod.Name)
+		return a.patchedPodResponse(pod, req)
+	}
+
+	// the pod was already placed or gated, e.g., the webhook is invoked again by the reinvocationPolicy IfNeeded after
+	// another webhook mutated the pod: the object is admitted as it is, so that the scheduling gate is never added
+	// twice, which the API server would reject, and the gates added in the meantime keep their order.
+	if a.Instance.hasPlacementDecision(pod) || a.Instance.hasSchedulingGate(pod) {
+		klog.V(5).Infof("Not mutating pod %s/%s again: it is already placed or gated", pod.Namespace, pod.Name)
+		return admission.Allowed("the pod is already placed or gated")
+	}
+
+	ctx = debugContext(ctx, a.Client, a.DebugLogging, a.Instance, pod)
+
+	// the images of the image volumes are placed as the ones of the containers. The pods that are not gated are
+	// admitted without the annotation recording them.
+	volumes, err := decodeImageVolumes(req.Object.Raw)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	unchanged := pod.DeepCopy()
+	a.Instance.setImageVolumes(pod, volumes)
+
+	// the admission does not wait for the PodPlacementConfig objects to be read: until their first snapshot, the pods
+	// are admitted unchanged, as when the webhook is unavailable.
+	configs, err := a.podPlacementConfigs(ctx)
+	if errors.Is(err, errNoPodPlacementConfigSnapshot) {
+		admissionsWithoutSnapshot.Inc()
+		klog.V(2).Infof("Not gating pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		core.DebugLog(ctx, "Not gating the pod: %v", err)
+		return a.patchedPodResponse(unchanged, req)
+	}
+
+	// no pod is mutated while the pod placement is paused. On errors, the pod is gated and the reconciler removes the
+	// gate if the pod placement is paused.
+	if err == nil && configs.pausedBy != "" {
+		klog.V(4).Infof("Not gating pod %s/%s: the pod placement is paused by the PodPlacementConfig %s",
+			pod.Namespace, pod.Name, configs.pausedBy)
+		core.DebugLog(ctx, "The pod placement is paused by the PodPlacementConfig %s: not gating the pod",
+			configs.pausedBy)
+		return a.patchedPodResponse(unchanged, req)
+	}
+
+	// the pods of the opted-out namespaces are not placed. On errors, the pod is gated and the reconciler reads the
+	// placement settings again.
+	var policy multiarchv1alpha1.PlacementPolicy
+	if err == nil {
+		policy, err = mergePlacementPolicy(ctx, a.Client, pod.Namespace, configs.items)
+	}
+	if err != nil {
+		klog.Warningf("Unable to get the placement settings for the namespace %s: %v", pod.Namespace, err)
+	} else {
+		debugLogPolicy(ctx, policy)
+		if isOptedOut(policy) {
+			core.DebugLog(ctx, "The pod is opted out of the placement: not gating it")
+			return a.patchedPodResponse(unchanged, req)
+		}
 	}
 
-	// if the gate is already present, do not try to patch (it would fail)
-	for _, schedulingGate := range pod.Spec.SchedulingGates {
-		if schedulingGate.Name == schedulingGateName {
+	// the images of the excluded containers neither restrict the architectures of the pod nor are inspected
+	placed := pod
+	if err == nil {
+		placed, _ = withoutExcludedContainers(pod, policy)
+	}
+
+	// the images known to be available for all the architectures do not need the inspection. The pods declaring their
+	// architectures are still gated, so that the reconciler applies the node affinity.
+	if configs != nil && !a.Instance.hasArchitecturesOverride(pod) &&
+		imagesMatchTrustedPrefixes(a.Instance, placed, configs.trustedPrefixes) {
+		core.DebugLog(ctx, "The pod only uses trusted multi-arch images: not gating it")
+		return a.patchedPodResponse(unchanged, req)
+	}
+
+	// the pods known to be impossible to run are denied in the strict admission mode, and the ones that might be are
+	// gated with a warning
+	var warnings []string
+	if err == nil && configs.denyImpossiblePods {
+		impossible := a.checkImpossible(ctx, placed, configs.items)
+		if impossible.allCached {
+			observeDecision(reasons.NoCommonArchitecture)
+			return admission.Denied(fmt.Sprintf("the pod cannot run on any node of the cluster: %s",
+				impossible.message))
+		}
+		if impossible.message != "" {
+			warnings = append(warnings, fmt.Sprintf("the pod might not run on any node of the cluster: %s",
+				impossible.message))
+		}
+	}
+
+	// the pods can only run on the architecture of the cluster if it has a single one: they are admitted without being
+	// gated, and recreated by the SingleArchitectureReconciler if they are not scheduled before another one joins
+	if err == nil && configs.skipSingleArchCluster {
+		if architecture, ok := a.singleArchitecture(ctx, configs.items); ok {
+			klog.V(4).Infof("Not gating pod %s/%s: the cluster only has nodes of the architecture %s", pod.Namespace,
+				pod.Name, architecture)
+			core.DebugLog(ctx, "The cluster only has nodes of the architecture %s: not gating the pod", architecture)
+			admitted := unchanged.DeepCopy()
+			a.Instance.markAdmittedOnSingleArchitecture(unchanged)
+			a.Instance.stampMutatedBy(unchanged, a.MutatedBy)
+			a.Instance.recordDecision(unchanged, admitted, decision.Decision{Reason: reasons.SingleArchitectureCluster,
+				MutatedBy: a.MutatedBy, Timestamp: time.Now()}, a.OmitLegacyAnnotations)
+			observeDecision(reasons.SingleArchitectureCluster)
+			response := a.patchedPodResponse(unchanged, req)
+			response.Warnings = warnings
+			return response
+		}
+	}
+
+	if err == nil {
+		if decision, ok := a.placeAtAdmission(ctx, pod, policy, configs.items); ok {
+			klog.V(4).Infof("Placing pod %s/%s at admission", pod.Namespace, pod.Name)
+			decision.apply(ctx, pod, a.Instance)
+			a.Instance.stampMutatedBy(pod, a.MutatedBy)
+			if document, ok := decision.document(a.Instance, time.Now(), a.MutatedBy); ok {
+				a.Instance.recordDecision(pod, unchanged, document, a.OmitLegacyAnnotations)
+			}
+			observeDecision(decision.reason)
 			return a.patchedPodResponse(pod, req)
 		}
 	}
 
-	pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, schedulingGate)
+	// the namespaces with too many gated pods get their next pods admitted unchanged, as when the webhook is
+	// unavailable, until the backlog drains
+	if a.gatedPodsCapExceeded(pod.Namespace) {
+		gatedPodsCapExceeded.WithLabelValues(pod.Namespace).Inc()
+		core.DebugLog(ctx, "The namespace has %d gated pods or more: not gating the pod", a.MaxGatedPodsPerNamespace)
+		response := a.patchedPodResponse(unchanged, req)
+		response.Warnings = append(warnings, fmt.Sprintf("the pod is not gated for its placement: the namespace has "+
+			"%d gated pods or more, it is scheduled without the node affinity for the architectures of its images",
+			a.MaxGatedPodsPerNamespace))
+		return response
+	}
+
+	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-scheduling/3521-pod-scheduling-readiness
+	if pod.Spec.SchedulingGates == nil {
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{}
+	}
+
+	core.DebugLog(ctx, "Gating the pod until the reconciler places it")
+	pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, a.Instance.schedulingGate())
+	if a.RecordGatedAt {
+		a.Instance.markGatedAt(pod, time.Now())
+	}
+	a.Instance.stampMutatedBy(pod, a.MutatedBy)
 
 	// Temporary workaround. TODO[aleskandro]: remove when kubernetes/kubernetes#118052 is fixed.
 	if pod.Spec.Affinity == nil {
 		pod.Spec.Affinity = &corev1.Affinity{}
 	}
 
-	return a.patchedPodResponse(pod, req)
+	response := a.patchedPodResponse(pod, req)
+	response.Warnings = warnings
+	return response
+}
+
+// isExempt returns true if the pod runs with one of the ExemptServiceAccounts
+func (a *PodSchedulingGateMutatingWebHook) isExempt(pod *corev1.Pod) bool {
+	for _, serviceAccount := range a.ExemptServiceAccounts {
+		if pod.Namespace == serviceAccount.Namespace && pod.Spec.ServiceAccountName == serviceAccount.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// gatedPodsCapExceeded returns true if the namespace has MaxGatedPodsPerNamespace gated pods or more, logging when
+// the cap of the namespace engages and releases
+func (a *PodSchedulingGateMutatingWebHook) gatedPodsCapExceeded(namespace string) bool {
+	if a.MaxGatedPodsPerNamespace <= 0 {
+		return false
+	}
+	gated := a.GatedPodsIndex.count(namespace)
+	if gated < a.MaxGatedPodsPerNamespace {
+		if _, capped := a.cappedNamespaces.LoadAndDelete(namespace); capped {
+			klog.Infof("Gating the pods of the namespace %s again: it has %d gated pods, less than %d", namespace,
+				gated, a.MaxGatedPodsPerNamespace)
+		}
+		return false
+	}
+	if _, capped := a.cappedNamespaces.LoadOrStore(namespace, struct{}{}); !capped {
+		klog.Warningf("Not gating the pods of the namespace %s until its backlog drains: it has %d gated pods, the "+
+			"maximum is %d", namespace, gated, a.MaxGatedPodsPerNamespace)
+	}
+	return true
+}
+
+// placeAtAdmission returns the placement decision for the pod if the architectures of all its images are cached. ok is
+// false when the pod has to be gated for the reconciler to place it, including when the policy allows none of the
+// cached architectures or the pod selects the keys of the additional node selector terms: the reconciler reports it.
+func (a *PodSchedulingGateMutatingWebHook) placeAtAdmission(ctx context.Context, pod *corev1.Pod,
+	policy multiarchv1alpha1.PlacementPolicy,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (decision placementDecision, ok bool) {
+	if a.ArchitecturesCache == nil || a.Instance.hasArchitecturesOverride(pod) {
+		return placementDecision{}, false
+	}
+	// the pods whose containers are all excluded are left to the reconciler, that removes their scheduling gate only
+	placed, excluded := withoutExcludedContainers(pod, policy)
+	if len(a.Instance.podImageReferences(placed)) == 0 {
+		return placementDecision{}, false
+	}
+	supportedArchitectures, ok := cachedArchitectures(ctx, a.ArchitecturesCache, a.Instance, placed)
+	if !ok {
+		return placementDecision{}, false
+	}
+	decision, ok = placeRequirement(ctx, a.Client, a.CapacityCache, a.ExtendedResources, a.Instance, pod, policy,
+		corev1.NodeSelectorRequirement{
+			Key:      archLabel,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   sets.List(supportedArchitectures),
+		})
+	if !ok {
+		return placementDecision{}, false
+	}
+	decision.additionalRequirements = additionalNodeSelectorRequirements(podPlacementConfigs)
+	additional := decision.additionalRequirementsOf(*decision.requirement)
+	if _, conflicts := withoutSelectedKeys(pod, additional); len(conflicts) > 0 {
+		core.DebugLog(ctx, "The pod selects the keys %v of the additional node selector terms", conflicts)
+		return placementDecision{}, false
+	}
+	if len(excluded) > 0 {
+		decision.annotations[a.Instance.annotation(excludedContainersAnnotation)] = strings.Join(excluded, ",")
+	}
+	decision.annotations[a.Instance.annotation(placementDecisionAnnotation)] = placedByWebhook
+	return decision, true
+}
+
+// cachedArchitectures returns the architectures supported by all the images of the pod, if the architectures of all of
+// them are cached
+func cachedArchitectures(ctx context.Context, cache inspect.CachedInspector, instance *Instance,
+	pod *corev1.Pod) (supportedArchitectures sets.Set[string], ok bool) {
+	for imageName := range podImageNames(instance, pod) {
+		result, ok := cache.Cached(imageName)
+		if !ok {
+			klog.V(4).Infof("The architectures of the image %s of pod %s/%s are not cached", imageName,
+				pod.Namespace, pod.Name)
+			core.DebugLog(ctx, "The architectures of the image %s are not cached", imageName)
+			return nil, false
+		}
+		architectures := result.Architectures()
+		core.DebugLog(ctx, "The cached architectures of the image %s are %v", imageName, sets.List(architectures))
+		supportedArchitectures = intersectArchitectures(supportedArchitectures, architectures)
+	}
+	return supportedArchitectures, true
 }
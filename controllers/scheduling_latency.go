@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// schedulingLatencyPlaced is the result of the pods whose scheduling gate was removed with a node affinity
+	schedulingLatencyPlaced = "placed"
+	// schedulingLatencyGateRemoved is the result of the pods whose scheduling gate was removed without a node
+	// affinity, e.g., when their images cannot be inspected and the failure policy is Ignore
+	schedulingLatencyGateRemoved = "gate_removed"
+	// schedulingLatencyPaused is the result of the pods whose scheduling gate was removed because the pod placement
+	// is paused
+	schedulingLatencyPaused = "paused"
+)
+
+// gatedSince returns the time the pod started waiting for the operator: the time the webhook gated it, when recorded
+// and valid, or else the creation of the pod. The time the pod spent in the admission before the webhook gated it,
+// e.g., when it is gated by a reinvocation after the other webhooks, is not counted.
+func (i *Instance) gatedSince(pod *corev1.Pod) time.Time {
+	since := pod.CreationTimestamp.Time
+	if value, ok := pod.Annotations[i.annotation(gatedAtAnnotation)]; ok {
+		if gatedAt, err := time.Parse(time.RFC3339, value); err == nil && gatedAt.After(since) {
+			return gatedAt
+		}
+	}
+	return since
+}
+
+// imagesCached returns true if the architectures of all the images of the pod are cached by the inspector, i.e., the
+// pod can be placed without inspecting any image in its registry
+func (r *PodReconciler) imagesCached(pod *corev1.Pod) bool {
+	inspector := r.inspector()
+	for imageName := range podImageNames(r.Instance, pod) {
+		if _, ok := inspector.Cached(imageName); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// observeSchedulingLatency observes the time the pod, as read before its scheduling gate was removed, waited for the
+// removal, see gatedSince, and logs a warning when it exceeds the LatencyWarningThreshold
+func (r *PodReconciler) observeSchedulingLatency(pod *corev1.Pod, result string, cacheHit bool) {
+	latency := r.passiveClock().Since(r.Instance.gatedSince(pod))
+	if latency < 0 {
+		latency = 0
+	}
+	schedulingGateLatency.WithLabelValues(strconv.FormatBool(cacheHit), result).Observe(latency.Seconds())
+	if r.LatencyWarningThreshold > 0 && latency > r.LatencyWarningThreshold {
+		klog.Warningf("The scheduling gate of pod %s/%s was removed after %s, more than %s: result %s, cache hit %t",
+			pod.Namespace, pod.Name, latency.Round(time.Millisecond), r.LatencyWarningThreshold, result, cacheHit)
+	}
+}
+
+// passiveClock returns the clock of the reconciler, or the real clock if it is not set. It does not set the clock: the
+// reconciles run concurrently.
+func (r *PodReconciler) passiveClock() clock.PassiveClock {
+	if r.clock != nil {
+		return r.clock
+	}
+	return clock.RealClock{}
+}
+
+// schedulingLatencyResult returns the result of the removal of the scheduling gate by the decision
+func schedulingLatencyResult(decision placementDecision) string {
+	if decision.requirement == nil {
+		return schedulingLatencyGateRemoved
+	}
+	return schedulingLatencyPlaced
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// latencySamples returns the number and the sum of the latencies observed for the labels
+func latencySamples(cacheHit, result string) (uint64, float64) {
+	m := &dto.Metric{}
+	Expect(schedulingGateLatency.WithLabelValues(cacheHit, result).(prometheus.Metric).Write(m)).To(Succeed())
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+var _ = Describe("The scheduling gate latency", func() {
+	var (
+		c          client.Client
+		fakeClock  *clocktesting.FakeClock
+		reconciler *PodReconciler
+	)
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		fakeClock = clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+		reconciler = &PodReconciler{Client: c, clock: fakeClock, Inspector: &fakeArchitectures{
+			cached:   map[string][]string{"//quay.io/org/cached:v1": {"amd64", "arm64"}},
+			registry: map[string][]string{"//quay.io/org/cached:v1": {"amd64", "arm64"}, "//quay.io/org/app:v1": {"amd64"}},
+		}}
+	})
+
+	gatedPod := func(name, image string, age time.Duration) *corev1.Pod {
+		pod := podWithImages(name, image)
+		pod.CreationTimestamp = metav1.NewTime(fakeClock.Now().Add(-age))
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		return pod
+	}
+
+	reconcile := func(pod *corev1.Pod) {
+		Expect(c.Create(context.Background(), pod)).To(Succeed())
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should be measured from the time the webhook gated the pod when recorded", func() {
+		pod := gatedPod("pod", "quay.io/org/cached:v1", time.Minute)
+		var instance *Instance
+		Expect(instance.gatedSince(pod)).To(Equal(pod.CreationTimestamp.Time))
+		instance.markGatedAt(pod, fakeClock.Now().Add(-20*time.Second))
+		Expect(instance.gatedSince(pod)).To(BeTemporally("==", fakeClock.Now().Add(-20*time.Second)))
+	})
+
+	It("should be measured from the creation of the pod when the gating time is invalid", func() {
+		pod := gatedPod("pod", "quay.io/org/cached:v1", time.Minute)
+		pod.Annotations = map[string]string{gatedAtAnnotation: "yesterday"}
+		var instance *Instance
+		Expect(instance.gatedSince(pod)).To(Equal(pod.CreationTimestamp.Time))
+		instance.markGatedAt(pod, fakeClock.Now().Add(-2*time.Minute))
+		Expect(instance.gatedSince(pod)).To(Equal(pod.CreationTimestamp.Time))
+	})
+
+	It("should be observed by cache hit and result when the scheduling gate is removed", func() {
+		hits, hitsSum := latencySamples("true", schedulingLatencyPlaced)
+		misses, _ := latencySamples("false", schedulingLatencyPlaced)
+		reconcile(gatedPod("cached", "quay.io/org/cached:v1", 30*time.Second))
+		count, sum := latencySamples("true", schedulingLatencyPlaced)
+		Expect(count).To(Equal(hits + 1))
+		Expect(sum - hitsSum).To(BeNumerically("~", 30, 0.001))
+
+		reconcile(gatedPod("inspected", "quay.io/org/app:v1", time.Second))
+		count, _ = latencySamples("false", schedulingLatencyPlaced)
+		Expect(count).To(Equal(misses + 1))
+	})
+
+	It("should be observed for the pods whose scheduling gate is removed without a node affinity", func() {
+		removed, _ := latencySamples("false", schedulingLatencyGateRemoved)
+		reconcile(gatedPod("missing", "quay.io/org/missing:v1", time.Second))
+		count, _ := latencySamples("false", schedulingLatencyGateRemoved)
+		Expect(count).To(Equal(removed + 1))
+	})
+
+	It("should be recorded by the webhook when enabled", func() {
+		scheme := newTestScheme()
+		webhook := &PodSchedulingGateMutatingWebHook{Client: c, RecordGatedAt: true}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		raw, err := json.Marshal(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		annotations := map[string]string{}
+		patchedValue(response, "/metadata/annotations", &annotations)
+		Expect(annotations).To(HaveKey(gatedAtAnnotation))
+		gatedAt, err := time.Parse(time.RFC3339, annotations[gatedAtAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gatedAt).To(BeTemporally("~", time.Now(), time.Minute))
+	})
+})
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// singleArchitectureRequest is the only request of the SingleArchitectureReconciler
+var singleArchitectureRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "single-architecture"}}
+
+// singleArchitecture returns the architecture of the cluster, see clusterArchitectures, if it is the only one. ok is
+// false when the cluster has several architectures or they are not known.
+func (a *PodSchedulingGateMutatingWebHook) singleArchitecture(ctx context.Context,
+	podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) (architecture string, ok bool) {
+	architectures, ok := a.clusterArchitectures(ctx, podPlacementConfigs)
+	if !ok || architectures.Len() != 1 {
+		return "", false
+	}
+	return sets.List(architectures)[0], true
+}
+
+// markAdmittedOnSingleArchitecture records that the pod was admitted without being gated because the cluster only had
+// nodes of a single architecture. The placementDecisionAnnotation keeps the webhook from gating it if it is invoked
+// again on the pod.
+func (i *Instance) markAdmittedOnSingleArchitecture(pod *corev1.Pod) {
+	setPodAnnotation(pod, i.annotation(placementDecisionAnnotation), placedByWebhook)
+	setPodAnnotation(pod, i.annotation(placementReasonAnnotation), reasons.SingleArchitectureCluster)
+}
+
+// admittedOnSingleArchitecture returns true if the pod was admitted without being gated because the cluster only had
+// nodes of a single architecture
+func (i *Instance) admittedOnSingleArchitecture(pod *corev1.Pod) bool {
+	return i.placementReason(pod) == reasons.SingleArchitectureCluster
+}
+
+// ClusterArchitectures returns the sorted architectures of the schedulable nodes of the cluster and, when the
+// PodPlacementConfig objects consider the autoscaled capacity, of the scalable MachineSets provisionable according to
+// the capacity cache, which is optional. It is empty when they are not known.
+func ClusterArchitectures(ctx context.Context, c client.Reader, capacity *ArchitectureCapacityCache) ([]string,
+	error) {
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	architectures, err := clusterArchitectures(ctx, c, capacity, podPlacementConfigs)
+	if err != nil {
+		return nil, err
+	}
+	return sets.List(architectures), nil
+}
+
+// SingleArchitectureReconciler reports the pods the webhook admitted without gating them while the cluster only had
+// nodes of a single architecture, see the skipSingleArchCluster field of the PodPlacementConfig, once the cluster has
+// several architectures: the pods that are still pending and not scheduled are reported once through a Warning event,
+// as their node affinity can only be set when they are recreated, e.g., by their owner or by the user. The creation of
+// these pods triggers a reconcile too, so that the pods admitted while the node of another architecture joins the
+// cluster are not missed. The pods are never deleted by the operator.
+type SingleArchitectureReconciler struct {
+	client.Client
+	// CapacityCache is optional. When set, the architectures of the scalable MachineSets count as architectures of the
+	// cluster if the PodPlacementConfig objects consider the autoscaled capacity, as for the webhook.
+	CapacityCache *ArchitectureCapacityCache
+	Recorder      record.EventRecorder
+	// Instance is optional. When set, only the pods it admitted are reported.
+	Instance *Instance
+	// reported are the UIDs of the pods already reported, among the ones still pending
+	reported sets.Set[types.UID]
+}
+
+// Reconcile reports the pods admitted without being gated when the cluster has several architectures
+func (r *SingleArchitectureReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	architectures, err := clusterArchitectures(ctx, r.Client, r.CapacityCache, podPlacementConfigs)
+	if err != nil || architectures.Len() < 2 {
+		return ctrl.Result{}, err
+	}
+	if pausedBy := placementPausedBy(podPlacementConfigs); pausedBy != "" {
+		// the pods would not be gated when recreated
+		klog.V(4).Infof("Not reporting the pods admitted on a single architecture: the pod placement is paused by "+
+			"the PodPlacementConfig %s", pausedBy)
+		return ctrl.Result{}, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods); err != nil {
+		return ctrl.Result{}, err
+	}
+	message := fmt.Sprintf("The pod was admitted without being gated while the cluster had a single architecture, "+
+		"and the cluster now has the architectures %s", strings.Join(sets.List(architectures), ","))
+	pending := sets.New[types.UID]()
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !r.isAdmittedOnSingleArchitectureAndUnscheduled(pod) {
+			continue
+		}
+		pending.Insert(pod.UID)
+		if r.reported.Has(pod.UID) {
+			continue
+		}
+		klog.V(3).Infof("%s: pod %s/%s", message, pod.Namespace, pod.Name)
+		r.Recorder.Event(pod, corev1.EventTypeWarning, reasons.ArchitecturesChanged,
+			versionedEventMessage(message+". Recreate the pod to set its node affinity"))
+	}
+	r.reported = pending
+	return ctrl.Result{}, nil
+}
+
+// isAdmittedOnSingleArchitectureAndUnscheduled returns true if the pod was admitted without being gated because the
+// cluster had a single architecture, is pending and is not bound to a node yet
+func (r *SingleArchitectureReconciler) isAdmittedOnSingleArchitectureAndUnscheduled(pod *corev1.Pod) bool {
+	return r.Instance.admittedOnSingleArchitecture(pod) && pod.Status.Phase == corev1.PodPending &&
+		pod.Spec.NodeName == "" && pod.DeletionTimestamp == nil
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the node events that can change the architectures of
+// the cluster and the creations of the pods admitted on a single architecture are processed.
+func (r *SingleArchitectureReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{singleArchitectureRequest}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("single-architecture").
+		Watches(&corev1.Node{}, enqueue, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldArch, oldOk := schedulableNodeArchitecture(e.ObjectOld.(*corev1.Node))
+				newArch, newOk := schedulableNodeArchitecture(e.ObjectNew.(*corev1.Node))
+				return oldArch != newArch || oldOk != newOk
+			},
+		})).
+		Watches(&corev1.Pod{}, enqueue, builder.WithPredicates(r.podPredicate())).
+		Complete(r)
+}
+
+// podPredicate only processes the creations of the pods admitted on a single architecture
+func (r *SingleArchitectureReconciler) podPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return r.Instance.admittedOnSingleArchitecture(e.Object.(*corev1.Pod))
+		},
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/reasons"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("The pods of the single-architecture clusters", func() {
+	var (
+		ctx        context.Context
+		c          client.Client
+		config     *multiarchv1alpha1.PodPlacementConfig
+		webhook    *PodSchedulingGateMutatingWebHook
+		reconciler *SingleArchitectureReconciler
+		recorder   *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{SkipSingleArchCluster: true},
+		}
+		scheme := newTestScheme()
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(config,
+			nodeWithCapacity("amd64-1", "amd64", "8", "32Gi", false),
+			nodeWithCapacity("arm64-unschedulable", "arm64", "8", "32Gi", true)).Build()
+		webhook = &PodSchedulingGateMutatingWebHook{Client: c}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &SingleArchitectureReconciler{Client: c, Recorder: recorder}
+	})
+
+	admit := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		return webhook.Handle(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+	}
+
+	// createAdmitted creates the pending pod admitted by the webhook
+	createAdmitted := func(name string) *corev1.Pod {
+		pod := podWithImages(name, "quay.io/org/app:v1")
+		response := admit(pod)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).NotTo(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		patchedValue(response, "/metadata/annotations", &pod.Annotations)
+		pod.UID = types.UID(name + "-uid")
+		pod.Status.Phase = corev1.PodPending
+		Expect(c.Create(ctx, pod)).To(Succeed())
+		return pod
+	}
+
+	addNode := func(name, arch string) {
+		Expect(c.Create(ctx, nodeWithCapacity(name, arch, "8", "32Gi", false))).To(Succeed())
+	}
+
+	updateConfig := func(update func(spec *multiarchv1alpha1.PodPlacementConfigSpec)) {
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(config), config)).To(Succeed())
+		update(&config.Spec)
+		Expect(c.Update(ctx, config)).To(Succeed())
+	}
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, singleArchitectureRequest)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// podsCount returns the number of pods, as they are never deleted
+	podsCount := func() int {
+		pods := &corev1.PodList{}
+		Expect(c.List(ctx, pods)).To(Succeed())
+		return len(pods.Items)
+	}
+
+	It("should not gate the pods while the cluster has a single architecture", func() {
+		pod := createAdmitted("pod")
+		Expect(pod.Annotations).To(HaveKeyWithValue(placementReasonAnnotation, reasons.SingleArchitectureCluster))
+		Expect(pod.Annotations).To(HaveKeyWithValue(placementDecisionAnnotation, placedByWebhook))
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should gate the pods once the cluster has several architectures", func() {
+		addNode("arm64-1", "arm64")
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should gate the pods when no PodPlacementConfig skips the single-architecture clusters", func() {
+		updateConfig(func(spec *multiarchv1alpha1.PodPlacementConfigSpec) { spec.SkipSingleArchCluster = false })
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should gate the pods when the autoscaled capacity adds an architecture", func() {
+		updateConfig(func(spec *multiarchv1alpha1.PodPlacementConfigSpec) { spec.ConsiderAutoscaledCapacity = true })
+		webhook.CapacityCache = &ArchitectureCapacityCache{autoscaled: sets.New("arm64")}
+		response := admit(podWithImages("pod", "quay.io/org/app:v1"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should stop gating the pods when the nodes of the other architectures leave the cluster", func() {
+		addNode("arm64-1", "arm64")
+		Expect(admit(podWithImages("gated", "quay.io/org/app:v1")).Patches).To(
+			ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		Expect(c.Delete(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "arm64-1"}})).To(Succeed())
+		createAdmitted("pod")
+	})
+
+	It("should report the pending pods admitted without being gated when another architecture joins", func() {
+		createAdmitted("pending")
+		scheduled := createAdmitted("scheduled")
+		scheduled.Spec.NodeName = "amd64-1"
+		Expect(c.Update(ctx, scheduled)).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+
+		addNode("arm64-1", "arm64")
+		reconcile()
+		Expect(podsCount()).To(Equal(2))
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(ContainSubstring("Warning " + reasons.ArchitecturesChanged))
+		// the pods are reported once
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should report the pods admitted without being gated while another architecture joins", func() {
+		// the webhook does not see the arm64 node yet when it admits the pod, and the reconcile triggered by the node
+		// does not see the pod yet
+		pod := podWithImages("racing", "quay.io/org/app:v1")
+		response := admit(pod)
+		Expect(response.Patches).NotTo(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+		addNode("arm64-1", "arm64")
+		reconcile()
+
+		patchedValue(response, "/metadata/annotations", &pod.Annotations)
+		pod.UID = "racing-uid"
+		pod.Status.Phase = corev1.PodPending
+		Expect(c.Create(ctx, pod)).To(Succeed())
+		// the creation of the pod triggers a reconcile
+		Expect(reconciler.podPredicate().Create(event.CreateEvent{Object: pod})).To(BeTrue())
+		reconcile()
+		Expect(podsCount()).To(Equal(1))
+		Expect(recorder.Events).To(HaveLen(1))
+		// the pod is gated when recreated
+		Expect(admit(podWithImages("racing", "quay.io/org/app:v1")).Patches).To(
+			ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should not report the pods while the pod placement is paused", func() {
+		createAdmitted("pod")
+		updateConfig(func(spec *multiarchv1alpha1.PodPlacementConfigSpec) { spec.Paused = true })
+		addNode("arm64-1", "arm64")
+		reconcile()
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("should only be triggered by the creation of the pods admitted without being gated", func() {
+		Expect(reconciler.podPredicate().Create(event.CreateEvent{
+			Object: podWithImages("pod", "quay.io/org/app:v1")})).To(BeFalse())
+	})
+})
@@ -17,19 +17,25 @@ limitations under the License.
 package controllers
 
 import (
-	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	multiarchcontrollers "multiarch-operator/controllers/multiarch"
+	"multiarch-operator/pkg/testenv"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -38,7 +44,38 @@ import (
 
 var cfg *rest.Config
 var k8sClient client.Client
-var testEnv *envtest.Environment
+var harness *testenv.Harness
+
+// envtestNamespaceLabel labels the namespaces whose pods are gated by the webhook of the harness. The pods of the
+// other namespaces, e.g., the ones of the specs calling the reconciler directly, are not.
+const envtestNamespaceLabel = "multiarch.openshift.io/envtest"
+
+// envtestInstance is the Instance of the webhook and of the reconciler of the harness: they only place the pods
+// carrying its scheduling gate, so that they never race with the specs placing the default-gated pods themselves.
+var envtestInstance = &Instance{
+	SchedulingGateName: "envtest.multiarch.openshift.io/scheduling-gate",
+	AnnotationPrefix:   "envtest.multiarch.openshift.io",
+}
+
+// envtestArchitectures are the architectures of the images known to the webhook and to the reconciler of the harness:
+// the images missing from its registry are not found.
+var envtestArchitectures = &fakeArchitectures{
+	cached: map[string][]string{"//quay.io/org/arm64-only:v1": {"arm64"}},
+	registry: map[string][]string{
+		"//quay.io/org/app:v1":        {"amd64", "arm64"},
+		"//quay.io/org/arm64-only:v1": {"arm64"},
+	},
+}
+
+// newTestScheme returns the scheme of the fake clients of the unit tests, with the core and apps APIs and the ones of
+// the operator
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+	Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -49,31 +86,52 @@ func TestAPIs(t *testing.T) {
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
-	By("bootstrapping test environment")
-	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
-		ErrorIfCRDPathMissing: false,
+	if !testenv.Available() {
+		// The unit tests in this suite use the fake client and do not need the test environment.
+		By("skipping the test environment bootstrap: KUBEBUILDER_ASSETS is not set")
+		return
 	}
-
+	By("bootstrapping test environment")
 	var err error
-	// cfg is defined in this file globally.
-	cfg, err = testEnv.Start()
-	Expect(err).NotTo(HaveOccurred())
-	Expect(cfg).NotTo(BeNil())
-
-	err = corev1.AddToScheme(scheme.Scheme)
+	harness, err = testenv.Start(testenv.Options{
+		RootDir: "..",
+		MutatingNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{
+			envtestNamespaceLabel: "true",
+		}},
+	})
 	Expect(err).NotTo(HaveOccurred())
+	// cfg and k8sClient are defined in this file globally.
+	cfg = harness.Config
+	k8sClient = harness.Client
 
 	//+kubebuilder:scaffold:scheme
 
-	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
-	Expect(err).NotTo(HaveOccurred())
-	Expect(k8sClient).NotTo(BeNil())
-
+	mgr := harness.Manager
+	Expect((&PodReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("multiarch-operator"),
+		Inspector: envtestArchitectures,
+		Instance:  envtestInstance,
+	}).SetupWithManager(mgr)).To(Succeed())
+	webhookHandler := &PodSchedulingGateMutatingWebHook{
+		Client:             mgr.GetClient(),
+		ArchitecturesCache: envtestArchitectures,
+		Instance:           envtestInstance,
+	}
+	Expect(webhookHandler.InjectDecoder(admission.NewDecoder(mgr.GetScheme()))).To(Succeed())
+	mgr.GetWebhookServer().Register(DefaultSchedulingGateWebhookPath, &webhook.Admission{Handler: webhookHandler})
+	Expect((&multiarchcontrollers.PodPlacementConfigValidator{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&multiarchcontrollers.PodPlacementPolicyValidator{
+		Reader: mgr.GetAPIReader(),
+	}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect(harness.StartManager()).To(Succeed())
 })
 
 var _ = AfterSuite(func() {
+	if harness == nil {
+		return
+	}
 	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).NotTo(HaveOccurred())
+	Expect(harness.Stop()).To(Succeed())
 })
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/system_config"
+	"multiarch-operator/pkg/version"
+)
+
+const (
+	// SummaryPath is the path of the endpoint of the SummaryServer
+	SummaryPath = "/v1/summary"
+	// SummaryAPIVersion is the version of the Summary document
+	SummaryAPIVersion = "summary.multiarch.openshift.io/v1"
+	// summaryCacheTTL is the time the Summary is served from its cache, bounding the lists of the nodes and pods
+	// whatever the rate of the requests
+	summaryCacheTTL = time.Second
+)
+
+const (
+	// SummaryModeActive is the mode of the operator gating and placing the pods
+	SummaryModeActive = "Active"
+	// SummaryModePaused is the mode of the operator while the pod placement is paused by a PodPlacementConfig
+	SummaryModePaused = "Paused"
+	// SummaryModeSchedulingGatesUnsupported is the mode of the operator when the cluster does not support the pod
+	// scheduling gates: the pods are not gated
+	SummaryModeSchedulingGatesUnsupported = "SchedulingGatesUnsupported"
+)
+
+// Summary is the state of the operator, e.g., for the console plugin to display it
+type Summary struct {
+	// APIVersion is the version of the document, i.e., SummaryAPIVersion
+	APIVersion string `json:"apiVersion"`
+	// OperatorVersion is the version of the operator serving the document
+	OperatorVersion string `json:"operatorVersion"`
+	// OperatorCommit is the git commit the operator serving the document was built from
+	OperatorCommit string `json:"operatorCommit"`
+	// OperatorBuildDate is the date of the build of the operator serving the document
+	OperatorBuildDate string `json:"operatorBuildDate"`
+	// Mode is one of SummaryModeActive, SummaryModePaused and SummaryModeSchedulingGatesUnsupported
+	Mode string `json:"mode"`
+	// PausedBy is the name of the PodPlacementConfig pausing the pod placement, if any
+	PausedBy string `json:"pausedBy,omitempty"`
+	// Features are the features of the operator, as exported by the multiarch_operator_feature_enabled metric: the
+	// boolean flags of its command line by name, the mode, e.g., mode=Active, and the toggles of the
+	// PodPlacementConfig objects, e.g., paused or placementMode=Preferred
+	Features map[string]bool `json:"features"`
+	// GatedPods is the number of pods waiting for their placement
+	GatedPods int32 `json:"gatedPods"`
+	// Architectures is the number of schedulable nodes of each architecture, sorted by architecture
+	Architectures []multiarchv1alpha1.ArchitectureNodes `json:"architectures"`
+	// InspectionErrors are the last failed inspections of the images, the most recent first
+	InspectionErrors []image.InspectionErrorSample `json:"inspectionErrors"`
+	// PodPlacementConfigs are the generations of the PodPlacementConfig objects, sorted by name
+	PodPlacementConfigs []SummaryPodPlacementConfig `json:"podPlacementConfigs"`
+	// SystemConfig is the last sync of the system config files. It is missing when the operator does not sync them.
+	SystemConfig *SummarySystemConfig `json:"systemConfig,omitempty"`
+	// Tuning are the tunables in effect: the ones of the command line flags, overridden by the tuning of the
+	// PodPlacementConfig objects
+	Tuning *multiarchv1alpha1.TuningSettings `json:"tuning"`
+	// GeneratedAt is the time the document was assembled: it is served from a cache for one second
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// SummaryPodPlacementConfig is the generation of a PodPlacementConfig
+type SummaryPodPlacementConfig struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+}
+
+// SummarySystemConfig is the last sync of the system config files
+type SummarySystemConfig struct {
+	LastSyncTime  time.Time `json:"lastSyncTime"`
+	LastSyncError string    `json:"lastSyncError,omitempty"`
+}
+
+// SummaryServer serves the Summary of the state of the operator on GET SummaryPath. The document is assembled from
+// the snapshot of the PodPlacementConfig objects, the nodes and pods of the cache of the manager, the recent
+// inspection errors, the tuning in effect and the status of the system config syncer, and is cached for
+// summaryCacheTTL.
+// The requests are authenticated by a TokenReview of their bearer token, and the user must be allowed to get the
+// PodPlacementConfig objects.
+type SummaryServer struct {
+	// Client reads the nodes and the pods, and creates the TokenReview and SubjectAccessReview objects authorizing
+	// the requests
+	Client client.Client
+	// PodPlacementConfigs is the snapshot of the PodPlacementConfig objects. Until it is ready, the requests are
+	// answered with http.StatusServiceUnavailable.
+	PodPlacementConfigs *PodPlacementConfigSnapshot
+	// SchedulingGatesUnsupported is true when the cluster does not support the pod scheduling gates
+	SchedulingGatesUnsupported bool
+	// SyncStatus is optional. When set, it returns the last sync of the system config files.
+	SyncStatus func() system_config.SyncStatus
+	// InspectionErrors returns the recent inspection errors. It defaults to image.RecentInspectionErrors.
+	InspectionErrors func() []image.InspectionErrorSample
+	// Instance is optional. When set, the gated pods are the ones with its scheduling gate.
+	Instance *Instance
+
+	clock clock.PassiveClock
+	// mutex serializes the assembly of the summary, so that the concurrent requests share the cached document
+	mutex    sync.Mutex
+	cached   []byte
+	cachedAt time.Time
+}
+
+func (s *SummaryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only the GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	authorizer := &requestAuthorizer{client: s.Client}
+	if status, err := authorizer.authorize(r.Context(), r, authorizationv1.ResourceAttributes{
+		Verb:     "get",
+		Group:    multiarchv1alpha1.GroupVersion.Group,
+		Resource: "podplacementconfigs",
+	}); err != nil {
+		klog.V(3).Infof("Rejecting the summary request: %v", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	body, status, err := s.summary(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		klog.Warningf("Unable to write the summary response: %v", err)
+	}
+}
+
+// passiveClock returns the clock of the server, or the real clock if it is not set. It does not set the clock: the
+// requests are served concurrently.
+func (s *SummaryServer) passiveClock() clock.PassiveClock {
+	if s.clock != nil {
+		return s.clock
+	}
+	return clock.RealClock{}
+}
+
+// summary returns the cached summary, assembling it again if it is older than summaryCacheTTL. On errors, it also
+// returns the HTTP status code to answer with.
+func (s *SummaryServer) summary(r *http.Request) ([]byte, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.passiveClock().Now()
+	if s.cached != nil && now.Sub(s.cachedAt) < summaryCacheTTL {
+		return s.cached, http.StatusOK, nil
+	}
+	configs, ok := s.PodPlacementConfigs.load()
+	if !ok {
+		return nil, http.StatusServiceUnavailable, errNoPodPlacementConfigSnapshot
+	}
+	architectures, pendingGatedPods, err := ArchitecturesDistribution(r.Context(), s.Client, s.Instance)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("unable to list the nodes and the pods: %w", err)
+	}
+	summary := &Summary{
+		APIVersion:          SummaryAPIVersion,
+		OperatorVersion:     version.Version,
+		OperatorCommit:      version.Commit,
+		OperatorBuildDate:   version.Date,
+		Mode:                operatorMode(s.SchedulingGatesUnsupported, configs),
+		PausedBy:            configs.pausedBy,
+		Architectures:       architectures,
+		PodPlacementConfigs: make([]SummaryPodPlacementConfig, 0, len(configs.items)),
+		Tuning:              currentTuning().effective.summary(),
+		GeneratedAt:         now.UTC(),
+	}
+	summary.Features = recordedFeatures(configs, summary.Mode)
+	for _, requirement := range pendingGatedPods {
+		summary.GatedPods += requirement.Pods
+	}
+	inspectionErrors := s.InspectionErrors
+	if inspectionErrors == nil {
+		inspectionErrors = image.RecentInspectionErrors
+	}
+	summary.InspectionErrors = inspectionErrors()
+	for _, ppc := range configs.items {
+		summary.PodPlacementConfigs = append(summary.PodPlacementConfigs, SummaryPodPlacementConfig{
+			Name:       ppc.Name,
+			Generation: ppc.Generation,
+		})
+	}
+	if s.SyncStatus != nil {
+		status := s.SyncStatus()
+		summary.SystemConfig = &SummarySystemConfig{LastSyncTime: status.LastSyncTime.UTC()}
+		if status.LastSyncError != nil {
+			summary.SystemConfig.LastSyncError = status.LastSyncError.Error()
+		}
+	}
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	s.cached, s.cachedAt = body, now
+	return body, http.StatusOK, nil
+}
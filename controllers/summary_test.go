@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/system_config"
+	"multiarch-operator/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("The summary server", func() {
+	var (
+		ctx       context.Context
+		c         client.Client
+		reviews   []authorizationv1.SubjectAccessReviewSpec
+		fakeClock *clocktesting.FakeClock
+		snapshot  *PodPlacementConfigSnapshot
+		server    *SummaryServer
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		reviews = nil
+		previousVersion := version.Version
+		previousCommit, previousDate := version.Commit, version.Date
+		version.Version, version.Commit, version.Date = "v1.2.3", "0123456789abcdef", "2023-05-01T12:00:00Z"
+		DeferCleanup(func() { version.Version, version.Commit, version.Date = previousVersion, previousCommit, previousDate })
+		recordFlagFeatures(map[string]bool{"enable-summary": true, "leader-elect": false})
+
+		gated := func(name string) *corev1.Pod {
+			pod := podWithImages(name, "quay.io/org/app:v1")
+			pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+			return pod
+		}
+		c = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			nodeWithCapacity("amd64-a", "amd64", "4", "8Gi", false),
+			nodeWithCapacity("amd64-b", "amd64", "4", "8Gi", false),
+			nodeWithCapacity("arm64-a", "arm64", "4", "8Gi", false),
+			nodeWithCapacity("arm64-b", "arm64", "4", "8Gi", true),
+			gated("gated-a"), gated("gated-b"), podWithImages("placed", "quay.io/org/app:v1"),
+			&multiarchv1alpha1.PodPlacementConfig{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Generation: 3}},
+			&multiarchv1alpha1.PodPlacementConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "incident", Generation: 1},
+				Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Paused: true},
+			},
+		).WithInterceptorFuncs(reviewingTokens("", &reviews)).Build()
+		snapshot = NewPodPlacementConfigSnapshot(nil, c)
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		fakeClock = clocktesting.NewFakeClock(time.Date(2023, 5, 4, 10, 0, 30, 0, time.UTC))
+		server = &SummaryServer{
+			Client:              c,
+			PodPlacementConfigs: snapshot,
+			SyncStatus: func() system_config.SyncStatus {
+				return system_config.SyncStatus{
+					LastSyncTime:  time.Date(2023, 5, 4, 9, 59, 0, 0, time.UTC),
+					LastSyncError: errors.New("unable to write the registries.conf file"),
+				}
+			},
+			InspectionErrors: func() []image.InspectionErrorSample {
+				return []image.InspectionErrorSample{{
+					Time:  time.Date(2023, 5, 4, 10, 0, 0, 0, time.UTC),
+					Image: "//quay.io/org/missing:v1",
+					Kind:  "not_found",
+					Error: "manifest unknown",
+				}}
+			},
+			clock: fakeClock,
+		}
+	})
+
+	get := func(token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, SummaryPath, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	summary := func() *Summary {
+		recorder := get(simulationToken)
+		Expect(recorder.Code).To(Equal(http.StatusOK), recorder.Body.String())
+		summary := &Summary{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), summary)).To(Succeed())
+		return summary
+	}
+
+	It("should match the golden file", func() {
+		recorder := get(simulationToken)
+		Expect(recorder.Code).To(Equal(http.StatusOK), recorder.Body.String())
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		golden, err := os.ReadFile(filepath.Join("testdata", "summary.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Body.String()).To(MatchJSON(golden))
+		Expect(reviews).To(ConsistOf(HaveField("ResourceAttributes", Equal(&authorizationv1.ResourceAttributes{
+			Verb: "get", Group: multiarchv1alpha1.GroupVersion.Group, Resource: "podplacementconfigs",
+		}))))
+	})
+
+	It("should report the mode of the operator", func() {
+		Expect(summary().Mode).To(Equal(SummaryModePaused))
+
+		incident := &multiarchv1alpha1.PodPlacementConfig{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "incident"}, incident)).To(Succeed())
+		Expect(c.Delete(ctx, incident)).To(Succeed())
+		Expect(snapshot.refresh(ctx)).To(Succeed())
+		fakeClock.Step(summaryCacheTTL)
+		active := summary()
+		Expect(active.Mode).To(Equal(SummaryModeActive))
+		Expect(active.PausedBy).To(BeEmpty())
+
+		server.SchedulingGatesUnsupported = true
+		fakeClock.Step(summaryCacheTTL)
+		unsupported := summary()
+		Expect(unsupported.Mode).To(Equal(SummaryModeSchedulingGatesUnsupported))
+		Expect(unsupported.Features).To(And(HaveKeyWithValue("mode=SchedulingGatesUnsupported", true),
+			HaveKeyWithValue("mode=Paused", false), HaveKeyWithValue("paused", false)))
+	})
+
+	It("should serve the cached summary for one second", func() {
+		Expect(summary().GatedPods).To(Equal(int32(2)))
+		gated := podWithImages("gated-c", "quay.io/org/app:v1")
+		gated.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		Expect(c.Create(ctx, gated)).To(Succeed())
+		fakeClock.Step(summaryCacheTTL - time.Millisecond)
+		Expect(summary().GatedPods).To(Equal(int32(2)))
+		fakeClock.Step(time.Millisecond)
+		Expect(summary().GatedPods).To(Equal(int32(3)))
+	})
+
+	It("should omit the system config when the operator does not sync it", func() {
+		server.SyncStatus = nil
+		Expect(summary().SystemConfig).To(BeNil())
+	})
+
+	It("should be unavailable until the snapshot of the PodPlacementConfig objects is ready", func() {
+		server.PodPlacementConfigs = NewPodPlacementConfigSnapshot(nil, c)
+		Expect(get(simulationToken).Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should reject the requests without a valid token", func() {
+		Expect(get("").Code).To(Equal(http.StatusUnauthorized))
+		Expect(get("invalid-token").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject the users not allowed to get the PodPlacementConfig objects", func() {
+		server.Client = fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithInterceptorFuncs(reviewingTokens("other", &reviews)).Build()
+		Expect(get(simulationToken).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should only allow the GET method", func() {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, SummaryPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal(http.MethodGet))
+	})
+})
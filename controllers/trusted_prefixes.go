@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// trustedPrefix is a parsed entry of the trustedMultiArchPrefixes of a PodPlacementConfig
+type trustedPrefix struct {
+	domain string
+	// path is empty when the prefix matches all the repositories of the domain
+	path string
+}
+
+// parseTrustedPrefix parses a repository prefix. A prefix without a path component that looks like a host matches all
+// the repositories of that registry. The prefixes without a registry are normalized as repository names, e.g.,
+// "nginx" becomes "docker.io/library/nginx", while the path of the prefixes with a registry is kept as is, so that
+// "docker.io/bitnami" matches the bitnami organization.
+func parseTrustedPrefix(prefix string) (trustedPrefix, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.Contains(prefix, "/") && looksLikeHost(prefix) {
+		return trustedPrefix{domain: prefix}, nil
+	}
+	named, err := reference.ParseNormalizedNamed(prefix)
+	if err != nil {
+		return trustedPrefix{}, err
+	}
+	if !reference.IsNameOnly(named) {
+		return trustedPrefix{}, fmt.Errorf("the prefix %s must not have a tag or a digest", prefix)
+	}
+	path := reference.Path(named)
+	if host, literalPath, ok := strings.Cut(prefix, "/"); ok && looksLikeHost(host) {
+		path = literalPath
+	}
+	return trustedPrefix{
+		domain: reference.Domain(named),
+		path:   path,
+	}, nil
+}
+
+// looksLikeHost applies the heuristic of the docker reference parser to tell a registry host from the first path
+// component of a repository name
+func looksLikeHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// matches returns true if the repository of named is the prefix or is nested in it. The paths are compared by
+// component, so that "quay.io/org" does not match "quay.io/organization/app".
+func (p trustedPrefix) matches(named reference.Named) bool {
+	if reference.Domain(named) != p.domain {
+		return false
+	}
+	path := reference.Path(named)
+	return p.path == "" || path == p.path || strings.HasPrefix(path, p.path+"/")
+}
+
+// getTrustedMultiArchPrefixes returns the trusted prefixes of all the PodPlacementConfig objects.
+// The invalid prefixes are logged and ignored.
+func getTrustedMultiArchPrefixes(ctx context.Context, c client.Reader) ([]trustedPrefix, error) {
+	podPlacementConfigs, err := listPodPlacementConfigs(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrustedMultiArchPrefixes(podPlacementConfigs), nil
+}
+
+// parseTrustedMultiArchPrefixes returns the trusted prefixes of the PodPlacementConfig objects. The invalid prefixes
+// are logged and ignored.
+func parseTrustedMultiArchPrefixes(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) []trustedPrefix {
+	var prefixes []trustedPrefix
+	for _, ppc := range podPlacementConfigs {
+		for _, prefix := range ppc.Spec.TrustedMultiArchPrefixes {
+			parsed, err := parseTrustedPrefix(prefix)
+			if err != nil {
+				klog.Warningf("Ignoring the invalid trusted multi-arch prefix %q of the PodPlacementConfig %s: %v",
+					prefix, ppc.Name, err)
+				continue
+			}
+			prefixes = append(prefixes, parsed)
+		}
+	}
+	return prefixes
+}
+
+// imagesMatchTrustedPrefixes returns true if all the images of the pod's containers, init containers and image volumes
+// recorded by the instance match one of the prefixes or of the implicit trusted prefixes. It returns false for the pods
+// with no images or when no prefix is trusted.
+func imagesMatchTrustedPrefixes(instance *Instance, pod *corev1.Pod, prefixes []trustedPrefix) bool {
+	if implicit := currentImplicitTrustedPrefixes(); len(implicit) > 0 {
+		prefixes = append(implicit[:len(implicit):len(implicit)], prefixes...)
+	}
+	references := instance.podImageReferences(pod)
+	if len(prefixes) == 0 || len(references) == 0 {
+		return false
+	}
+	for _, imageReference := range references {
+		named, err := reference.ParseNormalizedNamed(imageReference)
+		if err != nil {
+			return false
+		}
+		if !matchesAnyPrefix(named, prefixes) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAnyPrefix(named reference.Named, prefixes []trustedPrefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.matches(named) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesOnlyTrustedImages returns true if all the images of the pod match the trusted multi-arch prefixes of the
+// PodPlacementConfig objects. Errors are logged and reported as false, so that the pod goes through the inspection.
+func usesOnlyTrustedImages(ctx context.Context, c client.Reader, instance *Instance, pod *corev1.Pod) bool {
+	prefixes, err := getTrustedMultiArchPrefixes(ctx, c)
+	if err != nil {
+		klog.Warningf("Unable to get the trusted multi-arch prefixes for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return false
+	}
+	return imagesMatchTrustedPrefixes(instance, pod, prefixes)
+}
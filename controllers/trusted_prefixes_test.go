@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containers/image/v5/docker/reference"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func podPlacementConfigTrusting(prefixes ...string) *multiarchv1alpha1.PodPlacementConfig {
+	return &multiarchv1alpha1.PodPlacementConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Spec: multiarchv1alpha1.PodPlacementConfigSpec{
+			TrustedMultiArchPrefixes: prefixes,
+		},
+	}
+}
+
+var _ = Describe("The trusted multi-arch prefixes", func() {
+	DescribeTable("should match by repository prefix",
+		func(prefix, image string, expected bool) {
+			parsed, err := parseTrustedPrefix(prefix)
+			Expect(err).NotTo(HaveOccurred())
+			named, err := reference.ParseNormalizedNamed(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.matches(named)).To(Equal(expected))
+		},
+		Entry("same repository", "registry.access.redhat.com/ubi9", "registry.access.redhat.com/ubi9:latest", true),
+		Entry("nested repository", "registry.access.redhat.com/ubi9", "registry.access.redhat.com/ubi9/ubi-minimal", true),
+		Entry("digest reference", "quay.io/openshift-release-dev",
+			"quay.io/openshift-release-dev/ocp-release@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true),
+		Entry("trailing slash in the prefix", "quay.io/openshift-release-dev/", "quay.io/openshift-release-dev/ocp-v4.0-art-dev", true),
+		Entry("repository sharing the prefix string", "registry.access.redhat.com/ubi9", "registry.access.redhat.com/ubi9-minimal", false),
+		Entry("organization sharing the prefix string", "quay.io/org", "quay.io/organization/app", false),
+		Entry("prefix in the path of another registry", "quay.io/org", "evil.example.com/quay.io/org/app", false),
+		Entry("registry sharing the prefix string", "quay.io/org", "quay.io.example.com/org/app", false),
+		Entry("parent repository", "quay.io/org/app", "quay.io/org", false),
+		Entry("registry-only prefix", "quay.io", "quay.io/org/app:v1", true),
+		Entry("registry-only prefix and a different port", "quay.io", "quay.io:443/org/app", false),
+		Entry("registry with port", "registry.example.com:5000", "registry.example.com:5000/app", true),
+		Entry("docker hub short name", "nginx", "docker.io/library/nginx:1.25", true),
+		Entry("docker hub short image name", "docker.io/library/nginx", "nginx", true),
+		Entry("docker hub short name sharing the prefix string", "redis", "redis-stack", false),
+		Entry("docker hub organization", "bitnami", "docker.io/bitnami/redis", false),
+		Entry("docker hub explicit organization", "docker.io/bitnami", "bitnami/redis", true),
+		Entry("docker hub explicit organization and a library image", "docker.io/bitnami", "bitnami", false),
+	)
+
+	DescribeTable("should reject the invalid prefixes",
+		func(prefix string) {
+			_, err := parseTrustedPrefix(prefix)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("tagged prefix", "quay.io/org/app:v1"),
+		Entry("digested prefix", "quay.io/org/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"),
+		Entry("upper case repository", "quay.io/Org"),
+	)
+
+	It("should require all the images of the pod to match", func() {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			podPlacementConfigTrusting("registry.access.redhat.com/ubi9", "not a prefix:"),
+		).Build()
+		ctx := context.Background()
+		Expect(usesOnlyTrustedImages(ctx, c, nil,
+			podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122"))).To(BeTrue())
+		Expect(usesOnlyTrustedImages(ctx, c, nil, podWithImages("pod",
+			"registry.access.redhat.com/ubi9/nginx-122", "quay.io/org/app"))).To(BeFalse())
+		pod := podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122")
+		pod.Spec.InitContainers = []corev1.Container{{Image: "quay.io/org/init"}}
+		Expect(usesOnlyTrustedImages(ctx, c, nil, pod)).To(BeFalse())
+	})
+
+	It("should not trust any image without a PodPlacementConfig", func() {
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		Expect(usesOnlyTrustedImages(context.Background(), c, nil, podWithImages("pod", "nginx"))).To(BeFalse())
+	})
+})
+
+var _ = Describe("The scheduling gate webhook", func() {
+	var webhook *PodSchedulingGateMutatingWebHook
+
+	handle := func(pod *corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		return response
+	}
+
+	BeforeEach(func() {
+		scheme := newTestScheme()
+		webhook = &PodSchedulingGateMutatingWebHook{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				podPlacementConfigTrusting("registry.access.redhat.com/ubi9")).Build(),
+		}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+	})
+
+	It("should not gate the pods using only trusted images", func() {
+		Expect(handle(podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122")).Patches).To(BeEmpty())
+	})
+
+	It("should gate the pods using untrusted images", func() {
+		response := handle(podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122",
+			"registry.access.redhat.com/ubi9-init"))
+		Expect(response.Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should gate the pods declaring their architectures", func() {
+		pod := podWithImages("pod", "registry.access.redhat.com/ubi9/nginx-122")
+		pod.Annotations = map[string]string{architecturesOverrideAnnotation: "arm64"}
+		Expect(handle(pod).Patches).To(ContainElement(HaveField("Path", "/spec/schedulingGates")))
+	})
+
+	It("should not gate the pods of the opted-out namespaces", func() {
+		optOut := true
+		Expect(webhook.Client.Create(context.Background(), &multiarchv1alpha1.PodPlacementPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "test"},
+			Spec: multiarchv1alpha1.PodPlacementPolicySpec{
+				PlacementPolicy: multiarchv1alpha1.PlacementPolicy{OptOut: &optOut},
+			},
+		})).To(Succeed())
+		Expect(handle(podWithImages("pod", "quay.io/org/app")).Patches).To(BeEmpty())
+	})
+})
+
+var _ = Describe("The pod reconciler", func() {
+	It("should not inspect the gated pods using only trusted images", func() {
+		pod := podWithImages("pod", "quay.io/openshift-release-dev/ocp-release:4.13")
+		pod.Spec.SchedulingGates = []corev1.PodSchedulingGate{schedulingGate}
+		c := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			podPlacementConfigTrusting("quay.io/openshift-release-dev"), pod).Build()
+		// any inspection would panic
+		reconciler := &PodReconciler{Client: c, Inspector: noInspections{}}
+		key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		updated := &corev1.Pod{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(pod), updated)).To(Succeed())
+		Expect(updated.Spec.SchedulingGates).To(BeEmpty())
+		Expect(updated.Spec.Affinity).To(BeNil())
+	})
+})
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image"
+)
+
+// Tuning are the tunables of the operator. The command line flags set their defaults, see SetTuningDefaults, and the
+// tuning of the PodPlacementConfig objects overrides them field by field at every refresh of their snapshot.
+type Tuning struct {
+	InspectionTimeout      time.Duration
+	MaxParallelInspections int
+	ArchitecturesCacheTTL  time.Duration
+	NotFoundCacheTTL       time.Duration
+	UnauthorizedCacheTTL   time.Duration
+	// DecisionCacheTTL and GatedPodsSweepInterval only apply when the flags enable the cache and the sweeps
+	DecisionCacheTTL           time.Duration
+	MaxRetryableBackoff        time.Duration
+	MaxPermanentFailureBackoff time.Duration
+	GatedPodsSweepInterval     time.Duration
+}
+
+// DefaultTuning returns the tuning of the operator when no flag sets it
+func DefaultTuning() Tuning {
+	return Tuning{
+		InspectionTimeout:          image.DefaultInspectionTimeout,
+		NotFoundCacheTTL:           image.DefaultNotFoundCacheTTL,
+		UnauthorizedCacheTTL:       image.DefaultUnauthorizedCacheTTL,
+		DecisionCacheTTL:           DefaultDecisionCacheTTL,
+		MaxRetryableBackoff:        retryableFailureMaxDelay,
+		MaxPermanentFailureBackoff: permanentFailureMaxDelay,
+	}
+}
+
+// tuningState is the tuning in effect and the overrides of the PodPlacementConfig objects it was computed with
+type tuningState struct {
+	effective Tuning
+	overrides multiarchv1alpha1.TuningSettings
+	// changed is closed when the state is replaced
+	changed chan struct{}
+}
+
+var (
+	// tuningDefaults are the tunables set by the command line flags
+	tuningDefaults = DefaultTuning()
+	// tuningMutex is used to protect tuningDefaults from concurrent access and serializes the changes of tuning
+	tuningMutex sync.Mutex
+	// tuning is the current tuningState, replaced as a whole so that the reconciler and the inspections read it
+	// without locking
+	tuning atomic.Pointer[tuningState]
+)
+
+func init() {
+	tuning.Store(&tuningState{effective: DefaultTuning(), changed: make(chan struct{})})
+}
+
+// SetTuningDefaults sets the tunables of the command line flags and applies them to the image inspections. The tuning
+// of the PodPlacementConfig objects overrides them. It is expected to be called once, before the manager starts.
+func SetTuningDefaults(defaults Tuning) {
+	tuningMutex.Lock()
+	defer tuningMutex.Unlock()
+	tuningDefaults = defaults
+	replaceTuningLocked(tuning.Load().overrides)
+}
+
+// applyTuning overrides the tunables of the command line flags with the ones set by the PodPlacementConfig objects,
+// restoring the defaults of the unset ones
+func applyTuning(overrides multiarchv1alpha1.TuningSettings) {
+	tuningMutex.Lock()
+	defer tuningMutex.Unlock()
+	replaceTuningLocked(overrides)
+}
+
+// replaceTuningLocked computes the tuning in effect, applies it to the image inspections and notifies the readers
+// waiting for a change. The mutex must be held.
+func replaceTuningLocked(overrides multiarchv1alpha1.TuningSettings) {
+	effective := tuningDefaults
+	overrideDuration(&effective.InspectionTimeout, overrides.InspectionTimeout)
+	overrideDuration(&effective.ArchitecturesCacheTTL, overrides.ArchitecturesCacheTTL)
+	overrideDuration(&effective.NotFoundCacheTTL, overrides.NotFoundCacheTTL)
+	overrideDuration(&effective.UnauthorizedCacheTTL, overrides.UnauthorizedCacheTTL)
+	overrideDuration(&effective.DecisionCacheTTL, overrides.DecisionCacheTTL)
+	overrideDuration(&effective.MaxRetryableBackoff, overrides.MaxRetryableBackoff)
+	overrideDuration(&effective.MaxPermanentFailureBackoff, overrides.MaxPermanentFailureBackoff)
+	overrideDuration(&effective.GatedPodsSweepInterval, overrides.GatedPodsSweepInterval)
+	if overrides.MaxParallelInspections != nil {
+		effective.MaxParallelInspections = int(*overrides.MaxParallelInspections)
+	}
+	image.SetInspectionTimeout(effective.InspectionTimeout)
+	image.SetMaxParallelInspections(effective.MaxParallelInspections)
+	image.SetArchitecturesCacheTTL(effective.ArchitecturesCacheTTL)
+	image.SetFailureCacheTTLs(effective.NotFoundCacheTTL, effective.UnauthorizedCacheTTL)
+	previous := tuning.Load()
+	if effective != previous.effective {
+		klog.Infof("Applying the tuning %+v", effective)
+	}
+	tuning.Store(&tuningState{effective: effective, overrides: overrides, changed: make(chan struct{})})
+	close(previous.changed)
+}
+
+func overrideDuration(value *time.Duration, override *metav1.Duration) {
+	if override != nil {
+		*value = override.Duration
+	}
+}
+
+// currentTuning returns the tuning in effect
+func currentTuning() *tuningState {
+	return tuning.Load()
+}
+
+// tunedDuration returns the override selected from the tuning of the PodPlacementConfig objects, if set, or the value
+// the component was created with
+func tunedDuration(value time.Duration,
+	override func(*multiarchv1alpha1.TuningSettings) *metav1.Duration) time.Duration {
+	if d := override(&currentTuning().overrides); d != nil {
+		return d.Duration
+	}
+	return value
+}
+
+// mergedTuning returns the tuning of the PodPlacementConfig objects sorted by name, the first one setting a field
+// winning
+func mergedTuning(podPlacementConfigs []multiarchv1alpha1.PodPlacementConfig) multiarchv1alpha1.TuningSettings {
+	var merged multiarchv1alpha1.TuningSettings
+	for _, ppc := range podPlacementConfigs {
+		t := ppc.Spec.Tuning
+		if t == nil {
+			continue
+		}
+		for _, field := range []struct{ merged, set **metav1.Duration }{
+			{&merged.InspectionTimeout, &t.InspectionTimeout},
+			{&merged.ArchitecturesCacheTTL, &t.ArchitecturesCacheTTL},
+			{&merged.NotFoundCacheTTL, &t.NotFoundCacheTTL},
+			{&merged.UnauthorizedCacheTTL, &t.UnauthorizedCacheTTL},
+			{&merged.DecisionCacheTTL, &t.DecisionCacheTTL},
+			{&merged.MaxRetryableBackoff, &t.MaxRetryableBackoff},
+			{&merged.MaxPermanentFailureBackoff, &t.MaxPermanentFailureBackoff},
+			{&merged.GatedPodsSweepInterval, &t.GatedPodsSweepInterval},
+		} {
+			if *field.merged == nil {
+				*field.merged = *field.set
+			}
+		}
+		if merged.MaxParallelInspections == nil {
+			merged.MaxParallelInspections = t.MaxParallelInspections
+		}
+	}
+	return merged
+}
+
+// summary returns the tuning in the format of the PodPlacementConfig objects, e.g., for the Summary
+func (t Tuning) summary() *multiarchv1alpha1.TuningSettings {
+	maxParallelInspections := int32(t.MaxParallelInspections)
+	return &multiarchv1alpha1.TuningSettings{
+		InspectionTimeout:          &metav1.Duration{Duration: t.InspectionTimeout},
+		MaxParallelInspections:     &maxParallelInspections,
+		ArchitecturesCacheTTL:      &metav1.Duration{Duration: t.ArchitecturesCacheTTL},
+		NotFoundCacheTTL:           &metav1.Duration{Duration: t.NotFoundCacheTTL},
+		UnauthorizedCacheTTL:       &metav1.Duration{Duration: t.UnauthorizedCacheTTL},
+		DecisionCacheTTL:           &metav1.Duration{Duration: t.DecisionCacheTTL},
+		MaxRetryableBackoff:        &metav1.Duration{Duration: t.MaxRetryableBackoff},
+		MaxPermanentFailureBackoff: &metav1.Duration{Duration: t.MaxPermanentFailureBackoff},
+		GatedPodsSweepInterval:     &metav1.Duration{Duration: t.GatedPodsSweepInterval},
+	}
+}
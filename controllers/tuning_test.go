@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+	"multiarch-operator/pkg/image/inspect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// tuningConfig returns a PodPlacementConfig setting the tuning
+func tuningConfig(name string, tuning *multiarchv1alpha1.TuningSettings) multiarchv1alpha1.PodPlacementConfig {
+	return multiarchv1alpha1.PodPlacementConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       multiarchv1alpha1.PodPlacementConfigSpec{Tuning: tuning},
+	}
+}
+
+var _ = Describe("The tuning", func() {
+	BeforeEach(func() {
+		DeferCleanup(func() {
+			SetTuningDefaults(DefaultTuning())
+			applyTuning(multiarchv1alpha1.TuningSettings{})
+		})
+	})
+
+	It("should merge the tuning of the PodPlacementConfig objects, the first one by name setting a field winning",
+		func() {
+			Expect(mergedTuning([]multiarchv1alpha1.PodPlacementConfig{
+				tuningConfig("a", &multiarchv1alpha1.TuningSettings{
+					DecisionCacheTTL: &metav1.Duration{Duration: 5 * time.Minute},
+				}),
+				tuningConfig("b", nil),
+				tuningConfig("c", &multiarchv1alpha1.TuningSettings{
+					DecisionCacheTTL:       &metav1.Duration{Duration: time.Hour},
+					MaxParallelInspections: pointer.Int32(4),
+				}),
+			})).To(Equal(multiarchv1alpha1.TuningSettings{
+				DecisionCacheTTL:       &metav1.Duration{Duration: 5 * time.Minute},
+				MaxParallelInspections: pointer.Int32(4),
+			}))
+		})
+
+	It("should override the defaults of the flags and restore them once unset", func() {
+		defaults := DefaultTuning()
+		defaults.InspectionTimeout = time.Minute
+		defaults.GatedPodsSweepInterval = 10 * time.Minute
+		SetTuningDefaults(defaults)
+		Expect(currentTuning().effective).To(Equal(defaults))
+
+		changed := currentTuning().changed
+		applyTuning(multiarchv1alpha1.TuningSettings{
+			InspectionTimeout:      &metav1.Duration{Duration: 30 * time.Second},
+			MaxParallelInspections: pointer.Int32(8),
+		})
+		Expect(changed).To(BeClosed())
+		expected := defaults
+		expected.InspectionTimeout = 30 * time.Second
+		expected.MaxParallelInspections = 8
+		Expect(currentTuning().effective).To(Equal(expected))
+
+		applyTuning(multiarchv1alpha1.TuningSettings{})
+		Expect(currentTuning().effective).To(Equal(defaults))
+	})
+
+	It("should apply the tuning of the snapshot of the PodPlacementConfig objects", func() {
+		ppc := tuningConfig("cluster", &multiarchv1alpha1.TuningSettings{
+			DecisionCacheTTL: &metav1.Duration{Duration: 5 * time.Minute},
+		})
+		snapshot := NewPodPlacementConfigSnapshot(nil, fake.NewClientBuilder().WithScheme(newTestScheme()).
+			WithObjects(&ppc).Build())
+		Expect(snapshot.refresh(context.Background())).To(Succeed())
+		Expect(currentTuning().effective.DecisionCacheTTL).To(Equal(5 * time.Minute))
+		Expect(currentTuning().effective.summary().DecisionCacheTTL).To(Equal(&metav1.Duration{
+			Duration: 5 * time.Minute,
+		}))
+	})
+
+	It("should cap the backoff of the gated pods by the tuned maximum delays", func() {
+		backoff := NewPlacementBackoff()
+		req := ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "test", Name: "pod"}}
+		backoff.observe(req, &inspect.Error{Kind: inspect.NotFound, Reference: "quay.io/org/missing:v1"})
+		for i := 0; i < 10; i++ {
+			backoff.When(req)
+		}
+		Expect(backoff.When(req)).To(Equal(permanentFailureMaxDelay))
+		applyTuning(multiarchv1alpha1.TuningSettings{
+			MaxPermanentFailureBackoff: &metav1.Duration{Duration: 2 * time.Hour},
+		})
+		Expect(backoff.When(req)).To(Equal(2 * time.Hour))
+		applyTuning(multiarchv1alpha1.TuningSettings{
+			MaxPermanentFailureBackoff: &metav1.Duration{Duration: 5 * time.Minute},
+		})
+		Expect(backoff.When(req)).To(Equal(5 * time.Minute))
+	})
+
+	It("should expire the cached decisions after the tuned TTL", func() {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		decisions := newDecisionCache(time.Hour, fakeClock)
+		key := decisionCacheKey{namespace: "test", templateHash: "abc"}
+		applyTuning(multiarchv1alpha1.TuningSettings{DecisionCacheTTL: &metav1.Duration{Duration: 10 * time.Second}})
+		decisions.add(key, decisionInputs{}, placementDecision{})
+		_, ok := decisions.get(key, decisionInputs{})
+		Expect(ok).To(BeTrue())
+		fakeClock.Step(11 * time.Second)
+		_, ok = decisions.get(key, decisionInputs{})
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// certificateIssuedPollInterval is the interval of the reads of the secret while cert-manager issues it
+	certificateIssuedPollInterval = 2 * time.Second
+	// certificateRefreshInterval is the interval of the reads of the secret to serve the certificates cert-manager
+	// renews
+	certificateRefreshInterval = time.Minute
+	// DefaultValidatingWebhookConfigurationName is the name of the ValidatingWebhookConfiguration of the webhooks
+	// validating the PodPlacementConfig and PodPlacementPolicy objects
+	DefaultValidatingWebhookConfigurationName = "multiarch-operator-validating-webhook-configuration"
+)
+
+// certificateGVK is the kind of the Certificate objects of cert-manager. They are read as unstructured objects, as
+// cert-manager is optional.
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+// WebhookCertificate requests the serving certificate of the webhooks from cert-manager, for the clusters without the
+// OpenShift service CA operator: it creates a Certificate for the DNS names of the webhook service, waits for
+// cert-manager to issue its secret and serves the certificate of the secret, reloaded when cert-manager renews it. The
+// CA of the secret is set as the caBundle of the webhooks of the webhook configurations.
+// It implements the manager.Runnable interface and is expected to be added to the manager once requested.
+type WebhookCertificate struct {
+	client client.Client
+	// certificate is the name of the Certificate and of its secret
+	certificate types.NamespacedName
+	// issuerKind is either Issuer, in the namespace of the Certificate, or ClusterIssuer
+	issuerKind string
+	issuerName string
+	dnsNames   []string
+	// MutatingWebhookConfigurations and ValidatingWebhookConfigurations are the names of the webhook configurations
+	// whose caBundle is set to the CA of the secret. The ones not found are skipped.
+	MutatingWebhookConfigurations   []string
+	ValidatingWebhookConfigurations []string
+	// resourceVersion is the version of the secret served
+	resourceVersion string
+	tlsCertificate  *tls.Certificate
+	// mutex is used to protect the certificate served from concurrent access
+	mutex sync.RWMutex
+}
+
+// NewWebhookCertificate returns a WebhookCertificate of the service, whose Certificate and secret are named certificate
+// in the namespace of the service. The issuer is the name of an Issuer in that namespace, or <kind>/<name>, where the
+// kind is Issuer or ClusterIssuer.
+func NewWebhookCertificate(c client.Client, service types.NamespacedName, certificate string,
+	issuer string) (*WebhookCertificate, error) {
+	issuerKind, issuerName, found := strings.Cut(issuer, "/")
+	if !found {
+		issuerKind, issuerName = "Issuer", issuer
+	}
+	if issuerKind != "Issuer" && issuerKind != "ClusterIssuer" || issuerName == "" {
+		return nil, fmt.Errorf("invalid issuer %q: expected <name>, Issuer/<name> or ClusterIssuer/<name>", issuer)
+	}
+	return &WebhookCertificate{
+		client:      c,
+		certificate: types.NamespacedName{Namespace: service.Namespace, Name: certificate},
+		issuerKind:  issuerKind,
+		issuerName:  issuerName,
+		dnsNames: []string{
+			fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+			fmt.Sprintf("%s.%s.svc.cluster.local", service.Name, service.Namespace),
+		},
+	}, nil
+}
+
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;create;update
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+
+// Request creates or updates the Certificate and waits up to the timeout for cert-manager to issue its secret. It
+// returns an error matched by meta.IsNoMatchError when the cert-manager CRDs are not installed.
+func (w *WebhookCertificate) Request(ctx context.Context, timeout time.Duration) error {
+	if err := w.ensureCertificate(ctx); err != nil {
+		return err
+	}
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, certificateIssuedPollInterval, timeout, true,
+		func(ctx context.Context) (bool, error) {
+			lastErr = w.refresh(ctx)
+			return lastErr == nil, nil
+		})
+	if err != nil && lastErr != nil {
+		return fmt.Errorf("the certificate %s was not issued: %w: %v", w.certificate, err, lastErr)
+	}
+	return err
+}
+
+// ensureCertificate creates the Certificate, or updates its spec if it differs
+func (w *WebhookCertificate) ensureCertificate(ctx context.Context) error {
+	spec := map[string]interface{}{
+		"secretName": w.certificate.Name,
+		"dnsNames":   toInterfaces(w.dnsNames),
+		"issuerRef": map[string]interface{}{
+			"group": certificateGVK.Group,
+			"kind":  w.issuerKind,
+			"name":  w.issuerName,
+		},
+	}
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	err := w.client.Get(ctx, w.certificate, certificate)
+	if apierrors.IsNotFound(err) {
+		certificate.SetNamespace(w.certificate.Namespace)
+		certificate.SetName(w.certificate.Name)
+		certificate.Object["spec"] = spec
+		klog.Infof("Creating the certificate %s of the webhooks, issued by the %s %s", w.certificate,
+			w.issuerKind, w.issuerName)
+		return w.client.Create(ctx, certificate, client.FieldOwner(FieldManager))
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(certificate.Object["spec"], spec) {
+		return nil
+	}
+	certificate.Object["spec"] = spec
+	klog.Infof("Updating the certificate %s of the webhooks", w.certificate)
+	return w.client.Update(ctx, certificate, client.FieldOwner(FieldManager))
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
+
+// Start reloads the certificate every certificateRefreshInterval until the context is done.
+func (w *WebhookCertificate) Start(ctx context.Context) error {
+	ticker := time.NewTicker(certificateRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := w.refresh(ctx); err != nil {
+				klog.Errorf("Unable to reload the certificate %s of the webhooks: %v", w.certificate, err)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false: the webhooks are served by every replica of the operator.
+func (w *WebhookCertificate) NeedLeaderElection() bool {
+	return false
+}
+
+// refresh serves the certificate of the secret, if it changed, and sets its CA as the caBundle of the webhooks. The
+// certificate served is kept when the secret cannot be read or is invalid.
+func (w *WebhookCertificate) refresh(ctx context.Context) error {
+	secret := &corev1.Secret{}
+	if err := w.client.Get(ctx, w.certificate, secret); err != nil {
+		return err
+	}
+	w.mutex.RLock()
+	unchanged := secret.ResourceVersion == w.resourceVersion
+	w.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	certificate, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("invalid certificate in the secret %s: %w", w.certificate, err)
+	}
+	caBundle := secret.Data["ca.crt"]
+	if len(caBundle) > 0 {
+		if err := w.injectCABundle(ctx, caBundle); err != nil {
+			return err
+		}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.resourceVersion = secret.ResourceVersion
+	w.tlsCertificate = &certificate
+	klog.Infof("Serving the certificate %s of the webhooks", w.certificate)
+	return nil
+}
+
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;update
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,verbs=get;update
+
+// injectCABundle sets the CA as the caBundle of the webhooks of the webhook configurations
+func (w *WebhookCertificate) injectCABundle(ctx context.Context, caBundle []byte) error {
+	for _, name := range w.MutatingWebhookConfigurations {
+		configuration := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		if err := w.client.Get(ctx, client.ObjectKey{Name: name}, configuration); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		injected := false
+		for i := range configuration.Webhooks {
+			injected = setCABundle(&configuration.Webhooks[i].ClientConfig, caBundle) || injected
+		}
+		if injected {
+			if err := w.client.Update(ctx, configuration, client.FieldOwner(FieldManager)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range w.ValidatingWebhookConfigurations {
+		configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		if err := w.client.Get(ctx, client.ObjectKey{Name: name}, configuration); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		injected := false
+		for i := range configuration.Webhooks {
+			injected = setCABundle(&configuration.Webhooks[i].ClientConfig, caBundle) || injected
+		}
+		if injected {
+			if err := w.client.Update(ctx, configuration, client.FieldOwner(FieldManager)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setCABundle sets the caBundle of the client config and returns true if it changed
+func setCABundle(clientConfig *admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	if bytes.Equal(clientConfig.CABundle, caBundle) {
+		return false
+	}
+	clientConfig.CABundle = caBundle
+	return true
+}
+
+// GetCertificate returns the certificate served, as the GetCertificate function of the tls.Config of the webhook
+// server
+func (w *WebhookCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.tlsCertificate == nil {
+		return nil, fmt.Errorf("the certificate %s was not issued yet", w.certificate)
+	}
+	return w.tlsCertificate, nil
+}
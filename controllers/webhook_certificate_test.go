@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// servingCertificateSecret returns the secret of a self-signed certificate of the name, as issued by cert-manager
+func servingCertificateSecret(name string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "serving-cert", Namespace: "operator"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			"ca.crt":                cert,
+		},
+	}
+}
+
+var _ = Describe("The webhook certificate issued by cert-manager", func() {
+	var (
+		ctx         context.Context
+		c           client.Client
+		certificate *WebhookCertificate
+		secret      *corev1.Secret
+		service     = types.NamespacedName{Namespace: "operator", Name: "webhook-service"}
+	)
+
+	newWebhookCertificate := func(c client.Client) *WebhookCertificate {
+		w, err := NewWebhookCertificate(c, service, "serving-cert", "ClusterIssuer/ca")
+		Expect(err).NotTo(HaveOccurred())
+		w.MutatingWebhookConfigurations = []string{"mutating", "missing"}
+		w.ValidatingWebhookConfigurations = []string{"validating"}
+		return w
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := newTestScheme()
+		Expect(admissionregistrationv1.AddToScheme(scheme)).To(Succeed())
+		secret = servingCertificateSecret("webhook-service.operator.svc")
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "mutating"},
+				Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "a"}, {Name: "b"}},
+			},
+			&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "validating"},
+				Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "c"}},
+			},
+			secret,
+		).Build()
+		certificate = newWebhookCertificate(c)
+	})
+
+	// certificateSpec returns the spec of the Certificate
+	certificateSpec := func() map[string]interface{} {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(certificateGVK)
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "operator", Name: "serving-cert"}, u)).To(Succeed())
+		spec, _, err := unstructured.NestedMap(u.Object, "spec")
+		Expect(err).NotTo(HaveOccurred())
+		return spec
+	}
+
+	// expectCABundle expects the webhooks of the configurations to trust the CA
+	expectCABundle := func(caBundle []byte) {
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "mutating"}, mutating)).To(Succeed())
+		for _, webhook := range mutating.Webhooks {
+			Expect(webhook.ClientConfig.CABundle).To(Equal(caBundle))
+		}
+		validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "validating"}, validating)).To(Succeed())
+		Expect(validating.Webhooks[0].ClientConfig.CABundle).To(Equal(caBundle))
+	}
+
+	// served returns the certificate served by the webhook server
+	served := func() []byte {
+		cert, err := certificate.GetCertificate(&tls.ClientHelloInfo{})
+		Expect(err).NotTo(HaveOccurred())
+		return cert.Certificate[0]
+	}
+
+	It("should create the Certificate and serve its secret", func() {
+		_, err := certificate.GetCertificate(&tls.ClientHelloInfo{})
+		Expect(err).To(MatchError(ContainSubstring("not issued yet")))
+		Expect(certificate.Request(ctx, time.Minute)).To(Succeed())
+		Expect(certificateSpec()).To(Equal(map[string]interface{}{
+			"secretName": "serving-cert",
+			"dnsNames":   []interface{}{"webhook-service.operator.svc", "webhook-service.operator.svc.cluster.local"},
+			"issuerRef":  map[string]interface{}{"group": "cert-manager.io", "kind": "ClusterIssuer", "name": "ca"},
+		}))
+		block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+		Expect(served()).To(Equal(block.Bytes))
+		expectCABundle(secret.Data["ca.crt"])
+	})
+
+	It("should serve the certificates renewed by cert-manager", func() {
+		Expect(certificate.Request(ctx, time.Minute)).To(Succeed())
+		renewed := servingCertificateSecret("webhook-service.operator.svc")
+		secret.Data = renewed.Data
+		Expect(c.Update(ctx, secret)).To(Succeed())
+		Expect(certificate.refresh(ctx)).To(Succeed())
+		block, _ := pem.Decode(renewed.Data[corev1.TLSCertKey])
+		Expect(served()).To(Equal(block.Bytes))
+		expectCABundle(renewed.Data["ca.crt"])
+
+		// an invalid secret does not replace the certificate served
+		secret.Data = map[string][]byte{corev1.TLSCertKey: []byte("invalid")}
+		Expect(c.Update(ctx, secret)).To(Succeed())
+		Expect(certificate.refresh(ctx)).To(MatchError(ContainSubstring("invalid certificate")))
+		Expect(served()).To(Equal(block.Bytes))
+	})
+
+	It("should update the Certificate requested with another issuer", func() {
+		Expect(certificate.Request(ctx, time.Minute)).To(Succeed())
+		w, err := NewWebhookCertificate(c, service, "serving-cert", "self-signed")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Request(ctx, time.Minute)).To(Succeed())
+		Expect(certificateSpec()).To(HaveKeyWithValue("issuerRef",
+			map[string]interface{}{"group": "cert-manager.io", "kind": "Issuer", "name": "self-signed"}))
+	})
+
+	It("should fail when the secret is not issued in time", func() {
+		Expect(c.Delete(ctx, secret)).To(Succeed())
+		Expect(certificate.Request(ctx, 10*time.Millisecond)).To(MatchError(ContainSubstring("was not issued")))
+	})
+
+	It("should report the clusters without the cert-manager CRDs", func() {
+		certificate = newWebhookCertificate(interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+				if _, ok := obj.(*unstructured.Unstructured); ok {
+					return &meta.NoKindMatchError{GroupKind: certificateGVK.GroupKind(),
+						SearchedVersions: []string{certificateGVK.Version}}
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}))
+		Expect(meta.IsNoMatchError(certificate.Request(ctx, time.Minute))).To(BeTrue())
+	})
+
+	DescribeTable("should parse the issuer", func(issuer, kind, name string) {
+		w, err := NewWebhookCertificate(c, service, "serving-cert", issuer)
+		if kind == "" {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.issuerKind).To(Equal(kind))
+		Expect(w.issuerName).To(Equal(name))
+	},
+		Entry("an Issuer by name", "ca", "Issuer", "ca"),
+		Entry("an Issuer", "Issuer/ca", "Issuer", "ca"),
+		Entry("a ClusterIssuer", "ClusterIssuer/ca", "ClusterIssuer", "ca"),
+		Entry("another kind", "Certificate/ca", "", ""),
+		Entry("no name", "ClusterIssuer/", "", ""),
+	)
+})
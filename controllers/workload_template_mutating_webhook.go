@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"multiarch-operator/pkg/image/inspect"
+)
+
+// WorkloadTemplateWebhookPath is the path of the WorkloadTemplateMutatingWebHook
+const WorkloadTemplateWebhookPath = "/mutate-workload-arch-affinity"
+
+// +kubebuilder:webhook:path=/mutate-workload-arch-affinity,mutating=true,sideEffects=None,admissionReviewVersions=v1,failurePolicy=ignore,groups=apps;batch,resources=deployments;statefulsets;jobs,verbs=create;update,versions=v1,name=workload-arch-affinity.multiarch.openshift.io
+
+// WorkloadTemplateMutatingWebHook sets the node affinity of the pod templates of the Deployments, StatefulSets and
+// Jobs whose images all have their architectures cached, while a PodPlacementConfig sets mutateWorkloadTemplates. The
+// templates are marked as placed, so that their pods are admitted without being gated. The other templates are left to
+// the PodSchedulingGateMutatingWebHook, which gates their pods: the workloads themselves are never gated nor denied.
+type WorkloadTemplateMutatingWebHook struct {
+	Client client.Client
+	// ArchitecturesCache are the architectures of the images known at admission, e.g., inspect.Singleton()
+	ArchitecturesCache inspect.CachedInspector
+	// PodPlacementConfigs is optional. When set, the PodPlacementConfig objects are read from its snapshot instead of
+	// the Client, and the workloads admitted before the first snapshot are not mutated.
+	PodPlacementConfigs *PodPlacementConfigSnapshot
+	// MutatedBy is optional. When set, it is the value of the mutatedByAnnotation annotation of the pod templates the
+	// webhook places, e.g., the version of the operator.
+	MutatedBy string
+	// Instance is optional. When set, its annotations are read and set instead of the default ones.
+	Instance *Instance
+	// ExcludedNamespaces are the glob patterns of the namespaces whose workloads are never mutated, as the
+	// PodSchedulingGateMutatingWebHook's ones
+	ExcludedNamespaces []string
+	decoder            *admission.Decoder
+}
+
+func (a *WorkloadTemplateMutatingWebHook) InjectDecoder(d *admission.Decoder) error {
+	a.decoder = d
+	return nil
+}
+
+// rawWorkloadImageVolumes is the part of a workload declaring the image volumes of its pod template, see
+// rawPodImageVolumes
+type rawWorkloadImageVolumes struct {
+	Spec struct {
+		Template rawPodImageVolumes `json:"template"`
+	} `json:"spec"`
+}
+
+// decodeWorkload decodes the workload of the request. It returns a nil object for the kinds the webhook does not
+// mutate.
+func (a *WorkloadTemplateMutatingWebHook) decodeWorkload(req admission.Request) (runtime.Object,
+	*corev1.PodTemplateSpec, error) {
+	var (
+		obj      runtime.Object
+		template *corev1.PodTemplateSpec
+	)
+	switch req.Kind.Group + "/" + req.Kind.Kind {
+	case "apps/Deployment":
+		deployment := &appsv1.Deployment{}
+		obj, template = deployment, &deployment.Spec.Template
+	case "apps/StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		obj, template = statefulSet, &statefulSet.Spec.Template
+	case "batch/Job":
+		job := &batchv1.Job{}
+		obj, template = job, &job.Spec.Template
+	default:
+		return nil, nil, nil
+	}
+	if err := a.decoder.Decode(req, obj); err != nil {
+		return nil, nil, err
+	}
+	return obj, template, nil
+}
+
+func (a *WorkloadTemplateMutatingWebHook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	obj, template, err := a.decodeWorkload(req)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if obj == nil {
+		return admission.Allowed("the kind of the object is not mutated")
+	}
+	// the pod templates of the Jobs are immutable
+	if req.Kind.Kind == "Job" && req.Operation != admissionv1.Create {
+		return admission.Allowed("the pod template of the Job is immutable")
+	}
+	if isExcludedNamespace(req.Namespace, a.ExcludedNamespaces) {
+		return admission.Allowed("the namespace is excluded")
+	}
+	// the templates are left alone while their node affinity cannot be computed: their pods are gated and placed by
+	// the reconciler as usual
+	configs, err := admissionPodPlacementConfigs(ctx, a.PodPlacementConfigs, a.Client)
+	if err != nil {
+		klog.V(2).Infof("Not mutating the pod template of the %s %s/%s: %v", req.Kind.Kind, req.Namespace,
+			req.Name, err)
+		return admission.Allowed("the PodPlacementConfig objects are not available")
+	}
+	if !configs.mutateWorkloadTemplates {
+		return admission.Allowed("the pod templates are not mutated")
+	}
+	if configs.pausedBy != "" {
+		return admission.Allowed("the pod placement is paused")
+	}
+	volumes, err := decodeWorkloadImageVolumes(req.Object.Raw)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// the template is placed again from scratch, so that the updates of the template replace its node affinity
+	original := obj.DeepCopyObject()
+	a.Instance.removeWorkloadTemplatePlacement(template)
+	if a.placeTemplate(ctx, req.Namespace, template, volumes, configs) {
+		klog.V(4).Infof("Placing the pod template of the %s %s/%s at admission", req.Kind.Kind, req.Namespace,
+			req.Name)
+	}
+
+	// the patch is computed against the workload of the request decoded into the API types too, so that it never
+	// removes the fields unknown to them
+	marshaledOriginal, err := json.Marshal(original)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	marshaledObj, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(marshaledOriginal, marshaledObj)
+}
+
+// placeTemplate sets the node affinity of the pod template of a workload of the namespace, if the architectures of
+// all its images are cached, and marks the template as placed. It returns false when the template is left to the
+// placement of its pods, including when the node affinity would depend on the nodes of the cluster, i.e., when the
+// additional node selector terms apply or some nodes only have the betaArchLabel label.
+func (a *WorkloadTemplateMutatingWebHook) placeTemplate(ctx context.Context, namespace string,
+	template *corev1.PodTemplateSpec, volumes []imageVolume, configs *podPlacementConfigs) bool {
+	if a.ArchitecturesCache == nil {
+		return false
+	}
+	// the pod the template would create, as seen by the PodSchedulingGateMutatingWebHook
+	pod := &corev1.Pod{ObjectMeta: *template.ObjectMeta.DeepCopy(), Spec: *template.Spec.DeepCopy()}
+	pod.Namespace = namespace
+	a.Instance.setImageVolumes(pod, volumes)
+	if a.Instance.hasArchitecturesOverride(pod) || a.Instance.hasPlacementDecision(pod) ||
+		configs.skipSingleArchCluster {
+		return false
+	}
+	policy, err := mergePlacementPolicy(ctx, a.Client, namespace, configs.items)
+	if err != nil {
+		klog.Warningf("Unable to get the placement settings for the namespace %s: %v", namespace, err)
+		return false
+	}
+	if isOptedOut(policy) {
+		return false
+	}
+	placed, excluded := withoutExcludedContainers(pod, policy)
+	if len(a.Instance.podImageReferences(placed)) == 0 ||
+		imagesMatchTrustedPrefixes(a.Instance, placed, configs.trustedPrefixes) {
+		return false
+	}
+	supportedArchitectures, ok := cachedArchitectures(ctx, a.ArchitecturesCache, a.Instance, placed)
+	if !ok {
+		return false
+	}
+	decision, ok := placeRequirement(ctx, a.Client, nil, nil, a.Instance, pod, policy, corev1.NodeSelectorRequirement{
+		Key:      archLabel,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   sets.List(supportedArchitectures),
+	})
+	if !ok || decision.betaArchLabelFallback {
+		return false
+	}
+	decision.additionalRequirements = additionalNodeSelectorRequirements(configs.items)
+	if len(decision.additionalRequirementsOf(*decision.requirement)) > 0 {
+		return false
+	}
+	// the architectures providing the extended resources change with the nodes: the pods requesting the mapped ones
+	// are placed one by one
+	for name := range extendedResourceMapping(configs.items) {
+		if requestedResourceNames(pod).Has(name) {
+			return false
+		}
+	}
+
+	if decision.preferred {
+		setPodPreferredNodeAffinity(pod, *decision.requirement, nil, false)
+	} else {
+		setPodNodeAffinityRequirement(ctx, pod, *decision.requirement, nil, false)
+	}
+	template.Spec.Affinity = pod.Spec.Affinity
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	for key, value := range decision.annotations {
+		template.Annotations[key] = value
+	}
+	if len(excluded) > 0 {
+		template.Annotations[a.Instance.annotation(excludedContainersAnnotation)] = strings.Join(excluded, ",")
+	}
+	template.Annotations[a.Instance.annotation(placementDecisionAnnotation)] = placedByWorkloadTemplate
+	template.Annotations[a.Instance.annotation(workloadTemplateArchitecturesAnnotation)] =
+		strings.Join(decision.requirement.Values, ",")
+	if a.MutatedBy != "" {
+		template.Annotations[a.Instance.annotation(mutatedByAnnotation)] = a.MutatedBy
+	}
+	return true
+}
+
+// decodeWorkloadImageVolumes returns the image volumes of the pod template of the raw workload, see
+// decodeImageVolumes
+func decodeWorkloadImageVolumes(raw []byte) ([]imageVolume, error) {
+	workload := &rawWorkloadImageVolumes{}
+	if err := json.Unmarshal(raw, workload); err != nil {
+		return nil, err
+	}
+	template, err := json.Marshal(workload.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImageVolumes(template)
+}
+
+// removeWorkloadTemplatePlacement removes the node affinity requirement and the annotations set by the
+// WorkloadTemplateMutatingWebHook from the pod template, if it placed it. The requirement is only removed where it was
+// added: the terms of the node affinity it was added to are kept, and the ones it created are removed.
+func (i *Instance) removeWorkloadTemplatePlacement(template *corev1.PodTemplateSpec) {
+	value, ok := template.Annotations[i.annotation(workloadTemplateArchitecturesAnnotation)]
+	if !ok {
+		return
+	}
+	removeNodeAffinityRequirement(&template.Spec, corev1.NodeSelectorRequirement{
+		Key:      archLabel,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   strings.Split(value, ","),
+	})
+	for _, key := range []string{workloadTemplateArchitecturesAnnotation, placementReasonAnnotation,
+		mutatedByAnnotation, excludedContainersAnnotation} {
+		delete(template.Annotations, i.annotation(key))
+	}
+	if template.Annotations[i.annotation(placementDecisionAnnotation)] == placedByWorkloadTemplate {
+		delete(template.Annotations, i.annotation(placementDecisionAnnotation))
+	}
+}
+
+// removeNodeAffinityRequirement removes the requirement from the required node selector terms of the pod spec, and
+// the preferred terms only made of it with the preferredArchitecturesWeight. The terms and the node affinity left
+// empty by the removal are removed too.
+func removeNodeAffinityRequirement(spec *corev1.PodSpec, requirement corev1.NodeSelectorRequirement) {
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil {
+		return
+	}
+	nodeAffinity := spec.Affinity.NodeAffinity
+	if required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		terms := make([]corev1.NodeSelectorTerm, 0, len(required.NodeSelectorTerms))
+		for _, term := range required.NodeSelectorTerms {
+			expressions := make([]corev1.NodeSelectorRequirement, 0, len(term.MatchExpressions))
+			for _, expression := range term.MatchExpressions {
+				if !equality.Semantic.DeepEqual(expression, requirement) {
+					expressions = append(expressions, expression)
+				}
+			}
+			if len(expressions) < len(term.MatchExpressions) {
+				if len(expressions) == 0 && len(term.MatchFields) == 0 {
+					continue
+				}
+				term.MatchExpressions = expressions
+			}
+			terms = append(terms, term)
+		}
+		required.NodeSelectorTerms = terms
+		if len(terms) == 0 {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+		}
+	}
+	var preferred []corev1.PreferredSchedulingTerm
+	for _, term := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if term.Weight == preferredArchitecturesWeight && len(term.Preference.MatchFields) == 0 &&
+			equality.Semantic.DeepEqual(term.Preference.MatchExpressions, []corev1.NodeSelectorRequirement{requirement}) {
+			continue
+		}
+		preferred = append(preferred, term)
+	}
+	nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = preferred
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil && len(preferred) == 0 {
+		spec.Affinity.NodeAffinity = nil
+	}
+	if equality.Semantic.DeepEqual(*spec.Affinity, corev1.Affinity{}) {
+		spec.Affinity = nil
+	}
+}
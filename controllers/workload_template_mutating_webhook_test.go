@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+var _ = Describe("The workload template webhook", func() {
+	var (
+		architectures *fakeArchitectures
+		c             client.Client
+		webhook       *WorkloadTemplateMutatingWebHook
+		ppc           *multiarchv1alpha1.PodPlacementConfig
+	)
+
+	BeforeEach(func() {
+		architectures = &fakeArchitectures{cached: map[string][]string{
+			"//quay.io/org/app:v1":        {"amd64", "arm64"},
+			"//quay.io/org/arm64-only:v1": {"arm64"},
+		}}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(multiarchv1alpha1.AddToScheme(scheme)).To(Succeed())
+		ppc = &multiarchv1alpha1.PodPlacementConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Spec:       multiarchv1alpha1.PodPlacementConfigSpec{MutateWorkloadTemplates: true},
+		}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(ppc).Build()
+		webhook = &WorkloadTemplateMutatingWebHook{Client: c, ArchitecturesCache: architectures}
+		Expect(webhook.InjectDecoder(admission.NewDecoder(scheme))).To(Succeed())
+	})
+
+	deployment := func(images ...string) *appsv1.Deployment {
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"}}
+		for _, image := range images {
+			d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, corev1.Container{Image: image})
+		}
+		return d
+	}
+
+	// admit returns the object of the request mutated by the patch of the response
+	admit := func(operation admissionv1.Operation, kind metav1.GroupVersionKind, obj, mutated client.Object) {
+		raw, err := json.Marshal(obj)
+		Expect(err).NotTo(HaveOccurred())
+		response := webhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Kind:      kind,
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		if len(response.Patches) > 0 {
+			patch, err := json.Marshal(response.Patches)
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := jsonpatch.DecodePatch(patch)
+			Expect(err).NotTo(HaveOccurred())
+			raw, err = decoded.Apply(raw)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(json.Unmarshal(raw, mutated)).To(Succeed())
+	}
+	deploymentKind := metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	admitDeployment := func(operation admissionv1.Operation, d *appsv1.Deployment) *appsv1.Deployment {
+		mutated := &appsv1.Deployment{}
+		admit(operation, deploymentKind, d, mutated)
+		return mutated
+	}
+
+	It("should set the node affinity of the templates whose images are all cached", func() {
+		mutated := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1", "quay.io/org/arm64-only:v1"))
+		template := mutated.Spec.Template
+		Expect(template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).
+			To(Equal([]corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}},
+			}}}))
+		Expect(template.Annotations).To(HaveKeyWithValue(placementDecisionAnnotation, placedByWorkloadTemplate))
+		Expect(template.Annotations).To(HaveKeyWithValue(workloadTemplateArchitecturesAnnotation, "arm64"))
+		Expect(template.Spec.SchedulingGates).To(BeEmpty())
+	})
+
+	It("should leave the templates with images not cached to the placement of their pods", func() {
+		mutated := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1", "quay.io/org/unknown:v1"))
+		Expect(mutated).To(Equal(deployment("quay.io/org/app:v1", "quay.io/org/unknown:v1")))
+	})
+
+	It("should not mutate the templates unless a PodPlacementConfig sets mutateWorkloadTemplates", func() {
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(ppc), ppc)).To(Succeed())
+		ppc.Spec.MutateWorkloadTemplates = false
+		Expect(c.Update(context.Background(), ppc)).To(Succeed())
+		mutated := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1"))
+		Expect(mutated.Spec.Template.Spec.Affinity).To(BeNil())
+	})
+
+	It("should be idempotent and follow the updates of the template", func() {
+		created := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1"))
+		created.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms[0].MatchExpressions = append([]corev1.NodeSelectorRequirement{{
+			Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"},
+		}}, created.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms[0].MatchExpressions...)
+		Expect(admitDeployment(admissionv1.Update, created)).To(Equal(created))
+
+		By("changing the images of the template")
+		created.Spec.Template.Spec.Containers[0].Image = "quay.io/org/arm64-only:v1"
+		updated := admitDeployment(admissionv1.Update, created)
+		Expect(updated.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+			{Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}},
+		}}}))
+		Expect(updated.Spec.Template.Annotations).To(HaveKeyWithValue(workloadTemplateArchitecturesAnnotation, "arm64"))
+
+		By("using an image that is not cached")
+		updated.Spec.Template.Spec.Containers[0].Image = "quay.io/org/unknown:v1"
+		uncached := admitDeployment(admissionv1.Update, updated)
+		Expect(uncached.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.
+			NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+		}}}))
+		Expect(uncached.Spec.Template.Annotations).NotTo(HaveKey(placementDecisionAnnotation))
+		Expect(uncached.Spec.Template.Annotations).NotTo(HaveKey(workloadTemplateArchitecturesAnnotation))
+	})
+
+	It("should remove the node affinity it created when it no longer applies", func() {
+		created := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1"))
+		Expect(created.Spec.Template.Spec.Affinity).NotTo(BeNil())
+		created.Spec.Template.Spec.Containers[0].Image = "quay.io/org/unknown:v1"
+		Expect(admitDeployment(admissionv1.Update, created).Spec.Template.Spec.Affinity).To(BeNil())
+	})
+
+	It("should only mutate the pod templates of the Jobs at their creation", func() {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "test"}}
+		job.Spec.Template.Spec.Containers = []corev1.Container{{Image: "quay.io/org/app:v1"}}
+		jobKind := metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}
+		updated := &batchv1.Job{}
+		admit(admissionv1.Update, jobKind, job, updated)
+		Expect(updated.Spec.Template.Spec.Affinity).To(BeNil())
+		created := &batchv1.Job{}
+		admit(admissionv1.Create, jobKind, job, created)
+		Expect(created.Spec.Template.Annotations).To(HaveKeyWithValue(placementDecisionAnnotation,
+			placedByWorkloadTemplate))
+	})
+
+	It("should admit the pods of the placed templates without gating them", func() {
+		created := admitDeployment(admissionv1.Create, deployment("quay.io/org/app:v1"))
+		podWebhook := &PodSchedulingGateMutatingWebHook{Client: c}
+		Expect(podWebhook.InjectDecoder(admission.NewDecoder(newTestScheme()))).To(Succeed())
+		pod := &corev1.Pod{ObjectMeta: created.Spec.Template.ObjectMeta, Spec: created.Spec.Template.Spec}
+		pod.Name, pod.Namespace = "app-1", "test"
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		response := podWebhook.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+})
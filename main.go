@@ -17,31 +17,61 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"k8s.io/klog/v2"
 	"multiarch-operator/pkg/system_config"
 	"os"
+	"strings"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cliflag "k8s.io/component-base/cli/flag"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	ocpv1 "github.com/openshift/api/config/v1"
+	imagev1 "github.com/openshift/api/image/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
 	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
 	"multiarch-operator/controllers"
+	"multiarch-operator/controllers/core"
 	multiarchcontrollers "multiarch-operator/controllers/multiarch"
+	"multiarch-operator/pkg/bindaddress"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/image/inspect"
+	"multiarch-operator/pkg/version"
 	//+kubebuilder:scaffold:imports
 )
 
+const (
+	// operatorMode is the default value of the --mode flag, running the operator
+	operatorMode = "operator"
+	// customResourcesWatchInterval is the interval of the checks of the CRDs of the operator, when it is started
+	// without them
+	customResourcesWatchInterval = time.Minute
+)
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -50,6 +80,9 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
+	utilruntime.Must(ocpv1.AddToScheme(scheme))
+	utilruntime.Must(imagev1.AddToScheme(scheme))
+	utilruntime.Must(operatorv1alpha1.Install(scheme))
 	utilruntime.Must(multiarchv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
@@ -58,11 +91,378 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	var webhookAddr string
+	var enableCapacityFeasibility bool
+	var capacityRefreshInterval time.Duration
+	var extendedResourcesRefreshInterval time.Duration
+	var analyzeBlockedRegistries bool
+	var blockedRegistriesAnalysisInterval time.Duration
+	var resolveImageStreams bool
+	var podBatchWorkers int
+	var decisionCacheTTL time.Duration
+	var blockMirrorsOfBlockedRegistries bool
+	var watchNamespaces []string
+	var registryTLSMinVersion string
+	var registryTLSCipherSuites []string
+	var registryTLSMinVersionOverrides map[string]string
+	var inspectionMetricsMaxRegistries int
+	var sigstoreAttachmentsConfigMap string
+	var registriesDir string
+	var registriesOutput string
+	var bootstrapRegistriesConf string
+	var policyOmitAtomicTransport bool
+	var gracefulShutdownTimeout time.Duration
+	var kubeletCompatibleCredentials bool
+	var reevaluatePendingPodsOnArchitectureChanges bool
+	var imageNotFoundCacheTTL time.Duration
+	var imageUnauthorizedCacheTTL time.Duration
+	var admissionFastPath bool
+	var maxGatedPodsPerNamespace int
+	var imageArchitecturesCacheTTL time.Duration
+	var imageInspectionTimeout time.Duration
+	var maxParallelInspections int
+	var maxManifestSize int64
+	var maxManifestPlatforms int
+	var mode string
+	var nodeSyncerImage string
+	var nodeSyncerNamespace string
+	var nodeSyncerServiceAccount string
+	var nodeSyncerHostDir string
+	var namespaceDebugLogging bool
+	var debugLogQPS float64
+	var debugLogBurst int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var gatedPodsSweepInterval time.Duration
+	var eventDedupInterval time.Duration
+	var disableGatingWithoutCRDs bool
+	var placementAuditInterval time.Duration
+	var ownerAnnotationsDebounce time.Duration
+	var ownerAnnotationsExcludedNamespaces []string
+	var excludedNamespaces []string
+	var placementAuditSampleRate float64
+	var enablePlacementSimulation bool
+	var stampMutatedBy bool
+	var legacyDecisionAnnotations bool
+	var enableSummary bool
+	var gatedPodsSweepMinAge time.Duration
+	var pullSecretsCacheTTL time.Duration
+	var registryUserAgent string
+	var clusterID string
+	var multiArchReleasePayload bool
+	var registryAuditLog bool
+	var architecturesStatusInterval time.Duration
+	var schedulingGateName string
+	var legacySchedulingGateNames []string
+	var annotationPrefix string
+	var schedulingGateWebhookPath string
+	var mutatingWebhookConfiguration string
+	var gatingLabel string
+	var instanceName string
+	var schedulingLatencyWarningThreshold time.Duration
+	var recentDecisionsWindow time.Duration
+	var resyncPeriods system_config.ResyncPeriods
+	var pullSecretResyncPeriod time.Duration
+	var postSyncHook system_config.PostSyncHook
+	var postSyncHookCommand string
+	var icspMigrationReportConfigMap string
+	var validatingWebhookConfiguration string
+	var webhookService string
+	var certManagerIssuer string
+	var certManagerCertificate string
+	var certManagerTimeout time.Duration
+	var operatorServiceAccount string
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to, "+
+		"as host:port. The IPv6 hosts are enclosed in brackets, e.g., [::1]:8080. An empty host or [::] binds to "+
+		"both the IPv4 and the IPv6 addresses. 0 disables the metrics.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to, "+
+		"as host:port. The IPv6 hosts are enclosed in brackets, e.g., [::1]:8081. An empty host or [::] binds to "+
+		"both the IPv4 and the IPv6 addresses. 0 disables the probes.")
+	flag.StringVar(&webhookAddr, "webhook-bind-address", ":9443", "The address the webhook server binds to, "+
+		"as host:port. The IPv6 hosts are enclosed in brackets, e.g., [::1]:9443. An empty host or [::] binds to "+
+		"both the IPv4 and the IPv6 addresses. The port must match the targetPort of the webhook Service.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableCapacityFeasibility, "enable-capacity-feasibility", false,
+		"Exclude from the node affinity of the pods the architectures that have not enough allocatable capacity "+
+			"to fit the pods' requests, as long as at least one architecture remains.")
+	flag.DurationVar(&capacityRefreshInterval, "capacity-refresh-interval", time.Minute,
+		"The interval at which the per-architecture allocatable capacity is refreshed. "+
+			"Only used when --enable-capacity-feasibility is set.")
+	flag.DurationVar(&extendedResourcesRefreshInterval, "extended-resources-refresh-interval", time.Minute,
+		"The interval at which the architectures of the nodes providing the extended resources of the "+
+			"extendedResourceArchitectures of the PodPlacementConfig objects are refreshed.")
+	flag.BoolVar(&analyzeBlockedRegistries, "analyze-blocked-registries", false,
+		"Look for the pods using images from the registries blocked in the image.config.openshift.io/cluster object "+
+			"and report them through an event and a metric. It lists all the pods in the cluster at every change of "+
+			"the blocked registries: keep it disabled on very large clusters.")
+	flag.DurationVar(&blockedRegistriesAnalysisInterval, "blocked-registries-analysis-interval", 5*time.Minute,
+		"The minimum interval between two analyses of the pods using blocked registries.")
+	flag.BoolVar(&resolveImageStreams, "resolve-image-streams", false,
+		"Read the architectures of the images pulled from the OpenShift internal registry from their "+
+			"ImageStreamTag or ImageStreamImage objects instead of inspecting them in the registry. "+
+			"The registry inspection is used as a fallback on any error.")
+	flag.DurationVar(&decisionCacheTTL, "decision-cache-ttl", controllers.DefaultDecisionCacheTTL,
+		"The time the placement decision computed for a gated pod is reused for the next pods of the same controller "+
+			"and pod template, placing them without inspecting their images again. The decisions are invalidated by "+
+			"any change of the placement settings. Zero disables the cache.")
+	flag.IntVar(&podBatchWorkers, "pod-batch-workers", 0,
+		"When greater than zero, the node affinity computed for a gated pod is applied to the other gated pods of the "+
+			"same controller and pod template, updating them concurrently with the given number of workers.")
+	flag.BoolVar(&blockMirrorsOfBlockedRegistries, "block-mirrors-of-blocked-registries", false,
+		"Block the mirrors of the registries blocked in the image.config.openshift.io/cluster object too, "+
+			"so that the images of a blocked registry cannot be pulled from its mirrors.")
+	flag.Var(cliflag.NewStringSlice(&watchNamespaces), "watch-namespaces",
+		"Comma-separated list of the namespaces whose pods are gated and placed by the operator. If omitted, all the "+
+			"namespaces are watched. The namespaces prefixed by openshift-, hypershift- and kube-, and the ones "+
+			"matching --excluded-namespaces, are not allowed. "+
+			"It cannot be used with --enable-capacity-feasibility, that needs the pods of all the namespaces.")
+	flag.StringVar(&registryTLSMinVersion, "registry-tls-min-version", "VersionTLS12",
+		"The minimum TLS version required to the registries. Possible values: "+
+			strings.Join(cliflag.TLSPossibleVersions(), ", ")+".")
+	flag.Var(cliflag.NewStringSlice(&registryTLSCipherSuites), "registry-tls-cipher-suites",
+		"Comma-separated list of the cipher suites allowed for the connections to the registries using TLS 1.2 "+
+			"or lower. If omitted, any cipher suite is allowed. Possible values: "+
+			strings.Join(cliflag.TLSCipherPossibleValues(), ", ")+".")
+	flag.Var(cliflag.NewMapStringString(&registryTLSMinVersionOverrides), "registry-tls-min-version-overrides",
+		"Comma-separated list of <registry host>=<TLS version> pairs overriding --registry-tls-min-version "+
+			"for legacy registries, e.g., registry.example.com:5000=VersionTLS10.")
+	flag.IntVar(&inspectionMetricsMaxRegistries, "image-inspection-metrics-max-registries",
+		image.DefaultInspectionMetricsMaxRegistries,
+		"The number of registry hosts labeled by name in the image inspection metrics. The hosts inspected after "+
+			"this number is reached are labeled as \"other\".")
+	flag.StringVar(&sigstoreAttachmentsConfigMap, "sigstore-attachments-configmap", "",
+		"The <namespace>/<name> of the ConfigMap declaring the sigstore attachments configuration of the registries, "+
+			"written to the --registries-d-dir directory. Each key is a registry host and each value is a YAML "+
+			"document with the useSigstoreAttachments and lookaside fields. If omitted, no registries.d file is written.")
+	flag.StringVar(&icspMigrationReportConfigMap, "icsp-migration-report-configmap", "",
+		"The <namespace>/<name> of the ConfigMap the ImageDigestMirrorSet objects equivalent to the "+
+			"ImageContentSourcePolicy objects are reported to, one YAML document per ImageContentSourcePolicy, to "+
+			"plan their migration. The ImageDigestMirrorSet objects are not created. If omitted, no report is written.")
+	flag.StringVar(&registriesDir, "registries-d-dir", system_config.RegistriesDirPath,
+		"The registries.d directory the sigstore attachments configuration of the registries is written to.")
+	flag.StringVar(&registriesOutput, "registries-output", string(system_config.RegistriesOutputFull),
+		"How the configuration of the registries is written: full writes the whole registries.conf file, dropin only "+
+			"writes the mirrors and the blocked, allowed and insecure registries to a drop-in file of the "+
+			"registries.conf.d directory, leaving the registries.conf file alone.")
+	flag.StringVar(&bootstrapRegistriesConf, "bootstrap-registries-conf", "",
+		"The registries.conf file loaded as the initial configuration of the registries, e.g., the mirrors of an "+
+			"air-gapped cluster at its bootstrap, before any cluster object is read. The cluster sources override it "+
+			"field by field as they appear. An invalid file fails the startup. If omitted, the defaults are used.")
+	flag.BoolVar(&policyOmitAtomicTransport, "policy-omit-atomic-transport", false,
+		"Omit the deprecated atomic transport from the policy.json file. By default, it is written with the same "+
+			"entries as the docker transport.")
+	flag.StringVar(&postSyncHook.SentinelFile, "post-sync-hook-sentinel-file", "",
+		"The file touched by the node syncer after the syncs that change the registries.conf file, e.g., for the "+
+			"tools of the node to reload CRI-O. If omitted, no file is touched.")
+	flag.StringVar(&postSyncHookCommand, "post-sync-hook-command", "",
+		"The command run by the node syncer after the syncs that change the registries.conf file, split on the "+
+			"white spaces, e.g., \"pkill -HUP crio\". If omitted, no command is run.")
+	flag.DurationVar(&postSyncHook.Timeout, "post-sync-hook-timeout", system_config.DefaultPostSyncHookTimeout,
+		"The time the --post-sync-hook-command can run for before it is killed.")
+	flag.DurationVar(&postSyncHook.MinInterval, "post-sync-hook-min-interval",
+		system_config.DefaultPostSyncHookMinInterval, "The minimum time between two runs of the post-sync hook: "+
+			"the changes of the registries.conf file made meanwhile run it once at the end of the interval.")
+	flag.DurationVar(&gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second,
+		"The time the manager waits for the in-flight reconciles and the other runnables to complete on shutdown.")
+	flag.BoolVar(&kubeletCompatibleCredentials, "kubelet-compatible-credentials", false,
+		"Resolve the credentials of the images with the kubelet semantics: the credentials of the pod's pull secrets "+
+			"matching the image, the most specific first, and then the global pull secret's ones are tried in order "+
+			"until the inspection succeeds. If false, the pull secrets are merged and one credential per registry is used.")
+	flag.BoolVar(&reevaluatePendingPodsOnArchitectureChanges, "reevaluate-pending-pods-on-architecture-changes", false,
+		"When an architecture appears in or disappears from the schedulable nodes, re-evaluate the pending, not yet "+
+			"scheduled pods: the gated ones whose images support an added architecture are placed again right away "+
+			"and, with --enable-capacity-feasibility, the placed ones whose architectures allowed by the capacity "+
+			"changed are reported through an event: they are placed again when recreated.")
+	flag.DurationVar(&imageNotFoundCacheTTL, "image-not-found-cache-ttl", image.DefaultNotFoundCacheTTL,
+		"The time the inspections of the images whose manifest or repository is missing are cached. "+
+			"Zero disables their caching.")
+	flag.DurationVar(&imageUnauthorizedCacheTTL, "image-unauthorized-cache-ttl", image.DefaultUnauthorizedCacheTTL,
+		"The time the inspections rejected by the registry for the credentials used are cached, per pull secrets. "+
+			"Zero disables their caching. The other failed inspections, e.g., the network errors, are never cached.")
+	flag.BoolVar(&admissionFastPath, "admission-fast-path", false,
+		"Set the node affinity of the pods at admission when the architectures of all their images are cached, "+
+			"instead of gating them until the reconciler inspects their images. The pods placed at admission are "+
+			"annotated with multiarch.openshift.io/placement-decision=webhook.")
+	flag.IntVar(&maxGatedPodsPerNamespace, "max-gated-pods-per-namespace", 5000,
+		"The maximum number of the gated pods of a namespace. The pods of the namespaces with as many gated pods are "+
+			"admitted without the scheduling gate, with a warning, until their backlog drains. Zero means no limit.")
+	flag.DurationVar(&imageArchitecturesCacheTTL, "image-architectures-cache-ttl", 0,
+		"The time the architectures of the inspected images are cached. The images are inspected again once "+
+			"their entry expires, and the expired entries are not used at admission. Zero caches them forever.")
+	flag.DurationVar(&imageInspectionTimeout, "image-inspection-timeout", image.DefaultInspectionTimeout,
+		"The time an inspection of an image can take. The concurrent lookups of the same image share its inspection, "+
+			"that is not cancelled with the lookup that started it. Zero disables the timeout.")
+	flag.IntVar(&maxParallelInspections, "max-parallel-inspections", 0,
+		"The number of the inspections of images running at the same time. The inspections exceeding it wait for a "+
+			"slot within their timeout. Zero means no limit.")
+	flag.Int64Var(&maxManifestSize, "max-manifest-size", image.DefaultMaxManifestSize,
+		"The size, in bytes, of the largest manifest or manifest list inspected. The inspections of the images with "+
+			"a larger manifest fail and are not retried. It is checked once containers/image read the manifest, up "+
+			"to 4 MiB: larger values have no effect.")
+	flag.IntVar(&maxManifestPlatforms, "max-manifest-platforms", image.DefaultMaxManifestPlatforms,
+		"The number of the manifests of the largest manifest list inspected, including the attestation manifests. "+
+			"The inspections of the images with more manifests fail and are not retried.")
+	flag.StringVar(&mode, "mode", operatorMode,
+		"The mode of the manager: "+operatorMode+" runs the operator, "+multiarchcontrollers.NodeSyncerMode+
+			" only runs the system config syncer, in the pods of the node syncer DaemonSet.")
+	flag.StringVar(&nodeSyncerImage, "node-syncer-image", "",
+		"The image of the node syncer DaemonSet, managed by the operator for the PodPlacementConfig to write the "+
+			"system config files to every node. If omitted, the node syncer is not deployed.")
+	flag.StringVar(&nodeSyncerNamespace, "node-syncer-namespace", "openshift-multiarch-operator",
+		"The namespace of the node syncer DaemonSet.")
+	flag.StringVar(&nodeSyncerServiceAccount, "node-syncer-service-account", "multiarch-operator-node-syncer",
+		"The service account of the node syncer pods. The default one is bound to the read-only node-syncer-role.")
+	flag.StringVar(&nodeSyncerHostDir, "node-syncer-host-dir", "/etc/multiarch-operator",
+		"The directory of the nodes the node syncer writes the containers and docker directories of the system "+
+			"config files to.")
+	flag.BoolVar(&namespaceDebugLogging, "namespace-debug-logging", false,
+		"Log the traces of the placement decisions, including the manifests, the pull sources and the credentials "+
+			"tried for the images, of the pods of the namespaces annotated with multiarch.openshift.io/debug=true, "+
+			"regardless of the verbosity. The secrets are never logged.")
+	flag.Float64Var(&debugLogQPS, "debug-log-qps", 10,
+		"The maximum number of traces per second logged for the namespaces with debug logging enabled. "+
+			"The traces exceeding the limit are dropped.")
+	flag.IntVar(&debugLogBurst, "debug-log-burst", 100,
+		"The maximum burst of traces logged for the namespaces with debug logging enabled.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", core.DefaultKubeAPIQPS,
+		"The maximum number of requests per second of the clients of the operator to the API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", core.DefaultKubeAPIBurst,
+		"The maximum burst of requests of the clients of the operator to the API server.")
+	flag.DurationVar(&gatedPodsSweepInterval, "gated-pods-sweep-interval", 0,
+		"The interval of the sweeps requeueing the pods gated for longer than --gated-pods-sweep-min-age ahead of "+
+			"their backoff. When set, all the gated pods are also requeued as soon as the global pull secret or the "+
+			"registry certificates change. Zero disables the sweeps.")
+	flag.DurationVar(&pullSecretsCacheTTL, "pull-secrets-cache-ttl", controllers.DefaultPullSecretsCacheTTL,
+		"The time the pull secrets of the pods are cached, so that the pods of the same workload are placed with a "+
+			"single read of each secret. Zero disables the cache.")
+	flag.DurationVar(&gatedPodsSweepMinAge, "gated-pods-sweep-min-age", 5*time.Minute,
+		"The minimum age of the gated pods requeued by the periodic sweeps.")
+	flag.DurationVar(&eventDedupInterval, "event-dedup-interval", 0,
+		"The minimum interval between two identical events of the reconciler for the pods of the same owner, e.g., "+
+			"the Placed events of the pods of a CronJob. The pods of all the Jobs of a CronJob share the same owner. "+
+			"Zero emits all the events.")
+	flag.BoolVar(&disableGatingWithoutCRDs, "disable-gating-without-crds", false,
+		"Do not gate the pods when the PodPlacementConfig and PodPlacementPolicy CRDs are not installed. By default, "+
+			"the pods are gated and placed with the default placement settings.")
+	flag.DurationVar(&placementAuditInterval, "placement-audit-interval", 0,
+		"The interval of the audits verifying that the running pods placed by the operator run on an architecture "+
+			"their images support. The mismatches are reported through a PlacementMismatch event and the "+
+			"multiarch_placement_mismatches_total metric. Zero disables the audits.")
+	flag.Float64Var(&placementAuditSampleRate, "placement-audit-sample-rate", 0.1,
+		"The fraction of the running placed pods audited at each audit, between 0 and 1.")
+	flag.DurationVar(&ownerAnnotationsDebounce, "owner-annotations-debounce", 0,
+		"Annotate the top-level owner of the placed pods, e.g., their Deployment, with the "+
+			"multiarch.openshift.io/placement-summary annotation once no pod of the same controller was placed for "+
+			"this time, so that a rollout patches the owner once. Zero disables the owner annotations.")
+	flag.Var(cliflag.NewStringSlice(&ownerAnnotationsExcludedNamespaces), "owner-annotations-excluded-namespaces",
+		"Comma-separated list of the namespaces whose owners are never annotated, in addition to the protected ones.")
+	flag.Var(cliflag.NewStringSlice(&excludedNamespaces), "excluded-namespaces",
+		"Comma-separated list of the glob patterns of the namespaces whose pods are never gated, e.g., team-*-infra, "+
+			"in addition to the protected namespaces prefixed by openshift-, hypershift- and kube-. The webhook "+
+			"checks them even if the namespaceSelector of the webhook configuration would gate their pods.")
+	flag.StringVar(&registryUserAgent, "registry-user-agent", "",
+		"The User-Agent of the requests to the registries. It defaults to multiarch-operator/<version> "+
+			"(cluster-id <cluster ID>).")
+	flag.StringVar(&clusterID, "cluster-id", "",
+		"The ID of the cluster in the default User-Agent of the requests to the registries. It defaults to the "+
+			"cluster ID of the ClusterVersion object on OpenShift.")
+	flag.BoolVar(&multiArchReleasePayload, "multi-arch-release-payload", false,
+		"Trust the organization of the release image of the ClusterVersion object, e.g., "+
+			"quay.io/openshift-release-dev, as a multi-arch prefix even when the desired update of the ClusterVersion "+
+			"object does not set the Multi architecture, e.g., on the clusters installed with a multi-arch payload.")
+	flag.BoolVar(&registryAuditLog, "registry-audit-log", false,
+		"Log the method, host, repository, status and latency of each request to the registries to the "+
+			"registry-audit logger.")
+	flag.DurationVar(&architecturesStatusInterval, "architectures-status-interval", 0,
+		"The minimum interval between two refreshes of the clusterArchitectures and pendingGatedPods of the "+
+			"status of the PodPlacementConfig. The status is only updated when they change. Zero disables them.")
+	flag.BoolVar(&enablePlacementSimulation, "enable-placement-simulation", false,
+		"Serve the dry-run simulation of the placement of the pods on "+controllers.PlacementSimulationPath+
+			" of the webhook server. The requests are authorized by a TokenReview and a SubjectAccessReview.")
+	flag.BoolVar(&enableSummary, "enable-summary", false,
+		"Serve the JSON summary of the state of the operator, e.g., for the console plugin, on "+
+			controllers.SummaryPath+" of the webhook server. The requests are authorized by a TokenReview and a "+
+			"SubjectAccessReview.")
+	flag.BoolVar(&stampMutatedBy, "stamp-mutated-by", false,
+		"Annotate the pods gated or placed by the webhook and the pods placed by the reconciler with the version of "+
+			"the operator, in the multiarch.openshift.io/mutated-by annotation.")
+	flag.BoolVar(&legacyDecisionAnnotations, "legacy-decision-annotations", true,
+		"Record the decisions of the placement of the pods by the individual annotations, e.g., "+
+			"multiarch.openshift.io/placement-reason, in addition to the multiarch.openshift.io/decision one. "+
+			"Deprecated: the individual annotations will no longer be set in the next release.")
+	flag.StringVar(&schedulingGateName, "scheduling-gate-name", controllers.DefaultSchedulingGateName,
+		"The name of the scheduling gate of the pods. The instances of the operator running in the same cluster, "+
+			"e.g., a staging one, must use different names: each instance only places the pods with its own gate.")
+	flag.Var(cliflag.NewStringSlice(&legacySchedulingGateNames), "legacy-scheduling-gate-names",
+		"Comma-separated list of the previous names of the scheduling gate, e.g., before a rename. The pods gated "+
+			"with them by the previous releases of the operator are placed and their legacy gate is removed. "+
+			"Defaults to the previous names of the default scheduling gate when --scheduling-gate-name is not set.")
+	flag.StringVar(&annotationPrefix, "annotation-prefix", controllers.DefaultAnnotationPrefix,
+		"The prefix of the annotations of the pods and namespaces read and set by the operator, e.g., "+
+			"staging.multiarch.openshift.io for the staging.multiarch.openshift.io/architectures annotation.")
+	flag.StringVar(&schedulingGateWebhookPath, "webhook-path", controllers.DefaultSchedulingGateWebhookPath,
+		"The path of the webhook gating the pods on the webhook server.")
+	flag.StringVar(&mutatingWebhookConfiguration, "mutating-webhook-configuration",
+		multiarchcontrollers.DefaultMutatingWebhookConfigurationName,
+		"The name of the MutatingWebhookConfiguration whose namespaceSelector is managed by the operator.")
+	flag.StringVar(&validatingWebhookConfiguration, "validating-webhook-configuration",
+		controllers.DefaultValidatingWebhookConfigurationName,
+		"The name of the ValidatingWebhookConfiguration of the webhooks validating the PodPlacementConfig and "+
+			"PodPlacementPolicy objects. Only used with --cert-manager-issuer.")
+	flag.StringVar(&webhookService, "webhook-service",
+		"openshift-multiarch-operator/multiarch-operator-webhook-service",
+		"The <namespace>/<name> of the Service of the webhook server. Only used with --cert-manager-issuer.")
+	flag.StringVar(&certManagerIssuer, "cert-manager-issuer", "",
+		"The issuer of the serving certificate of the webhooks, for the clusters without the OpenShift service CA "+
+			"operator: <name> of an Issuer in the namespace of --webhook-service, or Issuer/<name> or "+
+			"ClusterIssuer/<name>. The operator creates a cert-manager Certificate for the DNS names of the Service, "+
+			"waits for its secret, serves it, reloading it when it is renewed, and sets its CA as the caBundle of "+
+			"the webhook configurations. If omitted, or if the cert-manager CRDs are not installed, the certificate "+
+			"mounted in /var/run/manager/tls is served.")
+	flag.StringVar(&certManagerCertificate, "cert-manager-certificate", "multiarch-operator-serving-cert",
+		"The name of the cert-manager Certificate and of its secret, in the namespace of --webhook-service. Only "+
+			"used with --cert-manager-issuer.")
+	flag.DurationVar(&certManagerTimeout, "cert-manager-timeout", 5*time.Minute,
+		"The time cert-manager is given to issue the serving certificate of the webhooks at the start. Only used "+
+			"with --cert-manager-issuer.")
+	flag.StringVar(&operatorServiceAccount, "operator-service-account",
+		"openshift-multiarch-operator/multiarch-operator-controller-manager",
+		"The <namespace>/<name> of the service account of the operator pods. Their pods, and the ones of the node "+
+			"syncer, are never gated, so that a new pod of the operator does not wait for itself to be placed when "+
+			"no other replica runs. Empty disables it.")
+	flag.StringVar(&gatingLabel, "gating-label", "",
+		"The key of the namespace label assigning the namespaces to the instances of the operator running in the "+
+			"same cluster. When set, the webhook only gates the pods of the namespaces whose label value is "+
+			"--instance-name, or of the ones without the label if --instance-name is empty. The instances must "+
+			"share the same --gating-label.")
+	flag.StringVar(&instanceName, "instance-name", "",
+		"The name of the instance of the operator, e.g., staging: the value of the --gating-label of its namespaces. "+
+			"When set, it also prefixes the leader election ID.")
+	flag.DurationVar(&schedulingLatencyWarningThreshold, "scheduling-latency-warning-threshold", 0,
+		"Log a warning for the pods whose scheduling gate is removed more than this time after they were gated, as "+
+			"measured by the multiarch_scheduling_gate_latency_seconds metric. Zero disables the warnings.")
+	flag.DurationVar(&recentDecisionsWindow, "recent-decisions-window", 15*time.Minute,
+		"The window of the multiarch_decisions_recent metric, counting the pods placed requiring each architecture, "+
+			"exported along with the schedulable nodes of each architecture by the multiarch_nodes metric. Zero "+
+			"disables both metrics.")
+	flag.DurationVar(&resyncPeriods.RegistryCerts, "registry-certs-resync-period", core.DefaultResyncPeriod,
+		"The period at which the image-registry-certificates ConfigMap is read again by its watcher, spread by "+
+			"--resync-jitter. Zero disables the resyncs.")
+	flag.DurationVar(&resyncPeriods.Image, "image-config-resync-period", core.DefaultResyncPeriod,
+		"The period at which the image.config.openshift.io/cluster object is read again by its watcher, spread by "+
+			"--resync-jitter. Zero disables the resyncs.")
+	flag.DurationVar(&resyncPeriods.SigstoreAttachments, "sigstore-attachments-resync-period",
+		core.DefaultResyncPeriod, "The period at which the --sigstore-attachments-configmap ConfigMap is read "+
+			"again by its watcher, spread by --resync-jitter. Zero disables the resyncs.")
+	flag.DurationVar(&pullSecretResyncPeriod, "pull-secret-resync-period", core.DefaultResyncPeriod,
+		"The period at which the global pull secret is read again by its watcher, spread by --resync-jitter. "+
+			"Zero disables the resyncs.")
+	flag.Float64Var(&resyncPeriods.Jitter, "resync-jitter", core.DefaultResyncJitter,
+		"The fraction of its resync period the period of each watcher is randomly spread by, e.g., 0.1 for up to "+
+			"10% earlier or later, so that the watchers of all the replicas do not resync at the same time. "+
+			"Zero disables the jitter.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -74,14 +474,152 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if err := bindaddress.Validate("health-probe-bind-address", probeAddr, true); err != nil {
+		setupLog.Error(err, "invalid bind address")
+		os.Exit(1)
+	}
+
+	// all the clients share the configuration, and its limits
+	restConfig := ctrl.GetConfigOrDie()
+	core.ConfigureRESTConfig(restConfig, kubeAPIQPS, kubeAPIBurst)
+
+	switch mode {
+	case operatorMode:
+	case multiarchcontrollers.NodeSyncerMode:
+		if err := bindaddress.Validate("metrics-bind-address", metricsAddr, true); err != nil {
+			setupLog.Error(err, "invalid bind address")
+			os.Exit(1)
+		}
+		systemConfigSyncer := newSystemConfigSyncer(blockMirrorsOfBlockedRegistries, system_config.RegistriesDirPath,
+			sigstoreAttachmentsConfigMap, registriesOutput, bootstrapRegistriesConf, policyOmitAtomicTransport,
+			resyncPeriods)
+		postSyncHook.Command = strings.Fields(postSyncHookCommand)
+		systemConfigSyncer.SetPostSyncHook(postSyncHook)
+		core.SetRESTConfig(restConfig)
+		runNodeSyncer(restConfig, metricsAddr, probeAddr, gracefulShutdownTimeout, systemConfigSyncer)
+		return
+	default:
+		setupLog.Error(nil, "--mode must be one of "+operatorMode+", "+multiarchcontrollers.NodeSyncerMode)
+		os.Exit(1)
+	}
+
+	if err := bindaddress.Validate("metrics-bind-address", metricsAddr, true); err != nil {
+		setupLog.Error(err, "invalid bind address")
+		os.Exit(1)
+	}
+	webhookHost, webhookPort, err := bindaddress.Parse("webhook-bind-address", webhookAddr)
+	if err != nil {
+		setupLog.Error(err, "invalid bind address")
+		os.Exit(1)
+	}
+
+	tlsPolicy, err := image.NewTLSPolicy(registryTLSMinVersion, registryTLSCipherSuites, registryTLSMinVersionOverrides)
+	if err != nil {
+		setupLog.Error(err, "invalid registry TLS policy")
+		os.Exit(1)
+	}
+	image.SetTLSPolicy(tlsPolicy)
+	image.SetInspectionMetricsMaxRegistries(inspectionMetricsMaxRegistries)
+	image.SetKubeletCompatibleCredentials(kubeletCompatibleCredentials)
+	// the tuning of the PodPlacementConfig objects overrides the flags
+	tuning := controllers.DefaultTuning()
+	tuning.InspectionTimeout = imageInspectionTimeout
+	tuning.MaxParallelInspections = maxParallelInspections
+	tuning.ArchitecturesCacheTTL = imageArchitecturesCacheTTL
+	tuning.NotFoundCacheTTL, tuning.UnauthorizedCacheTTL = imageNotFoundCacheTTL, imageUnauthorizedCacheTTL
+	tuning.DecisionCacheTTL = decisionCacheTTL
+	tuning.GatedPodsSweepInterval = gatedPodsSweepInterval
+	controllers.SetTuningDefaults(tuning)
+	image.SetManifestLimits(maxManifestSize, maxManifestPlatforms)
+	image.SetPullSecretResyncPeriod(pullSecretResyncPeriod, resyncPeriods.Jitter)
+
+	if err := controllers.ValidateExcludedNamespaces(excludedNamespaces); err != nil {
+		setupLog.Error(err, "invalid --excluded-namespaces")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateWatchNamespaces(watchNamespaces, excludedNamespaces); err != nil {
+		setupLog.Error(err, "invalid --watch-namespaces")
+		os.Exit(1)
+	}
+	if len(watchNamespaces) > 0 && enableCapacityFeasibility {
+		setupLog.Error(nil, "--watch-namespaces cannot be used with --enable-capacity-feasibility")
+		os.Exit(1)
+	}
+	if len(watchNamespaces) > 0 && nodeSyncerImage != "" {
+		setupLog.Error(nil, "--watch-namespaces cannot be used with --node-syncer-image")
+		os.Exit(1)
+	}
+	if legacySchedulingGateNames == nil && schedulingGateName == controllers.DefaultSchedulingGateName {
+		legacySchedulingGateNames = controllers.DefaultLegacySchedulingGateNames
+	}
+	instance, err := controllers.NewInstance(schedulingGateName, annotationPrefix, legacySchedulingGateNames...)
+	if err != nil {
+		setupLog.Error(err, "invalid --scheduling-gate-name, --annotation-prefix or --legacy-scheduling-gate-names")
+		os.Exit(1)
+	}
+	var exemptServiceAccounts []types.NamespacedName
+	if operatorServiceAccount != "" {
+		namespace, name, ok := strings.Cut(operatorServiceAccount, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "--operator-service-account must be in the <namespace>/<name> format")
+			os.Exit(1)
+		}
+		exemptServiceAccounts = append(exemptServiceAccounts, types.NamespacedName{Namespace: namespace, Name: name})
+	}
+	if nodeSyncerImage != "" {
+		exemptServiceAccounts = append(exemptServiceAccounts,
+			types.NamespacedName{Namespace: nodeSyncerNamespace, Name: nodeSyncerServiceAccount})
+	}
+	if instanceName != "" && gatingLabel == "" {
+		setupLog.Error(nil, "--instance-name requires --gating-label")
+		os.Exit(1)
+	}
+	leaderElectionID := "208d7abd.multiarch.openshift.io"
+	if instanceName != "" {
+		if errs := validation.IsDNS1123Label(instanceName); len(errs) > 0 {
+			setupLog.Error(nil, "invalid --instance-name: "+strings.Join(errs, ", "))
+			os.Exit(1)
+		}
+		leaderElectionID = instanceName + "." + leaderElectionID
+	}
+
+	// the writes without an explicit field owner, e.g., the ones of the DaemonSet and of the status of the
+	// PodPlacementConfig, are recorded in the managedFields under the manager derived from the user agent
+	restConfig.UserAgent = controllers.FieldManager
+	core.SetRESTConfig(restConfig)
+	// the webhook server serves the certificate of its CertDir, unless cert-manager issues it
+	var webhookServer webhook.Server
+	var webhookCertificate *controllers.WebhookCertificate
+	if certManagerIssuer != "" {
+		webhookCertificate = requestWebhookCertificate(restConfig, webhookService, certManagerCertificate,
+			certManagerIssuer, certManagerTimeout, mutatingWebhookConfiguration, validatingWebhookConfiguration)
+	}
+	if webhookCertificate != nil {
+		webhookServer = webhook.NewServer(webhook.Options{
+			Host: webhookHost,
+			Port: webhookPort,
+			TLSOpts: []func(*tls.Config){func(config *tls.Config) {
+				config.GetCertificate = webhookCertificate.GetCertificate
+			}},
+		})
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
+		Host:                   webhookHost,
+		Port:                   webhookPort,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "208d7abd.multiarch.openshift.io",
+		LeaderElectionID:       leaderElectionID,
 		CertDir:                "/var/run/manager/tls",
+		WebhookServer:          webhookServer,
+		// The in-flight reconciles complete the update of the pods whose placement is decided within this timeout
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
+		// The pods are only cached in the watched namespaces, if any. The watchers of the OpenShift configuration
+		// objects use their own clients and are not affected.
+		Cache: cache.Options{
+			Namespaces: watchNamespaces,
+		},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -98,25 +636,229 @@ func main() {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	if webhookCertificate != nil {
+		if err := mgr.Add(webhookCertificate); err != nil {
+			setupLog.Error(err, "unable to add the webhook certificate to the manager")
+			os.Exit(1)
+		}
+	}
 
-	config := ctrl.GetConfigOrDie()
-	clientset := kubernetes.NewForConfigOrDie(config)
+	if registryUserAgent == "" {
+		if clusterID == "" {
+			clusterID = lookupClusterID(mgr.GetAPIReader())
+		}
+		registryUserAgent = image.UserAgent(version.Version, clusterID)
+	}
+	setupLog.Info("identifying the requests to the registries", "userAgent", registryUserAgent)
+	image.SetUserAgent(registryUserAgent)
+	if registryAuditLog {
+		image.SetRegistryAuditLogger(ctrl.Log.WithName("registry-audit"))
+	}
 
-	if err = (&controllers.PodReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		Clientset: clientset,
+	trustReleaseImagePrefix(mgr.GetAPIReader(), multiArchReleasePayload)
+
+	discoveryClient := discovery.NewDiscoveryClientForConfigOrDie(restConfig)
+	schedulingGatesSupported, err := controllers.SchedulingGatesSupported(discoveryClient)
+	if err != nil {
+		setupLog.Error(err, "unable to check whether the cluster supports the pod scheduling gates")
+		os.Exit(1)
+	}
+	if !schedulingGatesSupported {
+		setupLog.Info("The cluster does not support the pod scheduling gates (Kubernetes < 1.27): running in degraded " +
+			"mode. The pods will not be gated and no node affinity will be set for them.")
+	}
+	controllers.RecordFeatures(flag.CommandLine, !schedulingGatesSupported)
+
+	customResourcesInstalled, err := controllers.CustomResourcesInstalled(discoveryClient)
+	if err != nil {
+		setupLog.Error(err, "unable to check whether the PodPlacementConfig and PodPlacementPolicy CRDs are installed")
+		os.Exit(1)
+	}
+	if !customResourcesInstalled {
+		gating := "the pods are placed with the default placement settings"
+		if disableGatingWithoutCRDs {
+			gating = "the pods are not gated (--disable-gating-without-crds)"
+		}
+		setupLog.Info("The PodPlacementConfig and PodPlacementPolicy CRDs are not installed: the PodPlacementConfig " +
+			"controller, the management of the webhook configuration and of the node syncer and the validating " +
+			"webhooks are disabled, and " + gating + ". The operator restarts once the CRDs are installed.")
+		if err = mgr.Add(controllers.NewCustomResourcesWatcher(discoveryClient,
+			customResourcesWatchInterval)); err != nil {
+			setupLog.Error(err, "unable to add the custom resources watcher to the manager")
+			os.Exit(1)
+		}
+	}
+
+	var debugLogging *core.DebugLogging
+	if namespaceDebugLogging {
+		debugLogging = core.NewDebugLogging(float32(debugLogQPS), debugLogBurst)
+	}
+	podReconciler := &controllers.PodReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("multiarch-operator"),
+
+		// the pull secrets are the only objects read through a typed client: they are not cached by the manager
+		PullSecrets: controllers.NewPullSecretsCache(corev1client.NewForConfigOrDie(restConfig),
+			pullSecretsCacheTTL),
+		BatchWorkers:            podBatchWorkers,
+		Backoff:                 controllers.NewPlacementBackoff(),
+		DebugLogging:            debugLogging,
+		Instance:                instance,
+		CustomResourcesMissing:  !customResourcesInstalled,
+		LatencyWarningThreshold: schedulingLatencyWarningThreshold,
+	}
+	if decisionCacheTTL > 0 {
+		podReconciler.DecisionCache = controllers.NewDecisionCache(decisionCacheTTL)
+	}
+	if stampMutatedBy {
+		podReconciler.MutatedBy = version.Version
+	}
+	podReconciler.OmitLegacyAnnotations = !legacyDecisionAnnotations
+	if eventDedupInterval > 0 {
+		podReconciler.Recorder = controllers.NewDedupingEventRecorder(podReconciler.Recorder, eventDedupInterval)
+	}
+	if enableCapacityFeasibility {
+		podReconciler.CapacityCache = controllers.NewArchitectureCapacityCache(mgr.GetClient(), capacityRefreshInterval)
+		if err = mgr.Add(podReconciler.CapacityCache); err != nil {
+			setupLog.Error(err, "unable to add the architecture capacity cache to the manager")
+			os.Exit(1)
+		}
+	}
+	if customResourcesInstalled {
+		podReconciler.ExtendedResources = controllers.NewExtendedResourcesIndex(mgr.GetClient(),
+			extendedResourcesRefreshInterval)
+		if err = mgr.Add(podReconciler.ExtendedResources); err != nil {
+			setupLog.Error(err, "unable to add the extended resources index to the manager")
+			os.Exit(1)
+		}
+	}
+	if reevaluatePendingPodsOnArchitectureChanges {
+		podReconciler.NodeArchitectures = controllers.NewNodeArchitecturesReconciler(mgr.GetClient(),
+			mgr.GetEventRecorderFor("multiarch-operator"), instance)
+		podReconciler.NodeArchitectures.CapacityCache = podReconciler.CapacityCache
+		if err = podReconciler.NodeArchitectures.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NodeArchitectures")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.PodMetadataCleanupReconciler{
+		Client:   mgr.GetClient(),
+		Instance: instance,
 	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PodMetadataCleanup")
+		os.Exit(1)
+	}
+	if gatedPodsSweepInterval > 0 {
+		podReconciler.GatedPodsSweeper = controllers.NewGatedPodsSweeper(mgr.GetClient(), instance,
+			gatedPodsSweepInterval, gatedPodsSweepMinAge)
+		if err = mgr.Add(podReconciler.GatedPodsSweeper); err != nil {
+			setupLog.Error(err, "unable to add the gated pods sweeper to the manager")
+			os.Exit(1)
+		}
+		image.SetPullSecretObserver(podReconciler.GatedPodsSweeper)
+	}
+	if placementAuditInterval > 0 {
+		if placementAuditSampleRate < 0 || placementAuditSampleRate > 1 {
+			setupLog.Error(nil, "--placement-audit-sample-rate must be between 0 and 1")
+			os.Exit(1)
+		}
+		if err = mgr.Add(controllers.NewPlacementAuditor(mgr.GetClient(), mgr.GetEventRecorderFor("multiarch-operator"),
+			instance, placementAuditInterval, placementAuditSampleRate)); err != nil {
+			setupLog.Error(err, "unable to add the placement auditor to the manager")
+			os.Exit(1)
+		}
+	}
+	if ownerAnnotationsDebounce > 0 {
+		podReconciler.OwnerAnnotator = controllers.NewOwnerAnnotator(mgr.GetClient(), instance,
+			ownerAnnotationsDebounce, ownerAnnotationsExcludedNamespaces)
+		if err = mgr.Add(podReconciler.OwnerAnnotator); err != nil {
+			setupLog.Error(err, "unable to add the owner annotator to the manager")
+			os.Exit(1)
+		}
+	}
+	if recentDecisionsWindow > 0 {
+		podReconciler.RecentDecisions = controllers.NewRecentDecisions(mgr.GetClient(), recentDecisionsWindow)
+		if err = mgr.Add(podReconciler.RecentDecisions); err != nil {
+			setupLog.Error(err, "unable to add the recent decisions aggregator to the manager")
+			os.Exit(1)
+		}
+	}
+	if resolveImageStreams {
+		podReconciler.ImageStreamResolver = image.NewImageStreamResolver(mgr.GetAPIReader())
+	}
+	if err = podReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
-	if err = (&multiarchcontrollers.PodPlacementConfigReconciler{
+	podPlacementConfigReconciler := &multiarchcontrollers.PodPlacementConfigReconciler{
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
-		Clientset: clientset,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "PodPlacementConfig")
-		os.Exit(1)
+		APIReader: mgr.GetAPIReader(),
+
+		SchedulingGatesUnsupported:       !schedulingGatesSupported,
+		WatchNamespaces:                  watchNamespaces,
+		MutatingWebhookConfigurationName: mutatingWebhookConfiguration,
+		GatingLabel:                      gatingLabel,
+		InstanceName:                     instanceName,
+	}
+	if architecturesStatusInterval > 0 {
+		podPlacementConfigReconciler.ArchitecturesStatusInterval = architecturesStatusInterval
+		podPlacementConfigReconciler.ArchitecturesDistribution = func(ctx context.Context) (
+			[]multiarchv1alpha1.ArchitectureNodes, []multiarchv1alpha1.GatedPodsRequirement, error) {
+			return controllers.ArchitecturesDistribution(ctx, mgr.GetClient(), instance)
+		}
+	}
+	podPlacementConfigReconciler.ClusterArchitectures = func(ctx context.Context) ([]string, error) {
+		return controllers.ClusterArchitectures(ctx, mgr.GetClient(), podReconciler.CapacityCache)
+	}
+	if nodeSyncerImage != "" {
+		// the node syncer writes the files of the nodes: the syncer of the operator only writes the files of its own
+		// container, used by the inspections of the images
+		nodeSyncerArgs := []string{fmt.Sprintf("--block-mirrors-of-blocked-registries=%t", blockMirrorsOfBlockedRegistries),
+			"--registries-output=" + registriesOutput,
+			fmt.Sprintf("--policy-omit-atomic-transport=%t", policyOmitAtomicTransport)}
+		if sigstoreAttachmentsConfigMap != "" {
+			nodeSyncerArgs = append(nodeSyncerArgs, "--sigstore-attachments-configmap="+sigstoreAttachmentsConfigMap)
+		}
+		// the post-sync hook only runs in the node syncer, which limits its requests to the API server as the operator
+		flag.Visit(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, "post-sync-hook-") || strings.HasPrefix(f.Name, "kube-api-") {
+				nodeSyncerArgs = append(nodeSyncerArgs, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+			}
+		})
+		podPlacementConfigReconciler.NodeSyncer = &multiarchcontrollers.NodeSyncerOptions{
+			Image:              nodeSyncerImage,
+			Namespace:          nodeSyncerNamespace,
+			ServiceAccountName: nodeSyncerServiceAccount,
+			HostDir:            nodeSyncerHostDir,
+			Args:               nodeSyncerArgs,
+			PodAnnotations:     map[string]string{instance.ExcludePodPlacementAnnotation(): "true"},
+		}
+	}
+	if customResourcesInstalled {
+		if err = podPlacementConfigReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PodPlacementConfig")
+			os.Exit(1)
+		}
+		if err = (&multiarchcontrollers.PodPlacementPolicyReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PodPlacementPolicy")
+			os.Exit(1)
+		}
+		// the pods admitted without being gated while the cluster had a single architecture are reported once it has
+		// several ones
+		if err = (&controllers.SingleArchitectureReconciler{
+			Client:        mgr.GetClient(),
+			CapacityCache: podReconciler.CapacityCache,
+			Recorder:      mgr.GetEventRecorderFor("multiarch-operator"),
+			Instance:      instance,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "SingleArchitecture")
+			os.Exit(1)
+		}
 	}
 	//+kubebuilder:scaffold:builder
 
@@ -129,11 +871,131 @@ func main() {
 		os.Exit(1)
 	}
 
-	mgr.GetWebhookServer().Register("/add-pod-scheduling-gate", &webhook.Admission{Handler: &controllers.PodSchedulingGateMutatingWebHook{
-		Client: mgr.GetClient(),
-	}})
+	podPlacementConfigSnapshot := controllers.NewDefaultPodPlacementConfigSnapshot()
+	if customResourcesInstalled {
+		podPlacementConfigSnapshot = controllers.NewPodPlacementConfigSnapshot(mgr.GetCache(), mgr.GetCache())
+		if err = mgr.Add(podPlacementConfigSnapshot); err != nil {
+			setupLog.Error(err, "unable to add the snapshot of the PodPlacementConfig objects to the manager")
+			os.Exit(1)
+		}
+	}
+	schedulingGateWebhook := &controllers.PodSchedulingGateMutatingWebHook{
+		Client:                mgr.GetClient(),
+		SkipGating:            !schedulingGatesSupported || (!customResourcesInstalled && disableGatingWithoutCRDs),
+		CapacityCache:         podReconciler.CapacityCache,
+		ExtendedResources:     podReconciler.ExtendedResources,
+		DebugLogging:          debugLogging,
+		PodPlacementConfigs:   podPlacementConfigSnapshot,
+		RecordGatedAt:         true,
+		Instance:              instance,
+		ExcludedNamespaces:    excludedNamespaces,
+		ExemptServiceAccounts: exemptServiceAccounts,
+	}
+	if stampMutatedBy {
+		schedulingGateWebhook.MutatedBy = version.Version
+	}
+	schedulingGateWebhook.OmitLegacyAnnotations = !legacyDecisionAnnotations
+	if admissionFastPath {
+		schedulingGateWebhook.ArchitecturesCache = inspect.Singleton()
+	}
+	if maxGatedPodsPerNamespace > 0 {
+		schedulingGateWebhook.GatedPodsIndex = controllers.NewGatedPodsIndex(mgr.GetCache(), instance)
+		schedulingGateWebhook.MaxGatedPodsPerNamespace = maxGatedPodsPerNamespace
+		if err = mgr.Add(schedulingGateWebhook.GatedPodsIndex); err != nil {
+			setupLog.Error(err, "unable to add the index of the gated pods to the manager")
+			os.Exit(1)
+		}
+	}
+	// the manager does not inject the decoder into the handlers since controller-runtime v0.15
+	if err := schedulingGateWebhook.InjectDecoder(admission.NewDecoder(mgr.GetScheme())); err != nil {
+		setupLog.Error(err, "unable to set the decoder of the scheduling gate webhook")
+		os.Exit(1)
+	}
+	mgr.GetWebhookServer().Register(schedulingGateWebhookPath, &webhook.Admission{Handler: schedulingGateWebhook})
+	if customResourcesInstalled {
+		// the pod templates of the workloads are only mutated while a PodPlacementConfig sets mutateWorkloadTemplates:
+		// the PodPlacementConfigReconciler keeps the webhook from being called otherwise
+		workloadTemplateWebhook := &controllers.WorkloadTemplateMutatingWebHook{
+			Client:              mgr.GetClient(),
+			ArchitecturesCache:  inspect.Singleton(),
+			PodPlacementConfigs: podPlacementConfigSnapshot,
+			MutatedBy:           schedulingGateWebhook.MutatedBy,
+			Instance:            instance,
+			ExcludedNamespaces:  excludedNamespaces,
+		}
+		if err := workloadTemplateWebhook.InjectDecoder(admission.NewDecoder(mgr.GetScheme())); err != nil {
+			setupLog.Error(err, "unable to set the decoder of the workload template webhook")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register(controllers.WorkloadTemplateWebhookPath,
+			&webhook.Admission{Handler: workloadTemplateWebhook})
+	}
+	if enablePlacementSimulation {
+		mgr.GetWebhookServer().Register(controllers.PlacementSimulationPath, &controllers.PlacementSimulator{
+			Reconciler: podReconciler,
+			Client:     mgr.GetClient(),
+		})
+	}
+	if customResourcesInstalled {
+		if err := (&multiarchcontrollers.PodPlacementConfigValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PodPlacementConfig")
+			os.Exit(1)
+		}
+		if err := (&multiarchcontrollers.PodPlacementPolicyValidator{
+			Reader: mgr.GetAPIReader(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PodPlacementPolicy")
+			os.Exit(1)
+		}
+	}
 
-	system_config.SystemConfigSyncerSingleton()
+	systemConfigSyncer := newSystemConfigSyncer(blockMirrorsOfBlockedRegistries, registriesDir,
+		sigstoreAttachmentsConfigMap, registriesOutput, bootstrapRegistriesConf, policyOmitAtomicTransport,
+		resyncPeriods)
+	if err := mgr.Add(systemConfigSyncer); err != nil {
+		setupLog.Error(err, "unable to add the system config syncer to the manager")
+		os.Exit(1)
+	}
+	podPlacementConfigReconciler.ForceSystemConfigSync = func(ctx context.Context) error {
+		return systemConfigSyncer.ForceSync(ctx, mgr.GetAPIReader())
+	}
+	if analyzeBlockedRegistries {
+		analyzer := controllers.NewBlockedRegistriesAnalyzer(mgr.GetClient(),
+			mgr.GetEventRecorderFor("multiarch-operator"), blockedRegistriesAnalysisInterval)
+		if err := mgr.Add(analyzer); err != nil {
+			setupLog.Error(err, "unable to add the blocked registries analyzer to the manager")
+			os.Exit(1)
+		}
+		systemConfigSyncer.SetBlockedRegistriesObserver(analyzer)
+	}
+	if podReconciler.GatedPodsSweeper != nil {
+		systemConfigSyncer.SetRegistryCertsObserver(podReconciler.GatedPodsSweeper)
+	}
+	if icspMigrationReportConfigMap != "" {
+		namespace, name, ok := strings.Cut(icspMigrationReportConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "--icsp-migration-report-configmap must be in the <namespace>/<name> format")
+			os.Exit(1)
+		}
+		if err = (&controllers.ICSPMigrationReconciler{
+			Client:    mgr.GetClient(),
+			Reader:    mgr.GetAPIReader(),
+			Recorder:  mgr.GetEventRecorderFor("multiarch-operator"),
+			ConfigMap: types.NamespacedName{Namespace: namespace, Name: name},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ICSPMigration")
+			os.Exit(1)
+		}
+	}
+	if enableSummary {
+		mgr.GetWebhookServer().Register(controllers.SummaryPath, &controllers.SummaryServer{
+			Client:                     mgr.GetClient(),
+			PodPlacementConfigs:        podPlacementConfigSnapshot,
+			SchedulingGatesUnsupported: !schedulingGatesSupported,
+			SyncStatus:                 systemConfigSyncer.SyncStatus,
+			Instance:                   instance,
+		})
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -141,3 +1003,134 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// requestWebhookCertificate requests the serving certificate of the webhooks from cert-manager. It returns nil when
+// the cert-manager CRDs are not installed, for the webhook server to serve the certificate of its CertDir.
+func requestWebhookCertificate(restConfig *rest.Config, service, certificate, issuer string, timeout time.Duration,
+	mutatingWebhookConfiguration, validatingWebhookConfiguration string) *controllers.WebhookCertificate {
+	namespace, name, ok := strings.Cut(service, "/")
+	if !ok || namespace == "" || name == "" {
+		setupLog.Error(nil, "--webhook-service must be in the <namespace>/<name> format")
+		os.Exit(1)
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create the client requesting the webhook certificate")
+		os.Exit(1)
+	}
+	webhookCertificate, err := controllers.NewWebhookCertificate(c,
+		types.NamespacedName{Namespace: namespace, Name: name}, certificate, issuer)
+	if err != nil {
+		setupLog.Error(err, "invalid --cert-manager-issuer")
+		os.Exit(1)
+	}
+	webhookCertificate.MutatingWebhookConfigurations = []string{mutatingWebhookConfiguration}
+	webhookCertificate.ValidatingWebhookConfigurations = []string{validatingWebhookConfiguration}
+	if err := webhookCertificate.Request(context.Background(), timeout); err != nil {
+		if meta.IsNoMatchError(err) {
+			setupLog.Info("the cert-manager CRDs are not installed, serving the certificate of the webhook server "+
+				"directory", "error", err.Error())
+			return nil
+		}
+		setupLog.Error(err, "unable to request the webhook certificate from cert-manager")
+		os.Exit(1)
+	}
+	setupLog.Info("serving the webhook certificate issued by cert-manager", "certificate",
+		types.NamespacedName{Namespace: namespace, Name: certificate}, "issuer", issuer)
+	return webhookCertificate
+}
+
+//+kubebuilder:rbac:groups=config.openshift.io,resources=clusterversions,verbs=get
+
+// lookupClusterID returns the cluster ID of the ClusterVersion object, or an empty string on the clusters other than
+// OpenShift
+func lookupClusterID(reader client.Reader) string {
+	clusterVersion := &ocpv1.ClusterVersion{}
+	if err := reader.Get(context.Background(), client.ObjectKey{Name: "version"}, clusterVersion); err != nil {
+		setupLog.Info("unable to read the cluster ID from the ClusterVersion object, use --cluster-id to set it",
+			"error", err.Error())
+		return ""
+	}
+	return string(clusterVersion.Spec.ClusterID)
+}
+
+// trustReleaseImagePrefix trusts the organization of the release image of the cluster as a multi-arch prefix when the
+// release payload is multi-arch, so that the pods of the cluster components are not gated during the upgrades
+func trustReleaseImagePrefix(reader client.Reader, multiArchPayload bool) {
+	prefix, err := controllers.ReleaseImagePrefix(context.Background(), reader, multiArchPayload)
+	if err != nil {
+		setupLog.Info("unable to read the release image from the ClusterVersion object, its images are inspected",
+			"error", err.Error())
+		return
+	}
+	if prefix == "" {
+		setupLog.Info("the release payload is not multi-arch, its images are inspected")
+		return
+	}
+	if err := controllers.SetImplicitTrustedMultiArchPrefixes(prefix); err != nil {
+		setupLog.Error(err, "unable to trust the release image prefix", "prefix", prefix)
+		return
+	}
+	setupLog.Info("trusting the images of the multi-arch release payload, their pods are not gated", "prefix", prefix)
+}
+
+// newSystemConfigSyncer returns the system config syncer configured by the flags
+func newSystemConfigSyncer(blockMirrorsOfBlockedRegistries bool, registriesDir string,
+	sigstoreAttachmentsConfigMap string, registriesOutput string, bootstrapRegistriesConf string,
+	policyOmitAtomicTransport bool, resyncPeriods system_config.ResyncPeriods) *system_config.SystemConfigSyncer {
+	systemConfigSyncer, err := system_config.NewSystemConfigSyncer(system_config.SystemConfigSyncerOptions{
+		RegistriesDirPath:       registriesDir,
+		RegistriesOutput:        system_config.RegistriesOutputMode(registriesOutput),
+		BootstrapRegistriesConf: bootstrapRegistriesConf,
+		OmitAtomicTransport:     policyOmitAtomicTransport,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create the system config syncer")
+		os.Exit(1)
+	}
+	systemConfigSyncer.SetResyncPeriods(resyncPeriods)
+	systemConfigSyncer.SetBlockMirrorsOfBlockedRegistries(blockMirrorsOfBlockedRegistries)
+	if sigstoreAttachmentsConfigMap != "" {
+		namespace, name, ok := strings.Cut(sigstoreAttachmentsConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "--sigstore-attachments-configmap must be in the <namespace>/<name> format")
+			os.Exit(1)
+		}
+		systemConfigSyncer.SetSigstoreAttachmentsConfigMap(namespace, name)
+	}
+	return systemConfigSyncer
+}
+
+// runNodeSyncer runs the manager of the node syncer pods: it only starts the system config syncer, writing the files
+// to the host directories of the node, and reports the pod as ready once the files are in sync.
+func runNodeSyncer(restConfig *rest.Config, metricsAddr, probeAddr string, gracefulShutdownTimeout time.Duration,
+	systemConfigSyncer *system_config.SystemConfigSyncer) {
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		HealthProbeBindAddress:  probeAddr,
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+	if err := mgr.Add(systemConfigSyncer); err != nil {
+		setupLog.Error(err, "unable to add the system config syncer to the manager")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("system-config", systemConfigSyncer.ReadyzCheck); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting the node syncer")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running the node syncer")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,55 @@
+// Package bindaddress parses and validates the bind addresses of the listeners of the operator: the metrics, the health
+// probes and the webhook.
+//
+// A bind address is a host and a port, as accepted by net.Listen. The IPv6 hosts are enclosed in brackets, e.g.,
+// "[::1]:9443". An empty host, e.g., ":9443", or the "::" one binds to all the addresses of both the IPv4 and the
+// IPv6 families (dual-stack), while "0.0.0.0" only binds to the IPv4 ones.
+package bindaddress
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Disabled is the bind address disabling the listeners that can be disabled, e.g., the metrics one
+const Disabled = "0"
+
+// Parse returns the host and the port of the bind address of the listener configured by the flag. The errors
+// name the flag and explain how to fix its value. The port must be positive, as the Services of the operator target
+// fixed ports.
+func Parse(flag, address string) (host string, port int, err error) {
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return "", 0, fmt.Errorf("--%s=%s: the IPv6 hosts must be enclosed in brackets, e.g., [::1]:9443",
+				flag, address)
+		}
+		return "", 0, fmt.Errorf("--%s=%s: expected a host and a port, e.g., :9443, 0.0.0.0:9443 or [::]:9443: %w",
+			flag, address, err)
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		if errs := validation.IsDNS1123Subdomain(host); len(errs) > 0 {
+			return "", 0, fmt.Errorf("--%s=%s: the host is neither an IP address nor a valid host name: %s",
+				flag, address, strings.Join(errs, ", "))
+		}
+	}
+	port, err = strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("--%s=%s: the port must be a number between 1 and 65535", flag, address)
+	}
+	return host, port, nil
+}
+
+// Validate returns an error if the bind address of the listener configured by the flag is invalid. Disabled is
+// accepted if canDisable is true.
+func Validate(flag, address string, canDisable bool) error {
+	if canDisable && address == Disabled {
+		return nil
+	}
+	_, _, err := Parse(flag, address)
+	return err
+}
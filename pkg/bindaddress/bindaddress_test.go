@@ -0,0 +1,119 @@
+package bindaddress
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+var _ = Describe("The bind addresses", func() {
+	DescribeTable("should be parsed",
+		func(address, expectedHost string, expectedPort int) {
+			host, port, err := Parse("webhook-bind-address", address)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(host).To(Equal(expectedHost))
+			Expect(port).To(Equal(expectedPort))
+		},
+		Entry("all the addresses", ":9443", "", 9443),
+		Entry("all the IPv4 addresses", "0.0.0.0:9443", "0.0.0.0", 9443),
+		Entry("all the IPv6 addresses", "[::]:9443", "::", 9443),
+		Entry("IPv6 loopback", "[::1]:9443", "::1", 9443),
+		Entry("IPv4 address", "10.0.0.1:9443", "10.0.0.1", 9443),
+		Entry("host name", "localhost:9443", "localhost", 9443),
+	)
+
+	DescribeTable("should be rejected with the flag and the reason",
+		func(address, reason string) {
+			_, _, err := Parse("webhook-bind-address", address)
+			Expect(err).To(MatchError(And(
+				HavePrefix("--webhook-bind-address="+address+":"),
+				ContainSubstring(reason))))
+		},
+		Entry("IPv6 host without brackets", "::1:9443", "must be enclosed in brackets"),
+		Entry("missing port", "127.0.0.1", "expected a host and a port"),
+		Entry("invalid host", "my_host:9443", "neither an IP address nor a valid host name"),
+		Entry("named port", ":https", "between 1 and 65535"),
+		Entry("zero port", ":0", "between 1 and 65535"),
+		Entry("port out of range", ":65536", "between 1 and 65535"),
+	)
+
+	It("should only accept the disabled address for the listeners that can be disabled", func() {
+		Expect(Validate("metrics-bind-address", Disabled, true)).To(Succeed())
+		Expect(Validate("metrics-bind-address", Disabled, false)).To(MatchError(ContainSubstring(
+			"--metrics-bind-address=0")))
+		Expect(Validate("metrics-bind-address", "[::1]:8080", false)).To(Succeed())
+	})
+})
+
+var _ = Describe("The manager", func() {
+	// freePort returns a port available on the IPv6 loopback address
+	freePort := func() int {
+		l, err := net.Listen("tcp", "[::1]:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer l.Close()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+
+	It("should serve the metrics, the probes and the webhook on the IPv6 loopback address", func() {
+		webhookOptions := &envtest.WebhookInstallOptions{LocalServingHost: "::1"}
+		Expect(webhookOptions.PrepWithoutInstalling()).To(Succeed())
+		DeferCleanup(webhookOptions.Cleanup)
+		metricsAddr := fmt.Sprintf("[::1]:%d", freePort())
+		probeAddr := fmt.Sprintf("[::1]:%d", freePort())
+		webhookAddr := net.JoinHostPort(webhookOptions.LocalServingHost, fmt.Sprint(webhookOptions.LocalServingPort))
+		Expect(Validate("metrics-bind-address", metricsAddr, true)).To(Succeed())
+		Expect(Validate("health-probe-bind-address", probeAddr, true)).To(Succeed())
+		webhookHost, webhookPort, err := Parse("webhook-bind-address", webhookAddr)
+		Expect(err).NotTo(HaveOccurred())
+
+		// no API server is needed: the manager has no controller and discovers the APIs lazily
+		mgr, err := ctrl.NewManager(&rest.Config{Host: "https://[::1]:1"}, ctrl.Options{
+			MetricsBindAddress:     metricsAddr,
+			HealthProbeBindAddress: probeAddr,
+			Host:                   webhookHost,
+			Port:                   webhookPort,
+			CertDir:                webhookOptions.LocalServingCertDir,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mgr.AddHealthzCheck("healthz", healthz.Ping)).To(Succeed())
+		mgr.GetWebhookServer().Register("/ping", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("pong"))
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- mgr.Start(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done, 10*time.Second).Should(Receive(BeNil()))
+		})
+
+		httpClient := &http.Client{Timeout: time.Second, Transport: &http.Transport{
+			// the test only checks the listener, not the serving certificate
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		}}
+		get := func(url string) (int, error) {
+			response, err := httpClient.Get(url)
+			if err != nil {
+				return 0, err
+			}
+			defer response.Body.Close()
+			return response.StatusCode, nil
+		}
+		Eventually(get).WithArguments("http://" + probeAddr + "/healthz").Should(Equal(http.StatusOK))
+		Eventually(get).WithArguments("http://" + metricsAddr + "/metrics").Should(Equal(http.StatusOK))
+		Eventually(get).WithArguments("https://" + webhookAddr + "/ping").Should(Equal(http.StatusOK))
+	})
+})
@@ -0,0 +1,13 @@
+package bindaddress
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBindAddress(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bind Address Suite")
+}
@@ -0,0 +1,104 @@
+// Package decision defines the document recording the placement decision of a pod, the value of the decision
+// annotation the operator sets on the pods it places, e.g.:
+//
+//	multiarch.openshift.io/decision: '{"v":1,"architectures":["amd64","arm64"],
+//	  "images":[{"reference":"quay.io/org/app:v1","digest":"sha256:<hex>"}],"reason":"ArchitectureConstrained",
+//	  "generation":1,"timestamp":"2023-06-01T10:00:00Z"}'
+//
+// The document is versioned by its v field. The fields of a version are never removed nor given another meaning: new
+// optional fields can be added to it, so that the consumers ignore the fields they do not know. A change breaking the
+// consumers of a version increments it.
+package decision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Version is the version of the documents written by this release of the operator
+	Version = 1
+	// MaxSize is the maximum size of the documents, in bytes. The images are left out of the documents exceeding it,
+	// e.g., the ones of the pods with many containers, see Image.
+	MaxSize = 4096
+)
+
+// Decision is the placement decision of a pod
+type Decision struct {
+	// V is the version of the document, see Version
+	V int `json:"v"`
+	// Architectures are the architectures of the node affinity set for the pod, e.g., amd64 and arm64. They are empty
+	// when no node affinity was set, e.g., for the pods opted out of the placement.
+	Architectures []string `json:"architectures,omitempty"`
+	// SupportedArchitectures are the architectures supported by the images of the pod, before the capacity of the
+	// architectures refined them. They are only set when the placement considers the capacity.
+	SupportedArchitectures []string `json:"supportedArchitectures,omitempty"`
+	// CapacityExcludedArchitectures are the SupportedArchitectures excluded from the node affinity because they had not
+	// enough allocatable capacity
+	CapacityExcludedArchitectures []string `json:"capacityExcludedArchitectures,omitempty"`
+	// Images are the images inspected to place the pod, with their digest
+	Images []Image `json:"images,omitempty"`
+	// ImagesOmitted is true when the images were left out of the document to bound its size to MaxSize
+	ImagesOmitted bool `json:"imagesOmitted,omitempty"`
+	// ExcludedContainers are the names of the containers excluded from the placement by the placement settings
+	ExcludedContainers []string `json:"excludedContainers,omitempty"`
+	// Reason is the reason of the decision, one of the reasons of the reasons package, e.g., ArchitectureConstrained
+	Reason string `json:"reason"`
+	// MutatedBy is the version of the operator that made the decision, when recorded
+	MutatedBy string `json:"mutatedBy,omitempty"`
+	// Generation counts the decisions recorded on the pod: it follows the one of the document the pod already carries,
+	// if any, e.g., when the pod was created from the manifest of a placed pod
+	Generation int64 `json:"generation"`
+	// Timestamp is the time of the decision
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Image is an image inspected to place a pod
+type Image struct {
+	// Reference is the normalized reference of the image, e.g., docker.io/library/nginx:latest
+	Reference string `json:"reference"`
+	// Digest is the digest of the image inspected, e.g., sha256:<hex>
+	Digest string `json:"digest"`
+}
+
+// Format returns the document of the decision, of Version. The images are left out of the documents exceeding
+// MaxSize. An error is returned when the document exceeds it without them.
+func Format(d Decision) (string, error) {
+	d.V = Version
+	d.Timestamp = d.Timestamp.UTC().Truncate(time.Second)
+	value, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	if len(value) > MaxSize && len(d.Images) > 0 {
+		d.Images, d.ImagesOmitted = nil, true
+		if value, err = json.Marshal(d); err != nil {
+			return "", err
+		}
+	}
+	if len(value) > MaxSize {
+		return "", fmt.Errorf("the decision document is %d bytes long, more than %d", len(value), MaxSize)
+	}
+	return string(value), nil
+}
+
+// Parse parses the document of a decision. An error is returned for the documents of another version than Version,
+// and for the ones missing the required fields.
+func Parse(value string) (*Decision, error) {
+	d := &Decision{}
+	if err := json.Unmarshal([]byte(value), d); err != nil {
+		return nil, fmt.Errorf("invalid decision document: %w", err)
+	}
+	if d.V != Version {
+		return nil, fmt.Errorf("unsupported version %d of the decision document, expected %d", d.V, Version)
+	}
+	if d.Reason == "" {
+		return nil, errors.New("invalid decision document: the reason is missing")
+	}
+	if d.Timestamp.IsZero() {
+		return nil, errors.New("invalid decision document: the timestamp is missing")
+	}
+	return d, nil
+}
@@ -0,0 +1,101 @@
+package decision
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The decision document", func() {
+	timestamp := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	It("should keep the schema of the version 1", func() {
+		value, err := Format(Decision{
+			Architectures:                 []string{"amd64"},
+			SupportedArchitectures:        []string{"amd64", "arm64"},
+			CapacityExcludedArchitectures: []string{"arm64"},
+			Images:                        []Image{{Reference: "quay.io/org/app:v1", Digest: digest}},
+			ExcludedContainers:            []string{"istio-proxy"},
+			Reason:                        "ArchitectureConstrained",
+			MutatedBy:                     "v1.2.3",
+			Generation:                    2,
+			Timestamp:                     timestamp,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(MatchJSON(`{"v":1,"architectures":["amd64"],"supportedArchitectures":["amd64","arm64"],
+			"capacityExcludedArchitectures":["arm64"],"images":[{"reference":"quay.io/org/app:v1","digest":"` + digest +
+			`"}],"excludedContainers":["istio-proxy"],"reason":"ArchitectureConstrained","mutatedBy":"v1.2.3",
+			"generation":2,"timestamp":"2023-06-01T10:00:00Z"}`))
+	})
+
+	It("should only set the optional fields when known", func() {
+		value, err := Format(Decision{Reason: "OptedOut", Generation: 1, Timestamp: timestamp})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(`{"v":1,"reason":"OptedOut","generation":1,"timestamp":"2023-06-01T10:00:00Z"}`))
+	})
+
+	It("should be parsed as formatted", func() {
+		d := Decision{Architectures: []string{"amd64", "arm64"}, Images: []Image{{Reference: "quay.io/org/app:v1",
+			Digest: digest}}, Reason: "ArchitectureConstrained", Generation: 1,
+			Timestamp: timestamp.Add(500 * time.Millisecond).In(time.FixedZone("CEST", 2*60*60))}
+		value, err := Format(d)
+		Expect(err).NotTo(HaveOccurred())
+		parsed, err := Parse(value)
+		Expect(err).NotTo(HaveOccurred())
+		d.V, d.Timestamp = Version, timestamp
+		Expect(*parsed).To(Equal(d))
+	})
+
+	It("should ignore the fields added to the version", func() {
+		parsed, err := Parse(`{"v":1,"reason":"OptedOut","generation":1,"timestamp":"2023-06-01T10:00:00Z",
+			"added":{"field":true}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.Reason).To(Equal("OptedOut"))
+	})
+
+	DescribeTable("should reject the invalid documents", func(value, expected string) {
+		_, err := Parse(value)
+		Expect(err).To(MatchError(ContainSubstring(expected)))
+	},
+		Entry("not JSON", "ArchitectureConstrained", "invalid decision document"),
+		Entry("another version", `{"v":2,"reason":"OptedOut","timestamp":"2023-06-01T10:00:00Z"}`,
+			"unsupported version 2"),
+		Entry("no version", `{"reason":"OptedOut","timestamp":"2023-06-01T10:00:00Z"}`, "unsupported version 0"),
+		Entry("no reason", `{"v":1,"timestamp":"2023-06-01T10:00:00Z"}`, "the reason is missing"),
+		Entry("no timestamp", `{"v":1,"reason":"OptedOut"}`, "the timestamp is missing"),
+	)
+
+	It("should leave out the images of the documents exceeding the maximum size", func() {
+		d := Decision{Architectures: []string{"amd64", "arm64"}, Reason: "ArchitectureConstrained", Generation: 1,
+			Timestamp: timestamp}
+		for i := 0; i < 100; i++ {
+			d.Images = append(d.Images, Image{Reference: fmt.Sprintf("quay.io/org/app-%d:v1", i), Digest: digest})
+		}
+		value, err := Format(d)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(value)).To(BeNumerically("<=", MaxSize))
+		parsed, err := Parse(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.Images).To(BeEmpty())
+		Expect(parsed.ImagesOmitted).To(BeTrue())
+		Expect(parsed.Architectures).To(Equal(d.Architectures))
+
+		d.Images = d.Images[:10]
+		value, err = Format(d)
+		Expect(err).NotTo(HaveOccurred())
+		parsed, err = Parse(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.Images).To(HaveLen(10))
+		Expect(parsed.ImagesOmitted).To(BeFalse())
+	})
+
+	It("should fail when the document exceeds the maximum size without the images", func() {
+		_, err := Format(Decision{ExcludedContainers: []string{strings.Repeat("c", MaxSize)}, Reason: "OptedOut",
+			Generation: 1, Timestamp: timestamp})
+		Expect(err).To(MatchError(ContainSubstring("more than 4096")))
+	})
+})
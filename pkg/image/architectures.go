@@ -0,0 +1,47 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// architectureAliases maps the architecture names reported by uname and the distributions to the GOARCH names used
+// by the kubernetes.io/arch node label and the image platforms
+var architectureAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"armv8":   "arm64",
+	"ppc64el": "ppc64le",
+	"i386":    "386",
+	"i686":    "386",
+	"armhf":   "arm",
+	"armv7l":  "arm",
+}
+
+// knownArchitectures are the GOARCH names of the architectures Linux nodes can run on
+var knownArchitectures = sets.New[string]("386", "amd64", "arm", "arm64", "loong64", "mips64le", "ppc64le",
+	"riscv64", "s390x")
+
+// NormalizeArchitecture returns the GOARCH name of the architecture, if name is one of its common aliases, e.g.,
+// x86_64 for amd64 and aarch64 for arm64. Any other name is returned unchanged.
+func NormalizeArchitecture(name string) string {
+	if normalized, ok := architectureAliases[name]; ok {
+		return normalized
+	}
+	return name
+}
+
+// ParseArchitecture normalizes the architecture name, like NormalizeArchitecture, and returns an error listing the
+// accepted values if it is not a known architecture.
+func ParseArchitecture(name string) (string, error) {
+	normalized := NormalizeArchitecture(name)
+	if !knownArchitectures.Has(normalized) {
+		return "", fmt.Errorf("unknown architecture %q: the accepted values are %s and the aliases %s", name,
+			strings.Join(sets.List(knownArchitectures), ", "),
+			strings.Join(sets.List(sets.KeySet(architectureAliases)), ", "))
+	}
+	return normalized, nil
+}
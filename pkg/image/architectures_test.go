@@ -0,0 +1,42 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The architecture names", func() {
+	DescribeTable("should be normalized to the GOARCH names",
+		func(name, expected string) {
+			Expect(NormalizeArchitecture(name)).To(Equal(expected))
+			parsed, err := ParseArchitecture(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(expected))
+		},
+		Entry("amd64", "amd64", "amd64"),
+		Entry("x86_64", "x86_64", "amd64"),
+		Entry("arm64", "arm64", "arm64"),
+		Entry("aarch64", "aarch64", "arm64"),
+		Entry("ppc64le", "ppc64le", "ppc64le"),
+		Entry("ppc64el", "ppc64el", "ppc64le"),
+		Entry("s390x", "s390x", "s390x"),
+		Entry("i686", "i686", "386"),
+	)
+
+	It("should keep the unknown names in the image platforms", func() {
+		Expect(NormalizeArchitecture("unknown")).To(Equal("unknown"))
+	})
+
+	DescribeTable("should reject the unknown names listing the accepted values",
+		func(name string) {
+			_, err := ParseArchitecture(name)
+			Expect(err).To(MatchError(And(
+				ContainSubstring("unknown architecture %q", name),
+				ContainSubstring("amd64, arm"),
+				ContainSubstring("aarch64"))))
+		},
+		Entry("unknown architecture", "sparc"),
+		Entry("upper case name", "ARM64"),
+		Entry("empty name", ""),
+	)
+})
@@ -0,0 +1,105 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	"k8s.io/klog/v2"
+	"multiarch-operator/pkg/version"
+)
+
+const (
+	auditRequestPing     = "ping"
+	auditRequestToken    = "token"
+	auditRequestManifest = "manifest"
+	auditRequestConfig   = "config"
+)
+
+var (
+	userAgent = UserAgent(version.Version, "")
+	// userAgentMutex is used to protect userAgent from concurrent access
+	userAgentMutex sync.RWMutex
+
+	// auditLogger is the logger of the registry requests, nil when the audit is disabled
+	auditLogger *klog.Logger
+	// auditLoggerMutex is used to protect auditLogger from concurrent access
+	auditLoggerMutex sync.RWMutex
+)
+
+// UserAgent returns the User-Agent of the requests to the registries for the given operator version and cluster ID,
+// so that the registry operators can identify the traffic of the operator, e.g., to exempt it from their rate limits.
+// The cluster ID is omitted when empty.
+func UserAgent(operatorVersion, clusterID string) string {
+	if clusterID == "" {
+		return fmt.Sprintf("multiarch-operator/%s", operatorVersion)
+	}
+	return fmt.Sprintf("multiarch-operator/%s (cluster-id %s)", operatorVersion, clusterID)
+}
+
+// SetUserAgent sets the User-Agent of the requests to the registries. It defaults to the UserAgent of the version of
+// the operator, without the cluster ID.
+// It is expected to be called once, before the inspections start.
+func SetUserAgent(ua string) {
+	userAgentMutex.Lock()
+	defer userAgentMutex.Unlock()
+	userAgent = ua
+}
+
+func currentUserAgent() string {
+	userAgentMutex.RLock()
+	defer userAgentMutex.RUnlock()
+	return userAgent
+}
+
+// SetRegistryAuditLogger enables the audit of the requests to the registries: each request is logged through the
+// logger with its method, host, repository, status and latency. The requests sent by containers/image are audited per
+// step of the inspection, i.e., the manifest, including the ping of the registry and the tries of its mirrors, and the
+// config, with the status reported by its error, if any. The manifests requested with the tokens of the
+// tokenAuthenticator are audited per request, with the status of their response, see registrySource.
+// It is expected to be called once, before the inspections start.
+func SetRegistryAuditLogger(logger klog.Logger) {
+	auditLoggerMutex.Lock()
+	defer auditLoggerMutex.Unlock()
+	auditLogger = &logger
+}
+
+func currentAuditLogger() *klog.Logger {
+	auditLoggerMutex.RLock()
+	defer auditLoggerMutex.RUnlock()
+	return auditLogger
+}
+
+// auditRegistryRequest logs the request, if the audit is enabled. The status is zero when no response was received.
+func auditRegistryRequest(request, method, host, repository string, status int, latency time.Duration) {
+	logger := currentAuditLogger()
+	if logger == nil {
+		return
+	}
+	logger.Info("registry request", "request", request, "method", method, "host", host,
+		"repository", repository, "status", status, "latency", latency)
+}
+
+// statusFromError returns the HTTP status reported by the error of a request sent by containers/image: 200 without
+// error and zero when the error does not report any status, e.g., when the registry is not reachable.
+func statusFromError(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var unauthorizedErr docker.ErrUnauthorizedForCredentials
+	if errors.As(err, &unauthorizedErr) {
+		return http.StatusUnauthorized
+	}
+	if isNotFoundError(err) {
+		return http.StatusNotFound
+	}
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) {
+		return ec.ErrorCode().Descriptor().HTTPStatusCode
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+)
+
+var _ = Describe("The registry requests", func() {
+	var (
+		fts            *fakeTokenServer
+		imageReference string
+	)
+
+	BeforeEach(func() {
+		fts = newFakeTokenServer(true)
+		DeferCleanup(fts.Close)
+		imageReference = fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+	})
+
+	It("should identify the operator and the cluster through the User-Agent", func() {
+		SetUserAgent(UserAgent("v1.2.3", "a1b2c3"))
+		DeferCleanup(SetUserAgent, UserAgent("dev", ""))
+		_, err := fts.inspector().GetCompatibleArchitecturesSet(context.Background(), imageReference,
+			[][]byte{dockerConfigAuths(testUsername, testPassword, fts.registry())})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.tokenRequests.Load()).To(BeNumerically(">", 0))
+		Expect(fts.manifestRequests.Load()).To(BeNumerically(">", 0))
+		Expect(fts.seenUserAgents()).To(Equal([]string{"multiarch-operator/v1.2.3 (cluster-id a1b2c3)"}))
+	})
+
+	It("should audit the requests when the audit is enabled", func() {
+		var entries []map[string]interface{}
+		SetRegistryAuditLogger(funcr.NewJSON(func(obj string) {
+			entry := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(obj), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}, funcr.Options{}))
+		DeferCleanup(func() {
+			auditLoggerMutex.Lock()
+			defer auditLoggerMutex.Unlock()
+			auditLogger = nil
+		})
+
+		_, err := fts.inspector().GetCompatibleArchitecturesSet(context.Background(), imageReference,
+			[][]byte{dockerConfigAuths(testUsername, "wrong", fts.registry())})
+		Expect(err).To(HaveOccurred())
+		_, err = fts.inspector().GetCompatibleArchitecturesSet(context.Background(), imageReference,
+			[][]byte{dockerConfigAuths(testUsername, testPassword, fts.registry())})
+		Expect(err).NotTo(HaveOccurred())
+
+		auditEntry := func(request string, status int, repository string) types.GomegaMatcher {
+			return And(HaveKeyWithValue("request", request), HaveKeyWithValue("method", http.MethodGet),
+				HaveKeyWithValue("host", fts.registry()), HaveKeyWithValue("repository", repository),
+				HaveKeyWithValue("status", BeNumerically("==", status)))
+		}
+		Expect(entries).To(ContainElements(
+			auditEntry(auditRequestPing, http.StatusUnauthorized, ""),
+			auditEntry(auditRequestToken, http.StatusUnauthorized, testRepository),
+			auditEntry(auditRequestToken, http.StatusOK, testRepository),
+			auditEntry(auditRequestManifest, http.StatusOK, testRepository),
+		))
+		for _, entry := range entries {
+			Expect(entry).To(HaveKeyWithValue("msg", "registry request"))
+			Expect(entry).To(HaveKey("latency"))
+		}
+	})
+
+	It("should not audit the requests by default", func() {
+		Expect(currentAuditLogger()).To(BeNil())
+	})
+})
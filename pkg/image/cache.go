@@ -2,34 +2,413 @@ package image
 
 import (
 	"context"
-	"k8s.io/apimachinery/pkg/util/sets"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"golang.org/x/sync/singleflight"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+	"multiarch-operator/controllers/core"
+	"multiarch-operator/pkg/system_config"
+)
+
+const (
+	// DefaultNotFoundCacheTTL is the default time the inspections of the images missing from their registry are cached
+	DefaultNotFoundCacheTTL = 30 * time.Second
+	// DefaultUnauthorizedCacheTTL is the default time the inspections rejected by the registry for the credentials
+	// used are cached
+	DefaultUnauthorizedCacheTTL = 5 * time.Minute
+	// DefaultInspectionTimeout is the default time an inspection of an image, shared by the concurrent lookups of the
+	// image, can take
+	DefaultInspectionTimeout = 2 * time.Minute
+
+	failureNotFound     = "not_found"
+	failureUnauthorized = "unauthorized"
+	// failureTransient are the failures that are never cached, e.g., the network errors
+	failureTransient = "transient"
 )
 
+var (
+	// architecturesCacheTTL is the time the architectures of the inspected images are cached. Zero means forever.
+	architecturesCacheTTL time.Duration
+	// architecturesCacheTTLMutex is used to protect architecturesCacheTTL from concurrent access
+	architecturesCacheTTLMutex sync.RWMutex
+
+	failureCacheTTLs = map[string]time.Duration{
+		failureNotFound:     DefaultNotFoundCacheTTL,
+		failureUnauthorized: DefaultUnauthorizedCacheTTL,
+	}
+	// failureCacheTTLsMutex is used to protect failureCacheTTLs from concurrent access
+	failureCacheTTLsMutex sync.RWMutex
+
+	// inspectionTimeout is the time an inspection can take. Zero means no timeout.
+	inspectionTimeout = DefaultInspectionTimeout
+	// inspectionTimeoutMutex is used to protect inspectionTimeout from concurrent access
+	inspectionTimeoutMutex sync.RWMutex
+)
+
+// SetFailureCacheTTLs sets the time the failed inspections are cached: notFound for the images missing from their
+// registry and unauthorized for the ones the registry rejected the credentials of. A zero TTL disables the caching of
+// the corresponding failures. The other failures are never cached.
+// It can be called while the inspections run: the failures already cached keep their expiration.
+func SetFailureCacheTTLs(notFound, unauthorized time.Duration) {
+	failureCacheTTLsMutex.Lock()
+	defer failureCacheTTLsMutex.Unlock()
+	failureCacheTTLs = map[string]time.Duration{
+		failureNotFound:     notFound,
+		failureUnauthorized: unauthorized,
+	}
+}
+
+// SetArchitecturesCacheTTL sets the time the architectures of the inspected images are cached. The images are inspected
+// again once their entry expires, e.g., to notice the tags moved to images supporting other architectures. A zero TTL,
+// the default, caches the architectures forever.
+// It can be called while the inspections run: the architectures already cached keep their expiration.
+func SetArchitecturesCacheTTL(ttl time.Duration) {
+	architecturesCacheTTLMutex.Lock()
+	defer architecturesCacheTTLMutex.Unlock()
+	architecturesCacheTTL = ttl
+}
+
+// SetInspectionTimeout sets the time an inspection of an image can take. The inspections are shared by the concurrent
+// lookups of the same image and run detached from the cancellation of the lookups: the timeout bounds them instead.
+// Zero disables the timeout.
+// It can be called while the inspections run: the inspections already running keep their timeout.
+func SetInspectionTimeout(timeout time.Duration) {
+	inspectionTimeoutMutex.Lock()
+	defer inspectionTimeoutMutex.Unlock()
+	inspectionTimeout = timeout
+}
+
+func getInspectionTimeout() time.Duration {
+	inspectionTimeoutMutex.RLock()
+	defer inspectionTimeoutMutex.RUnlock()
+	return inspectionTimeout
+}
+
+func getArchitecturesCacheTTL() time.Duration {
+	architecturesCacheTTLMutex.RLock()
+	defer architecturesCacheTTLMutex.RUnlock()
+	return architecturesCacheTTL
+}
+
+func failureCacheTTL(kind string) time.Duration {
+	failureCacheTTLsMutex.RLock()
+	defer failureCacheTTLsMutex.RUnlock()
+	return failureCacheTTLs[kind]
+}
+
+// cachedFailure is a failed inspection, returned until its expiration time
+type cachedFailure struct {
+	err        error
+	kind       string
+	expiration time.Time
+}
+
+// cachedArchitectures are the inspection of an image and the architectures of its platforms, returned until their
+// expiration time, if any
+type cachedArchitectures struct {
+	inspection    Inspection
+	architectures sets.Set[string]
+	// expiration is zero when the architectures never expire
+	expiration time.Time
+}
+
+func (c cachedArchitectures) expired(now time.Time) bool {
+	return !c.expiration.IsZero() && !now.Before(c.expiration)
+}
+
 type cacheProxy struct {
 	registryInspector        iRegistryInspector
-	imageRefsArchitectureMap map[string]sets.Set[string]
-	mutex                    sync.Mutex
+	imageRefsArchitectureMap map[string]cachedArchitectures
+	// failures are the failed inspections by image reference and credentials, see failureKey
+	failures map[string]cachedFailure
+	mutex    sync.Mutex
+	// clock is used to measure the duration of the lookups exported by the inspectionDuration metric and to expire
+	// the failures
+	clock clock.PassiveClock
+	// pullSecretObserver is notified when the global pull secret changes, after the cached failures are dropped
+	pullSecretObserver PullSecretObserver
+	// inflight are the inspections in flight by failureKey, shared by the concurrent lookups of the same image with the
+	// same credentials
+	inflight singleflight.Group
 }
 
-func (c *cacheProxy) GetCompatibleArchitecturesSet(ctx context.Context, imageReference string, secrets [][]byte) (sets.Set[string], error) {
-	if c.imageRefsArchitectureMap[imageReference] != nil {
-		return c.imageRefsArchitectureMap[imageReference], nil
-	}
-	architectures, err := c.registryInspector.GetCompatibleArchitecturesSet(ctx, imageReference, secrets)
+func (c *cacheProxy) GetCompatibleArchitecturesSet(ctx context.Context, imageReference string, secrets [][]byte) (architectures sets.Set[string], err error) {
+	inspection, err := c.GetInspection(ctx, imageReference, secrets)
 	if err != nil {
 		return nil, err
 	}
+	return platformsArchitectures(inspection.Platforms), nil
+}
+
+func (c *cacheProxy) GetInspection(ctx context.Context, imageReference string, secrets [][]byte) (inspection Inspection, err error) {
+	// the equivalent references share their cache entries
+	if imageReference, err = NormalizeReference(imageReference); err != nil {
+		return Inspection{}, err
+	}
+	start := c.clock.Now()
+	key := failureKey(imageReference, secrets)
+	c.mutex.Lock()
+	cached, cacheHit := c.imageRefsArchitectureMap[imageReference]
+	cacheHit = cacheHit && !cached.expired(start)
+	failure, failureHit := c.failures[key]
+	failureHit = failureHit && start.Before(failure.expiration)
+	c.mutex.Unlock()
+	defer func() {
+		observeInspection(imageReference, cacheHit || failureHit, err, c.clock.Since(start))
+	}()
+	if cacheHit {
+		core.DebugLog(ctx, "Using the cached architectures %v of the image %s", sets.List(cached.architectures),
+			imageReference)
+		return cached.inspection, nil
+	}
+	if failureHit {
+		inspectionFailureCacheHits.WithLabelValues(failure.kind).Inc()
+		core.DebugLog(ctx, "Using the cached %s failure of the inspection of the image %s: %v", failure.kind,
+			imageReference, failure.err)
+		return Inspection{}, failure.err
+	}
+	return c.inspect(ctx, key, imageReference, secrets)
+}
+
+// inspect inspects the image on a cache miss. The concurrent lookups of the same image with the same credentials share
+// a single inspection, that runs on a context detached from the cancellation of the lookups and bounded by the
+// inspection timeout: a lookup whose context is cancelled returns its context error without failing the others. The
+// inspection waits for a slot of the parallel inspections within its timeout, see SetMaxParallelInspections.
+func (c *cacheProxy) inspect(ctx context.Context, key, imageReference string, secrets [][]byte) (Inspection, error) {
+	// leader is only set by the lookup running the inspection, before its result is sent
+	leader := false
+	result := c.inflight.DoChan(key, func() (interface{}, error) {
+		leader = true
+		var inspectionCtx context.Context = detachedContext{parent: ctx}
+		if timeout := getInspectionTimeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			inspectionCtx, cancel = context.WithTimeout(inspectionCtx, timeout)
+			defer cancel()
+		}
+		if err := inspections.acquire(inspectionCtx); err != nil {
+			return Inspection{}, err
+		}
+		inspection, err := c.registryInspector.GetInspection(inspectionCtx, imageReference, secrets)
+		inspections.release()
+		if err != nil {
+			recentInspectionErrors.add(InspectionErrorSample{
+				Time:  c.clock.Now(),
+				Image: imageReference,
+				Kind:  classifyInspectionError(err),
+				Error: err.Error(),
+			})
+			c.storeFailure(key, err)
+			return Inspection{}, err
+		}
+		c.storeArchitectures(imageReference, inspection)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.failures, key)
+		return inspection, nil
+	})
+	select {
+	case <-ctx.Done():
+		return Inspection{}, ctx.Err()
+	case r := <-result:
+		if !leader {
+			sharedInspections.Inc()
+			core.DebugLog(ctx, "Sharing the inspection of the image %s in flight", imageReference)
+		}
+		if r.Err != nil {
+			return Inspection{}, r.Err
+		}
+		return r.Val.(Inspection), nil
+	}
+}
+
+// detachedContext carries the values of its parent, e.g., its debug log, but neither its deadline nor its
+// cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
+// CachedCompatibleArchitecturesSet returns the cached architectures of the image reference, if they did not expire. It
+// never inspects the image.
+func (c *cacheProxy) CachedCompatibleArchitecturesSet(imageReference string) (sets.Set[string], bool) {
+	cached, ok := c.cached(imageReference)
+	return cached.architectures, ok
+}
+
+// CachedInspection returns the cached inspection of the image reference, if it did not expire. It never inspects the
+// image.
+func (c *cacheProxy) CachedInspection(imageReference string) (Inspection, bool) {
+	cached, ok := c.cached(imageReference)
+	return cached.inspection, ok
+}
+
+func (c *cacheProxy) cached(imageReference string) (cachedArchitectures, bool) {
+	imageReference, err := NormalizeReference(imageReference)
+	if err != nil {
+		return cachedArchitectures{}, false
+	}
+	now := c.clock.Now()
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.imageRefsArchitectureMap[imageReference] = architectures
-	return architectures, nil
+	cached, ok := c.imageRefsArchitectureMap[imageReference]
+	if !ok || cached.expired(now) {
+		return cachedArchitectures{}, false
+	}
+	return cached, true
 }
 
-func newCache() ICache {
-	return &cacheProxy{
-		imageRefsArchitectureMap: map[string]sets.Set[string]{},
+// storeArchitectures caches the inspection of the image for the architectures cache TTL and evicts the expired
+// entries
+func (c *cacheProxy) storeArchitectures(imageReference string, inspection Inspection) {
+	now := c.clock.Now()
+	cached := cachedArchitectures{inspection: inspection, architectures: platformsArchitectures(inspection.Platforms)}
+	if ttl := getArchitecturesCacheTTL(); ttl > 0 {
+		cached.expiration = now.Add(ttl)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, entry := range c.imageRefsArchitectureMap {
+		if entry.expired(now) {
+			delete(c.imageRefsArchitectureMap, k)
+		}
+	}
+	c.imageRefsArchitectureMap[imageReference] = cached
+}
+
+// storeFailure caches the failed inspection for the TTL of its kind, if any, and evicts the expired failures
+func (c *cacheProxy) storeFailure(key string, err error) {
+	kind := classifyInspectionError(err)
+	inspectionFailures.WithLabelValues(kind).Inc()
+	class := ClassifyError(err)
+	inspectionErrorClasses.WithLabelValues(string(class), strconv.FormatBool(class.Retryable())).Inc()
+	ttl := failureCacheTTL(kind)
+	now := c.clock.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, failure := range c.failures {
+		if !now.Before(failure.expiration) {
+			delete(c.failures, k)
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.failures[key] = cachedFailure{
+		err:        err,
+		kind:       kind,
+		expiration: now.Add(ttl),
+	}
+}
+
+// failureKey returns the key of the failures of the inspections of the image with the given pull secrets: the
+// registries can answer differently to different credentials, e.g., with a 404 for the private repositories the
+// credentials cannot read.
+func failureKey(imageReference string, secrets [][]byte) string {
+	hash := sha256.New()
+	for _, secret := range secrets {
+		hash.Write(secret)
+		// the separator keeps the identity of the different splits of the same bytes distinct
+		hash.Write([]byte{0})
+	}
+	return imageReference + "@" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// classifyInspectionError returns the kind of the failure of an inspection. The errors of the inspections with
+// different credentials are of a kind only if all of them are.
+func classifyInspectionError(err error) string {
+	var aggregate utilerrors.Aggregate
+	if errors.As(err, &aggregate) && len(aggregate.Errors()) > 0 {
+		kind := classifyInspectionError(aggregate.Errors()[0])
+		for _, err := range aggregate.Errors()[1:] {
+			if classifyInspectionError(err) != kind {
+				return failureTransient
+			}
+		}
+		return kind
+	}
+	switch {
+	case isNotFoundError(err):
+		return failureNotFound
+	case isUnauthorizedError(err) || isInsufficientScopeError(err) || errors.Is(err, errInsufficientScope):
+		return failureUnauthorized
+	default:
+		return failureTransient
+	}
+}
+
+// IsNotFound returns true if the inspection failed because the image, or all its pull sources, do not exist in the
+// registry
+func IsNotFound(err error) bool {
+	return err != nil && classifyInspectionError(err) == failureNotFound
+}
+
+// IsUnauthorized returns true if the inspection failed because the registry rejected all the credentials used
+func IsUnauthorized(err error) bool {
+	return err != nil && classifyInspectionError(err) == failureUnauthorized
+}
+
+// isNotFoundError returns true if err reports the manifest or the repository of the image does not exist, as the
+// isManifestUnknownError function of containers/image does. registry.redhat.io reports the missing manifests with the
+// UNKNOWN code and the Not Found message.
+func isNotFoundError(err error) bool {
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) && (ec.ErrorCode() == v2.ErrorCodeManifestUnknown || ec.ErrorCode() == v2.ErrorCodeNameUnknown) {
+		return true
+	}
+	var e errcode.Error
+	return errors.As(err, &e) && e.ErrorCode() == errcode.ErrorCodeUnknown && e.Message == "Not Found"
+}
+
+// OnPullSecretChange drops the cached failures, as the failureKey does not include the global pull secret: the images
+// the registries rejected the previous credentials for are inspected again with the new ones.
+func (c *cacheProxy) OnPullSecretChange() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures = map[string]cachedFailure{}
+	if c.pullSecretObserver != nil {
+		c.pullSecretObserver.OnPullSecretChange()
+	}
+}
+
+func (c *cacheProxy) setPullSecretObserver(observer PullSecretObserver) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pullSecretObserver = observer
+}
+
+func newCache() iInspectionCache {
+	c := &cacheProxy{
+		imageRefsArchitectureMap: map[string]cachedArchitectures{},
+		failures:                 map[string]cachedFailure{},
 		registryInspector:        newRegistryInspector(),
+		clock:                    clock.RealClock{},
+	}
+	c.registryInspector.setPullSecretObserver(c)
+	return c
+}
+
+// NewInspectionCache returns a cache of the inspections of the images that reads the registries configuration from the
+// config and the credentials used by all the inspections from the keychain, if not nil. Unlike the FacadeSingleton,
+// it does not watch the global pull secret of the cluster, so that it can be used outside of it.
+func NewInspectionCache(config system_config.IConfigReader, keychain Keychain) IPlatformsCache {
+	return &cacheProxy{
+		imageRefsArchitectureMap: map[string]cachedArchitectures{},
+		failures:                 map[string]cachedFailure{},
+		registryInspector:        newStandaloneRegistryInspector(config, keychain),
+		clock:                    clock.RealClock{},
 	}
 }
 
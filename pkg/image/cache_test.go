@@ -0,0 +1,358 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func counterValue(counter *prometheus.CounterVec, kind string) float64 {
+	metric := &dto.Metric{}
+	Expect(counter.WithLabelValues(kind).(prometheus.Metric).Write(metric)).To(Succeed())
+	return metric.GetCounter().GetValue()
+}
+
+type countingPullSecretObserver struct {
+	changes int
+}
+
+func (o *countingPullSecretObserver) OnPullSecretChange() {
+	o.changes++
+}
+
+// blockingInspector is an iRegistryInspector counting the inspections and blocking them until released or until their
+// context is done
+type blockingInspector struct {
+	inspections atomic.Int32
+	release     chan struct{}
+	// cancelled are the inspections that returned because their context was done
+	cancelled atomic.Int32
+}
+
+func (i *blockingInspector) GetInspection(ctx context.Context, _ string, _ [][]byte) (Inspection, error) {
+	i.inspections.Add(1)
+	select {
+	case <-i.release:
+		return Inspection{Platforms: []Platform{{OS: "linux", Architecture: "arm64"}}}, nil
+	case <-ctx.Done():
+		i.cancelled.Add(1)
+		return Inspection{}, ctx.Err()
+	}
+}
+
+func (i *blockingInspector) storeGlobalPullSecret([]byte) {}
+
+func (i *blockingInspector) setPullSecretObserver(PullSecretObserver) {}
+
+var _ = Describe("The inspection cache", func() {
+	var (
+		ctx            context.Context
+		fts            *fakeTokenServer
+		fakeClock      *clocktesting.FakeClock
+		cache          *cacheProxy
+		imageReference string
+		validSecrets   [][]byte
+		invalidSecrets [][]byte
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fts = newFakeTokenServer(true)
+		DeferCleanup(fts.Close)
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		cache = &cacheProxy{
+			imageRefsArchitectureMap: map[string]cachedArchitectures{},
+			failures:                 map[string]cachedFailure{},
+			registryInspector:        fts.inspector(),
+			clock:                    fakeClock,
+		}
+		imageReference = fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+		validSecrets = [][]byte{dockerConfigAuths(testUsername, testPassword, fts.registry())}
+		invalidSecrets = [][]byte{dockerConfigAuths(testUsername, "wrong", fts.registry())}
+		SetFailureCacheTTLs(30*time.Second, 5*time.Minute)
+		DeferCleanup(SetFailureCacheTTLs, DefaultNotFoundCacheTTL, DefaultUnauthorizedCacheTTL)
+		inspectionFailures.Reset()
+		inspectionFailureCacheHits.Reset()
+		recentInspectionErrors = &inspectionErrorSamples{}
+	})
+
+	It("should cache the missing manifests for the not found TTL", func() {
+		fts.manifestUnknown.Store(true)
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+			Expect(err).To(HaveOccurred())
+			Expect(classifyInspectionError(err)).To(Equal(failureNotFound))
+		}
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(1))
+		Expect(counterValue(inspectionFailures, failureNotFound)).To(BeNumerically("==", 1))
+		Expect(counterValue(inspectionFailureCacheHits, failureNotFound)).To(BeNumerically("==", 1))
+		// the failures answered from the cache are not sampled
+		Expect(RecentInspectionErrors()).To(ConsistOf(And(
+			HaveField("Image", imageReference), HaveField("Kind", failureNotFound))))
+
+		// the missing tag is pushed
+		fts.manifestUnknown.Store(false)
+		fakeClock.Step(30 * time.Second)
+		architectures, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(2))
+		Expect(cache.failures).To(BeEmpty())
+	})
+
+	It("should cache the rejected credentials per pull secrets for the unauthorized TTL", func() {
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, invalidSecrets)
+			Expect(err).To(HaveOccurred())
+			Expect(classifyInspectionError(err)).To(Equal(failureUnauthorized))
+		}
+		Expect(counterValue(inspectionFailures, failureUnauthorized)).To(BeNumerically("==", 1))
+		Expect(counterValue(inspectionFailureCacheHits, failureUnauthorized)).To(BeNumerically("==", 1))
+
+		// other credentials are not affected by the cached failure
+		rejected := fts.tokenRequests.Load()
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.tokenRequests.Load()).To(BeNumerically(">", rejected))
+		// the architectures are cached for any credentials
+		delete(cache.imageRefsArchitectureMap, imageReference)
+
+		fakeClock.Step(5*time.Minute - time.Second)
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, "//"+fts.registry()+"/"+testRepository+":other",
+			invalidSecrets)
+		Expect(err).To(HaveOccurred())
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, invalidSecrets)
+		Expect(err).To(HaveOccurred())
+		Expect(counterValue(inspectionFailureCacheHits, failureUnauthorized)).To(BeNumerically("==", 2))
+		fakeClock.Step(time.Second)
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, invalidSecrets)
+		Expect(err).To(HaveOccurred())
+		Expect(counterValue(inspectionFailures, failureUnauthorized)).To(BeNumerically("==", 3))
+	})
+
+	It("should drop the cached failures when the global pull secret changes", func() {
+		observer := &countingPullSecretObserver{}
+		cache.setPullSecretObserver(observer)
+		inspector := cache.registryInspector.(*registryInspector)
+		inspector.setPullSecretObserver(cache)
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, nil)
+		Expect(classifyInspectionError(err)).To(Equal(failureUnauthorized))
+		Expect(cache.failures).To(HaveLen(1))
+
+		// the admin fixes the global pull secret
+		inspector.storeGlobalPullSecret(validSecrets[0])
+		Expect(observer.changes).To(Equal(1))
+		Expect(cache.failures).To(BeEmpty())
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the resyncs of the watcher store the same pull secret again
+		inspector.storeGlobalPullSecret(validSecrets[0])
+		Expect(observer.changes).To(Equal(1))
+	})
+
+	It("should not cache the transient failures", func() {
+		fts.Close()
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+			Expect(err).To(HaveOccurred())
+		}
+		Expect(counterValue(inspectionFailures, failureTransient)).To(BeNumerically("==", 2))
+		Expect(counterValue(inspectionFailureCacheHits, failureTransient)).To(BeZero())
+		Expect(cache.failures).To(BeEmpty())
+	})
+
+	It("should not cache the failures with a zero TTL", func() {
+		SetFailureCacheTTLs(0, 5*time.Minute)
+		fts.manifestUnknown.Store(true)
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+			Expect(err).To(HaveOccurred())
+		}
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(2))
+		Expect(cache.failures).To(BeEmpty())
+	})
+
+	It("should evict the expired failures", func() {
+		fts.manifestUnknown.Store(true)
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).To(HaveOccurred())
+		fakeClock.Step(time.Minute)
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, invalidSecrets)
+		Expect(err).To(HaveOccurred())
+		Expect(cache.failures).To(HaveLen(1))
+		Expect(cache.failures).To(HaveKey(failureKey(imageReference, invalidSecrets)))
+	})
+
+	It("should cache the architectures forever by default", func() {
+		_, ok := cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeFalse())
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		fakeClock.Step(24 * time.Hour)
+		architectures, ok := cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeTrue())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should share the entries of the equivalent references", func() {
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, " docker://"+imageReference[2:]+"\n", validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		architectures, ok := cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeTrue())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, "//"+fts.registry()+"/"+testRepository, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(1))
+		Expect(cache.imageRefsArchitectureMap).To(HaveLen(1))
+	})
+
+	It("should not inspect the images of the other transports", func() {
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, "oci:/var/lib/layouts/app", validSecrets)
+		var transportErr *UnsupportedTransportError
+		Expect(errors.As(err, &transportErr)).To(BeTrue())
+		Expect(transportErr.Transport).To(Equal("oci"))
+		Expect(fts.manifestRequests.Load()).To(BeZero())
+	})
+
+	It("should inspect the images again once their architectures expire", func() {
+		SetArchitecturesCacheTTL(time.Minute)
+		DeferCleanup(SetArchitecturesCacheTTL, time.Duration(0))
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		_, ok := cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeTrue())
+
+		fakeClock.Step(time.Minute)
+		// the expired architectures are not returned without the inspection
+		_, ok = cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeFalse())
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.manifestRequests.Load()).To(BeEquivalentTo(2))
+		_, ok = cache.CachedCompatibleArchitecturesSet(imageReference)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should evict the expired architectures", func() {
+		SetArchitecturesCacheTTL(time.Minute)
+		DeferCleanup(SetArchitecturesCacheTTL, time.Duration(0))
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		fakeClock.Step(time.Minute)
+		other := "//" + fts.registry() + "/" + testRepository + ":other"
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, other, validSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.imageRefsArchitectureMap).To(HaveLen(1))
+		Expect(cache.imageRefsArchitectureMap).To(HaveKey(other))
+	})
+})
+
+var _ = Describe("The inspections in flight", func() {
+	const (
+		imageReference = "//quay.io/org/app:v1"
+		callers        = 50
+	)
+	var (
+		inspector *blockingInspector
+		cache     *cacheProxy
+	)
+
+	BeforeEach(func() {
+		inspector = &blockingInspector{release: make(chan struct{})}
+		cache = &cacheProxy{
+			imageRefsArchitectureMap: map[string]cachedArchitectures{},
+			failures:                 map[string]cachedFailure{},
+			registryInspector:        inspector,
+			clock:                    clocktesting.NewFakeClock(time.Now()),
+		}
+	})
+
+	It("should be shared by the concurrent lookups of the same image", func() {
+		var wg sync.WaitGroup
+		errs := make(chan error, callers)
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				architectures, err := cache.GetCompatibleArchitecturesSet(context.Background(), imageReference, nil)
+				if err == nil && !architectures.Equal(sets.New("arm64")) {
+					err = fmt.Errorf("unexpected architectures %v", sets.List(architectures))
+				}
+				errs <- err
+			}()
+		}
+		Eventually(inspector.inspections.Load).Should(BeEquivalentTo(1))
+		close(inspector.release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(inspector.inspections.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should not be shared by the lookups with different credentials", func() {
+		done := make(chan struct{})
+		for _, secret := range []string{"a", "b"} {
+			secrets := [][]byte{[]byte(secret)}
+			go func() {
+				defer GinkgoRecover()
+				_, _ = cache.GetInspection(context.Background(), imageReference, secrets)
+				done <- struct{}{}
+			}()
+		}
+		Eventually(inspector.inspections.Load).Should(BeEquivalentTo(2))
+		close(inspector.release)
+		Eventually(done).Should(Receive())
+		Eventually(done).Should(Receive())
+	})
+
+	It("should outlive the cancellation of the lookup that started it", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error, 1)
+		go func() {
+			_, err := cache.GetInspection(ctx, imageReference, nil)
+			first <- err
+		}()
+		Eventually(inspector.inspections.Load).Should(BeEquivalentTo(1))
+		second := make(chan error, 1)
+		go func() {
+			_, err := cache.GetInspection(context.Background(), imageReference, nil)
+			second <- err
+		}()
+
+		cancel()
+		Eventually(first).Should(Receive(MatchError(context.Canceled)))
+		Consistently(second, 100*time.Millisecond).ShouldNot(Receive())
+		close(inspector.release)
+		Eventually(second).Should(Receive(BeNil()))
+		Expect(inspector.inspections.Load()).To(BeEquivalentTo(1))
+		Expect(inspector.cancelled.Load()).To(BeZero())
+		_, ok := cache.CachedInspection(imageReference)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should be bounded by the inspection timeout", func() {
+		SetInspectionTimeout(50 * time.Millisecond)
+		DeferCleanup(SetInspectionTimeout, DefaultInspectionTimeout)
+		_, err := cache.GetInspection(context.Background(), imageReference, nil)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(inspector.cancelled.Load()).To(BeEquivalentTo(1))
+		Expect(cache.failures).To(BeEmpty())
+	})
+})
@@ -0,0 +1,64 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"multiarch-operator/controllers/core"
+)
+
+var _ = Describe("The inspection traces", func() {
+	var (
+		fts   *fakeTokenServer
+		lines []string
+		ctx   context.Context
+	)
+
+	BeforeEach(func() {
+		fts = newFakeTokenServer(true)
+		DeferCleanup(fts.Close)
+		lines = nil
+		ctx = core.NewDebugLogging(100, 100).WithLogger(context.Background(), funcr.New(func(_, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{}))
+	})
+
+	It("should trace the manifests and the credentials tried without their secrets", func() {
+		SetKubeletCompatibleCredentials(true)
+		DeferCleanup(SetKubeletCompatibleCredentials, false)
+		secrets := [][]byte{
+			dockerConfigAuths(testUsername, "s3cr3t", fts.registry()+"/"+testRepository),
+			dockerConfigAuths(testUsername, testPassword, fts.registry()),
+		}
+		imageReference := fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+		architectures, err := fts.inspector().GetCompatibleArchitecturesSet(ctx, imageReference, secrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(architectures.UnsortedList()).To(ConsistOf("amd64", "arm64"))
+
+		Expect(lines).To(ContainElement(ContainSubstring("has no mirrors configured")))
+		Expect(lines).To(ContainElement(ContainSubstring(
+			`Trying the credentials 1 of 2 for the image %s: the user \"user\" from the pull secrets of the pod`,
+			imageReference)))
+		Expect(lines).To(ContainElement(ContainSubstring("with the credentials 1 failed")))
+		Expect(lines).To(ContainElement(ContainSubstring(fmt.Sprintf(
+			"has the manifests: sha256:%s (linux/amd64), sha256:%s (linux/arm64)",
+			strings.Repeat("a", 64), strings.Repeat("b", 64)))))
+		for _, line := range lines {
+			Expect(line).NotTo(ContainSubstring("s3cr3t"))
+			Expect(line).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString([]byte(testUsername + ":" + testPassword))))
+		}
+	})
+
+	It("should not trace the inspections of the other contexts", func() {
+		imageReference := fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+		_, err := fts.inspector().GetCompatibleArchitecturesSet(context.Background(), imageReference,
+			[][]byte{dockerConfigAuths(testUsername, testPassword, fts.registry())})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lines).To(BeEmpty())
+	})
+})
@@ -0,0 +1,167 @@
+package image
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ErrorClass is the class of the failure of an inspection. The inspections try each pull source of an image once: the
+// class tells the callers whether retrying the inspection later can succeed, so that they own the backoff.
+type ErrorClass string
+
+const (
+	// ErrorClassDNS is the class of the failures to resolve the host of the registry
+	ErrorClassDNS ErrorClass = "dns"
+	// ErrorClassConnectionRefused is the class of the failures to connect to the registry
+	ErrorClassConnectionRefused ErrorClass = "connection_refused"
+	// ErrorClassUnauthorized is the class of the 401 answers: the registry rejected the credentials
+	ErrorClassUnauthorized ErrorClass = "unauthorized"
+	// ErrorClassForbidden is the class of the 403 answers: the credentials do not grant the pull of the repository
+	ErrorClassForbidden ErrorClass = "forbidden"
+	// ErrorClassNotFound is the class of the 404 answers: the image is missing from the registry
+	ErrorClassNotFound ErrorClass = "not_found"
+	// ErrorClassTooManyRequests is the class of the 429 answers: the registry rate limits the requests
+	ErrorClassTooManyRequests ErrorClass = "too_many_requests"
+	// ErrorClassServerError is the class of the 5xx answers
+	ErrorClassServerError ErrorClass = "server_error"
+	// ErrorClassTLS is the class of the failures of the TLS handshake with the registry, e.g., an unknown certificate
+	// authority, and of the registries not complying with the TLS policy of the cluster
+	ErrorClassTLS ErrorClass = "tls"
+	// ErrorClassTokenRejected is the class of the failures with a token the registry rejected after rejecting the
+	// cached one: the token is dropped, and the next inspection requests a fresh one
+	ErrorClassTokenRejected ErrorClass = "token_rejected"
+	// ErrorClassInvalidReference is the class of the image references that cannot be parsed or are not in a registry
+	ErrorClassInvalidReference ErrorClass = "invalid_reference"
+	// ErrorClassManifestTooLarge is the class of the manifests larger than the limit of the inspections
+	ErrorClassManifestTooLarge ErrorClass = "manifest_too_large"
+	// ErrorClassOther is the class of all the other failures, e.g., the connections reset by the registry
+	ErrorClassOther ErrorClass = "other"
+)
+
+// retryableErrorClasses are the classes of the failures that retrying the inspection later can fix without any change
+// of the image, of the credentials or of the configuration of the cluster
+var retryableErrorClasses = map[ErrorClass]bool{
+	ErrorClassDNS:               true,
+	ErrorClassConnectionRefused: true,
+	ErrorClassTooManyRequests:   true,
+	ErrorClassServerError:       true,
+	ErrorClassTokenRejected:     true,
+	ErrorClassOther:             true,
+}
+
+// statusCodeRegexp matches the HTTP status codes containers/image reports without a typed error, e.g., for the pings
+// answered with 503 or the manifests answered with a 502 and an HTML body
+var statusCodeRegexp = regexp.MustCompile(`(?:StatusCode: |invalid status code from registry )([0-9]{3})`)
+
+// Retryable returns true if retrying the inspection later can succeed
+func (c ErrorClass) Retryable() bool {
+	return retryableErrorClasses[c]
+}
+
+// failsAllCredentials returns true if the failure is not specific to the credentials of the inspection, so that trying
+// the image with other credentials only adds load on the registry
+func (c ErrorClass) failsAllCredentials() bool {
+	switch c {
+	case ErrorClassDNS, ErrorClassConnectionRefused, ErrorClassTooManyRequests, ErrorClassServerError, ErrorClassTLS:
+		return true
+	}
+	return false
+}
+
+// ClassifyError returns the class of the err of an inspection. The errors of the inspections with different
+// credentials are retryable if one of them is. It returns an empty class for a nil err.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	var aggregate utilerrors.Aggregate
+	if errors.As(err, &aggregate) && len(aggregate.Errors()) > 0 {
+		class := ClassifyError(aggregate.Errors()[0])
+		for _, err := range aggregate.Errors()[1:] {
+			if other := ClassifyError(err); !class.Retryable() && other.Retryable() {
+				class = other
+			}
+		}
+		return class
+	}
+	var (
+		invalidErr   *InvalidReferenceError
+		transportErr *UnsupportedTransportError
+		tlsPolicyErr *TLSPolicyError
+		tooLargeErr  *ManifestTooLargeError
+		dnsErr       *net.DNSError
+	)
+	switch {
+	case errors.As(err, &invalidErr), errors.As(err, &transportErr):
+		return ErrorClassInvalidReference
+	case errors.As(err, &tooLargeErr):
+		return ErrorClassManifestTooLarge
+	case errors.Is(err, errTokenRejected):
+		return ErrorClassTokenRejected
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused
+	case errors.As(err, &tlsPolicyErr), isTLSError(err):
+		return ErrorClassTLS
+	}
+	switch statusCode := httpStatusCode(err); {
+	case statusCode == http.StatusUnauthorized:
+		return ErrorClassUnauthorized
+	case statusCode == http.StatusForbidden:
+		return ErrorClassForbidden
+	case statusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassTooManyRequests
+	case statusCode >= http.StatusInternalServerError:
+		return ErrorClassServerError
+	}
+	return ErrorClassOther
+}
+
+// isTLSError returns true if err reports a failed verification of the certificate of the registry or a registry not
+// talking TLS
+func isTLSError(err error) bool {
+	var (
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+	)
+	return errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		errors.As(err, &recordErr)
+}
+
+// httpStatusCode returns the HTTP status code of the answer of the registry err reports, or zero if it reports none
+func httpStatusCode(err error) int {
+	switch {
+	case errors.Is(err, docker.ErrTooManyRequests):
+		return http.StatusTooManyRequests
+	case isUnauthorizedError(err):
+		return http.StatusUnauthorized
+	case isInsufficientScopeError(err) || errors.Is(err, errInsufficientScope):
+		return http.StatusForbidden
+	case isNotFoundError(err):
+		return http.StatusNotFound
+	}
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) && ec.ErrorCode().Descriptor().HTTPStatusCode != 0 {
+		return ec.ErrorCode().Descriptor().HTTPStatusCode
+	}
+	if match := statusCodeRegexp.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ := strconv.Atoi(match[1])
+		return statusCode
+	}
+	return 0
+}
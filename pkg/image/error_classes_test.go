@@ -0,0 +1,87 @@
+package image
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// dialError returns the error of a request to the registry failing to dial it with err
+func dialError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://registry.example.com/v2/", Err: &net.OpError{Op: "dial", Net: "tcp",
+		Err: err}}
+}
+
+var _ = Describe("The classification of the inspection errors", func() {
+	DescribeTable("should classify the errors and tell whether they are retryable",
+		func(err error, class ErrorClass, retryable bool) {
+			Expect(ClassifyError(err)).To(Equal(class))
+			Expect(ClassifyError(err).Retryable()).To(Equal(retryable))
+		},
+		Entry("DNS failure", dialError(&net.DNSError{Err: "no such host", Name: "registry.example.com",
+			IsNotFound: true}), ErrorClassDNS, true),
+		Entry("connection refused", dialError(os.NewSyscallError("connect", syscall.ECONNREFUSED)),
+			ErrorClassConnectionRefused, true),
+		Entry("401 for the credentials", docker.ErrUnauthorizedForCredentials{Err: errors.New("invalid password")},
+			ErrorClassUnauthorized, false),
+		Entry("401 of the registry API", errcode.ErrorCodeUnauthorized.WithMessage("authentication required"),
+			ErrorClassUnauthorized, false),
+		Entry("403", fmt.Errorf("reading manifest latest: %w",
+			errcode.ErrorCodeDenied.WithMessage("requested access to the resource is denied")), ErrorClassForbidden,
+			false),
+		Entry("403 of an insufficient token scope", fmt.Errorf("%w: quay.io/org/app", errInsufficientScope),
+			ErrorClassForbidden, false),
+		Entry("404", fmt.Errorf("reading manifest latest: %w", v2.ErrorCodeManifestUnknown.WithMessage("unknown")),
+			ErrorClassNotFound, false),
+		Entry("404 of registry.redhat.io", errcode.ErrorCodeUnknown.WithMessage("Not Found"), ErrorClassNotFound,
+			false),
+		Entry("429 of the ping", fmt.Errorf("pinging container registry quay.io: %w", docker.ErrTooManyRequests),
+			ErrorClassTooManyRequests, true),
+		Entry("429 of the registry API", errcode.ErrorCodeTooManyRequests.WithMessage("slow down"),
+			ErrorClassTooManyRequests, true),
+		Entry("503 of the ping", errors.New("pinging container registry quay.io: invalid status code from "+
+			"registry 503 (Service Unavailable)"), ErrorClassServerError, true),
+		Entry("502 of the manifest", errors.New("reading manifest latest in quay.io/org/app: StatusCode: 502, "+
+			"<html>Bad Gateway</html>"), ErrorClassServerError, true),
+		Entry("503 of the registry API", errcode.ErrorCodeUnavailable.WithMessage("unavailable"),
+			ErrorClassServerError, true),
+		Entry("unknown certificate authority", dialError(x509.UnknownAuthorityError{}), ErrorClassTLS, false),
+		Entry("manifest too large", &ManifestTooLargeError{Max: DefaultMaxManifestSize}, ErrorClassManifestTooLarge,
+			false),
+		Entry("manifest list with too many manifests", &ManifestTooLargeError{MaxEntries: DefaultMaxManifestPlatforms},
+			ErrorClassManifestTooLarge, false),
+		Entry("certificate for another host", x509.HostnameError{Certificate: &x509.Certificate{},
+			Host: "registry.example.com"}, ErrorClassTLS, false),
+		Entry("TLS policy", &TLSPolicyError{Registry: "quay.io", Policy: "minimum version TLS 1.3",
+			Err: errors.New("TLS 1.2")}, ErrorClassTLS, false),
+		Entry("rejected token", fmt.Errorf("%w: quay.io/org/app: %v", errTokenRejected,
+			errcode.ErrorCodeUnauthorized.WithMessage("invalid token")), ErrorClassTokenRejected, true),
+		Entry("invalid reference", &InvalidReferenceError{Reference: "nginx:", Err: errors.New("invalid")},
+			ErrorClassInvalidReference, false),
+		Entry("connection reset", errors.New("read: connection reset by peer"), ErrorClassOther, true),
+		Entry("credentials failing differently", utilerrors.NewAggregate([]error{
+			docker.ErrUnauthorizedForCredentials{Err: errors.New("invalid password")},
+			errcode.ErrorCodeUnavailable.WithMessage("unavailable"),
+		}), ErrorClassServerError, true),
+		Entry("credentials all rejected", utilerrors.NewAggregate([]error{
+			docker.ErrUnauthorizedForCredentials{Err: errors.New("invalid password")},
+			errcode.ErrorCodeDenied.WithMessage("denied"),
+		}), ErrorClassUnauthorized, false),
+	)
+
+	It("should not classify the nil errors", func() {
+		Expect(ClassifyError(nil)).To(BeEmpty())
+	})
+})
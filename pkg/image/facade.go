@@ -12,14 +12,34 @@ var (
 	once sync.Once
 )
 
+// iInspectionCache is the cache of the inspections of the Facade
+type iInspectionCache interface {
+	ICache
+	ICachedArchitectures
+	IPlatformsCache
+	setPullSecretObserver(observer PullSecretObserver)
+}
+
 type Facade struct {
-	inspectionCache ICache
+	inspectionCache iInspectionCache
 }
 
 func (i *Facade) GetCompatibleArchitecturesSet(ctx context.Context, imageReference string, secrets [][]byte) (architectures sets.Set[string], err error) {
 	return i.inspectionCache.GetCompatibleArchitecturesSet(ctx, imageReference, secrets)
 }
 
+func (i *Facade) CachedCompatibleArchitecturesSet(imageReference string) (architectures sets.Set[string], ok bool) {
+	return i.inspectionCache.CachedCompatibleArchitecturesSet(imageReference)
+}
+
+func (i *Facade) GetInspection(ctx context.Context, imageReference string, secrets [][]byte) (Inspection, error) {
+	return i.inspectionCache.GetInspection(ctx, imageReference, secrets)
+}
+
+func (i *Facade) CachedInspection(imageReference string) (inspection Inspection, ok bool) {
+	return i.inspectionCache.CachedInspection(imageReference)
+}
+
 func newImageFacade() ICache {
 	return &Facade{
 		inspectionCache: newCache(),
@@ -32,3 +52,15 @@ func FacadeSingleton() ICache {
 	})
 	return singletonImageFacade
 }
+
+// PlatformsCacheSingleton returns the view of the cache of the FacadeSingleton reporting the platforms and the digests
+// of the images
+func PlatformsCacheSingleton() IPlatformsCache {
+	return FacadeSingleton().(*Facade)
+}
+
+// SetPullSecretObserver registers the observer notified every time the global pull secret used by the inspections of
+// the FacadeSingleton changes.
+func SetPullSecretObserver(observer PullSecretObserver) {
+	FacadeSingleton().(*Facade).inspectionCache.setPullSecretObserver(observer)
+}
@@ -0,0 +1,122 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/openshift/api/image/docker10"
+	imagev1 "github.com/openshift/api/image/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// internalRegistryHosts are the hosts the OpenShift internal registry is reachable at from the pods
+var internalRegistryHosts = sets.New[string](
+	"image-registry.openshift-image-registry.svc:5000",
+	"image-registry.openshift-image-registry.svc.cluster.local:5000",
+)
+
+// errNotAnImageStreamReference is returned when the image reference does not point to an image stream of the
+// internal registry, in the <registry>/<namespace>/<stream>[:<tag>|@<digest>] layout.
+var errNotAnImageStreamReference = errors.New("the image reference does not point to an image stream of the internal registry")
+
+// ImageStreamResolver gets the architectures supported by the images pulled from the OpenShift internal registry by
+// reading the metadata that the ImageStreamTag and ImageStreamImage objects carry, without contacting the registry.
+type ImageStreamResolver struct {
+	// client is expected to be an uncached reader: the imagestreamtags and imagestreamimages resources do not
+	// support watches
+	client client.Reader
+}
+
+func NewImageStreamResolver(c client.Reader) *ImageStreamResolver {
+	return &ImageStreamResolver{
+		client: c,
+	}
+}
+
+//+kubebuilder:rbac:groups=image.openshift.io,resources=imagestreamtags;imagestreamimages,verbs=get
+
+// GetCompatibleArchitecturesSet returns the set of architectures supported by imageReference, as reported by the
+// image stream it refers to. It returns an error if imageReference does not point to an image stream of the
+// internal registry or the image stream does not report the architectures: callers are expected to fall back to the
+// registry inspection.
+func (r *ImageStreamResolver) GetCompatibleArchitecturesSet(ctx context.Context, imageReference string) (sets.Set[string], error) {
+	namespace, name, isTag, err := parseImageStreamReference(imageReference)
+	if err != nil {
+		return nil, err
+	}
+	var image imagev1.Image
+	if isTag {
+		ist := &imagev1.ImageStreamTag{}
+		if err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, ist); err != nil {
+			return nil, err
+		}
+		image = ist.Image
+	} else {
+		isi := &imagev1.ImageStreamImage{}
+		if err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, isi); err != nil {
+			return nil, err
+		}
+		image = isi.Image
+	}
+	supportedArchitectures, err := imageArchitectures(&image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the architectures of %s/%s: %w", namespace, name, err)
+	}
+	klog.V(5).Infof("image %s resolved through the image stream %s/%s: %v", imageReference, namespace, name,
+		sets.List(supportedArchitectures))
+	return supportedArchitectures, nil
+}
+
+// parseImageStreamReference returns the namespace and the name of the ImageStreamTag (isTag = true) or
+// ImageStreamImage (isTag = false) imageReference refers to.
+func parseImageStreamReference(imageReference string) (namespace, name string, isTag bool, err error) {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(imageReference, "//"))
+	if err != nil {
+		return "", "", false, err
+	}
+	if !internalRegistryHosts.Has(reference.Domain(named)) {
+		return "", "", false, errNotAnImageStreamReference
+	}
+	path := strings.Split(reference.Path(named), "/")
+	if len(path) != 2 {
+		return "", "", false, errNotAnImageStreamReference
+	}
+	namespace, stream := path[0], path[1]
+	if digested, ok := named.(reference.Digested); ok {
+		return namespace, fmt.Sprintf("%s@%s", stream, digested.Digest()), false, nil
+	}
+	tag := "latest"
+	if tagged, ok := named.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	return namespace, fmt.Sprintf("%s:%s", stream, tag), true, nil
+}
+
+// imageArchitectures returns the architectures of the sub-manifests of image, if it is a manifest list, or the
+// architecture in its docker metadata otherwise.
+func imageArchitectures(image *imagev1.Image) (sets.Set[string], error) {
+	supportedArchitectures := sets.New[string]()
+	if len(image.DockerImageManifests) > 0 {
+		for _, m := range image.DockerImageManifests {
+			supportedArchitectures.Insert(NormalizeArchitecture(m.Architecture))
+		}
+		return supportedArchitectures, nil
+	}
+	if len(image.DockerImageMetadata.Raw) == 0 {
+		return nil, errors.New("the image has no docker metadata")
+	}
+	metadata := &docker10.DockerImage{}
+	if err := json.Unmarshal(image.DockerImageMetadata.Raw, metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Architecture == "" {
+		return nil, errors.New("the image metadata does not report the architecture")
+	}
+	return supportedArchitectures.Insert(NormalizeArchitecture(metadata.Architecture)), nil
+}
@@ -0,0 +1,95 @@
+package image
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	imagev1 "github.com/openshift/api/image/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testInternalRegistry = "image-registry.openshift-image-registry.svc:5000"
+	testDigest           = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func imageStreamTag(namespace, name string, image imagev1.Image) *imagev1.ImageStreamTag {
+	return &imagev1.ImageStreamTag{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Image:      image,
+	}
+}
+
+func manifestListImage(architectures ...string) imagev1.Image {
+	image := imagev1.Image{}
+	for _, arch := range architectures {
+		image.DockerImageManifests = append(image.DockerImageManifests, imagev1.ImageManifest{
+			Architecture: arch,
+			OS:           "linux",
+		})
+	}
+	return image
+}
+
+var _ = Describe("The ImageStreamResolver", func() {
+	var resolver *ImageStreamResolver
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(imagev1.AddToScheme(scheme)).To(Succeed())
+		resolver = NewImageStreamResolver(fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			imageStreamTag("ns", "multi:v1", manifestListImage("amd64", "arm64")),
+			imageStreamTag("ns", "single:latest", imagev1.Image{
+				DockerImageMetadata: runtime.RawExtension{Raw: []byte(`{"Architecture":"s390x"}`)},
+			}),
+			imageStreamTag("ns", "no-metadata:latest", imagev1.Image{}),
+			imageStreamTag("ns", "aliases:v1", manifestListImage("x86_64", "aarch64")),
+			imageStreamTag("ns", "alias:latest", imagev1.Image{
+				DockerImageMetadata: runtime.RawExtension{Raw: []byte(`{"Architecture":"aarch64"}`)},
+			}),
+			&imagev1.ImageStreamImage{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "multi@" + testDigest},
+				Image:      manifestListImage("ppc64le"),
+			},
+		).Build())
+	})
+
+	DescribeTable("should read the architectures from the image stream",
+		func(imageReference string, expected []string) {
+			architectures, err := resolver.GetCompatibleArchitecturesSet(context.Background(), imageReference)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(architectures.UnsortedList()).To(ConsistOf(expected))
+		},
+		Entry("manifest list through an ImageStreamTag", "//"+testInternalRegistry+"/ns/multi:v1",
+			[]string{"amd64", "arm64"}),
+		Entry("single-arch image with the implicit latest tag", "//"+testInternalRegistry+"/ns/single",
+			[]string{"s390x"}),
+		Entry("digest reference through an ImageStreamImage", testInternalRegistry+"/ns/multi@"+testDigest,
+			[]string{"ppc64le"}),
+		Entry("manifest list with architecture aliases", "//"+testInternalRegistry+"/ns/aliases:v1",
+			[]string{"amd64", "arm64"}),
+		Entry("single-arch image with an architecture alias", "//"+testInternalRegistry+"/ns/alias",
+			[]string{"arm64"}),
+	)
+
+	DescribeTable("should return an error so that the caller falls back to the registry inspection",
+		func(imageReference string) {
+			_, err := resolver.GetCompatibleArchitecturesSet(context.Background(), imageReference)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("external registry", "//quay.io/ns/multi:v1"),
+		Entry("unexpected path layout", "//"+testInternalRegistry+"/ns/nested/multi:v1"),
+		Entry("missing ImageStreamTag", "//"+testInternalRegistry+"/ns/missing:v1"),
+		Entry("image without metadata", "//"+testInternalRegistry+"/ns/no-metadata:latest"),
+	)
+
+	It("should not query the API server for references outside of the internal registry", func() {
+		_, err := resolver.GetCompatibleArchitecturesSet(context.Background(), "//quay.io/ns/multi:v1")
+		Expect(errors.Is(err, errNotAnImageStreamReference)).To(BeTrue())
+	})
+})
@@ -0,0 +1,100 @@
+package inspect
+
+import (
+	"errors"
+	"fmt"
+
+	"multiarch-operator/pkg/image"
+)
+
+// ErrorKind classifies the failures of the inspections
+type ErrorKind string
+
+const (
+	// InvalidReference is the kind of the failures to parse the image reference. Retrying does not help.
+	InvalidReference ErrorKind = "InvalidReference"
+	// UnsupportedTransport is the kind of the failures of the image references of a transport other than docker,
+	// e.g., oci:/path/to/layout, whose images are not in a registry. Retrying does not help.
+	UnsupportedTransport ErrorKind = "UnsupportedTransport"
+	// NotFound is the kind of the failures of the images missing from the registry and all its mirrors
+	NotFound ErrorKind = "NotFound"
+	// Unauthorized is the kind of the failures of the inspections the registry rejected all the credentials of
+	Unauthorized ErrorKind = "Unauthorized"
+	// TLSPolicy is the kind of the failures of the registries that do not comply with the TLS policy of the cluster
+	TLSPolicy ErrorKind = "TLSPolicy"
+	// Transient is the kind of all the other failures, e.g., the network errors, that can be retried unless Retryable
+	// reports otherwise, e.g., for the certificates of the registry that cannot be verified
+	Transient ErrorKind = "Transient"
+)
+
+// Error is the error of a failed inspection
+type Error struct {
+	// Kind is the kind of the failure
+	Kind ErrorKind
+	// Reference is the image reference, as given to the Inspector
+	Reference string
+	// Err is the underlying error
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("the inspection of the image %s failed (%s): %v", e.Reference, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns true if retrying the inspection later can succeed. The inspections try each pull source of the
+// image once: the callers own the backoff of the retries. Only the Transient failures can be retryable, depending on
+// their image.ErrorClass, e.g., the DNS failures, the 429 and the 5xx answers of the registry.
+func (e *Error) Retryable() bool {
+	return e.Kind == Transient && image.ClassifyError(e.Err).Retryable()
+}
+
+// IsRetryable returns true if err is nil, if it is or wraps a retryable *Error, or if it wraps no *Error
+func IsRetryable(err error) bool {
+	var inspectErr *Error
+	if errors.As(err, &inspectErr) {
+		return inspectErr.Retryable()
+	}
+	return true
+}
+
+// KindOf returns the kind of the err, if it is or wraps an *Error, or Transient otherwise. It returns an empty kind
+// for a nil err.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	var inspectErr *Error
+	if errors.As(err, &inspectErr) {
+		return inspectErr.Kind
+	}
+	return Transient
+}
+
+// newError classifies the err of the inspection of the image reference
+func newError(imageReference string, err error) *Error {
+	var (
+		invalidErr   *image.InvalidReferenceError
+		transportErr *image.UnsupportedTransportError
+		tlsErr       *image.TLSPolicyError
+		kind         ErrorKind
+	)
+	switch {
+	case errors.As(err, &invalidErr):
+		kind = InvalidReference
+	case errors.As(err, &transportErr):
+		kind = UnsupportedTransport
+	case errors.As(err, &tlsErr):
+		kind = TLSPolicy
+	case image.IsNotFound(err):
+		kind = NotFound
+	case image.IsUnauthorized(err):
+		kind = Unauthorized
+	default:
+		kind = Transient
+	}
+	return &Error{Kind: kind, Reference: imageReference, Err: err}
+}
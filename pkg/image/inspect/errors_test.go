@@ -0,0 +1,98 @@
+package inspect
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/containers/image/v5/docker"
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/system_config"
+)
+
+// failingCache is an image.IPlatformsCache failing all the inspections with err
+type failingCache struct {
+	err error
+}
+
+func (c *failingCache) GetInspection(context.Context, string, [][]byte) (image.Inspection, error) {
+	return image.Inspection{}, c.err
+}
+
+func (c *failingCache) CachedInspection(string) (image.Inspection, bool) {
+	return image.Inspection{}, false
+}
+
+var _ = Describe("The errors of the inspections", func() {
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		config system_config.ConfigPaths
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server, config = newFakeRegistry()
+	})
+
+	DescribeTable("should be classified by kind",
+		func(imageReference func() string, secrets bool, kind ErrorKind) {
+			var pullSecrets [][]byte
+			if secrets {
+				pullSecrets = [][]byte{dockerConfigAuths(registryHost(server))}
+			}
+			_, err := New(config, nil).Inspect(ctx, imageReference(), pullSecrets)
+			var inspectErr *Error
+			Expect(errors.As(err, &inspectErr)).To(BeTrue())
+			Expect(inspectErr.Kind).To(Equal(kind))
+			Expect(inspectErr.Reference).To(Equal(imageReference()))
+			Expect(KindOf(err)).To(Equal(kind))
+			Expect(IsRetryable(err)).To(BeFalse())
+		},
+		Entry("invalid reference", func() string { return "quay.io/org/app:" }, false, InvalidReference),
+		Entry("digest without a repository", func() string {
+			return "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		}, false, InvalidReference),
+		Entry("unsupported transport", func() string { return "containers-storage:app" }, false, UnsupportedTransport),
+		Entry("missing image", func() string { return registryHost(server) + "/org/missing:latest" }, true, NotFound),
+		Entry("rejected credentials", func() string { return registryHost(server) + "/org/app:latest" }, false,
+			Unauthorized),
+	)
+
+	It("should be transient when the registry is unreachable", func() {
+		imageReference := registryHost(server) + "/org/app:latest"
+		server.Close()
+		_, err := New(config, nil).Inspect(ctx, imageReference, [][]byte{dockerConfigAuths(registryHost(server))})
+		Expect(KindOf(err)).To(Equal(Transient))
+		Expect(IsRetryable(err)).To(BeTrue())
+	})
+
+	It("should not be retryable when the certificate of the registry cannot be verified", func() {
+		_, err := FromCache(&failingCache{err: x509.UnknownAuthorityError{}}, config).Inspect(ctx,
+			"quay.io/org/app:latest", nil)
+		Expect(KindOf(err)).To(Equal(Transient))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+
+	It("should wrap the underlying errors", func() {
+		underlying := docker.ErrUnauthorizedForCredentials{Err: errors.New("denied")}
+		_, err := FromCache(&failingCache{err: underlying}, config).Inspect(ctx, "quay.io/org/app:latest", nil)
+		Expect(KindOf(err)).To(Equal(Unauthorized))
+		Expect(errors.Is(err, underlying)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("quay.io/org/app:latest")))
+
+		policyErr := &image.TLSPolicyError{Registry: "quay.io", Policy: "minimum version TLS 1.3", Err: errors.New("TLS 1.2")}
+		_, err = FromCache(&failingCache{err: policyErr}, config).Inspect(ctx, "quay.io/org/app:latest", nil)
+		Expect(KindOf(err)).To(Equal(TLSPolicy))
+	})
+
+	It("should report the kind of the other errors as transient", func() {
+		Expect(KindOf(nil)).To(BeEmpty())
+		Expect(KindOf(errors.New("connection reset"))).To(Equal(Transient))
+		Expect(IsRetryable(errors.New("connection reset"))).To(BeTrue())
+	})
+})
@@ -0,0 +1,184 @@
+// Package inspect is the stable API of the inspection of the platforms supported by the container images.
+//
+// An Inspector is built by New from the paths of the registries configuration, i.e., the registries.conf files, the
+// signature policy and the CAs of the registries, and from an optional Keychain providing the credentials used by all
+// the inspections. The results and the errors of the inspections are cached as configured in the image package, e.g.,
+// by image.SetArchitecturesCacheTTL and image.SetFailureCacheTTLs.
+//
+// The InspectionResult is versioned by APIVersion: its fields are only added in a backward compatible way within the
+// same version. The errors are *Error values classified by an ErrorKind, so that the callers can decide whether to
+// retry them without depending on the errors of the registries' client.
+package inspect
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"multiarch-operator/pkg/image"
+	"multiarch-operator/pkg/system_config"
+)
+
+// APIVersion is the version of the InspectionResult
+const APIVersion = "inspect.multiarch.openshift.io/v1"
+
+// Platform is a platform supported by an image. The architecture is normalized to its GOARCH name, e.g., amd64 for
+// x86_64.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// InspectionResult is the result of the inspection of an image
+type InspectionResult struct {
+	// APIVersion is the version of the result, i.e., APIVersion
+	APIVersion string `json:"apiVersion"`
+	// Reference is the normalized reference of the image, e.g., docker.io/library/nginx:latest for nginx
+	Reference string `json:"reference"`
+	// Platforms are the platforms supported by the image, in the order of its manifest list
+	Platforms []Platform `json:"platforms"`
+	// Digest is the digest of the manifest, or of the manifest list, the reference resolved to when the image was
+	// inspected, e.g., sha256:<hex>. The tags can move to other images afterwards: the digest identifies the inspected
+	// one.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Architectures returns the architectures supported by the image
+func (r *InspectionResult) Architectures() sets.Set[string] {
+	architectures := sets.New[string]()
+	for _, platform := range r.Platforms {
+		architectures.Insert(platform.Architecture)
+	}
+	return architectures
+}
+
+// Supports returns true if one of the platforms of the image matches the platform, in the os/architecture[/variant]
+// format, e.g., linux/arm/v7, or an architecture alone, e.g., the kubernetes.io/arch label of a node. The variants are
+// only compared for arm, see image.Platform.Matches.
+func (r *InspectionResult) Supports(platform string) (bool, error) {
+	parsed, err := image.ParsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range r.Platforms {
+		if parsed.Matches(image.Platform(candidate)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Keychain provides the credentials used by all the inspections, in addition to the pull secrets of each inspection
+type Keychain interface {
+	// Auths returns the credentials, in the format of the auths field of a docker config.json file
+	Auths() []byte
+}
+
+// StaticKeychain returns a Keychain always providing the auths, e.g., the content of a docker config.json file
+func StaticKeychain(auths []byte) Keychain {
+	return staticKeychain(auths)
+}
+
+type staticKeychain []byte
+
+func (k staticKeychain) Auths() []byte {
+	return k
+}
+
+// CachedInspector returns the results of the inspections without inspecting the images
+type CachedInspector interface {
+	// Cached returns the cached result of the inspection of the image, if it did not expire. It never inspects the
+	// image: ok is false when the result is not cached.
+	Cached(imageReference string) (result *InspectionResult, ok bool)
+}
+
+// Inspector inspects the platforms supported by the images
+type Inspector interface {
+	CachedInspector
+	// Inspect returns the platforms supported by the image, inspecting it with the pull secrets, in the format of the
+	// auths field of a docker config.json file, and the credentials of the Keychain, if its result is not cached.
+	// The errors are *Error values.
+	Inspect(ctx context.Context, imageReference string, pullSecrets [][]byte) (*InspectionResult, error)
+	// PullSources returns the locations the image is pulled from, i.e., its mirrors and the registry itself, in the
+	// order they are tried. The errors are *Error values.
+	PullSources(imageReference string) ([]string, error)
+}
+
+// New returns an Inspector reading the registries configuration from the config and the credentials used by all the
+// inspections from the keychain, if not nil. The Inspector does not need a connection to a cluster.
+func New(config system_config.IConfigReader, keychain Keychain) Inspector {
+	var imageKeychain image.Keychain
+	if keychain != nil {
+		imageKeychain = keychain
+	}
+	return FromCache(image.NewInspectionCache(config, imageKeychain), config)
+}
+
+// FromCache returns an Inspector backed by the cache, e.g., image.PlatformsCacheSingleton(), reading the mirrors of
+// the images from the config
+func FromCache(cache image.IPlatformsCache, config system_config.IConfigReader) Inspector {
+	return &inspector{cache: cache, config: config}
+}
+
+// Singleton returns the Inspector backed by the cache of the operator, using the global pull secret of the cluster and
+// the registries configuration written by the system_config syncer. It requires an in-cluster configuration.
+func Singleton() Inspector {
+	return FromCache(image.PlatformsCacheSingleton(), system_config.DefaultConfigPaths)
+}
+
+type inspector struct {
+	cache  image.IPlatformsCache
+	config system_config.IConfigReader
+}
+
+func (i *inspector) Inspect(ctx context.Context, imageReference string, pullSecrets [][]byte) (*InspectionResult, error) {
+	normalized, err := image.NormalizeReference(imageReference)
+	if err != nil {
+		return nil, newError(imageReference, err)
+	}
+	inspection, err := i.cache.GetInspection(ctx, normalized, pullSecrets)
+	if err != nil {
+		return nil, newError(imageReference, err)
+	}
+	return newInspectionResult(normalized, inspection), nil
+}
+
+func (i *inspector) Cached(imageReference string) (*InspectionResult, bool) {
+	normalized, err := image.NormalizeReference(imageReference)
+	if err != nil {
+		return nil, false
+	}
+	inspection, ok := i.cache.CachedInspection(normalized)
+	if !ok {
+		return nil, false
+	}
+	return newInspectionResult(normalized, inspection), true
+}
+
+func (i *inspector) PullSources(imageReference string) ([]string, error) {
+	sources, err := image.PullSources(i.config, imageReference)
+	if err != nil {
+		return nil, newError(imageReference, err)
+	}
+	return sources, nil
+}
+
+// newInspectionResult converts the inspection of the normalized reference, as cached by the image package
+func newInspectionResult(normalized string, inspection image.Inspection) *InspectionResult {
+	result := &InspectionResult{
+		APIVersion: APIVersion,
+		Reference:  strings.TrimPrefix(normalized, "//"),
+		Platforms:  make([]Platform, 0, len(inspection.Platforms)),
+		Digest:     inspection.Digest,
+	}
+	for _, platform := range inspection.Platforms {
+		result.Platforms = append(result.Platforms, Platform{
+			OS:           platform.OS,
+			Architecture: platform.Architecture,
+			Variant:      platform.Variant,
+		})
+	}
+	return result
+}
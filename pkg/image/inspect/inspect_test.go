@@ -0,0 +1,170 @@
+package inspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"multiarch-operator/pkg/system_config"
+)
+
+const (
+	testUsername = "user"
+	testPassword = "pass"
+)
+
+// testImageIndex is the OCI index of org/app served by the fake registry
+var testImageIndex = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[`+
+	`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s","size":1,"platform":{"architecture":"amd64","os":"linux"}},`+
+	`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s","size":1,"platform":{"architecture":"arm","os":"linux","variant":"v7"}}]}`,
+	strings.Repeat("a", 64), strings.Repeat("b", 64))
+
+// newFakeRegistry returns a registry serving the manifest list of org/app to the testUsername user, and its
+// configuration: empty registries.conf files and the folder of its CA
+func newFakeRegistry() (*httptest.Server, system_config.ConfigPaths) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != testUsername || password != testPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake-registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v2/org/app/manifests/latest" {
+			if r.URL.Path == "/v2/" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		_, _ = w.Write([]byte(testImageIndex))
+	}))
+	DeferCleanup(server.Close)
+	config := system_config.ConfigPaths{
+		RegistriesConf:    filepath.Join(GinkgoT().TempDir(), "registries.conf"),
+		RegistriesConfDir: GinkgoT().TempDir(),
+		Policy:            system_config.PolicyConfPath,
+		DockerCerts:       GinkgoT().TempDir(),
+	}
+	Expect(os.WriteFile(config.RegistriesConf, nil, 0644)).To(Succeed())
+	certsDir := filepath.Join(config.DockerCerts, registryHost(server))
+	Expect(os.MkdirAll(certsDir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(certsDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0644)).To(Succeed())
+	return server, config
+}
+
+func registryHost(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+// dockerConfigAuths returns the auths field of a docker config.json file with the credentials of the testUsername user
+func dockerConfigAuths(registry string) []byte {
+	auths, err := json.Marshal(map[string]interface{}{
+		registry: map[string]string{
+			"auth": base64.StdEncoding.EncodeToString([]byte(testUsername + ":" + testPassword)),
+		},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return auths
+}
+
+var _ = Describe("The inspector", func() {
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		config system_config.ConfigPaths
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server, config = newFakeRegistry()
+	})
+
+	It("should inspect the platforms and the digest with the credentials of the keychain and cache them", func() {
+		inspector := New(config, StaticKeychain(dockerConfigAuths(registryHost(server))))
+		imageReference := registryHost(server) + "/org/app"
+		_, ok := inspector.Cached(imageReference)
+		Expect(ok).To(BeFalse())
+
+		result, err := inspector.Inspect(ctx, imageReference, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&InspectionResult{
+			APIVersion: APIVersion,
+			Reference:  imageReference + ":latest",
+			Platforms: []Platform{
+				{OS: "linux", Architecture: "amd64"},
+				{OS: "linux", Architecture: "arm", Variant: "v7"},
+			},
+			Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testImageIndex))),
+		}))
+		Expect(result.Architectures().UnsortedList()).To(ConsistOf("amd64", "arm"))
+
+		server.Close()
+		cached, ok := inspector.Cached("docker://" + imageReference + ":latest")
+		Expect(ok).To(BeTrue())
+		Expect(cached).To(Equal(result))
+	})
+
+	It("should inspect the platforms with the pull secrets", func() {
+		result, err := New(config, nil).Inspect(ctx, registryHost(server)+"/org/app:latest",
+			[][]byte{dockerConfigAuths(registryHost(server))})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Architectures().UnsortedList()).To(ConsistOf("amd64", "arm"))
+	})
+
+	It("should return the pull sources of the images", func() {
+		Expect(os.WriteFile(config.RegistriesConf, []byte(`
+[[registry]]
+location = "registry.example.com/org"
+
+[[registry.mirror]]
+location = "mirror.example.com/org"
+`), 0644)).To(Succeed())
+		inspector := New(config, nil)
+		Expect(inspector.PullSources("registry.example.com/org/app:v1")).To(Equal([]string{
+			"mirror.example.com/org/app:v1", "registry.example.com/org/app:v1",
+		}))
+		Expect(inspector.PullSources("quay.io/other/app:v1")).To(Equal([]string{"quay.io/other/app:v1"}))
+		_, err := inspector.PullSources("oci:/path/to/layout")
+		Expect(KindOf(err)).To(Equal(UnsupportedTransport))
+	})
+})
+
+var _ = Describe("The inspection results", func() {
+	result := &InspectionResult{Platforms: []Platform{
+		{OS: "linux", Architecture: "s390x", Variant: "v1"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}}
+
+	DescribeTable("should support the matching platforms",
+		func(platform string, expected bool) {
+			Expect(result.Supports(platform)).To(Equal(expected))
+		},
+		Entry("node architecture", "s390x", true),
+		Entry("platform without the variant", "linux/s390x", true),
+		Entry("arm variant", "linux/arm/v7", true),
+		Entry("other arm variant", "linux/arm/v6", false),
+		Entry("other architecture", "amd64", false),
+	)
+
+	It("should reject the invalid platforms", func() {
+		_, err := result.Supports("linux/")
+		Expect(err).To(HaveOccurred())
+	})
+})
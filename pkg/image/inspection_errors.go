@@ -0,0 +1,57 @@
+package image
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRecentInspectionErrors is the number of failed inspections returned by RecentInspectionErrors
+const maxRecentInspectionErrors = 10
+
+// InspectionErrorSample is a failed inspection of an image from its registry
+type InspectionErrorSample struct {
+	Time time.Time `json:"time"`
+	// Image is the normalized reference of the image
+	Image string `json:"image"`
+	// Kind is the kind of the failure: not_found, unauthorized or transient
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+}
+
+// inspectionErrorSamples keeps the last failed inspections in a ring buffer
+type inspectionErrorSamples struct {
+	mutex   sync.Mutex
+	samples []InspectionErrorSample
+	// next is the index of the ring buffer the next sample is written to once it is full
+	next int
+}
+
+var recentInspectionErrors = &inspectionErrorSamples{}
+
+func (s *inspectionErrorSamples) add(sample InspectionErrorSample) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.samples) < maxRecentInspectionErrors {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % maxRecentInspectionErrors
+}
+
+// list returns the samples, the most recent first
+func (s *inspectionErrorSamples) list() []InspectionErrorSample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	samples := make([]InspectionErrorSample, 0, len(s.samples))
+	for i := len(s.samples) - 1; i >= 0; i-- {
+		samples = append(samples, s.samples[(s.next+i)%len(s.samples)])
+	}
+	return samples
+}
+
+// RecentInspectionErrors returns the last failed inspections of the images from their registries, the most recent
+// first. The failures answered from the cache are not included.
+func RecentInspectionErrors() []InspectionErrorSample {
+	return recentInspectionErrors.list()
+}
@@ -0,0 +1,31 @@
+package image
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The recent inspection errors", func() {
+	It("should keep the most recent failed inspections first", func() {
+		samples := &inspectionErrorSamples{}
+		Expect(samples.list()).To(BeEmpty())
+		for i := 0; i < 3; i++ {
+			samples.add(InspectionErrorSample{Image: fmt.Sprintf("image-%d", i)})
+		}
+		Expect(samples.list()).To(HaveExactElements(
+			HaveField("Image", "image-2"), HaveField("Image", "image-1"), HaveField("Image", "image-0")))
+	})
+
+	It("should be bounded", func() {
+		samples := &inspectionErrorSamples{}
+		for i := 0; i < maxRecentInspectionErrors+3; i++ {
+			samples.add(InspectionErrorSample{Image: fmt.Sprintf("image-%d", i)})
+		}
+		listed := samples.list()
+		Expect(listed).To(HaveLen(maxRecentInspectionErrors))
+		Expect(listed[0].Image).To(Equal(fmt.Sprintf("image-%d", maxRecentInspectionErrors+2)))
+		Expect(listed[maxRecentInspectionErrors-1].Image).To(Equal("image-3"))
+	})
+})
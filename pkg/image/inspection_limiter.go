@@ -0,0 +1,85 @@
+package image
+
+import (
+	"context"
+	"sync"
+)
+
+// inspectionLimiter bounds the number of the inspections running at the same time. The inspections exceeding the
+// limit wait for a slot in their arrival order. The limit can be changed while the inspections run: lowering it lets
+// the running inspections complete, raising it starts the waiting ones right away. A non-positive limit means no limit.
+type inspectionLimiter struct {
+	mutex   sync.Mutex
+	max     int
+	running int
+	// waiters are the inspections waiting for a slot, the oldest first. Their channel is closed when they get one.
+	waiters []chan struct{}
+}
+
+// acquire waits for a slot until the context is done
+func (l *inspectionLimiter) acquire(ctx context.Context) error {
+	l.mutex.Lock()
+	if l.max <= 0 || l.running < l.max {
+		l.running++
+		l.mutex.Unlock()
+		return nil
+	}
+	slot := make(chan struct{})
+	l.waiters = append(l.waiters, slot)
+	l.mutex.Unlock()
+	select {
+	case <-slot:
+		return nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		for i, waiter := range l.waiters {
+			if waiter == slot {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// the slot was handed over while the context was done: give it back
+		l.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a completed inspection
+func (l *inspectionLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.releaseLocked()
+}
+
+func (l *inspectionLimiter) releaseLocked() {
+	l.running--
+	l.grantLocked()
+}
+
+// grantLocked hands the free slots over to the oldest waiters
+func (l *inspectionLimiter) grantLocked() {
+	for len(l.waiters) > 0 && (l.max <= 0 || l.running < l.max) {
+		l.running++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+func (l *inspectionLimiter) setMax(max int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.max = max
+	l.grantLocked()
+}
+
+// inspections bounds the inspections of all the caches of the process
+var inspections = &inspectionLimiter{}
+
+// SetMaxParallelInspections sets the number of the inspections of images running at the same time, e.g., to bound
+// the load on the registries and the memory of the operator when many workloads are created at once. The inspections
+// exceeding it wait for a slot, within their inspection timeout. Zero, the default, means no limit.
+// It can be called while the inspections run.
+func SetMaxParallelInspections(max int) {
+	inspections.setMax(max)
+}
@@ -0,0 +1,58 @@
+package image
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The limiter of the parallel inspections", func() {
+	var l *inspectionLimiter
+
+	BeforeEach(func() {
+		l = &inspectionLimiter{max: 1}
+	})
+
+	// acquireAsync acquires a slot in a new goroutine, returning the channel of its result
+	acquireAsync := func(ctx context.Context) <-chan error {
+		result := make(chan error, 1)
+		go func() { result <- l.acquire(ctx) }()
+		return result
+	}
+
+	It("should hand the released slots over to the waiting inspections in their order", func() {
+		Expect(l.acquire(context.Background())).To(Succeed())
+		first := acquireAsync(context.Background())
+		Eventually(func() int { l.mutex.Lock(); defer l.mutex.Unlock(); return len(l.waiters) }).Should(Equal(1))
+		second := acquireAsync(context.Background())
+		Eventually(func() int { l.mutex.Lock(); defer l.mutex.Unlock(); return len(l.waiters) }).Should(Equal(2))
+		Consistently(first, 50*time.Millisecond).ShouldNot(Receive())
+		l.release()
+		Eventually(first).Should(Receive(BeNil()))
+		Consistently(second, 50*time.Millisecond).ShouldNot(Receive())
+		l.release()
+		Eventually(second).Should(Receive(BeNil()))
+	})
+
+	It("should start the waiting inspections when the limit is raised", func() {
+		Expect(l.acquire(context.Background())).To(Succeed())
+		waiting := acquireAsync(context.Background())
+		Consistently(waiting, 50*time.Millisecond).ShouldNot(Receive())
+		l.setMax(0)
+		Eventually(waiting).Should(Receive(BeNil()))
+	})
+
+	It("should stop waiting when the context is done without leaking its slot", func() {
+		Expect(l.acquire(context.Background())).To(Succeed())
+		ctx, cancel := context.WithCancel(context.Background())
+		waiting := acquireAsync(ctx)
+		Eventually(func() int { l.mutex.Lock(); defer l.mutex.Unlock(); return len(l.waiters) }).Should(Equal(1))
+		cancel()
+		Eventually(waiting).Should(Receive(MatchError(context.Canceled)))
+		l.release()
+		Expect(l.acquire(context.Background())).To(Succeed())
+		Expect(l.running).To(Equal(1))
+	})
+})
@@ -1,36 +1,77 @@
 package image
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/image"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
 	"golang.org/x/sys/unix"
 	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/klog/v2"
 	"multiarch-operator/controllers/core"
 	"multiarch-operator/pkg/system_config"
+	"multiarch-operator/pkg/system_config/eval"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 type registryInspector struct {
-	globalPullSecret []byte
+	// config are the paths of the files configuring the registries. It defaults to system_config.DefaultConfigPaths.
+	config system_config.IConfigReader
+	// keychain provides the credentials used by all the inspections. When nil, the global pull secret is used.
+	keychain           Keychain
+	globalPullSecret   []byte
+	tokenAuthenticator *tokenAuthenticator
+	tlsPolicyChecker   *tlsPolicyChecker
+	pullSecretObserver PullSecretObserver
 	// mutex is used to protect the globalPullSecret field of the singletonImageFacade from concurrent write access
 	mutex sync.Mutex
 }
 
 func (i *registryInspector) GetCompatibleArchitecturesSet(ctx context.Context, imageReference string, secrets [][]byte) (supportedArchitectures sets.Set[string], err error) {
+	inspection, err := i.GetInspection(ctx, imageReference, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return platformsArchitectures(inspection.Platforms), nil
+}
+
+// GetInspection inspects the platforms supported by the image and its digest
+func (i *registryInspector) GetInspection(ctx context.Context, imageReference string, secrets [][]byte) (Inspection, error) {
+	// Check if the image is a manifest list
+	ref, err := docker.ParseReference(imageReference)
+	if err != nil {
+		klog.Warningf("Error parsing the image reference for the image %s: %v", imageReference, err)
+		return Inspection{}, err
+	}
+	sys := i.systemContext()
+	if err := i.tlsPolicyChecker.check(ctx, sys, ref); err != nil {
+		klog.Warningf("Error inspecting the image %s: %v", imageReference, err)
+		return Inspection{}, err
+	}
+	if core.DebugLogEnabled(ctx) {
+		debugLogPullSources(ctx, sys, ref)
+	}
+	if useKubeletCompatibleCredentials() {
+		return i.inspectWithKubeletCredentials(ctx, sys, ref, imageReference, secrets)
+	}
 	// Create the auth file
-	authFile, err := i.createAuthFile(append([][]byte{i.globalPullSecret}, secrets...)...)
+	authFile, err := i.createAuthFile(append([][]byte{i.globalAuths()}, secrets...)...)
 	if err != nil {
 		klog.Warningf("Couldn't write auth file for: %v", err)
-		return nil, err
+		return Inspection{}, err
 	} else {
 		defer func(f *os.File) {
 			if err := f.Close(); err != nil {
@@ -38,24 +79,133 @@ func (i *registryInspector) GetCompatibleArchitecturesSet(ctx context.Context, i
 			}
 		}(authFile)
 	}
-	// Check if the image is a manifest list
-	ref, err := docker.ParseReference(imageReference)
+	sys.AuthFilePath = authFile.Name()
+	core.DebugLog(ctx, "Inspecting the image %s with the merged credentials of the %d pull secrets of the pod and "+
+		"the global pull secret", imageReference, len(secrets))
+	return i.inspectWithCredentials(ctx, sys, ref, imageReference)
+}
+
+// inspectWithKubeletCredentials tries the credentials of the pod's pull secrets and then the ones of the global pull
+// secret, as the kubelet does with the pod's keyring and the node's one, until the inspection succeeds. The image is
+// inspected anonymously if no credentials match it. The remaining credentials are not tried after a failure that is not
+// specific to the credentials, e.g., a registry answering 503: the caller retries the inspection later.
+func (i *registryInspector) inspectWithKubeletCredentials(ctx context.Context, sys *types.SystemContext,
+	ref types.ImageReference, imageReference string, secrets [][]byte) (Inspection, error) {
+	podKeyring := newDockerKeyring()
+	for _, secret := range secrets {
+		if err := podKeyring.add(secret); err != nil {
+			klog.Warningf("Ignoring an invalid pull secret of the image %s: %v", imageReference, err)
+		}
+	}
+	nodeKeyring := newDockerKeyring()
+	if globalAuths := i.globalAuths(); len(globalAuths) > 0 {
+		if err := nodeKeyring.add(globalAuths); err != nil {
+			klog.Warningf("Ignoring the invalid global pull secret: %v", err)
+		}
+	}
+	repository := ref.DockerReference().Name()
+	podCredentials := podKeyring.lookup(repository)
+	credentials := append(podCredentials, nodeKeyring.lookup(repository)...)
+	if len(credentials) == 0 {
+		credentials = []types.DockerAuthConfig{{}}
+	}
+	var errs []error
+	for n, credential := range credentials {
+		credential := credential
+		if core.DebugLogEnabled(ctx) {
+			source := "the global pull secret"
+			if n < len(podCredentials) {
+				source = "the pull secrets of the pod"
+			}
+			core.DebugLog(ctx, "Trying the credentials %d of %d for the image %s: %s from %s", n+1, len(credentials),
+				imageReference, credentialIdentity(credential), source)
+		}
+		sys.DockerAuthConfig = &credential
+		sys.DockerBearerRegistryToken = ""
+		inspection, err := i.inspectWithCredentials(ctx, sys, ref, imageReference)
+		if err == nil {
+			return inspection, nil
+		}
+		core.DebugLog(ctx, "The inspection of the image %s with the credentials %d failed: %v", imageReference, n+1, err)
+		errs = append(errs, err)
+		if ClassifyError(err).failsAllCredentials() {
+			break
+		}
+	}
+	if len(errs) == 1 {
+		return Inspection{}, errs[0]
+	}
+	return Inspection{}, utilerrors.NewAggregate(errs)
+}
+
+// inspectWithCredentials inspects the image with the credentials of sys, going through the tokenAuthenticator if
+// the registry allows it
+func (i *registryInspector) inspectWithCredentials(ctx context.Context, sys *types.SystemContext,
+	ref types.ImageReference, imageReference string) (inspection Inspection, err error) {
+	if !i.useTokenAuthenticator(sys, ref) {
+		return i.inspect(ctx, sys, ref, imageReference)
+	}
+	registry := reference.Domain(ref.DockerReference())
+	auth, err := config.GetCredentials(sys, registry)
 	if err != nil {
-		klog.Warningf("Error parsing the image reference for the image %s: %v", imageReference, err)
-		return nil, err
+		klog.Warningf("Error getting the credentials for the registry %s: %v", registry, err)
+		return Inspection{}, err
+	}
+	core.DebugLog(ctx, "Requesting a token for the registry %s with %s", registry, credentialIdentity(auth))
+	err = i.tokenAuthenticator.withToken(ctx, registry, reference.Path(ref.DockerReference()), auth,
+		func(token string) error {
+			if token != "" && useRegistrySource(sys, ref) {
+				httpClient, err := i.tokenAuthenticator.httpClientForRegistry(registry)
+				if err != nil {
+					return err
+				}
+				inspection, err = i.inspectSource(ctx, sys, newRegistrySource(ref, httpClient, token), imageReference)
+				return err
+			}
+			sys.DockerBearerRegistryToken = token
+			inspection, err = i.inspect(ctx, sys, ref, imageReference)
+			return err
+		})
+	if err != nil {
+		return Inspection{}, err
 	}
-	sys := &types.SystemContext{
-		AuthFilePath:                authFile.Name(),
-		SystemRegistriesConfPath:    system_config.RegistriesConfPath,
-		SystemRegistriesConfDirPath: system_config.RegistryCertsDir,
-		SignaturePolicyPath:         system_config.PolicyConfPath,
-		DockerPerHostCertDirPath:    system_config.DockerCertsDir,
+	return inspection, nil
+}
+
+// useTokenAuthenticator returns false if the registry of ref is configured with mirrors: the tokens we request are
+// scoped to the source registry and would be rejected by the mirrors. In that case, containers/image goes through
+// the token flow by itself for each of the pull sources.
+func (i *registryInspector) useTokenAuthenticator(sys *types.SystemContext, ref types.ImageReference) bool {
+	registry, err := sysregistriesv2.FindRegistry(sys, ref.DockerReference().Name())
+	if err != nil {
+		klog.Warningf("Error looking up the registries.conf entry for the image %s: %v", ref.DockerReference().Name(), err)
+		return false
 	}
+	return registry == nil || len(registry.Mirrors) == 0
+}
+
+// inspect returns the platforms supported by the image and the digest of the manifest, or of the manifest list, its
+// reference resolved to
+func (i *registryInspector) inspect(ctx context.Context, sys *types.SystemContext, ref types.ImageReference,
+	imageReference string) (inspection Inspection, err error) {
+	host, repository := reference.Domain(ref.DockerReference()), reference.Path(ref.DockerReference())
+	// the image source pings the registry and loads the manifest when it is created
+	start := time.Now()
 	src, err := ref.NewImageSource(ctx, sys)
+	auditRegistryRequest(auditRequestManifest, http.MethodGet, host, repository, statusFromError(err), time.Since(start))
 	if err != nil {
 		klog.Warningf("Error creating the image source: %v", err)
-		return nil, err
+		return Inspection{}, err
 	}
+	return i.inspectSource(ctx, sys, src, imageReference)
+}
+
+// inspectSource returns the platforms supported by the image of the source and the digest of its manifest, or of its
+// manifest list. The source is closed on return.
+func (i *registryInspector) inspectSource(ctx context.Context, sys *types.SystemContext, src types.ImageSource,
+	imageReference string) (inspection Inspection, err error) {
+	named := src.Reference().DockerReference()
+	host, repository := reference.Domain(named), reference.Path(named)
 	defer func(src types.ImageSource) {
 		err := src.Close()
 		if err != nil {
@@ -65,38 +215,163 @@ func (i *registryInspector) GetCompatibleArchitecturesSet(ctx context.Context, i
 	rawManifest, _, err := src.GetManifest(ctx, nil)
 	if err != nil {
 		klog.Infof("Error getting the image manifest: %v", err)
-		return nil, err
+		return Inspection{}, err
+	}
+	// The manifest has already been read by the source, that reads up to DefaultMaxManifestSize bytes: the limit is
+	// checked after the read, and only rejects the manifests larger than a lower limit.
+	maxSize, maxPlatforms := manifestLimits()
+	if int64(len(rawManifest)) > maxSize {
+		klog.Warningf("The manifest of the image %s has %d bytes, more than the limit of %d bytes", imageReference,
+			len(rawManifest), maxSize)
+		return Inspection{}, &ManifestTooLargeError{Max: maxSize}
 	}
-	supportedArchitectures = sets.New[string]()
+	manifestDigest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		klog.Warningf("Error computing the digest of the manifest of the image %s: %v", imageReference, err)
+		return Inspection{}, err
+	}
+	inspection.Digest = manifestDigest.String()
 	if manifest.MIMETypeIsMultiImage(manifest.GuessMIMEType(rawManifest)) {
 		klog.V(5).Infof("image %s is a manifest list... getting the list of supported architectures",
 			imageReference)
 		// The image is a manifest list
-		index, err := manifest.OCI1IndexFromManifest(rawManifest)
+		entries, err := decodeManifestList(rawManifest, maxSize, maxPlatforms)
 		if err != nil {
-			klog.Warningf("Error parsing the OCI index from the raw manifest of the image %s: %v",
-				imageReference, err)
+			klog.Warningf("Error parsing the manifest list of the image %s: %v", imageReference, err)
+			return Inspection{}, err
+		}
+		for _, entry := range entries {
+			if platform, ok := entry.platform(); ok {
+				inspection.Platforms = append(inspection.Platforms, platform)
+			}
 		}
-		for _, m := range index.Manifests {
-			supportedArchitectures = sets.Insert(supportedArchitectures, m.Platform.Architecture)
+		if core.DebugLogEnabled(ctx) {
+			core.DebugLog(ctx, "The manifest list %s of the image %s has the manifests: %s", inspection.Digest,
+				imageReference, describeManifests(entries))
 		}
-		return supportedArchitectures, nil
+		return inspection, nil
 	} else {
 		klog.V(5).Infof("image %s is not a manifest list... getting the supported architecture", imageReference)
 		parsedImage, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
 		if err != nil {
 			klog.Warningf("Error parsing the manifest of the image %s: %v", imageReference, err)
-			return nil, err
+			return Inspection{}, err
 		}
+		start := time.Now()
 		config, err := parsedImage.OCIConfig(ctx)
+		auditRegistryRequest(auditRequestConfig, http.MethodGet, host, repository, statusFromError(err), time.Since(start))
 		if err != nil {
 			// Ignore errors due to invalid images at this stage
 			klog.Warningf("Error parsing the OCI config of the image %s: %v", imageReference, err)
-			return nil, err
+			return Inspection{}, err
 		}
-		supportedArchitectures = sets.Insert(supportedArchitectures, config.Architecture)
+		inspection.Platforms = append(inspection.Platforms, newPlatform(config.OS, config.Architecture, config.Variant))
+		core.DebugLog(ctx, "The image %s is not a manifest list: the config of its manifest %s reports the platform "+
+			"%s/%s", imageReference, inspection.Digest, config.OS, config.Architecture)
+	}
+	return inspection, nil
+}
+
+// systemContext returns the context of the inspections, reading the files of the config of the inspector
+func (i *registryInspector) systemContext() *types.SystemContext {
+	config := i.config
+	if config == nil {
+		config = system_config.DefaultConfigPaths
+	}
+	return &types.SystemContext{
+		SystemRegistriesConfPath:    config.RegistriesConfPath(),
+		SystemRegistriesConfDirPath: config.RegistriesConfDirPath(),
+		SignaturePolicyPath:         config.PolicyConfPath(),
+		DockerPerHostCertDirPath:    config.DockerCertsDirPath(),
+		DockerRegistryUserAgent:     currentUserAgent(),
+	}
+}
+
+// globalAuths returns the credentials used by all the inspections: the ones of the keychain, if any, or else the
+// ones of the global pull secret
+func (i *registryInspector) globalAuths() []byte {
+	if i.keychain != nil {
+		return i.keychain.Auths()
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.globalPullSecret
+}
+
+// debugLogPullSources traces the pull sources of the image, i.e., its mirrors and the registry itself, in the order
+// containers/image tries them
+func debugLogPullSources(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) {
+	locations, err := pullSources(sys, ref.DockerReference())
+	if err != nil {
+		core.DebugLog(ctx, "Unable to get the pull sources of the image %s: %v", ref.DockerReference(), err)
+		return
+	}
+	if len(locations) == 1 && locations[0] == ref.DockerReference().String() {
+		core.DebugLog(ctx, "The image %s has no mirrors configured", ref.DockerReference())
+		return
+	}
+	core.DebugLog(ctx, "Pull sources of the image %s, in the order they are tried: %v", ref.DockerReference(), locations)
+}
+
+// PullSources returns the pull sources of the image, i.e., its mirrors and the registry itself, in the order
+// containers/image tries them, as configured by the registries.conf files of the config. The image reference is
+// normalized as the inspections do.
+func PullSources(config system_config.IConfigReader, imageReference string) ([]string, error) {
+	normalized, err := NormalizeReference(imageReference)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := docker.ParseReference(normalized)
+	if err != nil {
+		return nil, &InvalidReferenceError{Reference: imageReference, Err: err}
+	}
+	return pullSources((&registryInspector{config: config}).systemContext(), ref.DockerReference())
+}
+
+// pullSources returns the locations of the pull sources of the named image, evaluating the registries.conf files of the
+// system context with the eval package. The image itself is its only pull source when its registry is not configured.
+func pullSources(sys *types.SystemContext, named reference.Named) ([]string, error) {
+	config, err := eval.Load(sys.SystemRegistriesConfPath, sys.SystemRegistriesConfDirPath)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := config.ResolveEndpoints(named.String())
+	if err != nil {
+		return nil, err
+	}
+	locations := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		locations = append(locations, endpoint.Reference)
+	}
+	return locations, nil
+}
+
+// describeManifests returns the digests and the platforms of the manifests of the manifest list
+func describeManifests(entries []manifestListEntry) string {
+	descriptions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		platform := "unknown platform"
+		if entry.Platform != nil {
+			platform = entry.Platform.OS + "/" + entry.Platform.Architecture
+			if entry.Platform.Variant != "" {
+				platform += "/" + entry.Platform.Variant
+			}
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", entry.Digest, platform))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// credentialIdentity describes the credentials without their secrets
+func credentialIdentity(auth types.DockerAuthConfig) string {
+	switch {
+	case auth.IdentityToken != "":
+		return "an identity token"
+	case auth.Username != "":
+		return fmt.Sprintf("the user %q", auth.Username)
+	default:
+		return "no credentials"
 	}
-	return supportedArchitectures, nil
 }
 
 func (i *registryInspector) createAuthFile(secrets ...[]byte) (*os.File, error) {
@@ -153,23 +428,84 @@ func writeMemFile(name string, b []byte) (int, error) {
 func (i *registryInspector) storeGlobalPullSecret(pullSecret []byte) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
+	// the periodic resyncs of the watcher store the same pull secret again
+	if bytes.Equal(i.globalPullSecret, pullSecret) {
+		return
+	}
 	i.globalPullSecret = pullSecret
+	if i.pullSecretObserver != nil {
+		i.pullSecretObserver.OnPullSecretChange()
+	}
+}
+
+func (i *registryInspector) setPullSecretObserver(observer PullSecretObserver) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.pullSecretObserver = observer
 }
 
+// newStandaloneRegistryInspector returns a registryInspector reading the registries configuration from the config and
+// the global credentials from the keychain, if any. Unlike newRegistryInspector, it does not watch the global pull
+// secret.
+func newStandaloneRegistryInspector(config system_config.IConfigReader, keychain Keychain) *registryInspector {
+	if config == nil {
+		config = system_config.DefaultConfigPaths
+	}
+	return &registryInspector{
+		config:             config,
+		keychain:           keychain,
+		tokenAuthenticator: newTokenAuthenticator(config.DockerCertsDirPath()),
+		tlsPolicyChecker:   newTLSPolicyChecker(),
+	}
+}
+
+var (
+	// pullSecretResyncPeriod is the period of the resyncs of the watcher of the global pull secret, spread by
+	// pullSecretResyncJitter
+	pullSecretResyncPeriod = core.DefaultResyncPeriod
+	pullSecretResyncJitter = core.DefaultResyncJitter
+	// pullSecretResyncMutex is used to protect pullSecretResyncPeriod and pullSecretResyncJitter from concurrent access
+	pullSecretResyncMutex sync.RWMutex
+)
+
+// SetPullSecretResyncPeriod sets the period of the resyncs of the watcher of the global pull secret and the fraction
+// of it the period is randomly spread by, see core.JitteredPeriod. A zero period disables the resyncs.
+// It is expected to be called once, before the first inspection.
+func SetPullSecretResyncPeriod(period time.Duration, jitter float64) {
+	pullSecretResyncMutex.Lock()
+	defer pullSecretResyncMutex.Unlock()
+	pullSecretResyncPeriod = period
+	pullSecretResyncJitter = jitter
+}
+
+// jitteredPullSecretResyncPeriod returns the period of the resyncs of the watcher of the global pull secret, spread by
+// its jitter
+func jitteredPullSecretResyncPeriod() time.Duration {
+	pullSecretResyncMutex.RLock()
+	defer pullSecretResyncMutex.RUnlock()
+	return core.JitteredPeriod(pullSecretResyncPeriod, pullSecretResyncJitter)
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// newRegistryInspector returns the registry inspector, watching the global pull secret of the cluster
 func newRegistryInspector() iRegistryInspector {
-	ri := &registryInspector{}
+	ri := newStandaloneRegistryInspector(system_config.DefaultConfigPaths, nil)
+	period := jitteredPullSecretResyncPeriod()
+	klog.V(2).Infof("Resync period of the global pull secret watcher: %s", period)
 	err := core.NewSingleObjectEventHandler[*v1.Secret, *v1.SecretList](context.Background(),
-		"pull-secret", "openshift-config", time.Hour, func(et watch.EventType, s *v1.Secret) {
+		"pull-secret", "openshift-config", period, func(et watch.EventType, s *v1.Secret) error {
 			if et == watch.Deleted || et == watch.Bookmark {
 				klog.Warningf("Ignoring event type: %+v", et)
-				return
+				return nil
 			}
 			klog.Warningln("global pull secret update")
-			if pullSecret, err := ExtractAuthFromSecret(s); err == nil {
-				ri.storeGlobalPullSecret(pullSecret)
-			} else {
-				klog.Warningf("Error extracting the auth from the secret: %v", err)
+			pullSecret, err := ExtractAuthFromSecret(s)
+			if err != nil {
+				return fmt.Errorf("error extracting the auth from the secret: %w", err)
 			}
+			ri.storeGlobalPullSecret(pullSecret)
+			return nil
 		}, nil)
 	if err != nil {
 		// This is a fatal error because we cannot continue without the global pull secret controller running.
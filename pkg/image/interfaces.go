@@ -11,10 +11,26 @@ type ICache interface {
 	GetCompatibleArchitecturesSet(ctx context.Context, imageReference string, secrets [][]byte) (sets.Set[string], error)
 }
 
+type ICachedArchitectures interface {
+	// CachedCompatibleArchitecturesSet returns the cached architectures compatible with the image reference, if they
+	// did not expire. It never inspects the image: ok is false when the architectures are not cached.
+	CachedCompatibleArchitecturesSet(imageReference string) (architectures sets.Set[string], ok bool)
+}
+
 type iRegistryInspector interface {
-	ICache
+	// GetInspection inspects the platforms supported by the image and its digest
+	GetInspection(ctx context.Context, imageReference string, secrets [][]byte) (Inspection, error)
 	// StoreGlobalPullSecret takes a pull secret and stores it in the ImageFacade. It will be used by the controller
 	// in charge of watching the global pull secret and to store it in the ImageFacade's relevant private field.
 	// Then, the ImageFacade will be responsible for consuming it during the inspection.
 	storeGlobalPullSecret(pullSecret []byte)
+	// setPullSecretObserver registers the observer notified every time storeGlobalPullSecret changes the global pull
+	// secret.
+	setPullSecretObserver(observer PullSecretObserver)
+}
+
+// PullSecretObserver is notified when the global pull secret changes, after the inspections started using it.
+// Implementations must not block, as they are called by the watcher of the global pull secret.
+type PullSecretObserver interface {
+	OnPullSecretChange()
 }
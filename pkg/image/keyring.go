@@ -0,0 +1,210 @@
+package image
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// defaultRegistryHost is the key the legacy https://index.docker.io/v1/ entries of the docker config files are stored
+// with. Its credentials are used for the Docker Hub images when no other key matches.
+const defaultRegistryHost = "index.docker.io"
+
+var (
+	kubeletCompatibleCredentials bool
+	// kubeletCompatibleCredentialsMutex is used to protect kubeletCompatibleCredentials from concurrent access
+	kubeletCompatibleCredentialsMutex sync.RWMutex
+)
+
+// SetKubeletCompatibleCredentials enables or disables the resolution of the credentials with the kubelet semantics.
+// When enabled, the credentials of the pull secrets matching the image are tried in the order the kubelet tries them,
+// the pod's ones first and the global pull secret's ones last, until the inspection succeeds. When disabled, the pull
+// secrets are merged in a single auth file and containers/image picks one credential per registry.
+// It is expected to be called once, before the inspections start.
+func SetKubeletCompatibleCredentials(enabled bool) {
+	kubeletCompatibleCredentialsMutex.Lock()
+	defer kubeletCompatibleCredentialsMutex.Unlock()
+	kubeletCompatibleCredentials = enabled
+}
+
+func useKubeletCompatibleCredentials() bool {
+	kubeletCompatibleCredentialsMutex.RLock()
+	defer kubeletCompatibleCredentialsMutex.RUnlock()
+	return kubeletCompatibleCredentials
+}
+
+// dockerConfigEntry is an entry of the auths of a docker config file
+type dockerConfigEntry struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// credentials returns the credentials of the entry. The auth field takes precedence over the username and password
+// ones.
+func (e dockerConfigEntry) credentials() (types.DockerAuthConfig, error) {
+	if e.Auth == "" {
+		return types.DockerAuthConfig{Username: e.Username, Password: e.Password}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return types.DockerAuthConfig{}, err
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return types.DockerAuthConfig{}, fmt.Errorf("the auth field must be in the <username>:<password> format")
+	}
+	return types.DockerAuthConfig{Username: username, Password: password}, nil
+}
+
+// dockerKeyring resolves the credentials of an image as the BasicDockerKeyring of the kubelet credentialprovider
+// package does:
+//   - the keys of the docker config files are URLs whose scheme and /v1/ or /v2/ path prefix are ignored;
+//   - all the keys matching the image are used, the most specific ones first, and the credentials of the same key in
+//     the order they were added;
+//   - a key matches the image if they have the same port and number of host components, the host components of the
+//     key, possibly globs like *.example.com, match the image's ones, and the path of the key is a prefix of the
+//     image's one;
+//   - if no key matches a Docker Hub image, the credentials of the legacy index.docker.io key are used.
+type dockerKeyring struct {
+	// index are the keys in reverse lexicographic order, so that the more specific keys come first
+	index []string
+	creds map[string][]types.DockerAuthConfig
+}
+
+func newDockerKeyring() *dockerKeyring {
+	return &dockerKeyring{
+		creds: map[string][]types.DockerAuthConfig{},
+	}
+}
+
+// add stores the credentials of the auths of a docker config file, in the authsBytes format of
+// authCfg.unmarshallAuthsDataAndStore. The auths are rejected as a whole if any of the entries is invalid, as the
+// kubelet rejects the pull secret.
+func (k *dockerKeyring) add(authsBytes []byte) error {
+	var auths map[string]dockerConfigEntry
+	if err := json.Unmarshal(authsBytes, &auths); err != nil {
+		return err
+	}
+	credentials := make(map[string]types.DockerAuthConfig, len(auths))
+	for location, entry := range auths {
+		auth, err := entry.credentials()
+		if err != nil {
+			return fmt.Errorf("invalid credentials for %s: %w", location, err)
+		}
+		credentials[location] = auth
+	}
+	// the locations are sorted to add the credentials of the locations mapped to the same key in a stable order
+	locations := make([]string, 0, len(credentials))
+	for location := range credentials {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	for _, location := range locations {
+		key, err := keyringKey(location)
+		if err != nil {
+			continue
+		}
+		k.creds[key] = append(k.creds[key], credentials[location])
+	}
+	k.index = sets.List(sets.KeySet(k.creds))
+	sort.Sort(sort.Reverse(sort.StringSlice(k.index)))
+	return nil
+}
+
+// keyringKey returns the key of a location of a docker config file, e.g., https://index.docker.io/v1/ is stored as
+// index.docker.io and quay.io/org as is.
+func keyringKey(location string) (string, error) {
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		location = "https://" + location
+	}
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	// The docker client considers /v1/ and /v2/ equivalent to the hostname
+	path := parsed.Path
+	if strings.HasPrefix(path, "/v1/") || strings.HasPrefix(path, "/v2/") {
+		path = path[3:]
+	}
+	if path == "" || path == "/" {
+		return parsed.Host, nil
+	}
+	return parsed.Host + path, nil
+}
+
+// lookup returns the credentials for the repository of the image, e.g., docker.io/library/nginx, in the order they
+// have to be tried
+func (k *dockerKeyring) lookup(repository string) []types.DockerAuthConfig {
+	var result []types.DockerAuthConfig
+	for _, key := range k.index {
+		if urlsMatch(key, repository) {
+			result = append(result, k.creds[key]...)
+		}
+	}
+	if len(result) == 0 && isDefaultRegistryMatch(repository) {
+		result = append(result, k.creds[defaultRegistryHost]...)
+	}
+	return result
+}
+
+// isDefaultRegistryMatch returns true if the repository is pulled from Docker Hub
+func isDefaultRegistryMatch(repository string) bool {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts[0]) == 0 {
+		return false
+	}
+	if len(parts) == 1 {
+		// e.g., ubuntu
+		return true
+	}
+	if parts[0] == "docker.io" || parts[0] == defaultRegistryHost {
+		return true
+	}
+	return !strings.ContainsAny(parts[0], ".:")
+}
+
+// urlsMatch returns true if the schemeless URL target matches the schemeless URL glob
+func urlsMatch(glob, target string) bool {
+	globURL, err := url.Parse("https://" + glob)
+	if err != nil {
+		return false
+	}
+	targetURL, err := url.Parse("https://" + target)
+	if err != nil {
+		return false
+	}
+	globHostParts, globPort := splitHost(globURL.Host)
+	targetHostParts, targetPort := splitHost(targetURL.Host)
+	if globPort != targetPort || len(globHostParts) != len(targetHostParts) {
+		return false
+	}
+	if !strings.HasPrefix(targetURL.Path, globURL.Path) {
+		return false
+	}
+	for i, globHostPart := range globHostParts {
+		if matched, err := filepath.Match(globHostPart, targetHostParts[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// splitHost returns the components of the host name and the port, if any
+func splitHost(hostPort string) ([]string, string) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		// no port
+		host, port = hostPort, ""
+	}
+	return strings.Split(host, "."), port
+}
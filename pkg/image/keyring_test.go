@@ -0,0 +1,194 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// dockerConfigAuths returns the auths of a docker config file storing the credentials username:password for each of
+// the locations
+func dockerConfigAuths(username, password string, locations ...string) []byte {
+	auths := "{"
+	for i, location := range locations {
+		if i > 0 {
+			auths += ","
+		}
+		auths += fmt.Sprintf(`%q:{"auth":%q}`, location,
+			base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+	return []byte(auths + "}")
+}
+
+func credentials(username string) types.DockerAuthConfig {
+	return types.DockerAuthConfig{Username: username, Password: "password"}
+}
+
+// The cases mirror the ones of the tests of the kubelet credentialprovider package
+var _ = Describe("The kubelet compatible keyring", func() {
+	DescribeTable("should match the URLs",
+		func(glob, target string, expected bool) {
+			Expect(urlsMatch(glob, target)).To(Equal(expected))
+		},
+		Entry(nil, "*.kubernetes.io", "prefix.kubernetes.io", true),
+		Entry(nil, "prefix.*.io", "prefix.kubernetes.io", true),
+		Entry(nil, "prefix.kubernetes.*", "prefix.kubernetes.io", true),
+		Entry(nil, "*-good.kubernetes.io", "prefix-good.kubernetes.io", true),
+		Entry(nil, "prefix.kubernetes.io", "prefix.kubernetes.io/kubernetes/foo", true),
+		Entry(nil, "prefix.kubernetes.io/kubernetes", "prefix.kubernetes.io/kubernetes/foo", true),
+		Entry(nil, "prefix.kubernetes.io:8080", "prefix.kubernetes.io:8080/foo", true),
+		Entry(nil, "*.kubernetes.io:8080", "prefix.kubernetes.io:8080", true),
+		Entry(nil, "*.kubernetes.io", "kubernetes.io", false),
+		Entry(nil, "*.*.kubernetes.io", "prefix.kubernetes.io", false),
+		Entry(nil, "prefix.kubernetes.io", "prefix.kubernetes.io:8080", false),
+		Entry(nil, "prefix.kubernetes.io:8080", "prefix.kubernetes.io", false),
+		Entry(nil, "prefix.kubernetes.io:8080", "prefix.kubernetes.io:8081", false),
+		Entry(nil, "prefix.kubernetes.io/foo", "prefix.kubernetes.io/bar", false),
+		Entry(nil, "*-bad.kubernetes.io", "prefix-good.kubernetes.io", false),
+	)
+
+	DescribeTable("should tell the Docker Hub repositories",
+		func(repository string, expected bool) {
+			Expect(isDefaultRegistryMatch(repository)).To(Equal(expected))
+		},
+		Entry(nil, "foo/bar", true),
+		Entry(nil, "docker.io/foo/bar", true),
+		Entry(nil, "index.docker.io/foo/bar", true),
+		Entry(nil, "foo", true),
+		Entry(nil, "", false),
+		Entry(nil, "registry.tld/foo/bar", false),
+		Entry(nil, "localhost:5000/foo/bar", false),
+		Entry(nil, "registry.tld:5000/foo/bar", false),
+	)
+
+	DescribeTable("should store the locations with the docker client semantics",
+		func(location, expected string) {
+			key, err := keyringKey(location)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(expected))
+		},
+		Entry(nil, "https://index.docker.io/v1/", "index.docker.io"),
+		Entry(nil, "http://quay.io", "quay.io"),
+		Entry(nil, "quay.io/v2/org", "quay.io/org"),
+		Entry(nil, "registry.example.com:5000/org/app", "registry.example.com:5000/org/app"),
+	)
+
+	It("should return the credentials of the most specific keys first", func() {
+		keyring := newDockerKeyring()
+		Expect(keyring.add(dockerConfigAuths("ada", "password", "bar.example.com/pong"))).To(Succeed())
+		Expect(keyring.add(dockerConfigAuths("grace", "password", "bar.example.com"))).To(Succeed())
+		Expect(keyring.add(dockerConfigAuths("alan", "password", "https://bar.example.com/kubernetes"))).To(Succeed())
+		Expect(keyring.add(dockerConfigAuths("edsger", "password", "http://bar.example.com"))).To(Succeed())
+		grace, edsger, ada, alan := credentials("grace"), credentials("edsger"), credentials("ada"), credentials("alan")
+		for repository, expected := range map[string][]types.DockerAuthConfig{
+			"bar.example.com/pong":             {ada, grace, edsger},
+			"bar.example.com/kubernetes/redis": {alan, grace, edsger},
+			"bar.example.com/ponger":           {ada, grace, edsger},
+			"bar.example.com":                  {grace, edsger},
+			"foo.example.com/pong":             nil,
+		} {
+			Expect(keyring.lookup(repository)).To(Equal(expected), repository)
+		}
+	})
+
+	It("should match the globs", func() {
+		keyring := newDockerKeyring()
+		Expect(keyring.add(dockerConfigAuths("grace", "password", "https://*.example.com"))).To(Succeed())
+		Expect(keyring.lookup("foo.example.com/foo/bar")).To(Equal([]types.DockerAuthConfig{credentials("grace")}))
+		Expect(keyring.lookup("example.com/foo/bar")).To(BeEmpty())
+	})
+
+	DescribeTable("should use the legacy Docker Hub credentials for the Docker Hub repositories only",
+		func(repository string, hit bool) {
+			keyring := newDockerKeyring()
+			Expect(keyring.add(dockerConfigAuths("grace", "password", "https://index.docker.io/v1/"))).To(Succeed())
+			if hit {
+				Expect(keyring.lookup(repository)).To(Equal([]types.DockerAuthConfig{credentials("grace")}))
+			} else {
+				Expect(keyring.lookup(repository)).To(BeEmpty())
+			}
+		},
+		Entry("unqualified repository", "google/docker-registry", true),
+		Entry("unqualified library repository", "jenkins", true),
+		Entry("normalized repository", "docker.io/library/jenkins", true),
+		Entry("other registry", "world.mesos.org/foo/bar", false),
+	)
+
+	It("should prefer the docker.io key over the legacy one", func() {
+		keyring := newDockerKeyring()
+		Expect(keyring.add(dockerConfigAuths("grace", "password", "https://index.docker.io/v1/"))).To(Succeed())
+		Expect(keyring.add(dockerConfigAuths("ada", "password", "docker.io"))).To(Succeed())
+		Expect(keyring.lookup("docker.io/library/nginx")).To(Equal([]types.DockerAuthConfig{credentials("ada")}))
+	})
+
+	It("should read the username and password fields", func() {
+		keyring := newDockerKeyring()
+		Expect(keyring.add([]byte(`{"quay.io":{"username":"grace","password":"password"}}`))).To(Succeed())
+		Expect(keyring.lookup("quay.io/org/app")).To(Equal([]types.DockerAuthConfig{credentials("grace")}))
+	})
+
+	It("should reject the pull secrets with invalid entries", func() {
+		keyring := newDockerKeyring()
+		Expect(keyring.add([]byte(`{"quay.io":{"auth":"Z3JhY2U6cGFzc3dvcmQ="},"gcr.io":{"auth":"not base64"}}`))).NotTo(Succeed())
+		Expect(keyring.lookup("quay.io/org/app")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("The inspection with the kubelet compatible credentials", func() {
+	var (
+		ctx            context.Context
+		fts            *fakeTokenServer
+		imageReference string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fts = newFakeTokenServer(true)
+		DeferCleanup(fts.Close)
+		imageReference = fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+		SetKubeletCompatibleCredentials(true)
+		DeferCleanup(SetKubeletCompatibleCredentials, false)
+	})
+
+	It("should use the credentials of the most specific key", func() {
+		architectures, err := fts.inspector().GetCompatibleArchitecturesSet(ctx, imageReference, [][]byte{
+			dockerConfigAuths(testUsername, "wrong", fts.registry()),
+			dockerConfigAuths(testUsername, testPassword, fts.registry()+"/"+testRepository),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should fall back to the global pull secret when the pod's credentials fail", func() {
+		i := fts.inspector()
+		i.storeGlobalPullSecret(dockerConfigAuths(testUsername, testPassword, fts.registry()))
+		secrets := [][]byte{dockerConfigAuths(testUsername, "wrong", "https://"+fts.registry())}
+		_, err := i.GetCompatibleArchitecturesSet(ctx, imageReference, secrets)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the merged auth file only keeps the pod's credentials
+		SetKubeletCompatibleCredentials(false)
+		_, err = i.GetCompatibleArchitecturesSet(ctx, imageReference, [][]byte{
+			dockerConfigAuths(testUsername, "wrong", fts.registry())})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should report the errors of all the credentials", func() {
+		_, err := fts.inspector().GetCompatibleArchitecturesSet(ctx, imageReference, [][]byte{
+			dockerConfigAuths(testUsername, "wrong", fts.registry()),
+			dockerConfigAuths(testUsername, "other", fts.registry()),
+		})
+		var aggregate utilerrors.Aggregate
+		Expect(errors.As(err, &aggregate)).To(BeTrue())
+		Expect(aggregate.Errors()).To(HaveLen(2))
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(0))
+	})
+})
@@ -0,0 +1,152 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultMaxManifestSize is the default size, in bytes, of the largest manifest or manifest list inspected. It is
+	// the limit containers/image enforces on the manifests it reads: a larger size has no effect.
+	DefaultMaxManifestSize int64 = 4 << 20
+	// DefaultMaxManifestPlatforms is the default number of the entries of the largest manifest list inspected, e.g.,
+	// the manifests of its platforms and of their attestations
+	DefaultMaxManifestPlatforms = 128
+	// maxTokenResponseSize is the size, in bytes, of the largest answer of a token server read
+	maxTokenResponseSize int64 = 1 << 20
+)
+
+var (
+	maxManifestSize      = DefaultMaxManifestSize
+	maxManifestPlatforms = DefaultMaxManifestPlatforms
+	// manifestLimitsMutex is used to protect maxManifestSize and maxManifestPlatforms from concurrent access
+	manifestLimitsMutex sync.RWMutex
+)
+
+// SetManifestLimits sets the size, in bytes, of the largest manifest inspected and the number of the entries of the
+// largest manifest list inspected. The non-positive values set the defaults. It is expected to be called once, before the
+// inspections start.
+func SetManifestLimits(maxSize int64, maxPlatforms int) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxManifestSize
+	}
+	if maxPlatforms <= 0 {
+		maxPlatforms = DefaultMaxManifestPlatforms
+	}
+	manifestLimitsMutex.Lock()
+	defer manifestLimitsMutex.Unlock()
+	maxManifestSize, maxManifestPlatforms = maxSize, maxPlatforms
+}
+
+func manifestLimits() (maxSize int64, maxPlatforms int) {
+	manifestLimitsMutex.RLock()
+	defer manifestLimitsMutex.RUnlock()
+	return maxManifestSize, maxManifestPlatforms
+}
+
+// ManifestTooLargeError is returned when a manifest is larger than the limit, or a manifest list has more entries than
+// the limit. Retrying the inspection cannot succeed.
+type ManifestTooLargeError struct {
+	// Max is the limit, in bytes. It is zero when the limit of the entries is exceeded.
+	Max int64
+	// MaxEntries is the limit of the entries of the manifest lists, when it is exceeded
+	MaxEntries int
+}
+
+func (e *ManifestTooLargeError) Error() string {
+	if e.MaxEntries > 0 {
+		return fmt.Sprintf("the manifest list has more manifests than the limit of %d", e.MaxEntries)
+	}
+	return fmt.Sprintf("the manifest is larger than the limit of %d bytes", e.Max)
+}
+
+// manifestListEntry is an entry of the manifests of an OCI index or of a Docker manifest list
+type manifestListEntry struct {
+	Digest   string `json:"digest"`
+	Platform *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// platform returns the platform of the entry and true, or false if the entry has none, e.g., an attestation manifest
+func (e manifestListEntry) platform() (Platform, bool) {
+	if e.Platform == nil {
+		return Platform{}, false
+	}
+	return newPlatform(e.Platform.OS, e.Platform.Architecture, e.Platform.Variant), true
+}
+
+// decodeManifestList decodes the entries of the manifests of the raw OCI index or Docker manifest list, one at a time,
+// rather than the whole document. The manifest lists larger than maxSize bytes or with more than maxEntries entries are
+// rejected with a ManifestTooLargeError: the architectures of the skipped entries would be missing from the node
+// affinity.
+func decodeManifestList(raw []byte, maxSize int64, maxEntries int) ([]manifestListEntry, error) {
+	if int64(len(raw)) > maxSize {
+		return nil, &ManifestTooLargeError{Max: maxSize}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+	var entries []manifestListEntry
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "manifests" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if entries, err = decodeManifestListEntries(decoder, maxEntries); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// decodeManifestListEntries decodes the array of the manifests of a manifest list, failing with a
+// ManifestTooLargeError once it has more than maxEntries entries
+func decodeManifestListEntries(decoder *json.Decoder, maxEntries int) ([]manifestListEntry, error) {
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token == nil {
+		return nil, nil
+	} else if token != json.Delim('[') {
+		return nil, fmt.Errorf("invalid manifest list: the manifests are not an array but %v", token)
+	}
+	var entries []manifestListEntry
+	for decoder.More() {
+		if len(entries) >= maxEntries {
+			return nil, &ManifestTooLargeError{MaxEntries: maxEntries}
+		}
+		var entry manifestListEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, expectDelim(decoder, ']')
+}
+
+// expectDelim reads the next token of the decoder, failing if it is not the delimiter
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("invalid manifest list: expected %v, found %v", delim, token)
+	}
+	return nil
+}
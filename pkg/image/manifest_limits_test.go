@@ -0,0 +1,118 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// manifestList returns an OCI index with a manifest per platform, followed by an attestation manifest for each
+func manifestList(platforms ...string) []byte {
+	var manifests []string
+	for n, platform := range platforms {
+		parts := strings.SplitN(platform, "/", 3)
+		variant := ""
+		if len(parts) == 3 {
+			variant = fmt.Sprintf(`,"variant":%q`, parts[2])
+		}
+		manifests = append(manifests, fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+			`"digest":"sha256:%064d","size":1,"platform":{"os":%q,"architecture":%q%s}}`, n, parts[0], parts[1],
+			variant))
+	}
+	for n := range platforms {
+		manifests = append(manifests, fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+			`"digest":"sha256:%064d","size":1,"annotations":{"vnd.docker.reference.type":"attestation-manifest"}}`,
+			len(platforms)+n))
+	}
+	return []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` +
+		strings.Join(manifests, ",") + `],"annotations":{"org.opencontainers.image.created":"2023-06-01"}}`)
+}
+
+// entriesPlatforms returns the platforms of the entries of a manifest list
+func entriesPlatforms(entries []manifestListEntry) []Platform {
+	var platforms []Platform
+	for _, entry := range entries {
+		if platform, ok := entry.platform(); ok {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+var _ = Describe("The manifest lists", func() {
+	It("should be decoded with the platforms of their manifests", func() {
+		entries, err := decodeManifestList(manifestList("linux/amd64", "linux/arm/v7", "linux/aarch64"),
+			DefaultMaxManifestSize, DefaultMaxManifestPlatforms)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(6))
+		Expect(entries[0].Digest).To(Equal(fmt.Sprintf("sha256:%064d", 0)))
+		Expect(entriesPlatforms(entries)).To(Equal([]Platform{
+			{OS: "linux", Architecture: "amd64"},
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+			{OS: "linux", Architecture: "arm64"},
+		}))
+	})
+
+	It("should reject the manifest lists with more entries than the limit", func() {
+		platforms := make([]string, 0, 20)
+		for n := 0; n < 20; n++ {
+			platforms = append(platforms, fmt.Sprintf("linux/arch%d", n))
+		}
+		_, err := decodeManifestList(manifestList(platforms...), DefaultMaxManifestSize, 16)
+		Expect(err).To(MatchError(&ManifestTooLargeError{MaxEntries: 16}))
+		Expect(ClassifyError(err)).To(Equal(ErrorClassManifestTooLarge))
+		// the manifest list of 20 platforms has 40 entries, with the attestation manifests
+		entries, err := decodeManifestList(manifestList(platforms...), DefaultMaxManifestSize, 40)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entriesPlatforms(entries)).To(HaveLen(20))
+	})
+
+	It("should reject the manifest lists larger than the limit", func() {
+		raw := manifestList("linux/amd64", "linux/arm64")
+		_, err := decodeManifestList(raw, int64(len(raw)-1), DefaultMaxManifestPlatforms)
+		Expect(err).To(MatchError(&ManifestTooLargeError{Max: int64(len(raw) - 1)}))
+	})
+
+	DescribeTable("should fail on the invalid manifest lists",
+		func(raw string) {
+			_, err := decodeManifestList([]byte(raw), DefaultMaxManifestSize, DefaultMaxManifestPlatforms)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("not an object", `[]`),
+		Entry("truncated", `{"manifests":[{"digest":"sha256:00"}`),
+		Entry("manifests not an array", `{"manifests":{}}`),
+		Entry("entry not an object", `{"manifests":[1]}`),
+		Entry("deeply nested", `{"annotations":`+strings.Repeat("[", 100000)+strings.Repeat("]", 100000)+`}`),
+	)
+
+	It("should have no entries without manifests", func() {
+		entries, err := decodeManifestList([]byte(`{"schemaVersion":2,"manifests":null}`), DefaultMaxManifestSize,
+			DefaultMaxManifestPlatforms)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
+
+// FuzzDecodeManifestList feeds arbitrary, oversized and deeply nested documents to the decoder of the manifest lists,
+// which must fail gracefully and never return more entries than the limit
+func FuzzDecodeManifestList(f *testing.F) {
+	f.Add(manifestList("linux/amd64", "linux/arm64", "linux/s390x"), int64(1024))
+	f.Add([]byte(`{"manifests":[`+strings.Repeat(`{"platform":{"os":"linux"}},`, 1000)+`{}]}`), int64(1<<20))
+	f.Add([]byte(`{"manifests":[`+strings.Repeat(`{"a":`, 20000)+strings.Repeat(`}`, 20000)+`]}`), int64(1<<20))
+	f.Add([]byte(`{"manifests":`+strings.Repeat("[", 20000)), int64(1<<20))
+	f.Fuzz(func(t *testing.T, raw []byte, maxSize int64) {
+		entries, err := decodeManifestList(raw, maxSize, 8)
+		if err != nil {
+			return
+		}
+		if int64(len(raw)) > maxSize {
+			t.Fatalf("a manifest list of %d bytes was decoded with a limit of %d bytes", len(raw), maxSize)
+		}
+		if len(entries) > 8 {
+			t.Fatalf("%d entries were returned with a limit of 8", len(entries))
+		}
+	})
+}
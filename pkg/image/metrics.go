@@ -0,0 +1,116 @@
+package image
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"multiarch-operator/pkg/version"
+)
+
+const (
+	metricsNamespace = "multiarch"
+	// DefaultInspectionMetricsMaxRegistries is the default number of registry hosts with their own label value in the
+	// inspection metrics
+	DefaultInspectionMetricsMaxRegistries = 20
+	// otherRegistriesLabel is the registry label value of the hosts beyond the maximum number of registries
+	otherRegistriesLabel = "other"
+	inspectionSuccess    = "success"
+	inspectionError      = "error"
+)
+
+var (
+	inspectionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_inspection_duration_seconds",
+		Help: "The time taken to get the architectures supported by an image, including the authentication and the " +
+			"fetch and parsing of the manifests on cache misses",
+		Buckets: []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"registry", "cache_hit", "result"})
+	inspectionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_inspection_failures_total",
+		Help: "The number of failed image inspections by kind: not_found and unauthorized, that are cached, and " +
+			"transient, that are not",
+	}, []string{"kind"})
+	inspectionErrorClasses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_inspection_errors_total",
+		Help: "The number of failed image inspections by class of error, e.g., dns, server_error or not_found, and " +
+			"whether retrying them later can succeed",
+	}, []string{"class", "retryable"})
+	inspectionFailureCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_inspection_failure_cache_hits_total",
+		Help:      "The number of lookups answered with a cached failed inspection, by kind of failure",
+	}, []string{"kind"})
+	sharedInspections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_inspection_shared_total",
+		Help: "The number of lookups answered by the inspection of the same image with the same credentials that " +
+			"another lookup had in flight",
+	})
+
+	inspectionRegistries = newRegistryLabels(DefaultInspectionMetricsMaxRegistries)
+)
+
+func init() {
+	version.MetricsRegisterer().MustRegister(inspectionDuration, inspectionFailures, inspectionErrorClasses,
+		inspectionFailureCacheHits, sharedInspections)
+}
+
+// SetInspectionMetricsMaxRegistries sets the number of registry hosts that get their own label value in the inspection
+// metrics. The hosts are assigned a label value in the order they are first inspected; the others are reported as
+// "other". It is expected to be called once, before the inspections start.
+func SetInspectionMetricsMaxRegistries(max int) {
+	inspectionRegistries = newRegistryLabels(max)
+}
+
+// registryLabels bounds the cardinality of the registry label of the metrics
+type registryLabels struct {
+	max   int
+	hosts sets.Set[string]
+	// mutex is used to protect the hosts set from concurrent access
+	mutex sync.Mutex
+}
+
+func newRegistryLabels(max int) *registryLabels {
+	return &registryLabels{
+		max:   max,
+		hosts: sets.New[string](),
+	}
+}
+
+// labelFor returns the registry label value for the image reference: the normalized registry host, if it is known or
+// there is room for one more, "other" otherwise
+func (l *registryLabels) labelFor(imageReference string) string {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(imageReference, "//"))
+	if err != nil {
+		return otherRegistriesLabel
+	}
+	host := strings.ToLower(reference.Domain(named))
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.hosts.Has(host) {
+		return host
+	}
+	if l.hosts.Len() >= l.max {
+		return otherRegistriesLabel
+	}
+	l.hosts.Insert(host)
+	return host
+}
+
+// observeInspection records the duration of a lookup of the architectures supported by the image reference
+func observeInspection(imageReference string, cacheHit bool, err error, duration time.Duration) {
+	result := inspectionSuccess
+	if err != nil {
+		result = inspectionError
+	}
+	inspectionDuration.WithLabelValues(inspectionRegistries.labelFor(imageReference),
+		strconv.FormatBool(cacheHit), result).Observe(duration.Seconds())
+}
@@ -0,0 +1,97 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// steppingInspector is an iRegistryInspector advancing the fake clock by latency at each inspection
+type steppingInspector struct {
+	clock   *clocktesting.FakeClock
+	latency time.Duration
+	err     error
+}
+
+func (i *steppingInspector) GetInspection(context.Context, string, [][]byte) (Inspection, error) {
+	i.clock.Step(i.latency)
+	if i.err != nil {
+		return Inspection{}, i.err
+	}
+	return Inspection{Platforms: []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}}, nil
+}
+
+func (i *steppingInspector) storeGlobalPullSecret([]byte) {}
+
+func (i *steppingInspector) setPullSecretObserver(PullSecretObserver) {}
+
+func inspectionDurationHistogram(registry, cacheHit, result string) *dto.Histogram {
+	metric := &dto.Metric{}
+	Expect(inspectionDuration.WithLabelValues(registry, cacheHit, result).(prometheus.Metric).Write(metric)).To(Succeed())
+	return metric.GetHistogram()
+}
+
+var _ = Describe("The image inspection metrics", func() {
+	var (
+		inspector *steppingInspector
+		cache     *cacheProxy
+	)
+
+	BeforeEach(func() {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		inspector = &steppingInspector{clock: fakeClock, latency: 3 * time.Second}
+		cache = &cacheProxy{
+			imageRefsArchitectureMap: map[string]cachedArchitectures{},
+			failures:                 map[string]cachedFailure{},
+			registryInspector:        inspector,
+			clock:                    fakeClock,
+		}
+		SetInspectionMetricsMaxRegistries(2)
+		DeferCleanup(SetInspectionMetricsMaxRegistries, DefaultInspectionMetricsMaxRegistries)
+		inspectionDuration.Reset()
+	})
+
+	It("should record the duration of the cache misses and hits by registry host", func() {
+		ctx := context.Background()
+		_, err := cache.GetCompatibleArchitecturesSet(ctx, "//quay.io/org/app:v1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cache.GetCompatibleArchitecturesSet(ctx, "//quay.io/org/app:v1", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		misses := inspectionDurationHistogram("quay.io", "false", "success")
+		Expect(misses.GetSampleCount()).To(BeEquivalentTo(1))
+		Expect(misses.GetSampleSum()).To(BeNumerically("==", 3))
+		hits := inspectionDurationHistogram("quay.io", "true", "success")
+		Expect(hits.GetSampleCount()).To(BeEquivalentTo(1))
+		Expect(hits.GetSampleSum()).To(BeNumerically("==", 0))
+	})
+
+	It("should record the failed inspections", func() {
+		inspector.err = errors.New("manifest unknown")
+		_, err := cache.GetCompatibleArchitecturesSet(context.Background(), "//quay.io/org/missing:v1", nil)
+		Expect(err).To(HaveOccurred())
+		failures := inspectionDurationHistogram("quay.io", "false", "error")
+		Expect(failures.GetSampleCount()).To(BeEquivalentTo(1))
+		Expect(failures.GetSampleSum()).To(BeNumerically("==", 3))
+	})
+
+	It("should collapse the registry hosts beyond the maximum", func() {
+		ctx := context.Background()
+		for _, imageReference := range []string{
+			"//nginx:latest", "//Registry.Example.com:5000/app", "//quay.io/org/app", "//registry.example.com:5000/other",
+		} {
+			_, err := cache.GetCompatibleArchitecturesSet(ctx, imageReference, nil)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(inspectionDurationHistogram("docker.io", "false", "success").GetSampleCount()).To(BeEquivalentTo(1))
+		Expect(inspectionDurationHistogram("registry.example.com:5000", "false", "success").GetSampleCount()).To(
+			BeEquivalentTo(2))
+		Expect(inspectionDurationHistogram("other", "false", "success").GetSampleCount()).To(BeEquivalentTo(1))
+	})
+})
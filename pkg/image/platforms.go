@@ -0,0 +1,113 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Platform is a platform supported by an image, as reported by its manifest list or, for the single-platform images,
+// by its config. The architecture is normalized to its GOARCH name.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// strictVariantArchitectures are the architectures whose variant selects an incompatible instruction set, e.g., v6
+// and v7 for arm. The variants of the other architectures are ignored when they are missing on one of the platforms,
+// as the builders set them inconsistently, e.g., linux/s390x images with a variant.
+var strictVariantArchitectures = sets.New[string]("arm")
+
+// newPlatform returns the platform, with the architecture normalized to its GOARCH name. The unknown architectures
+// are kept verbatim.
+func newPlatform(os, architecture, variant string) Platform {
+	return Platform{OS: os, Architecture: NormalizeArchitecture(architecture), Variant: variant}
+}
+
+// ParsePlatform parses a platform in the os/architecture[/variant] format of the manifest lists, e.g.,
+// linux/arm/v7, or an architecture alone, e.g., the ppc64le value of the kubernetes.io/arch node label. The OS is then
+// empty and matches any OS.
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, fmt.Errorf("invalid platform %q: expected os/architecture[/variant]", value)
+		}
+	}
+	switch len(parts) {
+	case 1:
+		return newPlatform("", parts[0], ""), nil
+	case 2:
+		return newPlatform(parts[0], parts[1], ""), nil
+	case 3:
+		return newPlatform(parts[0], parts[1], parts[2]), nil
+	}
+	return Platform{}, fmt.Errorf("invalid platform %q: expected os/architecture[/variant]", value)
+}
+
+// Matches returns true if the platforms are the same, once their architectures are normalized. An empty OS matches
+// any OS. The variants must be equal for the architectures in strictVariantArchitectures only: for the others, a
+// missing variant matches any variant.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != "" && other.OS != "" && p.OS != other.OS {
+		return false
+	}
+	architecture := NormalizeArchitecture(p.Architecture)
+	if architecture != NormalizeArchitecture(other.Architecture) {
+		return false
+	}
+	if strictVariantArchitectures.Has(architecture) {
+		return p.Variant == other.Variant
+	}
+	return p.Variant == "" || other.Variant == "" || p.Variant == other.Variant
+}
+
+func (p Platform) String() string {
+	value := p.Architecture
+	if p.OS != "" {
+		value = p.OS + "/" + value
+	}
+	if p.Variant != "" {
+		value += "/" + p.Variant
+	}
+	return value
+}
+
+// Inspection is the outcome of the inspection of an image
+type Inspection struct {
+	// Platforms are the platforms supported by the image
+	Platforms []Platform
+	// Digest is the digest of the manifest, or of the manifest list, the image reference resolved to when it was
+	// inspected, e.g., sha256:<hex>. It is empty if unknown.
+	Digest string
+}
+
+// IPlatformsCache is the cache of the inspections of the platforms supported by the images. The ICache and the
+// ICachedArchitectures interfaces are its views restricted to the architectures.
+type IPlatformsCache interface {
+	// GetInspection returns the platforms supported by the image and its digest, inspecting it with the pull secrets
+	// if they are not cached.
+	GetInspection(ctx context.Context, imageReference string, secrets [][]byte) (Inspection, error)
+	// CachedInspection returns the cached platforms supported by the image and its digest, if they did not expire. It
+	// never inspects the image: ok is false when the inspection is not cached.
+	CachedInspection(imageReference string) (inspection Inspection, ok bool)
+}
+
+// Keychain provides the credentials used by all the inspections, in addition to the pull secrets of each inspection,
+// e.g., the global pull secret of the cluster.
+type Keychain interface {
+	// Auths returns the credentials, in the format of the auths field of a docker config.json file
+	Auths() []byte
+}
+
+// platformsArchitectures returns the architectures of the platforms
+func platformsArchitectures(platforms []Platform) sets.Set[string] {
+	architectures := sets.New[string]()
+	for _, platform := range platforms {
+		architectures.Insert(platform.Architecture)
+	}
+	return architectures
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The platforms", func() {
+	DescribeTable("should be parsed with their architecture normalized",
+		func(value string, expected Platform) {
+			Expect(ParsePlatform(value)).To(Equal(expected))
+		},
+		Entry("architecture", "ppc64le", Platform{Architecture: "ppc64le"}),
+		Entry("architecture alias", "x86_64", Platform{Architecture: "amd64"}),
+		Entry("os and architecture", "linux/s390x", Platform{OS: "linux", Architecture: "s390x"}),
+		Entry("variant", "linux/arm/v7", Platform{OS: "linux", Architecture: "arm", Variant: "v7"}),
+		Entry("unknown architecture", "linux/riscv128", Platform{OS: "linux", Architecture: "riscv128"}),
+	)
+
+	DescribeTable("should reject the invalid platforms",
+		func(value string) {
+			_, err := ParsePlatform(value)
+			Expect(err).To(MatchError(ContainSubstring("invalid platform %q", value)))
+		},
+		Entry("empty", ""),
+		Entry("empty architecture", "linux/"),
+		Entry("empty variant", "linux/arm/"),
+		Entry("too many parts", "linux/arm/v7/extra"),
+	)
+
+	DescribeTable("should match",
+		func(a, b string, expected bool) {
+			first, err := ParsePlatform(a)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := ParsePlatform(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first.Matches(second)).To(Equal(expected))
+			Expect(second.Matches(first)).To(Equal(expected))
+		},
+		Entry("the node architecture and the manifest platform", "ppc64le", "linux/ppc64le", true),
+		Entry("the architecture aliases", "linux/x86_64", "linux/amd64", true),
+		Entry("a s390x variant set erroneously", "linux/s390x", "linux/s390x/v1", true),
+		Entry("an amd64 variant", "linux/amd64", "linux/amd64/v3", true),
+		Entry("an arm64 variant", "linux/arm64", "linux/arm64/v8", true),
+		Entry("different set variants", "linux/arm64/v8", "linux/arm64/v9", false),
+		Entry("different OS", "linux/amd64", "windows/amd64", false),
+		Entry("different architectures", "linux/amd64", "linux/arm64", false),
+		Entry("the same arm variants", "linux/arm/v7", "linux/armhf/v7", true),
+		Entry("different arm variants", "linux/arm/v6", "linux/arm/v7", false),
+		Entry("a missing arm variant", "linux/arm", "linux/arm/v7", false),
+		Entry("an unknown architecture", "linux/riscv128", "riscv128", true),
+		Entry("different unknown architectures", "linux/riscv128", "linux/loong128", false),
+	)
+
+	It("should match the permutations of the platforms consistently", func() {
+		var platforms []Platform
+		for _, os := range []string{"", "linux", "windows", "LINUX"} {
+			for _, architecture := range []string{"", "amd64", "x86_64", "arm", "armhf", "armv7l", "arm64",
+				"aarch64", "ppc64le", "ppc64el", "s390x", "riscv64", "unknown", "ARM64", "x86-64"} {
+				for _, variant := range []string{"", "v5", "v6", "v7", "v8", "V7", "v1", "unknown"} {
+					platforms = append(platforms, Platform{OS: os, Architecture: architecture, Variant: variant})
+				}
+			}
+		}
+		for _, a := range platforms {
+			Expect(a.Matches(a)).To(BeTrue(), "%s does not match itself", a)
+			for _, b := range platforms {
+				matches := a.Matches(b)
+				Expect(b.Matches(a)).To(Equal(matches), "%s and %s do not match symmetrically", a, b)
+				Expect(a.Matches(b)).To(Equal(matches), "%s and %s do not match stably", a, b)
+				if matches {
+					Expect(NormalizeArchitecture(a.Architecture)).To(Equal(NormalizeArchitecture(b.Architecture)))
+				}
+			}
+			// the os/architecture[/variant] format cannot represent the variants of the platforms without an OS
+			if a.Architecture == "" || a.OS == "" && a.Variant != "" {
+				continue
+			}
+			parsed, err := ParsePlatform(a.String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Matches(a)).To(BeTrue(), "%s does not match its parsed string %s", a, parsed)
+		}
+	})
+})
@@ -0,0 +1,86 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+)
+
+// dockerTransportPrefix is the prefix of the references of the docker transport, e.g., as reported by the CRI
+const dockerTransportPrefix = "docker://"
+
+var (
+	// unsupportedTransports are the transports of containers/image whose images are not pulled from a registry
+	unsupportedTransports = []string{
+		"containers-storage", "dir", "docker-archive", "docker-daemon", "oci", "oci-archive", "sif", "tarball",
+	}
+	// portPrefixRegexp matches the port and path following the host of a registry, e.g., "5000/" in "oci:5000/image"
+	portPrefixRegexp = regexp.MustCompile(`^[0-9]+/`)
+	// anchoredDigestRegexp matches a digest without a repository, e.g., "sha256:<hex>"
+	anchoredDigestRegexp = regexp.MustCompile(`^` + reference.DigestRegexp.String() + `$`)
+)
+
+// UnsupportedTransportError is returned for the image references of a transport other than docker, e.g.,
+// "oci:/path/to/layout" or "containers-storage:image": their images are not in a registry and cannot be inspected.
+type UnsupportedTransportError struct {
+	Reference string
+	Transport string
+}
+
+func (e *UnsupportedTransportError) Error() string {
+	return fmt.Sprintf("the image reference %q uses the %s transport: only the images of the registries, "+
+		"with the docker transport, can be inspected", e.Reference, e.Transport)
+}
+
+// InvalidReferenceError is returned for the image references that cannot be parsed, e.g., "nginx:" or
+// "sha256:<hex>", without a repository
+type InvalidReferenceError struct {
+	Reference string
+	Err       error
+}
+
+func (e *InvalidReferenceError) Error() string {
+	return fmt.Sprintf("invalid image reference %q: %v", e.Reference, e.Err)
+}
+
+func (e *InvalidReferenceError) Unwrap() error {
+	return e.Err
+}
+
+// NormalizeReference returns the normalized form of the image reference of a container, as inspected and cached:
+// "//" followed by the fully qualified reference, e.g., "//docker.io/library/nginx:latest" for "nginx", so that the
+// equivalent references share their cache entries. The surrounding whitespace and the docker:// transport prefix
+// are dropped, as well as the tag of the references with both a tag and a digest: the digest identifies the image
+// pulled by the container runtime. The references with a leading "//", as built by the callers, are accepted.
+// The references of the other transports are rejected with an UnsupportedTransportError, the invalid ones with an
+// InvalidReferenceError.
+func NormalizeReference(imageReference string) (string, error) {
+	trimmed := strings.TrimSpace(imageReference)
+	trimmed = strings.TrimPrefix(trimmed, "//")
+	trimmed = strings.TrimPrefix(trimmed, dockerTransportPrefix)
+	if transport, rest, ok := strings.Cut(trimmed, ":"); ok && !portPrefixRegexp.MatchString(rest) {
+		for _, unsupported := range unsupportedTransports {
+			if transport == unsupported {
+				return "", &UnsupportedTransportError{Reference: imageReference, Transport: transport}
+			}
+		}
+	}
+	if anchoredDigestRegexp.MatchString(trimmed) {
+		return "", &InvalidReferenceError{Reference: imageReference, Err: errors.New("a digest without a repository")}
+	}
+	named, err := reference.ParseNormalizedNamed(trimmed)
+	if err != nil {
+		return "", &InvalidReferenceError{Reference: imageReference, Err: err}
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		if _, tagged := named.(reference.NamedTagged); tagged {
+			if named, err = reference.WithDigest(reference.TrimNamed(named), canonical.Digest()); err != nil {
+				return "", &InvalidReferenceError{Reference: imageReference, Err: err}
+			}
+		}
+	}
+	return "//" + reference.TagNameOnly(named).String(), nil
+}
@@ -0,0 +1,57 @@
+package image
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The image references", func() {
+	const digest = "sha256:c9a4cc2b5ac4c6b6fbcf2d6a8a57dd8b1e4c8cba0bd5e0a4d6e9e2a39de6e0f1"
+
+	DescribeTable("should be normalized",
+		func(imageReference, expected string) {
+			normalized, err := NormalizeReference(imageReference)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(normalized).To(Equal(expected))
+		},
+		Entry("short name", "nginx", "//docker.io/library/nginx:latest"),
+		Entry("leading double slash", "//quay.io/org/app:v1", "//quay.io/org/app:v1"),
+		Entry("docker transport", "docker://quay.io/org/app", "//quay.io/org/app:latest"),
+		Entry("docker transport with a leading double slash", "//docker://quay.io/org/app:v1",
+			"//quay.io/org/app:v1"),
+		Entry("surrounding whitespace", " \tquay.io/org/app:v1\n", "//quay.io/org/app:v1"),
+		Entry("registry port", "registry.example.com:5000/app:v1", "//registry.example.com:5000/app:v1"),
+		Entry("registry named after a transport with a port", "oci:5000/app", "//oci:5000/app:latest"),
+		Entry("digest", "quay.io/org/app@"+digest, "//quay.io/org/app@"+digest),
+		Entry("tag and digest", "docker://quay.io/org/app:v1@"+digest, "//quay.io/org/app@"+digest),
+	)
+
+	DescribeTable("should reject the other transports",
+		func(imageReference, transport string) {
+			_, err := NormalizeReference(imageReference)
+			var transportErr *UnsupportedTransportError
+			Expect(errors.As(err, &transportErr)).To(BeTrue())
+			Expect(transportErr.Transport).To(Equal(transport))
+			Expect(err).To(MatchError(ContainSubstring("only the images of the registries")))
+		},
+		Entry("oci layout", "oci:/var/lib/layouts/app:v1", "oci"),
+		Entry("oci archive", "oci-archive:/tmp/app.tar", "oci-archive"),
+		Entry("containers storage", "containers-storage:quay.io/org/app:v1", "containers-storage"),
+		Entry("docker daemon", " docker-daemon:app:v1", "docker-daemon"),
+	)
+
+	DescribeTable("should reject the invalid references",
+		func(imageReference string) {
+			_, err := NormalizeReference(imageReference)
+			Expect(err).To(MatchError(ContainSubstring(imageReference)))
+			var transportErr *UnsupportedTransportError
+			Expect(errors.As(err, &transportErr)).To(BeFalse())
+		},
+		Entry("digest without a repository", digest),
+		Entry("image ID", "c9a4cc2b5ac4c6b6fbcf2d6a8a57dd8b1e4c8cba0bd5e0a4d6e9e2a39de6e0f1"),
+		Entry("upper case repository", "quay.io/Org/App"),
+		Entry("empty reference", " "),
+	)
+})
@@ -0,0 +1,219 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/opencontainers/go-digest"
+	"k8s.io/klog/v2"
+)
+
+// registrySource is the types.ImageSource of the images inspected with a token of the tokenAuthenticator. It requests
+// their manifests and configs with the http client of the operator for their registry, following
+// checkRegistryRedirect, instead of the client of containers/image, that builds its own transport and cannot be given
+// that redirect policy.
+type registrySource struct {
+	ref        types.ImageReference
+	httpClient *http.Client
+	// host is the host serving the registry API, see registryAPIHost
+	host       string
+	repository string
+	token      string
+	// manifest and mimeType are the manifest of the reference of the image, read once, so that the digest of the
+	// inspection and the platform of its config are read from the same manifest
+	manifest []byte
+	mimeType string
+	// mutex is used to protect the manifest and mimeType fields from concurrent access
+	mutex sync.Mutex
+}
+
+func newRegistrySource(ref types.ImageReference, httpClient *http.Client, token string) *registrySource {
+	return &registrySource{
+		ref:        ref,
+		httpClient: httpClient,
+		host:       registryAPIHost(reference.Domain(ref.DockerReference())),
+		repository: reference.Path(ref.DockerReference()),
+		token:      token,
+	}
+}
+
+// useRegistrySource returns true if the image is pulled from its own registry only: the registries.conf entry of the
+// registry, if any, has no mirrors, does not rewrite the location of the image and is neither blocked nor insecure.
+// The other images are inspected by containers/image, that resolves their pull sources.
+func useRegistrySource(sys *types.SystemContext, ref types.ImageReference) bool {
+	registry, err := sysregistriesv2.FindRegistry(sys, ref.DockerReference().Name())
+	if err != nil || registry == nil {
+		return err == nil
+	}
+	if len(registry.Mirrors) > 0 || registry.Blocked || registry.Insecure {
+		return false
+	}
+	sources, err := registry.PullSourcesFromReference(ref.DockerReference())
+	return err == nil && len(sources) == 1 && sources[0].Reference.Name() == ref.DockerReference().Name()
+}
+
+func (s *registrySource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *registrySource) Close() error {
+	return nil
+}
+
+// GetManifest returns the manifest of the instance, or of the reference of the image when instanceDigest is nil
+func (s *registrySource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return s.fetchManifest(ctx, instanceDigest.String())
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.manifest != nil {
+		return s.manifest, s.mimeType, nil
+	}
+	tagOrDigest := "latest"
+	switch named := s.ref.DockerReference().(type) {
+	case reference.Canonical:
+		tagOrDigest = named.Digest().String()
+	case reference.NamedTagged:
+		tagOrDigest = named.Tag()
+	}
+	rawManifest, mimeType, err := s.fetchManifest(ctx, tagOrDigest)
+	if err != nil {
+		return nil, "", err
+	}
+	s.manifest, s.mimeType = rawManifest, mimeType
+	return rawManifest, mimeType, nil
+}
+
+// fetchManifest requests the manifest of the tag or digest. The manifests requested by digest are verified against it.
+func (s *registrySource) fetchManifest(ctx context.Context, tagOrDigest string) ([]byte, string, error) {
+	resp, err := s.get(ctx, auditRequestManifest, "manifests/"+tagOrDigest, manifest.DefaultRequestedManifestMIMETypes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	// the same limit as containers/image: the lower limits are checked by the inspection
+	rawManifest, err := io.ReadAll(io.LimitReader(resp.Body, DefaultMaxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(rawManifest)) > DefaultMaxManifestSize {
+		return nil, "", &ManifestTooLargeError{Max: DefaultMaxManifestSize}
+	}
+	if expected, err := digest.Parse(tagOrDigest); err == nil {
+		if matches, err := manifest.MatchesDigest(rawManifest, expected); err != nil || !matches {
+			return nil, "", fmt.Errorf("the manifest of %s/%s does not match the digest %s", s.host, s.repository,
+				expected)
+		}
+	}
+	return rawManifest, manifest.NormalizedMIMEType(resp.Header.Get("Content-Type")), nil
+}
+
+// GetBlob returns the blob, e.g., the config of the image. The size is -1 when the registry does not report it.
+func (s *registrySource) GetBlob(ctx context.Context, info types.BlobInfo, _ types.BlobInfoCache) (io.ReadCloser,
+	int64, error) {
+	resp, err := s.get(ctx, "", "blobs/"+info.Digest.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (s *registrySource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+// GetSignatures returns no signature: the inspections do not verify them
+func (s *registrySource) GetSignatures(context.Context, *digest.Digest) ([][]byte, error) {
+	return nil, nil
+}
+
+func (s *registrySource) LayerInfosForCopy(context.Context, *digest.Digest) ([]types.BlobInfo, error) {
+	return nil, nil
+}
+
+// get requests the path of the repository with the token of the source. The request is audited as auditRequest, if
+// not empty. The answers that are not successful are returned as errors, read as the ones of containers/image, see
+// registryResponseError.
+func (s *registrySource) get(ctx context.Context, auditRequest, path string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/v2/%s/%s", s.host,
+		s.repository, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("User-Agent", currentUserAgent())
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	if auditRequest != "" {
+		auditRegistryRequest(auditRequest, req.Method, reference.Domain(s.ref.DockerReference()), s.repository, status,
+			time.Since(start))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		defer resp.Body.Close()
+		err := registryResponseError(resp)
+		klog.V(4).Infof("The request of %s/%s/%s failed: %v", s.host, s.repository, path, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// registryResponseError returns the error of the answer of the registry as containers/image does, so that it is
+// classified the same, see ClassifyError: the error of the Bearer challenge of the answer, e.g., invalid_token, or else
+// the first error code of its body, e.g., MANIFEST_UNKNOWN, or else its status code.
+func registryResponseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return docker.ErrTooManyRequests
+	}
+	for _, c := range challenge.ResponseChallenges(resp) {
+		if c.Scheme != "bearer" {
+			continue
+		}
+		switch c.Parameters["error"] {
+		case "invalid_token":
+			return errcode.ErrorCodeUnauthorized.WithMessage(c.Parameters["error_description"])
+		case "insufficient_scope":
+			return errcode.ErrorCodeDenied.WithMessage(c.Parameters["error_description"])
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+	if err != nil {
+		return err
+	}
+	var errs errcode.Errors
+	if json.Unmarshal(body, &errs) == nil && len(errs) > 0 {
+		return errs[0]
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errcode.ErrorCodeUnauthorized.WithDetail(string(body))
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 50 {
+		body = append(body[:50:50], "..."...)
+	}
+	return fmt.Errorf("StatusCode: %d, %s", resp.StatusCode, body)
+}
@@ -0,0 +1,258 @@
+package image
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/go-connections/tlsconfig"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tlsPolicyCheckTTL is how long the result of the TLS policy check of a registry host is cached
+	tlsPolicyCheckTTL = 10 * time.Minute
+	tlsDialTimeout    = 10 * time.Second
+)
+
+// TLSPolicy is the TLS configuration the operator requires to the registries it talks to.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version, as defined in the crypto/tls package
+	MinVersion uint16
+	// CipherSuites is the list of the allowed cipher suites for TLS 1.2 and lower. When empty, any cipher suite
+	// is allowed.
+	CipherSuites []uint16
+	// RegistryMinVersions overrides MinVersion for the registry hosts in its keys, e.g., legacy internal registries
+	// that do not support TLS 1.2 yet.
+	RegistryMinVersions map[string]uint16
+}
+
+// DefaultTLSPolicy requires TLS 1.2 with any cipher suite
+var DefaultTLSPolicy = TLSPolicy{
+	MinVersion: tls.VersionTLS12,
+}
+
+var (
+	tlsPolicy = DefaultTLSPolicy
+	// tlsPolicyMutex is used to protect tlsPolicy from concurrent access
+	tlsPolicyMutex sync.RWMutex
+)
+
+// SetTLSPolicy sets the TLS policy applied to the connections to the registries.
+// It is expected to be called once, before the inspections start.
+func SetTLSPolicy(policy TLSPolicy) {
+	tlsPolicyMutex.Lock()
+	defer tlsPolicyMutex.Unlock()
+	tlsPolicy = policy
+}
+
+func currentTLSPolicy() TLSPolicy {
+	tlsPolicyMutex.RLock()
+	defer tlsPolicyMutex.RUnlock()
+	return tlsPolicy
+}
+
+// NewTLSPolicy builds a TLSPolicy from the names of the TLS versions and cipher suites, as defined in the
+// crypto/tls package (e.g., VersionTLS12 and TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).
+func NewTLSPolicy(minVersion string, cipherSuites []string, registryMinVersions map[string]string) (TLSPolicy, error) {
+	policy := TLSPolicy{
+		RegistryMinVersions: map[string]uint16{},
+	}
+	var err error
+	if policy.MinVersion, err = cliflag.TLSVersion(minVersion); err != nil {
+		return TLSPolicy{}, err
+	}
+	if policy.CipherSuites, err = cliflag.TLSCipherSuites(cipherSuites); err != nil {
+		return TLSPolicy{}, err
+	}
+	for registry, version := range registryMinVersions {
+		if policy.RegistryMinVersions[registry], err = cliflag.TLSVersion(version); err != nil {
+			return TLSPolicy{}, fmt.Errorf("invalid TLS version for the registry %s: %w", registry, err)
+		}
+	}
+	return policy, nil
+}
+
+// minVersionFor returns the minimum TLS version for the registry host
+func (p TLSPolicy) minVersionFor(host string) uint16 {
+	if version, ok := p.RegistryMinVersions[host]; ok {
+		return version
+	}
+	return p.MinVersion
+}
+
+// tlsConfig returns the tls.Config implementing the policy for the registry host
+func (p TLSPolicy) tlsConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion:   p.minVersionFor(host),
+		CipherSuites: p.CipherSuites,
+	}
+}
+
+// verify returns an error if the parameters negotiated with the registry host violate the policy
+func (p TLSPolicy) verify(host string, state tls.ConnectionState) error {
+	if state.Version < p.minVersionFor(host) {
+		return fmt.Errorf("negotiated %s", tls.VersionName(state.Version))
+	}
+	// The TLS 1.3 cipher suites are not configurable
+	if state.Version >= tls.VersionTLS13 || len(p.CipherSuites) == 0 {
+		return nil
+	}
+	for _, id := range p.CipherSuites {
+		if id == state.CipherSuite {
+			return nil
+		}
+	}
+	return fmt.Errorf("negotiated the cipher suite %s", tls.CipherSuiteName(state.CipherSuite))
+}
+
+// String describes the policy for the registry host, so that errors can name it
+func (p TLSPolicy) String(host string) string {
+	version := tls.VersionName(p.minVersionFor(host))
+	if len(p.CipherSuites) == 0 {
+		return fmt.Sprintf("minimum version %s", version)
+	}
+	names := make([]string, 0, len(p.CipherSuites))
+	for _, id := range p.CipherSuites {
+		names = append(names, tls.CipherSuiteName(id))
+	}
+	return fmt.Sprintf("minimum version %s, cipher suites %s", version, strings.Join(names, ","))
+}
+
+// TLSPolicyError is returned when the connection to a registry violates the TLS policy because the registry does not
+// support the TLS versions or the cipher suites it allows.
+type TLSPolicyError struct {
+	Registry string
+	Policy   string
+	Err      error
+}
+
+func (e *TLSPolicyError) Error() string {
+	return fmt.Sprintf("the registry %s does not comply with the TLS policy (%s): %v", e.Registry, e.Policy, e.Err)
+}
+
+func (e *TLSPolicyError) Unwrap() error {
+	return e.Err
+}
+
+type tlsPolicyCheckResult struct {
+	err     error
+	expires time.Time
+}
+
+// tlsPolicyChecker verifies that the registries comply with the TLS policy before they are inspected.
+// containers/image builds its own transport and does not allow to configure the TLS versions and cipher suites:
+// the checker runs the same handshake containers/image would run and verifies the negotiated parameters against the
+// policy.
+type tlsPolicyChecker struct {
+	// results caches the results of the checks by registry host
+	results map[string]tlsPolicyCheckResult
+	// mutex is used to protect the results map from concurrent access
+	mutex sync.Mutex
+}
+
+func newTLSPolicyChecker() *tlsPolicyChecker {
+	return &tlsPolicyChecker{
+		results: map[string]tlsPolicyCheckResult{},
+	}
+}
+
+// check returns a *TLSPolicyError if any of the pull sources of ref, i.e., the registry and its mirrors, violates
+// the TLS policy. containers/image falls through the pull sources in order, so any of them can be contacted.
+func (c *tlsPolicyChecker) check(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) error {
+	endpoints := []sysregistriesv2.Endpoint{{Location: ref.DockerReference().Name()}}
+	registry, err := sysregistriesv2.FindRegistry(sys, ref.DockerReference().Name())
+	if err != nil {
+		return err
+	}
+	if registry != nil {
+		sources, err := registry.PullSourcesFromReference(ref.DockerReference())
+		if err != nil {
+			return err
+		}
+		endpoints = endpoints[:0]
+		for _, source := range sources {
+			endpoints = append(endpoints, source.Endpoint)
+		}
+	}
+	for _, endpoint := range endpoints {
+		host := strings.SplitN(endpoint.Location, "/", 2)[0]
+		if err := c.checkHost(ctx, sys.DockerPerHostCertDirPath, host, endpoint.Insecure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHost returns a *TLSPolicyError if the parameters negotiated with the registry host violate the policy.
+// Failures to connect are not reported, so that the inspection reports them with the details of containers/image.
+// The results of the completed handshakes, compliant or not, are cached for tlsPolicyCheckTTL.
+func (c *tlsPolicyChecker) checkHost(ctx context.Context, certsDir, host string, insecure bool) error {
+	c.mutex.Lock()
+	result, ok := c.results[host]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(result.expires) {
+		return result.err
+	}
+	policy := currentTLSPolicy()
+	var policyErr error
+	state, err := containersImageHandshake(ctx, certsDir, host, insecure)
+	if err != nil {
+		// The result is not cached, as the registry might be temporarily unreachable
+		klog.V(4).Infof("Unable to check the TLS policy of the registry %s: %v", host, err)
+		return nil
+	}
+	if err := policy.verify(host, state); err != nil {
+		policyErr = &TLSPolicyError{
+			Registry: host,
+			Policy:   policy.String(host),
+			Err:      err,
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results[host] = tlsPolicyCheckResult{
+		err:     policyErr,
+		expires: time.Now().Add(tlsPolicyCheckTTL),
+	}
+	return policyErr
+}
+
+// containersImageHandshake runs a TLS handshake with the registry host offering the same versions and cipher suites
+// as the docker transport of containers/image, and trusting the CAs configured for the host in the certsDir folder.
+// It returns the negotiated parameters.
+func containersImageHandshake(ctx context.Context, certsDir, host string, insecure bool) (tls.ConnectionState, error) {
+	config := &tls.Config{
+		// #nosec G402 -- the negotiated version is verified against the TLS policy
+		MinVersion:         tls.VersionTLS10,
+		CipherSuites:       tlsconfig.DefaultServerAcceptedCiphers,
+		InsecureSkipVerify: insecure, // #nosec G402 -- as configured in registries.conf
+	}
+	if err := tlsclientconfig.SetupCertificates(filepath.Join(certsDir, host), config); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	address := registryAPIHost(host)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	config.ServerName, _, _ = net.SplitHostPort(address)
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: tlsDialTimeout},
+		Config:    config,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState(), nil
+}
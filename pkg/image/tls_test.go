@@ -0,0 +1,163 @@
+package image
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"multiarch-operator/pkg/system_config"
+)
+
+// newTLSRegistry starts a TLS server accepting only the given TLS versions and, if not empty, cipher suites.
+// The CA of the server is stored in the system_config.DockerCertsDir folder, so that it is trusted by the checker.
+func newTLSRegistry(minVersion, maxVersion uint16, cipherSuites ...uint16) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		MinVersion:   minVersion, // #nosec G402 -- the server emulates legacy registries
+		MaxVersion:   maxVersion,
+		CipherSuites: cipherSuites,
+	}
+	server.StartTLS()
+	DeferCleanup(server.Close)
+	trustServerCertificate(server)
+	return server
+}
+
+// trustServerCertificate stores the certificate of the server in the system_config.DockerCertsDir folder, as the
+// system_config syncer does for the registries' CAs
+func trustServerCertificate(server *httptest.Server) {
+	certsDir := filepath.Join(system_config.DockerCertsDir, tlsRegistryHost(server))
+	Expect(os.MkdirAll(certsDir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(certsDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0644)).To(Succeed())
+	DeferCleanup(os.RemoveAll, certsDir)
+}
+
+func tlsRegistryHost(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+var _ = Describe("The TLS policy checker", func() {
+	var (
+		ctx     context.Context
+		checker *tlsPolicyChecker
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		checker = newTLSPolicyChecker()
+		SetTLSPolicy(DefaultTLSPolicy)
+		DeferCleanup(SetTLSPolicy, DefaultTLSPolicy)
+	})
+
+	DescribeTable("should enforce the minimum TLS version",
+		func(maxVersion uint16, compliant bool) {
+			server := newTLSRegistry(tls.VersionTLS10, maxVersion)
+			err := checker.checkHost(ctx, system_config.DockerCertsDir, tlsRegistryHost(server), false)
+			if compliant {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			var policyErr *TLSPolicyError
+			Expect(errors.As(err, &policyErr)).To(BeTrue())
+			Expect(policyErr.Registry).To(Equal(tlsRegistryHost(server)))
+			Expect(err.Error()).To(ContainSubstring("does not comply with the TLS policy (minimum version TLS 1.2)"))
+			Expect(err.Error()).To(ContainSubstring("negotiated " + tls.VersionName(maxVersion)))
+		},
+		Entry("TLS 1.0 server", uint16(tls.VersionTLS10), false),
+		Entry("TLS 1.1 server", uint16(tls.VersionTLS11), false),
+		Entry("TLS 1.2 server", uint16(tls.VersionTLS12), true),
+		Entry("TLS 1.3 server", uint16(tls.VersionTLS13), true),
+	)
+
+	It("should apply the per-registry minimum version overrides", func() {
+		legacy := newTLSRegistry(tls.VersionTLS10, tls.VersionTLS10)
+		other := newTLSRegistry(tls.VersionTLS10, tls.VersionTLS11)
+		policy, err := NewTLSPolicy("VersionTLS12", nil, map[string]string{
+			tlsRegistryHost(legacy): "VersionTLS10",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		SetTLSPolicy(policy)
+		Expect(checker.checkHost(ctx, system_config.DockerCertsDir, tlsRegistryHost(legacy), false)).To(Succeed())
+		Expect(checker.checkHost(ctx, system_config.DockerCertsDir, tlsRegistryHost(other), false)).To(MatchError(
+			ContainSubstring("minimum version TLS 1.2")))
+	})
+
+	It("should reject the cipher suites not in the policy", func() {
+		server := newTLSRegistry(tls.VersionTLS12, tls.VersionTLS12,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA)
+		policy, err := NewTLSPolicy("VersionTLS12", []string{
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		SetTLSPolicy(policy)
+		err = checker.checkHost(ctx, system_config.DockerCertsDir, tlsRegistryHost(server), false)
+		Expect(err).To(MatchError(ContainSubstring("negotiated the cipher suite TLS_ECDHE_")))
+		Expect(err).To(MatchError(ContainSubstring(
+			"cipher suites TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")))
+	})
+
+	It("should cache the results of the checks", func() {
+		server := newTLSRegistry(tls.VersionTLS10, tls.VersionTLS10)
+		host := tlsRegistryHost(server)
+		err := checker.checkHost(ctx, system_config.DockerCertsDir, host, false)
+		Expect(err).To(HaveOccurred())
+		server.Close()
+		Expect(checker.checkHost(ctx, system_config.DockerCertsDir, host, false)).To(Equal(err))
+	})
+
+	It("should not report the registries it cannot connect to", func() {
+		server := newTLSRegistry(tls.VersionTLS10, tls.VersionTLS10)
+		host := tlsRegistryHost(server)
+		server.Close()
+		Expect(checker.checkHost(ctx, system_config.DockerCertsDir, host, false)).To(Succeed())
+		Expect(checker.results).NotTo(HaveKey(host))
+	})
+
+	It("should check the mirrors of the registry", func() {
+		compliant := newTLSRegistry(tls.VersionTLS12, tls.VersionTLS12)
+		legacyMirror := newTLSRegistry(tls.VersionTLS10, tls.VersionTLS10)
+		registriesConf := filepath.Join(GinkgoT().TempDir(), "registries.conf")
+		Expect(os.WriteFile(registriesConf, []byte(fmt.Sprintf(`
+[[registry]]
+location = "%s"
+
+[[registry.mirror]]
+location = "%s"
+`, tlsRegistryHost(compliant), tlsRegistryHost(legacyMirror))), 0644)).To(Succeed())
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    registriesConf,
+			SystemRegistriesConfDirPath: GinkgoT().TempDir(),
+			DockerPerHostCertDirPath:    system_config.DockerCertsDir,
+		}
+		ref, err := docker.ParseReference(fmt.Sprintf("//%s/org/app:latest", tlsRegistryHost(compliant)))
+		Expect(err).NotTo(HaveOccurred())
+		var policyErr *TLSPolicyError
+		Expect(errors.As(checker.check(ctx, sys, ref), &policyErr)).To(BeTrue())
+		Expect(policyErr.Registry).To(Equal(tlsRegistryHost(legacyMirror)))
+	})
+
+	It("should reject invalid policies", func() {
+		_, err := NewTLSPolicy("VersionTLS9", nil, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewTLSPolicy("VersionTLS12", []string{"TLS_NOT_A_CIPHER"}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewTLSPolicy("VersionTLS12", nil, map[string]string{"registry.example.com": "1.0"})
+		Expect(err).To(MatchError(ContainSubstring("registry.example.com")))
+	})
+})
@@ -0,0 +1,334 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// pullScopeAction is the only action the inspection needs on a repository
+	pullScopeAction = "pull"
+	// defaultTokenExpiration is the expiration assumed for tokens whose response does not report expires_in,
+	// as per https://docs.docker.com/registry/spec/auth/token/#requesting-a-token
+	defaultTokenExpiration = 60 * time.Second
+	// tokenExpirationLeeway is subtracted from the token expiration to avoid using tokens that expire in-flight
+	tokenExpirationLeeway = 5 * time.Second
+	dockerHubRegistry     = "docker.io"
+	dockerHubAPIHost      = "registry-1.docker.io"
+	// maxRegistryRedirects is the number of redirects followed by a request to a registry, e.g., from a pull-through
+	// cache to the presigned URL of its blob storage
+	maxRegistryRedirects = 5
+)
+
+// errInsufficientScope is returned when a registry rejects a token because it does not grant the pull scope
+// on the requested repository. Refreshing the token does not help in this case.
+var errInsufficientScope = errors.New("the registry token does not grant the pull scope on the repository")
+
+// errTokenRejected is returned when a registry rejects a fresh token too, after rejecting the cached one, e.g., revoked
+// before its expiration. The token is evicted from the cache: the next inspection requests another one.
+var errTokenRejected = errors.New("the registry rejected the token")
+
+type bearerToken struct {
+	Token          string    `json:"token"`
+	AccessToken    string    `json:"access_token"`
+	ExpiresIn      int       `json:"expires_in"`
+	IssuedAt       time.Time `json:"issued_at"`
+	expirationTime time.Time
+}
+
+func (t *bearerToken) value() string {
+	if t.Token != "" {
+		return t.Token
+	}
+	return t.AccessToken
+}
+
+// tokenAuthenticator implements the token authentication flow described in
+// https://docs.docker.com/registry/spec/auth/token/ for registries like Quay and Harbor: it answers the
+// WWW-Authenticate Bearer challenge of a registry by requesting a repository-scoped token to the realm, anonymously or
+// with the basic auth credentials available for the registry. Tokens are cached by registry, repository, scope and
+// credentials, so that they can be reused across inspections until they expire or the registry rejects them, but are
+// never shared between pods whose pull secrets grant different credentials.
+type tokenAuthenticator struct {
+	tokens map[string]*bearerToken
+	// httpClientForRegistry returns the http client to talk to the given registry and its token realm
+	httpClientForRegistry func(registry string) (*http.Client, error)
+	// mutex is used to protect the tokens map from concurrent access
+	mutex sync.Mutex
+}
+
+// withToken runs inspect with a token for the pull scope on registry/repository.
+// If inspect fails because the registry considers the token as expired or invalid, the token is evicted from the cache
+// and inspect is run once more with a fresh one, so that a token revoked before its expiration does not fail the
+// inspection. errTokenRejected is returned when the fresh token is rejected too: the caller retries the inspection
+// later. An empty token is passed to inspect when the registry does not use the bearer token flow, so that the default
+// authentication of containers/image applies.
+func (a *tokenAuthenticator) withToken(ctx context.Context, registry, repository string, auth types.DockerAuthConfig,
+	inspect func(token string) error) error {
+	for attempt := 0; ; attempt++ {
+		token, err := a.getToken(ctx, registry, repository, auth)
+		if err != nil {
+			klog.Warningf("Unable to get a token for %s/%s, falling back to the default authentication flow: %v",
+				registry, repository, err)
+			return inspect("")
+		}
+		err = inspect(token)
+		if err == nil || token == "" {
+			return err
+		}
+		switch {
+		case isInsufficientScopeError(err):
+			a.invalidate(registry, repository, auth)
+			return fmt.Errorf("%w: %s/%s: %v", errInsufficientScope, registry, repository, err)
+		case isUnauthorizedError(err) && attempt == 0:
+			klog.V(4).Infof("The token for %s/%s has been rejected, evicting it and requesting a new one",
+				registry, repository)
+			a.invalidate(registry, repository, auth)
+			continue
+		case isUnauthorizedError(err):
+			klog.V(4).Infof("The new token for %s/%s has been rejected too, evicting it", registry, repository)
+			a.invalidate(registry, repository, auth)
+			return fmt.Errorf("%w: %s/%s: %v", errTokenRejected, registry, repository, err)
+		}
+		return err
+	}
+}
+
+// getToken returns a token for the pull scope on registry/repository, from the cache or by going through the
+// challenge/response flow. It returns an empty string if the registry does not ask for a bearer token.
+func (a *tokenAuthenticator) getToken(ctx context.Context, registry, repository string, auth types.DockerAuthConfig) (string, error) {
+	scope := pullScope(repository)
+	key := tokenCacheKey(registry, repository, scope, auth)
+	if token, ok := a.cachedToken(key); ok {
+		return token.value(), nil
+	}
+
+	httpClient, err := a.httpClientForRegistry(registry)
+	if err != nil {
+		return "", err
+	}
+	bearerChallenge, err := pingRegistry(ctx, httpClient, registry)
+	if err != nil || bearerChallenge == nil {
+		return "", err
+	}
+	token, err := requestToken(ctx, httpClient, *bearerChallenge, scope, auth)
+	if err != nil {
+		return "", err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tokens[key] = token
+	return token.value(), nil
+}
+
+// cachedToken returns the token cached for key, if it is not expired.
+// Expired tokens are evicted from the cache, so that it only grows with the repositories in use.
+func (a *tokenAuthenticator) cachedToken(key string) (*bearerToken, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for k, token := range a.tokens {
+		if !now.Before(token.expirationTime) {
+			delete(a.tokens, k)
+		}
+	}
+	token, ok := a.tokens[key]
+	return token, ok
+}
+
+// invalidate evicts the pull token cached for registry/repository and the given credentials
+func (a *tokenAuthenticator) invalidate(registry, repository string, auth types.DockerAuthConfig) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.tokens, tokenCacheKey(registry, repository, pullScope(repository), auth))
+}
+
+// pingRegistry queries the /v2/ endpoint of the registry and returns its Bearer challenge, if any.
+func pingRegistry(ctx context.Context, httpClient *http.Client, registry string) (*challenge.Challenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("https://%s/v2/", registryAPIHost(registry)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", currentUserAgent())
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		auditRegistryRequest(auditRequestPing, req.Method, req.URL.Host, "", 0, time.Since(start))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	auditRegistryRequest(auditRequestPing, req.Method, req.URL.Host, "", resp.StatusCode, time.Since(start))
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, nil
+	}
+	for _, c := range challenge.ResponseChallenges(resp) {
+		if strings.EqualFold(c.Scheme, "bearer") {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// requestToken asks the realm of the challenge for a token with the given scope.
+// The request is anonymous when no credentials are available for the registry.
+func requestToken(ctx context.Context, httpClient *http.Client, bearerChallenge challenge.Challenge,
+	scope string, auth types.DockerAuthConfig) (*bearerToken, error) {
+	realm, ok := bearerChallenge.Parameters["realm"]
+	if !ok || realm == "" {
+		return nil, errors.New("missing realm in the bearer auth challenge")
+	}
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return nil, err
+	}
+	params := realmURL.Query()
+	if service := bearerChallenge.Parameters["service"]; service != "" {
+		params.Set("service", service)
+	}
+	params.Set("scope", scope)
+	if auth.Username != "" {
+		params.Set("account", auth.Username)
+	}
+	realmURL.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realmURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Username != "" && auth.Password != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	req.Header.Set("User-Agent", currentUserAgent())
+	repository := repositoryFromScope(scope)
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		auditRegistryRequest(auditRequestToken, req.Method, realmURL.Host, repository, 0, time.Since(start))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	auditRegistryRequest(auditRequestToken, req.Method, realmURL.Host, repository, resp.StatusCode, time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d requesting a token to %s", resp.StatusCode, realmURL.Host)
+	}
+	token := &bearerToken{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponseSize)).Decode(token); err != nil {
+		return nil, err
+	}
+	if token.value() == "" {
+		return nil, fmt.Errorf("the token server at %s returned an empty token", realmURL.Host)
+	}
+	expiresIn := defaultTokenExpiration
+	if token.ExpiresIn > 0 {
+		expiresIn = time.Duration(token.ExpiresIn) * time.Second
+	}
+	if token.IssuedAt.IsZero() {
+		token.IssuedAt = time.Now()
+	}
+	token.expirationTime = token.IssuedAt.Add(expiresIn - tokenExpirationLeeway)
+	return token, nil
+}
+
+// isUnauthorizedError returns true if err reports the registry rejected the credentials or the token
+func isUnauthorizedError(err error) bool {
+	var unauthorizedErr docker.ErrUnauthorizedForCredentials
+	if errors.As(err, &unauthorizedErr) {
+		return true
+	}
+	var ec errcode.Error
+	return errors.As(err, &ec) && ec.Code == errcode.ErrorCodeUnauthorized
+}
+
+// isInsufficientScopeError returns true if err reports the token does not grant access to the repository.
+// containers/image maps the insufficient_scope error of the WWW-Authenticate header to errcode.ErrorCodeDenied.
+func isInsufficientScopeError(err error) bool {
+	var ec errcode.Error
+	return errors.As(err, &ec) && ec.Code == errcode.ErrorCodeDenied
+}
+
+func pullScope(repository string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, pullScopeAction)
+}
+
+// repositoryFromScope returns the repository of a repository:<name>:<actions> scope
+func repositoryFromScope(scope string) string {
+	repository, _, _ := strings.Cut(strings.TrimPrefix(scope, "repository:"), ":")
+	return repository
+}
+
+func tokenCacheKey(registry, repository, scope string, auth types.DockerAuthConfig) string {
+	return fmt.Sprintf("%s/%s|%s|%s", registry, repository, scope, credentialsIdentity(auth))
+}
+
+// credentialsIdentity returns a digest identifying the credentials, without exposing them in the cache keys
+func credentialsIdentity(auth types.DockerAuthConfig) string {
+	if auth.Username == "" && auth.Password == "" && auth.IdentityToken == "" {
+		return "anonymous"
+	}
+	digest := sha256.Sum256([]byte(auth.Username + "\x00" + auth.Password + "\x00" + auth.IdentityToken))
+	return hex.EncodeToString(digest[:])
+}
+
+// registryAPIHost returns the host serving the registry API for the given registry
+func registryAPIHost(registry string) string {
+	if registry == dockerHubRegistry {
+		return dockerHubAPIHost
+	}
+	return registry
+}
+
+// defaultHTTPClientForRegistry returns an http client using the transport of the pool for the registry, that trusts
+// the CAs configured for the registry and implements the TLS policy
+func defaultHTTPClientForRegistry(transports *transportPool, registry string) (*http.Client, error) {
+	transport, err := transports.transport(registry, false)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRegistryRedirect,
+		Timeout:       30 * time.Second,
+	}, nil
+}
+
+// checkRegistryRedirect is the redirect policy of the requests to the registries: it follows up to
+// maxRegistryRedirects redirects and drops the Authorization header of the requests redirected to another host than the
+// one first requested, e.g., to the presigned URL of a blob storage rejecting the requests with another authentication.
+// net/http keeps the header for the subdomains of that host. The manifests and the image configs requested with a
+// token of the tokenAuthenticator follow this policy too, see registrySource; the other ones are requested by the client
+// of containers/image, that builds its own transport and follows the default policy of net/http.
+func checkRegistryRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > maxRegistryRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRegistryRedirects)
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+func newTokenAuthenticator(certsDir string) *tokenAuthenticator {
+	transports := newTransportPool(certsDir)
+	return &tokenAuthenticator{
+		tokens: map[string]*bearerToken{},
+		httpClientForRegistry: func(registry string) (*http.Client, error) {
+			return defaultHTTPClientForRegistry(transports, registry)
+		},
+	}
+}
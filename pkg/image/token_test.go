@@ -0,0 +1,487 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"multiarch-operator/pkg/system_config"
+)
+
+const (
+	testRepository = "org/app"
+	testUsername   = "user"
+	testPassword   = "pass"
+)
+
+// testImageIndex is the OCI index served for testRepository by the fakeTokenServer
+var testImageIndex = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[`+
+	`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s","size":1,"platform":{"architecture":"amd64","os":"linux"}},`+
+	`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s","size":1,"platform":{"architecture":"arm64","os":"linux"}}]}`,
+	strings.Repeat("a", 64), strings.Repeat("b", 64))
+
+// fakeTokenServer serves the /v2/ endpoint of a registry answering with a Bearer challenge, the token realm and the
+// manifests of testRepository.
+type fakeTokenServer struct {
+	*httptest.Server
+	requireBasicAuth bool
+	issuedTokens     atomic.Int32
+	lastScope        atomic.Value
+	// manifestRequests is the number of authorized requests of the manifests of testRepository
+	manifestRequests atomic.Int32
+	// manifestUnknown makes the server answer the authorized requests of the manifests with MANIFEST_UNKNOWN
+	manifestUnknown atomic.Bool
+	// manifestRedirect is the URL the authorized requests of the manifests are redirected to, if not empty, e.g., the
+	// presigned URL of the blob storage of a pull-through cache. It is expected to be set before the first request.
+	manifestRedirect string
+	// tokenRequests is the number of requests of the token endpoint, including the rejected ones
+	tokenRequests atomic.Int32
+	// manifestTokenError returns the error reported in the WWW-Authenticate header when a manifest is requested
+	// with the given token, e.g. invalid_token or insufficient_scope, or an empty string to serve the manifest.
+	// It is expected to be set before the first request.
+	manifestTokenError func(token string) string
+	// userAgents are the User-Agent headers of the requests
+	userAgents sets.Set[string]
+	mutex      sync.Mutex
+}
+
+func newFakeTokenServer(requireBasicAuth bool) *fakeTokenServer {
+	fts := &fakeTokenServer{
+		requireBasicAuth:   requireBasicAuth,
+		manifestTokenError: func(string) string { return "" },
+		userAgents:         sets.New[string](),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, fts.URL)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(r.URL.Path, "/v2/"+testRepository+"/manifests/") || token == "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if tokenError := fts.manifestTokenError(token); tokenError != "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s,scope="%s",error="%s"`,
+				challenge, pullScope(testRepository), tokenError))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fts.manifestRequests.Add(1)
+		if fts.manifestUnknown.Load() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+			return
+		}
+		if fts.manifestRedirect != "" {
+			http.Redirect(w, r, fts.manifestRedirect, http.StatusTemporaryRedirect)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		_, _ = w.Write([]byte(testImageIndex))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fts.tokenRequests.Add(1)
+		if fts.requireBasicAuth {
+			if username, password, ok := r.BasicAuth(); !ok || username != testUsername || password != testPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		fts.lastScope.Store(r.URL.Query().Get("scope"))
+		n := fts.issuedTokens.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      fmt.Sprintf("token-%d", n),
+			"expires_in": 300,
+		})
+	})
+	fts.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fts.mutex.Lock()
+		fts.userAgents.Insert(r.UserAgent())
+		fts.mutex.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return fts
+}
+
+// seenUserAgents returns the User-Agent headers of the requests received so far
+func (f *fakeTokenServer) seenUserAgents() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sets.List(f.userAgents)
+}
+
+func (f *fakeTokenServer) registry() string {
+	return strings.TrimPrefix(f.URL, "https://")
+}
+
+// inspector returns a registryInspector using the authenticator of the server and trusting its certificate.
+// The registries.conf file is created, if missing, as the system_config syncer does at startup.
+func (f *fakeTokenServer) inspector() *registryInspector {
+	trustServerCertificate(f.Server)
+	ensureRegistriesConf()
+	return &registryInspector{
+		tokenAuthenticator: f.authenticator(),
+		tlsPolicyChecker:   newTLSPolicyChecker(),
+	}
+}
+
+// ensureRegistriesConf creates the registries.conf file, if missing, as the system_config syncer does at startup
+func ensureRegistriesConf() {
+	if _, err := os.Stat(system_config.RegistriesConfPath); os.IsNotExist(err) {
+		Expect(os.MkdirAll(filepath.Dir(system_config.RegistriesConfPath), 0755)).To(Succeed())
+		Expect(os.WriteFile(system_config.RegistriesConfPath, nil, 0644)).To(Succeed())
+		DeferCleanup(os.Remove, system_config.RegistriesConfPath)
+	}
+}
+
+func (f *fakeTokenServer) authenticator() *tokenAuthenticator {
+	a := newTokenAuthenticator(system_config.DockerCertsDir)
+	a.httpClientForRegistry = func(string) (*http.Client, error) {
+		return f.Client(), nil
+	}
+	return a
+}
+
+var _ = Describe("The token authenticator", func() {
+	var (
+		ctx context.Context
+		fts *fakeTokenServer
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+	AfterEach(func() {
+		if fts != nil {
+			fts.Close()
+			fts = nil
+		}
+	})
+
+	It("should fetch anonymous tokens for public repositories and cache them", func() {
+		fts = newFakeTokenServer(false)
+		a := fts.authenticator()
+		var tokens []string
+		for i := 0; i < 2; i++ {
+			Expect(a.withToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{}, func(token string) error {
+				tokens = append(tokens, token)
+				return nil
+			})).To(Succeed())
+		}
+		Expect(tokens).To(Equal([]string{"token-1", "token-1"}))
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(1))
+		Expect(fts.lastScope.Load()).To(Equal("repository:org/app:pull"))
+	})
+
+	It("should send the basic auth credentials to the token realm", func() {
+		fts = newFakeTokenServer(true)
+		a := fts.authenticator()
+		token, err := a.getToken(ctx, fts.registry(), testRepository,
+			types.DockerAuthConfig{Username: testUsername, Password: testPassword})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("token-1"))
+		_, err = a.getToken(ctx, fts.registry(), "other/repo",
+			types.DockerAuthConfig{Username: testUsername, Password: "wrong"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not share tokens between different credentials", func() {
+		fts = newFakeTokenServer(false)
+		a := fts.authenticator()
+		tenantA := types.DockerAuthConfig{Username: testUsername, Password: testPassword}
+		tenantB := types.DockerAuthConfig{Username: testUsername, Password: "other"}
+		tokenA, err := a.getToken(ctx, fts.registry(), testRepository, tenantA)
+		Expect(err).NotTo(HaveOccurred())
+		tokenAnonymous, err := a.getToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		tokenB, err := a.getToken(ctx, fts.registry(), testRepository, tenantB)
+		Expect(err).NotTo(HaveOccurred())
+		Expect([]string{tokenA, tokenAnonymous, tokenB}).To(Equal([]string{"token-1", "token-2", "token-3"}))
+		tokenA, err = a.getToken(ctx, fts.registry(), testRepository, tenantA)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokenA).To(Equal("token-1"))
+	})
+
+	It("should evict the expired tokens of other repositories", func() {
+		fts = newFakeTokenServer(false)
+		a := fts.authenticator()
+		_, err := a.getToken(ctx, fts.registry(), "other/repo", types.DockerAuthConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		for _, t := range a.tokens {
+			t.expirationTime = time.Now().Add(-time.Second)
+		}
+		_, err = a.getToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a.tokens).To(HaveLen(1))
+	})
+
+	It("should refresh expired tokens", func() {
+		fts = newFakeTokenServer(false)
+		a := fts.authenticator()
+		token, err := a.getToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("token-1"))
+		for _, t := range a.tokens {
+			t.expirationTime = time.Now().Add(-time.Second)
+		}
+		token, err = a.getToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("token-2"))
+
+	})
+
+	It("should evict the token the registry rejects and inspect the image again with a fresh one", func() {
+		fts = newFakeTokenServer(false)
+		fts.manifestTokenError = func(token string) string {
+			if token == "token-1" {
+				return "invalid_token"
+			}
+			return ""
+		}
+		i := fts.inspector()
+		architectures, err := i.GetCompatibleArchitecturesSet(ctx, fmt.Sprintf("//%s/%s:latest", fts.registry(),
+			testRepository), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(2))
+		Expect(i.tokenAuthenticator.tokens).To(HaveLen(1))
+	})
+
+	It("should fail when the registry rejects the fresh token too, for the next inspection to retry", func() {
+		fts = newFakeTokenServer(false)
+		fts.manifestTokenError = func(token string) string {
+			if token == "token-1" || token == "token-2" {
+				return "invalid_token"
+			}
+			return ""
+		}
+		i := fts.inspector()
+		imageReference := fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository)
+		_, err := i.GetCompatibleArchitecturesSet(ctx, imageReference, nil)
+		Expect(errors.Is(err, errTokenRejected)).To(BeTrue())
+		Expect(ClassifyError(err).Retryable()).To(BeTrue())
+		// the token is refreshed once per inspection
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(2))
+		Expect(i.tokenAuthenticator.tokens).To(BeEmpty())
+		architectures, err := i.GetCompatibleArchitecturesSet(ctx, imageReference, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sets.List(architectures)).To(Equal([]string{"amd64", "arm64"}))
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(3))
+	})
+
+	DescribeTable("should run the inspection once per failure, but for the refresh of a rejected cached token",
+		// failures are the errors of the successive attempts, and class the class of the error of the inspection,
+		// empty when it succeeds
+		func(failures []error, attempts int, class ErrorClass) {
+			fts = newFakeTokenServer(false)
+			a := fts.authenticator()
+			calls := 0
+			err := a.withToken(ctx, fts.registry(), testRepository, types.DockerAuthConfig{}, func(string) error {
+				calls++
+				if calls > len(failures) {
+					return nil
+				}
+				return failures[calls-1]
+			})
+			Expect(calls).To(Equal(attempts))
+			Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(attempts))
+			if class == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(ClassifyError(err)).To(Equal(class))
+		},
+		Entry("rejected cached token, fresh token accepted",
+			[]error{errcode.ErrorCodeUnauthorized.WithMessage("invalid token")}, 2, ErrorClass("")),
+		Entry("rejected cached and fresh tokens", []error{
+			errcode.ErrorCodeUnauthorized.WithMessage("invalid token"),
+			errcode.ErrorCodeUnauthorized.WithMessage("invalid token"),
+		}, 2, ErrorClassTokenRejected),
+		Entry("insufficient scope", []error{errcode.ErrorCodeDenied.WithMessage("insufficient_scope")}, 1,
+			ErrorClassForbidden),
+		Entry("404", []error{v2.ErrorCodeManifestUnknown.WithMessage("unknown")}, 1, ErrorClassNotFound),
+		Entry("429", []error{errcode.ErrorCodeTooManyRequests.WithMessage("slow down")}, 1,
+			ErrorClassTooManyRequests),
+		Entry("503", []error{errcode.ErrorCodeUnavailable.WithMessage("unavailable")}, 1, ErrorClassServerError),
+		Entry("DNS failure", []error{dialError(&net.DNSError{Err: "no such host", Name: "registry.example.com",
+			IsNotFound: true})}, 1, ErrorClassDNS),
+	)
+
+	It("should not retry when the token has an insufficient scope", func() {
+		fts = newFakeTokenServer(false)
+		fts.manifestTokenError = func(string) string {
+			return "insufficient_scope"
+		}
+		i := fts.inspector()
+		_, err := i.GetCompatibleArchitecturesSet(ctx, fmt.Sprintf("//%s/%s:latest", fts.registry(), testRepository), nil)
+		Expect(errors.Is(err, errInsufficientScope)).To(BeTrue())
+		Expect(fts.issuedTokens.Load()).To(BeEquivalentTo(1))
+		Expect(i.tokenAuthenticator.tokens).To(BeEmpty())
+	})
+
+	It("should not request tokens to registries not using the token flow", func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		a := newTokenAuthenticator(system_config.DockerCertsDir)
+		a.httpClientForRegistry = func(string) (*http.Client, error) {
+			return server.Client(), nil
+		}
+		Expect(a.withToken(ctx, strings.TrimPrefix(server.URL, "https://"), testRepository, types.DockerAuthConfig{},
+			func(token string) error {
+				Expect(token).To(BeEmpty())
+				return nil
+			})).To(Succeed())
+	})
+})
+
+var _ = Describe("The redirects of the requests to the registries", func() {
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		client *http.Client
+		// registry is the host of the registry, a pull-through cache redirecting the token requests to the presigned
+		// URLs of its blob storage, served on a subdomain
+		registry string
+		// authorizations are the Authorization headers of the requests, by host and path
+		authorizations map[string][]string
+		mutex          sync.Mutex
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		authorizations = map[string][]string{}
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			authorizations[r.Host+r.URL.Path] = append(authorizations[r.Host+r.URL.Path], r.Header.Get("Authorization"))
+			mutex.Unlock()
+			switch {
+			case strings.HasPrefix(r.Host, "s3.") && r.Header.Get("Authorization") != "":
+				// the presigned URLs reject the requests with another authentication, as S3 does
+				w.WriteHeader(http.StatusBadRequest)
+			case strings.HasPrefix(r.Host, "s3.") && r.URL.Path == "/manifest":
+				w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+				_, _ = w.Write([]byte(testImageIndex))
+			case strings.HasPrefix(r.Host, "s3."):
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": "presigned-token"})
+			case r.URL.Path == "/v2/"+testRepository+"/manifests/redirected":
+				http.Redirect(w, r, fmt.Sprintf("https://s3.%s/manifest?X-Amz-Signature=signature", registry),
+					http.StatusFound)
+			case r.URL.Path == "/v2/":
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token"`, registry))
+				w.WriteHeader(http.StatusUnauthorized)
+			case r.URL.Path == "/token":
+				http.Redirect(w, r, fmt.Sprintf("https://s3.%s/token?X-Amz-Signature=signature", registry),
+					http.StatusTemporaryRedirect)
+			default:
+				http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+		_, port, err := net.SplitHostPort(tlsRegistryHost(server))
+		Expect(err).NotTo(HaveOccurred())
+		// the certificate of the server is valid for example.com and its subdomains
+		registry = net.JoinHostPort("example.com", port)
+		certsDir := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(certsDir, registry), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(certsDir, registry, "ca.crt"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)).To(Succeed())
+		client, err = defaultHTTPClientForRegistry(newTransportPool(certsDir), registry)
+		Expect(err).NotTo(HaveOccurred())
+		client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}
+	})
+
+	It("should not forward the Authorization header to the other hosts", func() {
+		a := newTokenAuthenticator(system_config.DockerCertsDir)
+		a.httpClientForRegistry = func(string) (*http.Client, error) {
+			return client, nil
+		}
+		token, err := a.getToken(ctx, registry, testRepository,
+			types.DockerAuthConfig{Username: testUsername, Password: testPassword})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("presigned-token"))
+		Expect(authorizations[registry+"/token"]).To(ConsistOf(HavePrefix("Basic ")))
+		Expect(authorizations["s3."+registry+"/token"]).To(ConsistOf(BeEmpty()))
+	})
+
+	It("should not forward the Authorization header of the manifest requests to the other hosts", func() {
+		// the blob storage is served on another host name than the one of the registry, 127.0.0.1
+		var storageAuthorizations []string
+		storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			storageAuthorizations = append(storageAuthorizations, r.Header.Get("Authorization"))
+			mutex.Unlock()
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			_, _ = w.Write([]byte(testImageIndex))
+		}))
+		DeferCleanup(storage.Close)
+		_, port, err := net.SplitHostPort(strings.TrimPrefix(storage.URL, "http://"))
+		Expect(err).NotTo(HaveOccurred())
+		fts := newFakeTokenServer(true)
+		DeferCleanup(fts.Close)
+		fts.manifestRedirect = fmt.Sprintf("http://%s/manifest?X-Amz-Signature=signature",
+			net.JoinHostPort("localhost", port))
+		// the manifests are requested with the client of the authenticator of the server
+		_, err = fts.inspector().GetCompatibleArchitecturesSet(ctx, fmt.Sprintf("//%s/%s:latest", fts.registry(),
+			testRepository), [][]byte{dockerConfigAuths(testUsername, testPassword, fts.registry())})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fts.manifestRequests.Load()).To(BeNumerically(">", 0))
+		Expect(storageAuthorizations).NotTo(BeEmpty())
+		Expect(storageAuthorizations).To(HaveEach(BeEmpty()))
+	})
+
+	It("should not forward the Authorization header of the manifest requests to the subdomains of the registry", func() {
+		a := newTokenAuthenticator(system_config.DockerCertsDir)
+		a.httpClientForRegistry = func(string) (*http.Client, error) {
+			return client, nil
+		}
+		ensureRegistriesConf()
+		i := &registryInspector{tokenAuthenticator: a}
+		ref, err := docker.ParseReference(fmt.Sprintf("//%s/%s:redirected", registry, testRepository))
+		Expect(err).NotTo(HaveOccurred())
+		inspection, err := i.inspectWithCredentials(ctx, i.systemContext(), ref, ref.DockerReference().String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inspection.Platforms).To(ConsistOf(newPlatform("linux", "amd64", ""),
+			newPlatform("linux", "arm64", "")))
+		Expect(authorizations[fmt.Sprintf("%s/v2/%s/manifests/redirected", registry, testRepository)]).To(
+			ConsistOf("Bearer presigned-token"))
+		Expect(authorizations["s3."+registry+"/manifest"]).To(ConsistOf(BeEmpty()))
+	})
+
+	It("should follow a limited number of redirects, keeping the Authorization header on the registry host", func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/v2/%s/manifests/latest",
+			registry, testRepository), nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer token")
+		_, err = client.Do(req)
+		Expect(err).To(MatchError(ContainSubstring("stopped after %d redirects", maxRegistryRedirects)))
+		Expect(authorizations[fmt.Sprintf("%s/v2/%s/manifests/latest", registry, testRepository)]).To(
+			HaveLen(maxRegistryRedirects + 1))
+		Expect(authorizations[fmt.Sprintf("%s/v2/%s/manifests/latest", registry, testRepository)]).To(
+			HaveEach("Bearer token"))
+	})
+})
@@ -0,0 +1,138 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/klog/v2"
+)
+
+// maxIdleConnsPerRegistry is the number of idle connections kept open to a registry host, so that concurrent
+// inspections of images in the same registry do not run a handshake each
+const maxIdleConnsPerRegistry = 10
+
+// transportKey identifies the settings a transport to a registry is built with
+type transportKey struct {
+	host     string
+	insecure bool
+	// caBundleHash is the digest of the files in the certificates folder of the registry
+	caBundleHash string
+	// proxy describes the proxy settings of the environment
+	proxy string
+}
+
+// pooledTransport is a transport along with the settings it was built with
+type pooledTransport struct {
+	key       transportKey
+	transport *http.Transport
+}
+
+// transportPool caches the http transports to the registries, so that the connections, and their TLS sessions, are
+// reused across the requests of the inspections instead of running a handshake per request. A transport is reused as
+// long as its settings do not change: when the CAs of the registry in the certsDir folder are rewritten with a
+// different content by the system_config syncer, or the proxy settings change, the transport is replaced and the idle
+// connections of the previous one are closed.
+type transportPool struct {
+	// certsDir is the directory of the CAs of the registries, in a folder per registry host
+	certsDir   string
+	transports map[string]*pooledTransport
+	// mutex is used to protect the transports map from concurrent access
+	mutex sync.Mutex
+}
+
+func newTransportPool(certsDir string) *transportPool {
+	return &transportPool{
+		certsDir:   certsDir,
+		transports: map[string]*pooledTransport{},
+	}
+}
+
+// transport returns the transport to the registry host, building it if none is cached for the current settings
+func (p *transportPool) transport(host string, insecure bool) (*http.Transport, error) {
+	key, proxyConfig := p.key(host, insecure)
+	id := host
+	if insecure {
+		id += "|insecure"
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	cached, ok := p.transports[id]
+	if ok && cached.key == key {
+		return cached.transport, nil
+	}
+	transport, err := p.newTransport(key, proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		klog.V(4).Infof("The certificates or the proxy settings of the registry %s changed, replacing its transport", host)
+		cached.transport.CloseIdleConnections()
+	}
+	p.transports[id] = &pooledTransport{
+		key:       key,
+		transport: transport,
+	}
+	return transport, nil
+}
+
+// key returns the current settings of the transport to the registry host. The proxy configuration is read from the
+// environment at every call, unlike http.ProxyFromEnvironment that reads it once.
+func (p *transportPool) key(host string, insecure bool) (transportKey, *httpproxy.Config) {
+	proxyConfig := httpproxy.FromEnvironment()
+	return transportKey{
+		host:         host,
+		insecure:     insecure,
+		caBundleHash: caBundleHash(filepath.Join(p.certsDir, host)),
+		proxy:        proxyConfig.HTTPProxy + "|" + proxyConfig.HTTPSProxy + "|" + proxyConfig.NoProxy,
+	}, proxyConfig
+}
+
+// newTransport builds a transport trusting the CAs of the registry in the certsDir folder, implementing the TLS policy
+// and using the given proxy settings
+func (p *transportPool) newTransport(key transportKey, proxyConfig *httpproxy.Config) (*http.Transport, error) {
+	transport := tlsclientconfig.NewTransport()
+	transport.TLSClientConfig = currentTLSPolicy().tlsConfig(key.host)
+	transport.TLSClientConfig.InsecureSkipVerify = key.insecure // #nosec G402 -- as configured in registries.conf
+	if err := tlsclientconfig.SetupCertificates(filepath.Join(p.certsDir, key.host),
+		transport.TLSClientConfig); err != nil {
+		return nil, err
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+	// HTTP/2 is only negotiated by default when the TLS configuration is not customized
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerRegistry
+	return transport, nil
+}
+
+// caBundleHash returns the digest of the names and contents of the files in the certificates folder of a registry,
+// or an empty string if the folder does not exist
+func caBundleHash(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	digest := sha256.New()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		digest.Write([]byte(entry.Name() + "\x00"))
+		fileDigest := sha256.Sum256(content)
+		digest.Write(fileDigest[:])
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
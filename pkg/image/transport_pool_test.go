@@ -0,0 +1,166 @@
+package image
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"multiarch-operator/pkg/system_config"
+)
+
+// newHandshakeCountingServer returns a TLS server answering the pings of the registry API and counting the
+// connections, i.e., the TLS handshakes, it accepts
+func newHandshakeCountingServer() (*httptest.Server, *atomic.Int32) {
+	handshakes := &atomic.Int32{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			handshakes.Add(1)
+		}
+	}
+	server.StartTLS()
+	return server, handshakes
+}
+
+// ping runs a request to the /v2/ endpoint of the server through the transport
+func ping(transport http.RoundTripper, server *httptest.Server) error {
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/v2/")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+var _ = Describe("The transport pool", func() {
+	var (
+		server     *httptest.Server
+		handshakes *atomic.Int32
+		pool       *transportPool
+	)
+	BeforeEach(func() {
+		server, handshakes = newHandshakeCountingServer()
+		DeferCleanup(server.Close)
+		trustServerCertificate(server)
+		pool = newTransportPool(system_config.DockerCertsDir)
+	})
+
+	It("should reuse the connections to a registry across the requests", func() {
+		for i := 0; i < 5; i++ {
+			transport, err := pool.transport(tlsRegistryHost(server), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ping(transport, server)).To(Succeed())
+		}
+		Expect(handshakes.Load()).To(BeEquivalentTo(1))
+		Expect(pool.transports).To(HaveLen(1))
+	})
+
+	It("should replace the transport when the certificates of the registry change", func() {
+		transport, err := pool.transport(tlsRegistryHost(server), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ping(transport, server)).To(Succeed())
+		Expect(pool.transport(tlsRegistryHost(server), false)).To(BeIdenticalTo(transport))
+
+		By("rewriting the certificates with the same content")
+		trustServerCertificate(server)
+		Expect(pool.transport(tlsRegistryHost(server), false)).To(BeIdenticalTo(transport))
+
+		By("adding a certificate")
+		Expect(os.WriteFile(filepath.Join(system_config.DockerCertsDir, tlsRegistryHost(server), "other.crt"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)).To(Succeed())
+		replaced, err := pool.transport(tlsRegistryHost(server), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replaced).NotTo(BeIdenticalTo(transport))
+		Expect(ping(replaced, server)).To(Succeed())
+		Expect(handshakes.Load()).To(BeEquivalentTo(2))
+		Expect(pool.transports).To(HaveLen(1))
+	})
+
+	It("should replace the transport when the proxy settings change", func() {
+		transport, err := pool.transport("registry.example.com", false)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.Setenv, "HTTPS_PROXY", os.Getenv("HTTPS_PROXY"))
+		Expect(os.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")).To(Succeed())
+		replaced, err := pool.transport("registry.example.com", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replaced).NotTo(BeIdenticalTo(transport))
+		registryURL, err := url.Parse("https://registry.example.com/v2/")
+		Expect(err).NotTo(HaveOccurred())
+		proxyURL, err := replaced.Proxy(&http.Request{URL: registryURL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxyURL.Host).To(Equal("proxy.example.com:3128"))
+	})
+
+	It("should not share the transports of the insecure registries", func() {
+		transport, err := pool.transport(tlsRegistryHost(server), false)
+		Expect(err).NotTo(HaveOccurred())
+		insecure, err := pool.transport(tlsRegistryHost(server), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(insecure).NotTo(BeIdenticalTo(transport))
+		Expect(insecure.TLSClientConfig.InsecureSkipVerify).To(BeTrue())
+		Expect(transport.TLSClientConfig.InsecureSkipVerify).To(BeFalse())
+	})
+})
+
+// BenchmarkTransportPool runs 100 sequential requests against a TLS registry, reusing the transport of the pool or
+// building a fresh transport per request as the inspections did before the pool, and reports the TLS handshakes.
+func BenchmarkTransportPool(b *testing.B) {
+	server, handshakes := newHandshakeCountingServer()
+	defer server.Close()
+	certsDir := b.TempDir()
+	host := tlsRegistryHost(server)
+	if err := os.MkdirAll(filepath.Join(certsDir, host), 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(certsDir, host, "ca.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		b.Fatal(err)
+	}
+	const requests = 100
+	for _, bc := range []struct {
+		name   string
+		pooled bool
+	}{
+		{name: "pooled", pooled: true},
+		{name: "fresh", pooled: false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			handshakes.Store(0)
+			for n := 0; n < b.N; n++ {
+				pool := newTransportPool(certsDir)
+				for i := 0; i < requests; i++ {
+					var transport *http.Transport
+					var err error
+					if bc.pooled {
+						transport, err = pool.transport(host, false)
+					} else {
+						key, proxyConfig := pool.key(host, false)
+						transport, err = pool.newTransport(key, proxyConfig)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := ping(transport, server); err != nil {
+						b.Fatal(err)
+					}
+					if !bc.pooled {
+						transport.CloseIdleConnections()
+					}
+				}
+				for _, cached := range pool.transports {
+					cached.transport.CloseIdleConnections()
+				}
+			}
+			b.ReportMetric(float64(handshakes.Load())/float64(b.N), "handshakes/op")
+		})
+	}
+}
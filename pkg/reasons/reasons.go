@@ -0,0 +1,128 @@
+// Package reasons defines the machine-readable reasons of the decisions of the operator. They are the Reason of its
+// events and of the conditions of its custom resources, the reason label of its metrics and the value of the
+// placement-reason annotation of the pods, so that the tooling parsing them never depends on the messages, which are
+// free text and can change in any release.
+//
+// The reasons are CamelCase and stable: once released, a reason is never renamed nor given another meaning. New
+// reasons can be added, e.g., for a new class of failures of the inspections.
+package reasons
+
+const (
+	// ArchitectureConstrained is the reason of the pods whose node affinity was restricted to the architectures
+	// supported by their images
+	ArchitectureConstrained = "ArchitectureConstrained"
+	// PlacedAtAdmission is the reason of the pods placed by the webhook at admission, whose scheduling gate only is
+	// removed by the reconciler
+	PlacedAtAdmission = "PlacedAtAdmission"
+	// OptedOut is the reason of the pods of the namespaces opted out of the placement, and of the pods excluded from it
+	// by their annotation, whose scheduling gate is removed without node affinity
+	OptedOut = "OptedOut"
+	// TrustedImages is the reason of the pods only using trusted multi-arch images, whose scheduling gate is removed
+	// without node affinity
+	TrustedImages = "TrustedImages"
+	// AllContainersExcluded is the reason of the pods whose containers are all excluded from the placement by their
+	// placement settings, whose scheduling gate is removed without node affinity
+	AllContainersExcluded = "AllContainersExcluded"
+	// GateRemovedByPolicy is the reason of the pods whose images cannot be inspected and whose scheduling gate is
+	// removed without node affinity, as the failure policy of their placement settings is Ignore
+	GateRemovedByPolicy = "GateRemovedByPolicy"
+	// DefaultedArchitectures is the reason of the pods whose images cannot be inspected and whose node affinity was
+	// restricted to the default architectures of their placement settings
+	DefaultedArchitectures = "DefaultedArchitectures"
+	// NoCommonArchitecture is the reason of the pods kept gated, or denied at admission, because none of the
+	// architectures supported by their images is allowed or available
+	NoCommonArchitecture = "NoCommonArchitecture"
+	// PlacementPaused is the reason of the pods whose scheduling gate is removed without placing them while a
+	// PodPlacementConfig pauses the pod placement, and of the Paused condition of the PodPlacementConfig objects
+	PlacementPaused = "PlacementPaused"
+	// SingleArchitectureCluster is the reason of the pods admitted without being gated while the cluster only has nodes
+	// of a single architecture, and of the EffectivelyDisabled condition of the PodPlacementConfig objects
+	SingleArchitectureCluster = "SingleArchitectureCluster"
+
+	// InspectionFailed is the reason of the pods kept gated because their images cannot be inspected, for the
+	// failures of no other InspectionFailed reason. All the InspectionFailed reasons start with it.
+	InspectionFailed = "InspectionFailed"
+	// InspectionFailedAuth is the reason of the inspections the registry rejected the credentials of, or that the
+	// credentials do not grant the pull of the repository
+	InspectionFailedAuth = "InspectionFailedAuth"
+	// InspectionFailedNotFound is the reason of the inspections of the images missing from the registry
+	InspectionFailedNotFound = "InspectionFailedNotFound"
+	// InspectionFailedNetwork is the reason of the inspections that cannot resolve or connect to the registry
+	InspectionFailedNetwork = "InspectionFailedNetwork"
+	// InspectionFailedRateLimited is the reason of the inspections the registry rate limits
+	InspectionFailedRateLimited = "InspectionFailedRateLimited"
+	// InspectionFailedRegistryError is the reason of the inspections the registry answers with a server error
+	InspectionFailedRegistryError = "InspectionFailedRegistryError"
+	// InspectionFailedTLS is the reason of the inspections failing the TLS handshake with the registry, or of the
+	// registries not complying with the TLS policy of the cluster
+	InspectionFailedTLS = "InspectionFailedTLS"
+	// InspectionFailedInvalidReference is the reason of the image references that cannot be parsed or are not in a
+	// registry
+	InspectionFailedInvalidReference = "InspectionFailedInvalidReference"
+	// InspectionFailedManifestTooLarge is the reason of the manifests larger than the limit of the inspections
+	InspectionFailedManifestTooLarge = "InspectionFailedManifestTooLarge"
+
+	// UnsupportedImageTransport is the reason of the events reporting the images of a transport other than docker,
+	// e.g., oci:/path/to/layout
+	UnsupportedImageTransport = "UnsupportedImageTransport"
+	// InvalidArchitecturesOverride is the reason of the events reporting an invalid architectures annotation, ignored
+	InvalidArchitecturesOverride = "InvalidArchitecturesOverride"
+	// AdditionalNodeSelectorTermsConflict is the reason of the events reporting the additional node selector terms
+	// not added to the node affinity of the pods, as the pods already select their keys
+	AdditionalNodeSelectorTermsConflict = "AdditionalNodeSelectorTermsConflict"
+	// ArchitecturesChanged is the reason of the events reporting the pods whose placement changed with the
+	// architectures of the nodes
+	ArchitecturesChanged = "ArchitecturesChanged"
+	// PlacementMismatch is the reason of the events reporting the pods running on an architecture their images do not
+	// support
+	PlacementMismatch = "PlacementMismatch"
+	// RegistryBlocked is the reason of the events reporting the pods using images of the blocked registries
+	RegistryBlocked = "RegistryBlocked"
+	// AnnotationsTrimmed is the reason of the events reporting the annotations of the placement left out of the update
+	// of the pods, as the pods would exceed the size limits of the API server with them
+	AnnotationsTrimmed = "AnnotationsTrimmed"
+	// IDMSTranslation is the reason of the events reporting the ImageDigestMirrorSet equivalent to an
+	// ImageContentSourcePolicy, and the lossy aspects of the translation
+	IDMSTranslation = "IDMSTranslation"
+
+	// AsExpected is the reason of the conditions of the custom resources when everything is operational
+	AsExpected = "AsExpected"
+	// SchedulingGatesUnsupported is the reason of the Degraded condition when the cluster does not support the pod
+	// scheduling gates
+	SchedulingGatesUnsupported = "SchedulingGatesUnsupported"
+	// RolloutInProgress is the reason of the NodeSyncerAvailable condition when some pods of the node syncer are not
+	// updated or not ready yet
+	RolloutInProgress = "RolloutInProgress"
+	// InvalidSpec is the reason of the Valid condition when the spec of the PodPlacementPolicy is invalid
+	InvalidSpec = "InvalidSpec"
+	// MultiplePolicies is the reason of the Valid condition of the PodPlacementPolicy objects ignored because an older
+	// one exists in the same namespace
+	MultiplePolicies = "MultiplePolicies"
+)
+
+// all are the defined reasons
+var all = []string{
+	ArchitectureConstrained, PlacedAtAdmission, OptedOut, TrustedImages, GateRemovedByPolicy, NoCommonArchitecture,
+	PlacementPaused, SingleArchitectureCluster, DefaultedArchitectures, AllContainersExcluded,
+	InspectionFailed, InspectionFailedAuth, InspectionFailedNotFound, InspectionFailedNetwork,
+	InspectionFailedRateLimited, InspectionFailedRegistryError, InspectionFailedTLS, InspectionFailedInvalidReference,
+	InspectionFailedManifestTooLarge,
+	UnsupportedImageTransport, InvalidArchitecturesOverride, AdditionalNodeSelectorTermsConflict, ArchitecturesChanged,
+	PlacementMismatch, RegistryBlocked, AnnotationsTrimmed, IDMSTranslation,
+	AsExpected, SchedulingGatesUnsupported, RolloutInProgress, InvalidSpec, MultiplePolicies,
+}
+
+// All returns all the defined reasons
+func All() []string {
+	return append([]string(nil), all...)
+}
+
+// IsDefined returns true if the reason is one of the defined reasons
+func IsDefined(reason string) bool {
+	for _, defined := range all {
+		if reason == defined {
+			return true
+		}
+	}
+	return false
+}
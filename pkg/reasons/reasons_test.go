@@ -0,0 +1,43 @@
+package reasons
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The reasons", func() {
+	It("should be CamelCase, as the reasons of the events and of the conditions", func() {
+		for _, reason := range All() {
+			Expect(reason).To(MatchRegexp(`^[A-Z][a-zA-Z0-9]*$`))
+			// the Reason of the conditions is at most 1024 characters long
+			Expect(len(reason)).To(BeNumerically("<=", 1024))
+		}
+	})
+
+	It("should be unique", func() {
+		seen := map[string]bool{}
+		for _, reason := range All() {
+			Expect(seen).NotTo(HaveKey(reason))
+			seen[reason] = true
+		}
+	})
+
+	It("should start with InspectionFailed for all the failed inspections", func() {
+		for _, reason := range []string{InspectionFailedAuth, InspectionFailedNotFound, InspectionFailedNetwork,
+			InspectionFailedRateLimited, InspectionFailedRegistryError, InspectionFailedTLS,
+			InspectionFailedInvalidReference, InspectionFailedManifestTooLarge} {
+			Expect(reason).To(HavePrefix(InspectionFailed))
+		}
+	})
+
+	It("should only define the known reasons", func() {
+		Expect(IsDefined(ArchitectureConstrained)).To(BeTrue())
+		Expect(IsDefined("Placed")).To(BeFalse())
+		Expect(IsDefined("")).To(BeFalse())
+	})
+
+	It("should not be changed through All", func() {
+		All()[0] = "Changed"
+		Expect(All()[0]).To(Equal(ArchitectureConstrained))
+	})
+})
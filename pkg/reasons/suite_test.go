@@ -0,0 +1,13 @@
+package reasons
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestReasons(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Reasons Suite")
+}
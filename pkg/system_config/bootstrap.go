@@ -0,0 +1,70 @@
+package system_config
+
+import (
+	"fmt"
+	"multiarch-operator/pkg/system_config/eval"
+	"os"
+)
+
+// bootstrapConf is the initial configuration of the registries, loaded from a registries.conf file before any cluster
+// object is read, e.g., to inspect the images of an air-gapped cluster at its bootstrap. The cluster sources override
+// it field by field as they appear: the registry sources of the image.config.openshift.io/cluster object override its
+// blocked and insecure registries, and the mirrors set by any owner, e.g., an ImageContentSourcePolicy, override its
+// mirrors of the same source.
+type bootstrapConf struct {
+	sources registrySources
+	// unqualifiedSearchRegistries replace the default ones when set. No cluster source sets them.
+	unqualifiedSearchRegistries []string
+	// mirrors are the mirrors of each source registry, in the order of the file
+	mirrors map[string][]string
+}
+
+// loadBootstrapConf reads and validates the bootstrap registries.conf file with the parser the rendered files are
+// evaluated with before being written. The file must exist. The entries the syncer cannot render as they are, i.e.,
+// the prefixes remapped to another location, the wildcard prefixes and the insecure mirrors, are rejected.
+func loadBootstrapConf(path string) (*bootstrapConf, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := eval.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	conf := &bootstrapConf{
+		unqualifiedSearchRegistries: config.UnqualifiedSearchRegistries,
+		mirrors:                     map[string][]string{},
+	}
+	for _, registry := range config.Registries {
+		if registry.Prefix != registry.Location {
+			return nil, fmt.Errorf("the prefix %q of the location %q is not supported: the prefixes must be the "+
+				"locations of their registries", registry.Prefix, registry.Location)
+		}
+		if registry.Blocked {
+			conf.sources.blocked = append(conf.sources.blocked, registry.Location)
+		}
+		if registry.Insecure {
+			conf.sources.insecure = append(conf.sources.insecure, registry.Location)
+		}
+		for _, mirror := range registry.Mirrors {
+			if mirror.Insecure {
+				return nil, fmt.Errorf("the mirror %q of %q is insecure, which is not supported", mirror.Location,
+					registry.Location)
+			}
+			conf.mirrors[registry.Location] = append(conf.mirrors[registry.Location], mirror.Location)
+		}
+	}
+	return conf, nil
+}
+
+// overriddenBy returns the registry sources overridden by the ones of the cluster: the allowed and blocked registries
+// are overridden together when either is set, as only one of them can be, and the insecure registries when set.
+func (s registrySources) overriddenBy(cluster registrySources) registrySources {
+	if len(cluster.allowed) > 0 || len(cluster.blocked) > 0 {
+		s.allowed, s.blocked = cluster.allowed, cluster.blocked
+	}
+	if len(cluster.insecure) > 0 {
+		s.insecure = cluster.insecure
+	}
+	return s
+}
@@ -0,0 +1,281 @@
+// Package eval parses the registries.conf files and evaluates them the way CRI-O does through containers/image: it
+// finds the registry entry of an image by the longest matching prefix and resolves the endpoints the image is pulled
+// from, the mirrors first and the registry last, honouring the digest-only and tag-only restrictions of the mirrors and
+// rejecting the blocked registries. It is used to validate the files rendered by the SystemConfigSyncer and to report
+// the pull sources of the images without going through the caches of containers/image.
+//
+// Both the [[registry]] entries of containers/image, whose mirrors are tables, and the [[registries]] entries rendered
+// by the SystemConfigSyncer, whose mirrors are the locations only, are parsed. The v1 format is not supported.
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/docker/reference"
+)
+
+const (
+	// PullFromMirrorAll uses the mirror for the pulls by tag and by digest
+	PullFromMirrorAll = "all"
+	// PullFromMirrorDigestOnly only uses the mirror for the pulls by digest
+	PullFromMirrorDigestOnly = "digest-only"
+	// PullFromMirrorTagOnly only uses the mirror for the pulls by tag
+	PullFromMirrorTagOnly = "tag-only"
+)
+
+// Mirror is a mirror of a Registry
+type Mirror struct {
+	Location       string `toml:"location"`
+	Insecure       bool   `toml:"insecure"`
+	PullFromMirror string `toml:"pull-from-mirror"`
+}
+
+// Registry is an entry of registries.conf
+type Registry struct {
+	// Prefix matches the images of the entry. It defaults to the Location, and can be a wildcard of the subdomains of
+	// a host, e.g., *.example.com.
+	Prefix   string `toml:"prefix"`
+	Location string `toml:"location"`
+	Insecure bool   `toml:"insecure"`
+	// Blocked rejects the pulls of the images of the entry, from the registry and its mirrors alike
+	Blocked            bool     `toml:"blocked"`
+	MirrorByDigestOnly bool     `toml:"mirror-by-digest-only"`
+	Mirrors            []Mirror `toml:"mirror"`
+}
+
+// Config is a registries.conf configuration, possibly merged with its drop-in files
+type Config struct {
+	// UnqualifiedSearchRegistries is nil when not set, so that the drop-in files setting it override it
+	UnqualifiedSearchRegistries []string
+	// Registries are sorted by prefix, each prefix once
+	Registries []Registry
+}
+
+// file is the content of a registries.conf file
+type file struct {
+	UnqualifiedSearchRegistries []string           `toml:"unqualified-search-registries"`
+	Registry                    []fileRegistry     `toml:"registry"`
+	Registries                  []renderedRegistry `toml:"registries"`
+}
+
+// fileRegistry is a [[registry]] entry. The registries cannot set pull-from-mirror, unlike their mirrors.
+type fileRegistry struct {
+	Registry
+	PullFromMirror string `toml:"pull-from-mirror"`
+}
+
+// renderedRegistry is a [[registries]] entry rendered by the SystemConfigSyncer
+type renderedRegistry struct {
+	Location string   `toml:"location"`
+	Prefix   string   `toml:"prefix"`
+	Mirrors  []string `toml:"mirror"`
+	Blocked  bool     `toml:"blocked"`
+	Insecure bool     `toml:"insecure"`
+}
+
+// anchoredDomainRegexp matches the entries of unqualified-search-registries
+var anchoredDomainRegexp = regexp.MustCompile("^" + reference.DomainRegexp.String() + "$")
+
+// InvalidConfigError is returned for the registries.conf files containers/image rejects
+type InvalidConfigError struct {
+	Reason string
+}
+
+func (e *InvalidConfigError) Error() string {
+	return "invalid registries.conf: " + e.Reason
+}
+
+// Parse parses and validates a registries.conf file, or a drop-in file. The prefixes default to the locations, the
+// trailing slashes are dropped and the registries are sorted by prefix, the first entry of each prefix winning, as
+// containers/image does.
+func Parse(data []byte) (*Config, error) {
+	content := &file{}
+	if _, err := toml.Decode(string(data), content); err != nil {
+		return nil, &InvalidConfigError{Reason: err.Error()}
+	}
+	config := &Config{UnqualifiedSearchRegistries: content.UnqualifiedSearchRegistries}
+	for _, registry := range content.Registry {
+		if registry.PullFromMirror != "" {
+			return nil, &InvalidConfigError{Reason: fmt.Sprintf("pull-from-mirror is set for the registry %q, "+
+				"which is not a mirror", registry.Location)}
+		}
+		config.Registries = append(config.Registries, registry.Registry)
+	}
+	for _, registry := range content.Registries {
+		mirrors := make([]Mirror, 0, len(registry.Mirrors))
+		for _, mirror := range registry.Mirrors {
+			mirrors = append(mirrors, Mirror{Location: mirror})
+		}
+		config.Registries = append(config.Registries, Registry{Prefix: registry.Prefix, Location: registry.Location,
+			Insecure: registry.Insecure, Blocked: registry.Blocked, Mirrors: mirrors})
+	}
+	if err := config.normalize(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Load parses the registries.conf file and merges the *.conf files of the drop-in directory in alphabetical order, as
+// containers/image does. The missing file and directory are treated as empty.
+func Load(path, dropInDir string) (*Config, error) {
+	config := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if config, err = Parse(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if dropInDir == "" {
+		return config, nil
+	}
+	entries, err := os.ReadDir(dropInDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// os.ReadDir sorts the entries by name
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".conf" {
+			continue
+		}
+		dropInPath := filepath.Join(dropInDir, entry.Name())
+		data, err := os.ReadFile(dropInPath)
+		if err != nil {
+			return nil, err
+		}
+		dropIn, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dropInPath, err)
+		}
+		config.Merge(dropIn)
+	}
+	return config, nil
+}
+
+// Merge applies a drop-in configuration: its registries replace the ones with the same prefix, and its
+// unqualified-search-registries replace the current ones, if set
+func (c *Config) Merge(dropIn *Config) {
+	registries := make(map[string]Registry, len(c.Registries)+len(dropIn.Registries))
+	for _, registry := range c.Registries {
+		registries[registry.Prefix] = registry
+	}
+	for _, registry := range dropIn.Registries {
+		registries[registry.Prefix] = registry
+	}
+	c.Registries = sortedByPrefix(registries)
+	if dropIn.UnqualifiedSearchRegistries != nil {
+		c.UnqualifiedSearchRegistries = dropIn.UnqualifiedSearchRegistries
+	}
+}
+
+// normalize validates the registries as containers/image does and sorts them by prefix
+func (c *Config) normalize() error {
+	blocked, insecure := map[string]bool{}, map[string]bool{}
+	registries := make(map[string]Registry, len(c.Registries))
+	for _, registry := range c.Registries {
+		var err error
+		if registry.Location, err = parseLocation(registry.Location); err != nil {
+			return err
+		}
+		if registry.Prefix == "" {
+			if registry.Location == "" {
+				return &InvalidConfigError{Reason: "both the location and the prefix of a registry are unset"}
+			}
+			registry.Prefix = registry.Location
+		} else {
+			if registry.Prefix, err = parseLocation(registry.Prefix); err != nil {
+				return err
+			}
+			if registry.Location == "" && !strings.HasPrefix(registry.Prefix, "*.") {
+				return &InvalidConfigError{Reason: fmt.Sprintf("the location of the registry %q is unset and its "+
+					"prefix is not in the *.example.com format", registry.Prefix)}
+			}
+			if strings.HasPrefix(registry.Prefix, "*.") && strings.ContainsAny(registry.Prefix, "/@:") {
+				return &InvalidConfigError{Reason: fmt.Sprintf("the wildcard prefix %q is not in the *.example.com "+
+					"format", registry.Prefix)}
+			}
+		}
+		mirrors := make([]Mirror, 0, len(registry.Mirrors))
+		for _, mirror := range registry.Mirrors {
+			if mirror.Location, err = parseLocation(mirror.Location); err != nil {
+				return err
+			}
+			if mirror.Location == "" {
+				return &InvalidConfigError{Reason: fmt.Sprintf("a mirror of the registry %q has no location",
+					registry.Prefix)}
+			}
+			switch mirror.PullFromMirror {
+			case "", PullFromMirrorAll, PullFromMirrorDigestOnly, PullFromMirrorTagOnly:
+			default:
+				return &InvalidConfigError{Reason: fmt.Sprintf("unsupported pull-from-mirror value %q of the mirror "+
+					"%q", mirror.PullFromMirror, mirror.Location)}
+			}
+			if registry.MirrorByDigestOnly && mirror.PullFromMirror != "" {
+				return &InvalidConfigError{Reason: fmt.Sprintf("the registry %q sets mirror-by-digest-only and its "+
+					"mirror %q sets pull-from-mirror", registry.Prefix, mirror.Location)}
+			}
+			mirrors = append(mirrors, mirror)
+		}
+		registry.Mirrors = mirrors
+		// the entries of the same location must agree on whether it is blocked and insecure
+		key := registry.Location
+		if key == "" {
+			key = registry.Prefix
+		}
+		if previous, ok := blocked[key]; ok && previous != registry.Blocked {
+			return &InvalidConfigError{Reason: fmt.Sprintf("the registry %q is defined multiple times with "+
+				"conflicting blocked settings", key)}
+		}
+		if previous, ok := insecure[key]; ok && previous != registry.Insecure {
+			return &InvalidConfigError{Reason: fmt.Sprintf("the registry %q is defined multiple times with "+
+				"conflicting insecure settings", key)}
+		}
+		blocked[key], insecure[key] = registry.Blocked, registry.Insecure
+		if _, ok := registries[registry.Prefix]; !ok {
+			registries[registry.Prefix] = registry
+		}
+	}
+	for i, registry := range c.UnqualifiedSearchRegistries {
+		var err error
+		if c.UnqualifiedSearchRegistries[i], err = parseLocation(registry); err != nil {
+			return err
+		}
+		if !anchoredDomainRegexp.MatchString(c.UnqualifiedSearchRegistries[i]) {
+			return &InvalidConfigError{Reason: fmt.Sprintf("the unqualified-search-registries entry %q is not a "+
+				"registry", registry)}
+		}
+	}
+	c.Registries = sortedByPrefix(registries)
+	return nil
+}
+
+// parseLocation drops the trailing slashes of the location, rejecting the URLs
+func parseLocation(location string) (string, error) {
+	trimmed := strings.TrimRight(location, "/")
+	if strings.HasPrefix(trimmed, "http://") || strings.HasPrefix(trimmed, "https://") {
+		return "", &InvalidConfigError{Reason: fmt.Sprintf("the location %q has a URI scheme", location)}
+	}
+	return trimmed, nil
+}
+
+// sortedByPrefix returns the registries sorted by prefix
+func sortedByPrefix(registries map[string]Registry) []Registry {
+	sorted := make([]Registry, 0, len(registries))
+	for _, registry := range registries {
+		sorted = append(sorted, registry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Prefix < sorted[j].Prefix
+	})
+	return sorted
+}
@@ -0,0 +1,379 @@
+package eval
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// references returns the references of the endpoints
+func references(endpoints []Endpoint) []string {
+	refs := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		refs = append(refs, endpoint.Reference)
+	}
+	return refs
+}
+
+var _ = Describe("The conformance of the evaluation with containers/image", func() {
+	// the cases are derived from the examples of containers-registries.conf(5) and of the tests of containers/image
+	DescribeTable("should resolve the endpoints of the images", func(conf, image string, expected ...string) {
+		config, err := Parse([]byte(conf))
+		Expect(err).NotTo(HaveOccurred())
+		endpoints, err := config.ResolveEndpoints(image)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(references(endpoints)).To(Equal(expected))
+	},
+		Entry("the prefix remapped to the location", `
+[[registry]]
+prefix = "example.com/foo/images"
+location = "internal-registry-for-example.com/bar/images"
+`, "example.com/foo/images/myimage:latest", "internal-registry-for-example.com/bar/images/myimage:latest"),
+		Entry("the mirrors tried in order before the registry", `
+[[registry]]
+location = "example.com/foo"
+
+[[registry.mirror]]
+location = "mirror-1.local/foo"
+
+[[registry.mirror]]
+location = "mirror-2.local/mirrors/foo"
+`, "example.com/foo/myimage:v1",
+			"mirror-1.local/foo/myimage:v1", "mirror-2.local/mirrors/foo/myimage:v1", "example.com/foo/myimage:v1"),
+		Entry("the prefix not matching the partial path components", `
+[[registry]]
+prefix = "example.com/foo"
+location = "mirror.local/foo"
+`, "example.com/foobar/myimage:v1", "example.com/foobar/myimage:v1"),
+		Entry("the prefix matching the port of the registry, which containers/image documents", `
+[[registry]]
+prefix = "example.com"
+location = "mirror.local"
+`, "example.com:5000/myimage:v1", "mirror.local:5000/myimage:v1"),
+		Entry("the longest prefix", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "mirror.local"
+
+[[registry]]
+location = "example.com/foo"
+
+[[registry.mirror]]
+location = "mirror.local/other"
+`, "example.com/foo/myimage:v1", "mirror.local/other/myimage:v1", "example.com/foo/myimage:v1"),
+		Entry("the wildcard prefix with an empty location", `
+[[registry]]
+prefix = "*.example.com"
+
+[[registry.mirror]]
+location = "mirror.local"
+`, "blah.foo.example.com/myimage:v1", "mirror.local/myimage:v1", "blah.foo.example.com/myimage:v1"),
+		Entry("the wildcard prefix not matching its domain", `
+[[registry]]
+prefix = "*.example.com"
+
+[[registry.mirror]]
+location = "mirror.local"
+`, "example.com/myimage:v1", "example.com/myimage:v1"),
+		Entry("the short names normalized to docker.io", `
+[[registry]]
+location = "docker.io/library"
+
+[[registry.mirror]]
+location = "mirror.local/library"
+`, "busybox", "mirror.local/library/busybox:latest", "docker.io/library/busybox:latest"),
+		Entry("the mirrors left out of the pulls by tag by mirror-by-digest-only", `
+[[registry]]
+location = "example.com"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "mirror.local"
+`, "example.com/myimage:v1", "example.com/myimage:v1"),
+		Entry("the mirrors used by the pulls by digest with mirror-by-digest-only", `
+[[registry]]
+location = "example.com"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "mirror.local"
+`, "example.com/myimage@"+digest, "mirror.local/myimage@"+digest, "example.com/myimage@"+digest),
+		Entry("the pull-from-mirror restrictions of the pulls by tag", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "digest.local"
+pull-from-mirror = "digest-only"
+
+[[registry.mirror]]
+location = "tag.local"
+pull-from-mirror = "tag-only"
+
+[[registry.mirror]]
+location = "all.local"
+pull-from-mirror = "all"
+`, "example.com/myimage:v1", "tag.local/myimage:v1", "all.local/myimage:v1", "example.com/myimage:v1"),
+		Entry("the pull-from-mirror restrictions of the pulls by digest", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "digest.local"
+pull-from-mirror = "digest-only"
+
+[[registry.mirror]]
+location = "tag.local"
+pull-from-mirror = "tag-only"
+
+[[registry.mirror]]
+location = "all.local"
+pull-from-mirror = "all"
+`, "example.com/myimage@"+digest,
+			"digest.local/myimage@"+digest, "all.local/myimage@"+digest, "example.com/myimage@"+digest),
+		Entry("the first entry of a duplicate prefix", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "first.local"
+
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "second.local"
+`, "example.com/myimage:v1", "first.local/myimage:v1", "example.com/myimage:v1"),
+		Entry("the trailing slashes of the locations dropped", `
+[[registry]]
+location = "example.com/foo/"
+`, "example.com/foo/myimage:v1", "example.com/foo/myimage:v1"),
+		Entry("the registries rendered by the SystemConfigSyncer", `
+[[registries]]
+  location = "quay.io/org"
+  prefix = ""
+  mirror = ["mirror.example.com/org", "mirror2.example.com/org"]
+`, "quay.io/org/app:v1", "mirror.example.com/org/app:v1", "mirror2.example.com/org/app:v1", "quay.io/org/app:v1"),
+	)
+
+	It("should report the locations and the insecure endpoints", func() {
+		config, err := Parse([]byte(`
+[[registry]]
+location = "example.com"
+insecure = true
+
+[[registry.mirror]]
+location = "mirror.local"
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ResolveEndpoints("example.com/myimage:v1")).To(Equal([]Endpoint{
+			{Reference: "mirror.local/myimage:v1", Location: "mirror.local", Mirror: true},
+			{Reference: "example.com/myimage:v1", Location: "example.com", Insecure: true},
+		}))
+		Expect(config.ResolveEndpoints("quay.io/org/app:v1")).To(Equal([]Endpoint{
+			{Reference: "quay.io/org/app:v1", Location: "quay.io"},
+		}))
+	})
+
+	It("should reject the images of the blocked registries", func() {
+		config, err := Parse([]byte(`
+[[registry]]
+prefix = "example.com/blocked"
+location = "example.com/blocked"
+blocked = true
+
+[[registry.mirror]]
+location = "mirror.local/blocked"
+`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = config.ResolveEndpoints("example.com/blocked/myimage:v1")
+		blocked := &BlockedError{}
+		Expect(errors.As(err, &blocked)).To(BeTrue())
+		Expect(blocked.Prefix).To(Equal("example.com/blocked"))
+		Expect(config.ResolveEndpoints("example.com/other:v1")).To(HaveLen(1))
+	})
+
+	DescribeTable("should reject the configurations containers/image rejects", func(conf string) {
+		_, err := Parse([]byte(conf))
+		invalid := &InvalidConfigError{}
+		Expect(errors.As(err, &invalid)).To(BeTrue())
+	},
+		Entry("a location with a scheme", `
+[[registry]]
+location = "https://example.com"
+`),
+		Entry("a registry without location and prefix", `
+[[registry]]
+insecure = true
+`),
+		Entry("a prefix without location, not a wildcard", `
+[[registry]]
+prefix = "example.com"
+`),
+		Entry("a wildcard prefix with a path", `
+[[registry]]
+prefix = "*.example.com/foo"
+`),
+		Entry("a mirror without location", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+insecure = true
+`),
+		Entry("an unsupported pull-from-mirror", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "mirror.local"
+pull-from-mirror = "never"
+`),
+		Entry("both mirror-by-digest-only and pull-from-mirror", `
+[[registry]]
+location = "example.com"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "mirror.local"
+pull-from-mirror = "all"
+`),
+		Entry("pull-from-mirror set for a registry", `
+[[registry]]
+location = "example.com"
+pull-from-mirror = "all"
+`),
+		Entry("conflicting blocked settings of the same location", `
+[[registry]]
+prefix = "example.com/foo"
+location = "example.com"
+blocked = true
+
+[[registry]]
+prefix = "example.com/bar"
+location = "example.com"
+`),
+		Entry("conflicting insecure settings of the same location", `
+[[registry]]
+prefix = "example.com/foo"
+location = "example.com"
+insecure = true
+
+[[registry]]
+prefix = "example.com/bar"
+location = "example.com"
+`),
+		Entry("an unqualified search registry with a path", `
+unqualified-search-registries = ["example.com/foo"]
+`),
+		Entry("a malformed file", `
+[[registry]
+`),
+	)
+})
+
+var _ = Describe("The loading of the registries.conf files", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		Expect(os.Mkdir(filepath.Join(dir, "registries.conf.d"), 0755)).To(Succeed())
+	})
+
+	write := func(name, content string) {
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	load := func() *Config {
+		config, err := Load(filepath.Join(dir, "registries.conf"), filepath.Join(dir, "registries.conf.d"))
+		Expect(err).NotTo(HaveOccurred())
+		return config
+	}
+
+	It("should merge the drop-in files in alphabetical order", func() {
+		write("registries.conf", `
+unqualified-search-registries = ["registry.access.redhat.com", "docker.io"]
+
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "main.local"
+
+[[registry]]
+location = "quay.io"
+`)
+		write("registries.conf.d/02-second.conf", `
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "second.local"
+`)
+		write("registries.conf.d/01-first.conf", `
+unqualified-search-registries = ["quay.io"]
+
+[[registry]]
+location = "example.com"
+
+[[registry.mirror]]
+location = "first.local"
+`)
+		write("registries.conf.d/03-ignored.conf.bak", `
+[[registry]]
+location = "example.com"
+blocked = true
+`)
+		config := load()
+		Expect(config.UnqualifiedSearchRegistries).To(Equal([]string{"quay.io"}))
+		Expect(config.Registries).To(HaveLen(2))
+		Expect(config.ResolveEndpoints("example.com/myimage:v1")).To(Equal([]Endpoint{
+			{Reference: "second.local/myimage:v1", Location: "second.local", Mirror: true},
+			{Reference: "example.com/myimage:v1", Location: "example.com"},
+		}))
+	})
+
+	It("should keep the unqualified-search-registries of the drop-in files not setting them", func() {
+		write("registries.conf", `unqualified-search-registries = ["docker.io"]`)
+		write("registries.conf.d/01-empty.conf", `
+[[registry]]
+location = "example.com"
+`)
+		Expect(load().UnqualifiedSearchRegistries).To(Equal([]string{"docker.io"}))
+	})
+
+	It("should parse the files rendered by the SystemConfigSyncer", func() {
+		data, err := os.ReadFile(filepath.Join("..", "testdata", "registries-full.conf"))
+		Expect(err).NotTo(HaveOccurred())
+		write("registries.conf", string(data))
+		config := load()
+		Expect(config.UnqualifiedSearchRegistries).To(Equal([]string{"registry.access.redhat.com", "docker.io"}))
+		_, err = config.ResolveEndpoints("docker.io/library/busybox:latest")
+		Expect(err).To(BeAssignableToTypeOf(&BlockedError{}))
+		Expect(config.ResolveEndpoints("registry.example.com:5000/app:v1")).To(Equal([]Endpoint{
+			{Reference: "registry.example.com:5000/app:v1", Location: "registry.example.com:5000", Insecure: true},
+		}))
+	})
+
+	It("should treat the missing files as empty", func() {
+		config, err := Load(filepath.Join(dir, "missing.conf"), filepath.Join(dir, "missing.d"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Registries).To(BeEmpty())
+	})
+
+	It("should report the drop-in files containers/image rejects", func() {
+		write("registries.conf.d/01-invalid.conf", `
+[[registry]]
+location = "http://example.com"
+`)
+		_, err := Load(filepath.Join(dir, "registries.conf"), filepath.Join(dir, "registries.conf.d"))
+		Expect(err).To(MatchError(ContainSubstring("01-invalid.conf")))
+	})
+})
@@ -0,0 +1,142 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+)
+
+// Endpoint is a location an image is pulled from
+type Endpoint struct {
+	// Reference is the reference of the image at the endpoint
+	Reference string
+	// Location is the location of the mirror or of the registry, e.g., mirror.example.com/org
+	Location string
+	Insecure bool
+	// Mirror is true for the mirrors, false for the registry itself
+	Mirror bool
+}
+
+// BlockedError is returned for the images of the blocked registries
+type BlockedError struct {
+	Reference string
+	Prefix    string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("the image %s is blocked by the registry %s of registries.conf", e.Reference, e.Prefix)
+}
+
+// FindRegistry returns the registry with the longest prefix matching the reference, i.e., a registry, a repository or
+// an image reference starting with its registry, or nil if none matches
+func (c *Config) FindRegistry(ref string) *Registry {
+	var found *Registry
+	for i := range c.Registries {
+		registry := &c.Registries[i]
+		if refMatchingPrefix(ref, registry.Prefix) != -1 && (found == nil || len(registry.Prefix) > len(found.Prefix)) {
+			found = registry
+		}
+	}
+	return found
+}
+
+// ResolveEndpoints returns the endpoints the image is pulled from, in the order they are tried: the mirrors usable by
+// the reference, i.e., all of them except the ones restricted to the pulls by digest, for a tag, or by tag, for a
+// digest, then the registry. The references without a tag or a digest are tagged with latest, and the image itself is
+// its only endpoint when its registry is not configured. It returns a BlockedError if the registry is blocked.
+func (c *Config) ResolveEndpoints(imageRef string) ([]Endpoint, error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	named = reference.TagNameOnly(named)
+	registry := c.FindRegistry(named.Name())
+	if registry == nil {
+		return []Endpoint{{Reference: named.String(), Location: reference.Domain(named)}}, nil
+	}
+	if registry.Blocked {
+		return nil, &BlockedError{Reference: named.String(), Prefix: registry.Prefix}
+	}
+	_, digested := named.(reference.Canonical)
+	var endpoints []Endpoint
+	for _, mirror := range registry.Mirrors {
+		if !mirrorUsable(registry, mirror, digested) {
+			continue
+		}
+		rewritten, err := rewriteReference(named, registry.Prefix, mirror.Location)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, Endpoint{Reference: rewritten, Location: mirror.Location,
+			Insecure: mirror.Insecure, Mirror: true})
+	}
+	rewritten, err := rewriteReference(named, registry.Prefix, registry.Location)
+	if err != nil {
+		return nil, err
+	}
+	return append(endpoints, Endpoint{Reference: rewritten, Location: registry.Location,
+		Insecure: registry.Insecure}), nil
+}
+
+// mirrorUsable returns true if the mirror of the registry can be used by the pulls by digest, if digested, or by tag
+func mirrorUsable(registry *Registry, mirror Mirror, digested bool) bool {
+	if registry.MirrorByDigestOnly {
+		return digested
+	}
+	switch mirror.PullFromMirror {
+	case PullFromMirrorDigestOnly:
+		return digested
+	case PullFromMirrorTagOnly:
+		return !digested
+	}
+	return true
+}
+
+// rewriteReference replaces the prefix of the reference with the location. The references matching a wildcard prefix
+// without a location are left unchanged.
+func rewriteReference(named reference.Named, prefix, location string) (string, error) {
+	ref := named.String()
+	if location == "" {
+		return ref, nil
+	}
+	prefixLen := refMatchingPrefix(ref, prefix)
+	if prefixLen == -1 {
+		return "", fmt.Errorf("the prefix %q does not match the reference %q", prefix, ref)
+	}
+	rewritten, err := reference.ParseNamed(location + ref[prefixLen:])
+	if err != nil {
+		return "", fmt.Errorf("unable to rewrite the reference %q for the location %q: %w", ref, location, err)
+	}
+	return rewritten.String(), nil
+}
+
+// refMatchingPrefix returns the length of the part of the reference matching the prefix, or -1 if the prefix does not
+// match it. The prefix matches the reference equal to it or continuing with a ':', a '/' or a '@' after it, e.g.,
+// example.com/foo matches example.com/foo/bar but not example.com/foobar. The wildcard prefixes, e.g., *.example.com,
+// match the subdomains of their host.
+func refMatchingPrefix(ref, prefix string) int {
+	if strings.HasPrefix(prefix, "*.") {
+		index := strings.Index(ref, prefix[1:])
+		if index == -1 || strings.Contains(ref[:index], "/") {
+			return -1
+		}
+		return matchEnd(ref, index+len(prefix)-1)
+	}
+	if !strings.HasPrefix(ref, prefix) {
+		return -1
+	}
+	return matchEnd(ref, len(prefix))
+}
+
+// matchEnd returns the end of the match if the reference ends there or continues with a separator, -1 otherwise
+func matchEnd(ref string, end int) int {
+	if end == len(ref) {
+		return end
+	}
+	switch ref[end] {
+	case ':', '/', '@':
+		return end
+	}
+	return -1
+}
@@ -1,6 +1,27 @@
 package system_config
 
+import (
+	"context"
+
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IConfigReader is the read side of the IConfigSyncer: the paths of the files it writes, read by the inspections of
+// the images.
+type IConfigReader interface {
+	// RegistriesConfPath returns the path of the registries.conf file
+	RegistriesConfPath() string
+	// RegistriesConfDirPath returns the path of the directory of the registries.conf drop-in files
+	RegistriesConfDirPath() string
+	// PolicyConfPath returns the path of the policy.json file
+	PolicyConfPath() string
+	// DockerCertsDirPath returns the path of the directory of the CAs of the registries, in a folder per registry host
+	DockerCertsDirPath() string
+}
+
 type IConfigSyncer interface {
+	IConfigReader
 
 	// StoreImageRegistryConf stores the allowedRegistries and blockedRegistries in the structs representing the
 	// registries.conf and policy.json files. It fails if both allowedRegistries and blockedRegistries are set.
@@ -9,8 +30,52 @@ type IConfigSyncer interface {
 	StoreRegistryCerts(registryCertTuples []registryCertTuple) error
 
 	UpdateRegistryMirroringConfig(registry string, mirrors []string) error
+	// UpdateRegistryMirroringConfigBatch replaces the mirrors set by the owner with the entries, acquiring the lock
+	// and requesting a sync once for all of them.
+	UpdateRegistryMirroringConfigBatch(owner string, entries []RegistryMirrors) error
 	DeleteRegistryMirroringConfig(registry string) error
 	CleanupRegistryMirroringConfig() error
+	// StoreImageContentSourcePolicy replaces the mirrors owned by the ImageContentSourcePolicy with its repository
+	// digest mirrors. The objects being deleted and the ones older than the last stored or deleted are skipped.
+	StoreImageContentSourcePolicy(icsp *operatorv1alpha1.ImageContentSourcePolicy) error
+	// DeleteImageContentSourcePolicy deletes the mirrors owned by the ImageContentSourcePolicy
+	DeleteImageContentSourcePolicy(icsp *operatorv1alpha1.ImageContentSourcePolicy) error
+
+	// StoreSigstoreAttachmentConfig stores the registries.d configuration of the sigstore signatures of the registry:
+	// whether the signatures are stored as sigstore attachments and the URL of the lookaside storage, if any.
+	StoreSigstoreAttachmentConfig(registry string, useAttachments bool, lookasideURL string) error
+	// DeleteSigstoreAttachmentConfig deletes the registries.d configuration of the registry and its YAML file.
+	DeleteSigstoreAttachmentConfig(registry string) error
+
+	// SetBlockedRegistriesObserver registers an observer notified every time the set of blocked registries changes.
+	// The observer is immediately notified with the current set of blocked registries, none of which is reported as added.
+	SetBlockedRegistriesObserver(observer BlockedRegistriesObserver)
+
+	// SetRegistryCertsObserver registers an observer notified every time the registry certificates change, once they
+	// are written to the files.
+	SetRegistryCertsObserver(observer RegistryCertsObserver)
+
+	// ForceSync rebuilds the configuration from the cluster objects read with the reader and writes the files before
+	// returning.
+	ForceSync(ctx context.Context, reader client.Reader) error
+
+	// SetBlockMirrorsOfBlockedRegistries enables or disables the blocking of the mirrors of the blocked registries.
+	SetBlockMirrorsOfBlockedRegistries(enabled bool)
 
 	sync() error
 }
+
+// BlockedRegistriesObserver is notified when StoreImageRegistryConf changes the set of blocked registries.
+// Implementations must not block, as they are called while the syncer holds its lock.
+type BlockedRegistriesObserver interface {
+	// OnBlockedRegistriesChange receives the full set of blocked registries and the subset of them that was not blocked before.
+	// The registries blocked when the operator starts are a baseline and are never reported as added.
+	OnBlockedRegistriesChange(blocked []string, added []string)
+}
+
+// RegistryCertsObserver is notified when the certificates stored by StoreRegistryCerts are written to the files, so
+// that the inspections failed because of the previous certificates can be retried.
+// Implementations must not block, as they are called while the syncer holds its lock.
+type RegistryCertsObserver interface {
+	OnRegistryCertsChange()
+}
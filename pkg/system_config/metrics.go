@@ -0,0 +1,32 @@
+package system_config
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"multiarch-operator/pkg/version"
+)
+
+// the results of the post-sync hook, see postSyncHookRuns
+const (
+	postSyncHookSucceeded = "succeeded"
+	postSyncHookFailed    = "failed"
+	postSyncHookDeferred  = "deferred"
+)
+
+var (
+	skippedRegistryCertsKeys = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "multiarch",
+		Name:      "registry_certs_skipped_keys_total",
+		Help: "The number of keys of the additional trusted CA ConfigMap skipped because they are not a registry " +
+			"hostname, optionally followed by ..<port>",
+	})
+	postSyncHookRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multiarch",
+		Name:      "post_sync_hook_runs_total",
+		Help: "The number of the runs of the post-sync hook after the changes of registries.conf, by result: " +
+			"succeeded, failed or deferred by its minimum interval",
+	}, []string{"result"})
+)
+
+func init() {
+	version.MetricsRegisterer().MustRegister(skippedRegistryCertsKeys, postSyncHookRuns)
+}
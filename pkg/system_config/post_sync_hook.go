@@ -0,0 +1,137 @@
+package system_config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// DefaultPostSyncHookTimeout is the default time the command of the PostSyncHook can run for
+	DefaultPostSyncHookTimeout = 10 * time.Second
+	// DefaultPostSyncHookMinInterval is the default minimum time between two runs of the PostSyncHook
+	DefaultPostSyncHookMinInterval = time.Minute
+	// maxPostSyncHookOutput bounds the output of the command of the PostSyncHook reported by its errors
+	maxPostSyncHookOutput = 512
+)
+
+// PostSyncHook is run after the syncs that change the registries.conf file, or its drop-in file, e.g., for CRI-O to
+// reload the mirrors on the node of the node syncer: it touches the SentinelFile and runs the Command, if set. It is
+// never run after the syncs leaving the file unchanged. The zero PostSyncHook is disabled.
+type PostSyncHook struct {
+	// SentinelFile is the file created, or whose modification time is updated, e.g., for the tools of the node to
+	// watch it
+	SentinelFile string
+	// Command is the command run, e.g., ["pkill", "-HUP", "crio"]. It is killed once it runs longer than Timeout.
+	Command []string
+	// Timeout is the time the Command can run for. It defaults to DefaultPostSyncHookTimeout.
+	Timeout time.Duration
+	// MinInterval is the minimum time between two runs of the hook: the changes made meanwhile are covered by a run
+	// delayed to the end of the interval
+	MinInterval time.Duration
+}
+
+// enabled returns true if the hook has anything to run
+func (h PostSyncHook) enabled() bool {
+	return h.SentinelFile != "" || len(h.Command) > 0
+}
+
+// run touches the sentinel file and runs the command, if set
+func (h PostSyncHook) run(ctx context.Context) error {
+	var errs []error
+	if h.SentinelFile != "" {
+		if err := touchFile(h.SentinelFile); err != nil {
+			errs = append(errs, fmt.Errorf("unable to touch the sentinel file %s: %w", h.SentinelFile, err))
+		}
+	}
+	if len(h.Command) > 0 {
+		timeout := h.Timeout
+		if timeout <= 0 {
+			timeout = DefaultPostSyncHookTimeout
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...).CombinedOutput()
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			errs = append(errs, fmt.Errorf("the command %q timed out after %s", strings.Join(h.Command, " "), timeout))
+		case err != nil:
+			if len(output) > maxPostSyncHookOutput {
+				output = output[:maxPostSyncHookOutput]
+			}
+			errs = append(errs, fmt.Errorf("the command %q failed: %w: %s", strings.Join(h.Command, " "), err,
+				strings.TrimSpace(string(output))))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// touchFile creates the file, or updates its modification time if it exists
+func touchFile(path string) error {
+	createBaseDir(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// SetPostSyncHook sets the hook run after the syncs that change the registries.conf file
+func (s *SystemConfigSyncer) SetPostSyncHook(hook PostSyncHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.postSyncHook = hook
+}
+
+// runPostSyncHook runs the post-sync hook if the registries.conf file changed since its last run, and records its
+// outcome in the SyncStatus. The run is delayed, by requesting a sync at the end of the MinInterval of the hook, when
+// the hook ran less than MinInterval ago. The failed runs are not retried: the next change runs the hook again.
+func (s *SystemConfigSyncer) runPostSyncHook(ctx context.Context) {
+	s.mu.Lock()
+	hook := s.postSyncHook
+	if !hook.enabled() || !s.registriesConfChanged {
+		s.mu.Unlock()
+		return
+	}
+	if wait := hook.MinInterval - s.clock.Since(s.lastPostSyncHookRun); !s.lastPostSyncHookRun.IsZero() && wait > 0 {
+		if !s.postSyncHookDeferred {
+			s.postSyncHookDeferred = true
+			s.logger.V(3).Info("Delaying the post-sync hook to the end of its minimum interval", "delay", wait)
+			postSyncHookRuns.WithLabelValues(postSyncHookDeferred).Inc()
+			s.clock.AfterFunc(wait, func() {
+				s.mu.Lock()
+				s.postSyncHookDeferred = false
+				s.mu.Unlock()
+				s.requestSync()
+			})
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.registriesConfChanged = false
+	s.lastPostSyncHookRun = s.clock.Now()
+	s.mu.Unlock()
+
+	err := hook.run(ctx)
+	result := postSyncHookSucceeded
+	if err != nil {
+		s.logger.Error(err, "error running the post-sync hook")
+		result = postSyncHookFailed
+	} else {
+		s.logger.V(2).Info("Ran the post-sync hook after the change of registries.conf")
+	}
+	postSyncHookRuns.WithLabelValues(result).Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncStatus.LastPostSyncHookTime, s.syncStatus.LastPostSyncHookError = s.clock.Now(), err
+}
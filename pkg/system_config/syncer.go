@@ -4,208 +4,1055 @@ import (
 	"context"
 	"fmt"
 	ocpv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"math"
 	"multiarch-operator/controllers/core"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// registryCertsConfigMapName and registryCertsConfigMapNamespace identify the ConfigMap of the CAs of the
+	// registries
+	registryCertsConfigMapName      = "image-registry-certificates"
+	registryCertsConfigMapNamespace = "openshift-image-registry"
+	// maxDeletedOwners bounds the tombstones of the deleted ImageContentSourcePolicy objects
+	maxDeletedOwners = 256
+)
+
 var (
 	singletonSystemConfigInstance IConfigSyncer
 	once                          sync.Once
 )
 
+// SystemConfigSyncer keeps the registries.conf, policy.json and certs.d files used by the image inspection in sync with
+// the image registry configuration of the cluster. It also writes the sigstore attachments configuration of the
+// registries to the registries.d directory.
+// It implements the manager.Runnable interface and is expected to be added to the manager.
 type SystemConfigSyncer struct {
+	registriesConfPath string
+	policyConfPath     string
+	dockerCertsDir     string
+	// registriesOutput is whether the syncer writes the whole registries.conf file or only a drop-in file to the
+	// registriesConfDropInDir directory
+	registriesOutput        RegistriesOutputMode
+	registriesConfDropInDir string
+	// registriesDirPath is the registries.d directory. The syncer owns its YAML files and deletes the ones of the
+	// registries that are no longer configured.
+	registriesDirPath string
+	// sigstoreAttachmentsConfigMap is the ConfigMap the sigstore attachments configuration is read from, if any
+	sigstoreAttachmentsConfigMap types.NamespacedName
+	// resyncPeriods are the periods of the resyncs of the watchers registered by registerEventHandlers
+	resyncPeriods ResyncPeriods
+	// registerEventHandlers subscribes the syncer to the changes of the objects its configuration is built from
+	registerEventHandlers func(ctx context.Context, s *SystemConfigSyncer) error
+	// clock and logger are set by the SystemConfigSyncerOptions
+	clock  clock.WithDelayedExecution
+	logger klog.Logger
+
 	registriesConfContent registriesConf
 	policyConfContent     policyConf
 	registryCertTuples    []registryCertTuple
+	// sigstoreAttachmentConfs are the sigstore attachments configurations by registry
+	sigstoreAttachmentConfs map[string]sigstoreAttachmentConf
+
+	// mirrorsBySource are the mirrors of each source registry by owner, and sourcesByOwner the sources of each owner,
+	// as set by UpdateRegistryMirroringConfigBatch
+	mirrorsBySource map[string]map[string][]string
+	sourcesByOwner  map[string]sets.Set[string]
+	// ownerVersions are the resourceVersions of the ImageContentSourcePolicy objects whose mirrors were last stored
+	// or deleted, by name, so that the events delivered out of order are ignored. The deleted owners are kept as
+	// tombstones, bounded by maxDeletedOwners.
+	ownerVersions map[string]ownerVersion
+
+	// registrySources are the registry sources of the image.config.openshift.io/cluster object. They are kept to
+	// rebuild the configuration when the mirrors change.
+	registrySources registrySources
+	// bootstrap is the configuration loaded from the bootstrap registries.conf file, overridden by the cluster sources,
+	// or nil
+	bootstrap *bootstrapConf
+	// blockMirrorsOfBlockedRegistries extends the blocking of the blocked registries to their mirrors
+	blockMirrorsOfBlockedRegistries bool
 
+	blockedRegistries sets.Set[string]
+	// blockedRegistriesSynced is false until the first image.config.openshift.io/cluster event is processed: the
+	// registries blocked at that time are a baseline and are not reported as added to the observer.
+	blockedRegistriesSynced   bool
+	blockedRegistriesObserver BlockedRegistriesObserver
+	// registryCertsChanged is true when the registry certificates changed since they were last written to the files
+	registryCertsChanged  bool
+	registryCertsObserver RegistryCertsObserver
+
+	// postSyncHook is run after the syncs that change the registries.conf file, see SetPostSyncHook
+	postSyncHook PostSyncHook
+	// registriesConfChanged is true when the registries.conf file changed since the postSyncHook last ran
+	registriesConfChanged bool
+	// lastPostSyncHookRun is the time the postSyncHook last ran, and postSyncHookDeferred is true while a run delayed
+	// by its MinInterval is pending
+	lastPostSyncHookRun  time.Time
+	postSyncHookDeferred bool
+
+	// syncStatus is the outcome of the last sync of the files
+	syncStatus SyncStatus
+	// ch carries the sync requests. It has a buffer of one and requestSync never blocks: a pending request covers any
+	// later change, as sync writes the state at the time it runs. The request is received before sync acquires the
+	// lock, so that every change is followed by a sync reading it: the changes made before the lock is acquired are
+	// written by this sync, the others request the next one.
 	ch chan bool
 	mu sync.Mutex
 }
 
-// SystemConfigSyncerSingleton returns the singleton instance of the SystemConfigSyncer
+// SystemConfigSyncerSingleton returns the singleton instance of the SystemConfigSyncer, writing the files to the
+// default paths, started in background.
+//
+// Deprecated: use NewSystemConfigSyncer and add the syncer to the manager instead.
 func SystemConfigSyncerSingleton() IConfigSyncer {
 	once.Do(func() {
-		singletonSystemConfigInstance = newSystemConfigSyncer()
+		s, err := NewSystemConfigSyncer(SystemConfigSyncerOptions{})
+		if err != nil {
+			klog.Fatalf("error creating the system config syncer: %v", err)
+		}
+		go func() {
+			if err := s.Start(context.Background()); err != nil {
+				klog.Fatalf("error starting the system config syncer: %v", err)
+			}
+		}()
+		singletonSystemConfigInstance = s
 	})
 	return singletonSystemConfigInstance
 }
 
+// SystemConfigSyncerOptions configure a SystemConfigSyncer. The unset fields take the defaults, so that independent
+// syncers, e.g., of the tests or rendering the files of different container runtimes, only set their directories.
+type SystemConfigSyncerOptions struct {
+	// RegistriesConfPath is the path of the registries.conf file. It defaults to RegistriesConfPath.
+	RegistriesConfPath string
+	// RegistriesConfDropInDir is the directory of the registries.conf drop-in files. It defaults to
+	// RegistriesConfDropInDirPath.
+	RegistriesConfDropInDir string
+	// RegistriesOutput is whether the whole registries.conf file or only a drop-in file is written. It defaults to
+	// RegistriesOutputFull.
+	RegistriesOutput RegistriesOutputMode
+	// PolicyConfPath is the path of the policy.json file. It defaults to PolicyConfPath.
+	PolicyConfPath string
+	// DockerCertsDir is the directory of the CAs of the registries. It defaults to DockerCertsDir.
+	DockerCertsDir string
+	// RegistriesDirPath is the registries.d directory. It defaults to RegistriesDirPath.
+	RegistriesDirPath string
+	// Clock is the clock of the sync times and of the post-sync hook intervals. It defaults to the real clock.
+	Clock clock.WithDelayedExecution
+	// Logger is the logger of the syncer. It defaults to the klog logger, named system-config-syncer.
+	Logger klog.Logger
+	// BootstrapRegistriesConf is the path of a registries.conf file loaded as the initial configuration of the
+	// registries, overridden field by field by the cluster sources as they appear. Its mirrors, blocked and insecure
+	// registries and unqualified-search-registries are loaded. If empty, the syncer starts from the defaults.
+	BootstrapRegistriesConf string
+	// OmitAtomicTransport omits the deprecated atomic transport from the policy.json file. By default, it is written
+	// with the same entries as the docker transport.
+	OmitAtomicTransport bool
+}
+
+// NewSystemConfigSyncer returns a SystemConfigSyncer configured by the options. It watches the cluster objects and
+// writes the files once started. The syncers share no state: each one owns the files of its paths.
+func NewSystemConfigSyncer(options SystemConfigSyncerOptions) (*SystemConfigSyncer, error) {
+	s := &SystemConfigSyncer{
+		registriesConfPath:      stringOrDefault(options.RegistriesConfPath, RegistriesConfPath),
+		registriesOutput:        RegistriesOutputFull,
+		registriesConfDropInDir: stringOrDefault(options.RegistriesConfDropInDir, RegistriesConfDropInDirPath),
+		policyConfPath:          stringOrDefault(options.PolicyConfPath, PolicyConfPath),
+		dockerCertsDir:          stringOrDefault(options.DockerCertsDir, DockerCertsDir),
+		registriesDirPath:       stringOrDefault(options.RegistriesDirPath, RegistriesDirPath),
+		registerEventHandlers:   registerEventHandlers,
+		clock:                   options.Clock,
+		logger:                  options.Logger,
+		resyncPeriods:           DefaultResyncPeriods(),
+		registriesConfContent:   defaultRegistriesConf(),
+		policyConfContent:       defaultPolicyConf(),
+		registryCertTuples:      []registryCertTuple{},
+		sigstoreAttachmentConfs: map[string]sigstoreAttachmentConf{},
+		blockedRegistries:       sets.New[string](),
+		ch:                      make(chan bool, 1),
+	}
+	s.policyConfContent.omitAtomicTransport = options.OmitAtomicTransport
+	if s.clock == nil {
+		s.clock = clock.RealClock{}
+	}
+	if s.logger.GetSink() == nil {
+		s.logger = klog.Background().WithName("system-config-syncer")
+	}
+	if options.RegistriesOutput != "" {
+		if err := s.SetRegistriesOutput(options.RegistriesOutput); err != nil {
+			return nil, err
+		}
+	}
+	if options.BootstrapRegistriesConf != "" {
+		bootstrap, err := loadBootstrapConf(options.BootstrapRegistriesConf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap registries.conf %s: %w", options.BootstrapRegistriesConf, err)
+		}
+		s.applyBootstrapConf(bootstrap)
+	}
+	return s, nil
+}
+
+// applyBootstrapConf sets the bootstrap configuration as the initial state of the syncer. It is called before the
+// syncer is shared: the lock is not acquired.
+func (s *SystemConfigSyncer) applyBootstrapConf(bootstrap *bootstrapConf) {
+	s.bootstrap = bootstrap
+	if bootstrap.unqualifiedSearchRegistries != nil {
+		s.registriesConfContent.UnqualifiedSearchRegistries = bootstrap.unqualifiedSearchRegistries
+	}
+	s.registrySources = bootstrap.sources
+	for source := range bootstrap.mirrors {
+		s.registriesConfContent.getRegistryConfOrCreate(source).Mirrors = s.mirrorsOf(source)
+	}
+	s.applyRegistrySources()
+	s.logger.Info("Loaded the bootstrap registries configuration", "blocked", bootstrap.sources.blocked,
+		"insecure", bootstrap.sources.insecure, "mirroredSources", len(bootstrap.mirrors))
+}
+
+// stringOrDefault returns the value, or the default value if empty
+func stringOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// Start subscribes the syncer to the cluster objects and writes the files at every change, until the context is done.
+func (s *SystemConfigSyncer) Start(ctx context.Context) error {
+	if err := s.registerEventHandlers(ctx, s); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush()
+			return nil
+		case <-s.ch:
+			err := s.sync()
+			if err != nil {
+				s.logger.Error(err, "error syncing system config")
+			}
+			s.recordSync(err)
+			if err == nil {
+				s.runPostSyncHook(ctx)
+			}
+		}
+	}
+}
+
+// SyncStatus is the outcome of the last sync of the files.
+type SyncStatus struct {
+	// LastSyncTime is the time of the last sync, zero until the files are written the first time
+	LastSyncTime time.Time
+	// LastSyncError is the error of the last sync, if it failed
+	LastSyncError error
+	// LastPostSyncHookTime is the time the post-sync hook last ran, zero until it runs the first time
+	LastPostSyncHookTime time.Time
+	// LastPostSyncHookError is the error of the last run of the post-sync hook, if it failed
+	LastPostSyncHookError error
+}
+
+// recordSync stores the outcome of the sync that just completed.
+func (s *SystemConfigSyncer) recordSync(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncStatus.LastSyncTime, s.syncStatus.LastSyncError = s.clock.Now(), err
+}
+
+// SyncStatus returns the outcome of the last sync of the files.
+func (s *SystemConfigSyncer) SyncStatus() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncStatus
+}
+
+// ReadyzCheck is a healthz.Checker failing until the files are written the first time and as long as the last sync
+// failed. The pods of the node syncer are ready once the files of their node are up to date.
+func (s *SystemConfigSyncer) ReadyzCheck(_ *http.Request) error {
+	status := s.SyncStatus()
+	if status.LastSyncError != nil {
+		return fmt.Errorf("the last sync of the system config failed: %w", status.LastSyncError)
+	}
+	if status.LastSyncTime.IsZero() {
+		return fmt.Errorf("the system config is not synced yet")
+	}
+	return nil
+}
+
+// flush writes the files if a sync is pending, so that the changes received before the stop are not lost
+func (s *SystemConfigSyncer) flush() {
+	select {
+	case <-s.ch:
+		err := s.sync()
+		if err != nil {
+			s.logger.Error(err, "error flushing the system config")
+		}
+		s.recordSync(err)
+	default:
+	}
+}
+
+// ForceSync reads the image.config.openshift.io/cluster object and the ConfigMaps of the registry certificates and of
+// the sigstore attachments with the reader, replays them as their event handlers do and writes the files before
+// returning. It rebuilds the files from the current state of the cluster, e.g., when debugging. The objects not found
+// are skipped, except the sigstore attachments ConfigMap, whose configuration is deleted as on its deletion.
+func (s *SystemConfigSyncer) ForceSync(ctx context.Context, reader client.Reader) error {
+	var errs []error
+	image := &ocpv1.Image{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: "cluster"}, image); err == nil {
+		errs = append(errs, s.StoreImageRegistryConf(image.Spec.RegistrySources.AllowedRegistries,
+			image.Spec.RegistrySources.BlockedRegistries, image.Spec.RegistrySources.InsecureRegistries))
+	} else if !apierrors.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("error getting the image.config.openshift.io/cluster object: %w", err))
+	}
+	certs := &v1.ConfigMap{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: registryCertsConfigMapName,
+		Namespace: registryCertsConfigMapNamespace}, certs); err == nil {
+		errs = append(errs, s.StoreRegistryCerts(parseRegistryCerts(certs)))
+	} else if !apierrors.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("error getting the configmap %s/%s: %w", registryCertsConfigMapNamespace,
+			registryCertsConfigMapName, err))
+	}
+	if configMap := s.sigstoreAttachmentsConfigMap; configMap.Name != "" {
+		cm := &v1.ConfigMap{}
+		if err := reader.Get(ctx, configMap, cm); err == nil {
+			confs, err := parseSigstoreAttachmentConfs(cm)
+			s.storeSigstoreAttachmentConfigs(confs)
+			errs = append(errs, err)
+		} else if apierrors.IsNotFound(err) {
+			s.storeSigstoreAttachmentConfigs(nil)
+		} else {
+			errs = append(errs, fmt.Errorf("error getting the configmap %s: %w", configMap, err))
+		}
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return err
+	}
+	err := s.sync()
+	s.recordSync(err)
+	if err == nil {
+		s.runPostSyncHook(ctx)
+	}
+	return err
+}
+
+// NeedLeaderElection returns false: the files are needed by every replica of the operator.
+func (s *SystemConfigSyncer) NeedLeaderElection() bool {
+	return false
+}
+
+// requestSync queues a sync of the files, unless one is already pending.
+func (s *SystemConfigSyncer) requestSync() {
+	select {
+	case s.ch <- true:
+	default:
+	}
+}
+
 func (s *SystemConfigSyncer) StoreImageRegistryConf(allowedRegistries []string, blockedRegistries []string, insecureRegistries []string) error {
 	if len(allowedRegistries) > 0 && len(blockedRegistries) > 0 {
 		return fmt.Errorf("only one of allowedRegistries and blockedRegistries can be set. Ignoring this event")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.registrySources = registrySources{
+		allowed:  allowedRegistries,
+		blocked:  blockedRegistries,
+		insecure: insecureRegistries,
+	}
+	if s.bootstrap != nil {
+		s.registrySources = s.bootstrap.sources.overriddenBy(s.registrySources)
+	}
+	s.applyRegistrySources()
+	s.updateBlockedRegistries(s.registrySources.blocked)
+	s.requestSync()
+	return nil
+}
+
+// applyRegistrySources resets the allowed, blocked and insecure registries in registries.conf and the blocked
+// registries section of policy.json and sets them from s.registrySources. When blockMirrorsOfBlockedRegistries is set,
+// the mirrors of the blocked registries are blocked too, as CRI-O would pull from them otherwise.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) applyRegistrySources() {
 	// Ensure the previous state is reset
 	for _, rc := range s.registriesConfContent.Registries {
 		rc.Allowed = nil
 		rc.Blocked = nil
 		rc.Insecure = nil
 	}
-	s.policyConfContent.resetTransports()
 	// At the time of writing, we don't see the need to generate multiple bool pointers. Keeping it the same, but at
 	// the registryConf level.
 	trueValue := true
-	for _, registry := range allowedRegistries {
+	for _, registry := range s.registrySources.allowed {
 		rc := s.registriesConfContent.getRegistryConfOrCreate(registry)
 		rc.Allowed = &trueValue
 		rc.Blocked = nil
 	}
-	for _, registry := range blockedRegistries {
+	for _, registry := range s.blockedRegistriesAndMirrors() {
 		rc := s.registriesConfContent.getRegistryConfOrCreate(registry)
 		rc.Allowed = nil
 		rc.Blocked = &trueValue
-		s.policyConfContent.setRejectForRegistry(registry)
 	}
-	for _, registry := range insecureRegistries {
+	for _, registry := range s.registrySources.insecure {
 		rc := s.registriesConfContent.getRegistryConfOrCreate(registry)
 		rc.Insecure = &trueValue
 	}
-	s.ch <- true
-	return nil
+	s.applyBlockedRegistriesPolicy()
+}
+
+// applyBlockedRegistriesPolicy rewrites the blocked registries section of policy.json from s.registrySources. The other
+// sections are left alone.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) applyBlockedRegistriesPolicy() {
+	s.policyConfContent.resetSection(policySectionBlockedRegistries)
+	for _, registry := range s.blockedRegistriesAndMirrors() {
+		s.policyConfContent.setRejectForRegistry(registry)
+	}
+}
+
+// blockedRegistriesAndMirrors returns the blocked registries, followed by their mirrors when
+// blockMirrorsOfBlockedRegistries is set.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) blockedRegistriesAndMirrors() []string {
+	if s.blockMirrorsOfBlockedRegistries {
+		return s.withMirrors(s.registrySources.blocked)
+	}
+	return s.registrySources.blocked
+}
+
+// withMirrors returns the registries followed by the mirrors configured for them.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) withMirrors(registries []string) []string {
+	result := append([]string{}, registries...)
+	for _, registry := range registries {
+		if rc, ok := s.registriesConfContent.getRegistryConf(registry); ok {
+			result = append(result, rc.Mirrors...)
+		}
+	}
+	return result
+}
+
+// SetBlockMirrorsOfBlockedRegistries enables or disables the blocking of the mirrors of the blocked registries.
+// The mirrors configured later are blocked as well, as long as their source is blocked.
+func (s *SystemConfigSyncer) SetBlockMirrorsOfBlockedRegistries(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockMirrorsOfBlockedRegistries = enabled
+	s.applyRegistrySources()
+	s.requestSync()
+}
+
+// onMirrorsChange keeps the blocking of the mirrors up to date after a change of the mirrors. The blocked registries
+// section of policy.json is rewritten in any case, so that the stores, the deletions and the cleanup of the mirrors
+// leave it consistent with the blocked registries.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) onMirrorsChange() {
+	if s.blockMirrorsOfBlockedRegistries {
+		s.applyRegistrySources()
+	} else {
+		s.applyBlockedRegistriesPolicy()
+	}
+	s.requestSync()
+}
+
+func (s *SystemConfigSyncer) SetBlockedRegistriesObserver(observer BlockedRegistriesObserver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockedRegistriesObserver = observer
+	if observer != nil {
+		observer.OnBlockedRegistriesChange(sets.List(s.blockedRegistries), nil)
+	}
+}
+
+// updateBlockedRegistries stores the new set of blocked registries and notifies the observer, if any, when it changes.
+// The first call only sets the baseline: none of the registries is reported as added.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) updateBlockedRegistries(blockedRegistries []string) {
+	blocked := sets.New(blockedRegistries...)
+	added := sets.New[string]()
+	if s.blockedRegistriesSynced {
+		if blocked.Equal(s.blockedRegistries) {
+			return
+		}
+		added = blocked.Difference(s.blockedRegistries)
+	}
+	s.blockedRegistriesSynced = true
+	s.blockedRegistries = blocked
+	if s.blockedRegistriesObserver != nil {
+		s.blockedRegistriesObserver.OnBlockedRegistriesChange(sets.List(blocked), sets.List(added))
+	}
 }
 
 func (s *SystemConfigSyncer) StoreRegistryCerts(registryCertTuples []registryCertTuple) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if !sets.New(s.registryCertTuples...).Equal(sets.New(registryCertTuples...)) {
+		s.registryCertsChanged = true
+	}
 	s.registryCertTuples = registryCertTuples
-	s.ch <- true
+	s.requestSync()
+	return nil
+}
+
+func (s *SystemConfigSyncer) SetRegistryCertsObserver(observer RegistryCertsObserver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registryCertsObserver = observer
+}
+
+// SetRegistriesDirPath sets the registries.d directory the sigstore attachments configuration is written to.
+// It is expected to be called before the syncer is started.
+func (s *SystemConfigSyncer) SetRegistriesDirPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registriesDirPath = path
+}
+
+// SetRegistriesOutput sets whether the syncer writes the whole registries.conf file or only a drop-in file with the
+// registries it manages. It is expected to be called before the syncer is started.
+func (s *SystemConfigSyncer) SetRegistriesOutput(mode RegistriesOutputMode) error {
+	switch mode {
+	case RegistriesOutputFull, RegistriesOutputDropIn:
+	default:
+		return fmt.Errorf("unknown registries output mode %q, expected one of %v", mode, RegistriesOutputModes)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registriesOutput = mode
+	return nil
+}
+
+// RegistriesConfPath returns the path of the registries.conf file. The syncer only writes it in the
+// RegistriesOutputFull mode. The paths of the files are set when the syncer is created and never change.
+func (s *SystemConfigSyncer) RegistriesConfPath() string {
+	return s.registriesConfPath
+}
+
+// RegistriesConfDirPath returns the path of the directory of the registries.conf drop-in files. The syncer only writes
+// its drop-in file in the RegistriesOutputDropIn mode, and deletes it in the RegistriesOutputFull mode: the directory is
+// returned in both modes, so that the inspections do not fall back to the registries.conf.d directories of the host.
+func (s *SystemConfigSyncer) RegistriesConfDirPath() string {
+	return s.registriesConfDropInDir
+}
+
+// PolicyConfPath returns the path of the policy.json file written by the syncer
+func (s *SystemConfigSyncer) PolicyConfPath() string {
+	return s.policyConfPath
+}
+
+// DockerCertsDirPath returns the path of the directory the syncer writes the CAs of the registries to
+func (s *SystemConfigSyncer) DockerCertsDirPath() string {
+	return s.dockerCertsDir
+}
+
+// SetSigstoreAttachmentsConfigMap sets the ConfigMap the sigstore attachments configuration is read from. Each key of
+// the ConfigMap is a registry host, with ".." in place of the colon before the port as in the
+// image-registry-certificates ConfigMap, and each value is a YAML document with the useSigstoreAttachments and
+// lookaside fields. It is expected to be called before the syncer is started.
+func (s *SystemConfigSyncer) SetSigstoreAttachmentsConfigMap(namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sigstoreAttachmentsConfigMap = types.NamespacedName{Namespace: namespace, Name: name}
+}
+
+// ResyncPeriods are the periods of the resyncs of the watchers of the syncer: the watched objects are read again at
+// every period, so that the lost events are recovered. A zero period disables the resyncs of the watcher.
+type ResyncPeriods struct {
+	// RegistryCerts is the period of the watcher of the image-registry-certificates ConfigMap
+	RegistryCerts time.Duration
+	// Image is the period of the watcher of the image.config.openshift.io/cluster object
+	Image time.Duration
+	// SigstoreAttachments is the period of the watcher of the sigstore attachments ConfigMap, if any
+	SigstoreAttachments time.Duration
+	// Jitter is the fraction of its period the period of each watcher is randomly spread by, see
+	// core.JitteredPeriod. Zero disables the jitter.
+	Jitter float64
+}
+
+// DefaultResyncPeriods returns the default periods of the resyncs of the watchers of the syncer
+func DefaultResyncPeriods() ResyncPeriods {
+	return ResyncPeriods{
+		RegistryCerts:       core.DefaultResyncPeriod,
+		Image:               core.DefaultResyncPeriod,
+		SigstoreAttachments: core.DefaultResyncPeriod,
+		Jitter:              core.DefaultResyncJitter,
+	}
+}
+
+// jittered returns the periods spread by the jitter, each one independently of the others
+func (p ResyncPeriods) jittered() ResyncPeriods {
+	return ResyncPeriods{
+		RegistryCerts:       core.JitteredPeriod(p.RegistryCerts, p.Jitter),
+		Image:               core.JitteredPeriod(p.Image, p.Jitter),
+		SigstoreAttachments: core.JitteredPeriod(p.SigstoreAttachments, p.Jitter),
+		Jitter:              p.Jitter,
+	}
+}
+
+// SetResyncPeriods sets the periods of the resyncs of the watchers of the syncer. It is expected to be called before
+// the syncer is started.
+func (s *SystemConfigSyncer) SetResyncPeriods(periods ResyncPeriods) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resyncPeriods = periods
+}
+
+func (s *SystemConfigSyncer) StoreSigstoreAttachmentConfig(registry string, useAttachments bool, lookasideURL string) error {
+	if registry == "" {
+		return fmt.Errorf("the registry of the sigstore attachments configuration must not be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sigstoreAttachmentConfs == nil {
+		s.sigstoreAttachmentConfs = map[string]sigstoreAttachmentConf{}
+	}
+	s.sigstoreAttachmentConfs[registry] = sigstoreAttachmentConf{
+		registry:       registry,
+		useAttachments: useAttachments,
+		lookasideURL:   lookasideURL,
+	}
+	s.requestSync()
 	return nil
 }
 
+func (s *SystemConfigSyncer) DeleteSigstoreAttachmentConfig(registry string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sigstoreAttachmentConfs[registry]; !ok {
+		return fmt.Errorf("registry %s not found", registry)
+	}
+	delete(s.sigstoreAttachmentConfs, registry)
+	s.requestSync()
+	return nil
+}
+
+// storeSigstoreAttachmentConfigs replaces the sigstore attachments configurations: the registries missing from confs
+// are deleted.
+func (s *SystemConfigSyncer) storeSigstoreAttachmentConfigs(confs []sigstoreAttachmentConf) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sigstoreAttachmentConfs = make(map[string]sigstoreAttachmentConf, len(confs))
+	for _, conf := range confs {
+		s.sigstoreAttachmentConfs[conf.registry] = conf
+	}
+	s.requestSync()
+}
+
+// UpdateRegistryMirroringConfig sets the mirrors of the registry. It is a batch of one entry owned by the registry.
 func (s *SystemConfigSyncer) UpdateRegistryMirroringConfig(registry string, mirrors []string) error {
+	return s.UpdateRegistryMirroringConfigBatch(registry, []RegistryMirrors{{Source: registry, Mirrors: mirrors}})
+}
+
+// UpdateRegistryMirroringConfigBatch replaces the mirrors set by the owner, e.g., an ImageContentSourcePolicy, with
+// the entries: the sources the owner no longer lists lose its mirrors. The mirrors of a source are the union of the
+// mirrors of its owners, in the order of the owners' names. The lock is acquired once for the whole batch and a
+// single sync is requested.
+func (s *SystemConfigSyncer) UpdateRegistryMirroringConfigBatch(owner string, entries []RegistryMirrors) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateRegistryMirroringConfigBatch(owner, entries)
+	return nil
+}
+
+// updateRegistryMirroringConfigBatch implements UpdateRegistryMirroringConfigBatch. The caller must hold the lock.
+func (s *SystemConfigSyncer) updateRegistryMirroringConfigBatch(owner string, entries []RegistryMirrors) {
+	if s.mirrorsBySource == nil {
+		s.mirrorsBySource = map[string]map[string][]string{}
+		s.sourcesByOwner = map[string]sets.Set[string]{}
+	}
+	previous := s.sourcesByOwner[owner]
+	sources := sets.New[string]()
+	for _, entry := range entries {
+		owners, ok := s.mirrorsBySource[entry.Source]
+		if !ok {
+			owners = map[string][]string{}
+			s.mirrorsBySource[entry.Source] = owners
+		}
+		// the same source can be listed more than once by the owner, e.g., in different mirror sets of an ICSP: the
+		// mirrors of the previous batch are only replaced at its first entry
+		if !sources.Has(entry.Source) {
+			sources.Insert(entry.Source)
+			owners[owner] = nil
+		}
+		owners[owner] = append(owners[owner], entry.Mirrors...)
+	}
+	for source := range previous {
+		if !sources.Has(source) {
+			delete(s.mirrorsBySource[source], owner)
+		}
+	}
+	if len(sources) == 0 {
+		delete(s.sourcesByOwner, owner)
+	} else {
+		s.sourcesByOwner[owner] = sources
+	}
+	for source := range sources.Union(previous) {
+		s.registriesConfContent.getRegistryConfOrCreate(source).Mirrors = s.mirrorsOf(source)
+		if len(s.mirrorsBySource[source]) == 0 {
+			delete(s.mirrorsBySource, source)
+		}
+	}
+	s.onMirrorsChange()
+}
+
+// ownerVersion is the resourceVersion of an ImageContentSourcePolicy whose mirrors were stored or deleted
+type ownerVersion struct {
+	resourceVersion string
+	deleted         bool
+}
+
+// StoreImageContentSourcePolicy replaces the mirrors of the ImageContentSourcePolicy with its repository digest
+// mirrors, as a batch owned by its name. The objects being deleted are skipped: the informer can deliver the update
+// removing their finalizers after their deletion, and their mirrors must not be stored again. The objects older than
+// the last one stored or deleted with the same name are skipped as well.
+func (s *SystemConfigSyncer) StoreImageContentSourcePolicy(icsp *operatorv1alpha1.ImageContentSourcePolicy) error {
+	if icsp.DeletionTimestamp != nil {
+		s.logger.V(4).Info("Skipping the ImageContentSourcePolicy: it is being deleted", "name", icsp.Name)
+		return nil
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	rc := s.registriesConfContent.getRegistryConfOrCreate(registry)
-	rc.Mirrors = mirrors
-	s.ch <- true
+	if s.isStaleOwnerVersion(icsp.Name, icsp.ResourceVersion) {
+		s.logger.V(4).Info("Skipping the ImageContentSourcePolicy: a newer version was already processed",
+			"name", icsp.Name, "resourceVersion", icsp.ResourceVersion)
+		return nil
+	}
+	entries := make([]RegistryMirrors, 0, len(icsp.Spec.RepositoryDigestMirrors))
+	for _, rdm := range icsp.Spec.RepositoryDigestMirrors {
+		entries = append(entries, RegistryMirrors{Source: rdm.Source, Mirrors: rdm.Mirrors})
+	}
+	s.setOwnerVersion(icsp.Name, ownerVersion{resourceVersion: icsp.ResourceVersion})
+	s.updateRegistryMirroringConfigBatch(icsp.Name, entries)
 	return nil
 }
 
+// DeleteImageContentSourcePolicy deletes the mirrors of the ImageContentSourcePolicy. Its resourceVersion is kept as a
+// tombstone, so that the older objects delivered later do not store its mirrors again.
+func (s *SystemConfigSyncer) DeleteImageContentSourcePolicy(icsp *operatorv1alpha1.ImageContentSourcePolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resourceVersion := icsp.ResourceVersion
+	if s.isStaleOwnerVersion(icsp.Name, resourceVersion) {
+		// the mirrors of a newer object with the same name were stored: they are deleted anyway, keeping the newer
+		// resourceVersion as the tombstone
+		resourceVersion = s.ownerVersions[icsp.Name].resourceVersion
+	}
+	s.setOwnerVersion(icsp.Name, ownerVersion{resourceVersion: resourceVersion, deleted: true})
+	s.updateRegistryMirroringConfigBatch(icsp.Name, nil)
+	return nil
+}
+
+// isStaleOwnerVersion returns whether the resourceVersion of the owner is not newer than the last one stored or
+// deleted. The resourceVersions are compared as integers, as set by the API server: the ones that cannot be parsed are
+// never stale.
+func (s *SystemConfigSyncer) isStaleOwnerVersion(owner, resourceVersion string) bool {
+	last, ok := s.ownerVersions[owner]
+	if !ok {
+		return false
+	}
+	current, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return false
+	}
+	previous, err := strconv.ParseUint(last.resourceVersion, 10, 64)
+	if err != nil {
+		return false
+	}
+	return current <= previous
+}
+
+// setOwnerVersion records the version of the owner, evicting the oldest tombstone beyond maxDeletedOwners. The caller
+// must hold the lock.
+func (s *SystemConfigSyncer) setOwnerVersion(owner string, version ownerVersion) {
+	if s.ownerVersions == nil {
+		s.ownerVersions = map[string]ownerVersion{}
+	}
+	s.ownerVersions[owner] = version
+	if !version.deleted {
+		return
+	}
+	deleted, oldest, oldestVersion := 0, "", uint64(math.MaxUint64)
+	for name, v := range s.ownerVersions {
+		if !v.deleted {
+			continue
+		}
+		deleted++
+		// the resourceVersions that cannot be parsed are evicted first
+		if rv, _ := strconv.ParseUint(v.resourceVersion, 10, 64); rv < oldestVersion || oldest == "" {
+			oldest, oldestVersion = name, rv
+		}
+	}
+	if deleted <= maxDeletedOwners {
+		return
+	}
+	delete(s.ownerVersions, oldest)
+}
+
+// mirrorsOf returns the mirrors of the source: the union of the mirrors of its owners, or its bootstrap mirrors if no
+// owner sets mirrors for it.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) mirrorsOf(source string) []string {
+	if len(s.mirrorsBySource[source]) == 0 && s.bootstrap != nil && len(s.bootstrap.mirrors[source]) > 0 {
+		return append([]string{}, s.bootstrap.mirrors[source]...)
+	}
+	return mergeMirrors(s.mirrorsBySource[source])
+}
+
+// mergeMirrors returns the union of the mirrors of the owners, in the order of the owners' names
+func mergeMirrors(mirrorsByOwner map[string][]string) []string {
+	merged := []string{}
+	seen := sets.New[string]()
+	for _, owner := range sets.List(sets.KeySet(mirrorsByOwner)) {
+		for _, mirror := range mirrorsByOwner[owner] {
+			if !seen.Has(mirror) {
+				seen.Insert(mirror)
+				merged = append(merged, mirror)
+			}
+		}
+	}
+	return merged
+}
+
+// DeleteRegistryMirroringConfig deletes the mirrors of the registry set by any owner
 func (s *SystemConfigSyncer) DeleteRegistryMirroringConfig(registry string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if rc, ok := s.registriesConfContent.getRegistryConf(registry); ok {
+		for owner := range s.mirrorsBySource[registry] {
+			s.sourcesByOwner[owner].Delete(registry)
+			if s.sourcesByOwner[owner].Len() == 0 {
+				delete(s.sourcesByOwner, owner)
+			}
+		}
+		delete(s.mirrorsBySource, registry)
 		rc.Mirrors = []string{}
-		s.ch <- true
+		s.onMirrorsChange()
 		return nil
 	}
 	return fmt.Errorf("registry %s not found", registry)
 }
 
+// CleanupRegistryMirroringConfig deletes the mirrors set by the owners. The bootstrap mirrors, if any, are restored.
 func (s *SystemConfigSyncer) CleanupRegistryMirroringConfig() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.mirrorsBySource = nil
+	s.sourcesByOwner = nil
+	s.ownerVersions = nil
 	for _, registry := range s.registriesConfContent.Registries {
-		registry.Mirrors = []string{}
+		registry.Mirrors = s.mirrorsOf(registry.Location)
 	}
-	s.ch <- true
+	s.onMirrorsChange()
 	return nil
 }
 
 func (s *SystemConfigSyncer) sync() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// marshall registries.conf and write to file
-	if err := s.registriesConfContent.writeToFile(); err != nil {
-		klog.Errorf("error writing registries.conf: %v", err)
+	changed, err := s.syncRegistriesConf()
+	if err != nil {
+		s.logger.Error(err, "error writing registries.conf")
 		return err
 	}
+	s.registriesConfChanged = s.registriesConfChanged || changed
 	// marshall policy.json and write to file
-	if err := s.policyConfContent.writeToFile(); err != nil {
-		klog.Errorf("error writing policy.json: %v", err)
+	if err := s.policyConfContent.writeToFile(s.policyConfPath); err != nil {
+		s.logger.Error(err, "error writing policy.json")
 		return err
 	}
 	// delete the certs.d content
-	if err := os.RemoveAll(DockerCertsDir); err != nil {
-		klog.Errorf("error deleting certs.d directory: %v", err)
+	if err := os.RemoveAll(s.dockerCertsDir); err != nil {
+		s.logger.Error(err, "error deleting certs.d directory")
 		return err
 	}
 	// write registry certs to file
 	for _, tuple := range s.registryCertTuples {
-		if err := tuple.writeToFile(); err != nil {
-			klog.Errorf("error writing registry cert: %v", err)
+		if err := tuple.writeToFile(s.dockerCertsDir); err != nil {
+			s.logger.Error(err, "error writing registry cert")
+			return err
+		}
+	}
+	if s.registriesDirPath != "" {
+		if err := s.syncRegistriesDir(); err != nil {
+			s.logger.Error(err, "error writing the registries.d files")
 			return err
 		}
 	}
+	if s.registryCertsChanged {
+		s.registryCertsChanged = false
+		if s.registryCertsObserver != nil {
+			s.registryCertsObserver.OnRegistryCertsChange()
+		}
+	}
 	return nil
 }
 
-// this should launch as a goroutine to consume events from the channel and write to disk
-func (s *SystemConfigSyncer) syncer() {
-	for {
-		select {
-		case <-s.ch:
-			if err := s.sync(); err != nil {
-				klog.Errorf("error syncing system config: %v", err)
-			}
-		}
+// syncRegistriesConf writes the registries.conf file, or the drop-in file in the RegistriesOutputDropIn mode. The
+// drop-in file left by a previous run in the other mode is deleted, as it would override the registries.conf file;
+// the registries.conf file is never deleted, as it is not owned by the syncer in the RegistriesOutputDropIn mode.
+// It returns true if the file of the mode was written or the drop-in file deleted.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) syncRegistriesConf() (bool, error) {
+	dropInPath := filepath.Join(s.registriesConfDropInDir, registriesConfDropInFileName)
+	if s.registriesOutput == RegistriesOutputDropIn {
+		return s.registriesConfContent.writeToFile(dropInPath, RegistriesOutputDropIn)
+	}
+	deleted := true
+	if err := os.Remove(dropInPath); os.IsNotExist(err) {
+		deleted = false
+	} else if err != nil {
+		return false, err
 	}
+	written, err := s.registriesConfContent.writeToFile(s.registriesConfPath, RegistriesOutputFull)
+	return deleted || written, err
 }
 
-// newSystemConfigSyncer creates a new SystemConfigSyncer object
-func newSystemConfigSyncer() IConfigSyncer {
-	ic := &SystemConfigSyncer{
-		registriesConfContent: defaultRegistriesConf(),
-		policyConfContent:     defaultPolicyConf(),
-		registryCertTuples:    []registryCertTuple{},
-		ch:                    make(chan bool),
+// syncRegistriesDir writes the YAML files of the sigstore attachments configurations that changed and deletes the ones
+// of the registries that are no longer configured.
+// It expects the caller to hold the lock.
+func (s *SystemConfigSyncer) syncRegistriesDir() error {
+	files := sets.New[string]()
+	for _, conf := range s.sigstoreAttachmentConfs {
+		data, err := conf.marshal()
+		if err != nil {
+			return err
+		}
+		files.Insert(conf.fileName())
+		if _, err := writeFileIfChanged(filepath.Join(s.registriesDirPath, conf.fileName()), data); err != nil {
+			return err
+		}
 	}
-	go ic.syncer()
-	ctx := context.Background()
+	existing, err := filepath.Glob(filepath.Join(s.registriesDirPath, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if files.Has(filepath.Base(path)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=config.openshift.io,resources=images,verbs=get;list;watch
+
+// registerEventHandlers subscribes the syncer to the image-registry-certificates configmap and the
+// image.config.openshift.io/cluster object. It tries to register all the watchers and logs which of them succeeded
+// before returning the errors, if any.
+func registerEventHandlers(ctx context.Context, s *SystemConfigSyncer) error {
+	var registered []string
+	var errs []error
+	s.mu.Lock()
+	periods := s.resyncPeriods.jittered()
+	s.mu.Unlock()
+	s.logger.V(2).Info("Resync periods of the system config watchers", "registryCertificates",
+		periods.RegistryCerts, "imageConfig", periods.Image, "sigstoreAttachments", periods.SigstoreAttachments)
 	err := core.NewSingleObjectEventHandler[*v1.ConfigMap, *v1.ConfigMapList](ctx,
-		"image-registry-certificates", "openshift-image-registry",
-		time.Hour, func(et watch.EventType, cm *v1.ConfigMap) {
+		registryCertsConfigMapName, registryCertsConfigMapNamespace,
+		periods.RegistryCerts, func(et watch.EventType, cm *v1.ConfigMap) error {
 			if et == watch.Deleted || et == watch.Bookmark {
-				klog.Warningf("Ignoring event type: %+v", et)
-				return
+				s.logger.Info("Ignoring event type", "type", et)
+				return nil
 			}
-			klog.Warningln("the image-registry-certificates configmap has been updated.")
-			err := ic.StoreRegistryCerts(parseRegistryCerts(cm))
-			if err != nil {
-				klog.Warningf("error updating registry certs: %w", err)
-				return
+			s.logger.Info("The image-registry-certificates configmap has been updated")
+			if err := s.StoreRegistryCerts(parseRegistryCerts(cm)); err != nil {
+				return fmt.Errorf("error updating registry certs: %w", err)
 			}
+			return nil
 		}, nil)
 	if err != nil {
-		klog.Fatalf("error registering handler for the configmap image-registry-certificates: %w", err)
+		errs = append(errs, fmt.Errorf("error registering handler for the configmap image-registry-certificates: %w", err))
+	} else {
+		registered = append(registered, "configmaps/openshift-image-registry/image-registry-certificates")
 	}
-	err = ocpv1.AddToScheme(scheme.Scheme)
-	if err != nil {
-		return nil
+	if err = ocpv1.AddToScheme(scheme.Scheme); err != nil {
+		return err
 	}
 
 	err = core.NewSingleObjectEventHandler[*ocpv1.Image, *ocpv1.ImageList](ctx,
-		"cluster", "", time.Hour,
-		func(et watch.EventType, image *ocpv1.Image) {
+		"cluster", "", periods.Image,
+		func(et watch.EventType, image *ocpv1.Image) error {
 			if et == watch.Deleted || et == watch.Bookmark {
-				klog.Warningf("Ignoring event type: %+v", et)
-				return
+				s.logger.Info("Ignoring event type", "type", et)
+				return nil
 			}
-			klog.Warningln("the image.config.openshift.io/cluster object has been updated.")
-			err := ic.StoreImageRegistryConf(image.Spec.RegistrySources.AllowedRegistries,
+			s.logger.Info("The image.config.openshift.io/cluster object has been updated")
+			err := s.StoreImageRegistryConf(image.Spec.RegistrySources.AllowedRegistries,
 				image.Spec.RegistrySources.BlockedRegistries, image.Spec.RegistrySources.InsecureRegistries)
 			if err != nil {
-				klog.Warningf("error updating registry conf: %w", err)
-				return
+				return fmt.Errorf("error updating registry conf: %w", err)
 			}
+			return nil
 		}, nil)
 	if err != nil {
-		klog.Fatalf("error registering handler for the image.config.openshift.io/cluster object: %w", err)
+		errs = append(errs, fmt.Errorf("error registering handler for the image.config.openshift.io/cluster object: %w", err))
+	} else {
+		registered = append(registered, "images.config.openshift.io/cluster")
 	}
-
-	return ic
+	if configMap := s.sigstoreAttachmentsConfigMap; configMap.Name != "" {
+		err = core.NewSingleObjectEventHandler[*v1.ConfigMap, *v1.ConfigMapList](ctx,
+			configMap.Name, configMap.Namespace, periods.SigstoreAttachments, func(et watch.EventType, cm *v1.ConfigMap) error {
+				switch et {
+				case watch.Bookmark:
+					return nil
+				case watch.Deleted:
+					s.logger.Info("The sigstore attachments configmap has been deleted", "configmap", configMap)
+					s.storeSigstoreAttachmentConfigs(nil)
+					return nil
+				}
+				s.logger.Info("The sigstore attachments configmap has been updated", "configmap", configMap)
+				confs, err := parseSigstoreAttachmentConfs(cm)
+				// the valid entries are applied anyway, so that an invalid entry does not block the others
+				s.storeSigstoreAttachmentConfigs(confs)
+				return err
+			}, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error registering handler for the configmap %s: %w", configMap, err))
+		} else {
+			registered = append(registered, "configmaps/"+configMap.String())
+		}
+	}
+	s.logger.Info("Registered the system config watchers", "watchers", registered, "failed", len(errs))
+	return utilerrors.NewAggregate(errs)
 }
 
+// parseRegistryCerts returns the certificates of the additional trusted CA ConfigMap. Its keys are the registry
+// hostnames, with the port number after two dots, e.g., registry.example.com..5000. The ConfigMap can hold other
+// certificates, e.g., updateservice-registry for the update service: the keys that are not a registry hostname are
+// skipped, as a certs.d folder named after them would be invalid.
 func parseRegistryCerts(cm *v1.ConfigMap) []registryCertTuple {
 	var registryCertTuples []registryCertTuple
 	for k, v := range cm.Data {
+		if !isRegistryCertsKey(k) {
+			klog.V(4).Infof("Skipping the key %s of the configmap %s/%s: it is not a registry hostname", k,
+				cm.Namespace, cm.Name)
+			skippedRegistryCertsKeys.Inc()
+			continue
+		}
 		registryCertTuples = append(registryCertTuples, registryCertTuple{
 			registry: k,
 			cert:     v,
@@ -213,3 +1060,49 @@ func parseRegistryCerts(cm *v1.ConfigMap) []registryCertTuple {
 	}
 	return registryCertTuples
 }
+
+// isRegistryCertsKey returns true if the key of the additional trusted CA ConfigMap is a registry hostname: an IP
+// address, localhost or a DNS name of at least two labels, optionally followed by two dots and a port number. Any
+// hostname is accepted with a port number, as no other certificate key has one.
+func isRegistryCertsKey(key string) bool {
+	host, port, hasPort := strings.Cut(key, "..")
+	if hasPort {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return false
+		}
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if len(validation.IsDNS1123Subdomain(strings.ToLower(host))) > 0 {
+		return false
+	}
+	return hasPort || host == "localhost" || strings.Contains(host, ".")
+}
+
+// sigstoreAttachmentEntry is a value of the sigstore attachments ConfigMap
+type sigstoreAttachmentEntry struct {
+	UseSigstoreAttachments bool   `json:"useSigstoreAttachments"`
+	Lookaside              string `json:"lookaside"`
+}
+
+// parseSigstoreAttachmentConfs parses the sigstore attachments ConfigMap. The invalid entries are skipped and
+// reported in the returned error.
+func parseSigstoreAttachmentConfs(cm *v1.ConfigMap) ([]sigstoreAttachmentConf, error) {
+	var confs []sigstoreAttachmentConf
+	var errs []error
+	for key, value := range cm.Data {
+		entry := sigstoreAttachmentEntry{}
+		if err := yaml.UnmarshalStrict([]byte(value), &entry); err != nil {
+			errs = append(errs, fmt.Errorf("invalid sigstore attachments configuration for %s: %w", key, err))
+			continue
+		}
+		confs = append(confs, sigstoreAttachmentConf{
+			// the registry name could report the port number after two dots, as in the registry certs configmap
+			registry:       strings.Replace(key, "..", ":", 1),
+			useAttachments: entry.UseSigstoreAttachments,
+			lookasideURL:   entry.Lookaside,
+		})
+	}
+	return confs, utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,1160 @@
+package system_config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	ocpv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	testingclock "k8s.io/utils/clock/testing"
+	"multiarch-operator/pkg/testenv"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+type blockedRegistriesChange struct {
+	blocked []string
+	added   []string
+}
+
+type fakeBlockedRegistriesObserver struct {
+	changes []blockedRegistriesChange
+}
+
+func (o *fakeBlockedRegistriesObserver) OnBlockedRegistriesChange(blocked []string, added []string) {
+	o.changes = append(o.changes, blockedRegistriesChange{blocked: blocked, added: added})
+}
+
+type fakeRegistryCertsObserver struct {
+	changes atomic.Int32
+}
+
+func (o *fakeRegistryCertsObserver) OnRegistryCertsChange() {
+	o.changes.Add(1)
+}
+
+// newIsolatedSyncer returns a SystemConfigSyncer writing its files to a temporary directory of the spec, and the
+// directory
+func newIsolatedSyncer(options ...func(*SystemConfigSyncerOptions)) (*SystemConfigSyncer, string) {
+	dir := GinkgoT().TempDir()
+	syncerOptions := SystemConfigSyncerOptions{
+		RegistriesConfPath:      filepath.Join(dir, "registries.conf"),
+		RegistriesConfDropInDir: filepath.Join(dir, "registries.conf.d"),
+		PolicyConfPath:          filepath.Join(dir, "policy.json"),
+		DockerCertsDir:          filepath.Join(dir, "certs.d"),
+		RegistriesDirPath:       filepath.Join(dir, "registries.d"),
+	}
+	for _, option := range options {
+		option(&syncerOptions)
+	}
+	s, err := NewSystemConfigSyncer(syncerOptions)
+	Expect(err).NotTo(HaveOccurred())
+	return s, dir
+}
+
+var _ = Describe("The SystemConfigSyncer blocked registries", func() {
+	var (
+		s        *SystemConfigSyncer
+		observer *fakeBlockedRegistriesObserver
+	)
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer()
+		observer = &fakeBlockedRegistriesObserver{}
+	})
+
+	It("should not report the registries blocked at startup as added", func() {
+		s.updateBlockedRegistries([]string{"docker.io"})
+		s.SetBlockedRegistriesObserver(observer)
+		s.updateBlockedRegistries([]string{"docker.io"})
+		s.updateBlockedRegistries([]string{"docker.io", "quay.io"})
+		Expect(observer.changes).To(HaveLen(2))
+		Expect(observer.changes[0].blocked).To(Equal([]string{"docker.io"}))
+		Expect(observer.changes[0].added).To(BeEmpty())
+		Expect(observer.changes[1].added).To(Equal([]string{"quay.io"}))
+	})
+
+	It("should use the first sync as baseline when the observer is registered earlier", func() {
+		s.SetBlockedRegistriesObserver(observer)
+		s.updateBlockedRegistries([]string{"docker.io"})
+		s.updateBlockedRegistries([]string{})
+		s.updateBlockedRegistries([]string{"docker.io"})
+		Expect(observer.changes).To(HaveLen(4))
+		Expect(observer.changes[1].added).To(BeEmpty())
+		Expect(observer.changes[2].blocked).To(BeEmpty())
+		Expect(observer.changes[3].added).To(Equal([]string{"docker.io"}))
+	})
+})
+
+var _ = Describe("The SystemConfigSyncer image registry configuration", func() {
+	var (
+		s        *SystemConfigSyncer
+		observer *fakeBlockedRegistriesObserver
+	)
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer()
+		observer = &fakeBlockedRegistriesObserver{}
+		s.SetBlockedRegistriesObserver(observer)
+	})
+
+	// storeImageRegistryConf calls StoreImageRegistryConf and consumes the sync request it sends
+	storeImageRegistryConf := func(allowedRegistries, blockedRegistries, insecureRegistries []string) {
+		Expect(s.StoreImageRegistryConf(allowedRegistries, blockedRegistries, insecureRegistries)).To(Succeed())
+		Expect(s.ch).To(Receive())
+	}
+
+	It("should store the blocked registries and notify the observer", func() {
+		storeImageRegistryConf(nil, []string{"docker.io"}, []string{"registry.example.com:5000"})
+		storeImageRegistryConf(nil, []string{"docker.io", "quay.io"}, nil)
+		Expect(observer.changes).To(HaveLen(3))
+		Expect(observer.changes[1].blocked).To(Equal([]string{"docker.io"}))
+		Expect(observer.changes[1].added).To(BeEmpty())
+		Expect(observer.changes[2].blocked).To(Equal([]string{"docker.io", "quay.io"}))
+		Expect(observer.changes[2].added).To(Equal([]string{"quay.io"}))
+		rc, ok := s.registriesConfContent.getRegistryConf("quay.io")
+		Expect(ok).To(BeTrue())
+		Expect(rc.Blocked).NotTo(BeNil())
+		Expect(*rc.Blocked).To(BeTrue())
+		rc, ok = s.registriesConfContent.getRegistryConf("registry.example.com:5000")
+		Expect(ok).To(BeTrue())
+		Expect(rc.Insecure).To(BeNil())
+		Expect(s.registriesConfContent.Registries).To(HaveLen(3))
+	})
+
+	It("should stop rejecting the registries that are no longer blocked", func() {
+		storeImageRegistryConf(nil, []string{"quay.io", "docker.io", "registry.example.com:5000", "gcr.io/project"}, nil)
+		data, err := s.policyConfContent.marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(append(data, '\n'))).To(Equal(string(readGoldenFile("policy-multiple-blocked.json"))))
+
+		storeImageRegistryConf(nil, []string{"docker.io"}, nil)
+		data, err = s.policyConfContent.marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(append(data, '\n'))).To(Equal(string(readGoldenFile("policy-one-blocked.json"))))
+		rc, ok := s.registriesConfContent.getRegistryConf("quay.io")
+		Expect(ok).To(BeTrue())
+		Expect(rc.Blocked).To(BeNil())
+	})
+
+	It("should reject allowed and blocked registries set together", func() {
+		Expect(s.StoreImageRegistryConf([]string{"quay.io"}, []string{"docker.io"}, nil)).NotTo(Succeed())
+		Expect(s.ch).NotTo(Receive())
+	})
+})
+
+var _ = Describe("The SystemConfigSyncer mirrors of the blocked registries", func() {
+	var s *SystemConfigSyncer
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer()
+		s.SetBlockMirrorsOfBlockedRegistries(true)
+		Expect(s.ch).To(Receive())
+	})
+
+	storeBlockedRegistries := func(blockedRegistries ...string) {
+		Expect(s.StoreImageRegistryConf(nil, blockedRegistries, nil)).To(Succeed())
+		Expect(s.ch).To(Receive())
+	}
+
+	updateMirrors := func(registry string, mirrors ...string) {
+		Expect(s.UpdateRegistryMirroringConfig(registry, mirrors)).To(Succeed())
+		Expect(s.ch).To(Receive())
+	}
+
+	expectBlocked := func(registry string, blocked bool) {
+		rc, ok := s.registriesConfContent.getRegistryConf(registry)
+		Expect(ok && rc.Blocked != nil && *rc.Blocked).To(Equal(blocked), "registries.conf, registry %s", registry)
+		for _, transport := range []string{dockerTransport, atomicTransport} {
+			if blocked {
+				Expect(s.policyConfContent.transports()[transport]).To(HaveKeyWithValue(registry,
+					[]policyEntry{rejectPolicyEntry()}), "policy.json, registry %s", registry)
+			} else {
+				Expect(s.policyConfContent.transports()[transport]).NotTo(HaveKey(registry),
+					"policy.json, registry %s", registry)
+			}
+		}
+	}
+
+	It("should block the mirrors added after the registry is blocked", func() {
+		storeBlockedRegistries("docker.io")
+		expectBlocked("mirror.example.com/docker", false)
+		updateMirrors("docker.io", "mirror.example.com/docker")
+		expectBlocked("docker.io", true)
+		expectBlocked("mirror.example.com/docker", true)
+	})
+
+	It("should block the mirrors added before the registry is blocked", func() {
+		updateMirrors("docker.io", "mirror.example.com/docker", "mirror.example.org/docker")
+		updateMirrors("quay.io", "mirror.example.com/quay")
+		expectBlocked("mirror.example.com/docker", false)
+		storeBlockedRegistries("docker.io")
+		expectBlocked("mirror.example.com/docker", true)
+		expectBlocked("mirror.example.org/docker", true)
+		expectBlocked("mirror.example.com/quay", false)
+	})
+
+	It("should unblock the mirrors when their registry is unblocked or they are removed", func() {
+		updateMirrors("docker.io", "mirror.example.com/docker")
+		updateMirrors("quay.io", "mirror.example.com/quay")
+		storeBlockedRegistries("docker.io", "quay.io")
+		expectBlocked("mirror.example.com/quay", true)
+		storeBlockedRegistries("docker.io")
+		expectBlocked("mirror.example.com/quay", false)
+		Expect(s.DeleteRegistryMirroringConfig("docker.io")).To(Succeed())
+		Expect(s.ch).To(Receive())
+		expectBlocked("docker.io", true)
+		expectBlocked("mirror.example.com/docker", false)
+	})
+
+	It("should only block the mirrors when enabled", func() {
+		updateMirrors("docker.io", "mirror.example.com/docker")
+		storeBlockedRegistries("docker.io")
+		expectBlocked("mirror.example.com/docker", true)
+		s.SetBlockMirrorsOfBlockedRegistries(false)
+		Expect(s.ch).To(Receive())
+		expectBlocked("docker.io", true)
+		expectBlocked("mirror.example.com/docker", false)
+	})
+})
+
+var _ = Describe("The SystemConfigSyncer sections of policy.json", func() {
+	var s *SystemConfigSyncer
+
+	signatures := func() policyTransports {
+		return policyTransports{
+			dockerTransport: {
+				"docker.io":                   []policyEntry{{Type: "sigstoreSigned"}},
+				"registry.example.com/signed": []policyEntry{{Type: "sigstoreSigned"}},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer()
+		s.mu.Lock()
+		s.policyConfContent.setSection(policySectionSignatures, signatures())
+		s.mu.Unlock()
+	})
+
+	It("should keep the sections owned by the other paths across arbitrary call orderings", func() {
+		registries := []string{"docker.io", "quay.io", "gcr.io"}
+		// the model of the state stored by the calls
+		var blocked []string
+		mirrors := map[string][]string{}
+		blockMirrors := false
+
+		expectedTransports := func() policyTransports {
+			expected := defaultTransports()
+			expected[dockerTransport] = map[string][]policyEntry{}
+			for scope, entries := range signatures()[dockerTransport] {
+				expected[dockerTransport][scope] = entries
+			}
+			for _, registry := range blocked {
+				rejected := []string{registry}
+				if blockMirrors {
+					rejected = append(rejected, mirrors[registry]...)
+				}
+				for _, scope := range rejected {
+					expected[dockerTransport][scope] = []policyEntry{rejectPolicyEntry()}
+				}
+			}
+			// the atomic transport always has the entries of the docker transport
+			expected[atomicTransport] = expected[dockerTransport]
+			return expected
+		}
+
+		operations := []func(r *rand.Rand) string{
+			func(r *rand.Rand) string {
+				blocked = nil
+				for _, registry := range registries {
+					if r.Intn(2) == 0 {
+						blocked = append(blocked, registry)
+					}
+				}
+				Expect(s.StoreImageRegistryConf(nil, blocked, nil)).To(Succeed())
+				return fmt.Sprintf("StoreImageRegistryConf(%v)", blocked)
+			},
+			func(r *rand.Rand) string {
+				registry := registries[r.Intn(len(registries))]
+				mirrors[registry] = []string{fmt.Sprintf("mirror-%d.example.com/%s", r.Intn(100), registry)}
+				Expect(s.UpdateRegistryMirroringConfig(registry, mirrors[registry])).To(Succeed())
+				return fmt.Sprintf("UpdateRegistryMirroringConfig(%s, %v)", registry, mirrors[registry])
+			},
+			func(r *rand.Rand) string {
+				registry := registries[r.Intn(len(registries))]
+				delete(mirrors, registry)
+				// the registry is not found when it was never stored
+				_ = s.DeleteRegistryMirroringConfig(registry)
+				return fmt.Sprintf("DeleteRegistryMirroringConfig(%s)", registry)
+			},
+			func(r *rand.Rand) string {
+				mirrors = map[string][]string{}
+				Expect(s.CleanupRegistryMirroringConfig()).To(Succeed())
+				return "CleanupRegistryMirroringConfig()"
+			},
+			func(r *rand.Rand) string {
+				blockMirrors = r.Intn(2) == 0
+				s.SetBlockMirrorsOfBlockedRegistries(blockMirrors)
+				return fmt.Sprintf("SetBlockMirrorsOfBlockedRegistries(%t)", blockMirrors)
+			},
+		}
+
+		r := rand.New(rand.NewSource(GinkgoRandomSeed()))
+		var calls []string
+		for i := 0; i < 500; i++ {
+			calls = append(calls, operations[r.Intn(len(operations))](r))
+			select {
+			case <-s.ch:
+			default:
+			}
+			s.mu.Lock()
+			sections := s.policyConfContent.sections
+			transports := s.policyConfContent.transports()
+			s.mu.Unlock()
+			Expect(sections[policySectionDefaults]).To(Equal(defaultTransports()), "calls: %v", calls)
+			Expect(sections[policySectionSignatures]).To(Equal(signatures()), "calls: %v", calls)
+			Expect(transports).To(Equal(expectedTransports()), "calls: %v", calls)
+		}
+	})
+
+	It("should render the signature policies of the registries no longer blocked", func() {
+		Expect(s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)).To(Succeed())
+		Expect(s.policyConfContent.transports()[dockerTransport]).To(HaveKeyWithValue("docker.io",
+			[]policyEntry{rejectPolicyEntry()}))
+		Expect(s.StoreImageRegistryConf(nil, nil, nil)).To(Succeed())
+		Expect(s.policyConfContent.transports()[dockerTransport]).To(HaveKeyWithValue("docker.io",
+			[]policyEntry{{Type: "sigstoreSigned"}}))
+		Expect(s.policyConfContent.transports()[atomicTransport]).To(HaveKeyWithValue("docker.io",
+			[]policyEntry{{Type: "sigstoreSigned"}}))
+	})
+})
+
+// mirrorSources returns n sources with two mirrors each, as listed by a large ImageContentSourcePolicy
+func mirrorSources(n int) []RegistryMirrors {
+	entries := make([]RegistryMirrors, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, RegistryMirrors{
+			Source:  fmt.Sprintf("registry.example.com/team-%d/app", i),
+			Mirrors: []string{fmt.Sprintf("mirror.example.com/team-%d/app", i), fmt.Sprintf("mirror.example.org/team-%d/app", i)},
+		})
+	}
+	return entries
+}
+
+var _ = Describe("The SystemConfigSyncer batches of mirrors", func() {
+	var s *SystemConfigSyncer
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer()
+		s.SetBlockMirrorsOfBlockedRegistries(true)
+		Expect(s.ch).To(Receive())
+		Expect(s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)).To(Succeed())
+		Expect(s.ch).To(Receive())
+	})
+
+	updateBatch := func(owner string, entries ...RegistryMirrors) {
+		Expect(s.UpdateRegistryMirroringConfigBatch(owner, entries)).To(Succeed())
+		Expect(s.ch).To(Receive())
+		Expect(s.ch).NotTo(Receive())
+	}
+
+	mirrorsOf := func(registry string) []string {
+		rc, ok := s.registriesConfContent.getRegistryConf(registry)
+		Expect(ok).To(BeTrue())
+		return rc.Mirrors
+	}
+
+	It("should merge the mirrors of the owners of a source in the order of their names", func() {
+		updateBatch("icsp-b", RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.org/docker"}})
+		updateBatch("icsp-a",
+			RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.com/docker"}},
+			RegistryMirrors{Source: "quay.io", Mirrors: []string{"mirror.example.com/quay"}},
+			// the same source in another mirror set of the owner
+			RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.org/docker"}})
+		Expect(mirrorsOf("docker.io")).To(Equal([]string{"mirror.example.com/docker", "mirror.example.org/docker"}))
+		Expect(mirrorsOf("quay.io")).To(Equal([]string{"mirror.example.com/quay"}))
+		// the mirrors of the blocked registries are blocked by the same batch
+		rc, _ := s.registriesConfContent.getRegistryConf("mirror.example.org/docker")
+		Expect(rc.Blocked).NotTo(BeNil())
+	})
+
+	It("should replace the mirrors of the owner with its last batch", func() {
+		updateBatch("icsp-a",
+			RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.com/docker"}},
+			RegistryMirrors{Source: "quay.io", Mirrors: []string{"mirror.example.com/quay"}})
+		updateBatch("icsp-b", RegistryMirrors{Source: "quay.io", Mirrors: []string{"mirror.example.org/quay"}})
+		updateBatch("icsp-a", RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.net/docker"}})
+		Expect(mirrorsOf("docker.io")).To(Equal([]string{"mirror.example.net/docker"}))
+		Expect(mirrorsOf("quay.io")).To(Equal([]string{"mirror.example.org/quay"}))
+		updateBatch("icsp-a")
+		Expect(mirrorsOf("docker.io")).To(BeEmpty())
+		Expect(s.sourcesByOwner).To(HaveLen(1))
+	})
+
+	It("should delete the mirrors of all the owners of a registry", func() {
+		updateBatch("icsp-a", RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.com/docker"}})
+		updateBatch("icsp-b", RegistryMirrors{Source: "docker.io", Mirrors: []string{"mirror.example.org/docker"}})
+		Expect(s.DeleteRegistryMirroringConfig("docker.io")).To(Succeed())
+		Expect(s.ch).To(Receive())
+		Expect(mirrorsOf("docker.io")).To(BeEmpty())
+		Expect(s.sourcesByOwner).To(BeEmpty())
+		updateBatch("icsp-b", RegistryMirrors{Source: "quay.io", Mirrors: []string{"mirror.example.org/quay"}})
+		Expect(mirrorsOf("docker.io")).To(BeEmpty())
+	})
+
+	Context("of the ImageContentSourcePolicy objects", func() {
+		icsp := func(resourceVersion string, mirrors ...string) *operatorv1alpha1.ImageContentSourcePolicy {
+			return &operatorv1alpha1.ImageContentSourcePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp", ResourceVersion: resourceVersion},
+				Spec: operatorv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []operatorv1alpha1.RepositoryDigestMirrors{{Source: "quay.io", Mirrors: mirrors}},
+				},
+			}
+		}
+
+		It("should not store the mirrors of the objects being deleted", func() {
+			Expect(s.StoreImageContentSourcePolicy(icsp("10", "mirror.example.com/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(Equal([]string{"mirror.example.com/quay"}))
+			// the removal of the finalizers is delivered as an update after the deletion is requested
+			deleting := icsp("11", "mirror.example.com/quay")
+			deleting.DeletionTimestamp = &metav1.Time{}
+			deleting.Finalizers = nil
+			Expect(s.StoreImageContentSourcePolicy(deleting)).To(Succeed())
+			Expect(s.DeleteImageContentSourcePolicy(deleting)).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(BeEmpty())
+			Expect(s.sourcesByOwner).To(BeEmpty())
+			// the update delivered after the deletion
+			Expect(s.StoreImageContentSourcePolicy(deleting)).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(BeEmpty())
+		})
+
+		It("should ignore the objects delivered after a newer version", func() {
+			Expect(s.StoreImageContentSourcePolicy(icsp("12", "mirror.example.org/quay"))).To(Succeed())
+			Expect(s.StoreImageContentSourcePolicy(icsp("10", "mirror.example.com/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(Equal([]string{"mirror.example.org/quay"}))
+
+			Expect(s.DeleteImageContentSourcePolicy(icsp("13", "mirror.example.org/quay"))).To(Succeed())
+			Expect(s.StoreImageContentSourcePolicy(icsp("12", "mirror.example.org/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(BeEmpty())
+			// a new object with the same name is stored
+			Expect(s.StoreImageContentSourcePolicy(icsp("14", "mirror.example.net/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(Equal([]string{"mirror.example.net/quay"}))
+		})
+
+		It("should delete the mirrors of a deletion delivered before the last update", func() {
+			Expect(s.StoreImageContentSourcePolicy(icsp("12", "mirror.example.org/quay"))).To(Succeed())
+			Expect(s.DeleteImageContentSourcePolicy(icsp("11", "mirror.example.org/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(BeEmpty())
+			Expect(s.StoreImageContentSourcePolicy(icsp("12", "mirror.example.org/quay"))).To(Succeed())
+			Expect(mirrorsOf("quay.io")).To(BeEmpty())
+		})
+
+		It("should bound the tombstones of the deleted objects", func() {
+			for i := 1; i <= maxDeletedOwners+10; i++ {
+				deleted := icsp(strconv.Itoa(i))
+				deleted.Name = fmt.Sprintf("icsp-%d", i)
+				Expect(s.DeleteImageContentSourcePolicy(deleted)).To(Succeed())
+			}
+			Expect(s.ownerVersions).To(HaveLen(maxDeletedOwners))
+			Expect(s.ownerVersions).NotTo(HaveKey("icsp-10"))
+			Expect(s.ownerVersions).To(HaveKey("icsp-11"))
+		})
+	})
+
+	It("should set the mirrors of a single registry through a batch", func() {
+		Expect(s.UpdateRegistryMirroringConfig("docker.io", []string{"mirror.example.com/docker"})).To(Succeed())
+		Expect(s.ch).To(Receive())
+		Expect(mirrorsOf("docker.io")).To(Equal([]string{"mirror.example.com/docker"}))
+		Expect(s.sourcesByOwner).To(HaveKeyWithValue("docker.io", sets.New("docker.io")))
+	})
+
+	It("should render registries.conf within its size hint", func() {
+		updateBatch("icsp", mirrorSources(5000)...)
+		buffer := &bytes.Buffer{}
+		Expect(toml.NewEncoder(buffer).Encode(&s.registriesConfContent)).To(Succeed())
+		Expect(s.registriesConfContent.renderedSizeHint()).To(BeNumerically(">=", buffer.Len()))
+	})
+
+	It("should apply the mirrors of 5000 sources in a batch as one source at a time", func() {
+		entries := mirrorSources(5000)
+		for _, entry := range entries {
+			Expect(s.UpdateRegistryMirroringConfig(entry.Source, entry.Mirrors)).To(Succeed())
+		}
+		oneAtATime := make([][]string, 0, len(entries))
+		for _, entry := range entries {
+			oneAtATime = append(oneAtATime, mirrorsOf(entry.Source))
+		}
+		Expect(s.CleanupRegistryMirroringConfig()).To(Succeed())
+		updateBatch("icsp", entries...)
+		for i, entry := range entries {
+			Expect(mirrorsOf(entry.Source)).To(Equal(oneAtATime[i]), entry.Source)
+		}
+		Expect(mirrorsOf(entries[4999].Source)).To(Equal(entries[4999].Mirrors))
+	})
+})
+
+// BenchmarkRegistryMirroringConfig applies the mirrors of 5000 sources one source at a time and in a batch
+func BenchmarkRegistryMirroringConfig(b *testing.B) {
+	entries := mirrorSources(5000)
+	newSyncer := func(b *testing.B) *SystemConfigSyncer {
+		dir := b.TempDir()
+		s, err := NewSystemConfigSyncer(SystemConfigSyncerOptions{
+			RegistriesConfPath:      filepath.Join(dir, "registries.conf"),
+			RegistriesConfDropInDir: filepath.Join(dir, "registries.conf.d"),
+			PolicyConfPath:          filepath.Join(dir, "policy.json"),
+			DockerCertsDir:          filepath.Join(dir, "certs.d"),
+			RegistriesDirPath:       filepath.Join(dir, "registries.d"),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		return s
+	}
+	b.Run("one source at a time", func(b *testing.B) {
+		s := newSyncer(b)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			for _, entry := range entries {
+				if err := s.UpdateRegistryMirroringConfig(entry.Source, entry.Mirrors); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := s.CleanupRegistryMirroringConfig(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		s := newSyncer(b)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			if err := s.UpdateRegistryMirroringConfigBatch("icsp", entries); err != nil {
+				b.Fatal(err)
+			}
+			if err := s.CleanupRegistryMirroringConfig(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+var _ = Describe("The SystemConfigSyncer lifecycle", func() {
+	var (
+		s      *SystemConfigSyncer
+		dir    string
+		ctx    context.Context
+		cancel context.CancelFunc
+		done   chan error
+	)
+
+	BeforeEach(func() {
+		s, dir = newIsolatedSyncer()
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			// emulate the initial get of the image.config.openshift.io/cluster object
+			return s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan error, 1)
+		DeferCleanup(cancel)
+	})
+
+	start := func() {
+		go func() {
+			done <- s.Start(ctx)
+		}()
+	}
+
+	readFile := func(name string) func() string {
+		return func() string {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			return string(data)
+		}
+	}
+
+	It("should write the files at startup", func() {
+		start()
+		Eventually(readFile("policy.json")).Should(Equal(string(readGoldenFile("policy-one-blocked.json"))))
+		Eventually(readFile("registries.conf")).Should(ContainSubstring(`location = "docker.io"`))
+	})
+
+	It("should sync the files when the configuration changes", func() {
+		start()
+		Eventually(readFile("policy.json")).Should(Equal(string(readGoldenFile("policy-one-blocked.json"))))
+		Expect(s.StoreRegistryCerts([]registryCertTuple{{registry: "registry.example.com..5000", cert: "cert"}})).To(Succeed())
+		Eventually(readFile("certs.d/registry.example.com:5000/ca.crt")).Should(Equal("cert"))
+		Expect(s.StoreImageRegistryConf(nil, nil, nil)).To(Succeed())
+		Eventually(readFile("policy.json")).Should(Equal(string(readGoldenFile("policy-default.json"))))
+	})
+
+	It("should converge to the last state stored by concurrent writers", func() {
+		start()
+		Eventually(readFile("policy.json")).ShouldNot(BeEmpty())
+		const writers, iterations = 8, 200
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(writer int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < iterations; j++ {
+					registry := fmt.Sprintf("registry-%d-%d.example.com", writer, j)
+					Expect(s.StoreImageRegistryConf(nil, []string{registry}, nil)).To(Succeed())
+					Expect(s.StoreRegistryCerts([]registryCertTuple{{registry: registry, cert: registry}})).To(Succeed())
+				}
+			}(i)
+		}
+		// the Store calls never wait for the sync in progress
+		stored := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(stored)
+		}()
+		Eventually(stored).Should(BeClosed())
+
+		s.mu.Lock()
+		policy, err := s.policyConfContent.marshal()
+		certs := append([]registryCertTuple{}, s.registryCertTuples...)
+		s.mu.Unlock()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(1))
+		Eventually(readFile("policy.json")).Should(Equal(string(policy) + "\n"))
+		Eventually(readFile(filepath.Join("certs.d", certs[0].registry, "ca.crt"))).Should(Equal(certs[0].cert))
+		entries, err := os.ReadDir(filepath.Join(dir, "certs.d"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(readFile("registries.conf")()).To(ContainSubstring(fmt.Sprintf("location = %q", certs[0].registry)))
+	})
+
+	It("should notify the changes of the registry certificates once they are written", func() {
+		observer := &fakeRegistryCertsObserver{}
+		s.SetRegistryCertsObserver(observer)
+		start()
+		Eventually(readFile("policy.json")).ShouldNot(BeEmpty())
+		Expect(observer.changes.Load()).To(BeZero())
+		certs := []registryCertTuple{{registry: "a.example.com", cert: "a"}, {registry: "b.example.com", cert: "b"}}
+		Expect(s.StoreRegistryCerts(certs)).To(Succeed())
+		Eventually(observer.changes.Load).Should(Equal(int32(1)))
+		Expect(readFile("certs.d/a.example.com/ca.crt")()).To(Equal("a"))
+		// the ConfigMap resyncs list the same certificates in any order
+		Expect(s.StoreRegistryCerts([]registryCertTuple{certs[1], certs[0]})).To(Succeed())
+		Expect(s.StoreImageRegistryConf(nil, nil, nil)).To(Succeed())
+		Eventually(readFile("policy.json")).Should(Equal(string(readGoldenFile("policy-default.json"))))
+		Expect(observer.changes.Load()).To(Equal(int32(1)))
+		Expect(s.StoreRegistryCerts(certs[:1])).To(Succeed())
+		Eventually(observer.changes.Load).Should(Equal(int32(2)))
+	})
+
+	It("should only write the drop-in file in the dropin mode", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "registries.conf"), []byte("short-name-mode = \"enforcing\"\n"),
+			0644)).To(Succeed())
+		Expect(s.SetRegistriesOutput(RegistriesOutputDropIn)).To(Succeed())
+		Expect(s.RegistriesConfDirPath()).To(Equal(filepath.Join(dir, "registries.conf.d")))
+		start()
+		Eventually(readFile("registries.conf.d/99-multiarch-operator.conf")).Should(Equal(
+			"[[registries]]\n  location = \"docker.io\"\n  prefix = \"\"\n  blocked = true\n"))
+		Expect(s.UpdateRegistryMirroringConfig("quay.io", []string{"mirror.example.com"})).To(Succeed())
+		Eventually(readFile("registries.conf.d/99-multiarch-operator.conf")).Should(ContainSubstring(
+			`mirror = ["mirror.example.com"]`))
+		Expect(readFile("registries.conf")()).To(Equal("short-name-mode = \"enforcing\"\n"))
+		entries, err := os.ReadDir(filepath.Join(dir, "registries.conf.d"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should delete the drop-in file of a previous run in the full mode", func() {
+		Expect(os.MkdirAll(filepath.Join(dir, "registries.conf.d"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "registries.conf.d", "99-multiarch-operator.conf"),
+			[]byte("[[registries]]\n"), 0644)).To(Succeed())
+		start()
+		Eventually(readFile("registries.conf")).Should(ContainSubstring(`location = "docker.io"`))
+		Expect(filepath.Join(dir, "registries.conf.d", "99-multiarch-operator.conf")).NotTo(BeAnExistingFile())
+		// the inspections read the drop-in files of the emptied directory, not the ones of the host
+		Expect(s.RegistriesConfDirPath()).To(Equal(filepath.Join(dir, "registries.conf.d")))
+	})
+
+	It("should reject the unknown registries output modes", func() {
+		Expect(s.SetRegistriesOutput("partial")).NotTo(Succeed())
+		_, err := NewSystemConfigSyncer(SystemConfigSyncerOptions{RegistriesOutput: "partial"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should write the files of independent syncers to their own directories", func() {
+		other, otherDir := newIsolatedSyncer(func(options *SystemConfigSyncerOptions) {
+			options.RegistriesOutput = RegistriesOutputDropIn
+		})
+		other.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			return s.StoreImageRegistryConf(nil, []string{"quay.io"}, nil)
+		}
+		go func() {
+			_ = other.Start(ctx)
+		}()
+		start()
+		Eventually(readFile("registries.conf")).Should(ContainSubstring(`location = "docker.io"`))
+		Eventually(func() string {
+			data, _ := os.ReadFile(filepath.Join(otherDir, "registries.conf.d", registriesConfDropInFileName))
+			return string(data)
+		}).Should(ContainSubstring(`location = "quay.io"`))
+		Expect(readFile("registries.conf")()).NotTo(ContainSubstring("quay.io"))
+		Expect(filepath.Join(otherDir, "registries.conf")).NotTo(BeAnExistingFile())
+	})
+
+	It("should record the sync times of its clock", func() {
+		now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		s, dir = newIsolatedSyncer(func(options *SystemConfigSyncerOptions) {
+			options.Clock = testingclock.NewFakeClock(now)
+		})
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			return s.StoreImageRegistryConf(nil, nil, nil)
+		}
+		start()
+		Eventually(func() time.Time { return s.SyncStatus().LastSyncTime }).Should(Equal(now))
+	})
+
+	It("should omit the atomic transport from policy.json when configured", func() {
+		s, dir = newIsolatedSyncer(func(options *SystemConfigSyncerOptions) {
+			options.OmitAtomicTransport = true
+		})
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			return s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)
+		}
+		start()
+		Eventually(readFile("policy.json")).Should(ContainSubstring(`"docker":{"docker.io":[{"type":"reject"}]}`))
+		Expect(readFile("policy.json")()).NotTo(ContainSubstring(atomicTransport))
+	})
+
+	It("should write the sigstore attachments configuration to registries.d", func() {
+		start()
+		Eventually(readFile("policy.json")).ShouldNot(BeEmpty())
+		Expect(s.StoreSigstoreAttachmentConfig("quay.io", true, "")).To(Succeed())
+		Expect(s.StoreSigstoreAttachmentConfig("registry.example.com:5000/team", false,
+			"https://sigstore.example.com/signatures")).To(Succeed())
+		Eventually(readFile("registries.d/quay.io.yaml")).Should(Equal(
+			"docker:\n  quay.io:\n    use-sigstore-attachments: true\n"))
+		Eventually(readFile("registries.d/registry.example.com_5000_team.yaml")).Should(Equal(
+			"docker:\n  registry.example.com:5000/team:\n    lookaside: https://sigstore.example.com/signatures\n"))
+
+		Expect(s.DeleteSigstoreAttachmentConfig("quay.io")).To(Succeed())
+		Eventually(readFile("registries.d/quay.io.yaml")).Should(BeEmpty())
+		Expect(readFile("registries.d/registry.example.com_5000_team.yaml")()).NotTo(BeEmpty())
+		Expect(s.DeleteSigstoreAttachmentConfig("quay.io")).NotTo(Succeed())
+	})
+
+	It("should only rewrite the registries.d files that changed", func() {
+		start()
+		Expect(s.StoreSigstoreAttachmentConfig("quay.io", true, "")).To(Succeed())
+		Expect(s.StoreSigstoreAttachmentConfig("docker.io", true, "")).To(Succeed())
+		Eventually(readFile("registries.d/docker.io.yaml")).ShouldNot(BeEmpty())
+		Eventually(readFile("registries.d/quay.io.yaml")).ShouldNot(BeEmpty())
+		unchanged, err := os.Stat(filepath.Join(dir, "registries.d", "quay.io.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.StoreSigstoreAttachmentConfig("docker.io", true, "https://sigstore.example.com")).To(Succeed())
+		Eventually(readFile("registries.d/docker.io.yaml")).Should(ContainSubstring("lookaside"))
+		current, err := os.Stat(filepath.Join(dir, "registries.d", "quay.io.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.SameFile(unchanged, current)).To(BeTrue())
+	})
+
+	It("should replace the sigstore attachments configuration with the ConfigMap content", func() {
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			return s.StoreSigstoreAttachmentConfig("stale.example.com", true, "")
+		}
+		start()
+		Eventually(readFile("registries.d/stale.example.com.yaml")).ShouldNot(BeEmpty())
+		confs, err := parseSigstoreAttachmentConfs(&v1.ConfigMap{Data: map[string]string{
+			"registry.example.com..5000": "useSigstoreAttachments: true\nlookaside: https://sigstore.example.com\n",
+			"quay.io":                    "useSigstoreAttachment: true",
+		}})
+		Expect(err).To(MatchError(ContainSubstring("quay.io")))
+		s.storeSigstoreAttachmentConfigs(confs)
+		Eventually(readFile("registries.d/stale.example.com.yaml")).Should(BeEmpty())
+		Eventually(readFile("registries.d/registry.example.com_5000.yaml")).Should(Equal("docker:\n" +
+			"  registry.example.com:5000:\n    lookaside: https://sigstore.example.com\n    use-sigstore-attachments: true\n"))
+		Expect(readFile("registries.d/quay.io.yaml")()).To(BeEmpty())
+	})
+
+	It("should stop when the context is done", func() {
+		start()
+		Eventually(readFile("policy.json")).ShouldNot(BeEmpty())
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		// the changes received after the stop do not block the callers
+		Expect(s.StoreImageRegistryConf(nil, []string{"quay.io"}, nil)).To(Succeed())
+		Expect(s.StoreImageRegistryConf(nil, []string{"gcr.io"}, nil)).To(Succeed())
+	})
+
+	It("should write the pending changes when the context is done", func() {
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			// the stop is requested right after the initial get of the image.config.openshift.io/cluster object
+			cancel()
+			return s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)
+		}
+		start()
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(readFile("policy.json")()).To(Equal(string(readGoldenFile("policy-one-blocked.json"))))
+	})
+
+	It("should fail to start if the event handlers cannot be registered", func() {
+		s.registerEventHandlers = func(context.Context, *SystemConfigSyncer) error {
+			return errors.New("no API server")
+		}
+		start()
+		Eventually(done).Should(Receive(MatchError("no API server")))
+	})
+
+	It("should be ready once the files are written", func() {
+		Expect(s.ReadyzCheck(nil)).To(MatchError(ContainSubstring("not synced yet")))
+		start()
+		Eventually(func() error { return s.ReadyzCheck(nil) }).Should(Succeed())
+		Expect(s.SyncStatus().LastSyncTime).NotTo(BeZero())
+	})
+
+	It("should not be ready while the files cannot be written", func() {
+		// the parent of policy.json is a regular file
+		Expect(os.WriteFile(filepath.Join(dir, "file"), nil, 0644)).To(Succeed())
+		s.policyConfPath = filepath.Join(dir, "file", "policy.json")
+		start()
+		Eventually(func() error { return s.SyncStatus().LastSyncError }).Should(HaveOccurred())
+		Expect(s.ReadyzCheck(nil)).To(MatchError(ContainSubstring("the last sync of the system config failed")))
+
+		s.mu.Lock()
+		s.policyConfPath = filepath.Join(dir, "policy.json")
+		s.mu.Unlock()
+		Expect(s.StoreImageRegistryConf(nil, nil, nil)).To(Succeed())
+		Eventually(func() error { return s.ReadyzCheck(nil) }).Should(Succeed())
+	})
+
+	It("should not write the registries.conf files containers/image rejects", func() {
+		start()
+		Eventually(readFile("registries.conf")).Should(ContainSubstring(`location = "docker.io"`))
+		Expect(s.UpdateRegistryMirroringConfig("quay.io/org", []string{"https://mirror.example.com/org"})).To(Succeed())
+		Eventually(func() error { return s.SyncStatus().LastSyncError }).Should(
+			MatchError(ContainSubstring("has a URI scheme")))
+		Expect(readFile("registries.conf")()).NotTo(ContainSubstring("mirror.example.com"))
+	})
+
+	It("should run on every replica", func() {
+		Expect(s.NeedLeaderElection()).To(BeFalse())
+	})
+})
+
+var _ = Describe("The post-sync hook of the SystemConfigSyncer", func() {
+	var (
+		s      *SystemConfigSyncer
+		dir    string
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		s, dir = newIsolatedSyncer()
+		s.registerEventHandlers = func(ctx context.Context, s *SystemConfigSyncer) error {
+			return s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+	})
+
+	start := func() {
+		go func() {
+			_ = s.Start(ctx)
+		}()
+	}
+
+	// appendingHook returns a hook touching the reload sentinel file and appending a line to the runs file
+	appendingHook := func(minInterval time.Duration) PostSyncHook {
+		return PostSyncHook{
+			SentinelFile: filepath.Join(dir, "reload"),
+			Command:      []string{"sh", "-c", "echo run >> " + filepath.Join(dir, "runs")},
+			Timeout:      5 * time.Second,
+			MinInterval:  minInterval,
+		}
+	}
+
+	runs := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "runs"))
+		return bytes.Count(data, []byte("\n"))
+	}
+
+	It("should run after the syncs that change registries.conf", func() {
+		s.SetPostSyncHook(appendingHook(0))
+		start()
+		Eventually(runs).Should(Equal(1))
+		Expect(filepath.Join(dir, "reload")).To(BeAnExistingFile())
+		Expect(s.SyncStatus().LastPostSyncHookTime).NotTo(BeZero())
+		Expect(s.SyncStatus().LastPostSyncHookError).NotTo(HaveOccurred())
+
+		Expect(s.StoreImageRegistryConf(nil, []string{"quay.io"}, nil)).To(Succeed())
+		Eventually(runs).Should(Equal(2))
+	})
+
+	It("should not run after the syncs leaving registries.conf unchanged", func() {
+		s.SetPostSyncHook(appendingHook(0))
+		start()
+		Eventually(runs).Should(Equal(1))
+		Expect(s.StoreRegistryCerts([]registryCertTuple{{registry: "registry.example.com", cert: "cert"}})).To(Succeed())
+		Eventually(func() string {
+			data, _ := os.ReadFile(filepath.Join(dir, "certs.d", "registry.example.com", "ca.crt"))
+			return string(data)
+		}).Should(Equal("cert"))
+		Expect(s.StoreImageRegistryConf(nil, []string{"docker.io"}, nil)).To(Succeed())
+		Consistently(runs, 200*time.Millisecond).Should(Equal(1))
+	})
+
+	It("should delay the runs within its minimum interval", func() {
+		s.SetPostSyncHook(appendingHook(time.Second))
+		start()
+		Eventually(runs).Should(Equal(1))
+		Expect(s.StoreImageRegistryConf(nil, []string{"quay.io"}, nil)).To(Succeed())
+		Expect(s.StoreImageRegistryConf(nil, []string{"gcr.io"}, nil)).To(Succeed())
+		Consistently(runs, 300*time.Millisecond).Should(Equal(1))
+		// the changes made meanwhile are covered by a single run
+		Eventually(runs, 2*time.Second).Should(Equal(2))
+		Consistently(runs, 300*time.Millisecond).Should(Equal(2))
+	})
+
+	It("should report the commands that time out", func() {
+		s.SetPostSyncHook(PostSyncHook{Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond})
+		start()
+		Eventually(func() error { return s.SyncStatus().LastPostSyncHookError }).
+			Should(MatchError(ContainSubstring("timed out")))
+		// the failures of the hook do not fail the syncs
+		Expect(s.ReadyzCheck(nil)).To(Succeed())
+	})
+
+	It("should be disabled by default", func() {
+		start()
+		Eventually(func() error { return s.ReadyzCheck(nil) }).Should(Succeed())
+		Expect(s.SyncStatus().LastPostSyncHookTime).To(BeZero())
+	})
+})
+
+var _ = Describe("The parsing of the additional trusted CA ConfigMap", func() {
+	skippedKeys := func() float64 {
+		m := &dto.Metric{}
+		Expect(skippedRegistryCertsKeys.Write(m)).To(Succeed())
+		return m.GetCounter().GetValue()
+	}
+
+	DescribeTable("should convert the keys to the certs.d folder names", func(key, folder string) {
+		Expect(parseRegistryCerts(&v1.ConfigMap{Data: map[string]string{key: "cert"}})).To(Equal(
+			[]registryCertTuple{{registry: key, cert: "cert"}}))
+		Expect(registryCertTuple{registry: key}.getFolderName()).To(Equal(folder))
+	},
+		Entry("hostname", "registry.example.com", "registry.example.com"),
+		Entry("hostname with port", "registry.example.com..5000", "registry.example.com:5000"),
+		Entry("multi-label hostname with port", "mirror.registry.apps.example.com..8443",
+			"mirror.registry.apps.example.com:8443"),
+		Entry("numeric labels", "0123.registry.example.com..5000", "0123.registry.example.com:5000"),
+		Entry("IP address", "10.0.0.1", "10.0.0.1"),
+		Entry("IP address with port", "10.0.0.1..5000", "10.0.0.1:5000"),
+		Entry("localhost", "localhost", "localhost"),
+		Entry("single label with port", "registry..5000", "registry:5000"),
+	)
+
+	DescribeTable("should skip the keys that are not registry hostnames", func(key string) {
+		before := skippedKeys()
+		Expect(parseRegistryCerts(&v1.ConfigMap{Data: map[string]string{
+			key: "other", "registry.example.com": "cert",
+		}})).To(Equal([]registryCertTuple{{registry: "registry.example.com", cert: "cert"}}))
+		Expect(skippedKeys()).To(Equal(before + 1))
+	},
+		Entry("update service key", "updateservice-registry"),
+		Entry("invalid port", "registry.example.com..port"),
+		Entry("out of range port", "registry.example.com..65536"),
+		Entry("empty port", "registry.example.com.."),
+		Entry("invalid hostname", "-registry.example.com"),
+		Entry("underscores", "registry_example.com"),
+	)
+})
+
+var _ = Describe("The forced sync of the SystemConfigSyncer", func() {
+	var (
+		s   *SystemConfigSyncer
+		dir string
+	)
+
+	BeforeEach(func() {
+		s, dir = newIsolatedSyncer()
+		s.SetSigstoreAttachmentsConfigMap("openshift-config", "sigstore-attachments")
+	})
+
+	newReader := func(objects ...client.Object) client.Reader {
+		scheme := runtime.NewScheme()
+		Expect(v1.AddToScheme(scheme)).To(Succeed())
+		Expect(ocpv1.AddToScheme(scheme)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	}
+
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("should rebuild the files from the cluster objects before returning", func() {
+		Expect(s.StoreSigstoreAttachmentConfig("stale.example.com", true, "")).To(Succeed())
+		Expect(s.ForceSync(context.Background(), newReader(
+			&ocpv1.Image{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+				Spec: ocpv1.ImageSpec{RegistrySources: ocpv1.RegistrySources{
+					BlockedRegistries: []string{"docker.io"},
+				}},
+			},
+			&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: registryCertsConfigMapName, Namespace: registryCertsConfigMapNamespace},
+				Data:       map[string]string{"registry.example.com..5000": "cert", "updateservice-registry": "other"},
+			},
+			&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "sigstore-attachments", Namespace: "openshift-config"},
+				Data:       map[string]string{"quay.io": "useSigstoreAttachments: true\n"},
+			},
+		))).To(Succeed())
+		Expect(readFile("policy.json")).To(Equal(string(readGoldenFile("policy-one-blocked.json"))))
+		Expect(readFile("certs.d/registry.example.com:5000/ca.crt")).To(Equal("cert"))
+		Expect(readFile("registries.d/quay.io.yaml")).To(ContainSubstring("use-sigstore-attachments: true"))
+		_, err := os.Stat(filepath.Join(dir, "registries.d", "stale.example.com.yaml"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(s.SyncStatus().LastSyncTime).NotTo(BeZero())
+	})
+
+	It("should skip the missing objects and delete the sigstore attachments configuration", func() {
+		Expect(s.StoreSigstoreAttachmentConfig("stale.example.com", true, "")).To(Succeed())
+		Expect(s.ForceSync(context.Background(), newReader())).To(Succeed())
+		Expect(readFile("policy.json")).To(Equal(string(readGoldenFile("policy-default.json"))))
+		_, err := os.Stat(filepath.Join(dir, "registries.d", "stale.example.com.yaml"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should only read the objects the node-syncer-role allows", func() {
+		rules, err := testenv.RBACRules(".")
+		Expect(err).NotTo(HaveOccurred())
+		requests := &testenv.APIRequests{}
+		Expect(s.ForceSync(context.Background(), testenv.RecordingClient(newReader(
+			&ocpv1.Image{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}},
+		).(client.WithWatch), requests))).To(Succeed())
+		Expect(requests.List()).NotTo(BeEmpty())
+		for _, request := range requests.List() {
+			Expect(testenv.Allows(rules, request)).To(BeTrue(), request.String())
+		}
+	})
+
+	It("should not write the files when the objects cannot be read", func() {
+		reader := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+				return errors.New("the API server is unavailable")
+			},
+		}).Build()
+		Expect(s.ForceSync(context.Background(), reader)).To(MatchError(ContainSubstring("unavailable")))
+		_, err := os.Stat(filepath.Join(dir, "policy.json"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("The bootstrap registries.conf of the SystemConfigSyncer", func() {
+	var s *SystemConfigSyncer
+
+	BeforeEach(func() {
+		s, _ = newIsolatedSyncer(func(options *SystemConfigSyncerOptions) {
+			options.BootstrapRegistriesConf = filepath.Join("testdata", "registries-bootstrap.conf")
+		})
+	})
+
+	registryConfOf := func(registry string) *registryConf {
+		rc, ok := s.registriesConfContent.getRegistryConf(registry)
+		Expect(ok).To(BeTrue())
+		return rc
+	}
+
+	It("should be the initial state of the registries", func() {
+		Expect(s.registriesConfContent.UnqualifiedSearchRegistries).To(Equal([]string{"registry.example.com"}))
+		Expect(registryConfOf("quay.io").Mirrors).To(Equal([]string{"mirror.example.com/quay"}))
+		Expect(registryConfOf("docker.io").Blocked).To(HaveValue(BeTrue()))
+		Expect(registryConfOf("registry.example.com:5000").Insecure).To(HaveValue(BeTrue()))
+		Expect(s.policyConfContent.transports()[dockerTransport]).To(HaveKey("docker.io"))
+	})
+
+	It("should be overridden field by field by the registry sources of the cluster", func() {
+		Expect(s.StoreImageRegistryConf(nil, nil, []string{"insecure.example.com"})).To(Succeed())
+		Expect(registryConfOf("docker.io").Blocked).To(HaveValue(BeTrue()))
+		Expect(registryConfOf("registry.example.com:5000").Insecure).To(BeNil())
+		Expect(registryConfOf("insecure.example.com").Insecure).To(HaveValue(BeTrue()))
+
+		Expect(s.StoreImageRegistryConf([]string{"quay.io"}, nil, nil)).To(Succeed())
+		Expect(registryConfOf("docker.io").Blocked).To(BeNil())
+		Expect(registryConfOf("quay.io").Allowed).To(HaveValue(BeTrue()))
+		Expect(registryConfOf("registry.example.com:5000").Insecure).To(HaveValue(BeTrue()))
+	})
+
+	It("should be overridden by the mirrors of the other owners of a source until they are deleted", func() {
+		Expect(s.UpdateRegistryMirroringConfigBatch("icsp-a",
+			[]RegistryMirrors{{Source: "quay.io", Mirrors: []string{"mirror.example.org/quay"}}})).To(Succeed())
+		Expect(registryConfOf("quay.io").Mirrors).To(Equal([]string{"mirror.example.org/quay"}))
+		Expect(s.UpdateRegistryMirroringConfigBatch("icsp-a", nil)).To(Succeed())
+		Expect(registryConfOf("quay.io").Mirrors).To(Equal([]string{"mirror.example.com/quay"}))
+
+		Expect(s.UpdateRegistryMirroringConfigBatch("icsp-a",
+			[]RegistryMirrors{{Source: "quay.io", Mirrors: []string{"mirror.example.org/quay"}}})).To(Succeed())
+		Expect(s.CleanupRegistryMirroringConfig()).To(Succeed())
+		Expect(registryConfOf("quay.io").Mirrors).To(Equal([]string{"mirror.example.com/quay"}))
+	})
+
+	It("should be written as the first registries.conf file", func() {
+		Expect(s.sync()).To(Succeed())
+		data, err := os.ReadFile(s.RegistriesConfPath())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`mirror = ["mirror.example.com/quay"]`))
+	})
+
+	DescribeTable("should fail the creation of the syncer with an invalid file", func(content, reason string) {
+		path := filepath.Join(GinkgoT().TempDir(), "registries.conf")
+		if content != "" {
+			Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		}
+		_, err := NewSystemConfigSyncer(SystemConfigSyncerOptions{BootstrapRegistriesConf: path})
+		Expect(err).To(MatchError(ContainSubstring("invalid bootstrap registries.conf " + path)))
+		Expect(err).To(MatchError(ContainSubstring(reason)))
+	},
+		Entry("missing", "", "no such file"),
+		Entry("not TOML", "[[registry]\n", "invalid registries.conf"),
+		Entry("with a remapped prefix", "[[registry]]\nprefix = \"docker.io/library\"\n"+
+			"location = \"mirror.example.com/library\"\n", "the prefixes must be the locations"),
+		Entry("with an insecure mirror", "[[registry]]\nlocation = \"quay.io\"\n[[registry.mirror]]\n"+
+			"location = \"mirror.example.com\"\ninsecure = true\n", "is insecure"),
+	)
+})
+
+var _ = Describe("The resync periods of the watchers of the SystemConfigSyncer", func() {
+	It("should spread the period of each watcher by the jitter", func() {
+		periods := DefaultResyncPeriods().jittered()
+		applied := []time.Duration{periods.RegistryCerts, periods.Image, periods.SigstoreAttachments}
+		Expect(sets.New(applied...).Len()).To(Equal(len(applied)))
+		for _, period := range applied {
+			Expect(period).To(BeNumerically("~", time.Hour, 6*time.Minute))
+		}
+	})
+
+	It("should apply the periods unchanged without jitter", func() {
+		periods := ResyncPeriods{RegistryCerts: 30 * time.Minute, Image: time.Hour, Jitter: 0}
+		Expect(periods.jittered()).To(Equal(periods))
+	})
+})
@@ -1,11 +1,14 @@
 package system_config
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"k8s.io/apimachinery/pkg/util/json"
+	"multiarch-operator/pkg/system_config/eval"
 	"os"
 	"path/filepath"
+	"sigs.k8s.io/yaml"
 	"strings"
 )
 
@@ -14,16 +17,76 @@ const (
 	PolicyConfPath     = "/tmp/containers/policy.json"
 	DockerCertsDir     = "/tmp/docker/certs.d"
 	RegistryCertsDir   = "/etc/containers/registries.d"
+	// RegistriesDirPath is the default registries.d directory the sigstore attachments configuration is written to
+	RegistriesDirPath = "/tmp/containers/registries.d"
+	// RegistriesConfDropInDirPath is the registries.conf.d directory the drop-in file is written to in the
+	// RegistriesOutputDropIn mode. It is the directory of the drop-in files of the inspections in both modes.
+	RegistriesConfDropInDirPath = "/tmp/containers/registries.conf.d"
+	// registriesConfDropInFileName is the name of the drop-in file. The files of registries.conf.d are merged in
+	// alphabetical order: the 99- prefix makes the entries of the syncer override the ones of the other files.
+	registriesConfDropInFileName = "99-multiarch-operator.conf"
 )
 
+// RegistriesOutputMode is how the SystemConfigSyncer writes the configuration of the registries
+type RegistriesOutputMode string
+
+const (
+	// RegistriesOutputFull writes the whole registries.conf file
+	RegistriesOutputFull RegistriesOutputMode = "full"
+	// RegistriesOutputDropIn only writes the registries managed by the syncer, i.e., their mirrors and whether they
+	// are blocked, allowed or insecure, to a drop-in file of the registries.conf.d directory. The registries.conf file
+	// is left alone, and its unqualified-search-registries and short-name-mode keys apply.
+	RegistriesOutputDropIn RegistriesOutputMode = "dropin"
+)
+
+// RegistriesOutputModes are the supported values of RegistriesOutputMode
+var RegistriesOutputModes = []RegistriesOutputMode{RegistriesOutputFull, RegistriesOutputDropIn}
+
+// ConfigPaths are the paths of the files read by the inspections of the images. It implements IConfigReader, e.g.,
+// for the inspections of the images with a configuration not written by the SystemConfigSyncer.
+type ConfigPaths struct {
+	// RegistriesConf is the registries.conf file
+	RegistriesConf string
+	// RegistriesConfDir is the directory of the registries.conf drop-in files
+	RegistriesConfDir string
+	// Policy is the policy.json file
+	Policy string
+	// DockerCerts is the directory of the CAs of the registries, in a folder per registry host
+	DockerCerts string
+}
+
+// DefaultConfigPaths are the paths of the files written by the SystemConfigSyncer
+var DefaultConfigPaths = ConfigPaths{
+	RegistriesConf:    RegistriesConfPath,
+	RegistriesConfDir: RegistriesConfDropInDirPath,
+	Policy:            PolicyConfPath,
+	DockerCerts:       DockerCertsDir,
+}
+
+func (p ConfigPaths) RegistriesConfPath() string {
+	return p.RegistriesConf
+}
+
+func (p ConfigPaths) RegistriesConfDirPath() string {
+	return p.RegistriesConfDir
+}
+
+func (p ConfigPaths) PolicyConfPath() string {
+	return p.Policy
+}
+
+func (p ConfigPaths) DockerCertsDirPath() string {
+	return p.DockerCerts
+}
+
 type registryCertTuple struct {
 	registry string
 	cert     string
 }
 
-func (t registryCertTuple) writeToFile() error {
+func (t registryCertTuple) writeToFile(certsDir string) error {
 	// create folder if it doesn't exist
-	absoluteFolderPath := fmt.Sprintf("%s/%s", DockerCertsDir, t.getFolderName())
+	absoluteFolderPath := fmt.Sprintf("%s/%s", certsDir, t.getFolderName())
 	if _, err := os.Stat(absoluteFolderPath); os.IsNotExist(err) {
 		err = os.MkdirAll(absoluteFolderPath, 0755)
 		if err != nil {
@@ -31,7 +94,7 @@ func (t registryCertTuple) writeToFile() error {
 		}
 	}
 	// write cert to file
-	absoluteFilePath := fmt.Sprintf("%s/%s/ca.crt", DockerCertsDir, t.getFolderName())
+	absoluteFilePath := fmt.Sprintf("%s/%s/ca.crt", certsDir, t.getFolderName())
 	f, err := os.Create(absoluteFilePath)
 	if err != nil {
 		return err
@@ -50,6 +113,58 @@ func (t registryCertTuple) getFolderName() string {
 	return strings.Replace(t.registry, "..", ":", 1)
 }
 
+// sigstoreAttachmentConf is the registries.d configuration of the sigstore signatures of a registry
+type sigstoreAttachmentConf struct {
+	registry       string
+	useAttachments bool
+	lookasideURL   string
+}
+
+// registriesDConf is the content of a registries.d YAML file
+type registriesDConf struct {
+	Docker map[string]registryNamespaceConf `json:"docker"`
+}
+
+type registryNamespaceConf struct {
+	Lookaside              string `json:"lookaside,omitempty"`
+	UseSigstoreAttachments *bool  `json:"use-sigstore-attachments,omitempty"`
+}
+
+// fileName returns the name of the registries.d file of the registry. Each registry has its own file, as
+// containers/image rejects the registries configured in more than one file.
+func (c sigstoreAttachmentConf) fileName() string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(c.registry) + ".yaml"
+}
+
+// marshal renders the registries.d YAML file of the registry
+func (c sigstoreAttachmentConf) marshal() ([]byte, error) {
+	namespaceConf := registryNamespaceConf{
+		Lookaside: c.lookasideURL,
+	}
+	if c.useAttachments {
+		namespaceConf.UseSigstoreAttachments = &c.useAttachments
+	}
+	return yaml.Marshal(registriesDConf{
+		Docker: map[string]registryNamespaceConf{
+			c.registry: namespaceConf,
+		},
+	})
+}
+
+// RegistryMirrors are the mirrors of a source registry or repository
+type RegistryMirrors struct {
+	Source  string
+	Mirrors []string
+}
+
+// registrySources are the registries allowed, blocked and marked as insecure in the image.config.openshift.io/cluster
+// object
+type registrySources struct {
+	allowed  []string
+	blocked  []string
+	insecure []string
+}
+
 type registriesConf struct {
 	UnqualifiedSearchRegistries []string                 `toml:"unqualified-search-registries"`
 	ShortNameMode               string                   `toml:"short-name-mode"`
@@ -57,7 +172,7 @@ type registriesConf struct {
 	registriesMap               map[string]*registryConf `toml:"-"`
 }
 
-func (rsc registriesConf) getRegistryConfOrCreate(registry string) *registryConf {
+func (rsc *registriesConf) getRegistryConfOrCreate(registry string) *registryConf {
 	rc, _ := rsc.registriesMap[registry]
 	if rc == nil {
 		rc = &registryConf{
@@ -69,11 +184,58 @@ func (rsc registriesConf) getRegistryConfOrCreate(registry string) *registryConf
 	return rc
 }
 
-func (rsc registriesConf) writeToFile() error {
-	return writeTomlFile(RegistriesConfPath, rsc)
+// registriesConfDropIn is the content of a registries.conf.d drop-in file. It has no unqualified-search-registries and
+// short-name-mode keys, so that the ones of the registries.conf file are not overridden.
+type registriesConfDropIn struct {
+	Registries []*registryConf `toml:"registries"`
 }
 
-func (rsc registriesConf) getRegistryConf(registry string) (*registryConf, bool) {
+// marshal renders the registries.conf file, or the drop-in file in the RegistriesOutputDropIn mode, into a buffer
+// sized by renderedSizeHint, so that the large mirror sets are not rendered through many small writes
+func (rsc *registriesConf) marshal(mode RegistriesOutputMode) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0, rsc.renderedSizeHint()))
+	var content interface{} = rsc
+	if mode == RegistriesOutputDropIn {
+		content = registriesConfDropIn{Registries: rsc.Registries}
+	}
+	if err := toml.NewEncoder(buffer).Encode(content); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeToFile renders the file of the mode and writes it if changed, so that the large mirror sets are not rewritten
+// when unchanged. The rendered file is evaluated as containers/image would first: the file it rejects is not written,
+// so that CRI-O keeps the previous one. It returns true if the file was written.
+func (rsc *registriesConf) writeToFile(path string, mode RegistriesOutputMode) (bool, error) {
+	data, err := rsc.marshal(mode)
+	if err != nil {
+		return false, err
+	}
+	if _, err := eval.Parse(data); err != nil {
+		return false, fmt.Errorf("refusing to write %s: %w", path, err)
+	}
+	return writeFileIfChanged(path, data)
+}
+
+// renderedSizeHint returns an estimate of the size of the rendered registries.conf file, slightly larger than it
+func (rsc *registriesConf) renderedSizeHint() int {
+	// the unqualified-search-registries and short-name-mode keys
+	size := 64
+	for _, registry := range rsc.UnqualifiedSearchRegistries {
+		size += len(registry) + 4
+	}
+	for _, rc := range rsc.Registries {
+		// the table header, the keys, the quotes and the booleans
+		size += 128 + len(rc.Location) + len(rc.Prefix)
+		for _, mirror := range rc.Mirrors {
+			size += len(mirror) + 4
+		}
+	}
+	return size
+}
+
+func (rsc *registriesConf) getRegistryConf(registry string) (*registryConf, bool) {
 	rc, ok := rsc.registriesMap[registry]
 	return rc, ok
 }
@@ -93,38 +255,119 @@ func defaultRegistriesConf() registriesConf {
 	return registriesConf{
 		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
 		ShortNameMode:               "",
+		registriesMap:               map[string]*registryConf{},
 	}
 }
 
 const (
 	dockerDaemonTransport = "docker-daemon"
 	dockerTransport       = "docker"
-	atomicTransport       = "atomic"
+	// atomicTransport is deprecated by containers/image. Its entries are always the ones of dockerTransport, see
+	// policyConf.transports.
+	atomicTransport = "atomic"
 )
 
+// policySection is a section of policy.json owned by a single path of the SystemConfigSyncer. Each path only rewrites
+// its own section, so that it cannot clobber the entries of the others.
+type policySection string
+
+const (
+	// policySectionDefaults are the default entries of the transports, set at the creation of the policyConf
+	policySectionDefaults policySection = "defaults"
+	// policySectionSignatures are the signature policies of the scopes
+	policySectionSignatures policySection = "signatures"
+	// policySectionBlockedRegistries are the reject entries of the blocked registries, and of their mirrors when
+	// blockMirrorsOfBlockedRegistries is set
+	policySectionBlockedRegistries policySection = "blockedRegistries"
+)
+
+// policySections are the sections of policy.json in order of precedence: the entries of a section replace the ones of
+// the previous sections for the same scope, so that a blocked registry is rejected regardless of its signature policy.
+var policySections = []policySection{policySectionDefaults, policySectionSignatures, policySectionBlockedRegistries}
+
+// policyTransports are the entries of the scopes by transport
+type policyTransports map[string]map[string][]policyEntry
+
 // {"default":[{"type":"insecureAcceptAnything"}],"transports":{"atomic":{"docker.io":[{"type":"reject"}]},"docker":{"docker.io":[{"type":"reject"}]},"docker-daemon":{"":[{"type":"insecureAcceptAnything"}]}}}
 type policyConf struct {
-	Default    []policyEntry                       `json:"default"`
-	Transports map[string]map[string][]policyEntry `json:"transports"`
+	Default []policyEntry
+	// sections are the entries of the transports by owning section. They are merged by transports. The entries of
+	// the atomic transport are never set: they are rendered from the ones of the docker transport.
+	sections map[policySection]policyTransports
+	// omitAtomicTransport omits the atomic transport from the rendered policy.json
+	omitAtomicTransport bool
 }
 
-func (pc policyConf) resetTransports() {
-	pc.Transports = defaultTransports()
+// resetSection removes the entries of the section, leaving the ones of the other sections alone
+func (pc *policyConf) resetSection(section policySection) {
+	delete(pc.sections, section)
 }
 
-func (pc policyConf) setRejectForRegistry(registry string) {
-	pc.setRejectForRegistryOnTransport(registry, dockerTransport)
-	pc.setRejectForRegistryOnTransport(registry, atomicTransport)
+// setSection replaces the entries of the section
+func (pc *policyConf) setSection(section policySection, transports policyTransports) {
+	if pc.sections == nil {
+		pc.sections = map[policySection]policyTransports{}
+	}
+	pc.sections[section] = transports
 }
 
-func (pc policyConf) setRejectForRegistryOnTransport(registry, transport string) {
-	pc.Transports[transport][registry] = []policyEntry{
+// setRejectForRegistry rejects the images of the registry, through the docker and the atomic transports
+func (pc *policyConf) setRejectForRegistry(registry string) {
+	pc.setEntries(policySectionBlockedRegistries, dockerTransport, registry, []policyEntry{
 		rejectPolicyEntry(),
+	})
+}
+
+// setEntries sets the entries of the scope of the transport in the section
+func (pc *policyConf) setEntries(section policySection, transport, scope string, entries []policyEntry) {
+	if pc.sections[section] == nil {
+		pc.setSection(section, policyTransports{})
+	}
+	if pc.sections[section][transport] == nil {
+		pc.sections[section][transport] = map[string][]policyEntry{}
+	}
+	pc.sections[section][transport][scope] = entries
+}
+
+// transports merges the sections in order of precedence. The docker transport is always rendered, even if empty, and
+// the atomic transport, unless omitted, is rendered with the same entries, so that the two never diverge.
+func (pc *policyConf) transports() policyTransports {
+	transports := policyTransports{
+		dockerTransport: {},
 	}
+	for _, section := range policySections {
+		for transport, scopes := range pc.sections[section] {
+			if transport == atomicTransport {
+				continue
+			}
+			if transports[transport] == nil {
+				transports[transport] = map[string][]policyEntry{}
+			}
+			for scope, entries := range scopes {
+				transports[transport][scope] = entries
+			}
+		}
+	}
+	if !pc.omitAtomicTransport {
+		transports[atomicTransport] = transports[dockerTransport]
+	}
+	return transports
+}
+
+// marshal renders the content of policy.json
+func (pc *policyConf) marshal() ([]byte, error) {
+	return json.Marshal(struct {
+		Default    []policyEntry    `json:"default"`
+		Transports policyTransports `json:"transports"`
+	}{pc.Default, pc.transports()})
 }
 
-func (pc policyConf) writeToFile() error {
-	return writeJSONFile(PolicyConfPath, pc)
+func (pc *policyConf) writeToFile(path string) error {
+	data, err := pc.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(data, '\n'))
 }
 
 // defaultPolicyConf returns a default policyConf object
@@ -133,19 +376,19 @@ func defaultPolicyConf() policyConf {
 		Default: []policyEntry{
 			insecureAcceptAnythingPolicyEntry(),
 		},
-		Transports: defaultTransports(),
+		sections: map[policySection]policyTransports{
+			policySectionDefaults: defaultTransports(),
+		},
 	}
 }
 
-func defaultTransports() map[string]map[string][]policyEntry {
-	return map[string]map[string][]policyEntry{
+func defaultTransports() policyTransports {
+	return policyTransports{
 		dockerDaemonTransport: {
 			"": []policyEntry{
 				insecureAcceptAnythingPolicyEntry(),
 			},
 		},
-		atomicTransport: {},
-		dockerTransport: {},
 	}
 }
 
@@ -165,16 +408,6 @@ type policyEntry struct {
 	Type string `json:"type"`
 }
 
-func writeTomlFile(path string, data interface{}) error {
-	createBaseDir(path)
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return toml.NewEncoder(f).Encode(data)
-}
-
 func createBaseDir(path string) {
 	// create base dir if it doesn't exist
 	baseDir := filepath.Dir(path)
@@ -183,14 +416,40 @@ func createBaseDir(path string) {
 	}
 }
 
-func writeJSONFile(path string, data interface{}) error {
+func writeFile(path string, data []byte) error {
+	createBaseDir(path)
+	return os.WriteFile(path, data, 0644)
+}
+
+// writeFileIfChanged writes data to path, unless the file already has that content. The file is written to a
+// temporary file in the same directory and renamed, so that the readers never see a partial content. The temporary
+// file is hidden and does not end with the extension of path, so that the readers of the directory, e.g., of the
+// *.conf files of registries.conf.d, never load it. It returns true if the file was written.
+func writeFileIfChanged(path string, data []byte) (bool, error) {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
 	createBaseDir(path)
-	f, err := os.Create(path)
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(data)
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 /* example policy.json
@@ -0,0 +1,132 @@
+package system_config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func readGoldenFile(name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	Expect(err).NotTo(HaveOccurred())
+	return data
+}
+
+var _ = Describe("The policy.json rendering", func() {
+	DescribeTable("should match the golden file", func(omitAtomicTransport bool, golden string,
+		blockedRegistries ...string) {
+		pc := defaultPolicyConf()
+		pc.omitAtomicTransport = omitAtomicTransport
+		for _, registry := range blockedRegistries {
+			pc.setRejectForRegistry(registry)
+		}
+		data, err := pc.marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(append(data, '\n'))).To(Equal(string(readGoldenFile(golden))))
+	},
+		Entry("with the default configuration", false, "policy-default.json"),
+		Entry("with one blocked registry", false, "policy-one-blocked.json", "docker.io"),
+		Entry("with multiple blocked registries", false, "policy-multiple-blocked.json",
+			"quay.io", "docker.io", "registry.example.com:5000", "gcr.io/project"),
+		Entry("with the default configuration, without the atomic transport", true,
+			"policy-default-without-atomic.json"),
+		Entry("with multiple blocked registries, without the atomic transport", true,
+			"policy-multiple-blocked-without-atomic.json",
+			"quay.io", "docker.io", "registry.example.com:5000", "gcr.io/project"),
+	)
+
+	It("should render the atomic transport with the entries of the docker transport", func() {
+		pc := defaultPolicyConf()
+		pc.setSection(policySectionSignatures, policyTransports{
+			dockerTransport: {"quay.io": []policyEntry{{Type: "sigstoreSigned"}}},
+		})
+		pc.setRejectForRegistry("docker.io")
+		Expect(pc.transports()[atomicTransport]).To(Equal(map[string][]policyEntry{
+			"quay.io":   {{Type: "sigstoreSigned"}},
+			"docker.io": {rejectPolicyEntry()},
+		}))
+		pc.resetSection(policySectionBlockedRegistries)
+		Expect(pc.transports()[atomicTransport]).To(Equal(pc.transports()[dockerTransport]))
+		pc.omitAtomicTransport = true
+		Expect(pc.transports()).NotTo(HaveKey(atomicTransport))
+	})
+
+	It("should render the same content regardless of the insertion order", func() {
+		registries := []string{"quay.io", "docker.io", "registry.example.com:5000", "gcr.io/project", "a.io", "z.io"}
+		expected := defaultPolicyConf()
+		for _, registry := range registries {
+			expected.setRejectForRegistry(registry)
+		}
+		expectedData, err := expected.marshal()
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 20; i++ {
+			pc := defaultPolicyConf()
+			for j := range registries {
+				pc.setRejectForRegistry(registries[(i+j)%len(registries)])
+			}
+			data, err := pc.marshal()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal(expectedData))
+		}
+	})
+
+	It("should reset the rejected registries", func() {
+		pc := defaultPolicyConf()
+		pc.setRejectForRegistry("docker.io")
+		pc.resetSection(policySectionBlockedRegistries)
+		data, err := pc.marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(append(data, '\n'))).To(Equal(string(readGoldenFile("policy-default.json"))))
+	})
+
+	It("should only reset the entries of the section", func() {
+		pc := defaultPolicyConf()
+		pc.setSection(policySectionSignatures, policyTransports{
+			dockerTransport: {"quay.io": []policyEntry{{Type: "sigstoreSigned"}}},
+		})
+		pc.setRejectForRegistry("docker.io")
+		pc.resetSection(policySectionBlockedRegistries)
+		Expect(pc.transports()[dockerTransport]).To(Equal(map[string][]policyEntry{
+			"quay.io": {{Type: "sigstoreSigned"}},
+		}))
+		Expect(pc.transports()[dockerDaemonTransport]).To(Equal(defaultTransports()[dockerDaemonTransport]))
+	})
+
+	It("should let the rejects of the blocked registries take precedence over the signature policies", func() {
+		pc := defaultPolicyConf()
+		pc.setRejectForRegistry("quay.io")
+		pc.setSection(policySectionSignatures, policyTransports{
+			dockerTransport: {"quay.io": []policyEntry{{Type: "sigstoreSigned"}}},
+		})
+		Expect(pc.transports()[dockerTransport]).To(HaveKeyWithValue("quay.io", []policyEntry{rejectPolicyEntry()}))
+		Expect(pc.sections[policySectionSignatures][dockerTransport]).To(HaveKeyWithValue("quay.io",
+			[]policyEntry{{Type: "sigstoreSigned"}}))
+	})
+})
+
+var _ = Describe("The registries.conf rendering", func() {
+	DescribeTable("should match the golden file", func(mode RegistriesOutputMode, golden string) {
+		rsc := defaultRegistriesConf()
+		trueValue := true
+		rsc.getRegistryConfOrCreate("docker.io").Blocked = &trueValue
+		rsc.getRegistryConfOrCreate("quay.io/org").Mirrors = []string{"mirror.example.com/org",
+			"mirror2.example.com/org"}
+		rsc.getRegistryConfOrCreate("registry.example.com:5000").Insecure = &trueValue
+		data, err := rsc.marshal(mode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(string(readGoldenFile(golden))))
+		Expect(rsc.renderedSizeHint()).To(BeNumerically(">=", len(data)))
+	},
+		Entry("with the whole file", RegistriesOutputFull, "registries-full.conf"),
+		Entry("with the drop-in file", RegistriesOutputDropIn, "registries-dropin.conf"),
+	)
+
+	It("should render an empty drop-in file without registries", func() {
+		rsc := defaultRegistriesConf()
+		data, err := rsc.marshal(RegistriesOutputDropIn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeEmpty())
+	})
+})
@@ -0,0 +1,80 @@
+package testenv
+
+import (
+	"fmt"
+
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+// NewNamespace returns a namespace whose name is generated by the API server from the prefix, with the labels
+func NewNamespace(generateName string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: generateName, Labels: labels}}
+}
+
+// NewPod returns a pod with a container for each image, named after its index, e.g., c0 for the first image
+func NewPod(namespace, name string, images ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:  fmt.Sprintf("c%d", i),
+			Image: image,
+		})
+	}
+	return pod
+}
+
+// NewNode returns a schedulable node of the architecture, labelled with it as by the kubelet
+func NewNode(name, architecture string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+		corev1.LabelArchStable: architecture,
+	}}}
+}
+
+// NewConfigMap returns a ConfigMap with the data
+func NewConfigMap(namespace, name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Data: data}
+}
+
+// NewImageContentSourcePolicy returns an ImageContentSourcePolicy mirroring the source to the mirrors, in their order.
+// The ImageContentSourcePolicyCRD has to be installed by the Options of the Harness to create it.
+func NewImageContentSourcePolicy(name, source string, mirrors ...string) *operatorv1alpha1.ImageContentSourcePolicy {
+	return &operatorv1alpha1.ImageContentSourcePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: operatorv1alpha1.ImageContentSourcePolicySpec{
+			RepositoryDigestMirrors: []operatorv1alpha1.RepositoryDigestMirrors{{Source: source, Mirrors: mirrors}},
+		},
+	}
+}
+
+// ImageContentSourcePolicyCRD returns the CustomResourceDefinition of the ImageContentSourcePolicy objects of the
+// OpenShift clusters, missing from the test environment. Its schema does not validate their spec.
+func ImageContentSourcePolicyCRD() *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "imagecontentsourcepolicies.operator.openshift.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: operatorv1alpha1.GroupName,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "imagecontentsourcepolicies",
+				Singular: "imagecontentsourcepolicy",
+				Kind:     "ImageContentSourcePolicy",
+				ListKind: "ImageContentSourcePolicyList",
+			},
+			Scope: apiextensionsv1.ClusterScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    operatorv1alpha1.GroupVersion.Version,
+				Served:  true,
+				Storage: true,
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type:                   "object",
+						XPreserveUnknownFields: pointer.Bool(true),
+					},
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,202 @@
+// Package testenv is the harness shared by the envtest suites of the controllers and of the webhooks: it starts an API
+// server with the CustomResourceDefinitions and the webhook configurations of the operator installed, and a manager
+// serving the webhooks on a random port of the loopback address.
+//
+// The binaries of the API server and of etcd are read from the KUBEBUILDER_ASSETS directory, set by `make test`. The
+// suites skip their envtest specs when it is not set, see Available.
+package testenv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	multiarchv1alpha1 "multiarch-operator/apis/multiarch/v1alpha1"
+)
+
+// assetsEnv is the environment variable with the directory of the binaries of the API server and of etcd
+const assetsEnv = "KUBEBUILDER_ASSETS"
+
+// webhookServerTimeout is the time StartManager waits for the webhook server to serve
+const webhookServerTimeout = 30 * time.Second
+
+// Available returns true if the binaries of the test environment are available, i.e., KUBEBUILDER_ASSETS is set
+func Available() bool {
+	return os.Getenv(assetsEnv) != ""
+}
+
+// NewScheme returns a scheme with the types of Kubernetes, of the operator and the ImageContentSourcePolicy one
+func NewScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(multiarchv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(operatorv1alpha1.Install(scheme))
+	return scheme
+}
+
+// Options configure the Harness
+type Options struct {
+	// RootDir is the root directory of the repository, relative to the directory of the suite, e.g., "..". The
+	// CustomResourceDefinitions are read from its config/crd/bases directory and the webhook configurations from its
+	// config/webhook one.
+	RootDir string
+	// Scheme is the scheme of the clients and of the manager. It defaults to NewScheme.
+	Scheme *runtime.Scheme
+	// CRDs are the CustomResourceDefinitions installed in addition to the ones of the operator, e.g.,
+	// ImageContentSourcePolicyCRD
+	CRDs []*apiextensionsv1.CustomResourceDefinition
+	// MutatingNamespaceSelector is optional. When set, the mutating webhooks only mutate the objects of the namespaces
+	// matching it, e.g., the ones created by the specs exercising them, as the PodPlacementConfigReconciler restricts
+	// them in the cluster. The objects of the other specs of the suite are not mutated.
+	MutatingNamespaceSelector *metav1.LabelSelector
+}
+
+// Harness is a test environment and the manager of the controllers and of the webhooks under test
+type Harness struct {
+	// Config is the configuration of the clients of the API server
+	Config *rest.Config
+	// Scheme is the scheme of the clients and of the manager
+	Scheme *runtime.Scheme
+	// Client reads from and writes to the API server directly, without the cache of the manager
+	Client client.Client
+	// Clientset is the typed clientset of the API server
+	Clientset *kubernetes.Clientset
+	// Manager runs the controllers and serves the webhooks once started by StartManager. The controllers and the
+	// webhooks under test are registered before.
+	Manager ctrl.Manager
+	env     *envtest.Environment
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+// Start starts the API server, installs the CustomResourceDefinitions and the webhook configurations, and creates the
+// manager, without starting it. The webhook configurations call the webhook server of the manager.
+func Start(opts Options) (*Harness, error) {
+	if opts.Scheme == nil {
+		opts.Scheme = NewScheme()
+	}
+	env := &envtest.Environment{
+		Scheme:                opts.Scheme,
+		CRDs:                  opts.CRDs,
+		CRDDirectoryPaths:     []string{filepath.Join(opts.RootDir, "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join(opts.RootDir, "config", "webhook")},
+		},
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the test environment: %w", err)
+	}
+	h := &Harness{Config: cfg, Scheme: opts.Scheme, env: env}
+	if err := h.init(opts.MutatingNamespaceSelector); err != nil {
+		if stopErr := env.Stop(); stopErr != nil {
+			return nil, fmt.Errorf("%w, and unable to stop the test environment: %v", err, stopErr)
+		}
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Harness) init(mutatingNamespaceSelector *metav1.LabelSelector) error {
+	var err error
+	if h.Client, err = client.New(h.Config, client.Options{Scheme: h.Scheme}); err != nil {
+		return fmt.Errorf("unable to create the client: %w", err)
+	}
+	if h.Clientset, err = kubernetes.NewForConfig(h.Config); err != nil {
+		return fmt.Errorf("unable to create the clientset: %w", err)
+	}
+	if mutatingNamespaceSelector != nil {
+		if err := h.restrictMutatingWebhooks(mutatingNamespaceSelector); err != nil {
+			return err
+		}
+	}
+	webhookOptions := h.env.WebhookInstallOptions
+	h.Manager, err = ctrl.NewManager(h.Config, ctrl.Options{
+		Scheme:             h.Scheme,
+		MetricsBindAddress: "0",
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    webhookOptions.LocalServingHost,
+			Port:    webhookOptions.LocalServingPort,
+			CertDir: webhookOptions.LocalServingCertDir,
+		}),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the manager: %w", err)
+	}
+	return nil
+}
+
+// restrictMutatingWebhooks sets the namespaceSelector of the installed mutating webhooks
+func (h *Harness) restrictMutatingWebhooks(namespaceSelector *metav1.LabelSelector) error {
+	ctx := context.Background()
+	for _, installed := range h.env.WebhookInstallOptions.MutatingWebhooks {
+		configuration := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		if err := h.Client.Get(ctx, client.ObjectKeyFromObject(installed), configuration); err != nil {
+			return fmt.Errorf("unable to get the MutatingWebhookConfiguration %s: %w", installed.Name, err)
+		}
+		for i := range configuration.Webhooks {
+			configuration.Webhooks[i].NamespaceSelector = namespaceSelector.DeepCopy()
+		}
+		if err := h.Client.Update(ctx, configuration); err != nil {
+			return fmt.Errorf("unable to update the MutatingWebhookConfiguration %s: %w", installed.Name, err)
+		}
+	}
+	return nil
+}
+
+// StartManager starts the manager and waits for its webhook server to serve, so that the objects created afterwards
+// are admitted by the webhooks under test
+func (h *Harness) StartManager() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.done = make(chan error, 1)
+	go func() {
+		h.done <- h.Manager.Start(ctx)
+	}()
+	started := h.Manager.GetWebhookServer().StartedChecker()
+	err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, webhookServerTimeout, true,
+		func(context.Context) (bool, error) {
+			select {
+			case err := <-h.done:
+				return false, fmt.Errorf("the manager stopped: %w", err)
+			default:
+			}
+			return started(nil) == nil, nil
+		})
+	if err != nil {
+		return fmt.Errorf("the webhook server is not serving: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the manager, if started, and the test environment
+func (h *Harness) Stop() error {
+	if h.cancel != nil {
+		h.cancel()
+		if err := <-h.done; err != nil {
+			if stopErr := h.env.Stop(); stopErr != nil {
+				return fmt.Errorf("the manager failed: %w, and unable to stop the test environment: %v", err, stopErr)
+			}
+			return fmt.Errorf("the manager failed: %w", err)
+		}
+	}
+	return h.env.Stop()
+}
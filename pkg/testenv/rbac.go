@@ -0,0 +1,233 @@
+package testenv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// APIRequest is a request of a client to the API server, as authorized by the RBAC rules
+type APIRequest struct {
+	Group string
+	// Resource is the resource of the request, followed by its subresource, if any, e.g., pods/status
+	Resource string
+	Verb     string
+}
+
+func (r APIRequest) String() string {
+	group := r.Group
+	if group == "" {
+		group = "core"
+	}
+	return fmt.Sprintf("%s %s.%s", r.Verb, r.Resource, group)
+}
+
+// APIRequests records the API requests of the clients returned by RecordingClient
+type APIRequests struct {
+	mutex    sync.Mutex
+	requests map[APIRequest]struct{}
+}
+
+// List returns the distinct requests recorded, sorted
+func (r *APIRequests) List() []APIRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	requests := make([]APIRequest, 0, len(r.requests))
+	for request := range r.requests {
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].String() < requests[j].String() })
+	return requests
+}
+
+func (r *APIRequests) record(c client.Client, obj runtime.Object, subresource, verb string) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return
+	}
+	if _, ok := obj.(client.ObjectList); ok {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	resource, _ := meta.UnsafeGuessKindToResource(gvk)
+	r.add(gvk.Group, resource.Resource, subresource, verb)
+}
+
+func (r *APIRequests) add(group, resource, subresource, verb string) {
+	if subresource != "" {
+		resource += "/" + subresource
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.requests == nil {
+		r.requests = map[APIRequest]struct{}{}
+	}
+	r.requests[APIRequest{Group: group, Resource: resource, Verb: verb}] = struct{}{}
+}
+
+// RecordingClient returns a client forwarding the requests to the client and recording them in the requests, e.g.,
+// to verify that the RBAC rules of the operator allow them, see Allows
+func RecordingClient(c client.WithWatch, requests *APIRequests) client.WithWatch {
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+			opts ...client.GetOption) error {
+			requests.record(c, obj, "", "get")
+			return c.Get(ctx, key, obj, opts...)
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			requests.record(c, list, "", "list")
+			return c.List(ctx, list, opts...)
+		},
+		Watch: func(ctx context.Context, c client.WithWatch, list client.ObjectList,
+			opts ...client.ListOption) (watch.Interface, error) {
+			requests.record(c, list, "", "watch")
+			return c.Watch(ctx, list, opts...)
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			requests.record(c, obj, "", "create")
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			requests.record(c, obj, "", "update")
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+			opts ...client.PatchOption) error {
+			requests.record(c, obj, "", "patch")
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			requests.record(c, obj, "", "delete")
+			return c.Delete(ctx, obj, opts...)
+		},
+		DeleteAllOf: func(ctx context.Context, c client.WithWatch, obj client.Object,
+			opts ...client.DeleteAllOfOption) error {
+			requests.record(c, obj, "", "deletecollection")
+			return c.DeleteAllOf(ctx, obj, opts...)
+		},
+		SubResourceGet: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+			subResource client.Object, opts ...client.SubResourceGetOption) error {
+			requests.record(c, obj, subResourceName, "get")
+			return c.SubResource(subResourceName).Get(ctx, obj, subResource, opts...)
+		},
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+			subResource client.Object, opts ...client.SubResourceCreateOption) error {
+			requests.record(c, obj, subResourceName, "create")
+			return c.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+			opts ...client.SubResourceUpdateOption) error {
+			requests.record(c, obj, subResourceName, "update")
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+			patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			requests.record(c, obj, subResourceName, "patch")
+			return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	})
+}
+
+// rbacMarker matches the kubebuilder RBAC markers, capturing their arguments
+var rbacMarker = regexp.MustCompile(`^\s*//\s*\+kubebuilder:rbac:(.+)$`)
+
+// RBACRules returns the rules of the kubebuilder RBAC markers of the Go files at the paths, either files or directories
+// walked recursively, as controller-gen generates them into a role, e.g., the paths of the manifests target of the
+// Makefile relative to the suite. The tests, the vendor and the bin directories are skipped.
+func RBACRules(paths ...string) ([]rbacv1.PolicyRule, error) {
+	var rules []rbacv1.PolicyRule
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if name := entry.Name(); path != root && (name == "vendor" || name == "bin" ||
+					strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				return nil
+			}
+			fileRules, err := fileRBACRules(path)
+			rules = append(rules, fileRules...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func fileRBACRules(path string) ([]rbacv1.PolicyRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []rbacv1.PolicyRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := rbacMarker.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		var rule rbacv1.PolicyRule
+		for _, argument := range strings.Split(match[1], ",") {
+			key, value, _ := strings.Cut(argument, "=")
+			values := strings.Split(strings.Trim(value, `"`), ";")
+			switch key {
+			case "groups":
+				for _, group := range values {
+					if group == "core" {
+						group = ""
+					}
+					rule.APIGroups = append(rule.APIGroups, group)
+				}
+			case "resources":
+				rule.Resources = values
+			case "verbs":
+				rule.Verbs = values
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Allows returns true if one of the rules allows the request
+func Allows(rules []rbacv1.PolicyRule, request APIRequest) bool {
+	for _, rule := range rules {
+		if matches(rule.APIGroups, request.Group) && matches(rule.Resources, request.Resource) &&
+			matches(rule.Verbs, request.Verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == rbacv1.ResourceAll {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package version
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// metricsLabel is the label of the exported metrics reporting the Version of the operator that exported them
+const metricsLabel = "version"
+
+// MetricsRegisterer returns the registerer of the metrics of the operator: the metrics registered with it are
+// exported by the metrics endpoint of the manager, labeled with the Version.
+func MetricsRegisterer() prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{metricsLabel: Version}, metrics.Registry)
+}
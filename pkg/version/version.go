@@ -0,0 +1,46 @@
+// Package version exposes the version of the operator.
+package version
+
+import "runtime/debug"
+
+// unknown is the Commit and the Date of the binaries built without them
+const unknown = "unknown"
+
+// Version is the version of the operator. It is set at build time with
+// -ldflags "-X multiarch-operator/pkg/version.Version=<version>". When it is not, the version of the main module in
+// the build information is used, e.g., for the binaries installed with go install.
+var Version = "dev"
+
+// Commit is the git commit the operator was built from. It is set at build time with
+// -ldflags "-X multiarch-operator/pkg/version.Commit=<commit>". When it is not, the revision recorded by the go
+// toolchain in the build information is used, if any.
+var Commit = unknown
+
+// Date is the date the operator was built, or the date of its Commit, in RFC 3339 format. It is set at build time with
+// -ldflags "-X multiarch-operator/pkg/version.Date=<date>". When it is not, the time of the revision recorded by the go
+// toolchain in the build information is used, if any.
+var Date = unknown
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && (Commit == unknown || Commit == ""):
+			Commit = setting.Value
+		case setting.Key == "vcs.time" && (Date == unknown || Date == ""):
+			Date = setting.Value
+		}
+	}
+	if Commit == "" {
+		Commit = unknown
+	}
+	if Date == "" {
+		Date = unknown
+	}
+}